@@ -1,16 +1,229 @@
 package api
 
 import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"time"
+
 	"github.com/mcgtrt/go-puerto/api/handlers"
+	"github.com/mcgtrt/go-puerto/internal/accounts"
+	"github.com/mcgtrt/go-puerto/internal/apikeys"
+	"github.com/mcgtrt/go-puerto/internal/auth"
+	"github.com/mcgtrt/go-puerto/internal/authz"
+	"github.com/mcgtrt/go-puerto/internal/httpclient"
+	"github.com/mcgtrt/go-puerto/internal/images"
+	"github.com/mcgtrt/go-puerto/internal/jobs"
+	"github.com/mcgtrt/go-puerto/internal/jwt"
+	"github.com/mcgtrt/go-puerto/internal/magiclink"
+	"github.com/mcgtrt/go-puerto/internal/mail"
+	"github.com/mcgtrt/go-puerto/internal/mfa"
+	"github.com/mcgtrt/go-puerto/internal/oidc"
+	"github.com/mcgtrt/go-puerto/internal/scheduler"
+	"github.com/mcgtrt/go-puerto/internal/session"
+	"github.com/mcgtrt/go-puerto/internal/sse"
+	"github.com/mcgtrt/go-puerto/internal/uploads"
+	"github.com/mcgtrt/go-puerto/internal/ws"
 	"github.com/mcgtrt/go-puerto/storage"
+	"github.com/mcgtrt/go-puerto/templates/pages"
+	"github.com/mcgtrt/go-puerto/utils"
 )
 
 type Handler struct {
-	View *handlers.ViewHandler
+	View     *handlers.ViewHandler
+	Health   *handlers.HealthHandler
+	Accounts *handlers.AccountHandler
+	Tokens   *handlers.TokenHandler
+	OIDC     *handlers.OIDCHandler
+	MFA      *handlers.MFAHandler
+	// Passwordless login with links sent by email
+	MagicLink *handlers.MagicLinkHandler
+	// Background delivery of emails, close it on shutdown
+	Mail *mail.Queue
+	// API keys of users for machine clients
+	APIKeys *handlers.APIKeyHandler
+	// Background jobs. Register job handlers before the app starts it.
+	Jobs *jobs.Queue
+	// Admin page of the jobs
+	JobAdmin *handlers.JobHandler
+	// Recurring tasks. Add tasks before the app starts it.
+	Scheduler *scheduler.Scheduler
+	// Live updates streamed to browsers, publish to topics from anywhere
+	Events *sse.Broker
+	// WebSocket rooms, allow rooms with Hub.Allow and broadcast from anywhere
+	Hub       *ws.Hub
+	WebSocket *handlers.WebSocketHandler
+	// Uploads of logged in users and signed download links of the files
+	Uploads *handlers.UploadHandler
+	// Resized uploaded images, shown with @layout.Picture
+	Images   *handlers.ImageHandler
+	Sessions *session.Manager
+	// Authentication strategies tried in order by AuthMiddleware
+	Auth []auth.Strategy
+	// Register policies with Authorizer.Register after creating the handler
+	Authorizer *authz.Authorizer
 }
 
-func NewHandler(store *storage.Store) *Handler {
-	return &Handler{
-		View: handlers.NewViewHandler(store),
+func NewHandler(store *storage.Store, config *utils.Config) (*Handler, error) {
+	h := &Handler{
+		View:   handlers.NewViewHandler(store),
+		Health: handlers.NewHealthHandler(store),
+	}
+	if config.Session != nil {
+		h.Sessions = session.NewManager(store.Sessions, config.Session, !config.HTTP.Development)
+	}
+	var notifier accounts.Notifier = accounts.LogNotifier{}
+	if config.Mail != nil {
+		cfg := config.Mail
+		h.Mail = mail.NewQueue(mail.New(cfg), cfg.QueueSize, cfg.Workers, cfg.MaxAttempts)
+		notifier = mail.AccountNotifier{Mailer: h.Mail}
+	}
+	var service *accounts.Service
+	if config.Accounts != nil {
+		service = accounts.NewService(store.Users)
+		h.Accounts = handlers.NewAccountHandler(service, h.Sessions, notifier, config.HTTP.BaseURL)
+		h.Auth = append(h.Auth, auth.SessionStrategy{Users: store.Users})
+	}
+	if config.OIDC != nil {
+		client := httpclient.New(config.Middleware.RequestIDHeader)
+		providers := make([]*oidc.Provider, 0, len(config.OIDC.Providers))
+		for _, cfg := range config.OIDC.Providers {
+			providers = append(providers, oidc.NewProvider(cfg, config.HTTP.BaseURL, client))
+			h.Accounts.Providers = append(h.Accounts.Providers, pages.LoginProvider{Name: cfg.Name, Label: cfg.Label})
+		}
+		h.OIDC = handlers.NewOIDCHandler(providers, oidc.NewLinker(service, store.Identities))
+	}
+	if config.JWT != nil {
+		keys, err := jwt.LoadKeyring(config.JWT.KeyFiles)
+		if err != nil {
+			return nil, err
+		}
+		tokens := jwt.NewService(keys, config.JWT, store.JWT, store.JWT)
+		tokens.Users = store.Users
+		h.Tokens = handlers.NewTokenHandler(tokens, service)
+		h.Auth = append(h.Auth, auth.BearerStrategy{Verify: tokens.Authenticate})
+		if h.Accounts != nil {
+			h.Accounts.RefreshTokens = store.JWT
+		}
+	}
+	if config.MFA != nil {
+		m := mfa.NewService(store.MFA, config.MFA)
+		h.MFA = handlers.NewMFAHandler(m, service, !config.HTTP.Development)
+		h.Accounts.MFA = m
+		if h.OIDC != nil {
+			h.OIDC.MFA = m
+		}
+		if h.Tokens != nil {
+			h.Tokens.MFA = m
+		}
+	}
+	if config.MagicLink != nil {
+		links := magiclink.NewService(store.MagicLinks, service, config.MagicLink)
+		h.MagicLink = handlers.NewMagicLinkHandler(links, h.Accounts.Notifier, config.HTTP.BaseURL, !config.HTTP.Development)
+		h.MagicLink.MFA = h.Accounts.MFA
+		h.Accounts.MagicLink = true
+	}
+	if config.APIKeys != nil {
+		keys := apikeys.NewService(store.APIKeys, store.Users, config.APIKeys)
+		h.APIKeys = handlers.NewAPIKeyHandler(keys)
+		h.Auth = append(h.Auth, auth.APIKeyStrategy{Verify: keys.Verify})
+	}
+	if config.Jobs != nil {
+		h.Jobs = jobs.NewQueue(store.Jobs, config.Jobs)
+		h.JobAdmin = handlers.NewJobHandler(h.Jobs)
+	}
+	if config.Scheduler != nil {
+		h.Scheduler = scheduler.New(store.Leader, config.Scheduler)
+		h.Health.Scheduler = h.Scheduler
+		if err := addCleanupTasks(h.Scheduler, store); err != nil {
+			return nil, err
+		}
+	}
+	if config.SSE != nil {
+		h.Events = sse.NewBroker(store.Events, config.SSE)
+		h.Events.Start()
+	}
+	if config.WebSocket != nil {
+		h.Hub = ws.NewHub(store.WebSocket, config.WebSocket)
+		h.Hub.Start()
+		h.WebSocket = handlers.NewWebSocketHandler(h.Hub)
+	}
+	if config.Uploads != nil {
+		service := uploads.NewService(store.Blobs, config.Uploads, []byte(os.Getenv(utils.AES_SECRET)))
+		h.Uploads = handlers.NewUploadHandler(service)
+	}
+	if config.Images != nil {
+		service := images.NewService(h.Uploads.Uploads, config.Images)
+		images.SetDefault(service)
+		h.Images = handlers.NewImageHandler(service)
+	}
+	if config.Authz != nil {
+		h.Authorizer = authz.NewAuthorizer(store.Policies)
+	}
+	return h, nil
+}
+
+// Schedule of the cleanup of expired rows, every hour
+const CLEANUP_SCHEDULE = "0 * * * *"
+
+// Stores removing expired rows only when asked, e.g. the Postgres tables.
+// Stores with TTL indexes or expiring keys don't implement it.
+type expiredDeleter interface {
+	DeleteExpired(ctx context.Context) (int64, error)
+}
+
+// Add the cleanup tasks of the stores which need one
+func addCleanupTasks(s *scheduler.Scheduler, store *storage.Store) error {
+	tasks := []struct {
+		name  string
+		store any
+	}{
+		{"sessions:cleanup", store.Sessions},
+		{"jwt:cleanup", store.JWT},
+	}
+	for _, t := range tasks {
+		deleter, ok := t.store.(expiredDeleter)
+		if !ok {
+			continue
+		}
+		name := t.name
+		task := func(ctx context.Context) error {
+			deleted, err := deleter.DeleteExpired(ctx)
+			if deleted > 0 {
+				slog.Info("expired rows deleted", "task", name, "count", deleted)
+			}
+			return err
+		}
+		if err := s.Add(name, CLEANUP_SCHEDULE, task, scheduler.Jitter(time.Minute)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Finish the background work: scheduled tasks, running jobs and password
+// resets first, as they may still enqueue jobs and send emails, then the
+// queued emails
+func (h *Handler) Shutdown(ctx context.Context) error {
+	var errs []error
+	if h.Scheduler != nil {
+		errs = append(errs, h.Scheduler.Shutdown(ctx))
+	}
+	if h.Jobs != nil {
+		errs = append(errs, h.Jobs.Shutdown(ctx))
+	}
+	if h.Accounts != nil {
+		errs = append(errs, h.Accounts.Shutdown(ctx))
+	}
+	if h.Mail != nil {
+		errs = append(errs, h.Mail.Close(ctx))
+	}
+	if h.Events != nil {
+		h.Events.Close()
+	}
+	if h.Hub != nil {
+		h.Hub.Close()
 	}
+	return errors.Join(errs...)
 }
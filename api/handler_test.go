@@ -0,0 +1,35 @@
+package api
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mcgtrt/go-puerto/internal/jwt"
+	"github.com/mcgtrt/go-puerto/internal/scheduler"
+	"github.com/mcgtrt/go-puerto/internal/session"
+	"github.com/mcgtrt/go-puerto/storage"
+	"github.com/mcgtrt/go-puerto/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+// Session store removing expired sessions only when asked
+type expiringSessions struct {
+	session.SessionStore
+}
+
+func (expiringSessions) DeleteExpired(ctx context.Context) (int64, error) {
+	return 0, nil
+}
+
+func TestAddCleanupTasks(t *testing.T) {
+	s := scheduler.New(scheduler.LocalElector{}, &utils.SchedulerConfig{LockTTL: time.Second, Timezone: time.UTC})
+	store := &storage.Store{Sessions: expiringSessions{}, JWT: jwt.NewMemoryStore()}
+	assert.NoError(t, addCleanupTasks(s, store))
+
+	tasks := s.Status().Tasks
+	if assert.Len(t, tasks, 1, "Expected stores expiring rows on their own skipped") {
+		assert.Equal(t, "sessions:cleanup", tasks[0].Name)
+		assert.Equal(t, CLEANUP_SCHEDULE, tasks[0].Schedule)
+	}
+}
@@ -0,0 +1,228 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/mcgtrt/go-puerto/internal/accounts"
+	"github.com/mcgtrt/go-puerto/internal/jwt"
+	"github.com/mcgtrt/go-puerto/internal/logging"
+	"github.com/mcgtrt/go-puerto/internal/mfa"
+	"github.com/mcgtrt/go-puerto/internal/session"
+	"github.com/mcgtrt/go-puerto/templates/pages"
+	"github.com/mcgtrt/go-puerto/utils"
+)
+
+// Registration, login, email verification and password reset pages
+type AccountHandler struct {
+	Accounts *accounts.Service
+	Sessions *session.Manager
+	Notifier accounts.Notifier
+	// Refresh tokens revoked after password reset, nil without JWT
+	RefreshTokens jwt.RefreshStore
+	// Public address of the application used in emailed links
+	BaseURL string
+	// Identity providers offered on the login and register pages
+	Providers []pages.LoginProvider
+	// Offer logging in with a link sent by email
+	MagicLink bool
+	// Second factor asked after the password, nil without MFA
+	MFA *mfa.Service
+
+	// Password resets processed in the background
+	resets sync.WaitGroup
+}
+
+func NewAccountHandler(service *accounts.Service, sessions *session.Manager, notifier accounts.Notifier, baseURL string) *AccountHandler {
+	return &AccountHandler{
+		Accounts: service,
+		Sessions: sessions,
+		Notifier: notifier,
+		BaseURL:  baseURL,
+	}
+}
+
+func (h *AccountHandler) HandleRegisterPage(c *Ctx) error {
+	lang, _ := utils.GetLocale(c.Context)
+	return c.Render(pages.RegisterPage(lang, pages.AccountForm{Providers: h.Providers}))
+}
+
+// Existing emails get the same response as new ones, only the owner
+// of the address is notified about the registration attempt
+func (h *AccountHandler) HandleRegister(c *Ctx) error {
+	lang, _ := utils.GetLocale(c.Context)
+	form := pages.AccountForm{
+		Email:     c.Request.PostFormValue("email"),
+		Name:      c.Request.PostFormValue("name"),
+		Providers: h.Providers,
+	}
+	user, token, err := h.Accounts.Register(c.Context, form.Email, form.Name, c.Request.PostFormValue("password"))
+	switch {
+	case errors.Is(err, accounts.ErrInvalidEmail):
+		form.Errors = map[string]string{"email": "Enter a valid email address."}
+	case errors.Is(err, accounts.ErrInvalidName):
+		form.Errors = map[string]string{"name": "Name must have 2-64 characters."}
+	case errors.Is(err, accounts.ErrInvalidPassword):
+		form.Errors = map[string]string{"password": "Password must have 8-32 characters including a number, an upper case letter and a special character."}
+	case errors.Is(err, accounts.ErrEmailTaken):
+		h.notify(c.Context, accounts.Notification{Kind: accounts.NOTIFY_ACCOUNT_EXISTS, User: user, Link: h.link("/forgot-password", "")})
+	case err != nil:
+		return err
+	default:
+		h.notify(c.Context, accounts.Notification{Kind: accounts.NOTIFY_VERIFY_EMAIL, User: user, Link: h.link("/verify-email", token)})
+	}
+	if form.Errors != nil {
+		c.Response.WriteHeader(http.StatusUnprocessableEntity)
+		return c.Render(pages.RegisterPage(lang, form))
+	}
+	return c.Render(pages.AccountMessagePage(lang, "Check your inbox", "We sent you an email to confirm your address."))
+}
+
+func (h *AccountHandler) HandleLoginPage(c *Ctx) error {
+	lang, _ := utils.GetLocale(c.Context)
+	return c.Render(pages.LoginPage(lang, pages.AccountForm{Providers: h.Providers, MagicLink: h.MagicLink}))
+}
+
+func (h *AccountHandler) HandleLogin(c *Ctx) error {
+	lang, _ := utils.GetLocale(c.Context)
+	form := pages.AccountForm{Email: c.Request.PostFormValue("email"), Providers: h.Providers, MagicLink: h.MagicLink}
+	user, err := h.Accounts.Login(c.Context, form.Email, c.Request.PostFormValue("password"))
+	if errors.Is(err, accounts.ErrInvalidCredentials) {
+		form.Errors = map[string]string{"form": "Invalid email or password."}
+		c.Response.WriteHeader(http.StatusUnauthorized)
+		return c.Render(pages.LoginPage(lang, form))
+	}
+	if err != nil {
+		return err
+	}
+	return signIn(c, h.MFA, user.ID, safeRedirect(c.Request.URL.Query().Get("next")))
+}
+
+func (h *AccountHandler) HandleLogout(c *Ctx) error {
+	c.Session().Destroy()
+	return c.Redirect("/")
+}
+
+func (h *AccountHandler) HandleVerifyEmail(c *Ctx) error {
+	lang, _ := utils.GetLocale(c.Context)
+	_, err := h.Accounts.VerifyEmail(c.Context, c.Request.URL.Query().Get("token"))
+	if errors.Is(err, accounts.ErrTokenNotFound) || errors.Is(err, accounts.ErrUserNotFound) {
+		c.Response.WriteHeader(http.StatusBadRequest)
+		return c.Render(pages.AccountMessagePage(lang, "Link expired", "This link is invalid or has expired."))
+	}
+	if err != nil {
+		return err
+	}
+	return c.Render(pages.AccountMessagePage(lang, "Email confirmed", "Your email address has been confirmed."))
+}
+
+func (h *AccountHandler) HandleForgotPasswordPage(c *Ctx) error {
+	lang, _ := utils.GetLocale(c.Context)
+	return c.Render(pages.ForgotPasswordPage(lang, pages.AccountForm{}))
+}
+
+// The reset is processed in the background, so the response doesn't
+// reveal whether the email is registered, not even by its timing
+func (h *AccountHandler) HandleForgotPassword(c *Ctx) error {
+	lang, _ := utils.GetLocale(c.Context)
+	email := c.Request.PostFormValue("email")
+	if !utils.IsEmailCorrect(accounts.NormaliseEmail(email)) {
+		c.Response.WriteHeader(http.StatusUnprocessableEntity)
+		return c.Render(pages.ForgotPasswordPage(lang, pages.AccountForm{
+			Email:  email,
+			Errors: map[string]string{"email": "Enter a valid email address."},
+		}))
+	}
+
+	ctx := context.WithoutCancel(c.Context)
+	h.resets.Add(1)
+	go func() {
+		defer h.resets.Done()
+		user, token, err := h.Accounts.RequestPasswordReset(ctx, email)
+		if errors.Is(err, accounts.ErrUserNotFound) {
+			return
+		}
+		if err != nil {
+			logging.FromContext(ctx).Error("password reset failed", "error", err)
+			return
+		}
+		h.notify(ctx, accounts.Notification{Kind: accounts.NOTIFY_PASSWORD_RESET, User: user, Link: h.link("/reset-password", token)})
+	}()
+	return c.Render(pages.AccountMessagePage(lang, "Check your inbox", "If an account exists for this address, we sent a link to reset the password."))
+}
+
+// Wait for the password resets processed in the background, so their
+// emails are queued before the mail queue is closed
+func (h *AccountHandler) Shutdown(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		h.resets.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (h *AccountHandler) HandleResetPasswordPage(c *Ctx) error {
+	lang, _ := utils.GetLocale(c.Context)
+	return c.Render(pages.ResetPasswordPage(lang, pages.AccountForm{Token: c.Request.URL.Query().Get("token")}))
+}
+
+// Changing the password logs the user out everywhere
+func (h *AccountHandler) HandleResetPassword(c *Ctx) error {
+	lang, _ := utils.GetLocale(c.Context)
+	form := pages.AccountForm{Token: c.Request.PostFormValue("token")}
+	user, err := h.Accounts.ResetPassword(c.Context, form.Token, c.Request.PostFormValue("password"))
+	switch {
+	case errors.Is(err, accounts.ErrInvalidPassword):
+		form.Errors = map[string]string{"password": "Password must have 8-32 characters including a number, an upper case letter and a special character."}
+		c.Response.WriteHeader(http.StatusUnprocessableEntity)
+		return c.Render(pages.ResetPasswordPage(lang, form))
+	case errors.Is(err, accounts.ErrTokenNotFound) || errors.Is(err, accounts.ErrUserNotFound):
+		c.Response.WriteHeader(http.StatusBadRequest)
+		return c.Render(pages.AccountMessagePage(lang, "Link expired", "This link is invalid or has expired."))
+	case err != nil:
+		return err
+	}
+
+	if err := h.Sessions.RevokeUser(c.Context, user.ID); err != nil {
+		c.Logger().Warn("revoking sessions after password reset failed", "error", err)
+	}
+	if h.RefreshTokens != nil {
+		if err := h.RefreshTokens.RevokeUser(c.Context, user.ID); err != nil {
+			c.Logger().Warn("revoking refresh tokens after password reset failed", "error", err)
+		}
+	}
+	c.Session().Destroy()
+	return c.Render(pages.AccountMessagePage(lang, "Password changed", "Your password has been changed. You can now log in."))
+}
+
+func (h *AccountHandler) notify(ctx context.Context, n accounts.Notification) {
+	n.Lang, _ = utils.GetLocale(ctx)
+	if err := h.Notifier.Notify(ctx, n); err != nil {
+		logging.FromContext(ctx).Error("sending account notification failed", "kind", n.Kind, "error", err)
+	}
+}
+
+func (h *AccountHandler) link(path, token string) string {
+	if token == "" {
+		return h.BaseURL + path
+	}
+	return h.BaseURL + path + "?token=" + url.QueryEscape(token)
+}
+
+// Only local paths are followed after login to avoid open redirects
+func safeRedirect(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
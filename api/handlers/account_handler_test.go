@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mcgtrt/go-puerto/internal/accounts"
+	"github.com/mcgtrt/go-puerto/internal/session"
+	"github.com/mcgtrt/go-puerto/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingNotifier struct {
+	mu   sync.Mutex
+	sent []accounts.Notification
+}
+
+func (n *recordingNotifier) Notify(ctx context.Context, notification accounts.Notification) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.sent = append(n.sent, notification)
+	return nil
+}
+
+func (n *recordingNotifier) last() (accounts.Notification, int) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if len(n.sent) == 0 {
+		return accounts.Notification{}, 0
+	}
+	return n.sent[len(n.sent)-1], len(n.sent)
+}
+
+func TestAccountHandler(t *testing.T) {
+	service := accounts.NewService(accounts.NewMemoryStore())
+	service.HashParams = accounts.HashParams{Memory: 1024, Time: 1, Threads: 1, SaltLen: 16, KeyLen: 32}
+	sessions := session.NewManager(session.NewMemoryStore(), &utils.SessionConfig{
+		CookieName:      "session",
+		IdleTimeout:     time.Hour,
+		AbsoluteTimeout: time.Hour,
+	}, false)
+	notifier := &recordingNotifier{}
+	h := NewAccountHandler(service, sessions, notifier, "https://example.com")
+
+	post := func(fn func(*Ctx) error, target string, form url.Values) (*httptest.ResponseRecorder, *session.Session) {
+		req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		s, err := sessions.Load(req)
+		assert.NoError(t, err)
+		req = req.WithContext(session.WithSession(req.Context(), s))
+		rec := httptest.NewRecorder()
+		assert.NoError(t, fn(NewCtx(rec, req)))
+		return rec, s
+	}
+	register := url.Values{"email": {"john@example.com"}, "name": {"John"}, "password": {"Secret1!"}}
+
+	t.Run("Register sends verification link", func(t *testing.T) {
+		rec, _ := post(h.HandleRegister, "/register", register)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), "Check your inbox")
+
+		n, _ := notifier.last()
+		assert.Equal(t, accounts.NOTIFY_VERIFY_EMAIL, n.Kind)
+		assert.True(t, strings.HasPrefix(n.Link, "https://example.com/verify-email?token="), "Expected link with configured base URL")
+	})
+
+	t.Run("Registering existing email looks the same", func(t *testing.T) {
+		rec, _ := post(h.HandleRegister, "/register", register)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), "Check your inbox")
+
+		n, _ := notifier.last()
+		assert.Equal(t, accounts.NOTIFY_ACCOUNT_EXISTS, n.Kind, "Expected owner notified instead")
+	})
+
+	t.Run("Register shows validation errors", func(t *testing.T) {
+		rec, _ := post(h.HandleRegister, "/register", url.Values{"email": {"jane@example.com"}, "name": {"Jane"}, "password": {"weak"}})
+		assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+		assert.Contains(t, rec.Body.String(), "Password must have 8-32 characters")
+		assert.Contains(t, rec.Body.String(), "jane@example.com", "Expected entered values kept")
+	})
+
+	t.Run("Login binds user to session", func(t *testing.T) {
+		rec, s := post(h.HandleLogin, "/login?next=/orders", url.Values{"email": {"john@example.com"}, "password": {"Secret1!"}})
+		assert.Equal(t, http.StatusSeeOther, rec.Code)
+		assert.Equal(t, "/orders", rec.Header().Get("Location"))
+		assert.NotEmpty(t, s.UserID)
+	})
+
+	t.Run("Login rejects open redirects", func(t *testing.T) {
+		for _, next := range []string{"https://evil.com", "//evil.com", "/\\evil.com"} {
+			rec, _ := post(h.HandleLogin, "/login?next="+url.QueryEscape(next), url.Values{"email": {"john@example.com"}, "password": {"Secret1!"}})
+			assert.Equal(t, "/", rec.Header().Get("Location"), "Expected %q ignored", next)
+		}
+	})
+
+	t.Run("Login with wrong password", func(t *testing.T) {
+		rec, s := post(h.HandleLogin, "/login", url.Values{"email": {"john@example.com"}, "password": {"Wrong1!!"}})
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.Contains(t, rec.Body.String(), "Invalid email or password.")
+		assert.Empty(t, s.UserID)
+	})
+
+	t.Run("Forgot password doesn't reveal unknown emails", func(t *testing.T) {
+		_, count := notifier.last()
+		rec, _ := post(h.HandleForgotPassword, "/forgot-password", url.Values{"email": {"nobody@example.com"}})
+		known, _ := post(h.HandleForgotPassword, "/forgot-password", url.Values{"email": {"john@example.com"}})
+		assert.Equal(t, rec.Code, known.Code)
+		assert.Equal(t, rec.Body.String(), known.Body.String())
+
+		assert.NoError(t, h.Shutdown(context.Background()), "Expected background resets waited for")
+		n, sent := notifier.last()
+		assert.Equal(t, count+1, sent, "Expected only the known email notified")
+		assert.Equal(t, accounts.NOTIFY_PASSWORD_RESET, n.Kind)
+
+		token := strings.TrimPrefix(n.Link, "https://example.com/reset-password?token=")
+		rec, _ = post(h.HandleResetPassword, "/reset-password", url.Values{"token": {token}, "password": {"NewSecret1!"}})
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), "Password changed")
+
+		rec, _ = post(h.HandleResetPassword, "/reset-password", url.Values{"token": {token}, "password": {"NewSecret2!"}})
+		assert.Equal(t, http.StatusBadRequest, rec.Code, "Expected used token rejected")
+	})
+}
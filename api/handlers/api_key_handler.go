@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mcgtrt/go-puerto/internal/apikeys"
+	"github.com/mcgtrt/go-puerto/internal/auth"
+	"github.com/mcgtrt/go-puerto/templates/pages"
+	"github.com/mcgtrt/go-puerto/utils"
+)
+
+// API keys page of the logged in user. Keys are managed in the browser
+// session only, so a leaked key can't create more keys or hide itself.
+type APIKeyHandler struct {
+	Keys *apikeys.Service
+}
+
+func NewAPIKeyHandler(keys *apikeys.Service) *APIKeyHandler {
+	return &APIKeyHandler{Keys: keys}
+}
+
+func (h *APIKeyHandler) HandleListPage(c *Ctx) error {
+	if !isSession(c) {
+		return c.Problem(c.NewProblem(http.StatusForbidden, "API keys can only be managed after logging in."))
+	}
+	lang, _ := utils.GetLocale(c.Context)
+	form, err := h.form(c)
+	if err != nil {
+		return err
+	}
+	return c.Render(pages.APIKeysPage(lang, form))
+}
+
+func (h *APIKeyHandler) HandleCreate(c *Ctx) error {
+	if !isSession(c) {
+		return c.Problem(c.NewProblem(http.StatusForbidden, "API keys can only be managed after logging in."))
+	}
+	lang, _ := utils.GetLocale(c.Context)
+	name := c.Request.PostFormValue("name")
+	errs := map[string]string{}
+	days, err := strconv.Atoi(c.Request.PostFormValue("expires"))
+	if err != nil || !slices.Contains(pages.APIKeyExpiryDays, days) {
+		errs["expires"] = "Choose when the key expires."
+	}
+	var created *apikeys.Created
+	if len(errs) == 0 {
+		created, err = h.Keys.Create(c.Context, c.User().ID, name, c.Request.PostForm["scope"], apikeys.DEFAULT_POLICY, time.Duration(days)*24*time.Hour)
+		switch {
+		case errors.Is(err, apikeys.ErrInvalidName):
+			errs["name"] = "Name must have 1 to 64 characters."
+		case errors.Is(err, apikeys.ErrInvalidScope):
+			errs["scopes"] = "Choose at least one scope."
+		case errors.Is(err, apikeys.ErrTooManyKeys):
+			errs["name"] = "You have too many keys. Revoke a key you no longer use first."
+		case err != nil:
+			return err
+		}
+	}
+
+	form, err := h.form(c)
+	if err != nil {
+		return err
+	}
+	if len(errs) > 0 {
+		form.Name, form.Errors = name, errs
+		c.Response.WriteHeader(http.StatusUnprocessableEntity)
+		return c.Render(pages.APIKeysPage(lang, form))
+	}
+	c.Logger().Info("api key created", "key_id", created.Key.ID, "scopes", created.Key.Scopes)
+	form.Created = created.Plain
+	// The key is shown only in this response
+	c.Response.Header().Set("Cache-Control", "no-store")
+	return c.Render(pages.APIKeysPage(lang, form))
+}
+
+func (h *APIKeyHandler) HandleRevoke(c *Ctx) error {
+	if !isSession(c) {
+		return c.Problem(c.NewProblem(http.StatusForbidden, "API keys can only be managed after logging in."))
+	}
+	id := chi.URLParam(c.Request, "id")
+	err := h.Keys.Revoke(c.Context, c.User().ID, id)
+	if errors.Is(err, apikeys.ErrKeyNotFound) {
+		return c.Problem(c.NewProblem(http.StatusNotFound, "API key not found."))
+	}
+	if err != nil {
+		return err
+	}
+	c.Logger().Info("api key revoked", "key_id", id)
+	return c.Redirect("/account/api-keys")
+}
+
+func (h *APIKeyHandler) form(c *Ctx) (pages.APIKeysForm, error) {
+	keys, err := h.Keys.List(c.Context, c.User().ID)
+	if err != nil {
+		return pages.APIKeysForm{}, err
+	}
+	form := pages.APIKeysForm{}
+	for _, k := range keys {
+		form.Keys = append(form.Keys, pages.APIKeyItem{
+			ID:         k.ID,
+			DisplayID:  h.Keys.DisplayID(&k),
+			Name:       k.Name,
+			Scopes:     k.Scopes,
+			CreatedAt:  k.CreatedAt,
+			ExpiresAt:  k.ExpiresAt,
+			LastUsedAt: k.LastUsedAt,
+		})
+	}
+	for _, scope := range h.Keys.Scopes() {
+		form.Scopes = append(form.Scopes, pages.APIKeyScope{Name: scope.Name, Description: scope.Description})
+	}
+	return form, nil
+}
+
+// Whether the user is logged in the browser rather than calling with a key
+// or token
+func isSession(c *Ctx) bool {
+	p := c.User()
+	return p != nil && p.Method == auth.METHOD_SESSION
+}
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mcgtrt/go-puerto/internal/accounts"
+	"github.com/mcgtrt/go-puerto/internal/apikeys"
+	"github.com/mcgtrt/go-puerto/internal/auth"
+	"github.com/mcgtrt/go-puerto/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPIKeyHandler(t *testing.T) {
+	users := accounts.NewMemoryStore()
+	assert.NoError(t, users.CreateUser(context.Background(), &accounts.User{ID: "user", Email: "john@example.com", Name: "John"}))
+	keys := apikeys.NewService(apikeys.NewMemoryStore(), users, &utils.APIKeysConfig{Prefix: "api", MaxPerUser: 5, RateLimitPerMinute: 60})
+	h := NewAPIKeyHandler(keys)
+
+	do := func(fn func(*Ctx) error, target string, form url.Values, p *auth.Principal, params ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rctx := chi.NewRouteContext()
+		for i := 0; i+1 < len(params); i += 2 {
+			rctx.URLParams.Add(params[i], params[i+1])
+		}
+		ctx := context.WithValue(auth.WithPrincipal(req.Context(), p), chi.RouteCtxKey, rctx)
+		rec := httptest.NewRecorder()
+		assert.NoError(t, fn(NewCtx(rec, req.WithContext(ctx))))
+		return rec
+	}
+	user := &auth.Principal{ID: "user", Method: auth.METHOD_SESSION}
+
+	var plain string
+	t.Run("Create", func(t *testing.T) {
+		rec := do(h.HandleCreate, "/account/api-keys", url.Values{"name": {"CI"}, "expires": {"7"}, "scope": {"full"}}, user)
+		assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+		assert.Contains(t, rec.Body.String(), "Choose when the key expires")
+
+		rec = do(h.HandleCreate, "/account/api-keys", url.Values{"name": {"CI"}, "expires": {"90"}}, user)
+		assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+		assert.Contains(t, rec.Body.String(), "Choose at least one scope")
+
+		rec = do(h.HandleCreate, "/account/api-keys", url.Values{"name": {"CI"}, "expires": {"90"}, "scope": {"full"}}, user)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "no-store", rec.Header().Get("Cache-Control"))
+		plain = regexp.MustCompile(`api_[a-z2-7]{16}_[a-z2-7]{32}`).FindString(rec.Body.String())
+		assert.NotEmpty(t, plain, "Expected the new key shown")
+
+		p, err := keys.Verify(context.Background(), plain)
+		assert.NoError(t, err)
+		assert.Equal(t, "user", p.ID)
+	})
+
+	t.Run("List hides secrets", func(t *testing.T) {
+		rec := do(h.HandleListPage, "/account/api-keys", nil, user)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), plain[:len("api_")+apikeys.ID_LENGTH])
+		assert.NotContains(t, rec.Body.String(), plain)
+	})
+
+	t.Run("Keys can't manage keys", func(t *testing.T) {
+		rec := do(h.HandleCreate, "/account/api-keys", url.Values{"name": {"CI"}, "expires": {"0"}, "scope": {"full"}},
+			&auth.Principal{ID: "user", Method: auth.METHOD_API_KEY, Scopes: []string{"*"}})
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+	})
+
+	t.Run("Revoke", func(t *testing.T) {
+		id := plain[len("api_") : len("api_")+apikeys.ID_LENGTH]
+		rec := do(h.HandleRevoke, "/account/api-keys/"+id+"/revoke", nil, &auth.Principal{ID: "other", Method: auth.METHOD_SESSION}, "id", id)
+		assert.Equal(t, http.StatusNotFound, rec.Code, "Expected keys of other users not found")
+
+		rec = do(h.HandleRevoke, "/account/api-keys/"+id+"/revoke", nil, user, "id", id)
+		assert.Equal(t, http.StatusSeeOther, rec.Code)
+		_, err := keys.Verify(context.Background(), plain)
+		assert.ErrorIs(t, err, auth.ErrInvalidCredentials)
+	})
+}
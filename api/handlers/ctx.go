@@ -3,9 +3,20 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"log/slog"
+	"net"
 	"net/http"
 
 	"github.com/a-h/templ"
+	"github.com/mcgtrt/go-puerto/internal/auth"
+	"github.com/mcgtrt/go-puerto/internal/authz"
+	"github.com/mcgtrt/go-puerto/internal/listquery"
+	"github.com/mcgtrt/go-puerto/internal/logging"
+	"github.com/mcgtrt/go-puerto/internal/session"
+	"github.com/mcgtrt/go-puerto/internal/sse"
+	"github.com/mcgtrt/go-puerto/internal/tracing"
+	"github.com/mcgtrt/go-puerto/internal/uploads"
+	"github.com/mcgtrt/go-puerto/internal/ws"
 )
 
 type Ctx struct {
@@ -22,8 +33,15 @@ func NewCtx(w http.ResponseWriter, r *http.Request) *Ctx {
 	}
 }
 
+// Render templ component into the response. Rendering time is
+// recorded as a child span of the request span.
 func (c *Ctx) Render(component templ.Component) error {
-	return component.Render(c.Context, c.Response)
+	_, span := tracing.Tracer().Start(c.Context, "templ.Render")
+	defer span.End()
+
+	err := component.Render(c.Context, c.Response)
+	tracing.RecordError(span, err)
+	return err
 }
 
 func (c *Ctx) JSON(code int, v any) error {
@@ -32,6 +50,60 @@ func (c *Ctx) JSON(code int, v any) error {
 	return json.NewEncoder(c.Response).Encode(v)
 }
 
+// Start a server-sent event stream, e.g. for the HTMX SSE extension:
+//
+//	stream, err := c.SSE()
+//	if err != nil {
+//		return err
+//	}
+//	return stream.Listen(h.Events, "orders:"+c.User().ID)
+func (c *Ctx) SSE() (*sse.Stream, error) {
+	return sse.NewStream(c.Response, c.Request)
+}
+
+// Upgrade the request to a WebSocket connected to the hub. Failed
+// handshakes are already responded, return nil then:
+//
+//	client, err := c.WebSocket(h.Hub)
+//	if err != nil {
+//		return nil
+//	}
+//	client.Join("user:" + c.User().ID)
+//	return client.Run()
+func (c *Ctx) WebSocket(hub *ws.Hub) (*ws.Client, error) {
+	return hub.Accept(c.Response, c.Request)
+}
+
+// Read the multipart form of the request, streaming the files into the
+// store of the service. Size and type limits of the service apply:
+//
+//	form, err := c.Upload(h.Uploads)
+//	if err != nil {
+//		return uploadError(c, err)
+//	}
+//	avatar := form.File("avatar")
+func (c *Ctx) Upload(s *uploads.Service) (*uploads.Form, error) {
+	return s.Receive(c.Request)
+}
+
+// Parse the page, cursor, limit, sort and filters of a list request
+// allowed by the spec. Errors are safe to show to clients:
+//
+//	q, err := c.ListQuery(orderList)
+//	if err != nil {
+//		return c.Problem(c.NewProblem(http.StatusBadRequest, err.Error()))
+//	}
+func (c *Ctx) ListQuery(spec *listquery.Spec) (*listquery.Query, error) {
+	return spec.Parse(c.Request.URL.Query())
+}
+
+// Set Link header with the links of the pagination for JSON APIs
+func (c *Ctx) Paginate(p listquery.Pagination) {
+	if links := p.Header(); links != "" {
+		c.Response.Header().Set("Link", links)
+	}
+}
+
 func (c *Ctx) Text(code int, v string) error {
 	c.Response.Header().Set("Content-Type", "text/plain; charset=utf-8")
 	c.Response.WriteHeader(code)
@@ -39,10 +111,68 @@ func (c *Ctx) Text(code int, v string) error {
 	return err
 }
 
+// Redirect the client after a form submission. HTMX requests receive
+// HX-Redirect header instead, as HTMX would follow a plain redirect
+// in the background and swap the target page into the current one.
+func (c *Ctx) Redirect(url string) error {
+	if c.Request.Header.Get("HX-Request") == "true" {
+		c.Response.Header().Set("HX-Redirect", url)
+		c.Response.WriteHeader(http.StatusOK)
+		return nil
+	}
+	http.Redirect(c.Response, c.Request, url, http.StatusSeeOther)
+	return nil
+}
+
+// Write error response with the default status text. Clients accepting
+// JSON receive a problem+json body instead. Trace ID of the request (if
+// any) is always attached to help finding the request in the logs.
 func (c *Ctx) Error(code int) {
+	if c.WantsJSON() {
+		c.Problem(c.NewProblem(code, ""))
+		return
+	}
+	if traceID := tracing.TraceID(c.Context); traceID != "" {
+		c.Response.Header().Set(TRACE_ID_HEADER, traceID)
+	}
 	http.Error(c.Response, http.StatusText(code), code)
 }
 
+// Returns logger with request-scoped attributes (request ID, user ID,
+// trace ID etc.) for handler-level logging
+func (c *Ctx) Logger() *slog.Logger {
+	return logging.FromContext(c.Context)
+}
+
+// Returns the session of the request. Values are read with
+// session.Get[T](c.Session(), key). Requires SessionMiddleware.
+func (c *Ctx) Session() *session.Session {
+	return session.FromContext(c.Context)
+}
+
+// Returns the authenticated principal of the request or nil for
+// anonymous requests. Requires AuthMiddleware.
+func (c *Ctx) User() *auth.Principal {
+	return auth.FromContext(c.Context)
+}
+
+// Check if the user may perform the action (e.g. "orders:write") on the
+// resource. Pass nil resource for checks not bound to a resource.
+// Requires AuthorizationMiddleware, without it every action is denied.
+func (c *Ctx) Can(action string, resource any) bool {
+	return authz.Can(c.Context, action, resource)
+}
+
+// Client IP is taken from the connection. Put a trusted proxy
+// middleware (e.g. chi's RealIP) in front if running behind one.
+func (c *Ctx) ClientIP() string {
+	host, _, err := net.SplitHostPort(c.Request.RemoteAddr)
+	if err != nil {
+		return c.Request.RemoteAddr
+	}
+	return host
+}
+
 func (c *Ctx) CloseBody() {
 	c.Request.Body.Close()
 }
@@ -7,6 +7,8 @@ import (
 	"net/http/httptest"
 	"testing"
 
+	"github.com/mcgtrt/go-puerto/internal/listquery"
+	"github.com/mcgtrt/go-puerto/internal/sse"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
@@ -78,6 +80,66 @@ func TestText(t *testing.T) {
 	assert.Equal(t, message, rec.Body.String(), "Expected response body to match text")
 }
 
+// Response writer which can't flush, like ones of middlewares hiding it
+type bufferedWriter struct {
+	http.ResponseWriter
+}
+
+func TestSSE(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/events", nil)
+	rec := httptest.NewRecorder()
+	stream, err := NewCtx(rec, req).SSE()
+	assert.NoError(t, err)
+	assert.NoError(t, stream.Send(sse.Event{Name: "ping", Data: "pong"}))
+	assert.Equal(t, "text/event-stream", rec.Header().Get("Content-Type"))
+	assert.Equal(t, "event: ping\ndata: pong\n\n", rec.Body.String())
+	assert.True(t, rec.Flushed, "Expected events flushed")
+
+	rec = httptest.NewRecorder()
+	_, err = NewCtx(bufferedWriter{rec}, req).SSE()
+	assert.ErrorIs(t, err, sse.ErrStreamingUnsupported)
+	assert.False(t, rec.Code != http.StatusOK || rec.Body.Len() > 0 || rec.Header().Get("Content-Type") != "", "Expected nothing written")
+}
+
+func TestRedirect(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/login", nil)
+	rec := httptest.NewRecorder()
+	err := NewCtx(rec, req).Redirect("/")
+
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusSeeOther, rec.Code, "Expected browser redirect")
+	assert.Equal(t, "/", rec.Header().Get("Location"))
+
+	req.Header.Set("HX-Request", "true")
+	rec = httptest.NewRecorder()
+	err = NewCtx(rec, req).Redirect("/")
+
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, rec.Code, "Expected HTMX redirect")
+	assert.Equal(t, "/", rec.Header().Get("HX-Redirect"))
+	assert.Empty(t, rec.Header().Get("Location"))
+}
+
+func TestListQuery(t *testing.T) {
+	spec := &listquery.Spec{
+		Fields: []listquery.Field{{Name: "id", Type: listquery.TYPE_STRING, Sortable: true}},
+		Key:    "id",
+	}
+	req := httptest.NewRequest(http.MethodGet, "/orders?page=2&limit=10", nil)
+	rec := httptest.NewRecorder()
+	ctx := NewCtx(rec, req)
+
+	q, err := ctx.ListQuery(spec)
+	assert.NoError(t, err)
+	ctx.Paginate(q.Pages(req.URL, 25, false))
+	assert.Equal(t, `</orders?limit=10>; rel="first", </orders?limit=10>; rel="prev", `+
+		`</orders?limit=10&page=3>; rel="next", </orders?limit=10&page=3>; rel="last"`, rec.Header().Get("Link"))
+
+	req = httptest.NewRequest(http.MethodGet, "/orders?sort=secret", nil)
+	_, err = NewCtx(rec, req).ListQuery(spec)
+	assert.ErrorIs(t, err, listquery.ErrInvalidQuery)
+}
+
 func TestError(t *testing.T) {
 	req := httptest.NewRequest(http.MethodGet, "/", nil)
 	rec := httptest.NewRecorder()
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/mcgtrt/go-puerto/internal/scheduler"
+	"github.com/mcgtrt/go-puerto/storage"
+)
+
+// Time given to all checks of one health request
+const HEALTH_CHECK_TIMEOUT = 2 * time.Second
+
+const (
+	HEALTH_OK          = "ok"
+	HEALTH_UNAVAILABLE = "unavailable"
+)
+
+// Verifies a service the app depends on is reachable
+type HealthCheck func(ctx context.Context) error
+
+// Health of the app for load balancers and orchestrators. Responds 503
+// when any check fails. Errors are logged, not shown, as the endpoint is
+// public.
+type HealthHandler struct {
+	Checks map[string]HealthCheck
+	// Status of the scheduled tasks, nil without the scheduler
+	Scheduler *scheduler.Scheduler
+}
+
+type HealthStatus struct {
+	Status    string            `json:"status"`
+	Checks    map[string]string `json:"checks,omitempty"`
+	Scheduler *scheduler.Status `json:"scheduler,omitempty"`
+}
+
+// Checks ping the configured databases
+func NewHealthHandler(store *storage.Store) *HealthHandler {
+	h := &HealthHandler{Checks: map[string]HealthCheck{}}
+	if store.Mongo != nil {
+		h.Checks["mongo"] = func(ctx context.Context) error { return store.Mongo.Client.Ping(ctx, nil) }
+	}
+	if store.Postgres != nil {
+		h.Checks["postgres"] = store.Postgres.Pool.Ping
+	}
+	if store.Valkey != nil {
+		client := store.Valkey.Client
+		h.Checks["valkey"] = func(ctx context.Context) error { return client.Do(ctx, client.B().Ping().Build()).Error() }
+	}
+	return h
+}
+
+func (h *HealthHandler) HandleHealth(c *Ctx) error {
+	ctx, cancel := context.WithTimeout(c.Context, HEALTH_CHECK_TIMEOUT)
+	defer cancel()
+
+	status := HealthStatus{Status: HEALTH_OK, Checks: make(map[string]string, len(h.Checks))}
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	for name, check := range h.Checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result := HEALTH_OK
+			if err := check(ctx); err != nil {
+				c.Logger().Error("health check failed", "check", name, "error", err)
+				result = HEALTH_UNAVAILABLE
+			}
+			mu.Lock()
+			status.Checks[name] = result
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	code := http.StatusOK
+	for _, result := range status.Checks {
+		if result != HEALTH_OK {
+			status.Status, code = HEALTH_UNAVAILABLE, http.StatusServiceUnavailable
+		}
+	}
+	if h.Scheduler != nil {
+		s := h.Scheduler.Status()
+		status.Scheduler = &s
+	}
+	c.Response.Header().Set("Cache-Control", "no-store")
+	return c.JSON(code, status)
+}
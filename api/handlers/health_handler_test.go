@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mcgtrt/go-puerto/internal/scheduler"
+	"github.com/mcgtrt/go-puerto/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthHandler(t *testing.T) {
+	do := func(h *HealthHandler) (*httptest.ResponseRecorder, HealthStatus) {
+		req := httptest.NewRequest(http.MethodGet, "/health", nil)
+		rec := httptest.NewRecorder()
+		assert.NoError(t, h.HandleHealth(NewCtx(rec, req)))
+		var status HealthStatus
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &status))
+		return rec, status
+	}
+	healthy := func(ctx context.Context) error { return nil }
+
+	t.Run("Healthy", func(t *testing.T) {
+		s := scheduler.New(scheduler.LocalElector{}, &utils.SchedulerConfig{LockTTL: 30 * time.Second, Timezone: time.UTC})
+		assert.NoError(t, s.Add("cleanup", "@daily", func(ctx context.Context) error { return nil }))
+		h := &HealthHandler{Checks: map[string]HealthCheck{"postgres": healthy}, Scheduler: s}
+
+		rec, status := do(h)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "no-store", rec.Header().Get("Cache-Control"))
+		assert.Equal(t, HealthStatus{
+			Status:    HEALTH_OK,
+			Checks:    map[string]string{"postgres": HEALTH_OK},
+			Scheduler: &scheduler.Status{Tasks: []scheduler.TaskStatus{{Name: "cleanup", Schedule: "@daily", Timezone: "UTC"}}},
+		}, status)
+	})
+
+	t.Run("Failing check", func(t *testing.T) {
+		h := &HealthHandler{Checks: map[string]HealthCheck{
+			"postgres": healthy,
+			"valkey":   func(ctx context.Context) error { return errors.New("dial tcp 10.0.0.5:6379: connection refused") },
+		}}
+		rec, status := do(h)
+		assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+		assert.Equal(t, HEALTH_UNAVAILABLE, status.Status)
+		assert.Equal(t, HEALTH_UNAVAILABLE, status.Checks["valkey"])
+		assert.NotContains(t, rec.Body.String(), "10.0.0.5", "Expected errors hidden")
+		assert.Nil(t, status.Scheduler)
+	})
+}
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mcgtrt/go-puerto/internal/images"
+	"github.com/mcgtrt/go-puerto/internal/uploads"
+)
+
+// Serves uploaded images resized to the signed size of the URL, e.g.
+// /img/640x480/01J0ABC?fit=cover&expires=...&s=... Build URLs with
+// images.URL or the srcset of @layout.Picture, changed and expired URLs
+// are refused.
+type ImageHandler struct {
+	Images *images.Service
+}
+
+func NewImageHandler(service *images.Service) *ImageHandler {
+	return &ImageHandler{Images: service}
+}
+
+func (h *ImageHandler) HandleImage(c *Ctx) error {
+	key := chi.URLParam(c.Request, "*")
+	p, expires, err := h.Images.Parse(key, chi.URLParam(c.Request, "size"), c.Request.URL.Query())
+	switch {
+	case errors.Is(err, images.ErrInvalidSignature):
+		return c.Problem(c.NewProblem(http.StatusForbidden, "Image link is invalid or expired."))
+	case err != nil:
+		return c.Problem(c.NewProblem(http.StatusBadRequest, "Image size or format is not supported."))
+	}
+	r, o, err := h.Images.Render(c.Context, key, p)
+	switch {
+	case errors.Is(err, uploads.ErrBlobNotFound):
+		return c.Problem(c.NewProblem(http.StatusNotFound, "Image not found."))
+	case errors.Is(err, images.ErrNotImage):
+		return c.Problem(c.NewProblem(http.StatusUnsupportedMediaType, "File is not a supported image."))
+	case errors.Is(err, images.ErrTooManyPixels):
+		return c.Problem(c.NewProblem(http.StatusUnprocessableEntity, "Image is too large to resize."))
+	case err != nil:
+		return err
+	}
+	defer r.Close()
+
+	header := c.Response.Header()
+	header.Set("Content-Type", o.ContentType)
+	header.Set("Content-Security-Policy", "default-src 'none'; sandbox")
+	header.Set("X-Content-Type-Options", "nosniff")
+	// The signed URL always returns the same image, but like the download
+	// links it's not kept past the expiry
+	header.Set("Cache-Control", "private, max-age="+strconv.Itoa(int(time.Until(expires).Seconds()))+", immutable")
+	return serveBlob(c, r, o)
+}
@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mcgtrt/go-puerto/internal/images"
+	"github.com/mcgtrt/go-puerto/internal/uploads"
+	"github.com/mcgtrt/go-puerto/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImageHandler(t *testing.T) {
+	store := uploads.NewMemoryStore()
+	service := uploads.NewService(store, &utils.UploadsConfig{MaxSize: 1 << 20, AllowedTypes: []string{"image/*"}, URLTTL: time.Hour}, []byte("secret"))
+	h := NewImageHandler(images.NewService(service, &utils.ImagesConfig{MaxDimension: 100, MaxPixels: 10_000, Quality: 80}))
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewGray(image.Rect(0, 0, 60, 30)))
+	assert.NoError(t, store.Put(context.Background(), &uploads.Object{Key: "photo", ContentType: "image/png"}, &buf))
+
+	r := chi.NewRouter()
+	r.Get(images.IMAGE_PATH+"{size}/*", func(w http.ResponseWriter, req *http.Request) {
+		assert.NoError(t, h.HandleImage(NewCtx(w, req)))
+	})
+	get := func(target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		return rec
+	}
+
+	link := h.Images.URL("photo", images.Params{Width: 20, Height: 20})
+	rec := get(link)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "image/png", rec.Header().Get("Content-Type"))
+	cacheControl := rec.Header().Get("Cache-Control")
+	assert.True(t, strings.HasPrefix(cacheControl, "private, max-age="), "Expected image kept by the browser only")
+	maxAge, _ := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(cacheControl, "private, max-age="), ", immutable"))
+	assert.LessOrEqual(t, maxAge, int((2 * time.Hour).Seconds()), "Expected max-age capped at the expiry")
+	img, _, err := image.Decode(rec.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 20, 20), img.Bounds())
+
+	rec = get(strings.Replace(link, "20x20", "40x40", 1))
+	assert.Equal(t, http.StatusForbidden, rec.Code, "Expected other sizes refused")
+	rec = get(h.Images.URL("missing", images.Params{Width: 20}))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	rec = get(h.Images.URL("photo", images.Params{Width: 200}))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
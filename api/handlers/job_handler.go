@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mcgtrt/go-puerto/internal/jobs"
+	"github.com/mcgtrt/go-puerto/templates/pages"
+	"github.com/mcgtrt/go-puerto/utils"
+)
+
+// Jobs listed per status on the admin page
+const JOBS_PAGE_LIMIT = 100
+
+// Admin page of the background jobs. Failed jobs can be queued again or
+// deleted. HTMX requests get the jobs panel only.
+type JobHandler struct {
+	Queue *jobs.Queue
+}
+
+func NewJobHandler(queue *jobs.Queue) *JobHandler {
+	return &JobHandler{Queue: queue}
+}
+
+func (h *JobHandler) HandleListPage(c *Ctx) error {
+	status := c.Request.URL.Query().Get("status")
+	if !slices.Contains(pages.JobStatuses, status) {
+		status = jobs.STATUS_QUEUED
+	}
+	return h.render(c, status)
+}
+
+// Queue the failed job again with fresh attempts
+func (h *JobHandler) HandleRetry(c *Ctx) error {
+	id := chi.URLParam(c.Request, "id")
+	err := h.Queue.Backend.Requeue(c.Context, id)
+	switch {
+	case errors.Is(err, jobs.ErrJobNotFound):
+		return c.Problem(c.NewProblem(http.StatusNotFound, "Failed job not found."))
+	case errors.Is(err, jobs.ErrDuplicate):
+		return c.Problem(c.NewProblem(http.StatusConflict, "A job with the same unique key is already queued."))
+	case err != nil:
+		return err
+	}
+	c.Logger().Info("failed job queued again", "job_id", id)
+	return h.done(c)
+}
+
+func (h *JobHandler) HandleDelete(c *Ctx) error {
+	id := chi.URLParam(c.Request, "id")
+	err := h.Queue.Backend.Delete(c.Context, id)
+	if errors.Is(err, jobs.ErrJobNotFound) {
+		return c.Problem(c.NewProblem(http.StatusNotFound, "Failed job not found."))
+	}
+	if err != nil {
+		return err
+	}
+	c.Logger().Info("failed job deleted", "job_id", id)
+	return h.done(c)
+}
+
+// Show the failed jobs left after an action
+func (h *JobHandler) done(c *Ctx) error {
+	if c.Request.Header.Get("HX-Request") == "true" {
+		return h.render(c, jobs.STATUS_FAILED)
+	}
+	return c.Redirect("/admin/jobs?status=" + jobs.STATUS_FAILED)
+}
+
+func (h *JobHandler) render(c *Ctx, status string) error {
+	counts, err := h.Queue.Backend.Count(c.Context)
+	if err != nil {
+		return err
+	}
+	list, err := h.Queue.Backend.List(c.Context, status, JOBS_PAGE_LIMIT)
+	if err != nil {
+		return err
+	}
+	view := pages.JobsView{Status: status, Counts: counts, Jobs: make([]pages.JobItem, len(list))}
+	for i, j := range list {
+		view.Jobs[i] = pages.JobItem{
+			ID:          j.ID,
+			Kind:        j.Kind,
+			Priority:    priorityName(j.Priority),
+			Attempts:    j.Attempts,
+			MaxAttempts: j.MaxAttempts,
+			RunAt:       j.RunAt,
+			UpdatedAt:   j.UpdatedAt,
+			LastError:   j.LastError,
+		}
+	}
+	if c.Request.Header.Get("HX-Request") == "true" {
+		return c.Render(pages.JobsPanel(view))
+	}
+	lang, _ := utils.GetLocale(c.Context)
+	return c.Render(pages.JobsPage(lang, view))
+}
+
+func priorityName(priority int) string {
+	switch priority {
+	case jobs.PRIORITY_LOW:
+		return "low"
+	case jobs.PRIORITY_NORMAL:
+		return "normal"
+	case jobs.PRIORITY_HIGH:
+		return "high"
+	}
+	return strconv.Itoa(priority)
+}
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mcgtrt/go-puerto/internal/jobs"
+	"github.com/mcgtrt/go-puerto/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJobHandler(t *testing.T) {
+	backend := jobs.NewMemoryBackend()
+	queue := jobs.NewQueue(backend, &utils.JobsConfig{Concurrency: 1, MaxAttempts: 1, Lease: time.Minute, PollInterval: time.Second})
+	queue.Handle("report", func(ctx context.Context, j *jobs.Job) error { return nil })
+	h := NewJobHandler(queue)
+
+	queued, err := queue.Enqueue(context.Background(), "report", []byte(`{}`))
+	assert.NoError(t, err)
+	failed, err := queue.Enqueue(context.Background(), "report", []byte(`{}`), jobs.Priority(jobs.PRIORITY_HIGH))
+	assert.NoError(t, err)
+	claimed, err := backend.Claim(context.Background(), []string{"report"}, time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, failed.ID, claimed.ID)
+	assert.NoError(t, backend.Fail(context.Background(), claimed, "smtp: connection refused"))
+
+	do := func(fn func(*Ctx) error, method, target string, htmx bool, id string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		if htmx {
+			req.Header.Set("HX-Request", "true")
+		}
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", id)
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+		rec := httptest.NewRecorder()
+		assert.NoError(t, fn(NewCtx(rec, req)))
+		return rec
+	}
+
+	t.Run("List", func(t *testing.T) {
+		rec := do(h.HandleListPage, http.MethodGet, "/admin/jobs", false, "")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), "<html")
+		assert.Contains(t, rec.Body.String(), queued.ID)
+		assert.Contains(t, rec.Body.String(), "failed (1)")
+		assert.NotContains(t, rec.Body.String(), failed.ID)
+
+		rec = do(h.HandleListPage, http.MethodGet, "/admin/jobs?status=failed", true, "")
+		assert.NotContains(t, rec.Body.String(), "<html", "Expected the panel only for HTMX")
+		assert.Contains(t, rec.Body.String(), "smtp: connection refused")
+		assert.Contains(t, rec.Body.String(), "/admin/jobs/"+failed.ID+"/retry")
+	})
+
+	t.Run("Retry", func(t *testing.T) {
+		rec := do(h.HandleRetry, http.MethodPost, "/admin/jobs/"+queued.ID+"/retry", false, queued.ID)
+		assert.Equal(t, http.StatusNotFound, rec.Code, "Expected only failed jobs retried")
+
+		rec = do(h.HandleRetry, http.MethodPost, "/admin/jobs/"+failed.ID+"/retry", false, failed.ID)
+		assert.Equal(t, http.StatusSeeOther, rec.Code)
+		list, err := backend.List(context.Background(), jobs.STATUS_QUEUED, 10)
+		assert.NoError(t, err)
+		assert.Len(t, list, 2)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		claimed, err := backend.Claim(context.Background(), []string{"report"}, time.Minute)
+		assert.NoError(t, err)
+		assert.NoError(t, backend.Fail(context.Background(), claimed, "boom"))
+
+		rec := do(h.HandleDelete, http.MethodPost, "/admin/jobs/"+claimed.ID+"/delete", true, claimed.ID)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), "No failed jobs")
+		assert.ErrorIs(t, backend.Delete(context.Background(), claimed.ID), jobs.ErrJobNotFound)
+	})
+}
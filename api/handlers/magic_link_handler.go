@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+
+	"github.com/mcgtrt/go-puerto/internal/accounts"
+	"github.com/mcgtrt/go-puerto/internal/logging"
+	"github.com/mcgtrt/go-puerto/internal/magiclink"
+	"github.com/mcgtrt/go-puerto/internal/mfa"
+	"github.com/mcgtrt/go-puerto/templates/pages"
+	"github.com/mcgtrt/go-puerto/utils"
+)
+
+// HTMX stops polling on this status
+const htmxStopPolling = 286
+
+// Passwordless login with links sent by email. Only the browser which
+// requested the link gets logged in - opening the link elsewhere approves
+// the login of that browser, which polls for it.
+type MagicLinkHandler struct {
+	Links    *magiclink.Service
+	Notifier accounts.Notifier
+	// Public address of the application used in emailed links
+	BaseURL string
+	// Second factor asked after the link, nil without MFA
+	MFA *mfa.Service
+	// Send the browser cookie over HTTPS only
+	Secure bool
+}
+
+func NewMagicLinkHandler(service *magiclink.Service, notifier accounts.Notifier, baseURL string, secure bool) *MagicLinkHandler {
+	return &MagicLinkHandler{
+		Links:    service,
+		Notifier: notifier,
+		BaseURL:  baseURL,
+		Secure:   secure,
+	}
+}
+
+func (h *MagicLinkHandler) HandleRequestPage(c *Ctx) error {
+	lang, _ := utils.GetLocale(c.Context)
+	return c.Render(pages.MagicLinkRequestPage(lang, pages.AccountForm{}))
+}
+
+func (h *MagicLinkHandler) HandleRequest(c *Ctx) error {
+	lang, _ := utils.GetLocale(c.Context)
+	email := c.Request.PostFormValue("email")
+	started, err := h.Links.Begin(c.Context, email, c.ClientIP(), c.Request.UserAgent())
+	switch {
+	case errors.Is(err, accounts.ErrInvalidEmail):
+		c.Response.WriteHeader(http.StatusUnprocessableEntity)
+		return c.Render(pages.MagicLinkRequestPage(lang, pages.AccountForm{
+			Email:  email,
+			Errors: map[string]string{"email": "Enter a valid email address."},
+		}))
+	case errors.Is(err, magiclink.ErrRateLimited):
+		c.Response.WriteHeader(http.StatusTooManyRequests)
+		return c.Render(pages.AccountMessagePage(lang, "Too many requests", "Too many login links were requested for this address. Please try again later."))
+	case err != nil:
+		return err
+	}
+
+	magiclink.SetBrowserCookie(c.Response, started, h.Secure)
+	// Sent in the background, so the response takes the same time for
+	// addresses without an account
+	if started.User != nil {
+		ctx := context.WithoutCancel(c.Context)
+		link := h.BaseURL + magiclink.COOKIE_PATH + "/confirm?token=" + url.QueryEscape(started.Token)
+		go func() {
+			n := accounts.Notification{Kind: accounts.NOTIFY_MAGIC_LINK, User: started.User, Link: link, Lang: lang}
+			if err := h.Notifier.Notify(ctx, n); err != nil {
+				logging.FromContext(ctx).Error("sending login link failed", "error", err)
+			}
+		}()
+	}
+	return c.Render(pages.MagicLinkSentPage(lang))
+}
+
+// Polled by the page waiting for the link. HTMX receives no content until
+// the link is confirmed, the browser is logged in then.
+func (h *MagicLinkHandler) HandleStatus(c *Ctx) error {
+	lang, _ := utils.GetLocale(c.Context)
+	htmx := c.Request.Header.Get("HX-Request") == "true"
+	browser, ok := magiclink.BrowserSecret(c.Request)
+	if !ok {
+		return h.expired(c, lang, htmx)
+	}
+	user, err := h.Links.Complete(c.Context, browser)
+	switch {
+	case errors.Is(err, magiclink.ErrLinkPending):
+		if htmx {
+			c.Response.WriteHeader(http.StatusNoContent)
+			return nil
+		}
+		return c.Render(pages.MagicLinkSentPage(lang))
+	case errors.Is(err, magiclink.ErrLinkNotFound) || errors.Is(err, accounts.ErrUserNotFound):
+		return h.expired(c, lang, htmx)
+	case err != nil:
+		return err
+	}
+	magiclink.ClearBrowserCookie(c.Response, h.Secure)
+	return signIn(c, h.MFA, user.ID, "/")
+}
+
+// Opening the emailed link only asks for confirmation, so mail scanners
+// following links don't use the token up
+func (h *MagicLinkHandler) HandleConfirmPage(c *Ctx) error {
+	lang, _ := utils.GetLocale(c.Context)
+	token := c.Request.URL.Query().Get("token")
+	link, err := h.Links.Lookup(c.Context, token)
+	if errors.Is(err, magiclink.ErrLinkNotFound) {
+		return h.invalid(c, lang)
+	}
+	if err != nil {
+		return err
+	}
+	return c.Render(pages.MagicLinkConfirmPage(lang, pages.MagicLinkConfirm{
+		Token:       token,
+		SameBrowser: magiclink.IsRequestingBrowser(c.Request, link),
+		IP:          link.IP,
+		UserAgent:   link.UserAgent,
+		RequestedAt: link.CreatedAt,
+	}))
+}
+
+func (h *MagicLinkHandler) HandleConfirm(c *Ctx) error {
+	lang, _ := utils.GetLocale(c.Context)
+	link, err := h.Links.Confirm(c.Context, c.Request.PostFormValue("token"))
+	if errors.Is(err, magiclink.ErrLinkNotFound) {
+		return h.invalid(c, lang)
+	}
+	if err != nil {
+		return err
+	}
+	if !magiclink.IsRequestingBrowser(c.Request, link) {
+		return c.Render(pages.AccountMessagePage(lang, "Login approved", "You're logging in on the device where you requested the link. You can close this page."))
+	}
+
+	browser, _ := magiclink.BrowserSecret(c.Request)
+	user, err := h.Links.Complete(c.Context, browser)
+	if errors.Is(err, magiclink.ErrLinkNotFound) || errors.Is(err, accounts.ErrUserNotFound) {
+		return h.invalid(c, lang)
+	}
+	if err != nil {
+		return err
+	}
+	magiclink.ClearBrowserCookie(c.Response, h.Secure)
+	return signIn(c, h.MFA, user.ID, "/")
+}
+
+func (h *MagicLinkHandler) expired(c *Ctx, lang string, htmx bool) error {
+	if htmx {
+		c.Response.WriteHeader(htmxStopPolling)
+		return c.Render(pages.MagicLinkExpired())
+	}
+	return h.invalid(c, lang)
+}
+
+func (h *MagicLinkHandler) invalid(c *Ctx, lang string) error {
+	c.Response.WriteHeader(http.StatusBadRequest)
+	return c.Render(pages.AccountMessagePage(lang, "Invalid link", "The login link is invalid or has expired. Please request a new one."))
+}
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mcgtrt/go-puerto/internal/accounts"
+	"github.com/mcgtrt/go-puerto/internal/magiclink"
+	"github.com/mcgtrt/go-puerto/internal/session"
+	"github.com/mcgtrt/go-puerto/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMagicLinkHandler(t *testing.T) {
+	service := accounts.NewService(accounts.NewMemoryStore())
+	service.HashParams = accounts.HashParams{Memory: 1024, Time: 1, Threads: 1, SaltLen: 16, KeyLen: 32}
+	user, token, err := service.Register(context.Background(), "john@example.com", "John", "Secret1!")
+	assert.NoError(t, err)
+	_, err = service.VerifyEmail(context.Background(), token)
+	assert.NoError(t, err)
+
+	links := magiclink.NewService(magiclink.NewMemoryStore(), service, &utils.MagicLinkConfig{
+		TTL:           15 * time.Minute,
+		MaxRequests:   5,
+		RequestWindow: time.Hour,
+	})
+	notifier := &recordingNotifier{}
+	h := NewMagicLinkHandler(links, notifier, "https://example.com", false)
+
+	do := func(fn func(*Ctx) error, method, target string, form url.Values, s *session.Session, cookie *http.Cookie, htmx bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		if htmx {
+			req.Header.Set("HX-Request", "true")
+		}
+		rec := httptest.NewRecorder()
+		assert.NoError(t, fn(NewCtx(rec, req.WithContext(session.WithSession(req.Context(), s)))))
+		return rec
+	}
+	// Request the link and return the browser cookie and the emailed token
+	request := func(s *session.Session) (*http.Cookie, string) {
+		_, count := notifier.last()
+		rec := do(h.HandleRequest, http.MethodPost, "/login/link", url.Values{"email": {"john@example.com"}}, s, nil, false)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), `hx-get="/login/link/status"`, "Expected polling")
+		assert.Eventually(t, func() bool {
+			_, sent := notifier.last()
+			return sent == count+1
+		}, time.Second, 10*time.Millisecond)
+
+		n, _ := notifier.last()
+		assert.Equal(t, accounts.NOTIFY_MAGIC_LINK, n.Kind)
+		link, err := url.Parse(n.Link)
+		assert.NoError(t, err)
+		assert.Equal(t, "/login/link/confirm", link.Path)
+		return rec.Result().Cookies()[0], link.Query().Get("token")
+	}
+
+	t.Run("Link opened on another device", func(t *testing.T) {
+		s := &session.Session{}
+		cookie, token := request(s)
+
+		rec := do(h.HandleStatus, http.MethodGet, "/login/link/status", nil, s, cookie, true)
+		assert.Equal(t, http.StatusNoContent, rec.Code, "Expected polling to continue")
+
+		other := &session.Session{}
+		rec = do(h.HandleConfirmPage, http.MethodGet, "/login/link/confirm?token="+token, nil, other, nil, false)
+		assert.Contains(t, rec.Body.String(), "requested from another browser")
+		rec = do(h.HandleConfirm, http.MethodPost, "/login/link/confirm", url.Values{"token": {token}}, other, nil, false)
+		assert.Contains(t, rec.Body.String(), "Login approved")
+		assert.Empty(t, other.UserID, "Expected only the requesting browser logged in")
+
+		rec = do(h.HandleStatus, http.MethodGet, "/login/link/status", nil, s, cookie, true)
+		assert.Equal(t, "/", rec.Header().Get("HX-Redirect"))
+		assert.Equal(t, user.ID, s.UserID)
+
+		rec = do(h.HandleStatus, http.MethodGet, "/login/link/status", nil, &session.Session{}, cookie, true)
+		assert.Equal(t, htmxStopPolling, rec.Code, "Expected link used once")
+	})
+
+	t.Run("Link opened in the same browser", func(t *testing.T) {
+		s := &session.Session{}
+		cookie, token := request(s)
+		rec := do(h.HandleConfirmPage, http.MethodGet, "/login/link/confirm?token="+token, nil, s, cookie, false)
+		assert.NotContains(t, rec.Body.String(), "another browser")
+
+		rec = do(h.HandleConfirm, http.MethodPost, "/login/link/confirm", url.Values{"token": {token}}, s, cookie, false)
+		assert.Equal(t, http.StatusSeeOther, rec.Code)
+		assert.Equal(t, user.ID, s.UserID)
+
+		rec = do(h.HandleConfirm, http.MethodPost, "/login/link/confirm", url.Values{"token": {token}}, &session.Session{}, cookie, false)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, "Expected single-use token")
+	})
+
+	t.Run("Unknown address looks the same", func(t *testing.T) {
+		_, count := notifier.last()
+		rec := do(h.HandleRequest, http.MethodPost, "/login/link", url.Values{"email": {"nobody@example.com"}}, &session.Session{}, nil, false)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), "Check your inbox")
+		assert.NotEmpty(t, rec.Result().Cookies())
+
+		rec = do(h.HandleStatus, http.MethodGet, "/login/link/status", nil, &session.Session{}, rec.Result().Cookies()[0], true)
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+		time.Sleep(20 * time.Millisecond)
+		_, sent := notifier.last()
+		assert.Equal(t, count, sent, "Expected no email")
+	})
+
+	t.Run("Rate limited per address", func(t *testing.T) {
+		var rec *httptest.ResponseRecorder
+		for range links.MaxRequests + 1 {
+			rec = do(h.HandleRequest, http.MethodPost, "/login/link", url.Values{"email": {"limited@example.com"}}, &session.Session{}, nil, false)
+		}
+		assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	})
+}
@@ -0,0 +1,246 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/mcgtrt/go-puerto/internal/accounts"
+	"github.com/mcgtrt/go-puerto/internal/mfa"
+	"github.com/mcgtrt/go-puerto/templates/pages"
+	"github.com/mcgtrt/go-puerto/utils"
+)
+
+// Path of the page asking for the second factor after the password
+const MFA_LOGIN_PATH = "/login/2fa"
+
+// Message of requests to the settings authenticated by tokens or API keys
+const MFA_SESSION_ONLY_MESSAGE = "Two-factor authentication can only be managed after logging in."
+
+// Second factor step of the login and two-factor settings of the user.
+// Changing settings of enabled two-factor authentication requires
+// a current code, so a hijacked session can't turn it off. Settings are
+// managed in the browser session only, so a leaked token or API key
+// can't enrol another authenticator and lock the owner out.
+type MFAHandler struct {
+	MFA      *mfa.Service
+	Accounts *accounts.Service
+	// Send the remember device cookie over HTTPS only
+	Secure bool
+}
+
+func NewMFAHandler(service *mfa.Service, accountService *accounts.Service, secure bool) *MFAHandler {
+	return &MFAHandler{
+		MFA:      service,
+		Accounts: accountService,
+		Secure:   secure,
+	}
+}
+
+// Finish login of the user authenticated by the password or identity
+// provider. Users with two-factor authentication continue to the second
+// factor unless the browser is remembered.
+func signIn(c *Ctx, service *mfa.Service, userID, next string) error {
+	if service != nil {
+		required, err := service.Required(c.Context, c.Request, userID)
+		if err != nil {
+			return err
+		}
+		if required {
+			if err := service.SavePendingLogin(c.Session(), userID, next); err != nil {
+				return err
+			}
+			return c.Redirect(MFA_LOGIN_PATH)
+		}
+	}
+	// Binding the user rotates the session ID
+	c.Session().SetUser(userID)
+	return c.Redirect(next)
+}
+
+func (h *MFAHandler) HandleChallengePage(c *Ctx) error {
+	lang, _ := utils.GetLocale(c.Context)
+	if _, ok := h.MFA.GetPendingLogin(c.Session()); !ok {
+		return c.Redirect("/login")
+	}
+	return c.Render(pages.MFAChallengePage(lang, pages.MFAForm{CanRemember: h.MFA.RememberDeviceTTL > 0}))
+}
+
+func (h *MFAHandler) HandleChallenge(c *Ctx) error {
+	lang, _ := utils.GetLocale(c.Context)
+	pending, ok := h.MFA.GetPendingLogin(c.Session())
+	if !ok {
+		return c.Redirect("/login")
+	}
+	recovery, err := h.MFA.Verify(c.Context, pending.UserID, c.Request.PostFormValue("code"))
+	switch {
+	case errors.Is(err, mfa.ErrInvalidCode):
+		c.Response.WriteHeader(http.StatusUnauthorized)
+		return c.Render(pages.MFAChallengePage(lang, pages.MFAForm{
+			CanRemember: h.MFA.RememberDeviceTTL > 0,
+			Errors:      map[string]string{"code": "Invalid code."},
+		}))
+	case errors.Is(err, mfa.ErrTooManyAttempts):
+		c.Logger().Warn("second factor locked after failed attempts", "user_id", pending.UserID)
+		h.MFA.ClearPendingLogin(c.Session())
+		c.Response.WriteHeader(http.StatusTooManyRequests)
+		return c.Render(pages.AccountMessagePage(lang, "Too many attempts", "Too many invalid codes. Please try again later."))
+	case err != nil:
+		return err
+	}
+
+	h.MFA.ClearPendingLogin(c.Session())
+	c.Session().SetUser(pending.UserID)
+	if c.Request.PostFormValue("remember") == "on" {
+		if err := h.MFA.RememberDevice(c.Context, c.Response, pending.UserID, h.Secure); err != nil {
+			c.Logger().Warn("remembering device failed", "error", err)
+		}
+	}
+	// Show users how many recovery codes they have left
+	if recovery {
+		return c.Redirect("/account/2fa")
+	}
+	return c.Redirect(pending.Next)
+}
+
+func (h *MFAHandler) HandleSettingsPage(c *Ctx) error {
+	if !isSession(c) {
+		return c.Problem(c.NewProblem(http.StatusForbidden, MFA_SESSION_ONLY_MESSAGE))
+	}
+	lang, _ := utils.GetLocale(c.Context)
+	form, err := h.settings(c)
+	if err != nil {
+		return err
+	}
+	return c.Render(pages.MFASettingsPage(lang, form))
+}
+
+// Start the TOTP enrolment showing the QR code to scan
+func (h *MFAHandler) HandleSetup(c *Ctx) error {
+	if !isSession(c) {
+		return c.Problem(c.NewProblem(http.StatusForbidden, MFA_SESSION_ONLY_MESSAGE))
+	}
+	lang, _ := utils.GetLocale(c.Context)
+	user, err := h.Accounts.Store.GetUserByID(c.Context, c.User().ID)
+	if err != nil {
+		return err
+	}
+	enrollment, err := h.MFA.BeginTOTP(c.Context, user.ID, user.Email)
+	if errors.Is(err, mfa.ErrAlreadyEnabled) {
+		return c.Redirect("/account/2fa")
+	}
+	if err != nil {
+		return err
+	}
+	return c.Render(pages.MFASetupPage(lang, pages.MFAForm{URI: enrollment.URI, Secret: groupSecret(enrollment.Secret)}))
+}
+
+// Turn two-factor authentication on with the first code from the app
+func (h *MFAHandler) HandleConfirm(c *Ctx) error {
+	if !isSession(c) {
+		return c.Problem(c.NewProblem(http.StatusForbidden, MFA_SESSION_ONLY_MESSAGE))
+	}
+	lang, _ := utils.GetLocale(c.Context)
+	user, err := h.Accounts.Store.GetUserByID(c.Context, c.User().ID)
+	if err != nil {
+		return err
+	}
+	codes, err := h.MFA.ConfirmTOTP(c.Context, user.ID, c.Request.PostFormValue("code"))
+	switch {
+	case errors.Is(err, mfa.ErrInvalidCode):
+		enrollment, err := h.MFA.PendingTOTP(c.Context, user.ID, user.Email)
+		if err != nil {
+			return err
+		}
+		c.Response.WriteHeader(http.StatusUnprocessableEntity)
+		return c.Render(pages.MFASetupPage(lang, pages.MFAForm{
+			URI:    enrollment.URI,
+			Secret: groupSecret(enrollment.Secret),
+			Errors: map[string]string{"code": "Invalid code. Check the time on your phone is correct."},
+		}))
+	case errors.Is(err, mfa.ErrTooManyAttempts):
+		c.Response.WriteHeader(http.StatusTooManyRequests)
+		return c.Render(pages.AccountMessagePage(lang, "Too many attempts", "Too many invalid codes. Please try again later."))
+	case errors.Is(err, mfa.ErrFactorNotFound):
+		return c.Redirect("/account/2fa")
+	case err != nil:
+		return err
+	}
+	if codes == nil {
+		return c.Redirect("/account/2fa")
+	}
+	return c.Render(pages.MFARecoveryCodesPage(lang, codes))
+}
+
+func (h *MFAHandler) HandleRecoveryCodes(c *Ctx) error {
+	if !isSession(c) {
+		return c.Problem(c.NewProblem(http.StatusForbidden, MFA_SESSION_ONLY_MESSAGE))
+	}
+	lang, _ := utils.GetLocale(c.Context)
+	if ok, err := h.verifyCurrent(c, lang); !ok {
+		return err
+	}
+	codes, err := h.MFA.RegenerateRecoveryCodes(c.Context, c.User().ID)
+	if err != nil {
+		return err
+	}
+	return c.Render(pages.MFARecoveryCodesPage(lang, codes))
+}
+
+func (h *MFAHandler) HandleDisable(c *Ctx) error {
+	if !isSession(c) {
+		return c.Problem(c.NewProblem(http.StatusForbidden, MFA_SESSION_ONLY_MESSAGE))
+	}
+	lang, _ := utils.GetLocale(c.Context)
+	if ok, err := h.verifyCurrent(c, lang); !ok {
+		return err
+	}
+	if err := h.MFA.Disable(c.Context, c.User().ID); err != nil {
+		return err
+	}
+	c.Logger().Info("two-factor authentication disabled")
+	return c.Redirect("/account/2fa")
+}
+
+// Check the code of the logged in user, rendering the settings page with
+// the error when it's wrong
+func (h *MFAHandler) verifyCurrent(c *Ctx, lang string) (bool, error) {
+	_, err := h.MFA.Verify(c.Context, c.User().ID, c.Request.PostFormValue("code"))
+	var message string
+	switch {
+	case errors.Is(err, mfa.ErrInvalidCode) || errors.Is(err, mfa.ErrFactorNotFound):
+		message = "Invalid code."
+	case errors.Is(err, mfa.ErrTooManyAttempts):
+		message = "Too many invalid codes. Please try again later."
+	case err != nil:
+		return false, err
+	default:
+		return true, nil
+	}
+	form, err := h.settings(c)
+	if err != nil {
+		return false, err
+	}
+	form.Errors = map[string]string{"code": message}
+	c.Response.WriteHeader(http.StatusUnprocessableEntity)
+	return false, c.Render(pages.MFASettingsPage(lang, form))
+}
+
+func (h *MFAHandler) settings(c *Ctx) (pages.MFAForm, error) {
+	enabled, err := h.MFA.Enabled(c.Context, c.User().ID)
+	if err != nil || !enabled {
+		return pages.MFAForm{}, err
+	}
+	left, err := h.MFA.RemainingRecoveryCodes(c.Context, c.User().ID)
+	return pages.MFAForm{Enabled: true, RecoveryCodesLeft: left}, err
+}
+
+// Split the secret into groups of four to make typing it easier
+func groupSecret(secret string) string {
+	var groups []string
+	for len(secret) > 4 {
+		groups = append(groups, secret[:4])
+		secret = secret[4:]
+	}
+	return strings.Join(append(groups, secret), " ")
+}
@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mcgtrt/go-puerto/internal/accounts"
+	"github.com/mcgtrt/go-puerto/internal/auth"
+	"github.com/mcgtrt/go-puerto/internal/mfa"
+	"github.com/mcgtrt/go-puerto/internal/session"
+	"github.com/mcgtrt/go-puerto/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMFAHandler(t *testing.T) {
+	t.Setenv(utils.AES_SECRET, "0123456789abcdef0123456789abcdef")
+	service := accounts.NewService(accounts.NewMemoryStore())
+	service.HashParams = accounts.HashParams{Memory: 1024, Time: 1, Threads: 1, SaltLen: 16, KeyLen: 32}
+	user, _, err := service.Register(context.Background(), "john@example.com", "John", "Secret1!")
+	assert.NoError(t, err)
+
+	m := mfa.NewService(mfa.NewMemoryStore(), &utils.MFAConfig{Issuer: "example.com", RememberDeviceTTL: time.Hour})
+	h := NewMFAHandler(m, service, false)
+	accountHandler := NewAccountHandler(service, nil, accounts.LogNotifier{}, "https://example.com")
+	accountHandler.MFA = m
+
+	post := func(fn func(*Ctx) error, target string, form url.Values, s *session.Session, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		ctx := session.WithSession(req.Context(), s)
+		if s.UserID != "" {
+			ctx = auth.WithPrincipal(ctx, &auth.Principal{ID: s.UserID, Method: auth.METHOD_SESSION})
+		}
+		rec := httptest.NewRecorder()
+		assert.NoError(t, fn(NewCtx(rec, req.WithContext(ctx))))
+		return rec
+	}
+	login := url.Values{"email": {"john@example.com"}, "password": {"Secret1!"}}
+	code := func(secret string, offset int64) string {
+		c, _ := mfa.GenerateCode(secret, mfa.TimeStep(time.Now())+offset)
+		return c
+	}
+
+	var secret string
+	var recoveryCodes []string
+	t.Run("Enable", func(t *testing.T) {
+		s := &session.Session{UserID: user.ID}
+		rec := post(h.HandleSetup, "/account/2fa/setup", nil, s)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), "<svg", "Expected QR code")
+		match := regexp.MustCompile(`<code>([A-Z2-7 ]+)</code>`).FindStringSubmatch(rec.Body.String())
+		if !assert.Len(t, match, 2, "Expected the key to type") {
+			t.FailNow()
+		}
+		secret = strings.ReplaceAll(match[1], " ", "")
+
+		rec = post(h.HandleConfirm, "/account/2fa/confirm", url.Values{"code": {"000000"}}, s)
+		assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+		assert.Contains(t, rec.Body.String(), "Invalid code")
+
+		rec = post(h.HandleConfirm, "/account/2fa/confirm", url.Values{"code": {code(secret, 0)}}, s)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		for _, match := range regexp.MustCompile(`<code>([a-z2-7]{5}-[a-z2-7]{5})</code>`).FindAllStringSubmatch(rec.Body.String(), -1) {
+			recoveryCodes = append(recoveryCodes, match[1])
+		}
+		assert.Len(t, recoveryCodes, mfa.RECOVERY_CODE_COUNT)
+	})
+
+	t.Run("Login asks for the code", func(t *testing.T) {
+		s := &session.Session{}
+		rec := post(accountHandler.HandleLogin, "/login?next=/orders", login, s)
+		assert.Equal(t, MFA_LOGIN_PATH, rec.Header().Get("Location"))
+		assert.Empty(t, s.UserID, "Expected user not logged in before the second factor")
+
+		rec = post(h.HandleChallenge, MFA_LOGIN_PATH, url.Values{"code": {"000000"}}, s)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.Empty(t, s.UserID)
+
+		rec = post(h.HandleChallenge, MFA_LOGIN_PATH, url.Values{"code": {code(secret, 1)}, "remember": {"on"}}, s)
+		assert.Equal(t, "/orders", rec.Header().Get("Location"))
+		assert.Equal(t, user.ID, s.UserID)
+
+		var device *http.Cookie
+		for _, cookie := range rec.Result().Cookies() {
+			if cookie.Name == mfa.DEVICE_COOKIE {
+				device = cookie
+			}
+		}
+		assert.NotNil(t, device)
+
+		t.Run("Remembered device skips the code", func(t *testing.T) {
+			s := &session.Session{}
+			rec := post(accountHandler.HandleLogin, "/login?next=/orders", login, s, device)
+			assert.Equal(t, "/orders", rec.Header().Get("Location"))
+			assert.Equal(t, user.ID, s.UserID)
+		})
+	})
+
+	t.Run("Challenge without password", func(t *testing.T) {
+		s := &session.Session{}
+		rec := post(h.HandleChallenge, MFA_LOGIN_PATH, url.Values{"code": {code(secret, 1)}}, s)
+		assert.Equal(t, "/login", rec.Header().Get("Location"))
+		assert.Empty(t, s.UserID)
+	})
+
+	t.Run("Recovery code", func(t *testing.T) {
+		s := &session.Session{}
+		post(accountHandler.HandleLogin, "/login", login, s)
+		rec := post(h.HandleChallenge, MFA_LOGIN_PATH, url.Values{"code": {recoveryCodes[0]}}, s)
+		assert.Equal(t, "/account/2fa", rec.Header().Get("Location"), "Expected remaining codes shown")
+		assert.Equal(t, user.ID, s.UserID)
+
+		s = &session.Session{}
+		post(accountHandler.HandleLogin, "/login", login, s)
+		rec = post(h.HandleChallenge, MFA_LOGIN_PATH, url.Values{"code": {recoveryCodes[0]}}, s)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code, "Expected recovery code used up")
+	})
+
+	t.Run("Disable requires a code", func(t *testing.T) {
+		s := &session.Session{UserID: user.ID}
+		rec := post(h.HandleDisable, "/account/2fa/disable", url.Values{"code": {"000000"}}, s)
+		assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+
+		rec = post(h.HandleDisable, "/account/2fa/disable", url.Values{"code": {recoveryCodes[1]}}, s)
+		assert.Equal(t, http.StatusSeeOther, rec.Code)
+		enabled, err := m.Enabled(context.Background(), user.ID)
+		assert.NoError(t, err)
+		assert.False(t, enabled)
+	})
+
+	t.Run("Tokens and API keys can't manage settings", func(t *testing.T) {
+		for _, method := range []string{auth.METHOD_BEARER, auth.METHOD_API_KEY} {
+			req := httptest.NewRequest(http.MethodPost, "/account/2fa/setup", nil)
+			ctx := auth.WithPrincipal(req.Context(), &auth.Principal{ID: user.ID, Method: method, Scopes: []string{"*"}})
+			rec := httptest.NewRecorder()
+			assert.NoError(t, h.HandleSetup(NewCtx(rec, req.WithContext(ctx))))
+			assert.Equal(t, http.StatusForbidden, rec.Code, "Expected %s rejected", method)
+		}
+		_, err := m.PendingTOTP(context.Background(), user.ID, user.Email)
+		assert.ErrorIs(t, err, mfa.ErrFactorNotFound, "Expected no enrolment started")
+	})
+}
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mcgtrt/go-puerto/internal/mfa"
+	"github.com/mcgtrt/go-puerto/internal/oidc"
+	"github.com/mcgtrt/go-puerto/templates/pages"
+	"github.com/mcgtrt/go-puerto/utils"
+)
+
+// Sign-in with OpenID Connect providers. Logged in users visiting the
+// login route of a provider get its identity linked to their account.
+type OIDCHandler struct {
+	Providers map[string]*oidc.Provider
+	Linker    *oidc.Linker
+	// Second factor asked after the provider, nil without MFA
+	MFA *mfa.Service
+}
+
+func NewOIDCHandler(providers []*oidc.Provider, linker *oidc.Linker) *OIDCHandler {
+	h := &OIDCHandler{
+		Providers: make(map[string]*oidc.Provider, len(providers)),
+		Linker:    linker,
+	}
+	for _, p := range providers {
+		h.Providers[p.Name] = p
+	}
+	return h
+}
+
+// Redirect the user to the provider. The state, nonce and PKCE verifier
+// stay in the session, so the callback is accepted only in the browser
+// that started the sign-in.
+func (h *OIDCHandler) HandleLogin(c *Ctx) error {
+	p, ok := h.Providers[chi.URLParam(c.Request, "provider")]
+	if !ok {
+		c.Error(http.StatusNotFound)
+		return nil
+	}
+	flow, authURL, err := p.Begin(c.Context, safeRedirect(c.Request.URL.Query().Get("next")))
+	if err != nil {
+		return err
+	}
+	if err := oidc.SaveFlow(c.Session(), flow); err != nil {
+		return err
+	}
+	return c.Redirect(authURL)
+}
+
+func (h *OIDCHandler) HandleCallback(c *Ctx) error {
+	lang, _ := utils.GetLocale(c.Context)
+	p, ok := h.Providers[chi.URLParam(c.Request, "provider")]
+	if !ok {
+		c.Error(http.StatusNotFound)
+		return nil
+	}
+	query := c.Request.URL.Query()
+	flow, err := oidc.TakeFlow(c.Session(), query.Get("state"))
+	if err != nil || flow.Provider != p.Name {
+		c.Response.WriteHeader(http.StatusBadRequest)
+		return c.Render(pages.AccountMessagePage(lang, "Sign-in expired", "Your sign-in has expired. Please try again."))
+	}
+	if query.Get("error") != "" {
+		c.Logger().Info("oidc sign-in cancelled", "provider", p.Name, "error", query.Get("error"))
+		c.Response.WriteHeader(http.StatusUnauthorized)
+		return c.Render(pages.AccountMessagePage(lang, "Sign-in cancelled", "Signing in with "+p.Label+" was cancelled."))
+	}
+
+	claims, err := p.Exchange(c.Context, flow, query.Get("code"))
+	if errors.Is(err, oidc.ErrExchange) || errors.Is(err, oidc.ErrInvalidIDToken) {
+		c.Logger().Warn("oidc sign-in failed", "provider", p.Name, "error", err)
+		c.Response.WriteHeader(http.StatusBadRequest)
+		return c.Render(pages.AccountMessagePage(lang, "Sign-in failed", "Signing in with "+p.Label+" failed. Please try again."))
+	}
+	if err != nil {
+		return err
+	}
+
+	user, err := h.Linker.Resolve(c.Context, p.Name, claims, c.Session().UserID)
+	switch {
+	case errors.Is(err, oidc.ErrEmailInUse):
+		c.Response.WriteHeader(http.StatusConflict)
+		return c.Render(pages.AccountMessagePage(lang, "Account exists", "An account with this email already exists. Log in with your password to link "+p.Label+" to it."))
+	case errors.Is(err, oidc.ErrIdentityLinked):
+		c.Response.WriteHeader(http.StatusConflict)
+		return c.Render(pages.AccountMessagePage(lang, "Account linked", "This "+p.Label+" account is linked to another user."))
+	case errors.Is(err, oidc.ErrEmailRequired):
+		c.Response.WriteHeader(http.StatusBadRequest)
+		return c.Render(pages.AccountMessagePage(lang, "Email required", p.Label+" didn't share your email address, which is required to create an account."))
+	case err != nil:
+		return err
+	}
+	// Logged in users only linked the provider to their account
+	if c.Session().UserID == user.ID {
+		return c.Redirect(flow.Next)
+	}
+	return signIn(c, h.MFA, user.ID, flow.Next)
+}
@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mcgtrt/go-puerto/internal/accounts"
+	"github.com/mcgtrt/go-puerto/internal/oidc"
+	"github.com/mcgtrt/go-puerto/internal/oidc/oidctest"
+	"github.com/mcgtrt/go-puerto/internal/session"
+	"github.com/mcgtrt/go-puerto/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOIDCHandler(t *testing.T) {
+	server := oidctest.NewServer(t)
+	provider := oidc.NewProvider(utils.OIDCProviderConfig{
+		Name:         "test",
+		Label:        "Test",
+		Issuer:       server.Issuer(),
+		ClientID:     server.ClientID,
+		ClientSecret: server.ClientSecret,
+		Scopes:       []string{"openid", "email", "profile"},
+	}, "https://example.com", server.Client())
+	service := accounts.NewService(accounts.NewMemoryStore())
+	h := NewOIDCHandler([]*oidc.Provider{provider}, oidc.NewLinker(service, oidc.NewMemoryStore()))
+
+	get := func(fn func(*Ctx) error, target string, s *session.Session) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("provider", "test")
+		ctx := context.WithValue(session.WithSession(req.Context(), s), chi.RouteCtxKey, rctx)
+		rec := httptest.NewRecorder()
+		assert.NoError(t, fn(NewCtx(rec, req.WithContext(ctx))))
+		return rec
+	}
+	// Start sign-in and return the callback URL the provider redirects to
+	signIn := func(s *session.Session, next string) string {
+		rec := get(h.HandleLogin, "/auth/oidc/test/login?next="+url.QueryEscape(next), s)
+		assert.Equal(t, http.StatusSeeOther, rec.Code)
+		client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}}
+		resp, err := client.Get(rec.Header().Get("Location"))
+		assert.NoError(t, err)
+		resp.Body.Close()
+		callback, _ := url.Parse(resp.Header.Get("Location"))
+		return callback.RequestURI()
+	}
+
+	t.Run("Sign in registers the user", func(t *testing.T) {
+		s := &session.Session{}
+		rec := get(h.HandleCallback, signIn(s, "/orders"), s)
+		assert.Equal(t, http.StatusSeeOther, rec.Code)
+		assert.Equal(t, "/orders", rec.Header().Get("Location"))
+
+		user, err := service.Store.GetUserByEmail(context.Background(), server.User.Email)
+		assert.NoError(t, err)
+		assert.Equal(t, user.ID, s.UserID)
+	})
+
+	t.Run("Callback is single-use", func(t *testing.T) {
+		s := &session.Session{}
+		callback := signIn(s, "")
+		assert.Equal(t, http.StatusSeeOther, get(h.HandleCallback, callback, s).Code)
+		rec := get(h.HandleCallback, callback, s)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), "Sign-in expired")
+	})
+
+	t.Run("Callback in another browser", func(t *testing.T) {
+		callback := signIn(&session.Session{}, "")
+		s := &session.Session{}
+		rec := get(h.HandleCallback, callback, s)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Empty(t, s.UserID, "Expected login CSRF rejected")
+	})
+
+	t.Run("Unsafe next", func(t *testing.T) {
+		s := &session.Session{}
+		rec := get(h.HandleCallback, signIn(s, "https://evil.example.com"), s)
+		assert.Equal(t, "/", rec.Header().Get("Location"))
+	})
+
+	t.Run("Existing unlinked account", func(t *testing.T) {
+		server.User = oidctest.User{Subject: "other", Email: server.User.Email}
+		s := &session.Session{}
+		rec := get(h.HandleCallback, signIn(s, ""), s)
+		assert.Equal(t, http.StatusConflict, rec.Code)
+		assert.Empty(t, s.UserID)
+	})
+
+	t.Run("Provider error", func(t *testing.T) {
+		s := &session.Session{}
+		authURL, _ := url.Parse(get(h.HandleLogin, "/auth/oidc/test/login", s).Header().Get("Location"))
+		rec := get(h.HandleCallback, "/auth/oidc/test/callback?error=access_denied&state="+authURL.Query().Get("state"), s)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.Contains(t, rec.Body.String(), "Sign-in cancelled")
+	})
+}
@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/mcgtrt/go-puerto/internal/tracing"
+	"github.com/mcgtrt/go-puerto/utils"
+)
+
+// Error response body following RFC 9457 (application/problem+json)
+// extended with the request and trace IDs for correlating the response
+// with logs
+type Problem struct {
+	Type      string `json:"type,omitempty"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	Instance  string `json:"instance,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+	TraceID   string `json:"trace_id,omitempty"`
+}
+
+// Response header holding the trace ID of failed requests
+const TRACE_ID_HEADER = "X-Trace-ID"
+
+// Create new problem for the request with the default status title
+func (c *Ctx) NewProblem(code int, detail string) *Problem {
+	return &Problem{
+		Title:     http.StatusText(code),
+		Status:    code,
+		Detail:    detail,
+		Instance:  c.Request.URL.Path,
+		RequestID: utils.GetRequestID(c.Context),
+		TraceID:   tracing.TraceID(c.Context),
+	}
+}
+
+// Write problem+json error response
+func (c *Ctx) Problem(p *Problem) error {
+	if p.TraceID != "" {
+		c.Response.Header().Set(TRACE_ID_HEADER, p.TraceID)
+	}
+	c.Response.Header().Set("Content-Type", "application/problem+json")
+	c.Response.WriteHeader(p.Status)
+	return json.NewEncoder(c.Response).Encode(p)
+}
+
+// Check if client asked for a JSON response rather than a web page
+func (c *Ctx) WantsJSON() bool {
+	accept := c.Request.Header.Get("Accept")
+	return strings.Contains(accept, "application/json") || strings.Contains(accept, "application/problem+json")
+}
@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/a-h/templ"
+	"github.com/mcgtrt/go-puerto/internal/tracing"
+	"github.com/mcgtrt/go-puerto/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProblem(t *testing.T) {
+	_, exporter := tracing.SetupInMemory()
+
+	t.Run("JSON clients receive problem with trace ID", func(t *testing.T) {
+		ctx, span := tracing.Tracer().Start(context.Background(), "test")
+		defer span.End()
+		traceID := span.SpanContext().TraceID().String()
+
+		ctx = context.WithValue(ctx, types.RequestIDCtxKey{}, "req-1")
+		req := httptest.NewRequest(http.MethodGet, "/orders", nil).WithContext(ctx)
+		req.Header.Set("Accept", "application/json")
+		rec := httptest.NewRecorder()
+		c := NewCtx(rec, req)
+
+		c.Error(http.StatusNotFound)
+
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+		assert.Equal(t, "application/problem+json", rec.Header().Get("Content-Type"))
+		assert.Equal(t, traceID, rec.Header().Get(TRACE_ID_HEADER))
+		assert.JSONEq(t, `{"title":"Not Found","status":404,"instance":"/orders","request_id":"req-1","trace_id":"`+traceID+`"}`, rec.Body.String())
+	})
+
+	t.Run("Browsers receive plain text error", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Accept", "text/html")
+		rec := httptest.NewRecorder()
+
+		NewCtx(rec, req).Error(http.StatusBadRequest)
+
+		assert.Equal(t, "Bad Request\n", rec.Body.String())
+		assert.Empty(t, rec.Header().Get(TRACE_ID_HEADER), "Expected no trace ID without a span")
+	})
+
+	t.Run("Render records a span", func(t *testing.T) {
+		exporter.Reset()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		rec := httptest.NewRecorder()
+		component := templ.ComponentFunc(func(ctx context.Context, w io.Writer) error {
+			_, err := w.Write([]byte("<p>hi</p>"))
+			return err
+		})
+
+		assert.NoError(t, NewCtx(rec, req).Render(component))
+		assert.Len(t, exporter.GetSpans(), 1, "Expected one render span")
+		assert.Equal(t, "templ.Render", exporter.GetSpans()[0].Name)
+	})
+}
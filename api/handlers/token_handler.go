@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/mcgtrt/go-puerto/internal/accounts"
+	"github.com/mcgtrt/go-puerto/internal/auth"
+	"github.com/mcgtrt/go-puerto/internal/jwt"
+	"github.com/mcgtrt/go-puerto/internal/mfa"
+)
+
+// Token endpoints of stateless (mobile) clients and the public keys
+// other services verify the tokens with
+type TokenHandler struct {
+	Tokens *jwt.Service
+	// Nil when accounts are disabled - tokens are issued by own code then
+	Accounts *accounts.Service
+	// Second factor required with the password, nil without MFA
+	MFA *mfa.Service
+}
+
+func NewTokenHandler(tokens *jwt.Service, service *accounts.Service) *TokenHandler {
+	return &TokenHandler{
+		Tokens:   tokens,
+		Accounts: service,
+	}
+}
+
+// Body of the token endpoints. Only JSON is accepted, which browsers
+// can't send cross-site without a CORS preflight.
+type TokenRequest struct {
+	Email        string `json:"email"`
+	Password     string `json:"password"`
+	RefreshToken string `json:"refresh_token"`
+	// Current code or recovery code of users with two-factor authentication
+	Code string `json:"code"`
+}
+
+// Serve the public keys of the keyring. Caching is short so rotated
+// keys are picked up by verifiers before they sign the first token.
+func (h *TokenHandler) HandleJWKS(c *Ctx) error {
+	set, err := h.Tokens.Keys.JWKS()
+	if err != nil {
+		return err
+	}
+	c.Response.Header().Set("Cache-Control", "public, max-age=300")
+	c.Response.Header().Set("Content-Type", "application/jwk-set+json")
+	c.Response.WriteHeader(http.StatusOK)
+	return json.NewEncoder(c.Response).Encode(set)
+}
+
+// Exchange email and password for access and refresh tokens
+func (h *TokenHandler) HandleToken(c *Ctx) error {
+	req, ok := h.decode(c)
+	if !ok {
+		return nil
+	}
+	user, err := h.Accounts.Login(c.Context, req.Email, req.Password)
+	if errors.Is(err, accounts.ErrInvalidCredentials) {
+		return c.Problem(c.NewProblem(http.StatusUnauthorized, "Invalid email or password."))
+	}
+	if err != nil {
+		return err
+	}
+	if ok, err := h.verifySecondFactor(c, user.ID, req.Code); !ok {
+		return err
+	}
+	pair, err := h.Tokens.IssueTokens(c.Context, &auth.Principal{ID: user.ID, Email: user.Email, Name: user.Name, Method: auth.METHOD_BEARER})
+	if err != nil {
+		return err
+	}
+	return h.tokens(c, pair)
+}
+
+// Exchange the refresh token for a new token pair
+func (h *TokenHandler) HandleRefresh(c *Ctx) error {
+	req, ok := h.decode(c)
+	if !ok {
+		return nil
+	}
+	pair, err := h.Tokens.RefreshTokens(c.Context, req.RefreshToken)
+	if errors.Is(err, jwt.ErrRefreshNotFound) || errors.Is(err, jwt.ErrRefreshReused) {
+		return c.Problem(c.NewProblem(http.StatusUnauthorized, "Refresh token is invalid or expired."))
+	}
+	if err != nil {
+		return err
+	}
+	return h.tokens(c, pair)
+}
+
+// Log the client out by revoking its refresh token family and the
+// access token the request was authenticated with
+func (h *TokenHandler) HandleRevoke(c *Ctx) error {
+	req, ok := h.decode(c)
+	if !ok {
+		return nil
+	}
+	if req.RefreshToken != "" {
+		if err := h.Tokens.RevokeRefreshToken(c.Context, req.RefreshToken); err != nil {
+			return err
+		}
+	}
+	if token, found := strings.CutPrefix(c.Request.Header.Get("Authorization"), "Bearer "); found {
+		if claims, err := h.Tokens.Verify(c.Context, token); err == nil {
+			if err := h.Tokens.RevokeAccessToken(c.Context, claims); err != nil {
+				return err
+			}
+		}
+	}
+	c.Response.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// Check the code of users with two-factor authentication, writing the
+// problem when it's missing or wrong
+func (h *TokenHandler) verifySecondFactor(c *Ctx, userID, code string) (bool, error) {
+	if h.MFA == nil {
+		return true, nil
+	}
+	enabled, err := h.MFA.Enabled(c.Context, userID)
+	if err != nil || !enabled {
+		return err == nil, err
+	}
+	if code == "" {
+		return false, c.Problem(c.NewProblem(http.StatusUnauthorized, "Two-factor authentication code is required."))
+	}
+	_, err = h.MFA.Verify(c.Context, userID, code)
+	switch {
+	case errors.Is(err, mfa.ErrInvalidCode):
+		return false, c.Problem(c.NewProblem(http.StatusUnauthorized, "Two-factor authentication code is invalid."))
+	case errors.Is(err, mfa.ErrTooManyAttempts):
+		return false, c.Problem(c.NewProblem(http.StatusTooManyRequests, "Too many invalid codes. Try again later."))
+	case err != nil:
+		return false, err
+	}
+	return true, nil
+}
+
+func (h *TokenHandler) decode(c *Ctx) (*TokenRequest, bool) {
+	mediaType, _, _ := mime.ParseMediaType(c.Request.Header.Get("Content-Type"))
+	if mediaType != "application/json" {
+		c.Problem(c.NewProblem(http.StatusUnsupportedMediaType, "Request body must be JSON."))
+		return nil, false
+	}
+	req := &TokenRequest{}
+	if err := json.NewDecoder(http.MaxBytesReader(c.Response, c.Request.Body, 1<<16)).Decode(req); err != nil {
+		c.Problem(c.NewProblem(http.StatusBadRequest, "Request body is not valid JSON."))
+		return nil, false
+	}
+	return req, true
+}
+
+// Token responses must never be cached (RFC 6749 5.1)
+func (h *TokenHandler) tokens(c *Ctx, pair *jwt.TokenPair) error {
+	c.Response.Header().Set("Cache-Control", "no-store")
+	return c.JSON(http.StatusOK, pair)
+}
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mcgtrt/go-puerto/internal/accounts"
+	"github.com/mcgtrt/go-puerto/internal/jwt"
+	"github.com/mcgtrt/go-puerto/internal/mfa"
+	"github.com/mcgtrt/go-puerto/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenHandler(t *testing.T) {
+	service := accounts.NewService(accounts.NewMemoryStore())
+	service.HashParams = accounts.HashParams{Memory: 1024, Time: 1, Threads: 1, SaltLen: 16, KeyLen: 32}
+	user, _, err := service.Register(context.Background(), "john@example.com", "John", "Secret1!")
+	assert.NoError(t, err)
+
+	key, _ := jwt.GenerateKey(jwt.ALG_ES256)
+	ring, _ := jwt.NewKeyring(key)
+	store := jwt.NewMemoryStore()
+	tokens := jwt.NewService(ring, &utils.JWTConfig{
+		Issuer:     "https://example.com",
+		Audience:   []string{"https://example.com"},
+		AccessTTL:  15 * time.Minute,
+		RefreshTTL: time.Hour,
+		ClockSkew:  time.Minute,
+	}, store, store)
+	h := NewTokenHandler(tokens, service)
+
+	post := func(fn func(*Ctx) error, body string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/auth/", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		rec := httptest.NewRecorder()
+		assert.NoError(t, fn(NewCtx(rec, req)))
+		return rec
+	}
+	decode := func(rec *httptest.ResponseRecorder) jwt.TokenPair {
+		var pair jwt.TokenPair
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &pair))
+		return pair
+	}
+
+	t.Run("JWKS", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		assert.NoError(t, h.HandleJWKS(NewCtx(rec, httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "application/jwk-set+json", rec.Header().Get("Content-Type"))
+
+		var set jwt.JWKS
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &set))
+		assert.Len(t, set.Keys, 1)
+		assert.Equal(t, key.ID, set.Keys[0].KeyID)
+		assert.Equal(t, jwt.ALG_ES256, set.Keys[0].Algorithm)
+	})
+
+	t.Run("Invalid login", func(t *testing.T) {
+		rec := post(h.HandleToken, `{"email":"john@example.com","password":"wrong"}`, nil)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.Equal(t, "application/problem+json", rec.Header().Get("Content-Type"))
+	})
+
+	t.Run("Only JSON accepted", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/api/auth/token", strings.NewReader("email=john@example.com"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		assert.NoError(t, h.HandleToken(NewCtx(rec, req)))
+		assert.Equal(t, http.StatusUnsupportedMediaType, rec.Code)
+	})
+
+	t.Run("Login, refresh and revoke", func(t *testing.T) {
+		rec := post(h.HandleToken, `{"email":"john@example.com","password":"Secret1!"}`, nil)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "no-store", rec.Header().Get("Cache-Control"))
+		pair := decode(rec)
+		p, err := tokens.Authenticate(context.Background(), pair.AccessToken)
+		assert.NoError(t, err)
+		assert.NotEmpty(t, p.ID)
+
+		rec = post(h.HandleRefresh, `{"refresh_token":"`+pair.RefreshToken+`"}`, nil)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		next := decode(rec)
+
+		rec = post(h.HandleRevoke, `{"refresh_token":"`+next.RefreshToken+`"}`, http.Header{"Authorization": {"Bearer " + next.AccessToken}})
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+		_, err = tokens.Verify(context.Background(), next.AccessToken)
+		assert.ErrorIs(t, err, jwt.ErrRevoked)
+
+		rec = post(h.HandleRefresh, `{"refresh_token":"`+next.RefreshToken+`"}`, nil)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	t.Run("Two-factor code required", func(t *testing.T) {
+		t.Setenv(utils.AES_SECRET, "0123456789abcdef0123456789abcdef")
+		h.MFA = mfa.NewService(mfa.NewMemoryStore(), &utils.MFAConfig{Issuer: "example.com"})
+		defer func() { h.MFA = nil }()
+		enrollment, err := h.MFA.BeginTOTP(context.Background(), user.ID, user.Email)
+		assert.NoError(t, err)
+		code, _ := mfa.GenerateCode(enrollment.Secret, mfa.TimeStep(time.Now()))
+		_, err = h.MFA.ConfirmTOTP(context.Background(), user.ID, code)
+		assert.NoError(t, err)
+
+		rec := post(h.HandleToken, `{"email":"john@example.com","password":"Secret1!"}`, nil)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.Contains(t, rec.Body.String(), "code is required")
+
+		rec = post(h.HandleToken, `{"email":"john@example.com","password":"Secret1!","code":"000000"}`, nil)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+		code, _ = mfa.GenerateCode(enrollment.Secret, mfa.TimeStep(time.Now())+1)
+		rec = post(h.HandleToken, `{"email":"john@example.com","password":"Secret1!","code":"`+code+`"}`, nil)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.NotEmpty(t, decode(rec).AccessToken)
+	})
+}
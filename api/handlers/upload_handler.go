@@ -0,0 +1,236 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mcgtrt/go-puerto/internal/uploads"
+	"github.com/mcgtrt/go-puerto/templates/pages"
+	"github.com/mcgtrt/go-puerto/utils"
+)
+
+// Path of the resumable upload endpoints, an upload is continued at
+// RESUMABLE_PATH + "/" + its ID
+const RESUMABLE_PATH = "/uploads/resumable"
+
+// Uploads of logged in users and the signed download links of the stored
+// files. Resumable uploads are started with the file name and size:
+//
+//	POST /uploads/resumable {"filename": "video.mp4", "size": 73400320}
+//
+// and continued at the returned Location with PATCH requests carrying the
+// next chunk and its position in the Upload-Offset header. HEAD tells the
+// offset to resume from after a failure.
+type UploadHandler struct {
+	Uploads *uploads.Service
+}
+
+func NewUploadHandler(service *uploads.Service) *UploadHandler {
+	return &UploadHandler{Uploads: service}
+}
+
+func (h *UploadHandler) HandleUploadPage(c *Ctx) error {
+	return h.renderPage(c, nil)
+}
+
+// Store the files of the multipart form. HTMX requests get the uploaded
+// files list items, JSON clients the files with their download links.
+func (h *UploadHandler) HandleUpload(c *Ctx) error {
+	form, err := c.Upload(h.Uploads)
+	if err != nil {
+		return h.error(c, err)
+	}
+	var objects []*uploads.Object
+	for _, files := range form.Files {
+		objects = append(objects, files...)
+	}
+	c.Logger().Info("files uploaded", "count", len(objects))
+	if c.WantsJSON() {
+		res := make([]uploadResponse, len(objects))
+		for i, o := range objects {
+			res[i] = uploadResponse{Object: o, URL: h.Uploads.URL(o.Key)}
+		}
+		return c.JSON(http.StatusCreated, res)
+	}
+	files := make([]pages.UploadedFile, len(objects))
+	for i, o := range objects {
+		files[i] = h.file(o)
+	}
+	if c.Request.Header.Get("HX-Request") == "true" {
+		return c.Render(pages.UploadedFiles(files))
+	}
+	return h.renderPage(c, files)
+}
+
+// Stored file with its download link
+type uploadResponse struct {
+	*uploads.Object
+	URL string `json:"url"`
+}
+
+type startUploadRequest struct {
+	Filename string `json:"filename"`
+	Size     int64  `json:"size"`
+}
+
+func (h *UploadHandler) HandleStartResumable(c *Ctx) error {
+	var req startUploadRequest
+	if err := json.NewDecoder(http.MaxBytesReader(c.Response, c.Request.Body, 1<<16)).Decode(&req); err != nil {
+		return c.Problem(c.NewProblem(http.StatusBadRequest, "Send the filename and size of the file."))
+	}
+	u, err := h.Uploads.StartUpload(c.Context, c.User().ID, req.Filename, req.Size)
+	if err != nil {
+		return h.error(c, err)
+	}
+	c.Response.Header().Set("Location", RESUMABLE_PATH+"/"+u.ID)
+	c.Response.Header().Set("Upload-Chunk-Size", strconv.FormatInt(h.Uploads.ChunkSize, 10))
+	return c.JSON(http.StatusCreated, u)
+}
+
+// Offset to resume the upload from
+func (h *UploadHandler) HandleResumableStatus(c *Ctx) error {
+	u, err := h.Uploads.GetUpload(c.Context, chi.URLParam(c.Request, "id"), c.User().ID)
+	if err != nil {
+		return h.error(c, err)
+	}
+	c.Response.Header().Set("Cache-Control", "no-store")
+	c.Response.Header().Set("Upload-Length", strconv.FormatInt(u.Size, 10))
+	setUploadOffset(c, u)
+	c.Response.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// Append the chunk of the request body. Responds 204 with the new offset,
+// and 201 with the stored file once the last chunk arrived.
+func (h *UploadHandler) HandleChunk(c *Ctx) error {
+	offset, err := strconv.ParseInt(c.Request.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		return c.Problem(c.NewProblem(http.StatusBadRequest, "Upload-Offset header is required."))
+	}
+	u, o, err := h.Uploads.WriteChunk(c.Context, chi.URLParam(c.Request, "id"), c.User().ID, offset, c.Request.Body)
+	if u != nil {
+		setUploadOffset(c, u)
+	}
+	if err != nil {
+		return h.error(c, err)
+	}
+	if o == nil {
+		c.Response.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	c.Logger().Info("resumable upload finished", "upload_id", u.ID, "size", o.Size)
+	if c.WantsJSON() {
+		return c.JSON(http.StatusCreated, uploadResponse{Object: o, URL: h.Uploads.URL(o.Key)})
+	}
+	c.Response.WriteHeader(http.StatusCreated)
+	return c.Render(pages.UploadedFiles([]pages.UploadedFile{h.file(o)}))
+}
+
+func (h *UploadHandler) HandleAbort(c *Ctx) error {
+	if err := h.Uploads.AbortUpload(c.Context, chi.URLParam(c.Request, "id"), c.User().ID); err != nil {
+		return h.error(c, err)
+	}
+	c.Response.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// Serve the file of a signed download link. Files are sandboxed and only
+// images are shown inline, so uploaded HTML or SVG can't run scripts on
+// the origin of the app.
+func (h *UploadHandler) HandleDownload(c *Ctx) error {
+	key := chi.URLParam(c.Request, "*")
+	expires, err := h.Uploads.Verify(key, c.Request.URL.Query())
+	if err != nil {
+		return c.Problem(c.NewProblem(http.StatusForbidden, "Download link is invalid or expired."))
+	}
+	r, o, err := h.Uploads.Store.Get(c.Context, key)
+	if errors.Is(err, uploads.ErrBlobNotFound) {
+		return c.Problem(c.NewProblem(http.StatusNotFound, "File not found."))
+	}
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	disposition := "attachment"
+	if strings.HasPrefix(o.ContentType, "image/") && o.ContentType != "image/svg+xml" {
+		disposition = "inline"
+	}
+	if o.Filename != "" {
+		disposition = mime.FormatMediaType(disposition, map[string]string{"filename": o.Filename})
+	}
+	header := c.Response.Header()
+	header.Set("Content-Type", o.ContentType)
+	header.Set("Content-Disposition", disposition)
+	header.Set("Content-Security-Policy", "default-src 'none'; sandbox")
+	header.Set("X-Content-Type-Options", "nosniff")
+	// Not kept past the expiry of the link
+	header.Set("Cache-Control", "private, max-age="+strconv.Itoa(int(time.Until(expires).Seconds())))
+
+	return serveBlob(c, r, o)
+}
+
+// Write the blob with the headers set before. Range and conditional
+// requests are supported when the store can seek.
+func serveBlob(c *Ctx, r io.Reader, o *uploads.Object) error {
+	if rs, ok := r.(io.ReadSeeker); ok {
+		http.ServeContent(c.Response, c.Request, "", o.CreatedAt, rs)
+		return nil
+	}
+	c.Response.Header().Set("Content-Length", strconv.FormatInt(o.Size, 10))
+	c.Response.WriteHeader(http.StatusOK)
+	if c.Request.Method == http.MethodHead {
+		return nil
+	}
+	_, err := io.Copy(c.Response, r)
+	return err
+}
+
+func (h *UploadHandler) file(o *uploads.Object) pages.UploadedFile {
+	return pages.UploadedFile{
+		Filename:    o.Filename,
+		ContentType: o.ContentType,
+		Size:        o.Size,
+		URL:         h.Uploads.URL(o.Key),
+	}
+}
+
+func (h *UploadHandler) renderPage(c *Ctx, files []pages.UploadedFile) error {
+	lang, _ := utils.GetLocale(c.Context)
+	return c.Render(pages.UploadsPage(lang, pages.UploadsView{
+		Files:   files,
+		Accept:  strings.Join(h.Uploads.AllowedTypes, ","),
+		MaxSize: h.Uploads.MaxSize,
+	}))
+}
+
+func (h *UploadHandler) error(c *Ctx, err error) error {
+	switch {
+	case errors.Is(err, uploads.ErrTooLarge):
+		return c.Problem(c.NewProblem(http.StatusRequestEntityTooLarge, "File is too large, the limit is "+strconv.FormatInt(h.Uploads.MaxSize, 10)+" bytes."))
+	case errors.Is(err, uploads.ErrTooManyFiles):
+		return c.Problem(c.NewProblem(http.StatusRequestEntityTooLarge, "Send at most "+strconv.Itoa(uploads.MAX_FILES)+" files at once."))
+	case errors.Is(err, uploads.ErrTypeNotAllowed):
+		return c.Problem(c.NewProblem(http.StatusUnsupportedMediaType, "This type of file is not allowed."))
+	case errors.Is(err, uploads.ErrEmptyFile):
+		return c.Problem(c.NewProblem(http.StatusBadRequest, "File is empty."))
+	case errors.Is(err, http.ErrNotMultipart), errors.Is(err, http.ErrMissingBoundary):
+		return c.Problem(c.NewProblem(http.StatusBadRequest, "Send the files as a multipart form."))
+	case errors.Is(err, uploads.ErrUploadNotFound):
+		return c.Problem(c.NewProblem(http.StatusNotFound, "Upload not found."))
+	case errors.Is(err, uploads.ErrOffsetMismatch):
+		return c.Problem(c.NewProblem(http.StatusConflict, "Resume the upload from the Upload-Offset."))
+	}
+	return err
+}
+
+func setUploadOffset(c *Ctx, u *uploads.Upload) {
+	c.Response.Header().Set("Upload-Offset", strconv.FormatInt(u.Offset, 10))
+}
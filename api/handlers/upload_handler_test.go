@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mcgtrt/go-puerto/internal/auth"
+	"github.com/mcgtrt/go-puerto/internal/uploads"
+	"github.com/mcgtrt/go-puerto/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUploadHandler(t *testing.T) {
+	service := uploads.NewService(uploads.NewMemoryStore(), &utils.UploadsConfig{
+		MaxSize:      1024,
+		ChunkSize:    16,
+		AllowedTypes: []string{"image/png"},
+		URLTTL:       time.Hour,
+	}, []byte("secret"))
+	h := NewUploadHandler(service)
+	png := append([]byte("\x89PNG\r\n\x1a\n"), make([]byte, 32)...)
+
+	r := chi.NewRouter()
+	route := func(fn func(*Ctx) error) http.HandlerFunc {
+		return func(w http.ResponseWriter, req *http.Request) {
+			req = req.WithContext(auth.WithPrincipal(req.Context(), &auth.Principal{ID: req.Header.Get("X-User")}))
+			assert.NoError(t, fn(NewCtx(w, req)))
+		}
+	}
+	r.Post("/uploads", route(h.HandleUpload))
+	r.Post(RESUMABLE_PATH, route(h.HandleStartResumable))
+	r.Head(RESUMABLE_PATH+"/{id}", route(h.HandleResumableStatus))
+	r.Patch(RESUMABLE_PATH+"/{id}", route(h.HandleChunk))
+	r.Delete(RESUMABLE_PATH+"/{id}", route(h.HandleAbort))
+	r.Get("/files/*", route(h.HandleDownload))
+
+	do := func(req *http.Request) *httptest.ResponseRecorder {
+		if req.Header.Get("X-User") == "" {
+			req.Header.Set("X-User", "1")
+		}
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec
+	}
+	upload := func(filename string, content []byte, header http.Header) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		w := multipart.NewWriter(&body)
+		part, _ := w.CreateFormFile("files", filename)
+		part.Write(content)
+		w.Close()
+		req := httptest.NewRequest(http.MethodPost, "/uploads", &body)
+		for key, values := range header {
+			req.Header[key] = values
+		}
+		req.Header.Set("Content-Type", w.FormDataContentType())
+		return do(req)
+	}
+
+	t.Run("Uploads and downloads files", func(t *testing.T) {
+		rec := upload("cat.png", png, http.Header{"Accept": {"application/json"}})
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		var files []struct {
+			Key         string `json:"key"`
+			ContentType string `json:"content_type"`
+			URL         string `json:"url"`
+		}
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &files))
+		assert.Len(t, files, 1)
+		assert.Equal(t, "image/png", files[0].ContentType)
+
+		rec = do(httptest.NewRequest(http.MethodGet, files[0].URL, nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, png, rec.Body.Bytes())
+		assert.Equal(t, "image/png", rec.Header().Get("Content-Type"))
+		assert.Equal(t, `inline; filename=cat.png`, rec.Header().Get("Content-Disposition"))
+		assert.Equal(t, "nosniff", rec.Header().Get("X-Content-Type-Options"))
+
+		rec = do(httptest.NewRequest(http.MethodGet, "/files/"+files[0].Key, nil))
+		assert.Equal(t, http.StatusForbidden, rec.Code, "Expected unsigned links refused")
+	})
+
+	t.Run("Renders the files for HTMX", func(t *testing.T) {
+		rec := upload("cat.png", png, http.Header{"Hx-Request": {"true"}})
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), "<li>")
+		assert.Contains(t, rec.Body.String(), "cat.png")
+		assert.NotContains(t, rec.Body.String(), "<html")
+	})
+
+	t.Run("Refuses files", func(t *testing.T) {
+		rec := upload("cat.png", []byte("GIF89a not a png"), nil)
+		assert.Equal(t, http.StatusUnsupportedMediaType, rec.Code)
+		rec = upload("cat.png", append(png, make([]byte, 1024)...), nil)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+		rec = do(httptest.NewRequest(http.MethodPost, "/uploads", strings.NewReader("a=b")))
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("Resumes uploads", func(t *testing.T) {
+		rec := do(httptest.NewRequest(http.MethodPost, RESUMABLE_PATH, strings.NewReader(`{"filename":"cat.png","size":40}`)))
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		location := rec.Header().Get("Location")
+		assert.Equal(t, "16", rec.Header().Get("Upload-Chunk-Size"))
+
+		chunk := func(offset int, data []byte) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(http.MethodPatch, location, bytes.NewReader(data))
+			req.Header.Set("Upload-Offset", strconv.Itoa(offset))
+			return do(req)
+		}
+		rec = chunk(0, png[:16])
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+		assert.Equal(t, "16", rec.Header().Get("Upload-Offset"))
+
+		rec = chunk(0, png[:16])
+		assert.Equal(t, http.StatusConflict, rec.Code)
+		assert.Equal(t, "16", rec.Header().Get("Upload-Offset"), "Expected the offset to resume from")
+
+		req := httptest.NewRequest(http.MethodHead, location, nil)
+		req.Header.Set("X-User", "2")
+		assert.Equal(t, http.StatusNotFound, do(req).Code, "Expected uploads of others hidden")
+		rec = do(httptest.NewRequest(http.MethodHead, location, nil))
+		assert.Equal(t, "16", rec.Header().Get("Upload-Offset"))
+		assert.Equal(t, "40", rec.Header().Get("Upload-Length"))
+
+		chunk(16, png[16:32])
+		rec = chunk(32, png[32:])
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		assert.Contains(t, rec.Body.String(), "cat.png")
+	})
+
+	t.Run("Aborts uploads", func(t *testing.T) {
+		rec := do(httptest.NewRequest(http.MethodPost, RESUMABLE_PATH, strings.NewReader(`{"filename":"cat.png","size":40}`)))
+		location := rec.Header().Get("Location")
+
+		assert.Equal(t, http.StatusNoContent, do(httptest.NewRequest(http.MethodDelete, location, nil)).Code)
+		assert.Equal(t, http.StatusNotFound, do(httptest.NewRequest(http.MethodHead, location, nil)).Code)
+	})
+}
@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/mcgtrt/go-puerto/internal/ws"
+)
+
+// Connects browsers to the hub. Clients join the rooms of the room query
+// parameters, e.g. with the HTMX ws extension:
+//
+//	<div hx-ext="ws" ws-connect="/ws?room=orders:42">
+//
+// and subscribe to more rooms by sending control messages.
+type WebSocketHandler struct {
+	Hub *ws.Hub
+}
+
+func NewWebSocketHandler(hub *ws.Hub) *WebSocketHandler {
+	return &WebSocketHandler{Hub: hub}
+}
+
+func (h *WebSocketHandler) HandleConnect(c *Ctx) error {
+	rooms := c.Request.URL.Query()["room"]
+	// Checked before the upgrade, so the client gets a plain 403
+	for _, room := range rooms {
+		if !h.Hub.Allowed(c.Context, room) {
+			return c.Problem(c.NewProblem(http.StatusForbidden, ws.ErrForbiddenRoom.Error()))
+		}
+	}
+	client, err := c.WebSocket(h.Hub)
+	if err != nil {
+		c.Logger().Warn("websocket handshake failed", "error", err)
+		return nil
+	}
+	for _, room := range rooms {
+		client.Join(room)
+	}
+	return client.Run()
+}
@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/mcgtrt/go-puerto/internal/auth"
+	"github.com/mcgtrt/go-puerto/internal/ws"
+	"github.com/mcgtrt/go-puerto/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebSocketHandler(t *testing.T) {
+	hub := ws.NewHub(nil, &utils.WebSocketConfig{MaxMessageSize: 1024, SendBuffer: 8, PingInterval: time.Minute})
+	hub.Allow("user:", func(ctx context.Context, room string) bool {
+		p := auth.FromContext(ctx)
+		return p != nil && room == "user:"+p.ID
+	})
+	h := NewWebSocketHandler(hub)
+	joined := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = r.WithContext(auth.WithPrincipal(r.Context(), &auth.Principal{ID: "1"}))
+		assert.NoError(t, h.HandleConnect(NewCtx(w, r)))
+	}))
+	defer srv.Close()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	_, res, err := websocket.Dial(ctx, url+"?room=user:2", nil)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusForbidden, res.StatusCode, "Expected other users rooms refused before the upgrade")
+
+	hub.OnMessage = func(c *ws.Client, m ws.Message) {
+		assert.Equal(t, []string{"user:1"}, c.Rooms())
+		close(joined)
+	}
+	conn, _, err := websocket.Dial(ctx, url+"?room=user:1", nil)
+	assert.NoError(t, err)
+	defer conn.CloseNow()
+	assert.NoError(t, conn.Write(ctx, websocket.MessageText, []byte("hello")))
+	<-joined
+	assert.NoError(t, hub.Broadcast(ctx, "user:1", ws.Text("update")))
+	_, data, err := conn.Read(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "update", string(data))
+	assert.NoError(t, conn.Close(websocket.StatusNormalClosure, ""))
+}
@@ -0,0 +1,133 @@
+package middleware
+
+import (
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/mcgtrt/go-puerto/internal/logging"
+	"github.com/mcgtrt/go-puerto/types"
+	"github.com/mcgtrt/go-puerto/utils"
+)
+
+// Replacement value of redacted headers, query params and cookies
+const REDACTED = "[REDACTED]"
+
+// Log every request as a single structured record once the response was
+// written. Redacted header, query param and cookie values are never logged.
+// Successful requests are sampled with the configured rate while failures
+// and slow requests are always logged (slow ones as warnings).
+func AccessLogMiddleware(cfg *utils.LoggingConfig) func(http.Handler) http.Handler {
+	var (
+		headers = lowerSet(cfg.RedactHeaders)
+		query   = lowerSet(cfg.RedactQuery)
+		cookies = lowerSet(cfg.RedactCookies)
+	)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			// Scope is created before calling next so attributes added deeper
+			// in the chain (e.g. user ID) end up in the access log record
+			r = r.WithContext(logging.WithAttrs(r.Context()))
+			rw := newResponseWriter(w)
+
+			next.ServeHTTP(rw, r)
+
+			var (
+				duration = time.Since(start)
+				slow     = cfg.SlowRequest > 0 && duration >= cfg.SlowRequest
+				failed   = rw.status >= http.StatusBadRequest
+			)
+			if !failed && !slow && cfg.SampleRate < 1 && rand.Float64() >= cfg.SampleRate {
+				return
+			}
+
+			lang, _ := r.Context().Value(types.LanguageCtxKey{}).(string)
+			attrs := []any{
+				slog.String("method", r.Method),
+				slog.String("route", routePattern(r)),
+				slog.String("path", r.URL.Path),
+				slog.String("query", redactQuery(r, query)),
+				slog.Int("status", rw.status),
+				slog.Int("bytes", rw.bytes),
+				slog.Duration("duration", duration),
+				slog.String("client_ip", clientIP(r)),
+				slog.String("lang", lang),
+				slog.Group("headers", redactHeaders(r.Header, headers, cookies)...),
+			}
+
+			logger := logging.FromContext(r.Context())
+			switch {
+			case rw.status >= http.StatusInternalServerError:
+				logger.Error("request", attrs...)
+			case slow:
+				logger.Warn("slow request", attrs...)
+			default:
+				logger.Info("request", attrs...)
+			}
+		})
+	}
+}
+
+func redactHeaders(h http.Header, redact, cookies map[string]bool) []any {
+	attrs := make([]any, 0, len(h))
+	for name, values := range h {
+		key := strings.ToLower(name)
+		switch {
+		case key == "cookie" && !redact[key]:
+			attrs = append(attrs, slog.String(name, redactCookies(values, cookies)))
+		case redact[key]:
+			attrs = append(attrs, slog.String(name, REDACTED))
+		default:
+			attrs = append(attrs, slog.String(name, strings.Join(values, ", ")))
+		}
+	}
+	return attrs
+}
+
+func redactCookies(values []string, redact map[string]bool) string {
+	var parts []string
+	for _, c := range (&http.Request{Header: http.Header{"Cookie": values}}).Cookies() {
+		value := c.Value
+		if redact[strings.ToLower(c.Name)] {
+			value = REDACTED
+		}
+		parts = append(parts, c.Name+"="+value)
+	}
+	return strings.Join(parts, "; ")
+}
+
+func redactQuery(r *http.Request, redact map[string]bool) string {
+	if r.URL.RawQuery == "" {
+		return ""
+	}
+	values := r.URL.Query()
+	for key := range values {
+		if redact[strings.ToLower(key)] {
+			values[key] = []string{REDACTED}
+		}
+	}
+	return strings.ReplaceAll(values.Encode(), url.QueryEscape(REDACTED), REDACTED)
+}
+
+// Client IP is taken from the connection. Put a trusted proxy
+// middleware (e.g. chi's RealIP) in front if running behind one.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func lowerSet(values []string) map[string]bool {
+	set := make(map[string]bool, len(values))
+	for _, v := range values {
+		set[strings.ToLower(v)] = true
+	}
+	return set
+}
@@ -0,0 +1,98 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mcgtrt/go-puerto/internal/logging"
+	"github.com/mcgtrt/go-puerto/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccessLogMiddleware(t *testing.T) {
+	// Capture log output
+	var logOutput bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(logging.New(&utils.LoggingConfig{Format: utils.LOG_FORMAT_JSON}, &logOutput))
+
+	cfg := &utils.LoggingConfig{
+		RedactHeaders: utils.DEFAULT_LOG_REDACT_HEADERS,
+		RedactQuery:   utils.DEFAULT_LOG_REDACT_QUERY,
+		RedactCookies: utils.DEFAULT_LOG_REDACT_COOKIES,
+		SampleRate:    1,
+		SlowRequest:   50 * time.Millisecond,
+	}
+	r := chi.NewRouter()
+	r.Use(AccessLogMiddleware(cfg))
+	r.Get("/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		logging.WithAttrs(r.Context(), slog.String("user_id", chi.URLParam(r, "id")))
+		w.Write([]byte("OK"))
+	})
+	r.Get("/slow", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(60 * time.Millisecond)
+	})
+
+	t.Run("Logs request with redacted values", func(t *testing.T) {
+		logOutput.Reset()
+		req := httptest.NewRequest(http.MethodGet, "/users/7?token=secret&page=2", nil)
+		req.Header.Set("Authorization", "Bearer token")
+		req.Header.Set("Content-Type", "application/json")
+		req.AddCookie(&http.Cookie{Name: "session", Value: "abc"})
+		req.AddCookie(&http.Cookie{Name: "theme", Value: "dark"})
+		rec := httptest.NewRecorder()
+
+		r.ServeHTTP(rec, req)
+
+		record := decodeRecord(t, logOutput.String())
+		assert.Equal(t, "INFO", record["level"])
+		assert.Equal(t, "/users/{id}", record["route"])
+		assert.Equal(t, float64(http.StatusOK), record["status"])
+		assert.Equal(t, float64(2), record["bytes"])
+		assert.Equal(t, "7", record["user_id"], "Expected attributes added by handlers")
+		assert.Equal(t, "page=2&token="+REDACTED, record["query"])
+
+		headers := record["headers"].(map[string]any)
+		assert.Equal(t, REDACTED, headers["Authorization"])
+		assert.Equal(t, "application/json", headers["Content-Type"])
+		assert.NotContains(t, logOutput.String(), "Bearer token")
+		assert.NotContains(t, logOutput.String(), "abc")
+	})
+
+	t.Run("Logs slow requests as warnings", func(t *testing.T) {
+		logOutput.Reset()
+		req := httptest.NewRequest(http.MethodGet, "/slow", nil)
+		r.ServeHTTP(httptest.NewRecorder(), req)
+
+		record := decodeRecord(t, logOutput.String())
+		assert.Equal(t, "WARN", record["level"])
+		assert.Equal(t, "slow request", record["msg"])
+	})
+
+	t.Run("Samples only successful requests", func(t *testing.T) {
+		cfg.SampleRate = 0
+		defer func() { cfg.SampleRate = 1 }()
+
+		logOutput.Reset()
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users/1", nil))
+		assert.Empty(t, logOutput.String(), "Expected successful request to be sampled out")
+
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/missing", nil))
+		record := decodeRecord(t, logOutput.String())
+		assert.Equal(t, float64(http.StatusNotFound), record["status"], "Expected failed request to be logged")
+	})
+}
+
+func decodeRecord(t *testing.T, output string) map[string]any {
+	var record map[string]any
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	assert.Len(t, lines, 1, "Expected exactly one log record")
+	assert.NoError(t, json.Unmarshal([]byte(lines[0]), &record), "Expected JSON log record")
+	return record
+}
@@ -0,0 +1,78 @@
+package middleware
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+
+	"github.com/mcgtrt/go-puerto/api/handlers"
+	"github.com/mcgtrt/go-puerto/internal/auth"
+	"github.com/mcgtrt/go-puerto/internal/logging"
+)
+
+// Page browsers are sent to by RequireAuth
+const LOGIN_PATH = "/login"
+
+// Resolve the principal of the request with the first matching strategy
+// (e.g. session, bearer token, API key) and store it in the context (see
+// Ctx.User). Anonymous requests pass through, use RequireAuth to protect
+// routes. Invalid bearer tokens or API keys are rejected with 401.
+func AuthMiddleware(strategies ...auth.Strategy) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p, err := auth.Authenticate(r, strategies...)
+			if errors.Is(err, auth.ErrInvalidCredentials) {
+				unauthorized(handlers.NewCtx(w, r), "invalid credentials")
+				return
+			}
+			if err != nil {
+				logging.FromContext(r.Context()).Error("authentication failed", "error", err)
+				handlers.NewCtx(w, r).Error(http.StatusInternalServerError)
+				return
+			}
+			if p != nil {
+				ctx := logging.WithAttrs(r.Context(), slog.String("user_id", p.ID))
+				r = r.WithContext(auth.WithPrincipal(ctx, p))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Guard routes from anonymous requests. Browsers are redirected to the
+// login page (HTMX requests with HX-Redirect) and API clients receive
+// 401 problem+json.
+func RequireAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if auth.FromContext(r.Context()) != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		c := handlers.NewCtx(w, r)
+		if c.WantsJSON() || r.Header.Get("Authorization") != "" || r.Header.Get(auth.API_KEY_HEADER) != "" {
+			unauthorized(c, "")
+			return
+		}
+		c.Redirect(LOGIN_PATH + "?next=" + url.QueryEscape(returnPath(r)))
+	})
+}
+
+// Page to return to after login. HTMX requests come from the page
+// in HX-Current-URL rather than the fragment URL they ask for.
+func returnPath(r *http.Request) string {
+	if r.Header.Get("HX-Request") == "true" {
+		if u, err := url.Parse(r.Header.Get("HX-Current-URL")); err == nil && u.Path != "" {
+			return u.RequestURI()
+		}
+	}
+	if r.Method != http.MethodGet {
+		return "/"
+	}
+	return r.URL.RequestURI()
+}
+
+func unauthorized(c *handlers.Ctx, detail string) {
+	c.Response.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+	c.Problem(c.NewProblem(http.StatusUnauthorized, detail))
+}
@@ -0,0 +1,76 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mcgtrt/go-puerto/api/handlers"
+	"github.com/mcgtrt/go-puerto/internal/auth"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthMiddleware(t *testing.T) {
+	strategy := auth.StrategyFunc(func(r *http.Request) (*auth.Principal, error) {
+		switch r.Header.Get("Authorization") {
+		case "Bearer good":
+			return &auth.Principal{ID: "u1", Method: auth.METHOD_BEARER}, nil
+		case "Bearer bad":
+			return nil, auth.ErrInvalidCredentials
+		}
+		return nil, nil
+	})
+	protected := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handlers.NewCtx(w, r).Text(http.StatusOK, "hello "+handlers.NewCtx(w, r).User().ID)
+	})
+	handler := AuthMiddleware(strategy)(RequireAuth(protected))
+
+	t.Run("Authenticated request", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/orders", nil)
+		req.Header.Set("Authorization", "Bearer good")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "hello u1", rec.Body.String())
+	})
+
+	t.Run("Invalid credentials", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/orders", nil)
+		req.Header.Set("Authorization", "Bearer bad")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.Equal(t, "application/problem+json", rec.Header().Get("Content-Type"))
+		assert.Contains(t, rec.Header().Get("WWW-Authenticate"), "Bearer")
+	})
+
+	t.Run("Browser is redirected to login", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/orders?page=2", nil))
+
+		assert.Equal(t, http.StatusSeeOther, rec.Code)
+		assert.Equal(t, "/login?next=%2Forders%3Fpage%3D2", rec.Header().Get("Location"))
+	})
+
+	t.Run("HTMX request receives HX-Redirect", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/orders/list", nil)
+		req.Header.Set("HX-Request", "true")
+		req.Header.Set("HX-Current-URL", "https://example.com/orders")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		assert.Equal(t, "/login?next=%2Forders", rec.Header().Get("HX-Redirect"))
+	})
+
+	t.Run("API client receives problem", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/orders", nil)
+		req.Header.Set("Accept", "application/json")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.JSONEq(t, `{"title":"Unauthorized","status":401,"instance":"/orders"}`, rec.Body.String())
+	})
+}
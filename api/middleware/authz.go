@@ -0,0 +1,47 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/mcgtrt/go-puerto/api/handlers"
+	"github.com/mcgtrt/go-puerto/internal/auth"
+	"github.com/mcgtrt/go-puerto/internal/authz"
+	"github.com/mcgtrt/go-puerto/internal/logging"
+)
+
+// Bind the authorizer to the request so handlers (c.Can), templates
+// and Require can check permissions. Mount it after AuthMiddleware.
+func AuthorizationMiddleware(a *authz.Authorizer) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(authz.WithAuthorizer(r.Context(), a)))
+		})
+	}
+}
+
+// Allow only users holding all the permissions. Anonymous requests are
+// handled like in RequireAuth (login redirect or 401), authenticated
+// users without the permissions get 403. Use it in chi groups:
+//
+//	r.Group(func(r chi.Router) {
+//		r.Use(middleware.Require("orders:write"))
+//		...
+//	})
+func Require(permissions ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if auth.FromContext(r.Context()) == nil {
+				RequireAuth(next).ServeHTTP(w, r)
+				return
+			}
+			for _, permission := range permissions {
+				if !authz.Can(r.Context(), permission, nil) {
+					logging.FromContext(r.Context()).Warn("permission denied", "permission", permission)
+					handlers.NewCtx(w, r).Error(http.StatusForbidden)
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mcgtrt/go-puerto/api/handlers"
+	"github.com/mcgtrt/go-puerto/internal/auth"
+	"github.com/mcgtrt/go-puerto/internal/authz"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequire(t *testing.T) {
+	ctx := context.Background()
+	store := authz.NewMemoryStore()
+	assert.NoError(t, store.SaveRole(ctx, &authz.Role{Name: "clerk", Permissions: []string{"orders:*"}}))
+	assert.NoError(t, store.AssignRole(ctx, "clerk", "clerk"))
+
+	strategy := auth.StrategyFunc(func(r *http.Request) (*auth.Principal, error) {
+		if id := r.Header.Get("X-User"); id != "" {
+			return &auth.Principal{ID: id}, nil
+		}
+		return nil, nil
+	})
+	protected := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c := handlers.NewCtx(w, r)
+		c.Text(http.StatusOK, map[bool]string{true: "can delete", false: "can't delete"}[c.Can("users:delete", nil)])
+	})
+	handler := AuthMiddleware(strategy)(AuthorizationMiddleware(authz.NewAuthorizer(store))(Require("orders:read", "orders:write")(protected)))
+
+	serve := func(user string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/orders", nil)
+		if user != "" {
+			req.Header.Set("X-User", user)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := serve("clerk")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "can't delete", rec.Body.String())
+
+	rec = serve("customer")
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	rec = serve("")
+	assert.Equal(t, http.StatusSeeOther, rec.Code, "Expected anonymous user sent to login")
+	assert.Equal(t, "/login?next=%2Forders", rec.Header().Get("Location"))
+}
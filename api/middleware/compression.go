@@ -0,0 +1,309 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Supported content encodings in the order of preference used
+// when the client accepts several of them with the same q-value
+const (
+	ENCODING_BROTLI = "br"
+	ENCODING_ZSTD   = "zstd"
+	ENCODING_GZIP   = "gzip"
+)
+
+var supportedEncodings = []string{ENCODING_BROTLI, ENCODING_ZSTD, ENCODING_GZIP}
+
+var (
+	DEFAULT_COMPRESSION_MIN_SIZE      = 1024
+	DEFAULT_COMPRESSION_CONTENT_TYPES = []string{
+		"text/html",
+		"text/css",
+		"text/plain",
+		"text/javascript",
+		"text/xml",
+		"application/javascript",
+		"application/json",
+		"application/problem+json",
+		"application/xml",
+		"image/svg+xml",
+	}
+)
+
+// Streaming encoder shared by all supported encodings
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(io.Writer)
+}
+
+// Encoders are expensive to allocate, so they are pooled per encoding
+var encoderPools = map[string]*sync.Pool{
+	ENCODING_BROTLI: {New: func() any {
+		return brotli.NewWriterLevel(io.Discard, brotli.DefaultCompression)
+	}},
+	ENCODING_ZSTD: {New: func() any {
+		enc, _ := zstd.NewWriter(io.Discard, zstd.WithEncoderLevel(zstd.SpeedDefault), zstd.WithEncoderConcurrency(1))
+		return enc
+	}},
+	ENCODING_GZIP: {New: func() any {
+		return gzip.NewWriter(io.Discard)
+	}},
+}
+
+// Compress responses with brotli, zstd or gzip chosen by the Accept-Encoding
+// q-values. Only responses of allowed content types bigger than the minimum
+// size are compressed. Compressed responses get an encoding specific ETag
+// (e.g. "abc-br") and the suffix is removed from If-None-Match before calling
+// the next handler, so it composes with ETagMiddleware mounted after it.
+func CompressionMiddleware(minSize int, contentTypes []string) func(http.Handler) http.Handler {
+	allowed := lowerSet(contentTypes)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			addVary(w.Header(), "Accept-Encoding")
+			encoding := NegotiateEncoding(r.Header.Get("Accept-Encoding"))
+			if encoding == "" || r.Method == http.MethodHead || r.Header.Get("Upgrade") != "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if match := r.Header.Get("If-None-Match"); match != "" {
+				r.Header.Set("If-None-Match", stripETagEncoding(match))
+			}
+
+			cw := &compressWriter{
+				ResponseWriter: w,
+				encoding:       encoding,
+				minSize:        minSize,
+				allowed:        allowed,
+				status:         http.StatusOK,
+			}
+			defer cw.close()
+			next.ServeHTTP(cw, r)
+		})
+	}
+}
+
+// Choose the best supported encoding from the Accept-Encoding header.
+// Returns an empty string when the response should not be compressed.
+func NegotiateEncoding(header string) string {
+	if accepted := AcceptedEncodings(header); len(accepted) > 0 {
+		return accepted[0]
+	}
+	return ""
+}
+
+// Returns supported encodings accepted by the client ordered from the most
+// preferred one. Encodings with the same q-value keep the server preference.
+func AcceptedEncodings(header string) []string {
+	if header == "" {
+		return nil
+	}
+	qvalues := make(map[string]float64)
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		qvalues[strings.ToLower(strings.TrimSpace(name))] = q
+	}
+
+	accepted := make([]string, 0, len(supportedEncodings))
+	for _, enc := range supportedEncodings {
+		q, ok := qvalues[enc]
+		if !ok {
+			q, ok = qvalues["*"]
+		}
+		if ok && q > 0 {
+			accepted = append(accepted, enc)
+		}
+	}
+	sort.SliceStable(accepted, func(i, j int) bool {
+		return qvalue(qvalues, accepted[i]) > qvalue(qvalues, accepted[j])
+	})
+	return accepted
+}
+
+func qvalue(qvalues map[string]float64, enc string) float64 {
+	if q, ok := qvalues[enc]; ok {
+		return q
+	}
+	return qvalues["*"]
+}
+
+// Buffers the beginning of the response until it's known whether it's worth
+// compressing (minimum size and content type), then streams through the
+// pooled encoder or directly to the client
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	minSize  int
+	allowed  map[string]bool
+
+	status      int
+	wroteHeader bool
+	decided     bool
+	buf         []byte
+	enc         encoder
+}
+
+func (w *compressWriter) WriteHeader(code int) {
+	// Informational responses are passed through without affecting the final one
+	if code >= 100 && code < 200 && code != http.StatusSwitchingProtocols {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if w.wroteHeader {
+		return
+	}
+	w.status = code
+	w.wroteHeader = true
+	if !bodyAllowed(code) {
+		w.decide(false)
+	}
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	if w.decided {
+		if w.enc != nil {
+			return w.enc.Write(b)
+		}
+		return w.ResponseWriter.Write(b)
+	}
+	w.buf = append(w.buf, b...)
+	if len(w.buf) >= w.minSize {
+		if err := w.decide(false); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// Flushing commits the response even below the minimum size, so streamed
+// responses of allowed types are compressed from the first chunk
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		w.decide(true)
+	}
+	if w.enc != nil {
+		w.enc.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *compressWriter) decide(streaming bool) error {
+	w.decided = true
+	h := w.Header()
+	if w.shouldCompress(streaming) {
+		h.Set("Content-Encoding", w.encoding)
+		h.Del("Content-Length")
+		if etag := h.Get("ETag"); etag != "" {
+			h.Set("ETag", addETagEncoding(etag, w.encoding))
+		}
+		w.enc = encoderPools[w.encoding].Get().(encoder)
+		w.enc.Reset(w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	if len(w.buf) == 0 {
+		return nil
+	}
+	var err error
+	if w.enc != nil {
+		_, err = w.enc.Write(w.buf)
+	} else {
+		_, err = w.ResponseWriter.Write(w.buf)
+	}
+	w.buf = nil
+	return err
+}
+
+func (w *compressWriter) shouldCompress(streaming bool) bool {
+	h := w.Header()
+	if !bodyAllowed(w.status) || w.status == http.StatusPartialContent {
+		return false
+	}
+	if h.Get("Content-Encoding") != "" || h.Get("Content-Range") != "" {
+		return false
+	}
+	if !streaming && len(w.buf) < w.minSize {
+		return false
+	}
+	contentType := h.Get("Content-Type")
+	if contentType == "" {
+		// Set sniffed type now, as the compressed body can't be sniffed anymore
+		contentType = http.DetectContentType(w.buf)
+		h.Set("Content-Type", contentType)
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && w.allowed[mediaType]
+}
+
+func (w *compressWriter) close() {
+	if !w.wroteHeader {
+		return
+	}
+	if !w.decided {
+		w.decide(false)
+	}
+	if w.enc != nil {
+		w.enc.Close()
+		w.enc.Reset(io.Discard)
+		encoderPools[w.encoding].Put(w.enc)
+		w.enc = nil
+	}
+}
+
+// Add the request header to Vary unless it's listed already, e.g. by
+// PrecompressedMiddleware in front of the compression
+func addVary(h http.Header, name string) {
+	for _, v := range h.Values("Vary") {
+		for _, field := range strings.Split(v, ",") {
+			field = strings.TrimSpace(field)
+			if field == "*" || strings.EqualFold(field, name) {
+				return
+			}
+		}
+	}
+	h.Add("Vary", name)
+}
+
+func bodyAllowed(status int) bool {
+	return status != http.StatusNoContent && status != http.StatusNotModified && status >= 200
+}
+
+// Append encoding to the opaque part of the ETag: "abc" -> "abc-br"
+func addETagEncoding(etag, encoding string) string {
+	if !strings.HasSuffix(etag, `"`) {
+		return etag
+	}
+	return strings.TrimSuffix(etag, `"`) + "-" + encoding + `"`
+}
+
+// Remove encoding suffixes added by addETagEncoding from If-None-Match
+func stripETagEncoding(header string) string {
+	for _, enc := range supportedEncodings {
+		header = strings.ReplaceAll(header, "-"+enc+`"`, `"`)
+	}
+	return header
+}
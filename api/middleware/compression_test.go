@@ -0,0 +1,176 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		header   string
+		expected string
+	}{
+		{"", ""},
+		{"identity", ""},
+		{"gzip", ENCODING_GZIP},
+		{"gzip, deflate, br, zstd", ENCODING_BROTLI},
+		{"gzip;q=1.0, br;q=0.5", ENCODING_GZIP},
+		{"br;q=0, zstd;q=0.8, gzip;q=0.8", ENCODING_ZSTD},
+		{"*", ENCODING_BROTLI},
+		{"*;q=0.5, gzip", ENCODING_GZIP},
+		{"br;q=invalid, gzip;q=0.1", ENCODING_GZIP},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, NegotiateEncoding(tt.header), "Accept-Encoding: %s", tt.header)
+	}
+}
+
+func TestCompressionMiddleware(t *testing.T) {
+	large := strings.Repeat("<p>go-puerto</p>", 200)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/small":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write([]byte("<p>small</p>"))
+		case "/image":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte(large))
+		default:
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Header().Set("Content-Length", "3200")
+			w.Write([]byte(large[:1000]))
+			w.Write([]byte(large[1000:]))
+		}
+	})
+	compressed := CompressionMiddleware(DEFAULT_COMPRESSION_MIN_SIZE, DEFAULT_COMPRESSION_CONTENT_TYPES)(handler)
+
+	decoders := map[string]func(io.Reader) io.Reader{
+		ENCODING_GZIP: func(r io.Reader) io.Reader {
+			gz, err := gzip.NewReader(r)
+			assert.NoError(t, err)
+			return gz
+		},
+		ENCODING_BROTLI: func(r io.Reader) io.Reader { return brotli.NewReader(r) },
+		ENCODING_ZSTD: func(r io.Reader) io.Reader {
+			zr, err := zstd.NewReader(r)
+			assert.NoError(t, err)
+			return zr
+		},
+	}
+	for enc, decode := range decoders {
+		t.Run("Compresses with "+enc, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Accept-Encoding", enc)
+			rec := httptest.NewRecorder()
+
+			compressed.ServeHTTP(rec, req)
+
+			assert.Equal(t, enc, rec.Header().Get("Content-Encoding"))
+			assert.Equal(t, "Accept-Encoding", rec.Header().Get("Vary"))
+			assert.Empty(t, rec.Header().Get("Content-Length"), "Expected stale content length removed")
+			body, err := io.ReadAll(decode(rec.Body))
+			assert.NoError(t, err)
+			assert.Equal(t, large, string(body))
+		})
+	}
+
+	t.Run("Skips small responses", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/small", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		rec := httptest.NewRecorder()
+
+		compressed.ServeHTTP(rec, req)
+
+		assert.Empty(t, rec.Header().Get("Content-Encoding"))
+		assert.Equal(t, "<p>small</p>", rec.Body.String())
+	})
+
+	t.Run("Skips content types outside allow-list", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/image", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		rec := httptest.NewRecorder()
+
+		compressed.ServeHTTP(rec, req)
+
+		assert.Empty(t, rec.Header().Get("Content-Encoding"))
+		assert.Equal(t, large, rec.Body.String())
+	})
+
+	t.Run("Composes with ETag middleware", func(t *testing.T) {
+		chain := CompressionMiddleware(DEFAULT_COMPRESSION_MIN_SIZE, DEFAULT_COMPRESSION_CONTENT_TYPES)(ETagMiddleware(handler))
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Accept-Encoding", "br")
+		rec := httptest.NewRecorder()
+		chain.ServeHTTP(rec, req)
+		etag := rec.Header().Get("ETag")
+		assert.Equal(t, `W/"123456-br"`, etag, "Expected encoding specific ETag")
+
+		req = httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Accept-Encoding", "br")
+		req.Header.Set("If-None-Match", etag)
+		rec = httptest.NewRecorder()
+		chain.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusNotModified, rec.Code, "Expected revalidation with compressed ETag")
+		assert.Empty(t, rec.Body.String())
+	})
+}
+
+func TestPrecompressedMiddleware(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "app.css"), []byte("body{}"), 0644))
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write([]byte("body{}"))
+	w.Close()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "app.css.gz"), gz.Bytes(), 0644))
+
+	fsys := http.Dir(dir)
+	handler := PrecompressedMiddleware(fsys)(http.FileServer(fsys))
+
+	t.Run("Serves precompressed sibling", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/app.css", nil)
+		req.Header.Set("Accept-Encoding", "br, gzip")
+		rec := httptest.NewRecorder()
+
+		handler.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, ENCODING_GZIP, rec.Header().Get("Content-Encoding"))
+		assert.Equal(t, "text/css; charset=utf-8", rec.Header().Get("Content-Type"))
+		assert.Equal(t, gz.Bytes(), rec.Body.Bytes())
+	})
+
+	t.Run("Varies once behind compression", func(t *testing.T) {
+		handler := CompressionMiddleware(0, []string{"text/css"})(handler)
+		req := httptest.NewRequest(http.MethodGet, "/app.css", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		rec := httptest.NewRecorder()
+
+		handler.ServeHTTP(rec, req)
+
+		assert.Equal(t, []string{"Accept-Encoding"}, rec.Header().Values("Vary"))
+	})
+
+	t.Run("Falls back to original file", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/app.css", nil)
+		req.Header.Set("Accept-Encoding", "br")
+		rec := httptest.NewRecorder()
+
+		handler.ServeHTTP(rec, req)
+
+		assert.Empty(t, rec.Header().Get("Content-Encoding"))
+		assert.Equal(t, "body{}", rec.Body.String())
+	})
+}
@@ -0,0 +1,167 @@
+package middleware
+
+import (
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/mcgtrt/go-puerto/api/handlers"
+	"github.com/mcgtrt/go-puerto/internal"
+	"github.com/mcgtrt/go-puerto/internal/auth"
+	"github.com/mcgtrt/go-puerto/internal/csrf"
+	"github.com/mcgtrt/go-puerto/internal/logging"
+	"github.com/mcgtrt/go-puerto/utils"
+)
+
+// Message used when the current language has no "csrf.forbidden" translation
+const CSRF_FORBIDDEN_MESSAGE = "Your form has expired or was sent from another site. Please reload the page and try again."
+
+// Protect unsafe requests (POST, PUT, PATCH, DELETE) against cross-site
+// request forgery with signed double-submit tokens. Every response gets
+// the signed secret cookie and the request context a masked token, which
+// layout.Base renders into a meta tag and hx-headers, so HTMX requests
+// send it back in the X-CSRF-Token header. Classic forms render it with
+// layout.CSRFField. Unsafe requests must also come from the same origin
+// (Sec-Fetch-Site/Origin/Referer) or one of the trusted origins.
+//
+// Multipart bodies are not parsed in search of the token, so handlers can
+// stream them. Multipart forms send the header or, without JavaScript,
+// the token in the query of the action (layout.CSRFAction). Requests
+// authenticated with a bearer token or API key are not checked, browsers
+// never attach those on their own. Exempt paths (prefixes) are meant for
+// other API routes without cookies.
+// Mount it after MethodOverrideMiddleware so the overridden method is
+// checked and after AuthMiddleware so the principal is known.
+func CSRFMiddleware(secret []byte, secure bool, exemptPaths, trustedOrigins []string) func(http.Handler) http.Handler {
+	protector := csrf.New(secret)
+	trusted := lowerSet(trustedOrigins)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if isExempt(r.URL.Path, exemptPaths) || isMachineClient(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			var (
+				token []byte
+				valid bool
+			)
+			if cookie, err := r.Cookie(csrf.COOKIE_NAME); err == nil {
+				token, valid = protector.ParseCookie(cookie.Value)
+			}
+			if !isSafeMethod(r.Method) {
+				if reason := checkCSRF(r, token, valid, trusted); reason != "" {
+					logging.FromContext(r.Context()).Warn("csrf check failed", "reason", reason)
+					rejectCSRF(w, r)
+					return
+				}
+			}
+			if !valid {
+				var value string
+				var err error
+				if token, value, err = protector.NewCookie(); err != nil {
+					handlers.NewCtx(w, r).Error(http.StatusInternalServerError)
+					return
+				}
+				http.SetCookie(w, &http.Cookie{
+					Name:     csrf.COOKIE_NAME,
+					Value:    value,
+					Path:     "/",
+					HttpOnly: true,
+					Secure:   secure,
+					SameSite: http.SameSiteLaxMode,
+				})
+			}
+
+			ctx := csrf.WithToken(r.Context(), csrf.Mask(token))
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// Returns the reason of rejecting the request or empty string if it's allowed
+func checkCSRF(r *http.Request, token []byte, valid bool, trusted map[string]bool) string {
+	if !isSameOrigin(r, trusted) {
+		return "cross origin request"
+	}
+	if !valid {
+		return "missing or invalid cookie"
+	}
+	if !csrf.Verify(token, submittedCSRFToken(r)) {
+		return "missing or invalid token"
+	}
+	return ""
+}
+
+// Fetch metadata is trusted when present. Otherwise the Origin (or Referer)
+// must match the requested host. Requests without any of these headers
+// don't come from a browser and rely on the token alone.
+func isSameOrigin(r *http.Request, trusted map[string]bool) bool {
+	site := r.Header.Get("Sec-Fetch-Site")
+	if site == "same-origin" || site == "none" {
+		return true
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		if referer, err := url.Parse(r.Referer()); err == nil && referer.Host != "" {
+			origin = referer.Scheme + "://" + referer.Host
+		}
+	}
+	if origin == "" {
+		return site == ""
+	}
+	if trusted[strings.ToLower(origin)] {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && site == "" && strings.EqualFold(u.Host, r.Host)
+}
+
+func submittedCSRFToken(r *http.Request) string {
+	if token := r.Header.Get(csrf.HEADER_NAME); token != "" {
+		return token
+	}
+	switch mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType {
+	case "application/x-www-form-urlencoded":
+		return r.PostFormValue(csrf.FIELD_NAME)
+	case "multipart/form-data":
+		return r.URL.Query().Get(csrf.FIELD_NAME)
+	}
+	return ""
+}
+
+func rejectCSRF(w http.ResponseWriter, r *http.Request) {
+	ctx := handlers.NewCtx(w, r)
+	lang, _ := utils.GetLocale(r.Context())
+	message := internal.Localise(lang, "csrf.forbidden", CSRF_FORBIDDEN_MESSAGE)
+	if ctx.WantsJSON() {
+		ctx.Problem(ctx.NewProblem(http.StatusForbidden, message))
+		return
+	}
+	ctx.Text(http.StatusForbidden, message)
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+// Credentials of machine clients come in headers set explicitly by the
+// client, so the request can't be forged by another site
+func isMachineClient(r *http.Request) bool {
+	p := auth.FromContext(r.Context())
+	return p != nil && (p.Method == auth.METHOD_BEARER || p.Method == auth.METHOD_API_KEY)
+}
+
+func isExempt(path string, exemptPaths []string) bool {
+	for _, prefix := range exemptPaths {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,99 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/mcgtrt/go-puerto/internal/csrf"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCSRFMiddleware(t *testing.T) {
+	var token string
+	handler := CSRFMiddleware([]byte("0123456789abcdef0123456789abcdef"), true, []string{"/api/"}, []string{"https://admin.example.com"})(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token = csrf.Token(r.Context())
+			w.WriteHeader(http.StatusOK)
+		}),
+	)
+
+	// Visit a page to receive the cookie and the masked token
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.NotEmpty(t, token, "Expected token in context")
+	cookies := rec.Result().Cookies()
+	assert.Len(t, cookies, 1)
+	cookie := cookies[0]
+	assert.Equal(t, csrf.COOKIE_NAME, cookie.Name)
+	assert.True(t, cookie.HttpOnly)
+	assert.True(t, cookie.Secure)
+	assert.Equal(t, http.SameSiteLaxMode, cookie.SameSite)
+
+	post := func(body string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "http://example.com/orders", strings.NewReader(body))
+		req.AddCookie(cookie)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("Accepts token from header", func(t *testing.T) {
+		rec := post("", map[string]string{csrf.HEADER_NAME: token, "Sec-Fetch-Site": "same-origin"})
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Empty(t, rec.Result().Cookies(), "Expected valid cookie kept")
+	})
+
+	t.Run("Accepts token from form field", func(t *testing.T) {
+		form := url.Values{csrf.FIELD_NAME: {token}}.Encode()
+		rec := post(form, map[string]string{"Content-Type": "application/x-www-form-urlencoded", "Origin": "http://example.com"})
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+
+	t.Run("Accepts trusted origin", func(t *testing.T) {
+		rec := post("", map[string]string{csrf.HEADER_NAME: token, "Sec-Fetch-Site": "same-site", "Origin": "https://admin.example.com"})
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+
+	t.Run("Rejects missing token", func(t *testing.T) {
+		rec := post("", nil)
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+		assert.Equal(t, CSRF_FORBIDDEN_MESSAGE, rec.Body.String())
+	})
+
+	t.Run("Rejects cross site requests", func(t *testing.T) {
+		rec := post("", map[string]string{csrf.HEADER_NAME: token, "Sec-Fetch-Site": "cross-site", "Origin": "https://evil.com"})
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+
+		rec = post("", map[string]string{csrf.HEADER_NAME: token, "Referer": "https://evil.com/form"})
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+	})
+
+	t.Run("Rejects request without cookie", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodDelete, "/orders/1", nil)
+		req.Header.Set(csrf.HEADER_NAME, token)
+		req.Header.Set("Accept", "application/json")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+		assert.Equal(t, "application/problem+json", rec.Header().Get("Content-Type"))
+		assert.Contains(t, rec.Body.String(), CSRF_FORBIDDEN_MESSAGE)
+	})
+
+	t.Run("Skips exempt paths", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/api/orders", nil)
+		req.Header.Set("Authorization", "Bearer token")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Empty(t, rec.Result().Cookies(), "Expected no cookie for API routes")
+	})
+}
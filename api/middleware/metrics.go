@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mcgtrt/go-puerto/internal/metrics"
+)
+
+// Name used for requests that did not match any registered route
+const UNMATCHED_ROUTE = "unmatched"
+
+// Record request count, latency and in-flight requests labelled by the chi
+// route pattern. Route pattern is only known after the router matched the
+// request, so it's read once the next handler returns.
+func MetricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		metrics.HTTPRequestsInFlight.Inc()
+		defer metrics.HTTPRequestsInFlight.Dec()
+
+		rw := newResponseWriter(w)
+		next.ServeHTTP(rw, r)
+
+		var (
+			route  = routePattern(r)
+			status = strconv.Itoa(rw.status)
+		)
+		metrics.HTTPRequestsTotal.WithLabelValues(route, r.Method, status).Inc()
+		metrics.HTTPRequestDuration.WithLabelValues(route, r.Method, status).Observe(time.Since(start).Seconds())
+	})
+}
+
+func routePattern(r *http.Request) string {
+	rctx := chi.RouteContext(r.Context())
+	if rctx == nil {
+		return UNMATCHED_ROUTE
+	}
+	if pattern := rctx.RoutePattern(); pattern != "" {
+		return pattern
+	}
+	return UNMATCHED_ROUTE
+}
@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mcgtrt/go-puerto/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricsMiddleware(t *testing.T) {
+	r := chi.NewRouter()
+	r.Use(MetricsMiddleware)
+	r.Get("/items/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	t.Run("Labels requests with the route pattern", func(t *testing.T) {
+		counter := metrics.HTTPRequestsTotal.WithLabelValues("/items/{id}", http.MethodGet, "201")
+		before := testutil.ToFloat64(counter)
+
+		for _, id := range []string{"1", "2", "3"} {
+			req := httptest.NewRequest(http.MethodGet, "/items/"+id, nil)
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+			assert.Equal(t, http.StatusCreated, rec.Code)
+		}
+
+		assert.Equal(t, before+3, testutil.ToFloat64(counter), "Expected all requests under one route label")
+		assert.Equal(t, float64(0), testutil.ToFloat64(metrics.HTTPRequestsInFlight), "Expected no requests in flight")
+	})
+
+	t.Run("Unmatched routes share a single label", func(t *testing.T) {
+		counter := metrics.HTTPRequestsTotal.WithLabelValues(UNMATCHED_ROUTE, http.MethodGet, "404")
+		before := testutil.ToFloat64(counter)
+
+		req := httptest.NewRequest(http.MethodGet, "/random/path", nil)
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+
+		assert.Equal(t, before+1, testutil.ToFloat64(counter))
+	})
+}
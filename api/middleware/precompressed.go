@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// File extensions of precompressed siblings by content encoding
+var precompressedExtensions = map[string]string{
+	ENCODING_BROTLI: ".br",
+	ENCODING_ZSTD:   ".zst",
+	ENCODING_GZIP:   ".gz",
+}
+
+// Serve precompressed siblings (e.g. app.css.br or app.css.gz) of the
+// requested file from fsys when the client accepts their encoding. Mount it
+// in front of a file server using the same file system and URL paths.
+func PrecompressedMiddleware(fsys http.FileSystem) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			name := r.URL.Path
+			if (r.Method != http.MethodGet && r.Method != http.MethodHead) || strings.HasSuffix(name, "/") {
+				next.ServeHTTP(w, r)
+				return
+			}
+			addVary(w.Header(), "Accept-Encoding")
+			for _, enc := range AcceptedEncodings(r.Header.Get("Accept-Encoding")) {
+				f, err := fsys.Open(name + precompressedExtensions[enc])
+				if err != nil {
+					continue
+				}
+				stat, err := f.Stat()
+				if err != nil || stat.IsDir() {
+					f.Close()
+					continue
+				}
+				defer f.Close()
+				if ctype := mime.TypeByExtension(path.Ext(name)); ctype != "" {
+					w.Header().Set("Content-Type", ctype)
+				}
+				w.Header().Set("Content-Encoding", enc)
+				if etag := w.Header().Get("ETag"); etag != "" {
+					w.Header().Set("ETag", addETagEncoding(etag, enc))
+				}
+				http.ServeContent(w, r, name, stat.ModTime(), f)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
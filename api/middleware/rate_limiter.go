@@ -3,6 +3,7 @@ package middleware
 import (
 	"net/http"
 
+	"github.com/mcgtrt/go-puerto/internal/metrics"
 	"golang.org/x/time/rate"
 )
 
@@ -11,12 +12,30 @@ var DEFAULT_RATE_LIMITER_BURST = 15
 
 var Limiter = rate.NewLimiter(DEFAULT_RATE_LIMITER_LIMIT, DEFAULT_RATE_LIMITER_BURST)
 
-func RateLimitMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if !Limiter.Allow() {
-			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
-			return
+// Chooses the limiter of the request, e.g. by its API key. Returning nil
+// leaves the request to the next policy and finally to the global Limiter.
+type RateLimitPolicy func(r *http.Request) *rate.Limiter
+
+// Limit requests with the limiter chosen by the policies, tried in order
+// before the global Limiter
+func RateLimitMiddleware(policies ...RateLimitPolicy) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !limiterOf(r, policies).Allow() {
+				metrics.RateLimitRejections.Inc()
+				http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func limiterOf(r *http.Request, policies []RateLimitPolicy) *rate.Limiter {
+	for _, policy := range policies {
+		if limiter := policy(r); limiter != nil {
+			return limiter
 		}
-		next.ServeHTTP(w, r)
-	})
+	}
+	return Limiter
 }
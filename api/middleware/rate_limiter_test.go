@@ -30,7 +30,7 @@ func TestRateLimitMiddleware(t *testing.T) {
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("OK"))
 	})
-	rateLimitedHandler := RateLimitMiddleware(handler)
+	rateLimitedHandler := RateLimitMiddleware()(handler)
 
 	t.Run("Within limit", func(t *testing.T) {
 		req := httptest.NewRequest(http.MethodGet, "/", nil)
@@ -70,4 +70,28 @@ func TestRateLimitMiddleware(t *testing.T) {
 		assert.Equal(t, http.StatusOK, rec.Code)
 		assert.Equal(t, "OK", rec.Body.String())
 	})
+
+	t.Run("Policy limiter", func(t *testing.T) {
+		keyLimiter := rate.NewLimiter(rate.Every(time.Hour), 1)
+		rateLimitedHandler := RateLimitMiddleware(func(r *http.Request) *rate.Limiter {
+			if r.Header.Get("X-API-Key") == "key" {
+				return keyLimiter
+			}
+			return nil
+		})(handler)
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-API-Key", "key")
+		rec := httptest.NewRecorder()
+		rateLimitedHandler.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		rec = httptest.NewRecorder()
+		rateLimitedHandler.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusTooManyRequests, rec.Code, "Expected own limit of the key")
+
+		rec = httptest.NewRecorder()
+		rateLimitedHandler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		assert.Equal(t, http.StatusOK, rec.Code, "Expected other requests left to the global limiter")
+	})
 }
@@ -0,0 +1,105 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"sort"
+	"strings"
+
+	"github.com/mcgtrt/go-puerto/api/handlers"
+	"github.com/mcgtrt/go-puerto/internal/logging"
+	"github.com/mcgtrt/go-puerto/internal/metrics"
+	"github.com/mcgtrt/go-puerto/internal/tracing"
+	"github.com/mcgtrt/go-puerto/templates/pages"
+	"github.com/mcgtrt/go-puerto/types"
+	"github.com/mcgtrt/go-puerto/utils"
+)
+
+// Recover from panics in the next handlers and respond with the project's
+// 500 error response. The panic is logged with its stack trace and request
+// context and counted in metrics. In development mode browsers receive
+// a detailed error page instead. Mount it as the very first middleware.
+func RecoveryMiddleware(development bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Log scope is created here so attributes added by the following
+			// middlewares (request ID, user ID) are known when recovering
+			r = r.WithContext(logging.WithAttrs(r.Context()))
+			rw := newResponseWriter(w)
+
+			defer func() {
+				rec := recover()
+				if rec == nil {
+					return
+				}
+				// Aborting the handler is a deliberate panic of net/http
+				if rec == http.ErrAbortHandler {
+					panic(rec)
+				}
+				stack := debug.Stack()
+				metrics.PanicsTotal.Inc()
+				logging.FromContext(r.Context()).Error("panic recovered",
+					slog.Any("panic", rec),
+					slog.String("method", r.Method),
+					slog.String("path", r.URL.Path),
+					slog.String("stack", string(stack)),
+				)
+				// Nothing can be done if the response has already started
+				if rw.wroteHeader {
+					return
+				}
+
+				ctx := handlers.NewCtx(rw, withRequestID(r))
+				if development && !ctx.WantsJSON() {
+					rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+					rw.WriteHeader(http.StatusInternalServerError)
+					ctx.Render(pages.DevErrorPage(panicReport(ctx.Request, rec, stack)))
+					return
+				}
+				ctx.Error(http.StatusInternalServerError)
+			}()
+
+			next.ServeHTTP(rw, r)
+		})
+	}
+}
+
+// Request ID is set by a middleware running after the recovery one, so it's
+// restored from the request-scoped log attributes for the error response
+func withRequestID(r *http.Request) *http.Request {
+	for _, attr := range logging.Attrs(r.Context()) {
+		if attr.Key == "request_id" {
+			ctx := context.WithValue(r.Context(), types.RequestIDCtxKey{}, attr.Value.String())
+			return r.WithContext(ctx)
+		}
+	}
+	return r
+}
+
+func panicReport(r *http.Request, rec any, stack []byte) pages.PanicReport {
+	report := pages.PanicReport{
+		Panic:  fmt.Sprint(rec),
+		Stack:  string(stack),
+		Method: r.Method,
+		URL:    r.URL.String(),
+	}
+	for name, values := range r.Header {
+		report.Headers = append(report.Headers, [2]string{name, strings.Join(values, ", ")})
+	}
+	sort.Slice(report.Headers, func(i, j int) bool { return report.Headers[i][0] < report.Headers[j][0] })
+
+	lang, currency := utils.GetLocale(r.Context())
+	report.Context = [][2]string{
+		{"language", lang},
+		{"currency", currency},
+		{"request_id", utils.GetRequestID(r.Context())},
+		{"trace_id", tracing.TraceID(r.Context())},
+	}
+	for _, attr := range logging.Attrs(r.Context()) {
+		report.Context = append(report.Context, [2]string{"log." + attr.Key, attr.Value.String()})
+	}
+	return report
+}
@@ -0,0 +1,97 @@
+package middleware
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mcgtrt/go-puerto/internal/logging"
+	"github.com/mcgtrt/go-puerto/internal/metrics"
+	"github.com/mcgtrt/go-puerto/utils"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecoveryMiddleware(t *testing.T) {
+	// Capture log output
+	var logOutput bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(logging.New(&utils.LoggingConfig{Format: utils.LOG_FORMAT_JSON}, &logOutput))
+
+	panicking := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("something went wrong")
+	})
+	chain := func(development bool) http.Handler {
+		return RecoveryMiddleware(development)(RequestIDMiddleware("X-Request-ID", utils.REQUEST_ID_FORMAT_UUIDV7)(panicking))
+	}
+
+	t.Run("Converts panic into error response", func(t *testing.T) {
+		logOutput.Reset()
+		before := testutil.ToFloat64(metrics.PanicsTotal)
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Request-ID", "req-1")
+		rec := httptest.NewRecorder()
+
+		chain(false).ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusInternalServerError, rec.Code)
+		assert.Equal(t, "Internal Server Error\n", rec.Body.String())
+		assert.Equal(t, before+1, testutil.ToFloat64(metrics.PanicsTotal), "Expected panic counted")
+		assert.Contains(t, logOutput.String(), `"panic":"something went wrong"`)
+		assert.Contains(t, logOutput.String(), `"request_id":"req-1"`, "Expected request context in logs")
+		assert.Contains(t, logOutput.String(), "runtime/debug.Stack", "Expected stack trace in logs")
+	})
+
+	t.Run("API clients receive problem with request ID", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Request-ID", "req-2")
+		req.Header.Set("Accept", "application/json")
+		rec := httptest.NewRecorder()
+
+		chain(true).ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusInternalServerError, rec.Code)
+		assert.JSONEq(t, `{"title":"Internal Server Error","status":500,"instance":"/","request_id":"req-2"}`, rec.Body.String())
+	})
+
+	t.Run("Development mode renders diagnostics page", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/orders?page=2", nil)
+		req.Header.Set("X-Custom", "custom-value")
+		rec := httptest.NewRecorder()
+
+		chain(true).ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusInternalServerError, rec.Code)
+		assert.Equal(t, "text/html; charset=utf-8", rec.Header().Get("Content-Type"))
+		assert.Contains(t, rec.Body.String(), "panic: something went wrong")
+		assert.Contains(t, rec.Body.String(), "/orders?page=2")
+		assert.Contains(t, rec.Body.String(), "custom-value", "Expected request headers")
+		assert.Contains(t, rec.Body.String(), "request_id", "Expected context values")
+	})
+
+	t.Run("Does not write after response started", func(t *testing.T) {
+		handler := RecoveryMiddleware(false)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusAccepted)
+			w.Write([]byte("partial"))
+			panic("too late")
+		}))
+		rec := httptest.NewRecorder()
+
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		assert.Equal(t, http.StatusAccepted, rec.Code)
+		assert.Equal(t, "partial", rec.Body.String())
+	})
+
+	t.Run("Re-panics on aborted handler", func(t *testing.T) {
+		handler := RecoveryMiddleware(false)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			panic(http.ErrAbortHandler)
+		}))
+
+		assert.PanicsWithValue(t, http.ErrAbortHandler, func() {
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		})
+	})
+}
@@ -0,0 +1,38 @@
+package middleware
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"regexp"
+
+	"github.com/mcgtrt/go-puerto/internal/logging"
+	"github.com/mcgtrt/go-puerto/types"
+	"github.com/mcgtrt/go-puerto/utils"
+)
+
+// Incoming request IDs are only accepted if they can't break logs or headers
+var requestIDPattern = regexp.MustCompile(`^[a-zA-Z0-9._:-]{1,128}$`)
+
+// Accept the request ID from the given header or generate a new one in the
+// given format (uuidv7 or ulid). The ID is stored in the context, added to
+// the request-scoped log attributes and echoed in the response header.
+func RequestIDMiddleware(header, format string) func(http.Handler) http.Handler {
+	generate := utils.NewUUIDv7
+	if format == utils.REQUEST_ID_FORMAT_ULID {
+		generate = utils.NewULID
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := r.Header.Get(header)
+			if !requestIDPattern.MatchString(id) {
+				id = generate()
+			}
+			w.Header().Set(header, id)
+
+			ctx := context.WithValue(r.Context(), types.RequestIDCtxKey{}, id)
+			ctx = logging.WithAttrs(ctx, slog.String("request_id", id))
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
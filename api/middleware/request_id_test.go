@@ -0,0 +1,47 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mcgtrt/go-puerto/internal/logging"
+	"github.com/mcgtrt/go-puerto/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestIDMiddleware(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attrs := logging.Attrs(r.Context())
+		assert.Len(t, attrs, 1, "Expected request ID in log attributes")
+		assert.Equal(t, utils.GetRequestID(r.Context()), attrs[0].Value.String())
+		w.Write([]byte(utils.GetRequestID(r.Context())))
+	})
+
+	tests := []struct {
+		name     string
+		header   string
+		format   string
+		incoming string
+		pattern  string
+	}{
+		{"Generates UUIDv7 when missing", "X-Request-ID", utils.REQUEST_ID_FORMAT_UUIDV7, "", `^[0-9a-f-]{36}$`},
+		{"Generates ULID when missing", "X-Request-ID", utils.REQUEST_ID_FORMAT_ULID, "", `^[0-9A-Z]{26}$`},
+		{"Accepts valid incoming ID", "X-Correlation-ID", utils.REQUEST_ID_FORMAT_UUIDV7, "abc-123", `^abc-123$`},
+		{"Replaces unsafe incoming ID", "X-Request-ID", utils.REQUEST_ID_FORMAT_ULID, "bad id\nInjected: yes", `^[0-9A-Z]{26}$`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.incoming != "" {
+				req.Header.Set(tt.header, tt.incoming)
+			}
+			rec := httptest.NewRecorder()
+
+			RequestIDMiddleware(tt.header, tt.format)(handler).ServeHTTP(rec, req)
+
+			assert.Regexp(t, tt.pattern, rec.Body.String(), "Expected request ID in context")
+			assert.Equal(t, rec.Body.String(), rec.Header().Get(tt.header), "Expected request ID echoed in response")
+		})
+	}
+}
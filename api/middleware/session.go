@@ -0,0 +1,64 @@
+package middleware
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/mcgtrt/go-puerto/api/handlers"
+	"github.com/mcgtrt/go-puerto/internal/logging"
+	"github.com/mcgtrt/go-puerto/internal/session"
+)
+
+// Load the session of the request into the context (see Ctx.Session) and
+// commit it right before the response headers are sent, so handlers can
+// change the session until they start writing the response.
+func SessionMiddleware(manager *session.Manager) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			s, err := manager.Load(r)
+			if err != nil {
+				logging.FromContext(r.Context()).Error("loading session failed", "error", err)
+				handlers.NewCtx(w, r).Error(http.StatusInternalServerError)
+				return
+			}
+			r = r.WithContext(session.WithSession(r.Context(), s))
+			sw := &sessionWriter{ResponseWriter: w}
+			sw.commit = func() {
+				if err := manager.Commit(w, r, s); err != nil {
+					logging.FromContext(r.Context()).Error("saving session failed", "error", err)
+				}
+			}
+
+			next.ServeHTTP(sw, r)
+			sw.once.Do(sw.commit)
+		})
+	}
+}
+
+// Commits the session once, before anything is written to the client
+type sessionWriter struct {
+	http.ResponseWriter
+	once   sync.Once
+	commit func()
+}
+
+func (w *sessionWriter) WriteHeader(code int) {
+	w.once.Do(w.commit)
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *sessionWriter) Write(b []byte) (int, error) {
+	w.once.Do(w.commit)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *sessionWriter) Flush() {
+	w.once.Do(w.commit)
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *sessionWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
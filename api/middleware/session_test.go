@@ -0,0 +1,118 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mcgtrt/go-puerto/api/handlers"
+	"github.com/mcgtrt/go-puerto/internal/session"
+	"github.com/mcgtrt/go-puerto/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSessionMiddleware(t *testing.T) {
+	store := session.NewMemoryStore()
+	manager := session.NewManager(store, &utils.SessionConfig{
+		CookieName:      "session",
+		IdleTimeout:     30 * time.Minute,
+		AbsoluteTimeout: time.Hour,
+	}, true)
+
+	handler := SessionMiddleware(manager)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c := handlers.NewCtx(w, r)
+		s := c.Session()
+		switch r.URL.Path {
+		case "/login":
+			s.SetUser("user-1")
+			s.AddFlash(session.FLASH_SUCCESS, "Welcome")
+		case "/count":
+			visits, _ := session.Get[int](s, "visits")
+			s.Set("visits", visits+1)
+		case "/logout":
+			s.Destroy()
+		}
+		visits, _ := session.Get[int](s, "visits")
+		c.JSON(http.StatusOK, map[string]any{"user": s.UserID, "visits": visits})
+	}))
+
+	do := func(path string, cookie *http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	sessionCookie := func(rec *httptest.ResponseRecorder) *http.Cookie {
+		for _, c := range rec.Result().Cookies() {
+			if c.Name == "session" {
+				return c
+			}
+		}
+		return nil
+	}
+
+	t.Run("Unmodified new session isn't saved", func(t *testing.T) {
+		rec := do("/", nil)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Nil(t, sessionCookie(rec))
+	})
+
+	rec := do("/count", nil)
+	cookie := sessionCookie(rec)
+	assert.NotNil(t, cookie, "Expected session cookie")
+	assert.True(t, cookie.HttpOnly)
+	assert.True(t, cookie.Secure)
+	assert.Equal(t, 1800, cookie.MaxAge, "Expected cookie expiring with idle timeout")
+
+	t.Run("Session is loaded from cookie", func(t *testing.T) {
+		rec := do("/count", cookie)
+		assert.JSONEq(t, `{"user":"","visits":2}`, rec.Body.String())
+	})
+
+	t.Run("Login rotates session ID", func(t *testing.T) {
+		rec := do("/login", cookie)
+		rotated := sessionCookie(rec)
+		assert.NotNil(t, rotated)
+		assert.NotEqual(t, cookie.Value, rotated.Value)
+
+		rec = do("/", cookie)
+		assert.JSONEq(t, `{"user":"","visits":0}`, rec.Body.String(), "Expected old session ID invalid")
+		rec = do("/", rotated)
+		assert.JSONEq(t, `{"user":"user-1","visits":2}`, rec.Body.String())
+		cookie = rotated
+	})
+
+	t.Run("Revoke all user sessions", func(t *testing.T) {
+		assert.NoError(t, manager.RevokeUser(context.Background(), "user-1"))
+		rec := do("/", cookie)
+		assert.JSONEq(t, `{"user":"","visits":0}`, rec.Body.String())
+	})
+
+	t.Run("Logout destroys session", func(t *testing.T) {
+		cookie := sessionCookie(do("/count", nil))
+		rec := do("/logout", cookie)
+		cleared := sessionCookie(rec)
+		assert.NotNil(t, cleared)
+		assert.Equal(t, -1, cleared.MaxAge)
+
+		rec = do("/", cookie)
+		assert.JSONEq(t, `{"user":"","visits":0}`, rec.Body.String())
+	})
+
+	t.Run("Expired sessions are replaced", func(t *testing.T) {
+		s, err := manager.Load(httptest.NewRequest(http.MethodGet, "/", nil))
+		assert.NoError(t, err)
+		s.Set("visits", 5)
+		s.CreatedAt = time.Now().Add(-2 * time.Hour)
+		_, err = store.Save(context.Background(), s, time.Hour)
+		assert.NoError(t, err)
+
+		rec := do("/", &http.Cookie{Name: "session", Value: s.ID})
+		assert.JSONEq(t, `{"user":"","visits":0}`, rec.Body.String(), "Expected absolute timeout enforced")
+	})
+}
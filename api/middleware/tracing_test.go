@@ -0,0 +1,64 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mcgtrt/go-puerto/internal/tracing"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTracingMiddleware(t *testing.T) {
+	_, exporter := tracing.SetupInMemory()
+
+	r := chi.NewRouter()
+	r.Use(TracingMiddleware)
+	r.Get("/orders/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(tracing.TraceID(r.Context())))
+	})
+	r.Get("/fail", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	t.Run("Names span after route pattern and continues incoming trace", func(t *testing.T) {
+		exporter.Reset()
+		traceID := "4bf92f3577b34da6a3ce929d0e0e4736"
+		req := httptest.NewRequest(http.MethodGet, "/orders/42", nil)
+		req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+		rec := httptest.NewRecorder()
+
+		r.ServeHTTP(rec, req)
+
+		spans := exporter.GetSpans()
+		assert.Len(t, spans, 1, "Expected one server span")
+		assert.Equal(t, "GET /orders/{id}", spans[0].Name)
+		assert.Equal(t, trace.SpanKindServer, spans[0].SpanKind)
+		assert.Equal(t, traceID, spans[0].SpanContext.TraceID().String(), "Expected trace to continue from traceparent")
+		assert.Equal(t, traceID, rec.Body.String(), "Expected trace ID available to handlers")
+		assert.Subset(t, spans[0].Attributes, []attribute.KeyValue{
+			semconv.HTTPRequestMethodKey.String(http.MethodGet),
+			semconv.URLPath("/orders/42"),
+			semconv.HTTPRoute("/orders/{id}"),
+			semconv.HTTPResponseStatusCode(http.StatusOK),
+		})
+	})
+
+	t.Run("Marks server errors", func(t *testing.T) {
+		exporter.Reset()
+		req := httptest.NewRequest(http.MethodGet, "/fail", nil)
+		rec := httptest.NewRecorder()
+
+		r.ServeHTTP(rec, req)
+
+		spans := exporter.GetSpans()
+		assert.Len(t, spans, 1, "Expected one server span")
+		assert.Equal(t, codes.Error, spans[0].Status.Code)
+		assert.Contains(t, spans[0].Attributes, semconv.HTTPResponseStatusCode(http.StatusInternalServerError))
+	})
+}
@@ -1,13 +1,18 @@
 package api
 
 import (
+	"log/slog"
 	"net/http"
 	"os"
-	"path/filepath"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/mcgtrt/go-puerto/api/handlers"
 	"github.com/mcgtrt/go-puerto/api/middleware"
+	"github.com/mcgtrt/go-puerto/internal/assets"
+	"github.com/mcgtrt/go-puerto/internal/images"
+	"github.com/mcgtrt/go-puerto/internal/metrics"
+	"github.com/mcgtrt/go-puerto/internal/uploads"
+	"github.com/mcgtrt/go-puerto/static"
 	"github.com/mcgtrt/go-puerto/utils"
 	"golang.org/x/time/rate"
 )
@@ -19,14 +24,49 @@ type APIFunc func(c *handlers.Ctx) error
 func NewRouter(h *Handler, cfg *utils.Config) *chi.Mux {
 	r := chi.NewRouter()
 
-	mountMiddlewares(r, cfg.Middleware)
-	mountRoutes(r, h, cfg.HTTP)
+	mountMiddlewares(r, h, cfg)
+	mountRoutes(r, h, cfg)
 
 	return r
 }
 
+// Returns a router for the admin server. It's used when the metrics
+// endpoint is configured to run on a separate port.
+func NewAdminRouter(cfg *utils.Config) *chi.Mux {
+	r := chi.NewRouter()
+	if cfg.Metrics != nil {
+		mountMetrics(r, cfg.Metrics.Path)
+	}
+	return r
+}
+
 // The place to mount all the middlewares
-func mountMiddlewares(r *chi.Mux, cfg *utils.MiddlewareConfig) {
+func mountMiddlewares(r *chi.Mux, h *Handler, config *utils.Config) {
+	cfg := config.Middleware
+	// Recovery must stay first to catch panics in every other middleware
+	r.Use(middleware.RecoveryMiddleware(config.HTTP.Development))
+	if cfg.RequestID {
+		r.Use(middleware.RequestIDMiddleware(cfg.RequestIDHeader, cfg.RequestIDFormat))
+	}
+	if config.Tracing != nil {
+		r.Use(middleware.TracingMiddleware)
+	}
+	if config.Metrics != nil {
+		r.Use(middleware.MetricsMiddleware)
+	}
+	if cfg.LogAndMonitorHeaders {
+		r.Use(middleware.AccessLogMiddleware(config.Logging))
+	}
+	if cfg.Compression {
+		minSize, types := middleware.DEFAULT_COMPRESSION_MIN_SIZE, middleware.DEFAULT_COMPRESSION_CONTENT_TYPES
+		if cfg.CompressionMinSize != nil {
+			minSize = *cfg.CompressionMinSize
+		}
+		if len(cfg.CompressionTypes) > 0 {
+			types = cfg.CompressionTypes
+		}
+		r.Use(middleware.CompressionMiddleware(minSize, types))
+	}
 	if cfg.Localisation {
 		r.Use(middleware.LocalisationMiddleware)
 	}
@@ -37,10 +77,12 @@ func mountMiddlewares(r *chi.Mux, cfg *utils.MiddlewareConfig) {
 		if cfg.RateLimiterLimit != nil && cfg.RateLimiterBurst != nil {
 			middleware.Limiter = rate.NewLimiter(rate.Limit(*cfg.RateLimiterLimit), *cfg.RateLimiterBurst)
 		}
-		r.Use(middleware.RateLimitMiddleware)
-	}
-	if cfg.LogAndMonitorHeaders {
-		r.Use(middleware.LogHeadersMiddleware)
+		var policies []middleware.RateLimitPolicy
+		if h.APIKeys != nil {
+			// Verified keys get their own limit instead of the global one
+			policies = append(policies, h.APIKeys.Keys.RateLimitPolicy)
+		}
+		r.Use(middleware.RateLimitMiddleware(policies...))
 	}
 	if cfg.CORS {
 		r.Use(middleware.CORSMiddleware)
@@ -54,32 +96,109 @@ func mountMiddlewares(r *chi.Mux, cfg *utils.MiddlewareConfig) {
 	if cfg.MethodOverride {
 		r.Use(middleware.MethodOverrideMiddleware)
 	}
+	if h.Sessions != nil {
+		r.Use(middleware.SessionMiddleware(h.Sessions))
+	}
+	if len(h.Auth) > 0 {
+		r.Use(middleware.AuthMiddleware(h.Auth...))
+	}
+	if h.Authorizer != nil {
+		r.Use(middleware.AuthorizationMiddleware(h.Authorizer))
+	}
+	// CSRF checks the method left after a possible override
+	if cfg.CSRF {
+		secret := []byte(os.Getenv(utils.AES_SECRET))
+		exempt := cfg.CSRFExemptPaths
+		if h.Tokens != nil {
+			// Token endpoints accept JSON only, which can't be sent cross-site
+			exempt = append(exempt, TOKEN_ROUTES_PREFIX)
+		}
+		r.Use(middleware.CSRFMiddleware(secret, !config.HTTP.Development, exempt, cfg.CSRFTrustedOrigins))
+	}
 }
 
 // This is the global routes mount entry. Add new mountSomethig
-// into this method to keep it simple and nicely organised
-func mountRoutes(r *chi.Mux, h *Handler, cfg *utils.HTTPConfig) {
-	if cfg.FileServerPath != "" {
-		mountFileServer(r, cfg.FileServerPath, "static")
+// into this method to keep it simple and nicely organised. Protect
+// groups of routes with permissions:
+//
+//	r.Group(func(r chi.Router) {
+//		r.Use(middleware.Require("orders:write"))
+//		r.Post("/orders", wrap(h.Orders.HandleCreate))
+//	})
+func mountRoutes(r *chi.Mux, h *Handler, cfg *utils.Config) {
+	if cfg.HTTP.FileServerPath != "" {
+		manifest, err := loadAssets(cfg.HTTP)
+		if err != nil {
+			slog.Error("file server disabled - could not load static files", "error", err)
+		} else {
+			assets.SetDefault(manifest)
+			mountFileServer(r, cfg.HTTP.FileServerPath, manifest)
+		}
+	}
+	if cfg.Metrics != nil && cfg.Metrics.Port == 0 {
+		mountMetrics(r, cfg.Metrics.Path)
 	}
+	mountHealth(r, h.Health)
 	mountView(r, h.View)
+	if h.Accounts != nil {
+		mountAccounts(r, h.Accounts)
+	}
+	if h.Tokens != nil {
+		mountTokens(r, h.Tokens)
+	}
+	if h.OIDC != nil {
+		mountOIDC(r, h.OIDC)
+	}
+	if h.MFA != nil {
+		mountMFA(r, h.MFA)
+	}
+	if h.MagicLink != nil {
+		mountMagicLink(r, h.MagicLink)
+	}
+	if h.APIKeys != nil {
+		mountAPIKeys(r, h.APIKeys)
+	}
+	if h.JobAdmin != nil {
+		mountJobs(r, h.JobAdmin)
+	}
+	if h.WebSocket != nil {
+		mountWebSocket(r, h.WebSocket)
+	}
+	if h.Uploads != nil {
+		mountUploads(r, h.Uploads)
+	}
+	if h.Images != nil {
+		mountImages(r, h.Images)
+	}
 }
 
-// Ensure local file server is serving the files from the directory
-// that will have the same url path in accessing the file server
-func mountFileServer(r *chi.Mux, pathURL, staticDir string) {
-	wd, err := os.Getwd()
-	if err != nil {
-		panic(err)
+// Static files are embedded into the binary and fingerprinted. In
+// development they are read from disk so changes show up without rebuilding.
+func loadAssets(cfg *utils.HTTPConfig) (*assets.Manifest, error) {
+	if cfg.Development {
+		return assets.Load(os.DirFS(cfg.StaticDir), cfg.FileServerPath, false)
 	}
+	return assets.Load(static.FS, cfg.FileServerPath, true)
+}
 
-	static := filepath.Join(wd, staticDir)
-	fs := http.FileServer(http.Dir(static))
-	fileServer := http.StripPrefix("/"+pathURL, fs)
+// Serve static files under the url path. Fingerprinted URLs are cached
+// forever, directory listings are disabled and range requests supported.
+func mountFileServer(r *chi.Mux, pathURL string, manifest *assets.Manifest) {
+	fsys := manifest.FileSystem()
+	files := middleware.PrecompressedMiddleware(fsys)(http.FileServer(fsys))
 
-	r.Get("/"+pathURL+"/*", func(w http.ResponseWriter, r *http.Request) {
-		fileServer.ServeHTTP(w, r)
-	})
+	r.Method(http.MethodGet, "/"+pathURL+"/*", manifest.Handler(files))
+	r.Method(http.MethodHead, "/"+pathURL+"/*", manifest.Handler(files))
+}
+
+// Expose collected metrics in the Prometheus text format
+func mountMetrics(r *chi.Mux, path string) {
+	r.Method(http.MethodGet, path, metrics.Handler())
+}
+
+// Health of the app and its databases for load balancers and orchestrators
+func mountHealth(r *chi.Mux, h *handlers.HealthHandler) {
+	r.Get("/health", wrap(h.HandleHealth))
 }
 
 // Use to match all the routes and implement serving web pages
@@ -87,6 +206,114 @@ func mountView(r *chi.Mux, h *handlers.ViewHandler) {
 	r.Get("/", wrap(h.HandleHomePage))
 }
 
+// Registration, login and password recovery pages
+func mountAccounts(r *chi.Mux, h *handlers.AccountHandler) {
+	r.Get("/register", wrap(h.HandleRegisterPage))
+	r.Post("/register", wrap(h.HandleRegister))
+	r.Get("/login", wrap(h.HandleLoginPage))
+	r.Post("/login", wrap(h.HandleLogin))
+	r.Post("/logout", wrap(h.HandleLogout))
+	r.Get("/verify-email", wrap(h.HandleVerifyEmail))
+	r.Get("/forgot-password", wrap(h.HandleForgotPasswordPage))
+	r.Post("/forgot-password", wrap(h.HandleForgotPassword))
+	r.Get("/reset-password", wrap(h.HandleResetPasswordPage))
+	r.Post("/reset-password", wrap(h.HandleResetPassword))
+}
+
+// Sign-in with OpenID Connect providers. Providers redirect back to the
+// callback route, which must be registered with them as the redirect URI.
+func mountOIDC(r *chi.Mux, h *handlers.OIDCHandler) {
+	r.Get("/auth/oidc/{provider}/login", wrap(h.HandleLogin))
+	r.Get("/auth/oidc/{provider}/callback", wrap(h.HandleCallback))
+}
+
+// Second factor step of the login and two-factor settings of logged in users
+func mountMFA(r *chi.Mux, h *handlers.MFAHandler) {
+	r.Get(handlers.MFA_LOGIN_PATH, wrap(h.HandleChallengePage))
+	r.Post(handlers.MFA_LOGIN_PATH, wrap(h.HandleChallenge))
+	r.Route("/account/2fa", func(r chi.Router) {
+		r.Use(middleware.RequireAuth)
+		r.Get("/", wrap(h.HandleSettingsPage))
+		r.Post("/setup", wrap(h.HandleSetup))
+		r.Post("/confirm", wrap(h.HandleConfirm))
+		r.Post("/recovery-codes", wrap(h.HandleRecoveryCodes))
+		r.Post("/disable", wrap(h.HandleDisable))
+	})
+}
+
+// Passwordless login. The emailed link opens the confirm page.
+func mountMagicLink(r *chi.Mux, h *handlers.MagicLinkHandler) {
+	r.Get("/login/link", wrap(h.HandleRequestPage))
+	r.Post("/login/link", wrap(h.HandleRequest))
+	r.Get("/login/link/status", wrap(h.HandleStatus))
+	r.Get("/login/link/confirm", wrap(h.HandleConfirmPage))
+	r.Post("/login/link/confirm", wrap(h.HandleConfirm))
+}
+
+// API keys page of logged in users
+func mountAPIKeys(r *chi.Mux, h *handlers.APIKeyHandler) {
+	r.Route("/account/api-keys", func(r chi.Router) {
+		r.Use(middleware.RequireAuth)
+		r.Get("/", wrap(h.HandleListPage))
+		r.Post("/", wrap(h.HandleCreate))
+		r.Post("/{id}/revoke", wrap(h.HandleRevoke))
+	})
+}
+
+// Admin page of the background jobs, allowed to roles with the
+// jobs:manage permission
+func mountJobs(r *chi.Mux, h *handlers.JobHandler) {
+	r.Route("/admin/jobs", func(r chi.Router) {
+		r.Use(middleware.Require("jobs:manage"))
+		r.Get("/", wrap(h.HandleListPage))
+		r.Post("/{id}/retry", wrap(h.HandleRetry))
+		r.Post("/{id}/delete", wrap(h.HandleDelete))
+	})
+}
+
+// Connections of the WebSocket hub. Rooms are authorised by the rules of
+// the hub, register them with Hub.Allow.
+func mountWebSocket(r *chi.Mux, h *handlers.WebSocketHandler) {
+	r.Get("/ws", wrap(h.HandleConnect))
+}
+
+// Uploads of logged in users. Download links are signed, so they work
+// without a session, e.g. in emails and image tags.
+func mountUploads(r *chi.Mux, h *handlers.UploadHandler) {
+	r.Route("/uploads", func(r chi.Router) {
+		r.Use(middleware.RequireAuth)
+		r.Get("/", wrap(h.HandleUploadPage))
+		r.Post("/", wrap(h.HandleUpload))
+		r.Post("/resumable", wrap(h.HandleStartResumable))
+		r.Head("/resumable/{id}", wrap(h.HandleResumableStatus))
+		r.Patch("/resumable/{id}", wrap(h.HandleChunk))
+		r.Delete("/resumable/{id}", wrap(h.HandleAbort))
+	})
+	r.Get(uploads.DOWNLOAD_PATH+"*", wrap(h.HandleDownload))
+	r.Head(uploads.DOWNLOAD_PATH+"*", wrap(h.HandleDownload))
+}
+
+// Resized uploaded images. URLs are signed, so they're public like the
+// download links.
+func mountImages(r *chi.Mux, h *handlers.ImageHandler) {
+	r.Get(images.IMAGE_PATH+"{size}/*", wrap(h.HandleImage))
+	r.Head(images.IMAGE_PATH+"{size}/*", wrap(h.HandleImage))
+}
+
+// Path prefix of the JWT token endpoints
+const TOKEN_ROUTES_PREFIX = "/api/auth/"
+
+// Public keys of the JWT keyring and token endpoints of stateless clients.
+// Issuing tokens for email and password requires accounts.
+func mountTokens(r *chi.Mux, h *handlers.TokenHandler) {
+	r.Get("/.well-known/jwks.json", wrap(h.HandleJWKS))
+	if h.Accounts != nil {
+		r.Post(TOKEN_ROUTES_PREFIX+"token", wrap(h.HandleToken))
+	}
+	r.Post(TOKEN_ROUTES_PREFIX+"refresh", wrap(h.HandleRefresh))
+	r.Post(TOKEN_ROUTES_PREFIX+"revoke", wrap(h.HandleRevoke))
+}
+
 // Use this function to convert APIFunc to http.HandlerFunc
 // and handle possible errors with the Error Handler func
 func wrap(fn APIFunc) http.HandlerFunc {
@@ -95,6 +322,7 @@ func wrap(fn APIFunc) http.HandlerFunc {
 
 		if err := fn(ctx); err != nil {
 			// TODO: HANDLE ERRORS
+			ctx.Logger().Error("request failed", "error", err)
 			ctx.Error(http.StatusInternalServerError)
 		}
 	}
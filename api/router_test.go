@@ -1,24 +1,34 @@
 package api
 
 import (
+	"bytes"
+	"context"
+	"io"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strings"
 	"testing"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/mcgtrt/go-puerto/api/handlers"
+	"github.com/mcgtrt/go-puerto/internal/accounts"
+	"github.com/mcgtrt/go-puerto/internal/apikeys"
+	"github.com/mcgtrt/go-puerto/internal/assets"
+	"github.com/mcgtrt/go-puerto/internal/auth"
+	"github.com/mcgtrt/go-puerto/templates/layout"
+	"github.com/mcgtrt/go-puerto/utils"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestMountFileServer(t *testing.T) {
 	// Create a temporary static directory for testing
-	wd, _ := os.Getwd()
-	staticPath := filepath.Join(wd, "static_test")
-	err := os.MkdirAll(staticPath, 0755) // Use MkdirAll to avoid "file exists" errors
+	staticPath := t.TempDir()
+	err := os.MkdirAll(filepath.Join(staticPath, "css"), 0755)
 	assert.NoError(t, err, "Failed to create static directory")
-	defer os.RemoveAll(staticPath) // Cleanup after the test
 
 	// Create a mock file in the static directory
 	filePath := filepath.Join(staticPath, "test.txt")
@@ -26,27 +36,51 @@ func TestMountFileServer(t *testing.T) {
 	assert.NoError(t, err, "Failed to create test file")
 
 	// Initialize the router and mount the file server
+	manifest, err := assets.Load(os.DirFS(staticPath), "static_test", true)
+	assert.NoError(t, err, "Failed to load static files")
 	r := chi.NewRouter()
-	mountFileServer(r, "static_test", "static_test")
+	mountFileServer(r, "static_test", manifest)
 
-	// Test serving an existing file
-	req := httptest.NewRequest(http.MethodGet, "/static_test/test.txt", nil)
+	// Test serving an existing file by its fingerprinted URL
+	req := httptest.NewRequest(http.MethodGet, manifest.URL("test.txt"), nil)
 	w := httptest.NewRecorder()
 
 	r.ServeHTTP(w, req)
 
-	// Assert 200 OK and correct file content
+	// Assert 200 OK, correct file content and long-lived caching
 	assert.Equal(t, http.StatusOK, w.Code, "Expected 200 OK response for static file")
 	assert.Equal(t, "test content", w.Body.String(), "Expected file content to be served")
+	assert.Equal(t, assets.CACHE_CONTROL_IMMUTABLE, w.Header().Get("Cache-Control"), "Expected immutable fingerprinted file")
 
-	// Test serving a non-existent file
-	req = httptest.NewRequest(http.MethodGet, "/static_test/nonexistent.txt", nil)
+	// Test serving an existing file by its plain name
+	req = httptest.NewRequest(http.MethodGet, "/static_test/test.txt", nil)
 	w = httptest.NewRecorder()
 
 	r.ServeHTTP(w, req)
 
-	// Assert 404 Not Found
-	assert.Equal(t, http.StatusNotFound, w.Code, "Expected 404 Not Found for non-existent file")
+	assert.Equal(t, http.StatusOK, w.Code, "Expected 200 OK response for static file")
+	assert.Equal(t, assets.CACHE_CONTROL_REVALIDATE, w.Header().Get("Cache-Control"), "Expected revalidated plain file")
+
+	// Test range requests
+	req = httptest.NewRequest(http.MethodGet, "/static_test/test.txt", nil)
+	req.Header.Set("Range", "bytes=0-3")
+	w = httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusPartialContent, w.Code, "Expected 206 Partial Content response")
+	assert.Equal(t, "test", w.Body.String(), "Expected requested range to be served")
+
+	// Test serving a non-existent file and a directory listing
+	for _, path := range []string{"/static_test/nonexistent.txt", "/static_test/", "/static_test/css/"} {
+		req = httptest.NewRequest(http.MethodGet, path, nil)
+		w = httptest.NewRecorder()
+
+		r.ServeHTTP(w, req)
+
+		// Assert 404 Not Found
+		assert.Equal(t, http.StatusNotFound, w.Code, "Expected 404 Not Found for %s", path)
+	}
 }
 
 func TestWrap(t *testing.T) {
@@ -83,3 +117,89 @@ func TestWrap(t *testing.T) {
 		assert.Equal(t, http.StatusInternalServerError, w.Code, "Expected 500 Internal Server Error response")
 	})
 }
+
+func TestCSRFSkipsMachineClients(t *testing.T) {
+	users := accounts.NewMemoryStore()
+	assert.NoError(t, users.CreateUser(context.Background(), &accounts.User{ID: "user", Email: "john@example.com", Name: "John"}))
+	keys := apikeys.NewService(apikeys.NewMemoryStore(), users, &utils.APIKeysConfig{Prefix: "api", MaxPerUser: 5, RateLimitPerMinute: 60})
+	created, err := keys.Create(context.Background(), "user", "CI", []string{apikeys.SCOPE_FULL}, apikeys.DEFAULT_POLICY, 0)
+	assert.NoError(t, err)
+
+	h := &Handler{Auth: []auth.Strategy{auth.APIKeyStrategy{Verify: keys.Verify}}}
+	cfg := &utils.Config{HTTP: &utils.HTTPConfig{}, Middleware: &utils.MiddlewareConfig{CSRF: true}}
+	r := chi.NewRouter()
+	mountMiddlewares(r, h, cfg)
+	r.Post("/orders", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	t.Run("API key without cookie", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/orders", nil)
+		req.Header.Set(auth.API_KEY_HEADER, created.Plain)
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusCreated, rec.Code)
+	})
+
+	t.Run("Anonymous without cookie", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/orders", nil))
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+	})
+}
+
+func TestCSRFPlainMultipartForm(t *testing.T) {
+	t.Setenv(utils.AES_SECRET, "0123456789abcdef0123456789abcdef")
+	cfg := &utils.Config{HTTP: &utils.HTTPConfig{}, Middleware: &utils.MiddlewareConfig{CSRF: true}}
+	r := chi.NewRouter()
+	mountMiddlewares(r, &Handler{}, cfg)
+	r.Get("/uploads", func(w http.ResponseWriter, r *http.Request) {
+		layout.Upload(layout.UploadForm{Action: "/uploads", Name: "file"}).Render(r.Context(), w)
+	})
+	r.Post("/uploads", func(w http.ResponseWriter, r *http.Request) {
+		// The body is left for the handler to stream
+		mr, err := r.MultipartReader()
+		if !assert.NoError(t, err) {
+			return
+		}
+		part, err := mr.NextPart()
+		if !assert.NoError(t, err) {
+			return
+		}
+		content, _ := io.ReadAll(part)
+		w.Write(content)
+	})
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/uploads", nil))
+	match := regexp.MustCompile(`action="([^"]+)"`).FindStringSubmatch(rec.Body.String())
+	if !assert.Len(t, match, 2, "Expected the form action") {
+		t.FailNow()
+	}
+	action := strings.ReplaceAll(match[1], "&amp;", "&")
+	cookies := rec.Result().Cookies()
+
+	post := func(target string) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		fw, _ := mw.CreateFormFile("file", "notes.txt")
+		fw.Write([]byte("hello"))
+		mw.Close()
+		req := httptest.NewRequest(http.MethodPost, "http://example.com"+target, &body)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		req.Header.Set("Origin", "http://example.com")
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec = post(action)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "hello", rec.Body.String())
+
+	rec = post("/uploads")
+	assert.Equal(t, http.StatusForbidden, rec.Code, "Expected form without token rejected")
+}
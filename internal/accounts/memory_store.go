@@ -0,0 +1,116 @@
+package accounts
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Keeps users in the process memory. Useful for tests and prototyping.
+type MemoryStore struct {
+	mu     sync.Mutex
+	users  map[string]User
+	tokens map[string]Token
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		users:  make(map[string]User),
+		tokens: make(map[string]Token),
+	}
+}
+
+func (m *MemoryStore) CreateUser(ctx context.Context, u *User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, existing := range m.users {
+		if existing.Email == u.Email {
+			return ErrEmailTaken
+		}
+	}
+	m.users[u.ID] = *u
+	return nil
+}
+
+func (m *MemoryStore) GetUserByID(ctx context.Context, id string) (*User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.users[id]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	return &u, nil
+}
+
+func (m *MemoryStore) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, u := range m.users {
+		if u.Email == email {
+			return &u, nil
+		}
+	}
+	return nil, ErrUserNotFound
+}
+
+func (m *MemoryStore) UpdateUser(ctx context.Context, u *User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.users[u.ID]; !ok {
+		return ErrUserNotFound
+	}
+	m.users[u.ID] = *u
+	return nil
+}
+
+func (m *MemoryStore) RecordFailedLogin(ctx context.Context, id string, max int, lockedUntil, now time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.users[id]
+	if !ok {
+		return false, ErrUserNotFound
+	}
+	u.FailedLogins++
+	locked := u.FailedLogins >= max
+	if locked {
+		u.FailedLogins = 0
+		u.LockedUntil = lockedUntil
+	}
+	u.UpdatedAt = now
+	m.users[id] = u
+	return locked, nil
+}
+
+func (m *MemoryStore) ResetFailedLogins(ctx context.Context, id string, now time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.users[id]
+	if !ok {
+		return ErrUserNotFound
+	}
+	u.FailedLogins = 0
+	u.UpdatedAt = now
+	m.users[id] = u
+	return nil
+}
+
+func (m *MemoryStore) CreateToken(ctx context.Context, t *Token) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tokens[t.Hash] = *t
+	return nil
+}
+
+func (m *MemoryStore) ConsumeToken(ctx context.Context, purpose, hash string) (*Token, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, ok := m.tokens[hash]
+	if !ok || t.Purpose != purpose {
+		return nil, ErrTokenNotFound
+	}
+	delete(m.tokens, hash)
+	if time.Now().After(t.ExpiresAt) {
+		return nil, ErrTokenNotFound
+	}
+	return &t, nil
+}
@@ -0,0 +1,40 @@
+package accounts
+
+import (
+	"context"
+
+	"github.com/mcgtrt/go-puerto/internal/logging"
+)
+
+// Kinds of account notifications
+const (
+	NOTIFY_VERIFY_EMAIL   = "verify_email"
+	NOTIFY_PASSWORD_RESET = "password_reset"
+	// Someone tried to register with an already registered email
+	NOTIFY_ACCOUNT_EXISTS = "account_exists"
+	// Passwordless login link
+	NOTIFY_MAGIC_LINK = "magic_link"
+)
+
+// Email sent to the user, e.g. with the verification or reset link. Lang
+// is the language of the request which triggered it.
+type Notification struct {
+	Kind string
+	User *User
+	Link string
+	Lang string
+}
+
+// Delivers account notifications to users
+type Notifier interface {
+	Notify(ctx context.Context, n Notification) error
+}
+
+// Writes notifications to the log instead of sending them. Meant for
+// local development until a mailer is configured.
+type LogNotifier struct{}
+
+func (LogNotifier) Notify(ctx context.Context, n Notification) error {
+	logging.FromContext(ctx).Info("account notification", "kind", n.Kind, "user_id", n.User.ID, "link", n.Link)
+	return nil
+}
@@ -0,0 +1,46 @@
+package accounts
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Cheap parameters keep the tests fast
+var testParams = HashParams{Memory: 1024, Time: 1, Threads: 1, SaltLen: 16, KeyLen: 32}
+
+func TestPasswordHashing(t *testing.T) {
+	hash, err := HashPassword("Secret1!", testParams)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(hash, "$argon2id$v=19$m=1024,t=1,p=1$"), "Expected PHC string format")
+
+	other, err := HashPassword("Secret1!", testParams)
+	assert.NoError(t, err)
+	assert.NotEqual(t, hash, other, "Expected random salt")
+
+	ok, rehash, err := VerifyPassword("Secret1!", hash, testParams)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.False(t, rehash)
+
+	ok, _, err = VerifyPassword("Secret2!", hash, testParams)
+	assert.NoError(t, err)
+	assert.False(t, ok, "Expected wrong password rejected")
+
+	t.Run("Detects outdated parameters", func(t *testing.T) {
+		stronger := testParams
+		stronger.Time = 2
+		ok, rehash, err := VerifyPassword("Secret1!", hash, stronger)
+		assert.NoError(t, err)
+		assert.True(t, ok, "Expected stored parameters used for verification")
+		assert.True(t, rehash)
+	})
+
+	t.Run("Rejects malformed hashes", func(t *testing.T) {
+		for _, h := range []string{"", "plain", "$argon2i$v=19$m=1024,t=1,p=1$c2FsdA$a2V5", "$argon2id$v=19$m=x$c2FsdA$a2V5", "$argon2id$v=19$m=1024,t=1,p=1$!!$a2V5"} {
+			_, _, err := VerifyPassword("Secret1!", h, testParams)
+			assert.ErrorIs(t, err, ErrInvalidHash, "Expected %q rejected", h)
+		}
+	})
+}
@@ -0,0 +1,274 @@
+package accounts
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/mcgtrt/go-puerto/internal/logging"
+	"github.com/mcgtrt/go-puerto/utils"
+)
+
+// Defaults of the account policies
+const (
+	DEFAULT_MAX_FAILED_LOGINS  = 5
+	DEFAULT_LOCKOUT_DURATION   = 15 * time.Minute
+	DEFAULT_VERIFY_EMAIL_TTL   = 48 * time.Hour
+	DEFAULT_PASSWORD_RESET_TTL = time.Hour
+)
+
+var (
+	ErrInvalidEmail       = errors.New("invalid email address")
+	ErrInvalidName        = errors.New("invalid name")
+	ErrInvalidPassword    = errors.New("password must have 8-32 characters including a number, an upper case letter and a special character")
+	ErrInvalidCredentials = errors.New("invalid email or password")
+)
+
+// Account use cases on top of the user store. Failures that could reveal
+// whether an email is registered take the same time and return the same
+// errors as for existing accounts.
+type Service struct {
+	Store            UserStore
+	HashParams       HashParams
+	MaxFailedLogins  int
+	LockoutDuration  time.Duration
+	VerifyEmailTTL   time.Duration
+	PasswordResetTTL time.Duration
+
+	// Hash compared against when the user doesn't exist, so unknown emails
+	// take as long as wrong passwords
+	dummyHash string
+}
+
+func NewService(store UserStore) *Service {
+	dummy, _ := HashPassword("dummy password", DEFAULT_HASH_PARAMS)
+	return &Service{
+		Store:            store,
+		HashParams:       DEFAULT_HASH_PARAMS,
+		MaxFailedLogins:  DEFAULT_MAX_FAILED_LOGINS,
+		LockoutDuration:  DEFAULT_LOCKOUT_DURATION,
+		VerifyEmailTTL:   DEFAULT_VERIFY_EMAIL_TTL,
+		PasswordResetTTL: DEFAULT_PASSWORD_RESET_TTL,
+		dummyHash:        dummy,
+	}
+}
+
+func NormaliseEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// Register new user and return the email verification token. Returns
+// ErrEmailTaken (with the existing user) if the email is registered -
+// callers should respond the same way as on success and notify the
+// owner of the address instead.
+func (s *Service) Register(ctx context.Context, email, name, password string) (*User, string, error) {
+	email, name = NormaliseEmail(email), strings.TrimSpace(name)
+	switch {
+	case !utils.IsEmailCorrect(email):
+		return nil, "", ErrInvalidEmail
+	case !utils.IsNameCorrect(name):
+		return nil, "", ErrInvalidName
+	case !utils.IsPasswordCorrect(password):
+		return nil, "", ErrInvalidPassword
+	}
+	// Hash before checking the email so both paths take the same time
+	hash, err := HashPassword(password, s.HashParams)
+	if err != nil {
+		return nil, "", err
+	}
+
+	now := time.Now()
+	user := &User{
+		ID:           utils.NewUUIDv7(),
+		Email:        email,
+		Name:         name,
+		PasswordHash: hash,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+	if err := s.Store.CreateUser(ctx, user); err != nil {
+		if errors.Is(err, ErrEmailTaken) {
+			existing, getErr := s.Store.GetUserByEmail(ctx, email)
+			if getErr != nil {
+				return nil, "", getErr
+			}
+			return existing, "", ErrEmailTaken
+		}
+		return nil, "", err
+	}
+	token, err := s.issueToken(ctx, TOKEN_VERIFY_EMAIL, user.ID, s.VerifyEmailTTL)
+	if err != nil {
+		return nil, "", err
+	}
+	return user, token, nil
+}
+
+// Register user authenticated by an external identity provider. Such
+// users have no password until they reset it. The email is marked
+// verified when the provider vouches for it. Invalid names (providers
+// have their own rules) are replaced with the local part of the email.
+func (s *Service) RegisterExternal(ctx context.Context, email, name string, verified bool) (*User, error) {
+	email, name = NormaliseEmail(email), strings.TrimSpace(name)
+	if !utils.IsEmailCorrect(email) {
+		return nil, ErrInvalidEmail
+	}
+	if !utils.IsNameCorrect(name) {
+		name, _, _ = strings.Cut(email, "@")
+	}
+	now := time.Now()
+	user := &User{
+		ID:        utils.NewUUIDv7(),
+		Email:     email,
+		Name:      name,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if verified {
+		user.EmailVerifiedAt = &now
+	}
+	if err := s.Store.CreateUser(ctx, user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// Authenticate the user. Every failure (unknown email, wrong password,
+// locked account) returns ErrInvalidCredentials. Repeated failures lock
+// the account and successful logins upgrade outdated password hashes.
+func (s *Service) Login(ctx context.Context, email, password string) (*User, error) {
+	log := logging.FromContext(ctx)
+	user, err := s.Store.GetUserByEmail(ctx, NormaliseEmail(email))
+	if errors.Is(err, ErrUserNotFound) {
+		VerifyPassword(password, s.dummyHash, s.HashParams)
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Users registered through identity providers have no password
+	if user.PasswordHash == "" {
+		VerifyPassword(password, s.dummyHash, s.HashParams)
+		return nil, ErrInvalidCredentials
+	}
+
+	ok, rehash, err := VerifyPassword(password, user.PasswordHash, s.HashParams)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if user.IsLocked(now) {
+		log.Warn("login to locked account", "user_id", user.ID)
+		return nil, ErrInvalidCredentials
+	}
+	if !ok {
+		// Counted by the store, so parallel guesses can't beat the lockout
+		// and the write can't undo a password change made meanwhile
+		locked, err := s.Store.RecordFailedLogin(ctx, user.ID, s.MaxFailedLogins, now.Add(s.LockoutDuration), now)
+		if err != nil {
+			return nil, err
+		}
+		if locked {
+			log.Warn("account locked after failed logins", "user_id", user.ID)
+		}
+		return nil, ErrInvalidCredentials
+	}
+
+	if rehash {
+		if user.PasswordHash, err = HashPassword(password, s.HashParams); err != nil {
+			return nil, err
+		}
+		user.FailedLogins = 0
+		user.UpdatedAt = now
+		if err := s.Store.UpdateUser(ctx, user); err != nil {
+			return nil, err
+		}
+	} else if user.FailedLogins > 0 {
+		if err := s.Store.ResetFailedLogins(ctx, user.ID, now); err != nil {
+			return nil, err
+		}
+		user.FailedLogins = 0
+	}
+	return user, nil
+}
+
+// Mark the email of the token owner as verified
+func (s *Service) VerifyEmail(ctx context.Context, token string) (*User, error) {
+	t, err := s.Store.ConsumeToken(ctx, TOKEN_VERIFY_EMAIL, HashToken(token))
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.Store.GetUserByID(ctx, t.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if !user.IsVerified() {
+		now := time.Now()
+		user.EmailVerifiedAt = &now
+		user.UpdatedAt = now
+		if err := s.Store.UpdateUser(ctx, user); err != nil {
+			return nil, err
+		}
+	}
+	return user, nil
+}
+
+// Issue new email verification token for the user
+func (s *Service) ResendVerification(ctx context.Context, user *User) (string, error) {
+	return s.issueToken(ctx, TOKEN_VERIFY_EMAIL, user.ID, s.VerifyEmailTTL)
+}
+
+// Issue password reset token. Returns ErrUserNotFound for unknown emails,
+// which callers must not reveal to the client.
+func (s *Service) RequestPasswordReset(ctx context.Context, email string) (*User, string, error) {
+	user, err := s.Store.GetUserByEmail(ctx, NormaliseEmail(email))
+	if err != nil {
+		return nil, "", err
+	}
+	token, err := s.issueToken(ctx, TOKEN_PASSWORD_RESET, user.ID, s.PasswordResetTTL)
+	if err != nil {
+		return nil, "", err
+	}
+	return user, token, nil
+}
+
+// Set new password of the token owner. The account gets unlocked and its
+// email verified, as the user proved access to the mailbox. Callers should
+// revoke all sessions of the returned user.
+func (s *Service) ResetPassword(ctx context.Context, token, password string) (*User, error) {
+	if !utils.IsPasswordCorrect(password) {
+		return nil, ErrInvalidPassword
+	}
+	t, err := s.Store.ConsumeToken(ctx, TOKEN_PASSWORD_RESET, HashToken(token))
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.Store.GetUserByID(ctx, t.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if user.PasswordHash, err = HashPassword(password, s.HashParams); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	user.FailedLogins, user.LockedUntil, user.UpdatedAt = 0, time.Time{}, now
+	if !user.IsVerified() {
+		user.EmailVerifiedAt = &now
+	}
+	if err := s.Store.UpdateUser(ctx, user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (s *Service) issueToken(ctx context.Context, purpose, userID string, ttl time.Duration) (string, error) {
+	plain, hash, err := NewToken()
+	if err != nil {
+		return "", err
+	}
+	t := &Token{Hash: hash, Purpose: purpose, UserID: userID, ExpiresAt: time.Now().Add(ttl)}
+	if err := s.Store.CreateToken(ctx, t); err != nil {
+		return "", err
+	}
+	return plain, nil
+}
@@ -0,0 +1,158 @@
+package accounts
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestService() *Service {
+	s := NewService(NewMemoryStore())
+	s.HashParams = testParams
+	return s
+}
+
+func TestRegister(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService()
+
+	user, token, err := s.Register(ctx, " John@Example.com ", "John", "Secret1!")
+	assert.NoError(t, err)
+	assert.Equal(t, "john@example.com", user.Email, "Expected normalised email")
+	assert.NotEmpty(t, token)
+	assert.False(t, user.IsVerified())
+
+	_, _, err = s.Register(ctx, "invalid", "John", "Secret1!")
+	assert.ErrorIs(t, err, ErrInvalidEmail)
+	_, _, err = s.Register(ctx, "jane@example.com", "J", "Secret1!")
+	assert.ErrorIs(t, err, ErrInvalidName)
+	_, _, err = s.Register(ctx, "jane@example.com", "Jane", "weak")
+	assert.ErrorIs(t, err, ErrInvalidPassword)
+
+	existing, _, err := s.Register(ctx, "john@example.com", "Johnny", "Secret2!")
+	assert.ErrorIs(t, err, ErrEmailTaken)
+	assert.Equal(t, user.ID, existing.ID, "Expected existing user returned for notification")
+
+	t.Run("Verify email", func(t *testing.T) {
+		verified, err := s.VerifyEmail(ctx, token)
+		assert.NoError(t, err)
+		assert.True(t, verified.IsVerified())
+
+		_, err = s.VerifyEmail(ctx, token)
+		assert.ErrorIs(t, err, ErrTokenNotFound, "Expected single-use token")
+	})
+}
+
+func TestLogin(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService()
+	user, _, err := s.Register(ctx, "john@example.com", "John", "Secret1!")
+	assert.NoError(t, err)
+
+	logged, err := s.Login(ctx, "JOHN@example.com", "Secret1!")
+	assert.NoError(t, err)
+	assert.Equal(t, user.ID, logged.ID)
+
+	_, err = s.Login(ctx, "nobody@example.com", "Secret1!")
+	assert.ErrorIs(t, err, ErrInvalidCredentials, "Expected unknown email indistinguishable")
+
+	t.Run("Upgrades outdated hashes", func(t *testing.T) {
+		s.HashParams.Time = 2
+		defer func() { s.HashParams.Time = 1 }()
+
+		_, err := s.Login(ctx, "john@example.com", "Secret1!")
+		assert.NoError(t, err)
+		stored, _ := s.Store.GetUserByID(ctx, user.ID)
+		assert.Contains(t, stored.PasswordHash, "t=2")
+	})
+
+	t.Run("Locks account after repeated failures", func(t *testing.T) {
+		for i := 0; i < s.MaxFailedLogins; i++ {
+			_, err := s.Login(ctx, "john@example.com", "Wrong1!!")
+			assert.ErrorIs(t, err, ErrInvalidCredentials)
+		}
+		_, err := s.Login(ctx, "john@example.com", "Secret1!")
+		assert.ErrorIs(t, err, ErrInvalidCredentials, "Expected locked account rejected")
+
+		stored, _ := s.Store.GetUserByID(ctx, user.ID)
+		assert.True(t, stored.IsLocked(time.Now()))
+		stored.LockedUntil = time.Now().Add(-time.Second)
+		assert.NoError(t, s.Store.UpdateUser(ctx, stored))
+
+		_, err = s.Login(ctx, "john@example.com", "Secret1!")
+		assert.NoError(t, err, "Expected login after lockout")
+	})
+
+	t.Run("Counts parallel failures", func(t *testing.T) {
+		var wg sync.WaitGroup
+		for i := 0; i < s.MaxFailedLogins; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				s.Login(ctx, "john@example.com", "Wrong1!!")
+			}()
+		}
+		wg.Wait()
+		stored, _ := s.Store.GetUserByID(ctx, user.ID)
+		assert.True(t, stored.IsLocked(time.Now()), "Expected no failure lost")
+	})
+}
+
+func TestPasswordReset(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService()
+	user, _, err := s.Register(ctx, "john@example.com", "John", "Secret1!")
+	assert.NoError(t, err)
+
+	_, _, err = s.RequestPasswordReset(ctx, "nobody@example.com")
+	assert.ErrorIs(t, err, ErrUserNotFound)
+
+	_, token, err := s.RequestPasswordReset(ctx, "john@example.com")
+	assert.NoError(t, err)
+
+	_, err = s.ResetPassword(ctx, token, "weak")
+	assert.ErrorIs(t, err, ErrInvalidPassword)
+
+	reset, err := s.ResetPassword(ctx, token, "NewSecret1!")
+	assert.NoError(t, err)
+	assert.Equal(t, user.ID, reset.ID)
+	assert.True(t, reset.IsVerified(), "Expected email verified by reset")
+
+	_, err = s.ResetPassword(ctx, token, "NewSecret2!")
+	assert.ErrorIs(t, err, ErrTokenNotFound, "Expected single-use token")
+
+	_, err = s.Login(ctx, "john@example.com", "Secret1!")
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+	_, err = s.Login(ctx, "john@example.com", "NewSecret1!")
+	assert.NoError(t, err)
+
+	t.Run("Expired token", func(t *testing.T) {
+		s.PasswordResetTTL = -time.Second
+		_, token, err := s.RequestPasswordReset(ctx, "john@example.com")
+		assert.NoError(t, err)
+		_, err = s.ResetPassword(ctx, token, "NewSecret3!")
+		assert.ErrorIs(t, err, ErrTokenNotFound)
+	})
+}
+
+func TestRegisterExternal(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService()
+
+	user, err := s.RegisterExternal(ctx, "Jane@Example.com", "J", true)
+	assert.NoError(t, err)
+	assert.Equal(t, "jane@example.com", user.Email)
+	assert.Equal(t, "jane", user.Name, "Expected invalid name replaced")
+	assert.True(t, user.IsVerified())
+
+	_, err = s.Login(ctx, "jane@example.com", "")
+	assert.ErrorIs(t, err, ErrInvalidCredentials, "Expected password login impossible")
+
+	_, err = s.RegisterExternal(ctx, "jane@example.com", "Jane", false)
+	assert.ErrorIs(t, err, ErrEmailTaken)
+	_, err = s.RegisterExternal(ctx, "invalid", "Jane", false)
+	assert.ErrorIs(t, err, ErrInvalidEmail)
+}
@@ -0,0 +1,90 @@
+package accounts
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+var (
+	ErrUserNotFound  = errors.New("user not found")
+	ErrEmailTaken    = errors.New("email is already registered")
+	ErrTokenNotFound = errors.New("token not found or expired")
+)
+
+// Registered user. Email is always stored normalised (trimmed, lower case).
+type User struct {
+	ID              string     `json:"id" bson:"_id"`
+	Email           string     `json:"email" bson:"email"`
+	Name            string     `json:"name" bson:"name"`
+	PasswordHash    string     `json:"-" bson:"password_hash"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at,omitempty" bson:"email_verified_at,omitempty"`
+	FailedLogins    int        `json:"-" bson:"failed_logins"`
+	LockedUntil     time.Time  `json:"-" bson:"locked_until"`
+	CreatedAt       time.Time  `json:"created_at" bson:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at" bson:"updated_at"`
+}
+
+func (u *User) IsVerified() bool {
+	return u.EmailVerifiedAt != nil
+}
+
+func (u *User) IsLocked(now time.Time) bool {
+	return now.Before(u.LockedUntil)
+}
+
+// Purposes of single-use tokens sent to users by email
+const (
+	TOKEN_VERIFY_EMAIL   = "verify_email"
+	TOKEN_PASSWORD_RESET = "password_reset"
+)
+
+// Single-use token. Only the SHA-256 hash of the token is stored, so
+// a leaked database can't be used to verify emails or reset passwords.
+type Token struct {
+	Hash      string    `bson:"_id"`
+	Purpose   string    `bson:"purpose"`
+	UserID    string    `bson:"user_id"`
+	ExpiresAt time.Time `bson:"expires_at"`
+}
+
+// Generate random token returning its plain value (to be sent to the
+// user) and the hash to be stored
+func NewToken() (plain, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	plain = base64.RawURLEncoding.EncodeToString(b)
+	return plain, HashToken(plain), nil
+}
+
+func HashToken(plain string) string {
+	sum := sha256.Sum256([]byte(plain))
+	return hex.EncodeToString(sum[:])
+}
+
+// Persistence of users and their single-use tokens
+type UserStore interface {
+	// Insert new user. Returns ErrEmailTaken if the email is registered.
+	CreateUser(ctx context.Context, u *User) error
+	// Returns ErrUserNotFound if the user doesn't exist
+	GetUserByID(ctx context.Context, id string) (*User, error)
+	// Returns ErrUserNotFound if the user doesn't exist
+	GetUserByEmail(ctx context.Context, email string) (*User, error)
+	UpdateUser(ctx context.Context, u *User) error
+	// Atomically count a failed login of the user. When the count reaches
+	// max, it's reset and the user locked until lockedUntil. Returns
+	// whether the user got locked.
+	RecordFailedLogin(ctx context.Context, id string, max int, lockedUntil, now time.Time) (bool, error)
+	// Reset the count of failed logins without touching other fields
+	ResetFailedLogins(ctx context.Context, id string, now time.Time) error
+	CreateToken(ctx context.Context, t *Token) error
+	// Atomically remove and return the token. Returns ErrTokenNotFound
+	// if it doesn't exist or has expired.
+	ConsumeToken(ctx context.Context, purpose, hash string) (*Token, error)
+}
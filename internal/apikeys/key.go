@@ -0,0 +1,110 @@
+package apikeys
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+)
+
+var (
+	ErrKeyNotFound   = errors.New("api key not found")
+	ErrInvalidScope  = errors.New("unknown api key scope")
+	ErrInvalidPolicy = errors.New("unknown api key rate limit policy")
+	ErrTooManyKeys   = errors.New("too many api keys")
+)
+
+// API key of a user. The key given to the client is "<prefix>_<id>_<secret>"
+// and only the SHA-256 hash of the secret is stored. The prefix makes
+// leaked keys easy to find by secret scanners, the ID to show and look up
+// the key without the secret. Policy names the rate limit policy of the
+// key (see Service.Policies).
+type Key struct {
+	ID         string     `json:"id" bson:"_id"`
+	UserID     string     `json:"user_id" bson:"user_id"`
+	Name       string     `json:"name" bson:"name"`
+	SecretHash string     `json:"-" bson:"secret_hash"`
+	Scopes     []string   `json:"scopes" bson:"scopes"`
+	Policy     string     `json:"policy" bson:"policy"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty" bson:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty" bson:"last_used_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at" bson:"created_at"`
+}
+
+func (k *Key) IsExpired(now time.Time) bool {
+	return k.ExpiresAt != nil && !now.Before(*k.ExpiresAt)
+}
+
+// Persistence of API keys
+type Store interface {
+	CreateKey(ctx context.Context, k *Key) error
+	// Returns ErrKeyNotFound if the key doesn't exist
+	GetKey(ctx context.Context, id string) (*Key, error)
+	// Keys of the user, newest first
+	ListKeys(ctx context.Context, userID string) ([]Key, error)
+	// Returns ErrKeyNotFound if the user has no such key
+	DeleteKey(ctx context.Context, userID, id string) error
+	// Record the time the key was last used
+	TouchKey(ctx context.Context, id string, at time.Time) error
+}
+
+// Keeps keys in the process memory. Useful for tests and prototyping.
+type MemoryStore struct {
+	mu   sync.Mutex
+	keys map[string]Key
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{keys: make(map[string]Key)}
+}
+
+func (m *MemoryStore) CreateKey(ctx context.Context, k *Key) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.keys[k.ID] = *k
+	return nil
+}
+
+func (m *MemoryStore) GetKey(ctx context.Context, id string) (*Key, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	k, ok := m.keys[id]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	return &k, nil
+}
+
+func (m *MemoryStore) ListKeys(ctx context.Context, userID string) ([]Key, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	keys := []Key{}
+	for _, k := range m.keys {
+		if k.UserID == userID {
+			keys = append(keys, k)
+		}
+	}
+	slices.SortFunc(keys, func(a, b Key) int { return b.CreatedAt.Compare(a.CreatedAt) })
+	return keys, nil
+}
+
+func (m *MemoryStore) DeleteKey(ctx context.Context, userID, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if k, ok := m.keys[id]; !ok || k.UserID != userID {
+		return ErrKeyNotFound
+	}
+	delete(m.keys, id)
+	return nil
+}
+
+func (m *MemoryStore) TouchKey(ctx context.Context, id string, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if k, ok := m.keys[id]; ok {
+		k.LastUsedAt = &at
+		m.keys[id] = k
+	}
+	return nil
+}
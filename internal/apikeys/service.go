@@ -0,0 +1,310 @@
+package apikeys
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base32"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mcgtrt/go-puerto/internal/accounts"
+	"github.com/mcgtrt/go-puerto/internal/auth"
+	"github.com/mcgtrt/go-puerto/internal/logging"
+	"github.com/mcgtrt/go-puerto/utils"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// Length of the key ID (80 bits) and secret (160 bits) in base32
+	ID_LENGTH     = 16
+	SECRET_LENGTH = 32
+	// Last used time is written at most once per interval, so busy keys
+	// don't write on every request
+	TOUCH_INTERVAL  = time.Minute
+	MAX_NAME_LENGTH = 64
+	// Rate limit policy of new keys
+	DEFAULT_POLICY = "default"
+	// Scope granting all permissions of the key owner
+	SCOPE_FULL = "full"
+)
+
+var ErrInvalidName = errors.New("api key name must have 1-64 characters")
+
+var b32 = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Named set of permissions ("resource:action", see authz) granted to keys
+// with the scope. Keys never get more than the roles of their owner grant.
+type Scope struct {
+	Name        string
+	Description string
+	Permissions []string
+}
+
+// Requests per second and burst allowed to keys of the policy
+type RateLimit struct {
+	Rate  rate.Limit
+	Burst int
+}
+
+// Newly created key. Plain is shown to the user once and never stored.
+type Created struct {
+	Key   *Key
+	Plain string
+}
+
+type keyLimiter struct {
+	secretHash string
+	expiresAt  *time.Time
+	limiter    *rate.Limiter
+}
+
+// API keys of users for machine clients. Register scopes offered to users
+// with RegisterScope and rate limit policies in Policies, then pass the
+// policy name to Create.
+type Service struct {
+	Store      Store
+	Users      accounts.UserStore
+	Prefix     string
+	MaxPerUser int
+	Policies   map[string]RateLimit
+
+	mu       sync.RWMutex
+	scopes   []Scope
+	limiters sync.Map
+	now      func() time.Time
+}
+
+func NewService(store Store, users accounts.UserStore, cfg *utils.APIKeysConfig) *Service {
+	s := &Service{
+		Store:      store,
+		Users:      users,
+		Prefix:     cfg.Prefix,
+		MaxPerUser: cfg.MaxPerUser,
+		Policies: map[string]RateLimit{
+			DEFAULT_POLICY: {Rate: rate.Limit(float64(cfg.RateLimitPerMinute) / 60), Burst: cfg.RateLimitPerMinute},
+		},
+		now: time.Now,
+	}
+	s.RegisterScope(SCOPE_FULL, "Full access", "*")
+	return s
+}
+
+// Offer the scope to users creating keys. Registering the name again
+// replaces the scope.
+func (s *Service) RegisterScope(name, description string, permissions ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	scope := Scope{Name: name, Description: description, Permissions: permissions}
+	if i := slices.IndexFunc(s.scopes, func(sc Scope) bool { return sc.Name == name }); i >= 0 {
+		s.scopes[i] = scope
+		return
+	}
+	s.scopes = append(s.scopes, scope)
+}
+
+// Scopes offered to users in the order of registration
+func (s *Service) Scopes() []Scope {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return slices.Clone(s.scopes)
+}
+
+// Create key of the user with the scopes, limited by the rate limit
+// policy (DEFAULT_POLICY when empty). Zero TTL creates a key which
+// doesn't expire.
+func (s *Service) Create(ctx context.Context, userID, name string, scopes []string, policy string, ttl time.Duration) (*Created, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len([]rune(name)) > MAX_NAME_LENGTH {
+		return nil, ErrInvalidName
+	}
+	if policy == "" {
+		policy = DEFAULT_POLICY
+	}
+	if _, ok := s.Policies[policy]; !ok {
+		return nil, ErrInvalidPolicy
+	}
+	slices.Sort(scopes)
+	scopes = slices.Compact(scopes)
+	if len(scopes) == 0 {
+		return nil, ErrInvalidScope
+	}
+	for _, scope := range scopes {
+		if _, ok := s.scope(scope); !ok {
+			return nil, ErrInvalidScope
+		}
+	}
+	keys, err := s.Store.ListKeys(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) >= s.MaxPerUser {
+		return nil, ErrTooManyKeys
+	}
+
+	id, secret := randomString(ID_LENGTH), randomString(SECRET_LENGTH)
+	now := s.now()
+	k := &Key{
+		ID:         id,
+		UserID:     userID,
+		Name:       name,
+		SecretHash: accounts.HashToken(secret),
+		Scopes:     scopes,
+		Policy:     policy,
+		CreatedAt:  now,
+	}
+	if ttl > 0 {
+		expiresAt := now.Add(ttl)
+		k.ExpiresAt = &expiresAt
+	}
+	if err := s.Store.CreateKey(ctx, k); err != nil {
+		return nil, err
+	}
+	return &Created{Key: k, Plain: s.Prefix + "_" + id + "_" + secret}, nil
+}
+
+func (s *Service) List(ctx context.Context, userID string) ([]Key, error) {
+	return s.Store.ListKeys(ctx, userID)
+}
+
+func (s *Service) Revoke(ctx context.Context, userID, id string) error {
+	if err := s.Store.DeleteKey(ctx, userID, id); err != nil {
+		return err
+	}
+	s.limiters.Delete(id)
+	return nil
+}
+
+// Identifier of the key shown to users, the key without the secret
+func (s *Service) DisplayID(k *Key) string {
+	return s.Prefix + "_" + k.ID
+}
+
+// Resolve the owner of the key limited to the permissions of its scopes.
+// Use it as auth.APIKeyStrategy verifier. Unknown, expired and malformed
+// keys return auth.ErrInvalidCredentials.
+func (s *Service) Verify(ctx context.Context, plain string) (*auth.Principal, error) {
+	id, secret, ok := s.parse(plain)
+	if !ok {
+		return nil, auth.ErrInvalidCredentials
+	}
+	k, err := s.Store.GetKey(ctx, id)
+	if errors.Is(err, ErrKeyNotFound) {
+		// Deleted from the store without Revoke
+		s.limiters.Delete(id)
+		return nil, auth.ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+	secretHash := accounts.HashToken(secret)
+	now := s.now()
+	if subtle.ConstantTimeCompare([]byte(secretHash), []byte(k.SecretHash)) != 1 {
+		return nil, auth.ErrInvalidCredentials
+	}
+	if k.IsExpired(now) {
+		s.limiters.Delete(id)
+		return nil, auth.ErrInvalidCredentials
+	}
+	user, err := s.Users.GetUserByID(ctx, k.UserID)
+	if errors.Is(err, accounts.ErrUserNotFound) {
+		return nil, auth.ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Principals without scopes act with all permissions of the user, so
+	// keys left without permissions (e.g. their scopes were unregistered)
+	// are rejected
+	permissions := s.permissions(k.Scopes)
+	if len(permissions) == 0 {
+		return nil, auth.ErrInvalidCredentials
+	}
+
+	if k.LastUsedAt == nil || now.Sub(*k.LastUsedAt) >= TOUCH_INTERVAL {
+		if err := s.Store.TouchKey(ctx, k.ID, now); err != nil {
+			logging.FromContext(ctx).Warn("recording api key use failed", "key_id", k.ID, "error", err)
+		}
+	}
+	s.register(k, secretHash)
+	return &auth.Principal{ID: user.ID, Email: user.Email, Name: user.Name, Method: auth.METHOD_API_KEY, Scopes: permissions}, nil
+}
+
+// Limiter of the API key sending the request. Plug it into
+// RateLimitMiddleware. Keys get their limiter once verified, so requests
+// with unknown or wrong keys are left to the global limiter and can't use
+// up the limit of someone else's key.
+func (s *Service) RateLimitPolicy(r *http.Request) *rate.Limiter {
+	id, secret, ok := s.parse(r.Header.Get(auth.API_KEY_HEADER))
+	if !ok {
+		return nil
+	}
+	entry, ok := s.limiters.Load(id)
+	if !ok {
+		return nil
+	}
+	kl := entry.(*keyLimiter)
+	if subtle.ConstantTimeCompare([]byte(accounts.HashToken(secret)), []byte(kl.secretHash)) != 1 {
+		return nil
+	}
+	return kl.limiter
+}
+
+// Keep the limiter of the verified key. Limiters of keys expired
+// meanwhile are dropped, as their keys may never be verified again.
+func (s *Service) register(k *Key, secretHash string) {
+	if _, ok := s.limiters.Load(k.ID); ok {
+		return
+	}
+	now := s.now()
+	s.limiters.Range(func(id, entry any) bool {
+		if expiresAt := entry.(*keyLimiter).expiresAt; expiresAt != nil && !now.Before(*expiresAt) {
+			s.limiters.Delete(id)
+		}
+		return true
+	})
+	// Policies may be removed after keys were created with them
+	policy, ok := s.Policies[k.Policy]
+	if !ok {
+		policy = s.Policies[DEFAULT_POLICY]
+	}
+	s.limiters.LoadOrStore(k.ID, &keyLimiter{secretHash: secretHash, expiresAt: k.ExpiresAt, limiter: rate.NewLimiter(policy.Rate, policy.Burst)})
+}
+
+func (s *Service) permissions(scopes []string) []string {
+	var permissions []string
+	for _, name := range scopes {
+		if scope, ok := s.scope(name); ok {
+			permissions = append(permissions, scope.Permissions...)
+		}
+	}
+	return permissions
+}
+
+func (s *Service) scope(name string) (Scope, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	i := slices.IndexFunc(s.scopes, func(sc Scope) bool { return sc.Name == name })
+	if i < 0 {
+		return Scope{}, false
+	}
+	return s.scopes[i], true
+}
+
+func (s *Service) parse(plain string) (id, secret string, ok bool) {
+	rest, found := strings.CutPrefix(plain, s.Prefix+"_")
+	if !found || len(rest) != ID_LENGTH+1+SECRET_LENGTH || rest[ID_LENGTH] != '_' {
+		return "", "", false
+	}
+	return rest[:ID_LENGTH], rest[ID_LENGTH+1:], true
+}
+
+func randomString(length int) string {
+	b := make([]byte, length*5/8)
+	rand.Read(b)
+	return strings.ToLower(b32.EncodeToString(b))
+}
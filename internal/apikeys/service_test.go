@@ -0,0 +1,167 @@
+package apikeys
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mcgtrt/go-puerto/internal/accounts"
+	"github.com/mcgtrt/go-puerto/internal/auth"
+	"github.com/mcgtrt/go-puerto/utils"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/time/rate"
+)
+
+func newTestService(t *testing.T) (*Service, *accounts.User) {
+	users := accounts.NewMemoryStore()
+	user := &accounts.User{ID: "user", Email: "john@example.com", Name: "John"}
+	assert.NoError(t, users.CreateUser(context.Background(), user))
+	s := NewService(NewMemoryStore(), users, &utils.APIKeysConfig{Prefix: "shop", MaxPerUser: 2, RateLimitPerMinute: 60})
+	s.RegisterScope("orders.read", "Read orders", "orders:read")
+	return s, user
+}
+
+func TestCreateAndVerify(t *testing.T) {
+	s, user := newTestService(t)
+	ctx := context.Background()
+
+	created, err := s.Create(ctx, user.ID, " CI ", []string{"orders.read", "orders.read"}, DEFAULT_POLICY, 0)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(created.Plain, "shop_"+created.Key.ID+"_"), "Expected prefixed key")
+	assert.Equal(t, "CI", created.Key.Name)
+	assert.Equal(t, []string{"orders.read"}, created.Key.Scopes)
+	assert.NotContains(t, created.Key.SecretHash, created.Plain[len("shop_")+ID_LENGTH+1:], "Expected hashed secret")
+
+	p, err := s.Verify(ctx, created.Plain)
+	assert.NoError(t, err)
+	assert.Equal(t, user.ID, p.ID)
+	assert.Equal(t, auth.METHOD_API_KEY, p.Method)
+	assert.Equal(t, []string{"orders:read"}, p.Scopes, "Expected scopes mapped to permissions")
+
+	stored, _ := s.Store.GetKey(ctx, created.Key.ID)
+	assert.NotNil(t, stored.LastUsedAt, "Expected last use recorded")
+
+	for _, key := range []string{"", "shop_x", tamper(created.Plain), "api" + created.Plain[4:]} {
+		_, err := s.Verify(ctx, key)
+		assert.ErrorIs(t, err, auth.ErrInvalidCredentials, "Expected %q rejected", key)
+	}
+
+	assert.NoError(t, s.Revoke(ctx, user.ID, created.Key.ID))
+	_, err = s.Verify(ctx, created.Plain)
+	assert.ErrorIs(t, err, auth.ErrInvalidCredentials)
+}
+
+func TestCreateValidation(t *testing.T) {
+	s, user := newTestService(t)
+	ctx := context.Background()
+
+	_, err := s.Create(ctx, user.ID, "", []string{SCOPE_FULL}, DEFAULT_POLICY, 0)
+	assert.ErrorIs(t, err, ErrInvalidName)
+	_, err = s.Create(ctx, user.ID, "CI", nil, DEFAULT_POLICY, 0)
+	assert.ErrorIs(t, err, ErrInvalidScope)
+	_, err = s.Create(ctx, user.ID, "CI", []string{"admin"}, DEFAULT_POLICY, 0)
+	assert.ErrorIs(t, err, ErrInvalidScope)
+	_, err = s.Create(ctx, user.ID, "CI", []string{SCOPE_FULL}, "unlimited", 0)
+	assert.ErrorIs(t, err, ErrInvalidPolicy)
+
+	for range s.MaxPerUser {
+		_, err = s.Create(ctx, user.ID, "CI", []string{SCOPE_FULL}, DEFAULT_POLICY, 0)
+		assert.NoError(t, err)
+	}
+	_, err = s.Create(ctx, user.ID, "CI", []string{SCOPE_FULL}, DEFAULT_POLICY, 0)
+	assert.ErrorIs(t, err, ErrTooManyKeys)
+
+	assert.ErrorIs(t, s.Revoke(ctx, "other", "missing"), ErrKeyNotFound)
+}
+
+func TestExpiry(t *testing.T) {
+	s, user := newTestService(t)
+	ctx := context.Background()
+
+	created, err := s.Create(ctx, user.ID, "CI", []string{SCOPE_FULL}, DEFAULT_POLICY, time.Hour)
+	assert.NoError(t, err)
+	p, err := s.Verify(ctx, created.Plain)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"*"}, p.Scopes)
+
+	s.now = func() time.Time { return time.Now().Add(time.Hour) }
+	_, err = s.Verify(ctx, created.Plain)
+	assert.ErrorIs(t, err, auth.ErrInvalidCredentials)
+}
+
+func TestRateLimitPolicy(t *testing.T) {
+	s, user := newTestService(t)
+	created, err := s.Create(context.Background(), user.ID, "CI", []string{SCOPE_FULL}, DEFAULT_POLICY, 0)
+	assert.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(auth.API_KEY_HEADER, created.Plain)
+	assert.Nil(t, s.RateLimitPolicy(req), "Expected unverified key left to the global limiter")
+
+	_, err = s.Verify(context.Background(), created.Plain)
+	assert.NoError(t, err)
+	limiter := s.RateLimitPolicy(req)
+	assert.NotNil(t, limiter)
+	assert.Equal(t, 60, limiter.Burst())
+
+	forged := httptest.NewRequest(http.MethodGet, "/", nil)
+	forged.Header.Set(auth.API_KEY_HEADER, tamper(created.Plain))
+	assert.Nil(t, s.RateLimitPolicy(forged), "Expected wrong secret not using the limit of the key")
+
+	t.Run("Non-default policy", func(t *testing.T) {
+		s.Policies["partner"] = RateLimit{Rate: 10, Burst: 600}
+		partner, err := s.Create(context.Background(), user.ID, "Partner", []string{SCOPE_FULL}, "partner", 0)
+		assert.NoError(t, err)
+		assert.Equal(t, "partner", partner.Key.Policy)
+		_, err = s.Verify(context.Background(), partner.Plain)
+		assert.NoError(t, err)
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(auth.API_KEY_HEADER, partner.Plain)
+		partnerLimiter := s.RateLimitPolicy(req)
+		assert.NotNil(t, partnerLimiter)
+		assert.Equal(t, 600, partnerLimiter.Burst())
+		assert.Equal(t, rate.Limit(10), partnerLimiter.Limit())
+		assert.NotSame(t, limiter, partnerLimiter, "Expected own limiter of the key")
+	})
+}
+
+func TestLimiterEviction(t *testing.T) {
+	s, user := newTestService(t)
+	ctx := context.Background()
+
+	expiring, err := s.Create(ctx, user.ID, "Expiring", []string{SCOPE_FULL}, DEFAULT_POLICY, time.Hour)
+	assert.NoError(t, err)
+	deleted, err := s.Create(ctx, user.ID, "Deleted", []string{SCOPE_FULL}, DEFAULT_POLICY, 0)
+	assert.NoError(t, err)
+	for _, c := range []*Created{expiring, deleted} {
+		_, err = s.Verify(ctx, c.Plain)
+		assert.NoError(t, err)
+	}
+
+	assert.NoError(t, s.Store.DeleteKey(ctx, user.ID, deleted.Key.ID))
+	_, err = s.Verify(ctx, deleted.Plain)
+	assert.ErrorIs(t, err, auth.ErrInvalidCredentials)
+	_, ok := s.limiters.Load(deleted.Key.ID)
+	assert.False(t, ok, "Expected limiter of the deleted key evicted on verify")
+
+	s.now = func() time.Time { return time.Now().Add(time.Hour) }
+	fresh, err := s.Create(ctx, user.ID, "Fresh", []string{SCOPE_FULL}, DEFAULT_POLICY, 0)
+	assert.NoError(t, err)
+	_, err = s.Verify(ctx, fresh.Plain)
+	assert.NoError(t, err)
+	_, ok = s.limiters.Load(expiring.Key.ID)
+	assert.False(t, ok, "Expected limiter of the expired key dropped")
+}
+
+// Change the last character of the secret
+func tamper(key string) string {
+	last := "a"
+	if strings.HasSuffix(key, last) {
+		last = "b"
+	}
+	return key[:len(key)-1] + last
+}
@@ -1,27 +1,108 @@
 package app
 
 import (
-	"fmt"
+	"context"
+	"errors"
+	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
 	"strconv"
+	"syscall"
+	"time"
 
 	"github.com/mcgtrt/go-puerto/api"
+	"github.com/mcgtrt/go-puerto/internal"
+	"github.com/mcgtrt/go-puerto/internal/logging"
+	"github.com/mcgtrt/go-puerto/internal/tracing"
 	"github.com/mcgtrt/go-puerto/storage"
 	"github.com/mcgtrt/go-puerto/utils"
 )
 
+// Time given to requests and background work to finish on shutdown
+var SHUTDOWN_TIMEOUT = 30 * time.Second
+
+// Start the app and block until SIGINT or SIGTERM. The servers then stop
+// accepting connections and wait for open requests, the scheduler and the
+// job queue drain running work and queued emails are sent.
 func Run() {
 	config, err := utils.NewDefaultConfig()
 	if err != nil {
 		panic("configuration error: " + err.Error())
 	}
+	slog.SetDefault(logging.New(config.Logging, os.Stdout))
+	if config.Tracing != nil {
+		tp, err := tracing.Setup(config.Tracing)
+		if err != nil {
+			panic("tracing initialisation error: " + err.Error())
+		}
+		defer tp.Shutdown(context.Background())
+	}
+	translations := internal.NewTranslationManager()
+	if err := translations.LoadDir("locales"); err != nil {
+		panic("translations loading error: " + err.Error())
+	}
+	internal.SetDefaultTranslations(translations)
 	store, err := storage.NewStore(config)
 	if err != nil {
 		panic("store initialisation error:" + err.Error())
 	}
-	handler := api.NewHandler(store)
+	handler, err := api.NewHandler(store, config)
+	if err != nil {
+		panic("handler initialisation error: " + err.Error())
+	}
 	router := api.NewRouter(handler, config)
+	if handler.Jobs != nil {
+		// Register job handlers before starting the queue:
+		//
+		//	jobs.Register(handler.Jobs, "reports:build", buildReport)
+		handler.Jobs.Start()
+	}
+	if handler.Scheduler != nil {
+		// Add recurring tasks before starting the scheduler. Cleanup of
+		// expired sessions and tokens is added by the handler.
+		//
+		//	handler.Scheduler.Add("reports:daily", "0 3 * * *", sendDailyReport, scheduler.Jitter(time.Minute))
+		handler.Scheduler.Start()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
-	fmt.Println("http server running on port", config.HTTP.Port)
-	http.ListenAndServe(":"+strconv.Itoa(config.HTTP.Port), router)
+	servers := []*http.Server{{Addr: ":" + strconv.Itoa(config.HTTP.Port), Handler: router}}
+	if config.Metrics != nil && config.Metrics.Port != 0 {
+		admin := api.NewAdminRouter(config)
+		servers = append(servers, &http.Server{Addr: ":" + strconv.Itoa(config.Metrics.Port), Handler: admin})
+		slog.Info("admin server running", "port", config.Metrics.Port)
+	}
+	if handler.Events != nil {
+		// Event streams stay open until the broker disconnects them
+		servers[0].RegisterOnShutdown(handler.Events.Close)
+	}
+	if handler.Hub != nil {
+		// Hijacked WebSocket connections aren't closed by the server
+		servers[0].RegisterOnShutdown(handler.Hub.Close)
+	}
+	slog.Info("http server running", "port", config.HTTP.Port)
+	for _, server := range servers {
+		go func() {
+			if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				slog.Error("http server error", "addr", server.Addr, "error", err)
+				stop()
+			}
+		}()
+	}
+
+	<-ctx.Done()
+	slog.Info("shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), SHUTDOWN_TIMEOUT)
+	defer cancel()
+	for _, server := range servers {
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			slog.Error("http server shutdown error", "addr", server.Addr, "error", err)
+		}
+	}
+	if err := handler.Shutdown(shutdownCtx); err != nil {
+		slog.Error("background work shutdown error", "error", err)
+	}
 }
@@ -0,0 +1,186 @@
+package assets
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync/atomic"
+)
+
+// Cache policy of fingerprinted files (content can never change under the
+// same URL) and of files requested by their plain name
+const (
+	CACHE_CONTROL_IMMUTABLE  = "public, max-age=31536000, immutable"
+	CACHE_CONTROL_REVALIDATE = "no-cache"
+)
+
+// Extensions of precompressed siblings, they are fingerprinted
+// together with the file they were compressed from
+var precompressedExtensions = []string{".br", ".zst", ".gz"}
+
+type asset struct {
+	name string
+	hash string
+}
+
+// Maps logical asset names (e.g. "app.css") to content fingerprinted URLs
+// (e.g. "/static/app.3f2a1b9c.css") and serves both forms of the files
+type Manifest struct {
+	fsys        fs.FS
+	prefix      string
+	fingerprint bool
+	byName      map[string]asset
+	byHashed    map[string]string
+}
+
+// Load the manifest hashing every file of fsys. Prefix is the URL path the
+// files are served under. Without fingerprinting (e.g. in development with
+// files served from disk) URLs use plain names and files are revalidated
+// on every request. Go source files are never served.
+func Load(fsys fs.FS, prefix string, fingerprint bool) (*Manifest, error) {
+	m := &Manifest{
+		fsys:        fsys,
+		prefix:      "/" + strings.Trim(prefix, "/"),
+		fingerprint: fingerprint,
+		byName:      make(map[string]asset),
+		byHashed:    make(map[string]string),
+	}
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || path.Ext(name) == ".go" {
+			return err
+		}
+		hash, err := hashFile(fsys, name)
+		if err != nil {
+			return err
+		}
+		m.byName[name] = asset{name: name, hash: hash}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for name, a := range m.byName {
+		hashed := hashedName(name, m.sourceHash(name, a.hash))
+		m.byName[name] = asset{name: hashed, hash: a.hash}
+		m.byHashed[hashed] = name
+	}
+	return m, nil
+}
+
+// Returns the URL of the asset. Unknown assets resolve to their plain name
+func (m *Manifest) URL(name string) string {
+	name = strings.TrimPrefix(name, "/")
+	if a, ok := m.byName[name]; ok && m.fingerprint {
+		return m.prefix + "/" + a.name
+	}
+	return m.prefix + "/" + name
+}
+
+// Returns handler serving the assets under the manifest prefix. Next is the
+// handler serving the logical files (a file server, optionally wrapped with
+// precompression) - requests are rewritten to the logical name before.
+func (m *Manifest) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, m.prefix), "/")
+		cacheControl := CACHE_CONTROL_REVALIDATE
+		if logical, ok := m.byHashed[name]; ok && m.fingerprint {
+			name = logical
+			cacheControl = CACHE_CONTROL_IMMUTABLE
+		}
+		a, ok := m.byName[name]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Cache-Control", cacheControl)
+		w.Header().Set("ETag", `"`+a.hash+`"`)
+
+		r2 := new(http.Request)
+		*r2 = *r
+		r2.URL = new(url.URL)
+		*r2.URL = *r.URL
+		r2.URL.Path = "/" + name
+		r2.URL.RawPath = ""
+		next.ServeHTTP(w, r2)
+	})
+}
+
+// Returns the file system the manifest was loaded from with directories
+// hidden, so file servers built on it never render directory listings
+func (m *Manifest) FileSystem() http.FileSystem {
+	return noListingFS{http.FS(m.fsys)}
+}
+
+// Precompressed siblings share the fingerprint of their source file, so
+// "app.css.br" is served as "app.<hash>.css.br" next to "app.<hash>.css"
+func (m *Manifest) sourceHash(name, hash string) string {
+	for _, ext := range precompressedExtensions {
+		if source, ok := strings.CutSuffix(name, ext); ok {
+			if a, ok := m.byName[source]; ok {
+				return a.hash
+			}
+		}
+	}
+	return hash
+}
+
+func hashFile(fsys fs.FS, name string) (string, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16], nil
+}
+
+// Insert the hash before the first extension: css/app.min.css -> css/app.<hash>.min.css
+func hashedName(name, hash string) string {
+	dir, file := path.Split(name)
+	base, ext, found := strings.Cut(file, ".")
+	if !found || base == "" {
+		return dir + file + "." + hash
+	}
+	return dir + base + "." + hash + "." + ext
+}
+
+type noListingFS struct {
+	fs http.FileSystem
+}
+
+func (n noListingFS) Open(name string) (http.File, error) {
+	f, err := n.fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := f.Stat()
+	if err != nil || stat.IsDir() {
+		f.Close()
+		return nil, fs.ErrNotExist
+	}
+	return f, nil
+}
+
+var defaultManifest atomic.Pointer[Manifest]
+
+// Set the manifest used by URL. It's done once while mounting the file server
+func SetDefault(m *Manifest) {
+	defaultManifest.Store(m)
+}
+
+// Returns the URL of the asset from the default manifest. It's the helper
+// meant to be used in templ components, e.g. href={ assets.URL("app.css") }
+func URL(name string) string {
+	if m := defaultManifest.Load(); m != nil {
+		return m.URL(name)
+	}
+	return "/" + strings.TrimPrefix(name, "/")
+}
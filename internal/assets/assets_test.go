@@ -0,0 +1,78 @@
+package assets
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManifest(t *testing.T) {
+	fsys := fstest.MapFS{
+		"app.css":        {Data: []byte("body{}")},
+		"app.css.br":     {Data: []byte("compressed")},
+		"js/app.min.js":  {Data: []byte("console.log(1)")},
+		"static.go":      {Data: []byte("package static")},
+		"img/.gitignore": {Data: []byte("")},
+	}
+	m, err := Load(fsys, "/static/", true)
+	assert.NoError(t, err)
+
+	css := m.URL("app.css")
+	assert.Regexp(t, `^/static/app\.[0-9a-f]{16}\.css$`, css, "Expected fingerprinted URL")
+	assert.Equal(t, css+".br", m.URL("app.css.br"), "Expected precompressed sibling to share the fingerprint")
+	assert.Regexp(t, `^/static/js/app\.[0-9a-f]{16}\.min\.js$`, m.URL("js/app.min.js"))
+	assert.Equal(t, "/static/missing.css", m.URL("missing.css"), "Expected unknown asset to keep its name")
+
+	files := http.FileServer(m.FileSystem())
+	handler := m.Handler(files)
+
+	t.Run("Serves fingerprinted file", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, css, nil))
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "body{}", rec.Body.String())
+		assert.Equal(t, CACHE_CONTROL_IMMUTABLE, rec.Header().Get("Cache-Control"))
+		assert.NotEmpty(t, rec.Header().Get("ETag"))
+	})
+
+	t.Run("Revalidates with ETag", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/static/app.css", nil))
+		etag := rec.Header().Get("ETag")
+
+		req := httptest.NewRequest(http.MethodGet, "/static/app.css", nil)
+		req.Header.Set("If-None-Match", etag)
+		rec = httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusNotModified, rec.Code)
+	})
+
+	t.Run("Never serves Go sources", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/static/static.go", nil))
+
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+
+	t.Run("Development manifest uses plain names", func(t *testing.T) {
+		dev, err := Load(fsys, "static", false)
+		assert.NoError(t, err)
+		assert.Equal(t, "/static/app.css", dev.URL("app.css"))
+
+		rec := httptest.NewRecorder()
+		dev.Handler(http.FileServer(dev.FileSystem())).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, css, nil))
+		assert.Equal(t, http.StatusNotFound, rec.Code, "Expected fingerprinted URLs unknown in development")
+	})
+
+	t.Run("Default manifest", func(t *testing.T) {
+		assert.Equal(t, "/app.css", URL("app.css"), "Expected plain URL without manifest")
+		SetDefault(m)
+		defer defaultManifest.Store(nil)
+		assert.Equal(t, css, URL("app.css"))
+	})
+}
@@ -0,0 +1,125 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/mcgtrt/go-puerto/internal/accounts"
+	"github.com/mcgtrt/go-puerto/internal/session"
+	"github.com/mcgtrt/go-puerto/types"
+)
+
+// Authentication methods the principal was resolved with
+const (
+	METHOD_SESSION = "session"
+	METHOD_BEARER  = "bearer"
+	METHOD_API_KEY = "api_key"
+)
+
+// Header carrying API keys
+const API_KEY_HEADER = "X-API-Key"
+
+// Returned by strategies when the request carries credentials of their
+// kind which are invalid (e.g. expired token). Requests without such
+// credentials are not an error.
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// Authenticated user (or service) making the request
+type Principal struct {
+	ID     string
+	Email  string
+	Name   string
+	Method string
+	// Scopes granted to tokens and API keys. Empty for session logins,
+	// which act with all permissions of the user.
+	Scopes []string
+}
+
+// Resolves the principal from the request. Returns nil principal and nil
+// error when the request has no credentials handled by the strategy, so
+// the next strategy of the chain is tried.
+type Strategy interface {
+	Authenticate(r *http.Request) (*Principal, error)
+}
+
+// Adapter allowing ordinary functions to be used as strategies
+type StrategyFunc func(r *http.Request) (*Principal, error)
+
+func (f StrategyFunc) Authenticate(r *http.Request) (*Principal, error) {
+	return f(r)
+}
+
+// Authenticates users logged in with the session. Sessions of deleted
+// users are treated as anonymous.
+type SessionStrategy struct {
+	Users accounts.UserStore
+}
+
+func (s SessionStrategy) Authenticate(r *http.Request) (*Principal, error) {
+	sess := session.FromContext(r.Context())
+	if sess == nil || sess.UserID == "" {
+		return nil, nil
+	}
+	user, err := s.Users.GetUserByID(r.Context(), sess.UserID)
+	if errors.Is(err, accounts.ErrUserNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Principal{ID: user.ID, Email: user.Email, Name: user.Name, Method: METHOD_SESSION}, nil
+}
+
+// Authenticates requests with "Authorization: Bearer <token>" header
+// using the verifier (e.g. JWT validation)
+type BearerStrategy struct {
+	Verify func(ctx context.Context, token string) (*Principal, error)
+}
+
+func (s BearerStrategy) Authenticate(r *http.Request) (*Principal, error) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return nil, nil
+	}
+	if token = strings.TrimSpace(token); token == "" {
+		return nil, ErrInvalidCredentials
+	}
+	return s.Verify(r.Context(), token)
+}
+
+// Authenticates requests with API key in the X-API-Key header
+type APIKeyStrategy struct {
+	Verify func(ctx context.Context, key string) (*Principal, error)
+}
+
+func (s APIKeyStrategy) Authenticate(r *http.Request) (*Principal, error) {
+	key := r.Header.Get(API_KEY_HEADER)
+	if key == "" {
+		return nil, nil
+	}
+	return s.Verify(r.Context(), key)
+}
+
+// Try strategies in order and return the first resolved principal
+func Authenticate(r *http.Request, strategies ...Strategy) (*Principal, error) {
+	for _, s := range strategies {
+		p, err := s.Authenticate(r)
+		if err != nil || p != nil {
+			return p, err
+		}
+	}
+	return nil, nil
+}
+
+// Return the principal of the request or nil for anonymous requests
+func FromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(types.PrincipalCtxKey{}).(*Principal)
+	return p
+}
+
+// Store the principal in the context
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, types.PrincipalCtxKey{}, p)
+}
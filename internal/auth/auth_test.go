@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mcgtrt/go-puerto/internal/accounts"
+	"github.com/mcgtrt/go-puerto/internal/session"
+	"github.com/mcgtrt/go-puerto/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStrategies(t *testing.T) {
+	ctx := context.Background()
+	users := accounts.NewMemoryStore()
+	assert.NoError(t, users.CreateUser(ctx, &accounts.User{ID: "u1", Email: "john@example.com", Name: "John"}))
+
+	bearer := BearerStrategy{Verify: func(ctx context.Context, token string) (*Principal, error) {
+		if token != "good" {
+			return nil, ErrInvalidCredentials
+		}
+		return &Principal{ID: "u2", Method: METHOD_BEARER, Scopes: []string{"orders:read"}}, nil
+	}}
+	apiKey := APIKeyStrategy{Verify: func(ctx context.Context, key string) (*Principal, error) {
+		return &Principal{ID: "u3", Method: METHOD_API_KEY}, nil
+	}}
+	chain := []Strategy{SessionStrategy{Users: users}, bearer, apiKey}
+
+	withSession := func(r *http.Request, userID string) *http.Request {
+		manager := session.NewManager(session.NewMemoryStore(), &utils.SessionConfig{CookieName: "session", IdleTimeout: time.Hour, AbsoluteTimeout: time.Hour}, false)
+		s, _ := manager.Load(r)
+		s.UserID = userID
+		return r.WithContext(session.WithSession(r.Context(), s))
+	}
+
+	t.Run("Anonymous request", func(t *testing.T) {
+		p, err := Authenticate(httptest.NewRequest(http.MethodGet, "/", nil), chain...)
+		assert.NoError(t, err)
+		assert.Nil(t, p)
+	})
+
+	t.Run("Session user", func(t *testing.T) {
+		p, err := Authenticate(withSession(httptest.NewRequest(http.MethodGet, "/", nil), "u1"), chain...)
+		assert.NoError(t, err)
+		assert.Equal(t, &Principal{ID: "u1", Email: "john@example.com", Name: "John", Method: METHOD_SESSION}, p)
+	})
+
+	t.Run("Session of deleted user is anonymous", func(t *testing.T) {
+		p, err := Authenticate(withSession(httptest.NewRequest(http.MethodGet, "/", nil), "deleted"), chain...)
+		assert.NoError(t, err)
+		assert.Nil(t, p)
+	})
+
+	t.Run("Bearer token", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Authorization", "Bearer good")
+		p, err := Authenticate(r, chain...)
+		assert.NoError(t, err)
+		assert.Equal(t, "u2", p.ID)
+
+		r.Header.Set("Authorization", "bearer bad")
+		_, err = Authenticate(r, chain...)
+		assert.ErrorIs(t, err, ErrInvalidCredentials)
+
+		r.Header.Set("Authorization", "Basic dXNlcjpwYXNz")
+		p, err = Authenticate(r, chain...)
+		assert.NoError(t, err)
+		assert.Nil(t, p, "Expected other schemes ignored")
+	})
+
+	t.Run("API key", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set(API_KEY_HEADER, "key")
+		p, err := Authenticate(r, chain...)
+		assert.NoError(t, err)
+		assert.Equal(t, METHOD_API_KEY, p.Method)
+	})
+
+	t.Run("Context", func(t *testing.T) {
+		assert.Nil(t, FromContext(ctx))
+		p := &Principal{ID: "u1"}
+		assert.Equal(t, p, FromContext(WithPrincipal(ctx, p)))
+	})
+}
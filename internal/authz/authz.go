@@ -0,0 +1,159 @@
+package authz
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/mcgtrt/go-puerto/internal/auth"
+	"github.com/mcgtrt/go-puerto/internal/logging"
+	"github.com/mcgtrt/go-puerto/types"
+)
+
+// Grants every action of a resource ("orders:*") or everything ("*")
+const WILDCARD = "*"
+
+var ErrRoleNotFound = errors.New("role not found")
+
+// Named set of permissions. Permissions have the "resource:action" form
+// (e.g. "orders:write") and may use wildcards.
+type Role struct {
+	Name        string   `json:"name" bson:"_id"`
+	Permissions []string `json:"permissions" bson:"permissions"`
+}
+
+// Persistence of roles and their assignments to users
+type PolicyStore interface {
+	// Create or replace the role
+	SaveRole(ctx context.Context, r *Role) error
+	GetRole(ctx context.Context, name string) (*Role, error)
+	ListRoles(ctx context.Context) ([]Role, error)
+	// Delete the role together with its assignments
+	DeleteRole(ctx context.Context, name string) error
+	AssignRole(ctx context.Context, userID, role string) error
+	UnassignRole(ctx context.Context, userID, role string) error
+	UserRoles(ctx context.Context, userID string) ([]string, error)
+	// Permissions granted to the user by all assigned roles
+	UserPermissions(ctx context.Context, userID string) ([]string, error)
+}
+
+// Check if the granted permission covers the requested one
+func Matches(granted, permission string) bool {
+	if granted == WILDCARD || granted == permission {
+		return true
+	}
+	resource, found := strings.CutSuffix(granted, ":"+WILDCARD)
+	return found && strings.HasPrefix(permission, resource+":")
+}
+
+// Result of a policy
+type Decision int
+
+const (
+	// Leave the decision to roles
+	ABSTAIN Decision = iota
+	// Allow even without the permission (e.g. owner of the resource)
+	ALLOW
+	// Deny even with the permission (e.g. archived resource)
+	DENY
+)
+
+// Attribute based rule evaluated for the permission. Principal is nil for
+// anonymous requests and resource is nil for route-level checks.
+type Policy func(ctx context.Context, p *auth.Principal, resource any) Decision
+
+// Decides whether principals may perform actions. Roles grant
+// permissions, policies registered for the permission may override the
+// decision for the given resource - any DENY wins over ALLOW. Principals
+// with scopes (tokens, API keys) are additionally limited to their scopes.
+type Authorizer struct {
+	Store    PolicyStore
+	mu       sync.RWMutex
+	policies map[string][]Policy
+}
+
+func NewAuthorizer(store PolicyStore) *Authorizer {
+	return &Authorizer{
+		Store:    store,
+		policies: make(map[string][]Policy),
+	}
+}
+
+// Register policy evaluated for the exact permission
+func (a *Authorizer) Register(permission string, policy Policy) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.policies[permission] = append(a.policies[permission], policy)
+}
+
+// Permissions granted to the principal by its roles
+func (a *Authorizer) Permissions(ctx context.Context, p *auth.Principal) ([]string, error) {
+	if p == nil {
+		return nil, nil
+	}
+	return a.Store.UserPermissions(ctx, p.ID)
+}
+
+// Decide with the already resolved permissions of the principal
+func (a *Authorizer) Decide(ctx context.Context, p *auth.Principal, granted []string, action string, resource any) bool {
+	if p != nil && len(p.Scopes) > 0 && !matchesAny(p.Scopes, action) {
+		return false
+	}
+	a.mu.RLock()
+	policies := a.policies[action]
+	a.mu.RUnlock()
+
+	allowed := matchesAny(granted, action)
+	for _, policy := range policies {
+		switch policy(ctx, p, resource) {
+		case DENY:
+			return false
+		case ALLOW:
+			allowed = true
+		}
+	}
+	return allowed
+}
+
+func matchesAny(granted []string, permission string) bool {
+	for _, g := range granted {
+		if Matches(g, permission) {
+			return true
+		}
+	}
+	return false
+}
+
+// Authorizer bound to the request. Permissions are loaded once on the
+// first check and reused by every later check of the request.
+type requestAuthorizer struct {
+	authorizer *Authorizer
+	once       sync.Once
+	granted    []string
+	err        error
+}
+
+func WithAuthorizer(ctx context.Context, a *Authorizer) context.Context {
+	return context.WithValue(ctx, types.AuthorizerCtxKey{}, &requestAuthorizer{authorizer: a})
+}
+
+// Check if the principal of the request may perform the action on the
+// resource (nil for checks without a resource). Fails closed - without
+// the authorizer in the context or when loading permissions fails the
+// action is denied.
+func Can(ctx context.Context, action string, resource any) bool {
+	ra, ok := ctx.Value(types.AuthorizerCtxKey{}).(*requestAuthorizer)
+	if !ok {
+		return false
+	}
+	p := auth.FromContext(ctx)
+	ra.once.Do(func() {
+		ra.granted, ra.err = ra.authorizer.Permissions(ctx, p)
+	})
+	if ra.err != nil {
+		logging.FromContext(ctx).Error("loading permissions failed", "error", ra.err)
+		return false
+	}
+	return ra.authorizer.Decide(ctx, p, ra.granted, action, resource)
+}
@@ -0,0 +1,125 @@
+package authz
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/mcgtrt/go-puerto/internal/auth"
+	"github.com/stretchr/testify/assert"
+)
+
+type order struct {
+	OwnerID  string
+	Archived bool
+}
+
+type failingStore struct {
+	*MemoryStore
+	calls int
+}
+
+func (s *failingStore) UserPermissions(ctx context.Context, userID string) ([]string, error) {
+	s.calls++
+	return nil, errors.New("database down")
+}
+
+func TestMatches(t *testing.T) {
+	assert.True(t, Matches("orders:write", "orders:write"))
+	assert.True(t, Matches("orders:*", "orders:write"))
+	assert.True(t, Matches("*", "orders:write"))
+	assert.False(t, Matches("orders:read", "orders:write"))
+	assert.False(t, Matches("orders:*", "ordersx:write"))
+	assert.False(t, Matches("order*", "orders:write"))
+}
+
+func TestAuthorizer(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	assert.NoError(t, store.SaveRole(ctx, &Role{Name: "admin", Permissions: []string{"*"}}))
+	assert.NoError(t, store.SaveRole(ctx, &Role{Name: "clerk", Permissions: []string{"orders:read", "orders:write"}}))
+	assert.NoError(t, store.AssignRole(ctx, "admin", "admin"))
+	assert.NoError(t, store.AssignRole(ctx, "clerk", "clerk"))
+	assert.ErrorIs(t, store.AssignRole(ctx, "clerk", "missing"), ErrRoleNotFound)
+
+	a := NewAuthorizer(store)
+	// Customers may cancel their own orders, nobody may cancel archived ones
+	a.Register("orders:cancel", func(ctx context.Context, p *auth.Principal, resource any) Decision {
+		o, ok := resource.(*order)
+		switch {
+		case !ok:
+			return ABSTAIN
+		case o.Archived:
+			return DENY
+		case p != nil && o.OwnerID == p.ID:
+			return ALLOW
+		}
+		return ABSTAIN
+	})
+
+	can := func(p *auth.Principal, action string, resource any) bool {
+		ctx := WithAuthorizer(ctx, a)
+		if p != nil {
+			ctx = auth.WithPrincipal(ctx, p)
+		}
+		return Can(ctx, action, resource)
+	}
+	admin, clerk, customer := &auth.Principal{ID: "admin"}, &auth.Principal{ID: "clerk"}, &auth.Principal{ID: "customer"}
+
+	t.Run("Roles", func(t *testing.T) {
+		assert.True(t, can(clerk, "orders:write", nil))
+		assert.False(t, can(clerk, "users:write", nil))
+		assert.True(t, can(admin, "users:write", nil))
+		assert.False(t, can(customer, "orders:read", nil))
+		assert.False(t, can(nil, "orders:read", nil))
+	})
+
+	t.Run("Policies", func(t *testing.T) {
+		own := &order{OwnerID: "customer"}
+		assert.True(t, can(customer, "orders:cancel", own), "Expected owner allowed")
+		assert.False(t, can(customer, "orders:cancel", &order{OwnerID: "other"}))
+		assert.True(t, can(admin, "orders:cancel", &order{OwnerID: "other"}))
+		assert.False(t, can(admin, "orders:cancel", &order{Archived: true}), "Expected deny to win")
+	})
+
+	t.Run("Scopes limit tokens", func(t *testing.T) {
+		token := &auth.Principal{ID: "admin", Method: auth.METHOD_BEARER, Scopes: []string{"orders:*"}}
+		assert.True(t, can(token, "orders:write", nil))
+		assert.False(t, can(token, "users:write", nil), "Expected scope to cap admin role")
+	})
+
+	t.Run("Fails closed", func(t *testing.T) {
+		assert.False(t, Can(auth.WithPrincipal(ctx, admin), "orders:read", nil), "Expected deny without authorizer")
+
+		failing := &failingStore{MemoryStore: store}
+		ctx := auth.WithPrincipal(WithAuthorizer(ctx, NewAuthorizer(failing)), admin)
+		assert.False(t, Can(ctx, "orders:read", nil))
+		assert.False(t, Can(ctx, "orders:write", nil))
+		assert.Equal(t, 1, failing.calls, "Expected permissions loaded once per request")
+	})
+}
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	assert.NoError(t, store.SaveRole(ctx, &Role{Name: "editor", Permissions: []string{"posts:write", "posts:read"}}))
+	assert.NoError(t, store.SaveRole(ctx, &Role{Name: "viewer", Permissions: []string{"posts:read"}}))
+	assert.NoError(t, store.AssignRole(ctx, "u1", "editor"))
+	assert.NoError(t, store.AssignRole(ctx, "u1", "viewer"))
+	assert.NoError(t, store.AssignRole(ctx, "u1", "viewer"))
+
+	roles, _ := store.UserRoles(ctx, "u1")
+	assert.Equal(t, []string{"editor", "viewer"}, roles)
+	permissions, _ := store.UserPermissions(ctx, "u1")
+	assert.ElementsMatch(t, []string{"posts:write", "posts:read"}, permissions)
+
+	assert.NoError(t, store.DeleteRole(ctx, "editor"))
+	roles, _ = store.UserRoles(ctx, "u1")
+	assert.Equal(t, []string{"viewer"}, roles, "Expected assignments removed with the role")
+	_, err := store.GetRole(ctx, "editor")
+	assert.ErrorIs(t, err, ErrRoleNotFound)
+
+	assert.NoError(t, store.UnassignRole(ctx, "u1", "viewer"))
+	permissions, _ = store.UserPermissions(ctx, "u1")
+	assert.Empty(t, permissions)
+}
@@ -0,0 +1,100 @@
+package authz
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"sync"
+)
+
+// Keeps roles and assignments in the process memory. Useful for tests
+// and prototyping.
+type MemoryStore struct {
+	mu          sync.Mutex
+	roles       map[string]Role
+	assignments map[string][]string
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		roles:       make(map[string]Role),
+		assignments: make(map[string][]string),
+	}
+}
+
+func (m *MemoryStore) SaveRole(ctx context.Context, r *Role) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.roles[r.Name] = Role{Name: r.Name, Permissions: slices.Clone(r.Permissions)}
+	return nil
+}
+
+func (m *MemoryStore) GetRole(ctx context.Context, name string) (*Role, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r, ok := m.roles[name]
+	if !ok {
+		return nil, ErrRoleNotFound
+	}
+	return &r, nil
+}
+
+func (m *MemoryStore) ListRoles(ctx context.Context) ([]Role, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	roles := make([]Role, 0, len(m.roles))
+	for _, r := range m.roles {
+		roles = append(roles, r)
+	}
+	sort.Slice(roles, func(i, j int) bool { return roles[i].Name < roles[j].Name })
+	return roles, nil
+}
+
+func (m *MemoryStore) DeleteRole(ctx context.Context, name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.roles, name)
+	for userID, roles := range m.assignments {
+		m.assignments[userID] = slices.DeleteFunc(roles, func(r string) bool { return r == name })
+	}
+	return nil
+}
+
+func (m *MemoryStore) AssignRole(ctx context.Context, userID, role string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.roles[role]; !ok {
+		return ErrRoleNotFound
+	}
+	if !slices.Contains(m.assignments[userID], role) {
+		m.assignments[userID] = append(m.assignments[userID], role)
+	}
+	return nil
+}
+
+func (m *MemoryStore) UnassignRole(ctx context.Context, userID, role string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.assignments[userID] = slices.DeleteFunc(m.assignments[userID], func(r string) bool { return r == role })
+	return nil
+}
+
+func (m *MemoryStore) UserRoles(ctx context.Context, userID string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Clone(m.assignments[userID]), nil
+}
+
+func (m *MemoryStore) UserPermissions(ctx context.Context, userID string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var permissions []string
+	for _, name := range m.assignments[userID] {
+		for _, p := range m.roles[name].Permissions {
+			if !slices.Contains(permissions, p) {
+				permissions = append(permissions, p)
+			}
+		}
+	}
+	return permissions, nil
+}
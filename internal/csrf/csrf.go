@@ -0,0 +1,108 @@
+package csrf
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"strings"
+
+	"github.com/mcgtrt/go-puerto/types"
+)
+
+// Names under which the token travels between the server and the browser
+const (
+	COOKIE_NAME = "csrf"
+	HEADER_NAME = "X-CSRF-Token"
+	FIELD_NAME  = "csrf_token"
+)
+
+const tokenLength = 32
+
+var encoding = base64.RawURLEncoding
+
+// Issues and verifies signed double-submit tokens. The random secret lives
+// in a cookie signed with the server key, so it can't be planted by a
+// sibling subdomain. Pages receive the secret masked with a fresh one-time
+// pad on every request, which keeps it safe from BREACH-style attacks on
+// compressed responses.
+type Protector struct {
+	key []byte
+}
+
+// Create protector with the signing key derived from the application secret
+func New(secret []byte) *Protector {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("csrf"))
+	return &Protector{key: mac.Sum(nil)}
+}
+
+// Generate new random secret and its signed cookie value
+func (p *Protector) NewCookie() (secret []byte, cookie string, err error) {
+	secret = make([]byte, tokenLength)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, "", err
+	}
+	return secret, encoding.EncodeToString(secret) + "." + encoding.EncodeToString(p.sign(secret)), nil
+}
+
+// Return the secret stored in the signed cookie value
+func (p *Protector) ParseCookie(cookie string) ([]byte, bool) {
+	value, signature, ok := strings.Cut(cookie, ".")
+	if !ok {
+		return nil, false
+	}
+	secret, err := encoding.DecodeString(value)
+	if err != nil || len(secret) != tokenLength {
+		return nil, false
+	}
+	sig, err := encoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(sig, p.sign(secret)) {
+		return nil, false
+	}
+	return secret, true
+}
+
+func (p *Protector) sign(secret []byte) []byte {
+	mac := hmac.New(sha256.New, p.key)
+	mac.Write(secret)
+	return mac.Sum(nil)
+}
+
+// Mask the secret with a one-time pad to get the token rendered in pages
+func Mask(secret []byte) string {
+	token := make([]byte, 2*len(secret))
+	pad := token[:len(secret)]
+	rand.Read(pad)
+	for i := range secret {
+		token[len(secret)+i] = secret[i] ^ pad[i]
+	}
+	return encoding.EncodeToString(token)
+}
+
+// Check whether the submitted token was masked from the given secret
+func Verify(secret []byte, token string) bool {
+	raw, err := encoding.DecodeString(token)
+	if err != nil || len(raw) != 2*len(secret) {
+		return false
+	}
+	unmasked := make([]byte, len(secret))
+	for i := range unmasked {
+		unmasked[i] = raw[i] ^ raw[len(secret)+i]
+	}
+	return subtle.ConstantTimeCompare(unmasked, secret) == 1
+}
+
+// Store the masked token of the request in the context
+func WithToken(ctx context.Context, token string) context.Context {
+	return context.WithValue(ctx, types.CSRFTokenCtxKey{}, token)
+}
+
+// Return the masked token of the request to be rendered into
+// forms or sent back in the X-CSRF-Token header
+func Token(ctx context.Context) string {
+	token, _ := ctx.Value(types.CSRFTokenCtxKey{}).(string)
+	return token
+}
@@ -0,0 +1,53 @@
+package csrf
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProtector(t *testing.T) {
+	p := New([]byte("0123456789abcdef0123456789abcdef"))
+
+	secret, cookie, err := p.NewCookie()
+	assert.NoError(t, err)
+
+	t.Run("Parses signed cookie", func(t *testing.T) {
+		parsed, ok := p.ParseCookie(cookie)
+		assert.True(t, ok)
+		assert.Equal(t, secret, parsed)
+	})
+
+	t.Run("Rejects cookies signed with another key", func(t *testing.T) {
+		_, forged, err := New([]byte("another secret")).NewCookie()
+		assert.NoError(t, err)
+		_, ok := p.ParseCookie(forged)
+		assert.False(t, ok)
+
+		for _, value := range []string{"", "abc", "abc.def", cookie + "x"} {
+			_, ok := p.ParseCookie(value)
+			assert.False(t, ok, "Expected %q rejected", value)
+		}
+	})
+
+	t.Run("Masked tokens differ but verify", func(t *testing.T) {
+		first, second := Mask(secret), Mask(secret)
+		assert.NotEqual(t, first, second, "Expected fresh one-time pad")
+		assert.True(t, Verify(secret, first))
+		assert.True(t, Verify(secret, second))
+	})
+
+	t.Run("Rejects foreign tokens", func(t *testing.T) {
+		other, _, err := p.NewCookie()
+		assert.NoError(t, err)
+		assert.False(t, Verify(secret, Mask(other)))
+		assert.False(t, Verify(secret, ""))
+		assert.False(t, Verify(secret, "not-a-token"))
+	})
+
+	t.Run("Stores token in context", func(t *testing.T) {
+		assert.Empty(t, Token(context.Background()))
+		assert.Equal(t, "token", Token(WithToken(context.Background(), "token")))
+	})
+}
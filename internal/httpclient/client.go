@@ -0,0 +1,44 @@
+package httpclient
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/mcgtrt/go-puerto/utils"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// Default timeout of the clients created with New
+const DEFAULT_TIMEOUT = 30 * time.Second
+
+// Round tripper propagating the request ID and trace context found in the
+// outgoing request's context, so calls to other services can be correlated
+// with the request that triggered them
+type Transport struct {
+	Base            http.RoundTripper
+	RequestIDHeader string
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	// Round trippers must not modify the original request
+	req = req.Clone(req.Context())
+	if id := utils.GetRequestID(req.Context()); id != "" && req.Header.Get(t.RequestIDHeader) == "" {
+		req.Header.Set(t.RequestIDHeader, id)
+	}
+	otel.GetTextMapPropagator().Inject(req.Context(), propagation.HeaderCarrier(req.Header))
+	return base.RoundTrip(req)
+}
+
+// Create HTTP client for outbound calls. Always build requests with
+// http.NewRequestWithContext passing the handler's context.
+func New(requestIDHeader string) *http.Client {
+	return &http.Client{
+		Timeout:   DEFAULT_TIMEOUT,
+		Transport: &Transport{RequestIDHeader: requestIDHeader},
+	}
+}
@@ -0,0 +1,36 @@
+package httpclient
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mcgtrt/go-puerto/internal/tracing"
+	"github.com/mcgtrt/go-puerto/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClient(t *testing.T) {
+	tracing.SetupInMemory()
+
+	var received http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Clone()
+	}))
+	defer server.Close()
+
+	ctx, span := tracing.Tracer().Start(context.Background(), "test")
+	defer span.End()
+	ctx = context.WithValue(ctx, types.RequestIDCtxKey{}, "req-1")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+	assert.NoError(t, err)
+	resp, err := New("X-Request-ID").Do(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Equal(t, "req-1", received.Get("X-Request-ID"), "Expected request ID propagated")
+	assert.Contains(t, received.Get("traceparent"), span.SpanContext().TraceID().String(), "Expected trace context propagated")
+	assert.Empty(t, req.Header.Get("X-Request-ID"), "Expected original request untouched")
+}
@@ -0,0 +1,81 @@
+package images
+
+import (
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"sync"
+
+	// Decoders of the formats uploaded images are read from
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+// Encodes the image, quality (1-100) applies to lossy formats
+type Encoder func(w io.Writer, img image.Image, quality int) error
+
+// Output format of derived images
+type Format struct {
+	Name        string
+	ContentType string
+	Encode      Encoder
+}
+
+const (
+	FORMAT_JPEG = "jpeg"
+	FORMAT_PNG  = "png"
+	FORMAT_GIF  = "gif"
+)
+
+var (
+	formatsMu sync.RWMutex
+	formats   = map[string]Format{
+		FORMAT_JPEG: {Name: FORMAT_JPEG, ContentType: "image/jpeg", Encode: func(w io.Writer, img image.Image, quality int) error {
+			return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+		}},
+		FORMAT_PNG: {Name: FORMAT_PNG, ContentType: "image/png", Encode: func(w io.Writer, img image.Image, quality int) error {
+			return png.Encode(w, img)
+		}},
+		FORMAT_GIF: {Name: FORMAT_GIF, ContentType: "image/gif", Encode: func(w io.Writer, img image.Image, quality int) error {
+			return gif.Encode(w, img, nil)
+		}},
+	}
+	// Formats registered besides the built-in ones, offered as <source>
+	// elements of pictures in the order registered
+	modern []string
+)
+
+// Register an output format, e.g. "webp" or "avif". Go has no pure Go
+// encoders of them, register a binding of libwebp or libavif to serve
+// them. Pictures offer registered formats to browsers before the
+// JPEG or PNG fallback.
+func RegisterFormat(name, contentType string, encode Encoder) {
+	formatsMu.Lock()
+	defer formatsMu.Unlock()
+	if _, ok := formats[name]; !ok {
+		modern = append(modern, name)
+	}
+	formats[name] = Format{Name: name, ContentType: contentType, Encode: encode}
+}
+
+// Returns false if no encoder of the format is registered
+func LookupFormat(name string) (Format, bool) {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+	f, ok := formats[name]
+	return f, ok
+}
+
+// Registered formats besides JPEG, PNG and GIF
+func ModernFormats() []Format {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+	list := make([]Format, len(modern))
+	for i, name := range modern {
+		list[i] = formats[name]
+	}
+	return list
+}
@@ -0,0 +1,343 @@
+package images
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"io"
+	"log/slog"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/disintegration/imaging"
+	"github.com/mcgtrt/go-puerto/internal/uploads"
+	"github.com/mcgtrt/go-puerto/utils"
+	"golang.org/x/sync/singleflight"
+)
+
+// Path of the image URLs, serve it with the image handler. The size and
+// the key of the uploaded image follow, e.g. /img/640x480/01J0ABC.
+const IMAGE_PATH = "/img/"
+
+// Key prefix of derived images in the blob store, followed by the key of
+// the upload. They are made again when missing and never served once the
+// upload is deleted, but stay in the store. Expire them with a lifecycle
+// rule of the prefix (e.g. a few days after creation).
+const DERIVED_PREFIX = "derived/"
+
+// How the image is fitted into the requested size when both sides are set
+const (
+	// Fill the whole size, cropping the overflowing sides around the centre
+	FIT_COVER = "cover"
+	// Fit the whole image into the size keeping its aspect ratio
+	FIT_CONTAIN = "contain"
+)
+
+var (
+	ErrInvalidParams    = errors.New("invalid image parameters")
+	ErrInvalidSignature = errors.New("image url signature is invalid or expired")
+	ErrNotImage         = errors.New("file is not a supported image")
+	ErrTooManyPixels    = errors.New("image has too many pixels to process")
+)
+
+// Transformation of an uploaded image. A zero Width or Height keeps the
+// aspect ratio of the image. Empty Format keeps JPEG images JPEG and
+// converts other images to PNG, zero Quality uses the default quality.
+type Params struct {
+	Width   int
+	Height  int
+	Fit     string
+	Format  string
+	Quality int
+}
+
+// Resizes, crops and converts uploaded images on request. Images are
+// decoded with their EXIF orientation applied and encoded without any
+// metadata, so locations and camera details of photos never leak. Derived
+// images are kept in the blob store next to the uploads.
+type Service struct {
+	Uploads      *uploads.Service
+	MaxDimension int
+	MaxPixels    int
+	Quality      int
+	SrcSetWidths []int
+
+	group singleflight.Group
+	now   func() time.Time
+}
+
+func NewService(service *uploads.Service, cfg *utils.ImagesConfig) *Service {
+	return &Service{
+		Uploads:      service,
+		MaxDimension: cfg.MaxDimension,
+		MaxPixels:    cfg.MaxPixels,
+		Quality:      cfg.Quality,
+		SrcSetWidths: cfg.SrcSetWidths,
+		now:          time.Now,
+	}
+}
+
+// Signed URL of the uploaded image transformed with the params. Like the
+// download links, URLs expire after the URLTTL of the uploads and can't
+// be changed to request other sizes. The expiry is rounded up to the end
+// of the next URLTTL period, so pages rendered within a period link the
+// same URLs and browsers reuse the images they cached.
+func (s *Service) URL(key string, p Params) string {
+	ttl := max(int64(s.Uploads.URLTTL/time.Second), 1)
+	expires := strconv.FormatInt((s.now().Unix()/ttl+2)*ttl, 10)
+	query := p.query()
+	query.Set("expires", expires)
+	query.Set("s", s.Uploads.Sign(signedValue(key, p, expires)))
+	return IMAGE_PATH + size(p) + "/" + key + "?" + query.Encode()
+}
+
+// Parse and verify the params of an image URL. Size is the path segment
+// after IMAGE_PATH, e.g. 640x480. Returns the expiry time of the URL.
+func (s *Service) Parse(key, sizeParam string, query url.Values) (Params, time.Time, error) {
+	var p Params
+	w, h, ok := strings.Cut(sizeParam, "x")
+	if !ok {
+		return p, time.Time{}, ErrInvalidParams
+	}
+	var err error
+	if p.Width, err = strconv.Atoi(w); err != nil {
+		return p, time.Time{}, ErrInvalidParams
+	}
+	if p.Height, err = strconv.Atoi(h); err != nil {
+		return p, time.Time{}, ErrInvalidParams
+	}
+	p.Fit, p.Format = query.Get("fit"), query.Get("fm")
+	if q := query.Get("q"); q != "" {
+		if p.Quality, err = strconv.Atoi(q); err != nil {
+			return p, time.Time{}, ErrInvalidParams
+		}
+	}
+	expires := query.Get("expires")
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || !s.Uploads.ValidSignature(signedValue(key, p, expires), query.Get("s")) {
+		return p, time.Time{}, ErrInvalidSignature
+	}
+	at := time.Unix(unix, 0)
+	if !s.now().Before(at) {
+		return p, time.Time{}, ErrInvalidSignature
+	}
+	return p, at, s.validate(p)
+}
+
+func (s *Service) validate(p Params) error {
+	if p.Width < 0 || p.Height < 0 || p.Width+p.Height == 0 || p.Width > s.MaxDimension || p.Height > s.MaxDimension {
+		return ErrInvalidParams
+	}
+	if p.Fit != "" && p.Fit != FIT_COVER && p.Fit != FIT_CONTAIN {
+		return ErrInvalidParams
+	}
+	if _, ok := LookupFormat(p.Format); p.Format != "" && !ok {
+		return ErrInvalidParams
+	}
+	if p.Quality < 0 || p.Quality > 100 {
+		return ErrInvalidParams
+	}
+	return nil
+}
+
+// Image of the upload transformed with the params, made and stored on
+// the first request. Returns uploads.ErrBlobNotFound if there is no such
+// upload.
+func (s *Service) Render(ctx context.Context, key string, p Params) (io.ReadCloser, *uploads.Object, error) {
+	if err := s.validate(p); err != nil {
+		return nil, nil, err
+	}
+	source, err := s.Uploads.Store.Stat(ctx, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !strings.HasPrefix(source.ContentType, "image/") {
+		return nil, nil, ErrNotImage
+	}
+	p = s.resolve(p, source.ContentType)
+	derivedKey := DERIVED_PREFIX + key + "/" + size(p) + "-" + p.Fit + "-q" + strconv.Itoa(p.Quality) + "." + p.Format
+	r, o, err := s.Uploads.Store.Get(ctx, derivedKey)
+	if err == nil {
+		return r, o, nil
+	}
+	if !errors.Is(err, uploads.ErrBlobNotFound) {
+		return nil, nil, err
+	}
+	// Requests of the same image arriving together make it once. It's
+	// finished even if the request starting it goes away.
+	v, err, _ := s.group.Do(derivedKey, func() (any, error) {
+		return s.derive(context.WithoutCancel(ctx), key, derivedKey, p)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	d := v.(*derived)
+	return readSeekNopCloser{bytes.NewReader(d.data)}, d.object, nil
+}
+
+type derived struct {
+	object *uploads.Object
+	data   []byte
+}
+
+func (s *Service) derive(ctx context.Context, key, derivedKey string, p Params) (*derived, error) {
+	r, _, err := s.Uploads.Store.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(r)
+	r.Close()
+	if err != nil {
+		return nil, err
+	}
+	// Checked before decoding, a small file may declare a huge image
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrNotImage
+	}
+	if cfg.Width*cfg.Height > s.MaxPixels {
+		return nil, ErrTooManyPixels
+	}
+	img, err := imaging.Decode(bytes.NewReader(data), imaging.AutoOrientation(true))
+	if err != nil {
+		return nil, ErrNotImage
+	}
+	format, _ := LookupFormat(p.Format)
+	var buf bytes.Buffer
+	if err := format.Encode(&buf, transform(img, p), p.Quality); err != nil {
+		return nil, err
+	}
+	o := &uploads.Object{Key: derivedKey, ContentType: format.ContentType}
+	if err := s.Uploads.Store.Put(ctx, o, bytes.NewReader(buf.Bytes())); err != nil {
+		// Served anyway, it's made again on the next request
+		slog.Warn("failed to store derived image", "key", derivedKey, "error", err)
+		o.Size = int64(buf.Len())
+	}
+	return &derived{object: o, data: buf.Bytes()}, nil
+}
+
+// Params with the defaults filled in
+func (s *Service) resolve(p Params, contentType string) Params {
+	if p.Width == 0 || p.Height == 0 {
+		p.Fit = "scale"
+	} else if p.Fit == "" {
+		p.Fit = FIT_COVER
+	}
+	if p.Format == "" {
+		p.Format = FORMAT_PNG
+		if contentType == "image/jpeg" {
+			p.Format = FORMAT_JPEG
+		}
+	}
+	if p.Quality == 0 {
+		p.Quality = s.Quality
+	}
+	return p
+}
+
+// Images are never enlarged, except when cropped to cover the size
+func transform(img image.Image, p Params) image.Image {
+	bounds := img.Bounds()
+	switch {
+	case p.Fit == FIT_COVER:
+		return imaging.Fill(img, p.Width, p.Height, imaging.Center, imaging.Lanczos)
+	case p.Fit == FIT_CONTAIN:
+		return imaging.Fit(img, p.Width, p.Height, imaging.Lanczos)
+	case p.Width > 0 && p.Width < bounds.Dx():
+		return imaging.Resize(img, p.Width, 0, imaging.Lanczos)
+	case p.Height > 0 && p.Height < bounds.Dy():
+		return imaging.Resize(img, 0, p.Height, imaging.Lanczos)
+	}
+	return img
+}
+
+// Value of srcset attributes offering the image in the sizes of
+// SrcSetWidths up to twice the width of the params, for high density
+// screens. Images sized by their height only are offered at 1x and 2x.
+func (s *Service) SrcSet(key string, p Params) string {
+	var candidates []string
+	if p.Width == 0 {
+		for _, density := range []int{1, 2} {
+			if h := p.Height * density; density == 1 || h <= s.MaxDimension {
+				scaled := p
+				scaled.Height = h
+				candidates = append(candidates, s.URL(key, scaled)+" "+strconv.Itoa(density)+"x")
+			}
+		}
+		return strings.Join(candidates, ", ")
+	}
+	widths := append(slices.Clone(s.SrcSetWidths), p.Width, 2*p.Width)
+	slices.Sort(widths)
+	for _, w := range slices.Compact(widths) {
+		if w > 2*p.Width || w > s.MaxDimension {
+			continue
+		}
+		scaled := p
+		scaled.Width = w
+		if p.Height > 0 {
+			scaled.Height = (p.Height*w + p.Width/2) / p.Width
+			if scaled.Height > s.MaxDimension || scaled.Height == 0 {
+				continue
+			}
+		}
+		candidates = append(candidates, s.URL(key, scaled)+" "+strconv.Itoa(w)+"w")
+	}
+	return strings.Join(candidates, ", ")
+}
+
+func (p Params) query() url.Values {
+	query := url.Values{}
+	if p.Fit != "" {
+		query.Set("fit", p.Fit)
+	}
+	if p.Format != "" {
+		query.Set("fm", p.Format)
+	}
+	if p.Quality != 0 {
+		query.Set("q", strconv.Itoa(p.Quality))
+	}
+	return query
+}
+
+func size(p Params) string {
+	return strconv.Itoa(p.Width) + "x" + strconv.Itoa(p.Height)
+}
+
+// Prefixed, so image signatures can't be used as download links
+func signedValue(key string, p Params, expires string) string {
+	return "img\n" + size(p) + "/" + key + "?" + p.query().Encode() + "\n" + expires
+}
+
+type readSeekNopCloser struct {
+	io.ReadSeeker
+}
+
+func (readSeekNopCloser) Close() error { return nil }
+
+var defaultService atomic.Pointer[Service]
+
+// Set the service used by URL and SrcSet, e.g. in templ components
+func SetDefault(s *Service) {
+	defaultService.Store(s)
+}
+
+// Signed URL of the image from the default service, empty without one
+func URL(key string, p Params) string {
+	if s := defaultService.Load(); s != nil {
+		return s.URL(key, p)
+	}
+	return ""
+}
+
+// Srcset of the image from the default service, empty without one
+func SrcSet(key string, p Params) string {
+	if s := defaultService.Load(); s != nil {
+		return s.SrcSet(key, p)
+	}
+	return ""
+}
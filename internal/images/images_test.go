@@ -0,0 +1,229 @@
+package images
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mcgtrt/go-puerto/internal/uploads"
+	"github.com/mcgtrt/go-puerto/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestService(store uploads.BlobStore) *Service {
+	service := uploads.NewService(store, &utils.UploadsConfig{
+		MaxSize:      1 << 20,
+		AllowedTypes: []string{"image/*"},
+		URLTTL:       time.Hour,
+	}, []byte("secret"))
+	return NewService(service, &utils.ImagesConfig{
+		MaxDimension: 1000,
+		MaxPixels:    100_000,
+		Quality:      80,
+		SrcSetWidths: []int{100, 200, 400, 800},
+	})
+}
+
+func put(t *testing.T, store uploads.BlobStore, key, contentType string, data []byte) {
+	assert.NoError(t, store.Put(context.Background(), &uploads.Object{Key: key, ContentType: contentType}, bytes.NewReader(data)))
+}
+
+func encodePNG(w, h int) []byte {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for x := range w {
+		for y := range h {
+			img.Set(x, y, color.NRGBA{R: uint8(x), G: uint8(y), B: 100, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	png.Encode(&buf, img)
+	return buf.Bytes()
+}
+
+// JPEG with an EXIF block asking for a 90 degrees clockwise rotation, the
+// way phones store portrait photos
+func encodeRotatedJPEG(w, h int) []byte {
+	var buf bytes.Buffer
+	jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, w, h)), nil)
+	exif := []byte("Exif\x00\x00MM\x00\x2a\x00\x00\x00\x08\x00\x01\x01\x12\x00\x03\x00\x00\x00\x01\x00\x06\x00\x00\x00\x00\x00\x00")
+	segment := append([]byte{0xff, 0xe1, 0, byte(len(exif) + 2)}, exif...)
+	data := buf.Bytes()
+	return append(append([]byte{0xff, 0xd8}, segment...), data[2:]...)
+}
+
+func decode(t *testing.T, r io.Reader) (image.Image, string) {
+	img, format, err := image.Decode(r)
+	assert.NoError(t, err)
+	return img, format
+}
+
+func TestURL(t *testing.T) {
+	s := newTestService(uploads.NewMemoryStore())
+	now := time.Now()
+	s.now = func() time.Time { return now }
+	p := Params{Width: 640, Height: 480, Fit: FIT_CONTAIN, Format: FORMAT_PNG}
+
+	link, err := url.Parse(s.URL("01J0ABC", p))
+	assert.NoError(t, err)
+	assert.Equal(t, IMAGE_PATH+"640x480/01J0ABC", link.Path)
+	parsed, expires, err := s.Parse("01J0ABC", "640x480", link.Query())
+	assert.NoError(t, err)
+	assert.Equal(t, p, parsed)
+	assert.True(t, expires.After(time.Now().Add(time.Hour-time.Second)), "Expected URL valid for at least the TTL")
+	assert.True(t, expires.Before(time.Now().Add(2*time.Hour+time.Second)))
+	assert.Equal(t, link.String(), s.URL("01J0ABC", p), "Expected the same URL within the period")
+
+	_, _, err = s.Parse("01J0ABC", "1280x960", link.Query())
+	assert.ErrorIs(t, err, ErrInvalidSignature, "Expected size covered by the signature")
+	_, _, err = s.Parse("01J0XYZ", "640x480", link.Query())
+	assert.ErrorIs(t, err, ErrInvalidSignature)
+	tampered := link.Query()
+	tampered.Set("fm", FORMAT_JPEG)
+	_, _, err = s.Parse("01J0ABC", "640x480", tampered)
+	assert.ErrorIs(t, err, ErrInvalidSignature)
+	_, _, err = s.Parse("01J0ABC", "640", link.Query())
+	assert.ErrorIs(t, err, ErrInvalidParams)
+
+	link, _ = url.Parse(s.URL("01J0ABC", Params{Width: 2000}))
+	_, _, err = s.Parse("01J0ABC", "2000x0", link.Query())
+	assert.ErrorIs(t, err, ErrInvalidParams, "Expected sizes over the max refused")
+
+	t.Run("Expiry", func(t *testing.T) {
+		link, _ := url.Parse(s.URL("01J0ABC", p))
+		extended := link.Query()
+		extended.Set("expires", "4102444800")
+		_, _, err := s.Parse("01J0ABC", "640x480", extended)
+		assert.ErrorIs(t, err, ErrInvalidSignature, "Expected expiry covered by the signature")
+
+		s.now = func() time.Time { return now.Add(3 * time.Hour) }
+		_, _, err = s.Parse("01J0ABC", "640x480", link.Query())
+		assert.ErrorIs(t, err, ErrInvalidSignature, "Expected expired URL refused")
+	})
+}
+
+func TestRender(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Crops to cover the size and caches the result", func(t *testing.T) {
+		store := uploads.NewMemoryStore()
+		s := newTestService(store)
+		put(t, store, "wide", "image/png", encodePNG(100, 50))
+
+		r, o, err := s.Render(ctx, "wide", Params{Width: 40, Height: 40})
+		assert.NoError(t, err)
+		assert.Equal(t, "image/png", o.ContentType)
+		img, format := decode(t, r)
+		assert.Equal(t, "png", format)
+		assert.Equal(t, image.Rect(0, 0, 40, 40), img.Bounds())
+		assert.Contains(t, store.Keys(), DERIVED_PREFIX+"wide/40x40-cover-q80.png")
+
+		r, o, err = s.Render(ctx, "wide", Params{Width: 40, Height: 40})
+		assert.NoError(t, err)
+		assert.Equal(t, DERIVED_PREFIX+"wide/40x40-cover-q80.png", o.Key, "Expected the stored image served")
+		r.Close()
+
+		assert.NoError(t, store.Delete(ctx, "wide"))
+		_, _, err = s.Render(ctx, "wide", Params{Width: 40, Height: 40})
+		assert.ErrorIs(t, err, uploads.ErrBlobNotFound, "Expected derived image of a deleted upload not served")
+	})
+
+	t.Run("Fits and scales without enlarging", func(t *testing.T) {
+		store := uploads.NewMemoryStore()
+		s := newTestService(store)
+		put(t, store, "wide", "image/png", encodePNG(100, 50))
+
+		r, _, err := s.Render(ctx, "wide", Params{Width: 40, Height: 40, Fit: FIT_CONTAIN, Format: FORMAT_JPEG})
+		assert.NoError(t, err)
+		img, format := decode(t, r)
+		assert.Equal(t, "jpeg", format)
+		assert.Equal(t, image.Rect(0, 0, 40, 20), img.Bounds())
+
+		r, _, err = s.Render(ctx, "wide", Params{Height: 25})
+		assert.NoError(t, err)
+		img, _ = decode(t, r)
+		assert.Equal(t, image.Rect(0, 0, 50, 25), img.Bounds())
+
+		r, _, err = s.Render(ctx, "wide", Params{Width: 800})
+		assert.NoError(t, err)
+		img, _ = decode(t, r)
+		assert.Equal(t, image.Rect(0, 0, 100, 50), img.Bounds())
+	})
+
+	t.Run("Applies the orientation and strips EXIF", func(t *testing.T) {
+		store := uploads.NewMemoryStore()
+		s := newTestService(store)
+		put(t, store, "photo", "image/jpeg", encodeRotatedJPEG(40, 20))
+
+		r, o, err := s.Render(ctx, "photo", Params{Width: 20})
+		assert.NoError(t, err)
+		assert.Equal(t, "image/jpeg", o.ContentType)
+		data, _ := io.ReadAll(r)
+		img, _ := decode(t, bytes.NewReader(data))
+		assert.Equal(t, image.Rect(0, 0, 20, 40), img.Bounds(), "Expected the portrait photo upright")
+		assert.NotContains(t, string(data), "Exif")
+	})
+
+	t.Run("Refuses other files", func(t *testing.T) {
+		store := uploads.NewMemoryStore()
+		s := newTestService(store)
+		put(t, store, "doc", "application/pdf", []byte("%PDF-1.4"))
+		put(t, store, "fake", "image/png", []byte("not really a png"))
+		put(t, store, "huge", "image/png", encodePNG(400, 400))
+
+		_, _, err := s.Render(ctx, "doc", Params{Width: 10})
+		assert.ErrorIs(t, err, ErrNotImage)
+		_, _, err = s.Render(ctx, "fake", Params{Width: 10})
+		assert.ErrorIs(t, err, ErrNotImage)
+		_, _, err = s.Render(ctx, "huge", Params{Width: 10})
+		assert.ErrorIs(t, err, ErrTooManyPixels)
+		_, _, err = s.Render(ctx, "missing", Params{Width: 10})
+		assert.ErrorIs(t, err, uploads.ErrBlobNotFound)
+	})
+}
+
+func TestSrcSet(t *testing.T) {
+	s := newTestService(uploads.NewMemoryStore())
+
+	srcset := s.SrcSet("photo", Params{Width: 300, Height: 200})
+	candidates := strings.Split(srcset, ", ")
+	assert.Len(t, candidates, 5, "Expected widths up to twice the displayed width")
+	assert.True(t, strings.HasPrefix(candidates[0], IMAGE_PATH+"100x67/photo?"))
+	assert.True(t, strings.HasSuffix(candidates[0], " 100w"))
+	assert.True(t, strings.HasPrefix(candidates[2], IMAGE_PATH+"300x200/photo?"))
+	assert.True(t, strings.HasPrefix(candidates[4], IMAGE_PATH+"600x400/photo?"))
+
+	candidates = strings.Split(s.SrcSet("photo", Params{Height: 600}), ", ")
+	assert.Len(t, candidates, 1, "Expected 2x left out over the max dimension")
+	assert.True(t, strings.HasSuffix(candidates[0], " 1x"))
+
+	assert.Empty(t, SrcSet("photo", Params{Width: 300}), "Expected nothing without the default service")
+	SetDefault(s)
+	defer SetDefault(nil)
+	assert.Equal(t, srcset, SrcSet("photo", Params{Width: 300, Height: 200}))
+}
+
+func TestRegisterFormat(t *testing.T) {
+	assert.Empty(t, ModernFormats())
+	_, ok := LookupFormat("webp")
+	assert.False(t, ok)
+
+	RegisterFormat("webp", "image/webp", func(w io.Writer, img image.Image, quality int) error { return nil })
+	defer func() {
+		formatsMu.Lock()
+		delete(formats, "webp")
+		modern = nil
+		formatsMu.Unlock()
+	}()
+	f, ok := LookupFormat("webp")
+	assert.True(t, ok)
+	assert.Equal(t, "image/webp", f.ContentType)
+	assert.Equal(t, []string{"webp"}, []string{ModernFormats()[0].Name})
+}
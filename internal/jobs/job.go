@@ -0,0 +1,235 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/mcgtrt/go-puerto/utils"
+)
+
+// Statuses of jobs. Finished jobs are deleted, failed jobs stay as dead
+// letters until they are queued again or deleted.
+const (
+	STATUS_QUEUED  = "queued"
+	STATUS_RUNNING = "running"
+	STATUS_FAILED  = "failed"
+)
+
+// Priorities of jobs, due jobs with higher priority run first
+const (
+	PRIORITY_LOW    = 0
+	PRIORITY_NORMAL = 1
+	PRIORITY_HIGH   = 2
+)
+
+var (
+	// Returned by Claim when no job is due
+	ErrNoJob = errors.New("no job due")
+	// A queued or running job has the same unique key
+	ErrDuplicate       = errors.New("job with the unique key already queued")
+	ErrJobNotFound     = errors.New("job not found")
+	ErrUnknownKind     = errors.New("no handler registered for the job kind")
+	ErrInvalidPriority = errors.New("job priority must be low, normal or high")
+	// The lease of the job expired and another worker claimed it again
+	// (or it was deleted), so the result of the attempt is discarded
+	ErrLeaseLost = errors.New("job lease lost")
+)
+
+// Unit of background work. Payload is the JSON of the value given to
+// Enqueue. Attempts counts the runs including the current one.
+type Job struct {
+	ID          string          `json:"id"`
+	Kind        string          `json:"kind"`
+	Payload     json.RawMessage `json:"payload"`
+	Priority    int             `json:"priority"`
+	UniqueKey   string          `json:"unique_key,omitempty"`
+	Status      string          `json:"status"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"max_attempts"`
+	RunAt       time.Time       `json:"run_at"`
+	LockedUntil *time.Time      `json:"locked_until,omitempty"`
+	LastError   string          `json:"last_error,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+	// Unique to every claim of the job, so the results of a worker whose
+	// lease expired are refused even after the job was requeued
+	ClaimID string `json:"claim_id,omitempty"`
+	// Handle of the claimed job in the backend, e.g. its stream entry
+	Receipt string `json:"-"`
+}
+
+// Persistence of queued jobs shared by all app instances
+type Backend interface {
+	// Returns ErrDuplicate when a queued or running job has the unique key
+	Enqueue(ctx context.Context, j *Job) error
+	// Lease a due job of one of the kinds, highest priority first, and
+	// mark it running with one more attempt and a new ClaimID. Jobs of workers which stopped
+	// without finishing them are claimed again once their lease expires.
+	// Returns ErrNoJob when no job is due.
+	Claim(ctx context.Context, kinds []string, lease time.Duration) (*Job, error)
+	// Delete the finished job. Complete, Retry and Fail only change the
+	// job still running the claim (matched by ClaimID) and return
+	// ErrLeaseLost otherwise.
+	Complete(ctx context.Context, j *Job) error
+	// Queue the job again to run at runAt
+	Retry(ctx context.Context, j *Job, runAt time.Time, lastError string) error
+	// Keep the job as a dead letter
+	Fail(ctx context.Context, j *Job, lastError string) error
+	// Jobs with the status, most recently updated first
+	List(ctx context.Context, status string, limit int) ([]Job, error)
+	// Number of jobs by status
+	Count(ctx context.Context) (map[string]int, error)
+	// Queue the failed job again with fresh attempts. Returns
+	// ErrJobNotFound if there is no such failed job.
+	Requeue(ctx context.Context, id string) error
+	// Delete the failed job. Returns ErrJobNotFound if there is no such
+	// failed job.
+	Delete(ctx context.Context, id string) error
+}
+
+// Keeps jobs in the process memory. Useful for tests and prototyping.
+type MemoryBackend struct {
+	mu   sync.Mutex
+	jobs map[string]*Job
+	now  func() time.Time
+}
+
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{jobs: make(map[string]*Job), now: time.Now}
+}
+
+func (m *MemoryBackend) Enqueue(ctx context.Context, j *Job) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if j.UniqueKey != "" {
+		for _, other := range m.jobs {
+			if other.UniqueKey == j.UniqueKey && other.Status != STATUS_FAILED {
+				return ErrDuplicate
+			}
+		}
+	}
+	stored := *j
+	m.jobs[j.ID] = &stored
+	return nil
+}
+
+func (m *MemoryBackend) Claim(ctx context.Context, kinds []string, lease time.Duration) (*Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	var next *Job
+	for _, j := range m.jobs {
+		due := j.Status == STATUS_QUEUED && !j.RunAt.After(now) ||
+			j.Status == STATUS_RUNNING && j.LockedUntil != nil && j.LockedUntil.Before(now)
+		if !due || !slices.Contains(kinds, j.Kind) {
+			continue
+		}
+		if next == nil || j.Priority > next.Priority || j.Priority == next.Priority && j.RunAt.Before(next.RunAt) {
+			next = j
+		}
+	}
+	if next == nil {
+		return nil, ErrNoJob
+	}
+	lockedUntil := now.Add(lease)
+	next.Status, next.LockedUntil, next.UpdatedAt = STATUS_RUNNING, &lockedUntil, now
+	next.Attempts++
+	next.ClaimID = utils.NewUUIDv7()
+	claimed := *next
+	return &claimed, nil
+}
+
+func (m *MemoryBackend) Complete(ctx context.Context, j *Job) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !isClaimed(m.jobs[j.ID], j) {
+		return ErrLeaseLost
+	}
+	delete(m.jobs, j.ID)
+	return nil
+}
+
+func (m *MemoryBackend) Retry(ctx context.Context, j *Job, runAt time.Time, lastError string) error {
+	return m.finish(j, func(stored *Job) {
+		stored.Status, stored.RunAt, stored.LastError, stored.LockedUntil = STATUS_QUEUED, runAt, lastError, nil
+	})
+}
+
+func (m *MemoryBackend) Fail(ctx context.Context, j *Job, lastError string) error {
+	return m.finish(j, func(stored *Job) {
+		stored.Status, stored.LastError, stored.LockedUntil = STATUS_FAILED, lastError, nil
+	})
+}
+
+func (m *MemoryBackend) List(ctx context.Context, status string, limit int) ([]Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	jobs := []Job{}
+	for _, j := range m.jobs {
+		if j.Status == status {
+			jobs = append(jobs, *j)
+		}
+	}
+	slices.SortFunc(jobs, func(a, b Job) int { return b.UpdatedAt.Compare(a.UpdatedAt) })
+	return jobs[:min(limit, len(jobs))], nil
+}
+
+func (m *MemoryBackend) Count(ctx context.Context) (map[string]int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	counts := map[string]int{}
+	for _, j := range m.jobs {
+		counts[j.Status]++
+	}
+	return counts, nil
+}
+
+func (m *MemoryBackend) Requeue(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[id]
+	if !ok || j.Status != STATUS_FAILED {
+		return ErrJobNotFound
+	}
+	if j.UniqueKey != "" {
+		for _, other := range m.jobs {
+			if other.UniqueKey == j.UniqueKey && other.Status != STATUS_FAILED {
+				return ErrDuplicate
+			}
+		}
+	}
+	now := m.now()
+	j.Status, j.Attempts, j.RunAt, j.UpdatedAt = STATUS_QUEUED, 0, now, now
+	return nil
+}
+
+func (m *MemoryBackend) Delete(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if j, ok := m.jobs[id]; !ok || j.Status != STATUS_FAILED {
+		return ErrJobNotFound
+	}
+	delete(m.jobs, id)
+	return nil
+}
+
+func (m *MemoryBackend) finish(claimed *Job, fn func(j *Job)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j := m.jobs[claimed.ID]
+	if !isClaimed(j, claimed) {
+		return ErrLeaseLost
+	}
+	fn(j)
+	j.UpdatedAt = m.now()
+	return nil
+}
+
+// The stored job still runs the claim
+func isClaimed(stored, claimed *Job) bool {
+	return stored != nil && stored.Status == STATUS_RUNNING && stored.ClaimID == claimed.ClaimID
+}
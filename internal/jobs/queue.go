@@ -0,0 +1,350 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/mcgtrt/go-puerto/internal/metrics"
+	"github.com/mcgtrt/go-puerto/utils"
+)
+
+// Delay of the first retry, doubled for every next one up to MAX_RETRY_DELAY
+var (
+	RETRY_DELAY     = 10 * time.Second
+	MAX_RETRY_DELAY = time.Hour
+)
+
+// Runs the job. Returned errors retry the job until it runs out of
+// attempts. The context is cancelled when the lease expires or the
+// shutdown timeout runs out.
+type Handler func(ctx context.Context, j *Job) error
+
+type handler struct {
+	fn Handler
+	// Free run slots of kinds with their own concurrency limit
+	slots chan struct{}
+}
+
+// Options of enqueued jobs
+type Option func(j *Job)
+
+// Run the job no earlier than after the delay
+func Delay(d time.Duration) Option {
+	return func(j *Job) { j.RunAt = j.RunAt.Add(d) }
+}
+
+// Run the job no earlier than at the time
+func At(t time.Time) Option {
+	return func(j *Job) { j.RunAt = t }
+}
+
+func Priority(p int) Option {
+	return func(j *Job) { j.Priority = p }
+}
+
+// Skip enqueueing while a job with the key is queued or running (Enqueue
+// returns ErrDuplicate), e.g. "reindex:product:42"
+func Unique(key string) Option {
+	return func(j *Job) { j.UniqueKey = key }
+}
+
+func MaxAttempts(n int) Option {
+	return func(j *Job) { j.MaxAttempts = n }
+}
+
+// Options of registered handlers
+type HandlerOption func(h *handler)
+
+// Run at most n jobs of the kind at once in this instance, e.g. for jobs
+// calling rate limited APIs
+func Concurrency(n int) HandlerOption {
+	return func(h *handler) { h.slots = make(chan struct{}, n) }
+}
+
+// Background jobs run by a pool of workers. Register handlers at startup,
+// then Start the workers and Shutdown them when the app stops.
+type Queue struct {
+	Backend      Backend
+	Concurrency  int
+	MaxAttempts  int
+	Lease        time.Duration
+	PollInterval time.Duration
+	// Delay before the next attempt of a failed job
+	Backoff func(attempts int) time.Duration
+
+	mu       sync.RWMutex
+	handlers map[string]*handler
+	kinds    []string
+	// Claims are made one at a time, so a claimed job always gets a free
+	// slot of its kind
+	claimMu sync.Mutex
+	slots   chan struct{}
+	stop    chan struct{}
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+	started bool
+	now     func() time.Time
+}
+
+func NewQueue(backend Backend, cfg *utils.JobsConfig) *Queue {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Queue{
+		Backend:      backend,
+		Concurrency:  cfg.Concurrency,
+		MaxAttempts:  cfg.MaxAttempts,
+		Lease:        cfg.Lease,
+		PollInterval: cfg.PollInterval,
+		Backoff:      ExponentialBackoff,
+		handlers:     make(map[string]*handler),
+		stop:         make(chan struct{}),
+		ctx:          ctx,
+		cancel:       cancel,
+		now:          time.Now,
+	}
+}
+
+// Doubles the delay after every attempt with up to 50% jitter, so jobs
+// failed together don't retry together
+func ExponentialBackoff(attempts int) time.Duration {
+	delay := RETRY_DELAY
+	for i := 1; i < attempts && delay < MAX_RETRY_DELAY; i++ {
+		delay *= 2
+	}
+	delay = min(delay, MAX_RETRY_DELAY)
+	return delay/2 + rand.N(delay/2+1)
+}
+
+// Register the handler of jobs of the kind with the JSON payload decoded
+// into T. Payloads which can't be decoded fail without retrying.
+func Register[T any](q *Queue, kind string, fn func(ctx context.Context, payload T) error, opts ...HandlerOption) {
+	q.Handle(kind, func(ctx context.Context, j *Job) error {
+		var payload T
+		if err := json.Unmarshal(j.Payload, &payload); err != nil {
+			return permanent{fmt.Errorf("decoding payload: %w", err)}
+		}
+		return fn(ctx, payload)
+	}, opts...)
+}
+
+// Register the handler of jobs of the kind
+func (q *Queue) Handle(kind string, fn Handler, opts ...HandlerOption) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	h := &handler{fn: fn}
+	for _, opt := range opts {
+		opt(h)
+	}
+	if _, ok := q.handlers[kind]; !ok {
+		q.kinds = append(q.kinds, kind)
+	}
+	q.handlers[kind] = h
+}
+
+// Queue the job of the kind with the payload encoded as JSON
+func Enqueue[T any](ctx context.Context, q *Queue, kind string, payload T, opts ...Option) (*Job, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	return q.Enqueue(ctx, kind, data, opts...)
+}
+
+func (q *Queue) Enqueue(ctx context.Context, kind string, payload json.RawMessage, opts ...Option) (*Job, error) {
+	q.mu.RLock()
+	_, ok := q.handlers[kind]
+	q.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownKind, kind)
+	}
+	now := q.now()
+	j := &Job{
+		ID:          utils.NewUUIDv7(),
+		Kind:        kind,
+		Payload:     payload,
+		Priority:    PRIORITY_NORMAL,
+		Status:      STATUS_QUEUED,
+		MaxAttempts: q.MaxAttempts,
+		RunAt:       now,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	for _, opt := range opts {
+		opt(j)
+	}
+	if j.Priority < PRIORITY_LOW || j.Priority > PRIORITY_HIGH {
+		return nil, ErrInvalidPriority
+	}
+	if err := q.Backend.Enqueue(ctx, j); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// Start the workers claiming due jobs
+func (q *Queue) Start() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.started {
+		return
+	}
+	q.started = true
+	q.slots = make(chan struct{}, q.Concurrency)
+	q.wg.Add(1)
+	go q.work()
+}
+
+// Stop claiming jobs and wait for the running ones. Jobs still running
+// when the context is done are cancelled and retried later.
+func (q *Queue) Shutdown(ctx context.Context) error {
+	q.mu.Lock()
+	select {
+	case <-q.stop:
+	default:
+		close(q.stop)
+	}
+	q.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		q.cancel()
+		return nil
+	case <-ctx.Done():
+		// Jobs ignoring the cancellation run again once their lease expires
+		q.cancel()
+		return ctx.Err()
+	}
+}
+
+// Claim jobs while there are free slots. Polls when no job is due.
+func (q *Queue) work() {
+	defer q.wg.Done()
+	for {
+		select {
+		case q.slots <- struct{}{}:
+		case <-q.stop:
+			return
+		}
+		j, h, err := q.claim()
+		if err != nil {
+			<-q.slots
+			if !errors.Is(err, ErrNoJob) {
+				slog.Error("claiming job failed", "error", err)
+			}
+			select {
+			case <-time.After(q.PollInterval):
+			case <-q.stop:
+				return
+			}
+			continue
+		}
+		q.wg.Add(1)
+		go func() {
+			defer q.wg.Done()
+			defer func() { <-q.slots }()
+			if h.slots != nil {
+				defer func() { <-h.slots }()
+			}
+			q.run(j, h)
+		}()
+	}
+}
+
+func (q *Queue) claim() (*Job, *handler, error) {
+	q.claimMu.Lock()
+	defer q.claimMu.Unlock()
+	q.mu.RLock()
+	kinds := make([]string, 0, len(q.kinds))
+	for _, kind := range q.kinds {
+		if h := q.handlers[kind]; h.slots == nil || len(h.slots) < cap(h.slots) {
+			kinds = append(kinds, kind)
+		}
+	}
+	q.mu.RUnlock()
+	if len(kinds) == 0 {
+		return nil, nil, ErrNoJob
+	}
+	j, err := q.Backend.Claim(q.ctx, kinds, q.Lease)
+	if err != nil {
+		return nil, nil, err
+	}
+	q.mu.RLock()
+	h := q.handlers[j.Kind]
+	q.mu.RUnlock()
+	if h.slots != nil {
+		h.slots <- struct{}{}
+	}
+	return j, h, nil
+}
+
+func (q *Queue) run(j *Job, h *handler) {
+	log := slog.With("job_id", j.ID, "kind", j.Kind, "attempt", j.Attempts)
+	ctx, cancel := context.WithTimeout(q.ctx, q.Lease)
+	defer cancel()
+	start := time.Now()
+	err := call(ctx, h.fn, j)
+	// Outcomes are recorded even when the shutdown cancelled the job
+	ctx = context.WithoutCancel(ctx)
+
+	var result string
+	switch {
+	case err == nil:
+		result = "done"
+		err = q.Backend.Complete(ctx, j)
+	case isPermanent(err) || j.Attempts >= j.MaxAttempts:
+		result = "failed"
+		log.Error("job failed", "error", err)
+		err = q.Backend.Fail(ctx, j, err.Error())
+	default:
+		result = "retried"
+		log.Warn("job failed, retrying", "error", err)
+		err = q.Backend.Retry(ctx, j, q.now().Add(q.Backoff(j.Attempts)), err.Error())
+	}
+	if errors.Is(err, ErrLeaseLost) {
+		log.Warn("job result discarded, the lease expired before it finished", "result", result)
+		result = "lease_lost"
+	} else if err != nil {
+		log.Error("recording job result failed", "result", result, "error", err)
+	}
+	metrics.JobsProcessed.WithLabelValues(j.Kind, result).Inc()
+	metrics.JobDuration.WithLabelValues(j.Kind).Observe(time.Since(start).Seconds())
+}
+
+// Run the handler turning panics into errors, so one broken job doesn't
+// stop the workers
+func call(ctx context.Context, fn Handler, j *Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v\n%s", r, debug.Stack())
+		}
+	}()
+	return fn(ctx, j)
+}
+
+// Error of a job which fails the same way on every attempt
+type permanent struct{ error }
+
+func (p permanent) Unwrap() error { return p.error }
+
+// Wrap the error to fail the job without retrying, e.g. when the record
+// it works on was deleted
+func Permanent(err error) error {
+	return permanent{err}
+}
+
+func isPermanent(err error) bool {
+	var p permanent
+	return errors.As(err, &p)
+}
@@ -0,0 +1,253 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mcgtrt/go-puerto/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+type invoice struct {
+	OrderID string `json:"order_id"`
+}
+
+func newTestQueue() (*Queue, *MemoryBackend) {
+	backend := NewMemoryBackend()
+	q := NewQueue(backend, &utils.JobsConfig{Concurrency: 4, MaxAttempts: 3, Lease: time.Minute, PollInterval: 5 * time.Millisecond})
+	q.Backoff = func(int) time.Duration { return 0 }
+	return q, backend
+}
+
+// Wait until the condition holds or fail the test
+func eventually(t *testing.T, condition func() bool) {
+	t.Helper()
+	assert.Eventually(t, condition, 2*time.Second, 5*time.Millisecond)
+}
+
+func TestQueue(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Runs typed jobs", func(t *testing.T) {
+		q, backend := newTestQueue()
+		done := make(chan invoice, 1)
+		Register(q, "send_invoice", func(ctx context.Context, inv invoice) error {
+			done <- inv
+			return nil
+		})
+		_, err := Enqueue(ctx, q, "send_invoice", invoice{OrderID: "o1"})
+		assert.NoError(t, err)
+		_, err = Enqueue(ctx, q, "send_invoce", invoice{})
+		assert.ErrorIs(t, err, ErrUnknownKind)
+
+		q.Start()
+		defer q.Shutdown(ctx)
+		assert.Equal(t, invoice{OrderID: "o1"}, <-done)
+		eventually(t, func() bool {
+			counts, _ := backend.Count(ctx)
+			return len(counts) == 0
+		})
+	})
+
+	t.Run("Retries then keeps failed jobs", func(t *testing.T) {
+		q, backend := newTestQueue()
+		var attempts atomic.Int32
+		Register(q, "flaky", func(ctx context.Context, _ struct{}) error {
+			attempts.Add(1)
+			return errors.New("upstream unavailable")
+		})
+		j, err := Enqueue(ctx, q, "flaky", struct{}{})
+		assert.NoError(t, err)
+		q.Start()
+		defer q.Shutdown(ctx)
+
+		eventually(t, func() bool {
+			failed, _ := backend.List(ctx, STATUS_FAILED, 10)
+			return len(failed) == 1
+		})
+		assert.Equal(t, int32(3), attempts.Load())
+		failed, _ := backend.List(ctx, STATUS_FAILED, 10)
+		assert.Equal(t, "upstream unavailable", failed[0].LastError)
+
+		assert.NoError(t, backend.Requeue(ctx, j.ID))
+		eventually(t, func() bool { return attempts.Load() == 6 })
+	})
+
+	t.Run("Permanent errors and panics", func(t *testing.T) {
+		q, backend := newTestQueue()
+		var attempts atomic.Int32
+		Register(q, "gone", func(ctx context.Context, _ struct{}) error {
+			attempts.Add(1)
+			return Permanent(errors.New("order deleted"))
+		})
+		q.Handle("broken", func(ctx context.Context, j *Job) error { panic("nil map") })
+		Enqueue(ctx, q, "gone", struct{}{})
+		Enqueue(ctx, q, "broken", struct{}{}, MaxAttempts(1))
+		q.Start()
+		defer q.Shutdown(ctx)
+
+		eventually(t, func() bool {
+			failed, _ := backend.List(ctx, STATUS_FAILED, 10)
+			return len(failed) == 2
+		})
+		assert.Equal(t, int32(1), attempts.Load(), "Expected no retries")
+	})
+
+	t.Run("Delay, priority and unique keys", func(t *testing.T) {
+		q, _ := newTestQueue()
+		q.Concurrency = 1
+		var mu sync.Mutex
+		var order []string
+		Register(q, "task", func(ctx context.Context, name string) error {
+			mu.Lock()
+			defer mu.Unlock()
+			order = append(order, name)
+			return nil
+		})
+		Enqueue(ctx, q, "task", "later", Delay(50*time.Millisecond))
+		Enqueue(ctx, q, "task", "low", Priority(PRIORITY_LOW))
+		Enqueue(ctx, q, "task", "high", Priority(PRIORITY_HIGH), Unique("report"))
+		_, err := Enqueue(ctx, q, "task", "again", Unique("report"))
+		assert.ErrorIs(t, err, ErrDuplicate)
+		_, err = Enqueue(ctx, q, "task", "urgent", Priority(10))
+		assert.ErrorIs(t, err, ErrInvalidPriority)
+
+		q.Start()
+		defer q.Shutdown(ctx)
+		eventually(t, func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			return len(order) == 3
+		})
+		assert.Equal(t, []string{"high", "low", "later"}, order)
+	})
+
+	t.Run("Concurrency limit of kind", func(t *testing.T) {
+		q, _ := newTestQueue()
+		var running, peak atomic.Int32
+		Register(q, "export", func(ctx context.Context, _ struct{}) error {
+			n := running.Add(1)
+			for {
+				p := peak.Load()
+				if n <= p || peak.CompareAndSwap(p, n) {
+					break
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+			running.Add(-1)
+			return nil
+		}, Concurrency(1))
+		for range 4 {
+			Enqueue(ctx, q, "export", struct{}{})
+		}
+		q.Start()
+		defer q.Shutdown(ctx)
+		eventually(t, func() bool { return running.Load() == 0 && peak.Load() > 0 })
+		time.Sleep(50 * time.Millisecond)
+		assert.Equal(t, int32(1), peak.Load())
+	})
+
+	t.Run("Shutdown drains running jobs", func(t *testing.T) {
+		q, backend := newTestQueue()
+		started := make(chan struct{})
+		var finished atomic.Bool
+		Register(q, "slow", func(ctx context.Context, _ struct{}) error {
+			close(started)
+			time.Sleep(30 * time.Millisecond)
+			finished.Store(true)
+			return nil
+		})
+		Enqueue(ctx, q, "slow", struct{}{})
+		q.Start()
+		<-started
+		assert.NoError(t, q.Shutdown(ctx))
+		assert.True(t, finished.Load(), "Expected the running job finished")
+		counts, _ := backend.Count(ctx)
+		assert.Empty(t, counts)
+
+		_, err := Enqueue(ctx, q, "slow", struct{}{})
+		assert.NoError(t, err, "Expected jobs still accepted for other instances")
+	})
+
+	t.Run("Shutdown timeout cancels jobs", func(t *testing.T) {
+		q, backend := newTestQueue()
+		started := make(chan struct{})
+		Register(q, "stuck", func(ctx context.Context, _ struct{}) error {
+			close(started)
+			<-ctx.Done()
+			return ctx.Err()
+		})
+		Enqueue(ctx, q, "stuck", struct{}{})
+		q.Start()
+		<-started
+		timeout, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+		assert.ErrorIs(t, q.Shutdown(timeout), context.DeadlineExceeded)
+		eventually(t, func() bool {
+			queued, _ := backend.List(ctx, STATUS_QUEUED, 10)
+			return len(queued) == 1
+		})
+	})
+}
+
+func TestMemoryBackendReclaimsExpiredLeases(t *testing.T) {
+	ctx := context.Background()
+	backend := NewMemoryBackend()
+	now := time.Now()
+	backend.now = func() time.Time { return now }
+	assert.NoError(t, backend.Enqueue(ctx, &Job{ID: "j1", Kind: "task", Status: STATUS_QUEUED, RunAt: now}))
+
+	stale, err := backend.Claim(ctx, []string{"task"}, time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, stale.Attempts)
+	_, err = backend.Claim(ctx, []string{"task"}, time.Minute)
+	assert.ErrorIs(t, err, ErrNoJob)
+
+	now = now.Add(2 * time.Minute)
+	j, err := backend.Claim(ctx, []string{"task"}, time.Minute)
+	assert.NoError(t, err, "Expected job of a stopped worker claimed again")
+	assert.Equal(t, 2, j.Attempts)
+	_, err = backend.Claim(ctx, []string{"other"}, time.Minute)
+	assert.ErrorIs(t, err, ErrNoJob)
+
+	t.Run("Stale worker can't record its result", func(t *testing.T) {
+		assert.ErrorIs(t, backend.Complete(ctx, stale), ErrLeaseLost)
+		assert.ErrorIs(t, backend.Retry(ctx, stale, now, "timeout"), ErrLeaseLost)
+		assert.ErrorIs(t, backend.Fail(ctx, stale, "timeout"), ErrLeaseLost)
+		running, err := backend.List(ctx, STATUS_RUNNING, 10)
+		assert.NoError(t, err)
+		assert.Len(t, running, 1, "Expected the job of the new claim untouched")
+
+		assert.NoError(t, backend.Complete(ctx, j))
+		assert.ErrorIs(t, backend.Complete(ctx, j), ErrLeaseLost)
+	})
+
+	t.Run("Stale worker can't record its result after requeue", func(t *testing.T) {
+		assert.NoError(t, backend.Enqueue(ctx, &Job{ID: "j2", Kind: "task", Status: STATUS_QUEUED, RunAt: now}))
+		stale, err := backend.Claim(ctx, []string{"task"}, time.Minute)
+		assert.NoError(t, err)
+		now = now.Add(2 * time.Minute)
+		j, err := backend.Claim(ctx, []string{"task"}, time.Minute)
+		assert.NoError(t, err)
+		assert.NoError(t, backend.Fail(ctx, j, "boom"))
+		assert.NoError(t, backend.Requeue(ctx, "j2"))
+		j, err = backend.Claim(ctx, []string{"task"}, time.Minute)
+		assert.NoError(t, err)
+		assert.Equal(t, stale.Attempts, j.Attempts, "Expected attempts of the stale claim reached again")
+
+		assert.ErrorIs(t, backend.Complete(ctx, stale), ErrLeaseLost)
+		assert.NoError(t, backend.Complete(ctx, j))
+	})
+}
+
+func TestExponentialBackoff(t *testing.T) {
+	for attempts, max := range map[int]time.Duration{1: RETRY_DELAY, 3: 4 * RETRY_DELAY, 50: MAX_RETRY_DELAY} {
+		delay := ExponentialBackoff(attempts)
+		assert.GreaterOrEqual(t, delay, max/2)
+		assert.LessOrEqual(t, delay, max)
+	}
+}
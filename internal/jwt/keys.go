@@ -0,0 +1,402 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sync"
+)
+
+// Supported signing algorithms
+const (
+	ALG_EDDSA = "EdDSA"
+	ALG_ES256 = "ES256"
+	ALG_RS256 = "RS256"
+)
+
+// Smallest accepted RSA key size in bits
+const MIN_RSA_BITS = 2048
+
+var (
+	ErrUnsupportedKey = errors.New("unsupported key type")
+	ErrNoSigningKey   = errors.New("keyring has no signing key")
+)
+
+// Private signing key identified by the RFC 7638 thumbprint of its
+// public part. The algorithm is derived from the key type: Ed25519 keys
+// sign with EdDSA, P-256 keys with ES256 and RSA keys with RS256.
+type Key struct {
+	ID        string
+	Algorithm string
+	signer    crypto.Signer
+}
+
+// Wrap the private key. Only Ed25519, ECDSA P-256 and RSA (2048+ bits)
+// keys are supported.
+func NewKey(signer crypto.Signer) (*Key, error) {
+	key := &Key{signer: signer}
+	switch k := signer.(type) {
+	case ed25519.PrivateKey:
+		key.Algorithm = ALG_EDDSA
+	case *ecdsa.PrivateKey:
+		if k.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("%w: only P-256 curve is supported", ErrUnsupportedKey)
+		}
+		key.Algorithm = ALG_ES256
+	case *rsa.PrivateKey:
+		if k.N.BitLen() < MIN_RSA_BITS {
+			return nil, fmt.Errorf("%w: rsa key must have at least %d bits", ErrUnsupportedKey, MIN_RSA_BITS)
+		}
+		key.Algorithm = ALG_RS256
+	default:
+		return nil, ErrUnsupportedKey
+	}
+	jwk, err := key.JWK()
+	if err != nil {
+		return nil, err
+	}
+	key.ID = jwk.thumbprint()
+	return key, nil
+}
+
+// Generate new random key for the algorithm. Handy for tests and
+// development, production keys should be loaded from files.
+func GenerateKey(alg string) (*Key, error) {
+	var (
+		signer crypto.Signer
+		err    error
+	)
+	switch alg {
+	case ALG_EDDSA:
+		_, signer, err = ed25519.GenerateKey(rand.Reader)
+	case ALG_ES256:
+		signer, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case ALG_RS256:
+		signer, err = rsa.GenerateKey(rand.Reader, MIN_RSA_BITS)
+	default:
+		return nil, ErrUnsupportedKey
+	}
+	if err != nil {
+		return nil, err
+	}
+	return NewKey(signer)
+}
+
+// Parse PEM encoded private key in PKCS#8, SEC 1 (EC) or PKCS#1 (RSA) format
+func ParseKey(data []byte) (*Key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("key is not PEM encoded")
+	}
+	var (
+		parsed any
+		err    error
+	)
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		parsed, err = x509.ParseECPrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedKey, block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := parsed.(crypto.Signer)
+	if !ok {
+		return nil, ErrUnsupportedKey
+	}
+	return NewKey(signer)
+}
+
+// Encode the private key as PKCS#8 PEM block
+func (k *Key) MarshalPEM() ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(k.signer)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// Public key in the JWK format (RFC 7517)
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid,omitempty"`
+	Use       string `json:"use,omitempty"`
+	Algorithm string `json:"alg,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+}
+
+// Set of public keys served on the JWKS endpoint
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+func (k *Key) JWK() (JWK, error) {
+	return k.Public().JWK()
+}
+
+// Public part of the key
+func (k *Key) Public() *PublicKey {
+	return &PublicKey{ID: k.ID, Algorithm: k.Algorithm, key: k.signer.Public()}
+}
+
+// Key verifying token signatures
+type PublicKey struct {
+	ID        string
+	Algorithm string
+	key       crypto.PublicKey
+}
+
+func (k *PublicKey) JWK() (JWK, error) {
+	jwk := JWK{KeyID: k.ID, Use: "sig", Algorithm: k.Algorithm}
+	switch pub := k.key.(type) {
+	case ed25519.PublicKey:
+		jwk.KeyType, jwk.Curve, jwk.X = "OKP", "Ed25519", encode(pub)
+	case *ecdsa.PublicKey:
+		ecdhKey, err := pub.ECDH()
+		if err != nil {
+			return JWK{}, err
+		}
+		// Uncompressed point: 0x04 || X || Y
+		point := ecdhKey.Bytes()
+		jwk.KeyType, jwk.Curve = "EC", "P-256"
+		jwk.X, jwk.Y = encode(point[1:33]), encode(point[33:])
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N, jwk.E = encode(pub.N.Bytes()), encode(big.NewInt(int64(pub.E)).Bytes())
+	default:
+		return JWK{}, ErrUnsupportedKey
+	}
+	return jwk, nil
+}
+
+// Parse the public key. Missing algorithm is derived from the key type.
+func (j JWK) PublicKey() (*PublicKey, error) {
+	pub := &PublicKey{ID: j.KeyID, Algorithm: j.Algorithm}
+	var expected string
+	switch {
+	case j.KeyType == "OKP" && j.Curve == "Ed25519":
+		x, err := decode(j.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("%w: invalid ed25519 key", ErrUnsupportedKey)
+		}
+		pub.key, expected = ed25519.PublicKey(x), ALG_EDDSA
+	case j.KeyType == "EC" && j.Curve == "P-256":
+		x, errX := decode(j.X)
+		y, errY := decode(j.Y)
+		if errX != nil || errY != nil || len(x) != es256Size || len(y) != es256Size {
+			return nil, fmt.Errorf("%w: invalid P-256 key", ErrUnsupportedKey)
+		}
+		// Parsing the point checks it lies on the curve
+		if _, err := ecdh.P256().NewPublicKey(append(append([]byte{4}, x...), y...)); err != nil {
+			return nil, fmt.Errorf("%w: invalid P-256 key", ErrUnsupportedKey)
+		}
+		pub.key = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		expected = ALG_ES256
+	case j.KeyType == "RSA":
+		n, errN := decode(j.N)
+		e, errE := decode(j.E)
+		if errN != nil || errE != nil || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("%w: invalid rsa key", ErrUnsupportedKey)
+		}
+		key := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		if key.N.BitLen() < MIN_RSA_BITS {
+			return nil, fmt.Errorf("%w: rsa key must have at least %d bits", ErrUnsupportedKey, MIN_RSA_BITS)
+		}
+		pub.key, expected = key, ALG_RS256
+	default:
+		return nil, ErrUnsupportedKey
+	}
+	if pub.Algorithm == "" {
+		pub.Algorithm = expected
+	}
+	if pub.Algorithm != expected {
+		return nil, fmt.Errorf("%w: algorithm %s", ErrUnsupportedKey, pub.Algorithm)
+	}
+	return pub, nil
+}
+
+// RFC 7638 thumbprint - hash of the required members in lexicographic order
+func (j JWK) thumbprint() string {
+	var members string
+	switch j.KeyType {
+	case "OKP":
+		members = fmt.Sprintf(`{"crv":%q,"kty":%q,"x":%q}`, j.Curve, j.KeyType, j.X)
+	case "EC":
+		members = fmt.Sprintf(`{"crv":%q,"kty":%q,"x":%q,"y":%q}`, j.Curve, j.KeyType, j.X, j.Y)
+	default:
+		members = fmt.Sprintf(`{"e":%q,"kty":%q,"n":%q}`, j.E, j.KeyType, j.N)
+	}
+	sum := sha256.Sum256([]byte(members))
+	return encode(sum[:])
+}
+
+// Signing key together with older keys still accepted for verification.
+// Rotating puts a new key in charge of signing while tokens signed with
+// the previous one remain valid until it's retired - retire it once the
+// longest lived token signed by it has expired.
+type Keyring struct {
+	mu      sync.RWMutex
+	signing *Key
+	keys    map[string]*Key
+	order   []string
+}
+
+// Create keyring signing with the first key. Remaining keys are only
+// used to verify tokens issued before the last rotation.
+func NewKeyring(keys ...*Key) (*Keyring, error) {
+	if len(keys) == 0 {
+		return nil, ErrNoSigningKey
+	}
+	ring := &Keyring{keys: make(map[string]*Key)}
+	for i := len(keys) - 1; i >= 0; i-- {
+		ring.Rotate(keys[i])
+	}
+	return ring, nil
+}
+
+// Load keyring from PEM files. The first file holds the signing key.
+func LoadKeyring(paths []string) (*Keyring, error) {
+	keys := make([]*Key, 0, len(paths))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		key, err := ParseKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("jwt key %s: %w", path, err)
+		}
+		keys = append(keys, key)
+	}
+	return NewKeyring(keys...)
+}
+
+// Make the key the signing key keeping the previous ones for verification
+func (r *Keyring) Rotate(key *Key) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.keys[key.ID]; !ok {
+		r.order = append([]string{key.ID}, r.order...)
+	}
+	r.keys[key.ID] = key
+	r.signing = key
+}
+
+// Remove verification key. The signing key can't be retired.
+func (r *Keyring) Retire(id string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.keys[id]; !ok || r.signing.ID == id {
+		return false
+	}
+	delete(r.keys, id)
+	for i, kid := range r.order {
+		if kid == id {
+			r.order = append(r.order[:i], r.order[i+1:]...)
+			break
+		}
+	}
+	return true
+}
+
+func (r *Keyring) SigningKey() *Key {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.signing
+}
+
+// Find verification key by its ID
+func (r *Keyring) Lookup(id string) (*Key, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	key, ok := r.keys[id]
+	return key, ok
+}
+
+// Public keys of the keyring, newest first
+func (r *Keyring) JWKS() (JWKS, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	set := JWKS{Keys: make([]JWK, 0, len(r.order))}
+	for _, id := range r.order {
+		jwk, err := r.keys[id].JWK()
+		if err != nil {
+			return JWKS{}, err
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set, nil
+}
+
+// Source of keys verifying token signatures
+type KeySet interface {
+	VerificationKey(id string) (*PublicKey, bool)
+}
+
+func (r *Keyring) VerificationKey(id string) (*PublicKey, bool) {
+	key, ok := r.Lookup(id)
+	if !ok {
+		return nil, false
+	}
+	return key.Public(), true
+}
+
+// Public keys of another issuer, e.g. fetched from its JWKS endpoint
+type PublicKeySet struct {
+	keys map[string]*PublicKey
+}
+
+// Parse signing keys of the set. Encryption keys and keys of unsupported
+// types are skipped, as issuers may publish more than we can verify.
+func NewPublicKeySet(set JWKS) *PublicKeySet {
+	keys := make(map[string]*PublicKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		if key, err := jwk.PublicKey(); err == nil {
+			keys[key.ID] = key
+		}
+	}
+	return &PublicKeySet{keys: keys}
+}
+
+// Tokens without key ID are accepted only if the set has a single key
+func (s *PublicKeySet) VerificationKey(id string) (*PublicKey, bool) {
+	if id == "" && len(s.keys) == 1 {
+		for _, key := range s.keys {
+			return key, true
+		}
+	}
+	key, ok := s.keys[id]
+	return key, ok
+}
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decode(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(s)
+}
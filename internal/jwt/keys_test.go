@@ -0,0 +1,163 @@
+package jwt
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignParse(t *testing.T) {
+	for _, alg := range []string{ALG_EDDSA, ALG_ES256, ALG_RS256} {
+		t.Run(alg, func(t *testing.T) {
+			key, err := GenerateKey(alg)
+			assert.NoError(t, err)
+			assert.Equal(t, alg, key.Algorithm)
+			ring, err := NewKeyring(key)
+			assert.NoError(t, err)
+
+			token, err := Sign(&Claims{Subject: "u1", Audience: Audience{"mobile"}}, key)
+			assert.NoError(t, err)
+			claims := &Claims{}
+			assert.NoError(t, Parse(token, ring, claims))
+			assert.Equal(t, "u1", claims.Subject)
+			assert.Equal(t, Audience{"mobile"}, claims.Audience)
+
+			parts := strings.Split(token, ".")
+			tampered := parts[0] + "." + encode([]byte(`{"sub":"admin"}`)) + "." + parts[2]
+			assert.ErrorIs(t, Parse(tampered, ring, &Claims{}), ErrSignature)
+		})
+	}
+
+	t.Run("Rejects foreign keys and algorithms", func(t *testing.T) {
+		key, _ := GenerateKey(ALG_EDDSA)
+		other, _ := GenerateKey(ALG_EDDSA)
+		ring, _ := NewKeyring(key)
+
+		token, _ := Sign(&Claims{Subject: "u1"}, other)
+		assert.ErrorIs(t, Parse(token, ring, &Claims{}), ErrUnknownKey)
+
+		header := encode([]byte(`{"alg":"none","kid":"` + key.ID + `"}`))
+		assert.ErrorIs(t, Parse(header+"."+encode([]byte(`{}`))+".", ring, &Claims{}), ErrSignature)
+		assert.ErrorIs(t, Parse("not-a-token", ring, &Claims{}), ErrInvalidToken)
+	})
+}
+
+func TestParseKey(t *testing.T) {
+	for _, alg := range []string{ALG_EDDSA, ALG_ES256, ALG_RS256} {
+		key, err := GenerateKey(alg)
+		assert.NoError(t, err)
+		data, err := key.MarshalPEM()
+		assert.NoError(t, err)
+
+		parsed, err := ParseKey(data)
+		assert.NoError(t, err)
+		assert.Equal(t, key.ID, parsed.ID, "Expected the same thumbprint for %s", alg)
+		assert.Equal(t, alg, parsed.Algorithm)
+	}
+
+	_, err := ParseKey([]byte("garbage"))
+	assert.Error(t, err)
+}
+
+func TestJWKThumbprint(t *testing.T) {
+	// Example from RFC 7638 section 3.1
+	jwk := JWK{
+		KeyType: "RSA",
+		E:       "AQAB",
+		N:       "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+	}
+	assert.Equal(t, "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs", jwk.thumbprint())
+}
+
+func TestKeyring(t *testing.T) {
+	old, _ := GenerateKey(ALG_ES256)
+	ring, err := NewKeyring(old)
+	assert.NoError(t, err)
+	oldToken, _ := Sign(&Claims{Subject: "u1"}, ring.SigningKey())
+
+	current, _ := GenerateKey(ALG_EDDSA)
+	ring.Rotate(current)
+	assert.Equal(t, current, ring.SigningKey())
+	assert.NoError(t, Parse(oldToken, ring, &Claims{}), "Expected tokens of the previous key valid")
+
+	set, err := ring.JWKS()
+	assert.NoError(t, err)
+	assert.Len(t, set.Keys, 2)
+	assert.Equal(t, current.ID, set.Keys[0].KeyID, "Expected newest key first")
+	assert.Equal(t, "OKP", set.Keys[0].KeyType)
+	assert.Equal(t, "EC", set.Keys[1].KeyType)
+	x, _ := base64.RawURLEncoding.DecodeString(set.Keys[1].X)
+	assert.Len(t, x, 32)
+	data, _ := json.Marshal(set)
+	assert.NotContains(t, string(data), `"d"`, "Expected no private parts")
+
+	assert.False(t, ring.Retire(current.ID), "Expected signing key kept")
+	assert.True(t, ring.Retire(old.ID))
+	assert.ErrorIs(t, Parse(oldToken, ring, &Claims{}), ErrUnknownKey)
+
+	_, err = NewKeyring()
+	assert.ErrorIs(t, err, ErrNoSigningKey)
+}
+
+func TestLoadKeyring(t *testing.T) {
+	dir := t.TempDir()
+	var (
+		paths []string
+		ids   []string
+	)
+	for _, alg := range []string{ALG_RS256, ALG_EDDSA} {
+		key, _ := GenerateKey(alg)
+		data, _ := key.MarshalPEM()
+		path := filepath.Join(dir, alg+".pem")
+		assert.NoError(t, os.WriteFile(path, data, 0o600))
+		paths, ids = append(paths, path), append(ids, key.ID)
+	}
+
+	ring, err := LoadKeyring(paths)
+	assert.NoError(t, err)
+	assert.Equal(t, ids[0], ring.SigningKey().ID, "Expected first file signing")
+	_, ok := ring.Lookup(ids[1])
+	assert.True(t, ok, "Expected other files used for verification")
+
+	_, err = LoadKeyring([]string{filepath.Join(dir, "missing.pem")})
+	assert.Error(t, err)
+}
+
+func TestPublicKeySet(t *testing.T) {
+	var (
+		set    JWKS
+		tokens []string
+	)
+	for _, alg := range []string{ALG_EDDSA, ALG_ES256, ALG_RS256} {
+		key, _ := GenerateKey(alg)
+		jwk, err := key.JWK()
+		assert.NoError(t, err)
+		set.Keys = append(set.Keys, jwk)
+		token, _ := Sign(&Claims{Subject: alg}, key)
+		tokens = append(tokens, token)
+	}
+	set.Keys = append(set.Keys, JWK{KeyType: "RSA", KeyID: "enc", Use: "enc"}, JWK{KeyType: "oct", KeyID: "secret"})
+
+	keys := NewPublicKeySet(set)
+	for _, token := range tokens {
+		assert.NoError(t, Parse(token, keys, &Claims{}), "Expected tokens verified with parsed JWKs")
+	}
+	_, ok := keys.VerificationKey("")
+	assert.False(t, ok, "Expected key ID required with multiple keys")
+
+	single := set.Keys[0]
+	single.KeyID, single.Algorithm = "", ""
+	pub, ok := NewPublicKeySet(JWKS{Keys: []JWK{single}}).VerificationKey("")
+	assert.True(t, ok)
+	assert.Equal(t, ALG_EDDSA, pub.Algorithm, "Expected algorithm derived from key type")
+
+	mismatched := set.Keys[1]
+	mismatched.Algorithm = ALG_RS256
+	_, err := mismatched.PublicKey()
+	assert.ErrorIs(t, err, ErrUnsupportedKey)
+}
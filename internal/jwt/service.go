@@ -0,0 +1,228 @@
+package jwt
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mcgtrt/go-puerto/internal/accounts"
+	"github.com/mcgtrt/go-puerto/internal/auth"
+	"github.com/mcgtrt/go-puerto/internal/logging"
+	"github.com/mcgtrt/go-puerto/utils"
+)
+
+var (
+	ErrExpired     = fmt.Errorf("%w: expired", ErrInvalidToken)
+	ErrNotYetValid = fmt.Errorf("%w: not yet valid", ErrInvalidToken)
+	ErrIssuer      = fmt.Errorf("%w: wrong issuer", ErrInvalidToken)
+	ErrAudience    = fmt.Errorf("%w: wrong audience", ErrInvalidToken)
+	ErrRevoked     = fmt.Errorf("%w: revoked", ErrInvalidToken)
+)
+
+// Response of the token endpoints (RFC 6749 5.1)
+type TokenPair struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+}
+
+// Issues short lived access tokens with single-use refresh tokens and
+// verifies access tokens presented by clients
+type Service struct {
+	Keys       *Keyring
+	Issuer     string
+	Audience   []string
+	AccessTTL  time.Duration
+	RefreshTTL time.Duration
+	// Tolerated difference between the clocks of the issuer and the
+	// servers verifying the tokens
+	ClockSkew time.Duration
+	Refresh   RefreshStore
+	Revoked   RevocationList
+	// Users are looked up on refresh, so deleted and locked users can't
+	// keep their tokens alive. Nil when tokens aren't issued to accounts.
+	Users accounts.UserStore
+	now   func() time.Time
+}
+
+func NewService(keys *Keyring, cfg *utils.JWTConfig, refresh RefreshStore, revoked RevocationList) *Service {
+	return &Service{
+		Keys:       keys,
+		Issuer:     cfg.Issuer,
+		Audience:   cfg.Audience,
+		AccessTTL:  cfg.AccessTTL,
+		RefreshTTL: cfg.RefreshTTL,
+		ClockSkew:  cfg.ClockSkew,
+		Refresh:    refresh,
+		Revoked:    revoked,
+		now:        time.Now,
+	}
+}
+
+// Sign access token of the principal for every configured audience
+func (s *Service) IssueAccessToken(p *auth.Principal) (string, *Claims, error) {
+	key := s.Keys.SigningKey()
+	if key == nil {
+		return "", nil, ErrNoSigningKey
+	}
+	now := s.now()
+	claims := &Claims{
+		Issuer:    s.Issuer,
+		Subject:   p.ID,
+		Audience:  s.Audience,
+		ExpiresAt: now.Add(s.AccessTTL).Unix(),
+		NotBefore: now.Unix(),
+		IssuedAt:  now.Unix(),
+		ID:        newID(),
+		Scope:     strings.Join(p.Scopes, " "),
+	}
+	token, err := Sign(claims, key)
+	if err != nil {
+		return "", nil, err
+	}
+	return token, claims, nil
+}
+
+// Issue access token and refresh token starting a new token family
+func (s *Service) IssueTokens(ctx context.Context, p *auth.Principal) (*TokenPair, error) {
+	return s.issuePair(ctx, p, newID())
+}
+
+func (s *Service) issuePair(ctx context.Context, p *auth.Principal, familyID string) (*TokenPair, error) {
+	access, claims, err := s.IssueAccessToken(p)
+	if err != nil {
+		return nil, err
+	}
+	refresh, hash, err := accounts.NewToken()
+	if err != nil {
+		return nil, err
+	}
+	err = s.Refresh.Save(ctx, &RefreshToken{
+		Hash:      hash,
+		FamilyID:  familyID,
+		UserID:    p.ID,
+		Scopes:    p.Scopes,
+		ExpiresAt: s.now().Add(s.RefreshTTL),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &TokenPair{
+		AccessToken:  access,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(s.AccessTTL.Seconds()),
+		RefreshToken: refresh,
+		Scope:        claims.Scope,
+	}, nil
+}
+
+// Exchange the refresh token for a new token pair of the same family.
+// Presenting an already used token revokes the whole family, so both the
+// thief and the legitimate client have to log in again. So does refreshing
+// tokens of a deleted or locked user.
+func (s *Service) RefreshTokens(ctx context.Context, refreshToken string) (*TokenPair, error) {
+	t, err := s.Refresh.Use(ctx, accounts.HashToken(refreshToken))
+	if errors.Is(err, ErrRefreshReused) {
+		logging.FromContext(ctx).Warn("refresh token reused, revoking token family", "user_id", t.UserID)
+		if err := s.Refresh.RevokeFamily(ctx, t.FamilyID); err != nil {
+			return nil, err
+		}
+		return nil, ErrRefreshReused
+	}
+	if err != nil {
+		return nil, err
+	}
+	if s.Users != nil {
+		user, err := s.Users.GetUserByID(ctx, t.UserID)
+		if err != nil && !errors.Is(err, accounts.ErrUserNotFound) {
+			return nil, err
+		}
+		if user == nil || user.IsLocked(s.now()) {
+			logging.FromContext(ctx).Warn("refresh of deleted or locked user, revoking token family", "user_id", t.UserID)
+			if err := s.Refresh.RevokeFamily(ctx, t.FamilyID); err != nil {
+				return nil, err
+			}
+			return nil, ErrRefreshNotFound
+		}
+	}
+	return s.issuePair(ctx, &auth.Principal{ID: t.UserID, Method: auth.METHOD_BEARER, Scopes: t.Scopes}, t.FamilyID)
+}
+
+// Revoke the family of the refresh token (e.g. on logout of the client)
+func (s *Service) RevokeRefreshToken(ctx context.Context, refreshToken string) error {
+	t, err := s.Refresh.Use(ctx, accounts.HashToken(refreshToken))
+	if errors.Is(err, ErrRefreshNotFound) {
+		return nil
+	}
+	if t == nil {
+		return err
+	}
+	return s.Refresh.RevokeFamily(ctx, t.FamilyID)
+}
+
+// Revoke the access token before it expires
+func (s *Service) RevokeAccessToken(ctx context.Context, claims *Claims) error {
+	expiresAt := time.Unix(claims.ExpiresAt, 0).Add(s.ClockSkew)
+	return s.Revoked.Revoke(ctx, claims.ID, expiresAt)
+}
+
+// Verify signature, issuer, audience, validity period (with clock skew)
+// and revocation of the access token
+func (s *Service) Verify(ctx context.Context, token string) (*Claims, error) {
+	claims := &Claims{}
+	if err := Parse(token, s.Keys, claims); err != nil {
+		return nil, err
+	}
+	if claims.Issuer != s.Issuer {
+		return nil, ErrIssuer
+	}
+	if !claims.Audience.Contains(s.Audience) {
+		return nil, ErrAudience
+	}
+	now := s.now()
+	if claims.ExpiresAt == 0 || now.Add(-s.ClockSkew).Unix() >= claims.ExpiresAt {
+		return nil, ErrExpired
+	}
+	if now.Add(s.ClockSkew).Unix() < claims.NotBefore || now.Add(s.ClockSkew).Unix() < claims.IssuedAt {
+		return nil, ErrNotYetValid
+	}
+	if claims.ID != "" && s.Revoked != nil {
+		revoked, err := s.Revoked.IsRevoked(ctx, claims.ID)
+		if err != nil {
+			return nil, err
+		}
+		if revoked {
+			return nil, ErrRevoked
+		}
+	}
+	return claims, nil
+}
+
+// Verifier of auth.BearerStrategy. Tokens carry only the subject and
+// scopes, so email and name of the principal are empty.
+func (s *Service) Authenticate(ctx context.Context, token string) (*auth.Principal, error) {
+	claims, err := s.Verify(ctx, token)
+	if errors.Is(err, ErrInvalidToken) {
+		return nil, fmt.Errorf("%w: %w", auth.ErrInvalidCredentials, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var scopes []string
+	if claims.Scope != "" {
+		scopes = strings.Fields(claims.Scope)
+	}
+	return &auth.Principal{ID: claims.Subject, Method: auth.METHOD_BEARER, Scopes: scopes}, nil
+}
+
+func newID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return base64.RawURLEncoding.EncodeToString(id)
+}
@@ -0,0 +1,153 @@
+package jwt
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mcgtrt/go-puerto/internal/accounts"
+	"github.com/mcgtrt/go-puerto/internal/auth"
+	"github.com/mcgtrt/go-puerto/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestService(t *testing.T) (*Service, *MemoryStore) {
+	key, err := GenerateKey(ALG_EDDSA)
+	assert.NoError(t, err)
+	ring, _ := NewKeyring(key)
+	store := NewMemoryStore()
+	s := NewService(ring, &utils.JWTConfig{
+		Issuer:     "https://example.com",
+		Audience:   []string{"mobile"},
+		AccessTTL:  15 * time.Minute,
+		RefreshTTL: 24 * time.Hour,
+		ClockSkew:  time.Minute,
+	}, store, store)
+	return s, store
+}
+
+func TestVerify(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newTestService(t)
+	now := time.Now()
+	s.now = func() time.Time { return now }
+
+	token, issued, err := s.IssueAccessToken(&auth.Principal{ID: "u1", Scopes: []string{"orders:read", "orders:write"}})
+	assert.NoError(t, err)
+	claims, err := s.Verify(ctx, token)
+	assert.NoError(t, err)
+	assert.Equal(t, issued, claims)
+
+	t.Run("Clock skew", func(t *testing.T) {
+		s.now = func() time.Time { return now.Add(15*time.Minute + 30*time.Second) }
+		_, err := s.Verify(ctx, token)
+		assert.NoError(t, err, "Expected expiry tolerated within skew")
+
+		s.now = func() time.Time { return now.Add(16*time.Minute + time.Second) }
+		_, err = s.Verify(ctx, token)
+		assert.ErrorIs(t, err, ErrExpired)
+
+		s.now = func() time.Time { return now.Add(-2 * time.Minute) }
+		_, err = s.Verify(ctx, token)
+		assert.ErrorIs(t, err, ErrNotYetValid)
+		s.now = func() time.Time { return now }
+	})
+
+	t.Run("Issuer and audience", func(t *testing.T) {
+		s.Issuer = "https://evil.example.com"
+		_, err := s.Verify(ctx, token)
+		assert.ErrorIs(t, err, ErrIssuer)
+		s.Issuer = "https://example.com"
+
+		s.Audience = []string{"web"}
+		_, err = s.Verify(ctx, token)
+		assert.ErrorIs(t, err, ErrAudience)
+		s.Audience = []string{"web", "mobile"}
+		_, err = s.Verify(ctx, token)
+		assert.NoError(t, err)
+	})
+
+	t.Run("Revocation", func(t *testing.T) {
+		assert.NoError(t, s.RevokeAccessToken(ctx, claims))
+		_, err := s.Verify(ctx, token)
+		assert.ErrorIs(t, err, ErrRevoked)
+	})
+
+	t.Run("Bearer strategy principal", func(t *testing.T) {
+		token, _, _ := s.IssueAccessToken(&auth.Principal{ID: "u2", Scopes: []string{"orders:read"}})
+		p, err := s.Authenticate(ctx, token)
+		assert.NoError(t, err)
+		assert.Equal(t, &auth.Principal{ID: "u2", Method: auth.METHOD_BEARER, Scopes: []string{"orders:read"}}, p)
+
+		_, err = s.Authenticate(ctx, "garbage")
+		assert.ErrorIs(t, err, auth.ErrInvalidCredentials)
+	})
+}
+
+func TestRefreshTokens(t *testing.T) {
+	ctx := context.Background()
+	s, store := newTestService(t)
+
+	pair, err := s.IssueTokens(ctx, &auth.Principal{ID: "u1", Scopes: []string{"orders:read"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "Bearer", pair.TokenType)
+	assert.Equal(t, 900, pair.ExpiresIn)
+	assert.Equal(t, "orders:read", pair.Scope)
+
+	next, err := s.RefreshTokens(ctx, pair.RefreshToken)
+	assert.NoError(t, err)
+	assert.NotEqual(t, pair.RefreshToken, next.RefreshToken, "Expected rotated refresh token")
+	claims, err := s.Verify(ctx, next.AccessToken)
+	assert.NoError(t, err)
+	assert.Equal(t, "u1", claims.Subject)
+	assert.Equal(t, "orders:read", claims.Scope)
+
+	t.Run("Reuse revokes the family", func(t *testing.T) {
+		_, err := s.RefreshTokens(ctx, pair.RefreshToken)
+		assert.ErrorIs(t, err, ErrRefreshReused)
+		_, err = s.RefreshTokens(ctx, next.RefreshToken)
+		assert.ErrorIs(t, err, ErrRefreshNotFound, "Expected the latest token revoked too")
+	})
+
+	t.Run("Revoke", func(t *testing.T) {
+		pair, _ := s.IssueTokens(ctx, &auth.Principal{ID: "u1"})
+		assert.NoError(t, s.RevokeRefreshToken(ctx, pair.RefreshToken))
+		_, err := s.RefreshTokens(ctx, pair.RefreshToken)
+		assert.ErrorIs(t, err, ErrRefreshNotFound)
+		assert.NoError(t, s.RevokeRefreshToken(ctx, "unknown"))
+
+		pair, _ = s.IssueTokens(ctx, &auth.Principal{ID: "u1"})
+		assert.NoError(t, store.RevokeUser(ctx, "u1"))
+		_, err = s.RefreshTokens(ctx, pair.RefreshToken)
+		assert.ErrorIs(t, err, ErrRefreshNotFound)
+	})
+
+	t.Run("Deleted or locked user", func(t *testing.T) {
+		users := accounts.NewMemoryStore()
+		s.Users = users
+		defer func() { s.Users = nil }()
+
+		pair, _ := s.IssueTokens(ctx, &auth.Principal{ID: "u1"})
+		_, err := s.RefreshTokens(ctx, pair.RefreshToken)
+		assert.ErrorIs(t, err, ErrRefreshNotFound, "Expected tokens of deleted user refused")
+
+		user := &accounts.User{ID: "u1", Email: "john@example.com", LockedUntil: time.Now().Add(time.Hour)}
+		assert.NoError(t, users.CreateUser(ctx, user))
+		pair, _ = s.IssueTokens(ctx, &auth.Principal{ID: "u1"})
+		_, err = s.RefreshTokens(ctx, pair.RefreshToken)
+		assert.ErrorIs(t, err, ErrRefreshNotFound, "Expected tokens of locked user refused")
+
+		user.LockedUntil = time.Time{}
+		assert.NoError(t, users.UpdateUser(ctx, user))
+		pair, _ = s.IssueTokens(ctx, &auth.Principal{ID: "u1"})
+		_, err = s.RefreshTokens(ctx, pair.RefreshToken)
+		assert.NoError(t, err)
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		s.RefreshTTL = -time.Second
+		pair, _ := s.IssueTokens(ctx, &auth.Principal{ID: "u1"})
+		_, err := s.RefreshTokens(ctx, pair.RefreshToken)
+		assert.ErrorIs(t, err, ErrRefreshNotFound)
+	})
+}
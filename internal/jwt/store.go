@@ -0,0 +1,126 @@
+package jwt
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	ErrRefreshNotFound = errors.New("refresh token not found")
+	// Returned when an already used refresh token is presented again,
+	// which means it leaked. The whole token family gets revoked.
+	ErrRefreshReused = errors.New("refresh token reused")
+)
+
+// Single-use refresh token. Every refresh replaces the token with a new
+// one of the same family, so a family is a chain of tokens started by
+// one login. Only the hash of the token is stored.
+type RefreshToken struct {
+	Hash      string
+	FamilyID  string
+	UserID    string
+	Scopes    []string
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+}
+
+// Persistence of refresh tokens
+type RefreshStore interface {
+	Save(ctx context.Context, t *RefreshToken) error
+	// Atomically mark the token used and return it. Returns
+	// ErrRefreshNotFound for unknown, revoked or expired tokens and the
+	// token together with ErrRefreshReused if it was used before.
+	Use(ctx context.Context, hash string) (*RefreshToken, error)
+	// Delete all tokens of the family
+	RevokeFamily(ctx context.Context, familyID string) error
+	// Delete all refresh tokens of the user (e.g. after password change)
+	RevokeUser(ctx context.Context, userID string) error
+}
+
+// Revoked access token IDs. Entries are kept until the token expires.
+type RevocationList interface {
+	Revoke(ctx context.Context, jti string, expiresAt time.Time) error
+	IsRevoked(ctx context.Context, jti string) (bool, error)
+}
+
+// Database backing both refresh tokens and the revocation list
+type Store interface {
+	RefreshStore
+	RevocationList
+}
+
+// Keeps refresh tokens and revoked token IDs in the process memory.
+// Useful for tests and prototyping.
+type MemoryStore struct {
+	mu      sync.Mutex
+	tokens  map[string]RefreshToken
+	revoked map[string]time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		tokens:  make(map[string]RefreshToken),
+		revoked: make(map[string]time.Time),
+	}
+}
+
+func (m *MemoryStore) Save(ctx context.Context, t *RefreshToken) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tokens[t.Hash] = *t
+	return nil
+}
+
+func (m *MemoryStore) Use(ctx context.Context, hash string) (*RefreshToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, ok := m.tokens[hash]
+	now := time.Now()
+	if !ok || !t.ExpiresAt.After(now) {
+		return nil, ErrRefreshNotFound
+	}
+	if t.UsedAt != nil {
+		return &t, ErrRefreshReused
+	}
+	t.UsedAt = &now
+	m.tokens[hash] = t
+	return &t, nil
+}
+
+func (m *MemoryStore) RevokeFamily(ctx context.Context, familyID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for hash, t := range m.tokens {
+		if t.FamilyID == familyID {
+			delete(m.tokens, hash)
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) RevokeUser(ctx context.Context, userID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for hash, t := range m.tokens {
+		if t.UserID == userID {
+			delete(m.tokens, hash)
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) Revoke(ctx context.Context, jti string, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.revoked[jti] = expiresAt
+	return nil
+}
+
+func (m *MemoryStore) IsRevoked(ctx context.Context, jti string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	expiresAt, ok := m.revoked[jti]
+	return ok && expiresAt.After(time.Now()), nil
+}
@@ -0,0 +1,185 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// Every token validation error wraps ErrInvalidToken
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrMalformed    = fmt.Errorf("%w: malformed", ErrInvalidToken)
+	ErrUnknownKey   = fmt.Errorf("%w: signed with unknown key", ErrInvalidToken)
+	ErrSignature    = fmt.Errorf("%w: bad signature", ErrInvalidToken)
+)
+
+// Size of ES256 signature half (r or s) in bytes
+const es256Size = 32
+
+type header struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ,omitempty"`
+	KeyID     string `json:"kid"`
+}
+
+// Registered claims (RFC 7519) of the access tokens. Scope holds space
+// separated scopes as defined in RFC 8693.
+type Claims struct {
+	Issuer    string   `json:"iss,omitempty"`
+	Subject   string   `json:"sub,omitempty"`
+	Audience  Audience `json:"aud,omitempty"`
+	ExpiresAt int64    `json:"exp,omitempty"`
+	NotBefore int64    `json:"nbf,omitempty"`
+	IssuedAt  int64    `json:"iat,omitempty"`
+	ID        string   `json:"jti,omitempty"`
+	Scope     string   `json:"scope,omitempty"`
+}
+
+// Audience claim, which is either a single string or an array of strings
+type Audience []string
+
+func (a Audience) MarshalJSON() ([]byte, error) {
+	if len(a) == 1 {
+		return json.Marshal(a[0])
+	}
+	return json.Marshal([]string(a))
+}
+
+func (a *Audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = Audience{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+// Check if any of the audiences is accepted
+func (a Audience) Contains(accepted []string) bool {
+	for _, aud := range a {
+		for _, ok := range accepted {
+			if aud == ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Sign the claims with the key into a compact JWS
+func Sign(claims any, key *Key) (string, error) {
+	h, err := json.Marshal(header{Algorithm: key.Algorithm, Type: "JWT", KeyID: key.ID})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	input := encode(h) + "." + encode(payload)
+	sig, err := key.sign([]byte(input))
+	if err != nil {
+		return "", err
+	}
+	return input + "." + encode(sig), nil
+}
+
+// Verify the token signature with the key set and decode its claims.
+// The algorithm must match the one of the key named in the header, so
+// "none" and algorithm confusion attacks are rejected. Claims are not
+// validated - it's the job of the caller (see Service.Verify).
+func Parse(token string, keys KeySet, claims any) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return ErrMalformed
+	}
+	var h header
+	if err := decodeSegment(parts[0], &h); err != nil {
+		return ErrMalformed
+	}
+	if h.Type != "" && !strings.EqualFold(h.Type, "JWT") {
+		return ErrMalformed
+	}
+	key, ok := keys.VerificationKey(h.KeyID)
+	if !ok {
+		return ErrUnknownKey
+	}
+	if h.Algorithm != key.Algorithm {
+		return ErrSignature
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return ErrMalformed
+	}
+	if !key.verify([]byte(parts[0]+"."+parts[1]), sig) {
+		return ErrSignature
+	}
+	if err := decodeSegment(parts[1], claims); err != nil {
+		return ErrMalformed
+	}
+	return nil
+}
+
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func (k *Key) sign(input []byte) ([]byte, error) {
+	switch signer := k.signer.(type) {
+	case ed25519.PrivateKey:
+		return ed25519.Sign(signer, input), nil
+	case *ecdsa.PrivateKey:
+		digest := sha256.Sum256(input)
+		r, s, err := ecdsa.Sign(rand.Reader, signer, digest[:])
+		if err != nil {
+			return nil, err
+		}
+		// JWS uses fixed size r || s instead of ASN.1 (RFC 7518 3.4)
+		sig := make([]byte, 2*es256Size)
+		r.FillBytes(sig[:es256Size])
+		s.FillBytes(sig[es256Size:])
+		return sig, nil
+	case *rsa.PrivateKey:
+		digest := sha256.Sum256(input)
+		return rsa.SignPKCS1v15(nil, signer, crypto.SHA256, digest[:])
+	}
+	return nil, ErrUnsupportedKey
+}
+
+func (k *PublicKey) verify(input, sig []byte) bool {
+	switch pub := k.key.(type) {
+	case ed25519.PublicKey:
+		return ed25519.Verify(pub, input, sig)
+	case *ecdsa.PublicKey:
+		if len(sig) != 2*es256Size {
+			return false
+		}
+		digest := sha256.Sum256(input)
+		r := new(big.Int).SetBytes(sig[:es256Size])
+		s := new(big.Int).SetBytes(sig[es256Size:])
+		return ecdsa.Verify(pub, digest[:], r, s)
+	case *rsa.PublicKey:
+		digest := sha256.Sum256(input)
+		return rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig) == nil
+	}
+	return false
+}
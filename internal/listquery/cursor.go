@@ -0,0 +1,67 @@
+package listquery
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/mcgtrt/go-puerto/utils"
+)
+
+// Sort values of the last item of a page. The sort is kept to refuse
+// cursors of lists sorted differently.
+type cursor struct {
+	Sort   string   `json:"s"`
+	Values []string `json:"v"`
+}
+
+// Cursor of the page after the given last item of this page, keyed by
+// the names of the sort fields:
+//
+//	next, err := q.NextCursor(map[string]any{"id": last.ID, "created_at": last.CreatedAt})
+//
+// Cursors are sealed with AES_SECRET, clients can't read or change the
+// values in them.
+func (q *Query) NextCursor(last map[string]any) (string, error) {
+	c := cursor{Sort: q.SortParam(), Values: make([]string, len(q.Sort))}
+	for i, s := range q.Sort {
+		v, ok := last[s.Field.Name]
+		if !ok {
+			return "", fmt.Errorf("no cursor value of %q", s.Field.Name)
+		}
+		c.Values[i] = formatValue(v)
+	}
+	data, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	return utils.SealAES(string(data))
+}
+
+func (q *Query) decodeCursor(sealed string) ([]any, error) {
+	data, err := utils.OpenAES(sealed)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var c cursor
+	if err := json.Unmarshal([]byte(data), &c); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	if c.Sort != q.SortParam() || len(c.Values) != len(q.Sort) {
+		return nil, fmt.Errorf("%w: the list was sorted differently", ErrInvalidCursor)
+	}
+	after := make([]any, len(c.Values))
+	for i, v := range c.Values {
+		if after[i], err = parseValue(q.Sort[i].Field, v); err != nil {
+			return nil, ErrInvalidCursor
+		}
+	}
+	return after, nil
+}
+
+func formatValue(v any) string {
+	if t, ok := v.(time.Time); ok {
+		return t.Format(time.RFC3339Nano)
+	}
+	return fmt.Sprint(v)
+}
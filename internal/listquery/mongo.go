@@ -0,0 +1,84 @@
+package listquery
+
+import (
+	"regexp"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var mongoOps = map[string]string{
+	OP_NE:  "$ne",
+	OP_GT:  "$gt",
+	OP_GTE: "$gte",
+	OP_LT:  "$lt",
+	OP_LTE: "$lte",
+	OP_IN:  "$in",
+}
+
+// Filter of the query for MongoDB, empty without conditions. Conditions
+// are put in a single $and, so the filter can be appended to others:
+//
+//	filter := append(bson.D{{Key: "user_id", Value: userID}}, q.MongoFilter()...)
+//	cursor, err := orders.Find(ctx, filter, q.MongoOptions())
+func (q *Query) MongoFilter() bson.D {
+	conditions := bson.A{}
+	for _, f := range q.Filters {
+		conditions = append(conditions, mongoCondition(f))
+	}
+	if q.After != nil {
+		conditions = append(conditions, mongoKeyset(q))
+	}
+	if len(conditions) == 0 {
+		return bson.D{}
+	}
+	return bson.D{{Key: "$and", Value: conditions}}
+}
+
+// Sort, skip and limit of the query, fetching one more document (see Trim)
+func (q *Query) MongoOptions() *options.FindOptions {
+	sort := bson.D{}
+	for _, s := range q.Sort {
+		direction := 1
+		if s.Desc {
+			direction = -1
+		}
+		sort = append(sort, bson.E{Key: s.Field.column(), Value: direction})
+	}
+	opts := options.Find().SetSort(sort).SetLimit(int64(q.FetchLimit()))
+	if offset := q.Offset(); offset > 0 {
+		opts.SetSkip(int64(offset))
+	}
+	return opts
+}
+
+func mongoCondition(f Filter) bson.D {
+	switch f.Op {
+	case OP_EQ:
+		return bson.D{{Key: f.Field.column(), Value: f.Value}}
+	case OP_CONTAINS:
+		// Quoted, the value is matched literally
+		pattern := primitive.Regex{Pattern: regexp.QuoteMeta(f.Value.(string)), Options: "i"}
+		return bson.D{{Key: f.Field.column(), Value: pattern}}
+	}
+	return bson.D{{Key: f.Field.column(), Value: bson.D{{Key: mongoOps[f.Op], Value: f.Value}}}}
+}
+
+// Documents after the cursor, e.g. {$or: [{a: {$lt: 1}}, {a: 1, b: {$gt: 2}}]}
+func mongoKeyset(q *Query) bson.D {
+	alternatives := bson.A{}
+	for i, s := range q.Sort {
+		alternative := bson.D{}
+		for j := range i {
+			alternative = append(alternative, bson.E{Key: q.Sort[j].Field.column(), Value: q.After[j]})
+		}
+		op := "$gt"
+		if s.Desc {
+			op = "$lt"
+		}
+		alternative = append(alternative, bson.E{Key: s.Field.column(), Value: bson.D{{Key: op, Value: q.After[i]}}})
+		alternatives = append(alternatives, alternative)
+	}
+	return bson.D{{Key: "$or", Value: alternatives}}
+}
@@ -0,0 +1,39 @@
+package listquery
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestMongo(t *testing.T) {
+	s := newTestSpec(true)
+
+	q, err := parse(t, s, "")
+	assert.NoError(t, err)
+	assert.Empty(t, q.MongoFilter())
+
+	q, err = parse(t, s, "sort=-price&price[lte]=100&status=paid&name[contains]=a.b")
+	assert.NoError(t, err)
+	q.After = []any{int64(10), "01J0ABC"}
+	assert.Equal(t, bson.D{{Key: "$and", Value: bson.A{
+		bson.D{{Key: "name", Value: primitive.Regex{Pattern: `a\.b`, Options: "i"}}},
+		bson.D{{Key: "price", Value: bson.D{{Key: "$lte", Value: int64(100)}}}},
+		bson.D{{Key: "status", Value: "paid"}},
+		bson.D{{Key: "$or", Value: bson.A{
+			bson.D{{Key: "price", Value: bson.D{{Key: "$lt", Value: int64(10)}}}},
+			bson.D{{Key: "price", Value: int64(10)}, {Key: "_id", Value: bson.D{{Key: "$lt", Value: "01J0ABC"}}}},
+		}}},
+	}}}, q.MongoFilter())
+
+	opts := q.MongoOptions()
+	assert.Equal(t, bson.D{{Key: "price", Value: -1}, {Key: "_id", Value: -1}}, opts.Sort)
+	assert.Equal(t, int64(11), *opts.Limit)
+	assert.Nil(t, opts.Skip)
+
+	q, err = parse(t, newTestSpec(false), "page=3")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(20), *q.MongoOptions().Skip)
+}
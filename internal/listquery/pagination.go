@@ -0,0 +1,91 @@
+package listquery
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Links to the pages around the current one, keeping the filters and the
+// sort of the request. Empty links have no page, e.g. Prev on the first
+// page. Render them with @layout.Pagination or send them as Link header.
+type Pagination struct {
+	// Current page number, 0 with keyset pagination
+	Page int
+	// Number of pages, 0 when unknown
+	Pages int
+	First string
+	Prev  string
+	Next  string
+	Last  string
+}
+
+// Links of numbered pages. Total is the number of matching items, pass
+// -1 if they aren't counted. More reports if items follow the page, as
+// returned by Trim.
+func (q *Query) Pages(u *url.URL, total int, more bool) Pagination {
+	p := Pagination{Page: q.Page}
+	if total >= 0 {
+		p.Pages = max(1, (total+q.Limit-1)/q.Limit)
+		more = q.Page < p.Pages
+	}
+	if q.Page > 1 {
+		p.First = link(u, PARAM_PAGE, "")
+		p.Prev = link(u, PARAM_PAGE, strconv.Itoa(q.Page-1))
+		if q.Page == 2 {
+			p.Prev = p.First
+		}
+	}
+	if more {
+		p.Next = link(u, PARAM_PAGE, strconv.Itoa(q.Page+1))
+	}
+	if p.Pages > q.Page {
+		p.Last = link(u, PARAM_PAGE, strconv.Itoa(p.Pages))
+	}
+	return p
+}
+
+// Links of keyset pages. Next is the cursor made with NextCursor, empty
+// on the last page. Keyset pages can't go back other than to the first.
+func (q *Query) Cursors(u *url.URL, next string) Pagination {
+	var p Pagination
+	if q.After != nil {
+		p.First = link(u, PARAM_CURSOR, "")
+	}
+	if next != "" {
+		p.Next = link(u, PARAM_CURSOR, next)
+	}
+	return p
+}
+
+// Value of the Link header (RFC 8288) with the links of the pagination,
+// e.g. </orders?page=3>; rel="next"
+func (p Pagination) Header() string {
+	var links []string
+	for _, l := range []struct{ rel, url string }{
+		{"first", p.First},
+		{"prev", p.Prev},
+		{"next", p.Next},
+		{"last", p.Last},
+	} {
+		if l.url != "" {
+			links = append(links, "<"+l.url+`>; rel="`+l.rel+`"`)
+		}
+	}
+	return strings.Join(links, ", ")
+}
+
+// Path and query of the URL with the page or cursor param replaced,
+// removed when the value is empty
+func link(u *url.URL, param, value string) string {
+	query := u.Query()
+	query.Del(PARAM_PAGE)
+	query.Del(PARAM_CURSOR)
+	if value != "" {
+		query.Set(param, value)
+	}
+	if len(query) == 0 {
+		return u.Path
+	}
+	return u.Path + "?" + query.Encode()
+}
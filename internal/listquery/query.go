@@ -0,0 +1,451 @@
+package listquery
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Query parameters read by Parse besides the filters
+const (
+	PARAM_PAGE   = "page"
+	PARAM_CURSOR = "cursor"
+	PARAM_LIMIT  = "limit"
+	PARAM_SORT   = "sort"
+)
+
+// Items per page when the spec sets no limits
+const (
+	DEFAULT_LIMIT = 20
+	MAX_LIMIT     = 100
+)
+
+// Deepest page number and most values of an OP_IN filter when the spec
+// sets no limits. Deep pages make the database scan every skipped row.
+const (
+	MAX_PAGE      = 1000
+	MAX_IN_VALUES = 100
+)
+
+// Types of field values, filter values are parsed into string, int64,
+// float64, bool or time.Time (RFC 3339 or YYYY-MM-DD)
+const (
+	TYPE_STRING = "string"
+	TYPE_INT    = "int"
+	TYPE_FLOAT  = "float"
+	TYPE_BOOL   = "bool"
+	TYPE_TIME   = "time"
+)
+
+// Filter operators, written as name[op]=value, e.g. price[gte]=10. A
+// plain name=value filters with OP_EQ.
+const (
+	OP_EQ  = "eq"
+	OP_NE  = "ne"
+	OP_GT  = "gt"
+	OP_GTE = "gte"
+	OP_LT  = "lt"
+	OP_LTE = "lte"
+	// Any of comma separated values, e.g. status[in]=paid,shipped
+	OP_IN = "in"
+	// Case insensitive substring of string fields
+	OP_CONTAINS = "contains"
+)
+
+var (
+	ErrInvalidQuery  = errors.New("invalid list query")
+	ErrInvalidCursor = errors.New("invalid list cursor")
+)
+
+// Field clients may sort or filter by. Anything not listed in the spec
+// is refused, so columns never come from the request.
+type Field struct {
+	// Name in the query string, e.g. created_at
+	Name string
+	// Column or document field, e.g. o.created_at or _id. Defaults to
+	// Name. It's put into SQL as it is, never set it from user input.
+	Column string
+	Type   string
+	// Fields used in keyset pagination must not be NULL
+	Sortable bool
+	// Operators allowed in filters, none for fields not filtered
+	Ops []string
+}
+
+func (f Field) column() string {
+	if f.Column != "" {
+		return f.Column
+	}
+	return f.Name
+}
+
+// Fields and paging of a list endpoint, declared once per endpoint:
+//
+//	var orderList = listquery.MustSpec(&listquery.Spec{
+//		Fields: []listquery.Field{
+//			{Name: "id", Column: "_id", Type: listquery.TYPE_STRING, Sortable: true},
+//			{Name: "created_at", Type: listquery.TYPE_TIME, Sortable: true, Ops: []string{listquery.OP_GTE, listquery.OP_LT}},
+//			{Name: "status", Type: listquery.TYPE_STRING, Ops: []string{listquery.OP_EQ, listquery.OP_IN}},
+//		},
+//		Key:    "id",
+//		Sort:   "-created_at",
+//		Keyset: true,
+//	})
+//
+// Declare it with MustSpec, so mistakes in the spec are found on start.
+type Spec struct {
+	Fields []Field
+	// Unique sortable field ending every sort, e.g. id, so rows with equal
+	// values keep their order between pages
+	Key string
+	// Sort of queries without one, e.g. -created_at
+	Sort string
+	// Default and max items per page, DEFAULT_LIMIT and MAX_LIMIT if zero
+	Limit    int
+	MaxLimit int
+	// Deepest page number and most values of an OP_IN filter, MAX_PAGE
+	// and MAX_IN_VALUES if zero
+	MaxPage     int
+	MaxInValues int
+	// Paginate with cursors instead of page numbers. Keyset pages stay
+	// fast deep into the list and don't skip rows inserted meanwhile.
+	Keyset bool
+}
+
+// Check the spec for mistakes: unknown types and operators, names listed
+// twice, a key that isn't a sortable field or a default sort by fields
+// that can't be sorted.
+func (s *Spec) Validate() error {
+	names := map[string]bool{}
+	for _, f := range s.Fields {
+		if f.Name == "" {
+			return errors.New("list field without name")
+		}
+		if names[f.Name] {
+			return fmt.Errorf("list field %q listed twice", f.Name)
+		}
+		names[f.Name] = true
+		switch f.Type {
+		case TYPE_STRING, TYPE_INT, TYPE_FLOAT, TYPE_BOOL, TYPE_TIME:
+		default:
+			return fmt.Errorf("list field %q has unknown type %q", f.Name, f.Type)
+		}
+		for _, op := range f.Ops {
+			switch op {
+			case OP_EQ, OP_NE, OP_GT, OP_GTE, OP_LT, OP_LTE, OP_IN:
+			case OP_CONTAINS:
+				if f.Type != TYPE_STRING {
+					return fmt.Errorf("list field %q isn't text, can't allow %q", f.Name, op)
+				}
+			default:
+				return fmt.Errorf("list field %q has unknown operator %q", f.Name, op)
+			}
+		}
+	}
+	if s.Key == "" && s.Keyset {
+		return errors.New("keyset pagination needs a list key")
+	}
+	if s.Key != "" {
+		if f, ok := s.field(s.Key); !ok || !f.Sortable {
+			return fmt.Errorf("list key %q isn't a sortable field", s.Key)
+		}
+	}
+	for _, name := range strings.Split(s.Sort, ",") {
+		name = strings.TrimPrefix(strings.TrimSpace(name), "-")
+		if name == "" {
+			continue
+		}
+		if f, ok := s.field(name); !ok || !f.Sortable {
+			return fmt.Errorf("list sort %q isn't a sortable field", name)
+		}
+	}
+	if s.Limit < 0 || s.MaxLimit < 0 || s.MaxPage < 0 || s.MaxInValues < 0 {
+		return errors.New("list limits can't be negative")
+	}
+	return nil
+}
+
+// Spec checked with Validate, panics on mistakes:
+//
+//	var orderList = listquery.MustSpec(&listquery.Spec{...})
+func MustSpec(s *Spec) *Spec {
+	if err := s.Validate(); err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func (s *Spec) field(name string) (Field, bool) {
+	for _, f := range s.Fields {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return Field{}, false
+}
+
+// Order of one field
+type Sort struct {
+	Field Field
+	Desc  bool
+}
+
+// Condition of one field. Value is []any for OP_IN.
+type Filter struct {
+	Field Field
+	Op    string
+	Value any
+}
+
+// List query parsed from the request. Translate it with SQL or
+// MongoFilter and MongoOptions.
+type Query struct {
+	Spec *Spec
+	// Page number from 1, always 1 with keyset pagination
+	Page  int
+	Limit int
+	// Always ends with the key field of the spec
+	Sort    []Sort
+	Filters []Filter
+	// Sort values of the last item of the previous page, nil on the first
+	// page and with page numbers
+	After []any
+}
+
+// Parse the query string of a list request. Parameters other than
+// page, cursor, limit, sort and the filters of the spec fields are left
+// to the handler. Returns ErrInvalidQuery or ErrInvalidCursor wrapped
+// with the reason, which is safe to show to clients.
+func (s *Spec) Parse(values url.Values) (*Query, error) {
+	q := &Query{Spec: s, Page: 1, Limit: s.Limit}
+	if q.Limit == 0 {
+		q.Limit = DEFAULT_LIMIT
+	}
+	if v := values.Get(PARAM_LIMIT); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 {
+			return nil, fmt.Errorf("%w: limit must be a positive number", ErrInvalidQuery)
+		}
+		q.Limit = limit
+	}
+	maxLimit := s.MaxLimit
+	if maxLimit == 0 {
+		maxLimit = MAX_LIMIT
+	}
+	q.Limit = min(q.Limit, maxLimit)
+
+	if v := values.Get(PARAM_PAGE); v != "" && !s.Keyset {
+		page, err := strconv.Atoi(v)
+		if err != nil || page < 1 {
+			return nil, fmt.Errorf("%w: page must be a positive number", ErrInvalidQuery)
+		}
+		maxPage := s.MaxPage
+		if maxPage == 0 {
+			maxPage = MAX_PAGE
+		}
+		if page > maxPage {
+			return nil, fmt.Errorf("%w: page can't be over %d", ErrInvalidQuery, maxPage)
+		}
+		q.Page = page
+	}
+
+	sort := values.Get(PARAM_SORT)
+	if sort == "" {
+		sort = s.Sort
+	}
+	if err := q.parseSort(sort); err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	// Sorted for the same SQL and arguments on every request
+	slices.Sort(names)
+	for _, name := range names {
+		if err := q.parseFilter(name, values[name]); err != nil {
+			return nil, err
+		}
+	}
+
+	if v := values.Get(PARAM_CURSOR); v != "" && s.Keyset {
+		after, err := q.decodeCursor(v)
+		if err != nil {
+			return nil, err
+		}
+		q.After = after
+	}
+	return q, nil
+}
+
+func (q *Query) parseSort(sort string) error {
+	for _, name := range strings.Split(sort, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		desc := strings.HasPrefix(name, "-")
+		name = strings.TrimPrefix(name, "-")
+		f, ok := q.Spec.field(name)
+		if !ok || !f.Sortable {
+			return fmt.Errorf("%w: can't sort by %q", ErrInvalidQuery, name)
+		}
+		if q.sorted(name) {
+			return fmt.Errorf("%w: %q sorted twice", ErrInvalidQuery, name)
+		}
+		q.Sort = append(q.Sort, Sort{Field: f, Desc: desc})
+	}
+	if q.Spec.Key != "" && !q.sorted(q.Spec.Key) {
+		// In the direction of the last field, so an index of the fields in
+		// one direction serves the sort. Specs declared with MustSpec have
+		// the key among their sortable fields.
+		f, _ := q.Spec.field(q.Spec.Key)
+		desc := len(q.Sort) > 0 && q.Sort[len(q.Sort)-1].Desc
+		q.Sort = append(q.Sort, Sort{Field: f, Desc: desc})
+	}
+	return nil
+}
+
+func (q *Query) sorted(name string) bool {
+	return slices.ContainsFunc(q.Sort, func(s Sort) bool { return s.Field.Name == name })
+}
+
+func (q *Query) parseFilter(param string, values []string) error {
+	switch param {
+	case PARAM_PAGE, PARAM_CURSOR, PARAM_LIMIT, PARAM_SORT:
+		return nil
+	}
+	name, op, bracketed := strings.Cut(param, "[")
+	if bracketed {
+		if !strings.HasSuffix(op, "]") {
+			return fmt.Errorf("%w: malformed filter %q", ErrInvalidQuery, param)
+		}
+		op = strings.TrimSuffix(op, "]")
+	} else {
+		op = OP_EQ
+	}
+	f, ok := q.Spec.field(name)
+	if !bracketed && (!ok || len(f.Ops) == 0) {
+		// Left to the handler, e.g. the tab of the page
+		return nil
+	}
+	if !ok || !slices.Contains(f.Ops, op) {
+		return fmt.Errorf("%w: can't filter %q with %q", ErrInvalidQuery, name, op)
+	}
+	if op == OP_CONTAINS && f.Type != TYPE_STRING {
+		return fmt.Errorf("%w: %q isn't text", ErrInvalidQuery, name)
+	}
+	for _, v := range values {
+		filter := Filter{Field: f, Op: op}
+		if op == OP_IN {
+			items := strings.Split(v, ",")
+			maxValues := q.Spec.MaxInValues
+			if maxValues == 0 {
+				maxValues = MAX_IN_VALUES
+			}
+			if len(items) > maxValues {
+				return fmt.Errorf("%w: %q can't filter by over %d values", ErrInvalidQuery, name, maxValues)
+			}
+			list := []any{}
+			for _, item := range items {
+				value, err := parseValue(f, item)
+				if err != nil {
+					return err
+				}
+				list = append(list, value)
+			}
+			filter.Value = list
+		} else {
+			value, err := parseValue(f, v)
+			if err != nil {
+				return err
+			}
+			filter.Value = value
+		}
+		q.Filters = append(q.Filters, filter)
+	}
+	return nil
+}
+
+func parseValue(f Field, v string) (any, error) {
+	var (
+		value any
+		err   error
+	)
+	switch f.Type {
+	case TYPE_INT:
+		value, err = strconv.ParseInt(v, 10, 64)
+	case TYPE_FLOAT:
+		value, err = strconv.ParseFloat(v, 64)
+	case TYPE_BOOL:
+		value, err = strconv.ParseBool(v)
+	case TYPE_TIME:
+		value, err = parseTime(v)
+	default:
+		value = v
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q must be %s", ErrInvalidQuery, f.Name, describeType(f.Type))
+	}
+	return value, nil
+}
+
+func parseTime(v string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.DateOnly, v)
+}
+
+func describeType(t string) string {
+	switch t {
+	case TYPE_INT:
+		return "a whole number"
+	case TYPE_FLOAT:
+		return "a number"
+	case TYPE_BOOL:
+		return "true or false"
+	case TYPE_TIME:
+		return "a date"
+	}
+	return "text"
+}
+
+// Items skipped before the page with page numbers
+func (q *Query) Offset() int {
+	if q.Spec.Keyset {
+		return 0
+	}
+	return (q.Page - 1) * q.Limit
+}
+
+// Items to fetch, one more than the limit reveals if another page follows
+// without counting the items. Cut it off with Trim.
+func (q *Query) FetchLimit() int {
+	return q.Limit + 1
+}
+
+// Items of the page fetched with FetchLimit and whether more follow
+func Trim[T any](q *Query, items []T) ([]T, bool) {
+	if len(items) > q.Limit {
+		return items[:q.Limit], true
+	}
+	return items, false
+}
+
+// Sort of the query as in the sort parameter, e.g. -created_at,id
+func (q *Query) SortParam() string {
+	names := make([]string, len(q.Sort))
+	for i, s := range q.Sort {
+		names[i] = s.Field.Name
+		if s.Desc {
+			names[i] = "-" + names[i]
+		}
+	}
+	return strings.Join(names, ",")
+}
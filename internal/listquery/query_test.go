@@ -0,0 +1,168 @@
+package listquery
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mcgtrt/go-puerto/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestSpec(keyset bool) *Spec {
+	return &Spec{
+		Fields: []Field{
+			{Name: "id", Column: "_id", Type: TYPE_STRING, Sortable: true},
+			{Name: "created_at", Type: TYPE_TIME, Sortable: true, Ops: []string{OP_GTE, OP_LT}},
+			{Name: "price", Type: TYPE_INT, Sortable: true, Ops: []string{OP_EQ, OP_GTE, OP_LTE}},
+			{Name: "status", Type: TYPE_STRING, Ops: []string{OP_EQ, OP_IN}},
+			{Name: "name", Type: TYPE_STRING, Ops: []string{OP_CONTAINS}},
+			{Name: "tab", Type: TYPE_STRING, Sortable: true},
+		},
+		Key:      "id",
+		Sort:     "-created_at",
+		Limit:    10,
+		MaxLimit: 50,
+		Keyset:   keyset,
+	}
+}
+
+func parse(t *testing.T, s *Spec, query string) (*Query, error) {
+	values, err := url.ParseQuery(query)
+	assert.NoError(t, err)
+	return s.Parse(values)
+}
+
+func TestParse(t *testing.T) {
+	s := newTestSpec(false)
+
+	q, err := parse(t, s, "")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, q.Page)
+	assert.Equal(t, 10, q.Limit)
+	assert.Equal(t, "-created_at,-id", q.SortParam(), "Expected the default sort ended with the key")
+	assert.Empty(t, q.Filters)
+
+	q, err = parse(t, s, "page=3&limit=500&sort=price,-id&price[gte]=10&status[in]=paid,shipped&name[contains]=box&created_at[lt]=2024-05-01&tab=failed")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, q.Page)
+	assert.Equal(t, 50, q.Limit, "Expected the limit capped")
+	assert.Equal(t, 100, q.Offset())
+	assert.Equal(t, "price,-id", q.SortParam())
+	assert.Equal(t, []Filter{
+		{Field: s.Fields[1], Op: OP_LT, Value: time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)},
+		{Field: s.Fields[4], Op: OP_CONTAINS, Value: "box"},
+		{Field: s.Fields[2], Op: OP_GTE, Value: int64(10)},
+		{Field: s.Fields[3], Op: OP_IN, Value: []any{"paid", "shipped"}},
+	}, q.Filters, "Expected filters in the order of their names, unlisted params ignored")
+
+	for _, query := range []string{
+		"page=0",
+		"limit=-1",
+		"sort=secret",
+		"sort=status",
+		"sort=price,price",
+		"price[gte]=cheap",
+		"price[gt]=10",
+		"status[ne]=paid",
+		"secret[eq]=1",
+		"name=box",
+		"price[gte=10",
+		"page=1001",
+		"page=9223372036854775807",
+		"status[in]=" + strings.Repeat("paid,", MAX_IN_VALUES) + "shipped",
+	} {
+		_, err := parse(t, s, query)
+		assert.ErrorIs(t, err, ErrInvalidQuery, query)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, newTestSpec(true).Validate())
+
+	for name, change := range map[string]func(s *Spec){
+		"missing key":        func(s *Spec) { s.Key = "secret" },
+		"unsortable key":     func(s *Spec) { s.Key = "status" },
+		"keyset without key": func(s *Spec) { s.Key = "" },
+		"unknown type":       func(s *Spec) { s.Fields[2].Type = "money" },
+		"unknown operator":   func(s *Spec) { s.Fields[2].Ops = []string{"like"} },
+		"contains non-text":  func(s *Spec) { s.Fields[2].Ops = []string{OP_CONTAINS} },
+		"field twice":        func(s *Spec) { s.Fields = append(s.Fields, s.Fields[0]) },
+		"unsortable sort":    func(s *Spec) { s.Sort = "-status" },
+		"negative limit":     func(s *Spec) { s.Limit = -1 },
+	} {
+		s := newTestSpec(true)
+		change(s)
+		assert.Error(t, s.Validate(), name)
+		assert.Panics(t, func() { MustSpec(s) }, name)
+	}
+}
+
+func TestCursor(t *testing.T) {
+	t.Setenv(utils.AES_SECRET, "0123456789abcdef0123456789abcdef")
+	s := newTestSpec(true)
+	createdAt := time.Date(2024, 5, 1, 12, 30, 0, 123456000, time.UTC)
+
+	q, err := parse(t, s, "page=4")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, q.Offset(), "Expected page numbers ignored by keyset lists")
+	assert.Nil(t, q.After)
+
+	next, err := q.NextCursor(map[string]any{"id": "01J0ABC", "created_at": createdAt})
+	assert.NoError(t, err)
+	assert.NotContains(t, next, "01J0ABC", "Expected the cursor opaque")
+	q, err = parse(t, s, "cursor="+next)
+	assert.NoError(t, err)
+	assert.Equal(t, []any{createdAt, "01J0ABC"}, q.After)
+
+	_, err = q.NextCursor(map[string]any{"id": "01J0ABC"})
+	assert.Error(t, err, "Expected every sort value required")
+	_, err = parse(t, s, "sort=price&cursor="+next)
+	assert.ErrorIs(t, err, ErrInvalidCursor, "Expected cursors of other sorts refused")
+	tampered := []byte(next)
+	tampered[len(tampered)-1] ^= 1
+	_, err = parse(t, s, "cursor="+string(tampered))
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+	_, err = parse(t, s, "cursor=garbage")
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+}
+
+func TestTrim(t *testing.T) {
+	q := &Query{Limit: 2}
+	items, more := Trim(q, []int{1, 2, 3})
+	assert.Equal(t, []int{1, 2}, items)
+	assert.True(t, more)
+	items, more = Trim(q, []int{1, 2})
+	assert.Equal(t, []int{1, 2}, items)
+	assert.False(t, more)
+}
+
+func TestPagination(t *testing.T) {
+	u, _ := url.Parse("/orders?status=paid&page=2&sort=price")
+
+	q, err := parse(t, newTestSpec(false), u.RawQuery)
+	assert.NoError(t, err)
+	p := q.Pages(u, 35, false)
+	assert.Equal(t, Pagination{
+		Page:  2,
+		Pages: 4,
+		First: "/orders?sort=price&status=paid",
+		Prev:  "/orders?sort=price&status=paid",
+		Next:  "/orders?page=3&sort=price&status=paid",
+		Last:  "/orders?page=4&sort=price&status=paid",
+	}, p)
+	assert.Equal(t, `</orders?sort=price&status=paid>; rel="first", </orders?sort=price&status=paid>; rel="prev", `+
+		`</orders?page=3&sort=price&status=paid>; rel="next", </orders?page=4&sort=price&status=paid>; rel="last"`, p.Header())
+
+	p = q.Pages(u, -1, false)
+	assert.Empty(t, p.Next, "Expected no next page without more items")
+	assert.Empty(t, p.Last, "Expected no last page without the total")
+	assert.NotEmpty(t, q.Pages(u, -1, true).Next)
+
+	u, _ = url.Parse("/orders?cursor=abc")
+	q = &Query{After: []any{"01J0ABC"}}
+	p = q.Cursors(u, "def")
+	assert.Equal(t, Pagination{First: "/orders", Next: "/orders?cursor=def"}, p)
+	assert.Empty(t, (&Query{}).Cursors(u, "").Header())
+}
@@ -0,0 +1,135 @@
+package listquery
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Clauses of the query for PostgreSQL. Values are always passed as
+// arguments and columns come from the spec only.
+type SQL struct {
+	// Conditions joined with AND, TRUE without any
+	Where string
+	// e.g. created_at DESC, id
+	OrderBy string
+	// e.g. LIMIT 21 OFFSET 40, fetching one more row (see Trim)
+	Limit string
+	// Arguments of the statement, continuing the arguments given to SQL
+	Args []any
+}
+
+var sqlOps = map[string]string{
+	OP_EQ:  "=",
+	OP_NE:  "<>",
+	OP_GT:  ">",
+	OP_GTE: ">=",
+	OP_LT:  "<",
+	OP_LTE: "<=",
+}
+
+// Translate the query to SQL clauses. Args are the arguments of the
+// statement put before the clauses, placeholders continue their numbers:
+//
+//	s := q.SQL(userID)
+//	rows, err := pool.Query(ctx, `SELECT `+orderColumns+` FROM orders WHERE user_id = $1 AND `+s.Where+
+//		` ORDER BY `+s.OrderBy+` `+s.Limit, s.Args...)
+func (q *Query) SQL(args ...any) SQL {
+	s := SQL{Args: args}
+	var conditions []string
+	for _, f := range q.Filters {
+		conditions = append(conditions, s.condition(f))
+	}
+	if q.After != nil {
+		conditions = append(conditions, s.keyset(q))
+	}
+	s.Where = "TRUE"
+	if len(conditions) > 0 {
+		s.Where = strings.Join(conditions, " AND ")
+	}
+
+	order := make([]string, len(q.Sort))
+	for i, sort := range q.Sort {
+		order[i] = sort.Field.column()
+		if sort.Desc {
+			order[i] += " DESC"
+		}
+	}
+	s.OrderBy = strings.Join(order, ", ")
+
+	s.Limit = "LIMIT " + strconv.Itoa(q.FetchLimit())
+	if offset := q.Offset(); offset > 0 {
+		s.Limit += " OFFSET " + strconv.Itoa(offset)
+	}
+	return s
+}
+
+// Add the argument and return its placeholder
+func (s *SQL) arg(v any) string {
+	s.Args = append(s.Args, v)
+	return "$" + strconv.Itoa(len(s.Args))
+}
+
+func (s *SQL) condition(f Filter) string {
+	column := f.Field.column()
+	switch f.Op {
+	case OP_IN:
+		values := f.Value.([]any)
+		placeholders := make([]string, len(values))
+		for i, v := range values {
+			placeholders[i] = s.arg(v)
+		}
+		return column + " IN (" + strings.Join(placeholders, ", ") + ")"
+	case OP_CONTAINS:
+		return column + " ILIKE " + s.arg("%"+escapeLike(f.Value.(string))+"%")
+	}
+	return column + " " + sqlOps[f.Op] + " " + s.arg(f.Value)
+}
+
+// Rows after the cursor. Sorts in one direction compare rows, e.g.
+// (created_at, id) < ($1, $2), which uses an index of the columns. Mixed
+// directions are expanded to a = $1 AND b > $2 OR a < $1.
+func (s *SQL) keyset(q *Query) string {
+	desc := q.Sort[0].Desc
+	uniform := true
+	for _, sort := range q.Sort {
+		uniform = uniform && sort.Desc == desc
+	}
+	if uniform {
+		columns := make([]string, len(q.Sort))
+		placeholders := make([]string, len(q.Sort))
+		for i, sort := range q.Sort {
+			columns[i] = sort.Field.column()
+			placeholders[i] = s.arg(q.After[i])
+		}
+		return "(" + strings.Join(columns, ", ") + ") " + compare(desc) + " (" + strings.Join(placeholders, ", ") + ")"
+	}
+
+	placeholders := make([]string, len(q.Sort))
+	for i := range q.Sort {
+		placeholders[i] = s.arg(q.After[i])
+	}
+	alternatives := make([]string, len(q.Sort))
+	for i, sort := range q.Sort {
+		var terms []string
+		for j := range i {
+			terms = append(terms, q.Sort[j].Field.column()+" = "+placeholders[j])
+		}
+		terms = append(terms, sort.Field.column()+" "+compare(sort.Desc)+" "+placeholders[i])
+		alternatives[i] = "(" + strings.Join(terms, " AND ") + ")"
+	}
+	return "(" + strings.Join(alternatives, " OR ") + ")"
+}
+
+func compare(desc bool) string {
+	if desc {
+		return "<"
+	}
+	return ">"
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// Matched literally, backslash is the default escape of LIKE
+func escapeLike(v string) string {
+	return likeEscaper.Replace(v)
+}
@@ -0,0 +1,44 @@
+package listquery
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSQL(t *testing.T) {
+	s := newTestSpec(false)
+
+	q, err := parse(t, s, "")
+	assert.NoError(t, err)
+	assert.Equal(t, SQL{Where: "TRUE", OrderBy: "created_at DESC, _id DESC", Limit: "LIMIT 11"}, q.SQL())
+
+	q, err = parse(t, s, "page=2&price[gte]=10&status[in]=paid,shipped&name[contains]=50%25_off")
+	assert.NoError(t, err)
+	sql := q.SQL("user")
+	assert.Equal(t, `name ILIKE $2 AND price >= $3 AND status IN ($4, $5)`, sql.Where)
+	assert.Equal(t, []any{"user", `%50\%\_off%`, int64(10), "paid", "shipped"}, sql.Args)
+	assert.Equal(t, "LIMIT 11 OFFSET 10", sql.Limit)
+}
+
+func TestSQLKeyset(t *testing.T) {
+	s := newTestSpec(true)
+	createdAt := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+
+	q, err := parse(t, s, "status=paid")
+	assert.NoError(t, err)
+	q.After = []any{createdAt, "01J0ABC"}
+	sql := q.SQL()
+	assert.Equal(t, "status = $1 AND (created_at, _id) < ($2, $3)", sql.Where, "Expected rows compared with one direction")
+	assert.Equal(t, []any{"paid", createdAt, "01J0ABC"}, sql.Args)
+	assert.Equal(t, "LIMIT 11", sql.Limit)
+
+	q, err = parse(t, s, "sort=-price,id")
+	assert.NoError(t, err)
+	q.After = []any{int64(10), "01J0ABC"}
+	sql = q.SQL()
+	assert.Equal(t, "((price < $1) OR (price = $1 AND _id > $2))", sql.Where)
+	assert.Equal(t, []any{int64(10), "01J0ABC"}, sql.Args)
+	assert.Equal(t, "price DESC, _id", sql.OrderBy)
+}
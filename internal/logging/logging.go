@@ -0,0 +1,66 @@
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"sync"
+
+	"github.com/mcgtrt/go-puerto/internal/tracing"
+	"github.com/mcgtrt/go-puerto/types"
+	"github.com/mcgtrt/go-puerto/utils"
+)
+
+// Create new structured logger writing JSON or text records to w
+func New(cfg *utils.LoggingConfig, w io.Writer) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: cfg.Level}
+	if cfg.Format == utils.LOG_FORMAT_TEXT {
+		return slog.New(slog.NewTextHandler(w, opts))
+	}
+	return slog.New(slog.NewJSONHandler(w, opts))
+}
+
+// Request-scoped attributes shared by every context derived from the
+// request context. Middlewares deeper in the chain (e.g. authentication)
+// add attributes that are still visible to the access log.
+type scope struct {
+	mu    sync.Mutex
+	attrs []slog.Attr
+}
+
+// Add request-scoped attributes to the context. Every logger returned by
+// FromContext for this context (and its children) will include them.
+func WithAttrs(ctx context.Context, attrs ...slog.Attr) context.Context {
+	if s, ok := ctx.Value(types.LogAttrsCtxKey{}).(*scope); ok {
+		s.mu.Lock()
+		s.attrs = append(s.attrs, attrs...)
+		s.mu.Unlock()
+		return ctx
+	}
+	return context.WithValue(ctx, types.LogAttrsCtxKey{}, &scope{attrs: attrs})
+}
+
+// Returns request-scoped attributes stored in the context
+func Attrs(ctx context.Context) []slog.Attr {
+	s, ok := ctx.Value(types.LogAttrsCtxKey{}).(*scope)
+	if !ok {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]slog.Attr(nil), s.attrs...)
+}
+
+// Returns the default logger enriched with request-scoped attributes
+// and the trace ID of the span stored in the context
+func FromContext(ctx context.Context) *slog.Logger {
+	attrs := Attrs(ctx)
+	args := make([]any, 0, len(attrs)+1)
+	for _, a := range attrs {
+		args = append(args, a)
+	}
+	if traceID := tracing.TraceID(ctx); traceID != "" {
+		args = append(args, slog.String("trace_id", traceID))
+	}
+	return slog.Default().With(args...)
+}
@@ -0,0 +1,52 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"github.com/mcgtrt/go-puerto/internal/tracing"
+	"github.com/mcgtrt/go-puerto/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNew(t *testing.T) {
+	var buf bytes.Buffer
+
+	New(&utils.LoggingConfig{Format: utils.LOG_FORMAT_JSON}, &buf).Info("hello", "key", "value")
+	var record map[string]any
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &record), "Expected JSON log record")
+	assert.Equal(t, "hello", record["msg"])
+	assert.Equal(t, "value", record["key"])
+
+	buf.Reset()
+	New(&utils.LoggingConfig{Format: utils.LOG_FORMAT_TEXT}, &buf).Info("hello", "key", "value")
+	assert.Contains(t, buf.String(), "msg=hello key=value", "Expected text log record")
+
+	buf.Reset()
+	New(&utils.LoggingConfig{Level: slog.LevelWarn}, &buf).Info("hidden")
+	assert.Empty(t, buf.String(), "Expected records below the level to be dropped")
+}
+
+func TestFromContext(t *testing.T) {
+	var buf bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(New(&utils.LoggingConfig{Format: utils.LOG_FORMAT_JSON}, &buf))
+	tracing.SetupInMemory()
+
+	ctx, span := tracing.Tracer().Start(context.Background(), "test")
+	defer span.End()
+	ctx = WithAttrs(ctx, slog.String("user_id", "u1"))
+	ctx = WithAttrs(ctx, slog.String("lang", "en"))
+
+	FromContext(ctx).Info("handled")
+
+	var record map[string]any
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &record), "Expected JSON log record")
+	assert.Equal(t, "u1", record["user_id"])
+	assert.Equal(t, "en", record["lang"])
+	assert.Equal(t, span.SpanContext().TraceID().String(), record["trace_id"])
+	assert.Len(t, Attrs(ctx), 2, "Expected attributes to accumulate")
+}
@@ -0,0 +1,138 @@
+package magiclink
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	ErrLinkNotFound = errors.New("login link not found or expired")
+	ErrLinkPending  = errors.New("login link not confirmed yet")
+	ErrRateLimited  = errors.New("too many login links requested")
+)
+
+// Login link requested by a browser. The emailed token confirms the link,
+// the browser holding the secret of BrowserHash in its cookie gets logged
+// in. Only hashes are stored, so a leaked database can't be used to log
+// in. UserID is empty for addresses without an account, which get no
+// email but a link like any other so the response doesn't reveal them.
+type Link struct {
+	ID          string     `bson:"_id"`
+	TokenHash   string     `bson:"token_hash"`
+	BrowserHash string     `bson:"browser_hash"`
+	Email       string     `bson:"email"`
+	UserID      string     `bson:"user_id"`
+	IP          string     `bson:"ip"`
+	UserAgent   string     `bson:"user_agent"`
+	ConfirmedAt *time.Time `bson:"confirmed_at,omitempty"`
+	ExpiresAt   time.Time  `bson:"expires_at"`
+	CreatedAt   time.Time  `bson:"created_at"`
+}
+
+func (l *Link) IsConfirmed() bool {
+	return l.ConfirmedAt != nil
+}
+
+// Persistence of login links. Expired links are never returned.
+type Store interface {
+	CreateLink(ctx context.Context, l *Link) error
+	// Returns ErrLinkNotFound if it doesn't exist or has expired
+	GetLinkByToken(ctx context.Context, tokenHash string) (*Link, error)
+	// Returns ErrLinkNotFound if it doesn't exist or has expired
+	GetLinkByBrowser(ctx context.Context, browserHash string) (*Link, error)
+	// Atomically mark the link of the token confirmed. Returns
+	// ErrLinkNotFound if it doesn't exist, has expired or was confirmed.
+	ConfirmLink(ctx context.Context, tokenHash string, at time.Time) (*Link, error)
+	// Remove the link returning false if it was removed already
+	DeleteLink(ctx context.Context, id string) (bool, error)
+	// Count link request for the address and return the number of
+	// requests within the window started by the first one
+	RecordRequest(ctx context.Context, email string, window time.Duration) (int, error)
+}
+
+type requests struct {
+	count     int
+	expiresAt time.Time
+}
+
+// Keeps links in the process memory. Useful for tests and prototyping.
+type MemoryStore struct {
+	mu       sync.Mutex
+	links    map[string]Link
+	requests map[string]requests
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		links:    make(map[string]Link),
+		requests: make(map[string]requests),
+	}
+}
+
+func (m *MemoryStore) CreateLink(ctx context.Context, l *Link) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.links[l.ID] = *l
+	return nil
+}
+
+func (m *MemoryStore) GetLinkByToken(ctx context.Context, tokenHash string) (*Link, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.find(func(l Link) bool { return l.TokenHash == tokenHash })
+}
+
+func (m *MemoryStore) GetLinkByBrowser(ctx context.Context, browserHash string) (*Link, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.find(func(l Link) bool { return l.BrowserHash == browserHash })
+}
+
+func (m *MemoryStore) ConfirmLink(ctx context.Context, tokenHash string, at time.Time) (*Link, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	l, err := m.find(func(l Link) bool { return l.TokenHash == tokenHash && !l.IsConfirmed() })
+	if err != nil {
+		return nil, err
+	}
+	l.ConfirmedAt = &at
+	m.links[l.ID] = *l
+	return l, nil
+}
+
+func (m *MemoryStore) DeleteLink(ctx context.Context, id string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.links[id]
+	delete(m.links, id)
+	return ok, nil
+}
+
+func (m *MemoryStore) RecordRequest(ctx context.Context, email string, window time.Duration) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	r := m.requests[email]
+	if !r.expiresAt.After(now) {
+		r = requests{expiresAt: now.Add(window)}
+	}
+	r.count++
+	m.requests[email] = r
+	return r.count, nil
+}
+
+func (m *MemoryStore) find(match func(Link) bool) (*Link, error) {
+	now := time.Now()
+	for id, l := range m.links {
+		if !l.ExpiresAt.After(now) {
+			delete(m.links, id)
+			continue
+		}
+		if match(l) {
+			return &l, nil
+		}
+	}
+	return nil, ErrLinkNotFound
+}
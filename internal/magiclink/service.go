@@ -0,0 +1,188 @@
+package magiclink
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/mcgtrt/go-puerto/internal/accounts"
+	"github.com/mcgtrt/go-puerto/utils"
+)
+
+// Cookie holding the secret of the browser that requested the link. Only
+// that browser gets logged in, wherever the link is opened.
+const BROWSER_COOKIE = "magic_link"
+
+// Path the browser cookie is sent to
+const COOKIE_PATH = "/login/link"
+
+// Login link requested for the address. Token is emailed to the user
+// (nil User means no account - send nothing) and Browser set in the
+// cookie of the requesting browser.
+type Started struct {
+	Link    *Link
+	User    *accounts.User
+	Token   string
+	Browser string
+}
+
+// Passwordless login with links sent by email
+type Service struct {
+	Store    Store
+	Accounts *accounts.Service
+	TTL      time.Duration
+	// Links requested for one address within RequestWindow
+	MaxRequests   int
+	RequestWindow time.Duration
+	now           func() time.Time
+}
+
+func NewService(store Store, service *accounts.Service, cfg *utils.MagicLinkConfig) *Service {
+	return &Service{
+		Store:         store,
+		Accounts:      service,
+		TTL:           cfg.TTL,
+		MaxRequests:   cfg.MaxRequests,
+		RequestWindow: cfg.RequestWindow,
+		now:           time.Now,
+	}
+}
+
+// Start login of the address from the browser with the IP and user agent
+// shown to the user confirming the link on another device. Returns
+// ErrRateLimited when too many links were requested for the address.
+//
+// Links are sent to verified addresses only. Otherwise anyone could
+// register an account with the victim's address, set a password and keep
+// access after the victim logs in with a link.
+func (s *Service) Begin(ctx context.Context, email, ip, userAgent string) (*Started, error) {
+	email = accounts.NormaliseEmail(email)
+	if !utils.IsEmailCorrect(email) {
+		return nil, accounts.ErrInvalidEmail
+	}
+	count, err := s.Store.RecordRequest(ctx, email, s.RequestWindow)
+	if err != nil {
+		return nil, err
+	}
+	if count > s.MaxRequests {
+		return nil, ErrRateLimited
+	}
+
+	user, err := s.Accounts.Store.GetUserByEmail(ctx, email)
+	switch {
+	case errors.Is(err, accounts.ErrUserNotFound):
+		user = nil
+	case err != nil:
+		return nil, err
+	case !user.IsVerified():
+		user = nil
+	}
+
+	token, tokenHash, err := accounts.NewToken()
+	if err != nil {
+		return nil, err
+	}
+	browser, browserHash, err := accounts.NewToken()
+	if err != nil {
+		return nil, err
+	}
+	now := s.now()
+	l := &Link{
+		ID:          utils.NewUUIDv7(),
+		TokenHash:   tokenHash,
+		BrowserHash: browserHash,
+		Email:       email,
+		IP:          ip,
+		UserAgent:   userAgent,
+		ExpiresAt:   now.Add(s.TTL),
+		CreatedAt:   now,
+	}
+	if user != nil {
+		l.UserID = user.ID
+	}
+	if err := s.Store.CreateLink(ctx, l); err != nil {
+		return nil, err
+	}
+	return &Started{Link: l, User: user, Token: token, Browser: browser}, nil
+}
+
+// Return the link of the emailed token without using it up, e.g. to ask
+// the user before confirming
+func (s *Service) Lookup(ctx context.Context, token string) (*Link, error) {
+	l, err := s.Store.GetLinkByToken(ctx, accounts.HashToken(token))
+	if err != nil {
+		return nil, err
+	}
+	if l.IsConfirmed() {
+		return nil, ErrLinkNotFound
+	}
+	return l, nil
+}
+
+// Confirm the link of the emailed token. The token works only once, the
+// browser which requested the link completes the login then.
+func (s *Service) Confirm(ctx context.Context, token string) (*Link, error) {
+	return s.Store.ConfirmLink(ctx, accounts.HashToken(token), s.now())
+}
+
+// Finish login of the browser with the secret. Returns ErrLinkPending until
+// the link gets confirmed and ErrLinkNotFound once it has expired or was
+// used. The link is removed, so each confirmation logs in only once.
+func (s *Service) Complete(ctx context.Context, browser string) (*accounts.User, error) {
+	l, err := s.Store.GetLinkByBrowser(ctx, accounts.HashToken(browser))
+	if err != nil {
+		return nil, err
+	}
+	if !l.IsConfirmed() {
+		return nil, ErrLinkPending
+	}
+	deleted, err := s.Store.DeleteLink(ctx, l.ID)
+	if err != nil {
+		return nil, err
+	}
+	if !deleted {
+		return nil, ErrLinkNotFound
+	}
+	return s.Accounts.Store.GetUserByID(ctx, l.UserID)
+}
+
+// Check if the request comes from the browser which requested the link
+func IsRequestingBrowser(r *http.Request, l *Link) bool {
+	browser, ok := BrowserSecret(r)
+	return ok && accounts.HashToken(browser) == l.BrowserHash
+}
+
+// Return the secret of the browser sending the request, if any
+func BrowserSecret(r *http.Request) (string, bool) {
+	cookie, err := r.Cookie(BROWSER_COOKIE)
+	if err != nil || cookie.Value == "" {
+		return "", false
+	}
+	return cookie.Value, true
+}
+
+// Bind the started login to the browser
+func SetBrowserCookie(w http.ResponseWriter, started *Started, secure bool) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     BROWSER_COOKIE,
+		Value:    started.Browser,
+		Path:     COOKIE_PATH,
+		Expires:  started.Link.ExpiresAt,
+		MaxAge:   int(time.Until(started.Link.ExpiresAt).Seconds()),
+		HttpOnly: true,
+		Secure:   secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func ClearBrowserCookie(w http.ResponseWriter, secure bool) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     BROWSER_COOKIE,
+		Path:     COOKIE_PATH,
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
@@ -0,0 +1,125 @@
+package magiclink
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mcgtrt/go-puerto/internal/accounts"
+	"github.com/mcgtrt/go-puerto/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestService(t *testing.T) (*Service, *accounts.User) {
+	service := accounts.NewService(accounts.NewMemoryStore())
+	service.HashParams = accounts.HashParams{Memory: 1024, Time: 1, Threads: 1, SaltLen: 16, KeyLen: 32}
+	user, token, err := service.Register(context.Background(), "john@example.com", "John", "Secret1!")
+	assert.NoError(t, err)
+	_, err = service.VerifyEmail(context.Background(), token)
+	assert.NoError(t, err)
+	return NewService(NewMemoryStore(), service, &utils.MagicLinkConfig{
+		TTL:           15 * time.Minute,
+		MaxRequests:   3,
+		RequestWindow: time.Hour,
+	}), user
+}
+
+func TestLogin(t *testing.T) {
+	s, user := newTestService(t)
+	ctx := context.Background()
+
+	started, err := s.Begin(ctx, " John@Example.com ", "192.0.2.1", "Firefox")
+	assert.NoError(t, err)
+	assert.Equal(t, user.ID, started.User.ID)
+	assert.NotEqual(t, started.Token, started.Link.TokenHash, "Expected token hashed at rest")
+
+	_, err = s.Complete(ctx, started.Browser)
+	assert.ErrorIs(t, err, ErrLinkPending)
+
+	l, err := s.Lookup(ctx, started.Token)
+	assert.NoError(t, err)
+	assert.Equal(t, "Firefox", l.UserAgent)
+
+	_, err = s.Confirm(ctx, started.Token)
+	assert.NoError(t, err)
+	_, err = s.Confirm(ctx, started.Token)
+	assert.ErrorIs(t, err, ErrLinkNotFound, "Expected single-use token")
+
+	_, err = s.Complete(ctx, "another browser")
+	assert.ErrorIs(t, err, ErrLinkNotFound, "Expected only the requesting browser logged in")
+
+	logged, err := s.Complete(ctx, started.Browser)
+	assert.NoError(t, err)
+	assert.Equal(t, user.ID, logged.ID)
+	_, err = s.Complete(ctx, started.Browser)
+	assert.ErrorIs(t, err, ErrLinkNotFound, "Expected login completed once")
+}
+
+func TestExpiredLink(t *testing.T) {
+	s, _ := newTestService(t)
+	ctx := context.Background()
+	s.now = func() time.Time { return time.Now().Add(-s.TTL) }
+
+	started, err := s.Begin(ctx, "john@example.com", "", "")
+	assert.NoError(t, err)
+	_, err = s.Confirm(ctx, started.Token)
+	assert.ErrorIs(t, err, ErrLinkNotFound)
+	_, err = s.Complete(ctx, started.Browser)
+	assert.ErrorIs(t, err, ErrLinkNotFound)
+}
+
+func TestUnknownAddress(t *testing.T) {
+	s, _ := newTestService(t)
+	ctx := context.Background()
+
+	started, err := s.Begin(ctx, "nobody@example.com", "", "")
+	assert.NoError(t, err)
+	assert.Nil(t, started.User, "Expected no email for unknown address")
+	_, err = s.Complete(ctx, started.Browser)
+	assert.ErrorIs(t, err, ErrLinkPending, "Expected unknown address waiting like any other")
+
+	_, err = s.Begin(ctx, "not an email", "", "")
+	assert.ErrorIs(t, err, accounts.ErrInvalidEmail)
+
+	t.Run("Unverified account", func(t *testing.T) {
+		_, _, err := s.Accounts.Register(ctx, "jane@example.com", "Jane", "Secret1!")
+		assert.NoError(t, err)
+		started, err := s.Begin(ctx, "jane@example.com", "", "")
+		assert.NoError(t, err)
+		assert.Nil(t, started.User)
+	})
+}
+
+func TestRateLimit(t *testing.T) {
+	s, _ := newTestService(t)
+	ctx := context.Background()
+
+	for range s.MaxRequests {
+		_, err := s.Begin(ctx, "john@example.com", "", "")
+		assert.NoError(t, err)
+	}
+	_, err := s.Begin(ctx, "JOHN@example.com", "", "")
+	assert.ErrorIs(t, err, ErrRateLimited)
+	_, err = s.Begin(ctx, "nobody@example.com", "", "")
+	assert.NoError(t, err, "Expected limit per address")
+}
+
+func TestBrowserCookie(t *testing.T) {
+	s, _ := newTestService(t)
+	started, err := s.Begin(context.Background(), "john@example.com", "", "")
+	assert.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	SetBrowserCookie(rec, started, true)
+	cookie := rec.Result().Cookies()[0]
+	assert.True(t, cookie.HttpOnly)
+	assert.True(t, cookie.Secure)
+	assert.Equal(t, COOKIE_PATH, cookie.Path)
+
+	req := httptest.NewRequest(http.MethodGet, COOKIE_PATH+"/confirm", nil)
+	assert.False(t, IsRequestingBrowser(req, started.Link))
+	req.AddCookie(cookie)
+	assert.True(t, IsRequestingBrowser(req, started.Link))
+}
@@ -0,0 +1,37 @@
+package mail
+
+import (
+	"context"
+	"slices"
+	"sync"
+)
+
+// Keeps sent messages in the process memory. Useful for tests.
+type MemoryMailer struct {
+	From string
+
+	mu       sync.Mutex
+	messages []Message
+}
+
+func NewMemoryMailer(from string) *MemoryMailer {
+	return &MemoryMailer{From: from}
+}
+
+func (m *MemoryMailer) Send(ctx context.Context, msg *Message) error {
+	msg = withSender(msg, m.From)
+	if err := msg.Validate(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, *msg)
+	return nil
+}
+
+// Messages sent so far, oldest first
+func (m *MemoryMailer) Messages() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Clone(m.messages)
+}
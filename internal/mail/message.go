@@ -0,0 +1,220 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mcgtrt/go-puerto/utils"
+)
+
+var (
+	ErrInvalidMessage = errors.New("invalid email message")
+	ErrQueueFull      = errors.New("mail queue is full")
+	ErrQueueClosed    = errors.New("mail queue is closed")
+)
+
+// Email to send. From defaults to the sender of the mailer. Text is the
+// plain-text alternative of HTML, at least one of them is required.
+type Message struct {
+	From        string
+	To          []string
+	ReplyTo     string
+	Subject     string
+	HTML        string
+	Text        string
+	Attachments []Attachment
+}
+
+// File attached to the message. ContentType is guessed from the file name
+// when empty.
+type Attachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+// Delivers emails. Implementations are safe for concurrent use.
+type Mailer interface {
+	Send(ctx context.Context, msg *Message) error
+}
+
+// Check the addresses and headers of the message, so broken messages fail
+// before they are queued. From is checked when set, mailers fill in their
+// sender otherwise.
+func (m *Message) Validate() error {
+	if len(m.To) == 0 {
+		return fmt.Errorf("%w: no recipients", ErrInvalidMessage)
+	}
+	for _, addr := range append([]string{m.From, m.ReplyTo}, m.To...) {
+		if addr == "" {
+			continue
+		}
+		if _, err := mail.ParseAddress(addr); err != nil {
+			return fmt.Errorf("%w: address %q: %v", ErrInvalidMessage, addr, err)
+		}
+	}
+	if strings.ContainsAny(m.Subject, "\r\n") {
+		return fmt.Errorf("%w: line break in subject", ErrInvalidMessage)
+	}
+	if m.HTML == "" && m.Text == "" {
+		return fmt.Errorf("%w: no body", ErrInvalidMessage)
+	}
+	for _, a := range m.Attachments {
+		if a.Filename == "" || strings.ContainsAny(a.Filename+a.ContentType, "\r\n") {
+			return fmt.Errorf("%w: attachment name %q", ErrInvalidMessage, a.Filename)
+		}
+	}
+	return nil
+}
+
+// Envelope sender and recipients of the message
+func (m *Message) envelope() (string, []string, error) {
+	from, err := mail.ParseAddress(m.From)
+	if err != nil {
+		return "", nil, err
+	}
+	to := make([]string, 0, len(m.To))
+	for _, addr := range m.To {
+		a, err := mail.ParseAddress(addr)
+		if err != nil {
+			return "", nil, err
+		}
+		to = append(to, a.Address)
+	}
+	return from.Address, to, nil
+}
+
+// Encode the message in the MIME format (RFC 5322). Bodies are quoted-
+// printable alternatives wrapped in multipart/mixed with the attachments.
+func (m *Message) Bytes(now time.Time) ([]byte, error) {
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+	if m.From == "" {
+		return nil, fmt.Errorf("%w: no sender", ErrInvalidMessage)
+	}
+	from, _ := mail.ParseAddress(m.From)
+	var buf bytes.Buffer
+	header := func(key, value string) { fmt.Fprintf(&buf, "%s: %s\r\n", key, value) }
+	header("From", from.String())
+	to := make([]string, 0, len(m.To))
+	for _, addr := range m.To {
+		a, _ := mail.ParseAddress(addr)
+		to = append(to, a.String())
+	}
+	header("To", strings.Join(to, ", "))
+	if m.ReplyTo != "" {
+		a, _ := mail.ParseAddress(m.ReplyTo)
+		header("Reply-To", a.String())
+	}
+	header("Subject", mime.QEncoding.Encode("utf-8", m.Subject))
+	header("Date", now.Format(time.RFC1123Z))
+	header("Message-ID", messageID(from.Address))
+	header("MIME-Version", "1.0")
+
+	body, bodyHeader := m.alternatives()
+	if len(m.Attachments) == 0 {
+		for key, values := range bodyHeader {
+			header(key, values[0])
+		}
+		buf.WriteString("\r\n")
+		buf.Write(body)
+		return buf.Bytes(), nil
+	}
+
+	mixed := multipart.NewWriter(&buf)
+	header("Content-Type", "multipart/mixed; boundary="+mixed.Boundary())
+	buf.WriteString("\r\n")
+	part, _ := mixed.CreatePart(bodyHeader)
+	part.Write(body)
+	for _, a := range m.Attachments {
+		contentType := a.ContentType
+		if contentType == "" {
+			contentType = mime.TypeByExtension(filepath.Ext(a.Filename))
+		}
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		part, _ := mixed.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {contentType},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": a.Filename})},
+			"Content-Transfer-Encoding": {"base64"},
+		})
+		writeBase64(part, a.Data)
+	}
+	mixed.Close()
+	return buf.Bytes(), nil
+}
+
+// Body with the plain-text and HTML alternatives and its headers. Mail
+// clients show the last alternative they support, so HTML goes last.
+func (m *Message) alternatives() ([]byte, textproto.MIMEHeader) {
+	textPart := func(contentType, content string) ([]byte, textproto.MIMEHeader) {
+		return quotedPrintable(content), textproto.MIMEHeader{
+			"Content-Type":              {contentType + `; charset="utf-8"`},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		}
+	}
+	switch {
+	case m.HTML == "":
+		return textPart("text/plain", m.Text)
+	case m.Text == "":
+		return textPart("text/html", m.HTML)
+	}
+	var buf bytes.Buffer
+	alt := multipart.NewWriter(&buf)
+	for _, alternative := range [][2]string{{"text/plain", m.Text}, {"text/html", m.HTML}} {
+		body, header := textPart(alternative[0], alternative[1])
+		part, _ := alt.CreatePart(header)
+		part.Write(body)
+	}
+	alt.Close()
+	return buf.Bytes(), textproto.MIMEHeader{"Content-Type": {"multipart/alternative; boundary=" + alt.Boundary()}}
+}
+
+func quotedPrintable(s string) []byte {
+	var buf bytes.Buffer
+	w := quotedprintable.NewWriter(&buf)
+	w.Write([]byte(s))
+	w.Close()
+	return buf.Bytes()
+}
+
+// Write base64 in lines of 76 characters as required by RFC 2045
+func writeBase64(w io.Writer, data []byte) {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 76 {
+		io.WriteString(w, encoded[:76]+"\r\n")
+		encoded = encoded[76:]
+	}
+	io.WriteString(w, encoded+"\r\n")
+}
+
+func messageID(from string) string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	_, domain, _ := strings.Cut(from, "@")
+	return "<" + hex.EncodeToString(b) + "@" + domain + ">"
+}
+
+// Create the mailer of the configured transport
+func New(cfg *utils.MailConfig) Mailer {
+	if cfg.Transport == utils.MAIL_TRANSPORT_FILE {
+		return NewFileMailer(cfg.Dir, cfg.From)
+	}
+	return NewSMTPMailer(cfg.SMTP, cfg.From)
+}
@@ -0,0 +1,98 @@
+package mail
+
+import (
+	"context"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMessageBytes(t *testing.T) {
+	msg := &Message{
+		From:        "Shop <shop@example.com>",
+		To:          []string{"John <john@example.com>"},
+		Subject:     "Zamówienie gotowe",
+		HTML:        "<p>Hello</p>",
+		Text:        "Hello",
+		Attachments: []Attachment{{Filename: "invoice.pdf", Data: []byte("%PDF")}},
+	}
+	data, err := msg.Bytes(time.Now())
+	assert.NoError(t, err)
+
+	parsed, err := mail.ReadMessage(strings.NewReader(string(data)))
+	assert.NoError(t, err)
+	subject, _ := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+	assert.Equal(t, "Zamówienie gotowe", subject)
+	assert.Equal(t, `"John" <john@example.com>`, parsed.Header.Get("To"))
+	assert.True(t, strings.HasSuffix(parsed.Header.Get("Message-ID"), "@example.com>"))
+
+	mediaType, params, _ := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	assert.Equal(t, "multipart/mixed", mediaType)
+	parts := multipart.NewReader(parsed.Body, params["boundary"])
+	body, err := parts.NextPart()
+	assert.NoError(t, err)
+	mediaType, params, _ = mime.ParseMediaType(body.Header.Get("Content-Type"))
+	assert.Equal(t, "multipart/alternative", mediaType)
+
+	alternatives := multipart.NewReader(body, params["boundary"])
+	for _, expected := range []struct{ contentType, content string }{{"text/plain", "Hello"}, {"text/html", "<p>Hello</p>"}} {
+		part, err := alternatives.NextPart()
+		assert.NoError(t, err)
+		assert.Contains(t, part.Header.Get("Content-Type"), expected.contentType)
+		content, _ := io.ReadAll(part)
+		assert.Equal(t, expected.content, string(content), "Expected quoted-printable decoded by the reader")
+	}
+
+	attachment, err := parts.NextPart()
+	assert.NoError(t, err)
+	assert.Equal(t, "invoice.pdf", attachment.FileName())
+	assert.Equal(t, "application/pdf", attachment.Header.Get("Content-Type"))
+}
+
+func TestMessageValidate(t *testing.T) {
+	valid := Message{From: "shop@example.com", To: []string{"john@example.com"}, Subject: "Hi", Text: "Hello"}
+	assert.NoError(t, valid.Validate())
+
+	for name, change := range map[string]func(m *Message){
+		"No recipients":        func(m *Message) { m.To = nil },
+		"Invalid recipient":    func(m *Message) { m.To = []string{"john"} },
+		"Header injection":     func(m *Message) { m.Subject = "Hi\r\nBcc: all@example.com" },
+		"No body":              func(m *Message) { m.Text = "" },
+		"Attachment injection": func(m *Message) { m.Attachments = []Attachment{{Filename: "a\r\n.txt"}} },
+	} {
+		m := valid
+		change(&m)
+		assert.ErrorIs(t, m.Validate(), ErrInvalidMessage, name)
+		assert.True(t, IsPermanent(m.Validate()), name)
+	}
+
+	noSender := valid
+	noSender.From = ""
+	assert.NoError(t, noSender.Validate(), "Expected sender filled in by the mailer")
+	_, err := noSender.Bytes(time.Now())
+	assert.ErrorIs(t, err, ErrInvalidMessage)
+}
+
+func TestFileAndMemoryMailers(t *testing.T) {
+	msg := &Message{To: []string{"john@example.com"}, Subject: "Hi", Text: "Hello"}
+
+	dir := t.TempDir()
+	assert.NoError(t, NewFileMailer(filepath.Join(dir, "mail"), "shop@example.com").Send(context.Background(), msg))
+	files, _ := filepath.Glob(filepath.Join(dir, "mail", "*.eml"))
+	assert.Len(t, files, 1)
+	data, _ := os.ReadFile(files[0])
+	assert.Contains(t, string(data), "From: <shop@example.com>")
+
+	memory := NewMemoryMailer("shop@example.com")
+	assert.NoError(t, memory.Send(context.Background(), msg))
+	assert.Equal(t, "shop@example.com", memory.Messages()[0].From)
+	assert.Empty(t, msg.From, "Expected the message of the caller unchanged")
+}
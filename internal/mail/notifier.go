@@ -0,0 +1,39 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/a-h/templ"
+	"github.com/mcgtrt/go-puerto/internal/accounts"
+	"github.com/mcgtrt/go-puerto/templates/emails"
+)
+
+// Sends account notifications as emails in the language of the user.
+// Use it with a Queue, so requests don't wait for the mail server.
+type AccountNotifier struct {
+	Mailer Mailer
+}
+
+func (n AccountNotifier) Notify(ctx context.Context, notification accounts.Notification) error {
+	lang, user, link := notification.Lang, notification.User, notification.Link
+	var subject string
+	var body templ.Component
+	switch notification.Kind {
+	case accounts.NOTIFY_VERIFY_EMAIL:
+		subject, body = emails.VerifyEmailSubject(lang), emails.VerifyEmail(lang, user.Name, link)
+	case accounts.NOTIFY_PASSWORD_RESET:
+		subject, body = emails.PasswordResetSubject(lang), emails.PasswordReset(lang, user.Name, link)
+	case accounts.NOTIFY_ACCOUNT_EXISTS:
+		subject, body = emails.AccountExistsSubject(lang), emails.AccountExists(lang, user.Name, link)
+	case accounts.NOTIFY_MAGIC_LINK:
+		subject, body = emails.MagicLinkSubject(lang), emails.MagicLink(lang, user.Name, link)
+	default:
+		return fmt.Errorf("no email for account notification %q", notification.Kind)
+	}
+	msg, err := Render(ctx, []string{user.Email}, subject, body)
+	if err != nil {
+		return err
+	}
+	return n.Mailer.Send(ctx, msg)
+}
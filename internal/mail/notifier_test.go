@@ -0,0 +1,44 @@
+package mail
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mcgtrt/go-puerto/internal"
+	"github.com/mcgtrt/go-puerto/internal/accounts"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccountNotifier(t *testing.T) {
+	mailer := NewMemoryMailer("shop@example.com")
+	notifier := AccountNotifier{Mailer: mailer}
+	user := &accounts.User{ID: "user", Email: "john@example.com", Name: "John"}
+
+	err := notifier.Notify(context.Background(), accounts.Notification{Kind: accounts.NOTIFY_PASSWORD_RESET, User: user, Link: "https://example.com/reset-password?token=abc", Lang: "en"})
+	assert.NoError(t, err)
+	msg := mailer.Messages()[0]
+	assert.Equal(t, []string{"john@example.com"}, msg.To)
+	assert.Equal(t, "Reset your password", msg.Subject)
+	assert.Contains(t, msg.HTML, `href="https://example.com/reset-password?token=abc"`)
+	assert.Contains(t, msg.Text, "Reset password (https://example.com/reset-password?token=abc)")
+
+	t.Run("Localised", func(t *testing.T) {
+		tm := internal.NewTranslationManager()
+		path := filepath.Join(t.TempDir(), "pl.json")
+		os.WriteFile(path, []byte(`{"mail.greeting": "Cześć", "mail.magic_link.subject": "Twój link logowania"}`), 0o600)
+		assert.NoError(t, tm.Load("pl", path))
+		internal.SetDefaultTranslations(tm)
+		defer internal.SetDefaultTranslations(nil)
+
+		err := notifier.Notify(context.Background(), accounts.Notification{Kind: accounts.NOTIFY_MAGIC_LINK, User: user, Link: "https://example.com/login", Lang: "pl-PL,pl;q=0.9"})
+		assert.NoError(t, err)
+		msg := mailer.Messages()[1]
+		assert.Equal(t, "Twój link logowania", msg.Subject)
+		assert.Contains(t, msg.Text, "Cześć John,")
+		assert.Contains(t, msg.Text, "Log in", "Expected fallback for missing keys")
+	})
+
+	assert.Error(t, notifier.Notify(context.Background(), accounts.Notification{Kind: "unknown", User: user}))
+}
@@ -0,0 +1,131 @@
+package mail
+
+import (
+	"context"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/mcgtrt/go-puerto/internal/logging"
+	"github.com/mcgtrt/go-puerto/internal/metrics"
+)
+
+// Delay of the first retry, doubled for every next one up to MAX_RETRY_DELAY
+var (
+	RETRY_DELAY     = 2 * time.Second
+	MAX_RETRY_DELAY = 5 * time.Minute
+)
+
+type delivery struct {
+	ctx context.Context
+	msg *Message
+}
+
+// Delivers messages with the mailer in the background. Send returns once
+// the message is queued, failed deliveries are retried with exponential
+// backoff up to MaxAttempts times. Close the queue on shutdown to deliver
+// the queued messages.
+type Queue struct {
+	Mailer      Mailer
+	MaxAttempts int
+
+	mu         sync.RWMutex
+	closed     bool
+	deliveries chan delivery
+	stop       chan struct{}
+	stopOnce   sync.Once
+	wg         sync.WaitGroup
+}
+
+// Create queue holding up to size messages and start its workers
+func NewQueue(mailer Mailer, size, workers, maxAttempts int) *Queue {
+	q := &Queue{
+		Mailer:      mailer,
+		MaxAttempts: maxAttempts,
+		deliveries:  make(chan delivery, size),
+		stop:        make(chan struct{}),
+	}
+	for range workers {
+		q.wg.Add(1)
+		go q.work()
+	}
+	return q
+}
+
+// Queue the message. Returns ErrQueueFull rather than blocking the request
+// when the mailer can't keep up.
+func (q *Queue) Send(ctx context.Context, msg *Message) error {
+	// Invalid messages would fail every attempt
+	if err := msg.Validate(); err != nil {
+		return err
+	}
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.closed {
+		return ErrQueueClosed
+	}
+	select {
+	case q.deliveries <- delivery{ctx: context.WithoutCancel(ctx), msg: msg}:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Stop accepting messages and wait until the queued ones are delivered.
+// Pending retries give up when the context is done.
+func (q *Queue) Close(ctx context.Context) error {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.deliveries)
+	}
+	q.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		q.stopOnce.Do(func() { close(q.stop) })
+		return ctx.Err()
+	}
+}
+
+func (q *Queue) work() {
+	defer q.wg.Done()
+	for d := range q.deliveries {
+		q.deliver(d)
+	}
+}
+
+func (q *Queue) deliver(d delivery) {
+	log := logging.FromContext(d.ctx)
+	delay := RETRY_DELAY
+	for attempt := 1; ; attempt++ {
+		err := q.Mailer.Send(d.ctx, d.msg)
+		if err == nil {
+			metrics.MailDeliveries.WithLabelValues("sent").Inc()
+			return
+		}
+		if IsPermanent(err) || attempt >= q.MaxAttempts {
+			metrics.MailDeliveries.WithLabelValues("failed").Inc()
+			log.Error("sending email failed", "subject", d.msg.Subject, "attempts", attempt, "error", err)
+			return
+		}
+		metrics.MailDeliveries.WithLabelValues("retried").Inc()
+		log.Warn("sending email failed, retrying", "subject", d.msg.Subject, "attempt", attempt, "error", err)
+		// Jitter spreads the retries of messages failed at the same time
+		select {
+		case <-time.After(delay/2 + rand.N(delay/2+1)):
+		case <-q.stop:
+			log.Error("email dropped on shutdown", "subject", d.msg.Subject)
+			return
+		}
+		delay = min(delay*2, MAX_RETRY_DELAY)
+	}
+}
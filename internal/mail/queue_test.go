@@ -0,0 +1,77 @@
+package mail
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Fails the first failures deliveries
+type flakyMailer struct {
+	*MemoryMailer
+	failures int32
+	err      error
+	attempts atomic.Int32
+}
+
+func (m *flakyMailer) Send(ctx context.Context, msg *Message) error {
+	if m.attempts.Add(1) <= m.failures {
+		return m.err
+	}
+	return m.MemoryMailer.Send(ctx, msg)
+}
+
+func TestQueue(t *testing.T) {
+	defer func(delay time.Duration) { RETRY_DELAY = delay }(RETRY_DELAY)
+	RETRY_DELAY = time.Millisecond
+	msg := &Message{To: []string{"john@example.com"}, Subject: "Hi", Text: "Hello"}
+
+	t.Run("Retries until delivered", func(t *testing.T) {
+		mailer := &flakyMailer{MemoryMailer: NewMemoryMailer("shop@example.com"), failures: 2, err: errors.New("connection reset")}
+		q := NewQueue(mailer, 10, 1, 3)
+		assert.NoError(t, q.Send(context.Background(), msg))
+		assert.NoError(t, q.Close(context.Background()))
+		assert.Len(t, mailer.Messages(), 1)
+		assert.Equal(t, int32(3), mailer.attempts.Load())
+	})
+
+	t.Run("Gives up", func(t *testing.T) {
+		mailer := &flakyMailer{MemoryMailer: NewMemoryMailer("shop@example.com"), failures: 5, err: errors.New("connection reset")}
+		q := NewQueue(mailer, 10, 1, 2)
+		assert.NoError(t, q.Send(context.Background(), msg))
+		assert.NoError(t, q.Close(context.Background()))
+		assert.Empty(t, mailer.Messages())
+		assert.Equal(t, int32(2), mailer.attempts.Load())
+	})
+
+	t.Run("Permanent errors not retried", func(t *testing.T) {
+		mailer := &flakyMailer{MemoryMailer: NewMemoryMailer("shop@example.com"), failures: 5, err: ErrSTARTTLSUnsupported}
+		q := NewQueue(mailer, 10, 1, 5)
+		assert.NoError(t, q.Send(context.Background(), msg))
+		assert.NoError(t, q.Close(context.Background()))
+		assert.Equal(t, int32(1), mailer.attempts.Load())
+	})
+
+	t.Run("Full and closed", func(t *testing.T) {
+		q := NewQueue(NewMemoryMailer("shop@example.com"), 1, 0, 1)
+		assert.NoError(t, q.Send(context.Background(), msg))
+		assert.ErrorIs(t, q.Send(context.Background(), msg), ErrQueueFull)
+		assert.ErrorIs(t, q.Send(context.Background(), &Message{Subject: "Hi", Text: "Hello"}), ErrInvalidMessage)
+		assert.NoError(t, q.Close(context.Background()))
+		assert.ErrorIs(t, q.Send(context.Background(), msg), ErrQueueClosed)
+	})
+
+	t.Run("Close gives up pending retries", func(t *testing.T) {
+		RETRY_DELAY = time.Hour
+		mailer := &flakyMailer{MemoryMailer: NewMemoryMailer("shop@example.com"), failures: 5, err: errors.New("connection reset")}
+		q := NewQueue(mailer, 10, 1, 5)
+		assert.NoError(t, q.Send(context.Background(), msg))
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		assert.ErrorIs(t, q.Close(ctx), context.DeadlineExceeded)
+	})
+}
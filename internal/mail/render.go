@@ -0,0 +1,104 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+
+	"github.com/a-h/templ"
+	"golang.org/x/net/html"
+)
+
+// Render the templ component as the HTML body of the message with the
+// plain-text alternative derived from it. Set Text afterwards to write the
+// alternative by hand.
+func Render(ctx context.Context, to []string, subject string, body templ.Component) (*Message, error) {
+	var buf bytes.Buffer
+	if err := body.Render(ctx, &buf); err != nil {
+		return nil, err
+	}
+	return &Message{To: to, Subject: subject, HTML: buf.String(), Text: HTMLToText(buf.String())}, nil
+}
+
+// Elements starting a new line in the plain text
+var blockElements = map[string]bool{
+	"p": true, "div": true, "br": true, "tr": true, "table": true, "li": true, "ul": true, "ol": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true, "hr": true, "blockquote": true,
+}
+
+// Plain text of the HTML email. Links keep their address next to the text,
+// so they can be copied from clients that don't show HTML.
+func HTMLToText(s string) string {
+	var out, line strings.Builder
+	var skip int
+	var href string
+	var linkText strings.Builder
+	flush := func(blank bool) {
+		text := strings.Join(strings.Fields(line.String()), " ")
+		line.Reset()
+		if text != "" {
+			out.WriteString(text + "\n")
+		}
+		if blank && out.Len() > 0 && !strings.HasSuffix(out.String(), "\n\n") {
+			out.WriteString("\n")
+		}
+	}
+
+	z := html.NewTokenizer(strings.NewReader(s))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			if z.Err() == io.EOF {
+				break
+			}
+			return s
+		}
+		token := z.Token()
+		switch tt {
+		case html.StartTagToken, html.SelfClosingTagToken:
+			switch token.Data {
+			case "head", "style", "script", "title":
+				if tt == html.StartTagToken {
+					skip++
+				}
+			case "a":
+				for _, attr := range token.Attr {
+					if attr.Key == "href" {
+						href = attr.Val
+					}
+				}
+				linkText.Reset()
+			case "li":
+				flush(false)
+				line.WriteString("- ")
+			default:
+				if blockElements[token.Data] {
+					flush(token.Data != "br")
+				}
+			}
+		case html.EndTagToken:
+			switch token.Data {
+			case "head", "style", "script", "title":
+				skip = max(skip-1, 0)
+			case "a":
+				text := strings.TrimSpace(linkText.String())
+				if href != "" && href != text && !strings.HasPrefix(href, "mailto:") {
+					line.WriteString(" (" + href + ")")
+				}
+				href = ""
+			default:
+				if blockElements[token.Data] {
+					flush(token.Data != "li" && token.Data != "tr")
+				}
+			}
+		case html.TextToken:
+			if skip == 0 {
+				line.WriteString(token.Data)
+				linkText.WriteString(token.Data)
+			}
+		}
+	}
+	flush(false)
+	return strings.TrimSpace(out.String()) + "\n"
+}
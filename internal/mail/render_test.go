@@ -0,0 +1,25 @@
+package mail
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTMLToText(t *testing.T) {
+	html := `<!DOCTYPE html><html><head><title>Hi</title><style>p { color: red; }</style></head>
+<body>
+	<h1>Verify   your email</h1>
+	<p>Hi John,<br/>confirm it's you &amp; continue.</p>
+	<p><a href="https://example.com/verify?token=abc">Verify email</a></p>
+	<ul><li>One</li><li>Two</li></ul>
+	<p><a href="https://example.com">https://example.com</a></p>
+</body></html>`
+
+	expected := "Verify your email\n\n" +
+		"Hi John,\nconfirm it's you & continue.\n\n" +
+		"Verify email (https://example.com/verify?token=abc)\n\n" +
+		"- One\n- Two\n\n" +
+		"https://example.com\n"
+	assert.Equal(t, expected, HTMLToText(html))
+}
@@ -0,0 +1,133 @@
+package mail
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"time"
+
+	"github.com/mcgtrt/go-puerto/utils"
+)
+
+// Time allowed for one delivery when the context has no deadline
+const DEFAULT_SMTP_TIMEOUT = 30 * time.Second
+
+var ErrSTARTTLSUnsupported = errors.New("smtp server doesn't support STARTTLS")
+
+// Sends messages through an SMTP server. The connection is encrypted with
+// STARTTLS or implicit TLS unless TLS is none, meant for local sinks such
+// as Mailpit. Servers without STARTTLS are refused rather than sent the
+// message in plain text.
+type SMTPMailer struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	TLS      string
+	// Sender of messages without From
+	From      string
+	Timeout   time.Duration
+	TLSConfig *tls.Config
+}
+
+func NewSMTPMailer(cfg *utils.SMTPConfig, from string) *SMTPMailer {
+	return &SMTPMailer{
+		Host:      cfg.Host,
+		Port:      cfg.Port,
+		Username:  cfg.Username,
+		Password:  cfg.Password,
+		TLS:       cfg.TLS,
+		From:      from,
+		Timeout:   DEFAULT_SMTP_TIMEOUT,
+		TLSConfig: &tls.Config{ServerName: cfg.Host, MinVersion: tls.VersionTLS12},
+	}
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, msg *Message) error {
+	msg = withSender(msg, m.From)
+	data, err := msg.Bytes(time.Now())
+	if err != nil {
+		return err
+	}
+	from, to, err := msg.envelope()
+	if err != nil {
+		return err
+	}
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(m.Timeout)
+	}
+	dialer := &net.Dialer{Deadline: deadline}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(m.Host, strconv.Itoa(m.Port)))
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(deadline)
+	if m.TLS == utils.SMTP_TLS_IMPLICIT {
+		conn = tls.Client(conn, m.TLSConfig)
+	}
+	c, err := smtp.NewClient(conn, m.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if m.TLS == utils.SMTP_TLS_STARTTLS {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return ErrSTARTTLSUnsupported
+		}
+		if err := c.StartTLS(m.TLSConfig); err != nil {
+			return err
+		}
+	}
+	if m.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", m.Username, m.Password, m.Host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(from); err != nil {
+		return err
+	}
+	for _, rcpt := range to {
+		if err := c.Rcpt(rcpt); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// Whether retrying the delivery can't help, e.g. the message is invalid or
+// the server rejected it permanently (5xx reply)
+func IsPermanent(err error) bool {
+	var reply *textproto.Error
+	if errors.As(err, &reply) {
+		return reply.Code >= 500
+	}
+	return errors.Is(err, ErrInvalidMessage) || errors.Is(err, ErrSTARTTLSUnsupported)
+}
+
+// Copy of the message with the default sender
+func withSender(msg *Message, from string) *Message {
+	if msg.From != "" {
+		return msg
+	}
+	m := *msg
+	m.From = from
+	return &m
+}
@@ -0,0 +1,108 @@
+package mail
+
+import (
+	"context"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/mcgtrt/go-puerto/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+// Minimal SMTP sink accepting one message, like Mailpit without TLS
+func smtpSink(t *testing.T, extensions []string, received chan<- string) (string, int) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		tp := textproto.NewConn(conn)
+		tp.PrintfLine("220 localhost ESMTP")
+		var data strings.Builder
+		for {
+			line, err := tp.ReadLine()
+			if err != nil {
+				return
+			}
+			switch cmd := strings.ToUpper(strings.Fields(line + " x")[0]); cmd {
+			case "EHLO":
+				reply := append([]string{"localhost"}, extensions...)
+				for i, ext := range reply {
+					sep := "-"
+					if i == len(reply)-1 {
+						sep = " "
+					}
+					tp.PrintfLine("250%s%s", sep, ext)
+				}
+			case "DATA":
+				tp.PrintfLine("354 go ahead")
+				lines, _ := tp.ReadDotLines()
+				data.WriteString(strings.Join(lines, "\n"))
+				tp.PrintfLine("250 queued")
+			case "RCPT":
+				if strings.Contains(line, "unknown@") {
+					tp.PrintfLine("550 no such user")
+					continue
+				}
+				data.WriteString(line + "\n")
+				tp.PrintfLine("250 ok")
+			case "QUIT":
+				tp.PrintfLine("221 bye")
+				received <- data.String()
+				return
+			default:
+				tp.PrintfLine("250 ok")
+			}
+		}
+	}()
+	host, port, _ := net.SplitHostPort(l.Addr().String())
+	p, _ := strconv.Atoi(port)
+	return host, p
+}
+
+func TestSMTPMailer(t *testing.T) {
+	msg := &Message{To: []string{"John <john@example.com>"}, Subject: "Hi", Text: "Hello"}
+
+	t.Run("Plain sink", func(t *testing.T) {
+		received := make(chan string, 1)
+		host, port := smtpSink(t, nil, received)
+		m := NewSMTPMailer(&utils.SMTPConfig{Host: host, Port: port, TLS: utils.SMTP_TLS_NONE}, "Shop <shop@example.com>")
+		assert.NoError(t, m.Send(context.Background(), msg))
+		data := <-received
+		assert.Contains(t, data, "RCPT TO:<john@example.com>")
+		assert.Contains(t, data, "Subject: Hi")
+	})
+
+	t.Run("STARTTLS required", func(t *testing.T) {
+		host, port := smtpSink(t, nil, make(chan string, 1))
+		m := NewSMTPMailer(&utils.SMTPConfig{Host: host, Port: port, TLS: utils.SMTP_TLS_STARTTLS}, "shop@example.com")
+		err := m.Send(context.Background(), msg)
+		assert.ErrorIs(t, err, ErrSTARTTLSUnsupported, "Expected no fallback to plain text")
+		assert.True(t, IsPermanent(err))
+	})
+
+	t.Run("Rejected recipient", func(t *testing.T) {
+		host, port := smtpSink(t, nil, make(chan string, 1))
+		m := NewSMTPMailer(&utils.SMTPConfig{Host: host, Port: port, TLS: utils.SMTP_TLS_NONE}, "shop@example.com")
+		err := m.Send(context.Background(), &Message{To: []string{"unknown@example.com"}, Subject: "Hi", Text: "Hello"})
+		assert.Error(t, err)
+		assert.True(t, IsPermanent(err), "Expected 5xx replies not retried")
+	})
+
+	t.Run("Connection refused", func(t *testing.T) {
+		l, _ := net.Listen("tcp", "127.0.0.1:0")
+		port := l.Addr().(*net.TCPAddr).Port
+		l.Close()
+		m := NewSMTPMailer(&utils.SMTPConfig{Host: "127.0.0.1", Port: port, TLS: utils.SMTP_TLS_NONE}, "shop@example.com")
+		err := m.Send(context.Background(), msg)
+		assert.Error(t, err)
+		assert.False(t, IsPermanent(err), "Expected network errors retried")
+	})
+}
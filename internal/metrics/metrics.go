@@ -0,0 +1,182 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "puerto"
+
+// Project-wide registry. Every collector defined in this package is registered
+// here, together with the Go runtime and process collectors, so the exposed
+// endpoint contains only the metrics this application cares about.
+var Registry = prometheus.NewRegistry()
+
+var (
+	// HTTP metrics are labelled by the chi route pattern (e.g. /users/{id}) and
+	// never by the raw path to keep the label cardinality bounded.
+	HTTPRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "requests_total",
+		Help:      "Total number of HTTP requests processed.",
+	}, []string{"route", "method", "status"})
+	HTTPRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "HTTP request latency in seconds.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+	HTTPRequestsInFlight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "requests_in_flight",
+		Help:      "Number of HTTP requests currently being served.",
+	})
+	PanicsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "panics_total",
+		Help:      "Number of panics recovered while serving requests.",
+	})
+	RateLimitRejections = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "rate_limit_rejections_total",
+		Help:      "Number of requests rejected by the rate limiter.",
+	})
+
+	// Mongo pool gauges are fed by the driver's pool monitor events
+	MongoCommandDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "mongo",
+		Name:      "command_duration_seconds",
+		Help:      "MongoDB command latency in seconds.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"command", "status"})
+	MongoPoolConnections = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "mongo",
+		Name:      "pool_connections",
+		Help:      "Number of MongoDB pool connections by state.",
+	}, []string{"state"})
+
+	PostgresQueryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "postgres",
+		Name:      "query_duration_seconds",
+		Help:      "Postgres query latency in seconds.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"status"})
+
+	ValkeyRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "valkey",
+		Name:      "cache_requests_total",
+		Help:      "Number of Valkey cache lookups by result (hit or miss).",
+	}, []string{"result"})
+
+	TranslationMisses = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "translation",
+		Name:      "misses_total",
+		Help:      "Number of translation lookups with no matching key.",
+	}, []string{"lang"})
+
+	MailDeliveries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "mail",
+		Name:      "deliveries_total",
+		Help:      "Number of email delivery attempts by result (sent, retried or failed).",
+	}, []string{"result"})
+
+	JobsProcessed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "jobs",
+		Name:      "processed_total",
+		Help:      "Number of background job runs by kind and result (done, retried, failed or lease_lost).",
+	}, []string{"kind", "result"})
+	JobDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "jobs",
+		Name:      "duration_seconds",
+		Help:      "Background job run time in seconds by kind.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"kind"})
+	SchedulerRuns = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "scheduler",
+		Name:      "runs_total",
+		Help:      "Number of scheduled task runs by task and result (done, failed or skipped while the previous run was still running).",
+	}, []string{"task", "result"})
+	SSEClients = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "sse",
+		Name:      "clients",
+		Help:      "Number of connected server-sent event streams.",
+	})
+	SSEDropped = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "sse",
+		Name:      "dropped_clients_total",
+		Help:      "Number of event streams disconnected for falling behind.",
+	})
+	WebSocketClients = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "websocket",
+		Name:      "clients",
+		Help:      "Number of connected WebSocket clients.",
+	})
+	WebSocketDropped = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "websocket",
+		Name:      "dropped_clients_total",
+		Help:      "Number of WebSocket clients disconnected for falling behind.",
+	})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HTTPRequestsTotal,
+		HTTPRequestDuration,
+		HTTPRequestsInFlight,
+		PanicsTotal,
+		RateLimitRejections,
+		MongoCommandDuration,
+		MongoPoolConnections,
+		PostgresQueryDuration,
+		ValkeyRequests,
+		TranslationMisses,
+		MailDeliveries,
+		JobsProcessed,
+		JobDuration,
+		SchedulerRuns,
+		SSEClients,
+		SSEDropped,
+		WebSocketClients,
+		WebSocketDropped,
+	)
+}
+
+// Register additional collectors (e.g. pool stats collectors created by
+// the stores) in the project registry
+func Register(cs ...prometheus.Collector) error {
+	for _, c := range cs {
+		if err := Registry.Register(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the http.Handler that exposes all collected metrics
+// in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
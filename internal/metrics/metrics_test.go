@@ -0,0 +1,38 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandler(t *testing.T) {
+	HTTPRequestsTotal.WithLabelValues("/users/{id}", http.MethodGet, "200").Inc()
+	TranslationMisses.WithLabelValues("en").Inc()
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, req)
+
+	body, _ := io.ReadAll(rec.Body)
+	assert.Equal(t, http.StatusOK, rec.Code, "Expected metrics endpoint to respond with 200")
+	assert.Contains(t, string(body), `puerto_http_requests_total{method="GET",route="/users/{id}",status="200"} 1`)
+	assert.Contains(t, string(body), `puerto_translation_misses_total{lang="en"} 1`)
+	assert.Contains(t, string(body), "go_goroutines", "Expected runtime metrics to be exposed")
+}
+
+func TestPoolCollector(t *testing.T) {
+	c := NewPoolCollector("test", func() PoolStats {
+		return PoolStats{Total: 4, Idle: 3, InUse: 1, Max: 10, AcquireCount: 7, WaitDuration: 2 * time.Second}
+	})
+	assert.NoError(t, Register(c), "Expected pool collector to register")
+	defer Registry.Unregister(c)
+
+	assert.Equal(t, 6, testutil.CollectAndCount(c), "Expected all pool metrics to be collected")
+	assert.Error(t, Register(c), "Expected registering the same collector twice to fail")
+}
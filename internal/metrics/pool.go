@@ -0,0 +1,70 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Snapshot of a database connection pool at the time of scraping
+type PoolStats struct {
+	Total        int64
+	Idle         int64
+	InUse        int64
+	Max          int64
+	AcquireCount int64
+	WaitDuration time.Duration
+}
+
+type poolCollector struct {
+	stats        func() PoolStats
+	connections  *prometheus.Desc
+	max          *prometheus.Desc
+	acquireTotal *prometheus.Desc
+	waitSeconds  *prometheus.Desc
+}
+
+// Create a collector reading the pool statistics on every scrape. Subsystem
+// is the metric prefix after the namespace (e.g. "postgres")
+func NewPoolCollector(subsystem string, stats func() PoolStats) prometheus.Collector {
+	return &poolCollector{
+		stats: stats,
+		connections: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "pool_connections"),
+			"Number of pool connections by state.",
+			[]string{"state"}, nil,
+		),
+		max: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "pool_max_connections"),
+			"Maximum number of pool connections.",
+			nil, nil,
+		),
+		acquireTotal: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "pool_acquire_total"),
+			"Number of successful connection acquisitions.",
+			nil, nil,
+		),
+		waitSeconds: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "pool_acquire_wait_seconds_total"),
+			"Total time spent waiting for a pool connection.",
+			nil, nil,
+		),
+	}
+}
+
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.connections
+	ch <- c.max
+	ch <- c.acquireTotal
+	ch <- c.waitSeconds
+}
+
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	s := c.stats()
+	ch <- prometheus.MustNewConstMetric(c.connections, prometheus.GaugeValue, float64(s.Total), "total")
+	ch <- prometheus.MustNewConstMetric(c.connections, prometheus.GaugeValue, float64(s.Idle), "idle")
+	ch <- prometheus.MustNewConstMetric(c.connections, prometheus.GaugeValue, float64(s.InUse), "in_use")
+	ch <- prometheus.MustNewConstMetric(c.max, prometheus.GaugeValue, float64(s.Max))
+	ch <- prometheus.MustNewConstMetric(c.acquireTotal, prometheus.CounterValue, float64(s.AcquireCount))
+	ch <- prometheus.MustNewConstMetric(c.waitSeconds, prometheus.CounterValue, s.WaitDuration.Seconds())
+}
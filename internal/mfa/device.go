@@ -0,0 +1,118 @@
+package mfa
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/mcgtrt/go-puerto/internal/session"
+	"github.com/mcgtrt/go-puerto/utils"
+)
+
+// Cookie marking the browser as trusted to skip the second factor
+const DEVICE_COOKIE = "mfa_device"
+
+// Content of the sealed device cookie. The cookie is bound to a factor
+// of the user, so disabling two-factor authentication forgets devices.
+type rememberedDevice struct {
+	UserID    string `json:"u"`
+	FactorID  string `json:"f"`
+	ExpiresAt int64  `json:"e"`
+}
+
+// Check if the user has to pass the second factor to log in from the
+// browser sending the request
+func (s *Service) Required(ctx context.Context, r *http.Request, userID string) (bool, error) {
+	factors, err := s.Factors(ctx, userID)
+	if err != nil || len(factors) == 0 {
+		return false, err
+	}
+	return !s.isRemembered(r, userID, factors), nil
+}
+
+// Remember the browser for RememberDeviceTTL, skipping the second factor
+// on the next logins of the user
+func (s *Service) RememberDevice(ctx context.Context, w http.ResponseWriter, userID string, secure bool) error {
+	if s.RememberDeviceTTL <= 0 {
+		return nil
+	}
+	factors, err := s.Factors(ctx, userID)
+	if err != nil || len(factors) == 0 {
+		return err
+	}
+	expiresAt := s.now().Add(s.RememberDeviceTTL)
+	data, err := json.Marshal(rememberedDevice{UserID: userID, FactorID: factors[0].ID, ExpiresAt: expiresAt.Unix()})
+	if err != nil {
+		return err
+	}
+	value, err := utils.SealAES(string(data))
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     DEVICE_COOKIE,
+		Value:    value,
+		Path:     "/",
+		Expires:  expiresAt,
+		MaxAge:   int(s.RememberDeviceTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+func (s *Service) isRemembered(r *http.Request, userID string, factors []Factor) bool {
+	if s.RememberDeviceTTL <= 0 {
+		return false
+	}
+	cookie, err := r.Cookie(DEVICE_COOKIE)
+	if err != nil {
+		return false
+	}
+	data, err := utils.OpenAES(cookie.Value)
+	if err != nil {
+		return false
+	}
+	var device rememberedDevice
+	if json.Unmarshal([]byte(data), &device) != nil || device.UserID != userID || device.ExpiresAt <= s.now().Unix() {
+		return false
+	}
+	return slices.ContainsFunc(factors, func(f Factor) bool { return f.ID == device.FactorID })
+}
+
+// Time the user has to enter the second factor after the password
+const PENDING_LOGIN_TTL = 10 * time.Minute
+
+const pendingSessionKey = "mfa_pending"
+
+// Login waiting for the second factor. The session isn't bound to the
+// user until the second factor is verified.
+type PendingLogin struct {
+	UserID    string    `json:"user_id"`
+	Next      string    `json:"next,omitempty"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func (s *Service) SavePendingLogin(sess *session.Session, userID, next string) error {
+	return sess.Set(pendingSessionKey, PendingLogin{
+		UserID:    userID,
+		Next:      next,
+		ExpiresAt: s.now().Add(PENDING_LOGIN_TTL),
+	})
+}
+
+// Return the login waiting for the second factor, if any and not expired
+func (s *Service) GetPendingLogin(sess *session.Session) (*PendingLogin, bool) {
+	p, ok := session.Get[PendingLogin](sess, pendingSessionKey)
+	if !ok || !p.ExpiresAt.After(s.now()) {
+		return nil, false
+	}
+	return &p, true
+}
+
+func (s *Service) ClearPendingLogin(sess *session.Session) {
+	sess.Delete(pendingSessionKey)
+}
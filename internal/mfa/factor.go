@@ -0,0 +1,214 @@
+package mfa
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Kinds of second factors. WebAuthn is reserved for security keys and
+// passkeys plugged in through Verifier.
+const (
+	FACTOR_TOTP     = "totp"
+	FACTOR_WEBAUTHN = "webauthn"
+)
+
+var (
+	ErrFactorNotFound       = errors.New("factor not found")
+	ErrRecoveryCodeNotFound = errors.New("recovery code not found")
+)
+
+// Second factor of the user. Secret holds the sealed TOTP secret or the
+// credential of other kinds (e.g. WebAuthn public key) and Counter the
+// last accepted TOTP time step or the authenticator signature counter.
+// Factors are usable once confirmed by the first successful verification.
+type Factor struct {
+	ID          string     `json:"id" bson:"_id"`
+	UserID      string     `json:"user_id" bson:"user_id"`
+	Kind        string     `json:"kind" bson:"kind"`
+	Name        string     `json:"name" bson:"name"`
+	Secret      string     `json:"-" bson:"secret"`
+	Counter     int64      `json:"-" bson:"counter"`
+	ConfirmedAt *time.Time `json:"confirmed_at,omitempty" bson:"confirmed_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at" bson:"created_at"`
+}
+
+func (f *Factor) IsConfirmed() bool {
+	return f.ConfirmedAt != nil
+}
+
+// Checks responses of one kind of factor and returns the new counter of
+// the factor. Implement it to add factors like WebAuthn next to TOTP.
+type Verifier interface {
+	Verify(ctx context.Context, f *Factor, response string, now time.Time) (counter int64, ok bool, err error)
+}
+
+// Persistence of second factors, recovery codes and failed attempts
+type Store interface {
+	CreateFactor(ctx context.Context, f *Factor) error
+	ListFactors(ctx context.Context, userID string) ([]Factor, error)
+	ConfirmFactor(ctx context.Context, id string, at time.Time) error
+	// Atomically replace the counter if it still has the old value.
+	// Returns false when another request changed it first, so the same
+	// code can't be used twice even by concurrent requests.
+	UpdateCounter(ctx context.Context, id string, old, new int64) (bool, error)
+	DeleteFactor(ctx context.Context, id string) error
+	// Delete all factors and recovery codes of the user
+	DeleteUserFactors(ctx context.Context, userID string) error
+
+	// Replace recovery codes of the user with the hashed ones
+	ReplaceRecoveryCodes(ctx context.Context, userID string, hashes []string) error
+	// Atomically remove the code. Returns ErrRecoveryCodeNotFound if the
+	// user doesn't have it.
+	UseRecoveryCode(ctx context.Context, userID, hash string) error
+	CountRecoveryCodes(ctx context.Context, userID string) (int, error)
+
+	// Count failed verification of the user and return the number of
+	// failures within the window started by the first one
+	RecordFailure(ctx context.Context, userID string, window time.Duration) (int, error)
+	// Failures within the current window
+	Failures(ctx context.Context, userID string) (int, error)
+	ResetFailures(ctx context.Context, userID string) error
+}
+
+type failures struct {
+	count     int
+	expiresAt time.Time
+}
+
+// Keeps factors in the process memory. Useful for tests and prototyping.
+type MemoryStore struct {
+	mu       sync.Mutex
+	factors  map[string]Factor
+	codes    map[string][]string
+	failures map[string]failures
+	now      func() time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		factors:  make(map[string]Factor),
+		codes:    make(map[string][]string),
+		failures: make(map[string]failures),
+		now:      time.Now,
+	}
+}
+
+func (m *MemoryStore) CreateFactor(ctx context.Context, f *Factor) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.factors[f.ID] = *f
+	return nil
+}
+
+func (m *MemoryStore) ListFactors(ctx context.Context, userID string) ([]Factor, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	factors := []Factor{}
+	for _, f := range m.factors {
+		if f.UserID == userID {
+			factors = append(factors, f)
+		}
+	}
+	slices.SortFunc(factors, func(a, b Factor) int { return a.CreatedAt.Compare(b.CreatedAt) })
+	return factors, nil
+}
+
+func (m *MemoryStore) ConfirmFactor(ctx context.Context, id string, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f, ok := m.factors[id]
+	if !ok {
+		return ErrFactorNotFound
+	}
+	f.ConfirmedAt = &at
+	m.factors[id] = f
+	return nil
+}
+
+func (m *MemoryStore) UpdateCounter(ctx context.Context, id string, old, new int64) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f, ok := m.factors[id]
+	if !ok || f.Counter != old {
+		return false, nil
+	}
+	f.Counter = new
+	m.factors[id] = f
+	return true, nil
+}
+
+func (m *MemoryStore) DeleteFactor(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.factors, id)
+	return nil
+}
+
+func (m *MemoryStore) DeleteUserFactors(ctx context.Context, userID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, f := range m.factors {
+		if f.UserID == userID {
+			delete(m.factors, id)
+		}
+	}
+	delete(m.codes, userID)
+	return nil
+}
+
+func (m *MemoryStore) ReplaceRecoveryCodes(ctx context.Context, userID string, hashes []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.codes[userID] = slices.Clone(hashes)
+	return nil
+}
+
+func (m *MemoryStore) UseRecoveryCode(ctx context.Context, userID, hash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := slices.Index(m.codes[userID], hash)
+	if i < 0 {
+		return ErrRecoveryCodeNotFound
+	}
+	m.codes[userID] = slices.Delete(m.codes[userID], i, i+1)
+	return nil
+}
+
+func (m *MemoryStore) CountRecoveryCodes(ctx context.Context, userID string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.codes[userID]), nil
+}
+
+func (m *MemoryStore) RecordFailure(ctx context.Context, userID string, window time.Duration) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	f := m.failures[userID]
+	if !f.expiresAt.After(now) {
+		f = failures{expiresAt: now.Add(window)}
+	}
+	f.count++
+	m.failures[userID] = f
+	return f.count, nil
+}
+
+func (m *MemoryStore) Failures(ctx context.Context, userID string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f := m.failures[userID]
+	if !f.expiresAt.After(m.now()) {
+		return 0, nil
+	}
+	return f.count, nil
+}
+
+func (m *MemoryStore) ResetFailures(ctx context.Context, userID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.failures, userID)
+	return nil
+}
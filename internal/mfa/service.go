@@ -0,0 +1,281 @@
+package mfa
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/mcgtrt/go-puerto/internal/accounts"
+	"github.com/mcgtrt/go-puerto/utils"
+)
+
+// Defaults of the two-factor policies
+const (
+	RECOVERY_CODE_COUNT = 10
+	// Failed verifications allowed within the window before further
+	// attempts are rejected, so 6 digit codes can't be brute forced
+	MAX_FAILURES   = 5
+	FAILURE_WINDOW = 15 * time.Minute
+)
+
+var (
+	ErrInvalidCode     = errors.New("invalid verification code")
+	ErrTooManyAttempts = errors.New("too many failed verification attempts")
+	ErrAlreadyEnabled  = errors.New("two-factor authentication is already enabled")
+)
+
+// Pending TOTP enrolment shown to the user as a QR code until confirmed
+type Enrollment struct {
+	Factor *Factor
+	Secret string
+	URI    string
+}
+
+// Two-factor use cases on top of the store. TOTP is verified out of the
+// box, other kinds of factors are added to Verifiers.
+type Service struct {
+	Store             Store
+	Issuer            string
+	RememberDeviceTTL time.Duration
+	Verifiers         map[string]Verifier
+	now               func() time.Time
+}
+
+func NewService(store Store, cfg *utils.MFAConfig) *Service {
+	return &Service{
+		Store:             store,
+		Issuer:            cfg.Issuer,
+		RememberDeviceTTL: cfg.RememberDeviceTTL,
+		Verifiers:         map[string]Verifier{FACTOR_TOTP: TOTPVerifier{}},
+		now:               time.Now,
+	}
+}
+
+// Confirmed factors of the user. Two-factor authentication is enabled
+// for users having any.
+func (s *Service) Factors(ctx context.Context, userID string) ([]Factor, error) {
+	factors, err := s.Store.ListFactors(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	confirmed := factors[:0]
+	for _, f := range factors {
+		if f.IsConfirmed() {
+			confirmed = append(confirmed, f)
+		}
+	}
+	return confirmed, nil
+}
+
+func (s *Service) Enabled(ctx context.Context, userID string) (bool, error) {
+	factors, err := s.Factors(ctx, userID)
+	return len(factors) > 0, err
+}
+
+// Start TOTP enrolment replacing any unconfirmed one. Account is the
+// name shown in the authenticator app (usually the email).
+func (s *Service) BeginTOTP(ctx context.Context, userID, account string) (*Enrollment, error) {
+	factors, err := s.Store.ListFactors(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range factors {
+		if f.Kind != FACTOR_TOTP {
+			continue
+		}
+		if f.IsConfirmed() {
+			return nil, ErrAlreadyEnabled
+		}
+		if err := s.Store.DeleteFactor(ctx, f.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	secret := GenerateSecret()
+	sealed, err := utils.SealAES(secret)
+	if err != nil {
+		return nil, err
+	}
+	f := &Factor{
+		ID:        utils.NewUUIDv7(),
+		UserID:    userID,
+		Kind:      FACTOR_TOTP,
+		Name:      "Authenticator app",
+		Secret:    sealed,
+		CreatedAt: s.now(),
+	}
+	if err := s.Store.CreateFactor(ctx, f); err != nil {
+		return nil, err
+	}
+	return &Enrollment{Factor: f, Secret: secret, URI: TOTPURI(s.Issuer, account, secret)}, nil
+}
+
+// Return the TOTP enrolment waiting for confirmation, e.g. to show the
+// QR code again after a mistyped code
+func (s *Service) PendingTOTP(ctx context.Context, userID, account string) (*Enrollment, error) {
+	factors, err := s.Store.ListFactors(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	for i, f := range factors {
+		if f.Kind != FACTOR_TOTP || f.IsConfirmed() {
+			continue
+		}
+		secret, err := utils.OpenAES(f.Secret)
+		if err != nil {
+			return nil, err
+		}
+		return &Enrollment{Factor: &factors[i], Secret: secret, URI: TOTPURI(s.Issuer, account, secret)}, nil
+	}
+	return nil, ErrFactorNotFound
+}
+
+// Confirm the pending TOTP enrolment with the first code from the app.
+// Returns recovery codes when two-factor authentication gets enabled by
+// the confirmation - they are shown to the user only once.
+func (s *Service) ConfirmTOTP(ctx context.Context, userID, code string) ([]string, error) {
+	factors, err := s.Store.ListFactors(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	var pending *Factor
+	enabled := false
+	for i, f := range factors {
+		if f.IsConfirmed() {
+			enabled = true
+		} else if f.Kind == FACTOR_TOTP {
+			pending = &factors[i]
+		}
+	}
+	if pending == nil {
+		return nil, ErrFactorNotFound
+	}
+	if err := s.verify(ctx, userID, []Factor{*pending}, code); err != nil {
+		return nil, err
+	}
+	if err := s.Store.ConfirmFactor(ctx, pending.ID, s.now()); err != nil {
+		return nil, err
+	}
+	if enabled {
+		return nil, nil
+	}
+	return s.RegenerateRecoveryCodes(ctx, userID)
+}
+
+// Verify the second factor of the user. Accepts codes of any confirmed
+// factor and recovery codes, which are used up. Repeated failures are
+// rejected with ErrTooManyAttempts until the failure window passes.
+func (s *Service) Verify(ctx context.Context, userID, code string) (recovery bool, err error) {
+	factors, err := s.Factors(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	if len(factors) == 0 {
+		return false, ErrFactorNotFound
+	}
+	err = s.verify(ctx, userID, factors, code)
+	if !errors.Is(err, ErrInvalidCode) {
+		return false, err
+	}
+	// The recovery code is checked only after the factors, so the failure
+	// of the factor isn't counted twice
+	normalised := normaliseRecoveryCode(code)
+	if len(normalised) != recoveryCodeLength {
+		return false, err
+	}
+	switch useErr := s.Store.UseRecoveryCode(ctx, userID, accounts.HashToken(normalised)); {
+	case errors.Is(useErr, ErrRecoveryCodeNotFound):
+		return false, err
+	case useErr != nil:
+		return false, useErr
+	}
+	return true, s.Store.ResetFailures(ctx, userID)
+}
+
+// Check the code against the factors advancing the counter of the
+// matching one. Every failure counts towards MAX_FAILURES.
+func (s *Service) verify(ctx context.Context, userID string, factors []Factor, code string) error {
+	failures, err := s.Store.Failures(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if failures >= MAX_FAILURES {
+		return ErrTooManyAttempts
+	}
+	now := s.now()
+	for _, f := range factors {
+		verifier, ok := s.Verifiers[f.Kind]
+		if !ok {
+			continue
+		}
+		counter, ok, err := verifier.Verify(ctx, &f, code, now)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		// Concurrent request using the same code wins only once
+		swapped, err := s.Store.UpdateCounter(ctx, f.ID, f.Counter, counter)
+		if err != nil {
+			return err
+		}
+		if swapped {
+			return s.Store.ResetFailures(ctx, userID)
+		}
+	}
+	if _, err := s.Store.RecordFailure(ctx, userID, FAILURE_WINDOW); err != nil {
+		return err
+	}
+	return ErrInvalidCode
+}
+
+// Replace recovery codes of the user with new ones. Only hashes are
+// stored, the returned codes are shown to the user once.
+func (s *Service) RegenerateRecoveryCodes(ctx context.Context, userID string) ([]string, error) {
+	codes := make([]string, RECOVERY_CODE_COUNT)
+	hashes := make([]string, RECOVERY_CODE_COUNT)
+	for i := range codes {
+		code := newRecoveryCode()
+		codes[i] = code[:recoveryCodeLength/2] + "-" + code[recoveryCodeLength/2:]
+		hashes[i] = accounts.HashToken(code)
+	}
+	if err := s.Store.ReplaceRecoveryCodes(ctx, userID, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func (s *Service) RemainingRecoveryCodes(ctx context.Context, userID string) (int, error) {
+	return s.Store.CountRecoveryCodes(ctx, userID)
+}
+
+// Turn two-factor authentication off removing all factors and recovery
+// codes. Remembered devices are forgotten as their factors are gone.
+func (s *Service) Disable(ctx context.Context, userID string) error {
+	if err := s.Store.DeleteUserFactors(ctx, userID); err != nil {
+		return err
+	}
+	return s.Store.ResetFailures(ctx, userID)
+}
+
+// Recovery codes have 10 characters of the base32 alphabet (50 bits),
+// too many to guess within MAX_FAILURES attempts
+const recoveryCodeLength = 10
+
+const recoveryCodeAlphabet = "abcdefghijklmnopqrstuvwxyz234567"
+
+func newRecoveryCode() string {
+	b := make([]byte, recoveryCodeLength)
+	rand.Read(b)
+	for i := range b {
+		b[i] = recoveryCodeAlphabet[b[i]%byte(len(recoveryCodeAlphabet))]
+	}
+	return string(b)
+}
+
+func normaliseRecoveryCode(code string) string {
+	return strings.NewReplacer("-", "", " ", "").Replace(strings.ToLower(strings.TrimSpace(code)))
+}
@@ -0,0 +1,183 @@
+package mfa
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mcgtrt/go-puerto/internal/session"
+	"github.com/mcgtrt/go-puerto/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestService(t *testing.T) *Service {
+	t.Setenv(utils.AES_SECRET, "0123456789abcdef0123456789abcdef")
+	return NewService(NewMemoryStore(), &utils.MFAConfig{Issuer: "example.com", RememberDeviceTTL: time.Hour})
+}
+
+// Enable TOTP for the user returning the secret and recovery codes
+func enable(t *testing.T, s *Service, userID string) (string, []string) {
+	ctx := context.Background()
+	enrollment, err := s.BeginTOTP(ctx, userID, "john@example.com")
+	assert.NoError(t, err)
+	code, _ := GenerateCode(enrollment.Secret, TimeStep(s.now()))
+	codes, err := s.ConfirmTOTP(ctx, userID, code)
+	assert.NoError(t, err)
+	return enrollment.Secret, codes
+}
+
+func TestEnrolment(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+
+	enrollment, err := s.BeginTOTP(ctx, "user", "john@example.com")
+	assert.NoError(t, err)
+	assert.NotContains(t, enrollment.Factor.Secret, enrollment.Secret, "Expected secret stored encrypted")
+	enabled, _ := s.Enabled(ctx, "user")
+	assert.False(t, enabled, "Expected factor unusable until confirmed")
+
+	_, err = s.ConfirmTOTP(ctx, "user", "000000")
+	assert.ErrorIs(t, err, ErrInvalidCode)
+
+	code, _ := GenerateCode(enrollment.Secret, TimeStep(s.now()))
+	codes, err := s.ConfirmTOTP(ctx, "user", code)
+	assert.NoError(t, err)
+	assert.Len(t, codes, RECOVERY_CODE_COUNT)
+	enabled, _ = s.Enabled(ctx, "user")
+	assert.True(t, enabled)
+
+	_, err = s.BeginTOTP(ctx, "user", "john@example.com")
+	assert.ErrorIs(t, err, ErrAlreadyEnabled)
+
+	assert.NoError(t, s.Disable(ctx, "user"))
+	enabled, _ = s.Enabled(ctx, "user")
+	assert.False(t, enabled)
+}
+
+func TestVerify(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+	now := time.Now()
+	s.now = func() time.Time { return now }
+	s.Store.(*MemoryStore).now = s.now
+	secret, codes := enable(t, s, "user")
+
+	t.Run("Drift", func(t *testing.T) {
+		now = now.Add(TOTP_PERIOD)
+		next, _ := GenerateCode(secret, TimeStep(now)+TOTP_SKEW)
+		_, err := s.Verify(ctx, "user", next)
+		assert.NoError(t, err, "Expected code of the next step accepted")
+
+		previous, _ := GenerateCode(secret, TimeStep(now)-TOTP_SKEW)
+		_, err = s.Verify(ctx, "user", previous)
+		assert.ErrorIs(t, err, ErrInvalidCode, "Expected codes older than the last used rejected")
+
+		now = now.Add(10 * TOTP_PERIOD)
+		stale, _ := GenerateCode(secret, TimeStep(now)-TOTP_SKEW-1)
+		_, err = s.Verify(ctx, "user", stale)
+		assert.ErrorIs(t, err, ErrInvalidCode, "Expected codes outside the window rejected")
+	})
+
+	t.Run("Replay", func(t *testing.T) {
+		now = now.Add(10 * TOTP_PERIOD)
+		code, _ := GenerateCode(secret, TimeStep(now))
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		accepted := 0
+		for range 5 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := s.Verify(ctx, "user", code); err == nil {
+					mu.Lock()
+					accepted++
+					mu.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, 1, accepted, "Expected code accepted once")
+		assert.NoError(t, s.Store.ResetFailures(ctx, "user"))
+	})
+
+	t.Run("Recovery code", func(t *testing.T) {
+		recovery, err := s.Verify(ctx, "user", " "+codes[0]+" ")
+		assert.NoError(t, err)
+		assert.True(t, recovery)
+		_, err = s.Verify(ctx, "user", codes[0])
+		assert.ErrorIs(t, err, ErrInvalidCode, "Expected single-use recovery code")
+		remaining, _ := s.RemainingRecoveryCodes(ctx, "user")
+		assert.Equal(t, RECOVERY_CODE_COUNT-1, remaining)
+		assert.NoError(t, s.Store.ResetFailures(ctx, "user"))
+	})
+
+	t.Run("Too many attempts", func(t *testing.T) {
+		for range MAX_FAILURES {
+			_, err := s.Verify(ctx, "user", "000000")
+			assert.ErrorIs(t, err, ErrInvalidCode)
+		}
+		now = now.Add(10 * TOTP_PERIOD)
+		code, _ := GenerateCode(secret, TimeStep(now))
+		_, err := s.Verify(ctx, "user", code)
+		assert.ErrorIs(t, err, ErrTooManyAttempts, "Expected valid code rejected after failures")
+
+		now = now.Add(FAILURE_WINDOW)
+		code, _ = GenerateCode(secret, TimeStep(now))
+		_, err = s.Verify(ctx, "user", code)
+		assert.NoError(t, err, "Expected failures forgotten after the window")
+	})
+}
+
+func TestPendingLogin(t *testing.T) {
+	s := newTestService(t)
+	now := time.Now()
+	s.now = func() time.Time { return now }
+	sess := &session.Session{}
+
+	assert.NoError(t, s.SavePendingLogin(sess, "user", "/orders"))
+	pending, ok := s.GetPendingLogin(sess)
+	if assert.True(t, ok) {
+		assert.Equal(t, "user", pending.UserID)
+		assert.Equal(t, "/orders", pending.Next)
+	}
+
+	now = now.Add(PENDING_LOGIN_TTL)
+	_, ok = s.GetPendingLogin(sess)
+	assert.False(t, ok, "Expected pending login expired")
+
+	assert.NoError(t, s.SavePendingLogin(sess, "user", "/"))
+	s.ClearPendingLogin(sess)
+	_, ok = s.GetPendingLogin(sess)
+	assert.False(t, ok)
+}
+
+func TestRememberDevice(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+	enable(t, s, "user")
+
+	r := httptest.NewRequest(http.MethodPost, "/login", nil)
+	required, err := s.Required(ctx, r, "user")
+	assert.NoError(t, err)
+	assert.True(t, required)
+
+	rec := httptest.NewRecorder()
+	assert.NoError(t, s.RememberDevice(ctx, rec, "user", true))
+	cookie := rec.Result().Cookies()[0]
+	assert.True(t, cookie.HttpOnly && cookie.Secure)
+	r.AddCookie(cookie)
+	required, _ = s.Required(ctx, r, "user")
+	assert.False(t, required, "Expected remembered device skipping the second factor")
+
+	enable(t, s, "other")
+	required, _ = s.Required(ctx, r, "other")
+	assert.True(t, required, "Expected device remembered only for its user")
+
+	assert.NoError(t, s.Disable(ctx, "user"))
+	enable(t, s, "user")
+	required, _ = s.Required(ctx, r, "user")
+	assert.True(t, required, "Expected devices forgotten after disabling")
+}
@@ -0,0 +1,105 @@
+package mfa
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/mcgtrt/go-puerto/utils"
+)
+
+// Parameters of the generated codes (RFC 6238 defaults supported by
+// every authenticator app)
+const (
+	TOTP_DIGITS = 6
+	TOTP_PERIOD = 30 * time.Second
+	// Time steps accepted before and after the current one, to tolerate
+	// clock drift of the phone and codes typed just before they change
+	TOTP_SKEW = 1
+	// Secret length in bytes (160 bits recommended by RFC 4226)
+	TOTP_SECRET_SIZE = 20
+)
+
+var b32 = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Generate random TOTP secret encoded in base32 as expected by
+// authenticator apps
+func GenerateSecret() string {
+	secret := make([]byte, TOTP_SECRET_SIZE)
+	rand.Read(secret)
+	return b32.EncodeToString(secret)
+}
+
+// Enrolment URI encoded in the QR code scanned by authenticator apps
+func TOTPURI(issuer, account, secret string) string {
+	query := url.Values{
+		"secret":    {secret},
+		"issuer":    {issuer},
+		"algorithm": {"SHA1"},
+		"digits":    {fmt.Sprint(TOTP_DIGITS)},
+		"period":    {fmt.Sprint(int(TOTP_PERIOD.Seconds()))},
+	}
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+// Code of the secret for the time step (HOTP of RFC 4226)
+func GenerateCode(secret string, step int64) (string, error) {
+	key, err := b32.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", TOTP_DIGITS, value%uint32(math.Pow10(TOTP_DIGITS))), nil
+}
+
+// Time step of the moment
+func TimeStep(t time.Time) int64 {
+	return t.Unix() / int64(TOTP_PERIOD.Seconds())
+}
+
+// Verifies codes of authenticator apps. The secret is kept sealed with
+// utils.SealAES in Factor.Secret and Factor.Counter holds the last
+// accepted time step, so every code is accepted only once.
+type TOTPVerifier struct{}
+
+func (TOTPVerifier) Verify(ctx context.Context, f *Factor, response string, now time.Time) (int64, bool, error) {
+	response = strings.ReplaceAll(strings.TrimSpace(response), " ", "")
+	if len(response) != TOTP_DIGITS {
+		return 0, false, nil
+	}
+	secret, err := utils.OpenAES(f.Secret)
+	if err != nil {
+		return 0, false, err
+	}
+	current := TimeStep(now)
+	for step := current - TOTP_SKEW; step <= current+TOTP_SKEW; step++ {
+		if step <= f.Counter {
+			continue
+		}
+		code, err := GenerateCode(secret, step)
+		if err != nil {
+			return 0, false, err
+		}
+		if subtle.ConstantTimeCompare([]byte(code), []byte(response)) == 1 {
+			return step, true, nil
+		}
+	}
+	return 0, false, nil
+}
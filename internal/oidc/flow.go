@@ -0,0 +1,64 @@
+package oidc
+
+import (
+	"errors"
+	"time"
+
+	"github.com/mcgtrt/go-puerto/internal/session"
+)
+
+// Time the user has to complete sign-in at the provider
+const FLOW_TTL = 10 * time.Minute
+
+// Most pending flows kept per session (sign-in started in several tabs)
+const MAX_FLOWS = 5
+
+const flowsSessionKey = "oidc_flows"
+
+var ErrFlowNotFound = errors.New("oidc sign-in not started or expired")
+
+// Keep the flow in the session until the provider redirects back
+func SaveFlow(s *session.Session, f *Flow) error {
+	flows := pendingFlows(s, time.Now())
+	if len(flows) >= MAX_FLOWS {
+		var oldest string
+		for state, pending := range flows {
+			if oldest == "" || pending.ExpiresAt.Before(flows[oldest].ExpiresAt) {
+				oldest = state
+			}
+		}
+		delete(flows, oldest)
+	}
+	flows[f.State] = *f
+	return s.Set(flowsSessionKey, flows)
+}
+
+// Remove and return the flow started with the state. The flow can be used
+// only once, so a replayed callback fails.
+func TakeFlow(s *session.Session, state string) (*Flow, error) {
+	flows := pendingFlows(s, time.Now())
+	f, ok := flows[state]
+	if !ok || state == "" {
+		return nil, ErrFlowNotFound
+	}
+	delete(flows, state)
+	if len(flows) == 0 {
+		s.Delete(flowsSessionKey)
+	} else if err := s.Set(flowsSessionKey, flows); err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+func pendingFlows(s *session.Session, now time.Time) map[string]Flow {
+	flows, ok := session.Get[map[string]Flow](s, flowsSessionKey)
+	if !ok || flows == nil {
+		return make(map[string]Flow)
+	}
+	for state, f := range flows {
+		if !f.ExpiresAt.After(now) {
+			delete(flows, state)
+		}
+	}
+	return flows
+}
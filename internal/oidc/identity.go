@@ -0,0 +1,84 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	ErrIdentityNotFound = errors.New("identity not found")
+	ErrIdentityLinked   = errors.New("identity is linked to another user")
+)
+
+// Account of the user at the identity provider linked to the local user.
+// Subject is the stable user ID issued by the provider - unlike the email
+// it never changes and is never reassigned.
+type Identity struct {
+	Provider  string    `json:"provider" bson:"provider"`
+	Subject   string    `json:"subject" bson:"subject"`
+	UserID    string    `json:"user_id" bson:"user_id"`
+	Email     string    `json:"email,omitempty" bson:"email,omitempty"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+}
+
+// Persistence of linked identities
+type IdentityStore interface {
+	// Returns ErrIdentityNotFound if the identity isn't linked
+	GetIdentity(ctx context.Context, provider, subject string) (*Identity, error)
+	// Returns ErrIdentityLinked if the identity is linked already
+	CreateIdentity(ctx context.Context, i *Identity) error
+	ListIdentities(ctx context.Context, userID string) ([]Identity, error)
+	DeleteIdentity(ctx context.Context, provider, subject string) error
+}
+
+// Keeps identities in the process memory. Useful for tests and prototyping.
+type MemoryStore struct {
+	mu         sync.Mutex
+	identities map[[2]string]Identity
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{identities: make(map[[2]string]Identity)}
+}
+
+func (m *MemoryStore) GetIdentity(ctx context.Context, provider, subject string) (*Identity, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i, ok := m.identities[[2]string{provider, subject}]
+	if !ok {
+		return nil, ErrIdentityNotFound
+	}
+	return &i, nil
+}
+
+func (m *MemoryStore) CreateIdentity(ctx context.Context, i *Identity) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := [2]string{i.Provider, i.Subject}
+	if _, ok := m.identities[key]; ok {
+		return ErrIdentityLinked
+	}
+	m.identities[key] = *i
+	return nil
+}
+
+func (m *MemoryStore) ListIdentities(ctx context.Context, userID string) ([]Identity, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	identities := []Identity{}
+	for _, i := range m.identities {
+		if i.UserID == userID {
+			identities = append(identities, i)
+		}
+	}
+	return identities, nil
+}
+
+func (m *MemoryStore) DeleteIdentity(ctx context.Context, provider, subject string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.identities, [2]string{provider, subject})
+	return nil
+}
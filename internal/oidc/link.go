@@ -0,0 +1,96 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/mcgtrt/go-puerto/internal/accounts"
+)
+
+var (
+	// Returned when the email of the identity belongs to a local account
+	// that can't be linked automatically. The user has to log in with the
+	// password first and link the provider then.
+	ErrEmailInUse = errors.New("email is registered to another account")
+	// Returned for new users when the provider doesn't share the email
+	ErrEmailRequired = errors.New("provider didn't return an email")
+)
+
+// Resolves provider identities to local users
+type Linker struct {
+	Accounts   *accounts.Service
+	Identities IdentityStore
+}
+
+func NewLinker(service *accounts.Service, identities IdentityStore) *Linker {
+	return &Linker{
+		Accounts:   service,
+		Identities: identities,
+	}
+}
+
+// Return the user signing in with the identity, linking or registering it
+// when needed. CurrentUserID is the ID of the logged in user (empty for
+// anonymous requests), who gets the identity linked to the account.
+//
+// Identities are matched to existing accounts by email only when both the
+// provider and the local account verified it. Otherwise anyone could
+// register an unverified account with the victim's email and get access
+// to it once the victim signs in with the provider.
+func (l *Linker) Resolve(ctx context.Context, provider string, claims *IDClaims, currentUserID string) (*accounts.User, error) {
+	identity, err := l.Identities.GetIdentity(ctx, provider, claims.Subject)
+	switch {
+	case err == nil:
+		if currentUserID != "" && identity.UserID != currentUserID {
+			return nil, ErrIdentityLinked
+		}
+		return l.Accounts.Store.GetUserByID(ctx, identity.UserID)
+	case !errors.Is(err, ErrIdentityNotFound):
+		return nil, err
+	}
+
+	if currentUserID != "" {
+		user, err := l.Accounts.Store.GetUserByID(ctx, currentUserID)
+		if err != nil {
+			return nil, err
+		}
+		return l.link(ctx, provider, claims, user)
+	}
+
+	if claims.Email == "" {
+		return nil, ErrEmailRequired
+	}
+	user, err := l.Accounts.Store.GetUserByEmail(ctx, accounts.NormaliseEmail(claims.Email))
+	switch {
+	case err == nil:
+		if !claims.EmailVerified || !user.IsVerified() {
+			return nil, ErrEmailInUse
+		}
+	case errors.Is(err, accounts.ErrUserNotFound):
+		user, err = l.Accounts.RegisterExternal(ctx, claims.Email, claims.Name, claims.EmailVerified)
+		if errors.Is(err, accounts.ErrEmailTaken) {
+			return nil, ErrEmailInUse
+		}
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+	return l.link(ctx, provider, claims, user)
+}
+
+func (l *Linker) link(ctx context.Context, provider string, claims *IDClaims, user *accounts.User) (*accounts.User, error) {
+	err := l.Identities.CreateIdentity(ctx, &Identity{
+		Provider:  provider,
+		Subject:   claims.Subject,
+		UserID:    user.ID,
+		Email:     accounts.NormaliseEmail(claims.Email),
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
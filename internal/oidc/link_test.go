@@ -0,0 +1,57 @@
+package oidc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mcgtrt/go-puerto/internal/accounts"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolve(t *testing.T) {
+	ctx := context.Background()
+	service := accounts.NewService(accounts.NewMemoryStore())
+	l := NewLinker(service, NewMemoryStore())
+
+	claims := &IDClaims{Subject: "1", Email: "Jane@example.com", EmailVerified: true, Name: "Jane"}
+	user, err := l.Resolve(ctx, "google", claims, "")
+	assert.NoError(t, err)
+	assert.Equal(t, "jane@example.com", user.Email)
+	assert.True(t, user.IsVerified(), "Expected email verified by the provider")
+
+	again, err := l.Resolve(ctx, "google", &IDClaims{Subject: "1", Email: "changed@example.com"}, "")
+	assert.NoError(t, err)
+	assert.Equal(t, user.ID, again.ID, "Expected identity matched by subject, not email")
+
+	t.Run("Link to logged in user", func(t *testing.T) {
+		linked, err := l.Resolve(ctx, "github", &IDClaims{Subject: "42"}, user.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, user.ID, linked.ID)
+		identities, _ := l.Identities.ListIdentities(ctx, user.ID)
+		assert.Len(t, identities, 2)
+
+		other, _ := service.RegisterExternal(ctx, "john@example.com", "John", true)
+		_, err = l.Resolve(ctx, "github", &IDClaims{Subject: "42"}, other.ID)
+		assert.ErrorIs(t, err, ErrIdentityLinked)
+	})
+
+	t.Run("Link by verified email", func(t *testing.T) {
+		linked, err := l.Resolve(ctx, "gitlab", &IDClaims{Subject: "7", Email: "jane@example.com", EmailVerified: true}, "")
+		assert.NoError(t, err)
+		assert.Equal(t, user.ID, linked.ID)
+
+		_, err = l.Resolve(ctx, "azure", &IDClaims{Subject: "7", Email: "jane@example.com"}, "")
+		assert.ErrorIs(t, err, ErrEmailInUse, "Expected unverified provider email not linked")
+	})
+
+	t.Run("Unverified local account", func(t *testing.T) {
+		service.HashParams.Memory, service.HashParams.Time = 1024, 1
+		_, _, err := service.Register(ctx, "victim@example.com", "Attacker", "Secret1!")
+		assert.NoError(t, err)
+		_, err = l.Resolve(ctx, "google", &IDClaims{Subject: "2", Email: "victim@example.com", EmailVerified: true}, "")
+		assert.ErrorIs(t, err, ErrEmailInUse, "Expected pre-registered account not taken over")
+	})
+
+	_, err = l.Resolve(ctx, "google", &IDClaims{Subject: "3"}, "")
+	assert.ErrorIs(t, err, ErrEmailRequired)
+}
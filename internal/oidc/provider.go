@@ -0,0 +1,290 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mcgtrt/go-puerto/internal/jwt"
+	"github.com/mcgtrt/go-puerto/utils"
+)
+
+// Tolerated difference between the clocks of the provider and the server
+const CLOCK_SKEW = time.Minute
+
+// Minimum time between refetching provider keys for tokens signed with
+// an unknown key, so forged key IDs can't make us hammer the provider
+const KEYS_REFRESH_INTERVAL = time.Minute
+
+// Largest accepted response of the provider endpoints
+const maxResponseSize = 1 << 20
+
+var (
+	ErrDiscovery      = errors.New("oidc discovery failed")
+	ErrExchange       = errors.New("oidc code exchange failed")
+	ErrInvalidIDToken = errors.New("invalid id token")
+)
+
+// Provider configuration published at /.well-known/openid-configuration
+type Metadata struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	JWKSURI               string   `json:"jwks_uri"`
+	UserinfoEndpoint      string   `json:"userinfo_endpoint,omitempty"`
+	CodeChallengeMethods  []string `json:"code_challenge_methods_supported,omitempty"`
+}
+
+// Claims of the ID token identifying the user
+type IDClaims struct {
+	Issuer          string       `json:"iss"`
+	Subject         string       `json:"sub"`
+	Audience        jwt.Audience `json:"aud"`
+	ExpiresAt       int64        `json:"exp"`
+	IssuedAt        int64        `json:"iat"`
+	Nonce           string       `json:"nonce"`
+	AuthorizedParty string       `json:"azp,omitempty"`
+	Email           string       `json:"email,omitempty"`
+	EmailVerified   bool         `json:"email_verified,omitempty"`
+	Name            string       `json:"name,omitempty"`
+}
+
+// OpenID Connect provider the application signs users in with, using
+// the authorization code flow with PKCE. Provider metadata and keys are
+// discovered on first use.
+type Provider struct {
+	Name         string
+	Label        string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+	RedirectURL  string
+	Client       *http.Client
+
+	mu          sync.Mutex
+	metadata    *Metadata
+	keys        *jwt.PublicKeySet
+	keysFetched time.Time
+	now         func() time.Time
+}
+
+// Create provider redirecting back to /auth/oidc/{name}/callback of the base URL
+func NewProvider(cfg utils.OIDCProviderConfig, baseURL string, client *http.Client) *Provider {
+	return &Provider{
+		Name:         cfg.Name,
+		Label:        cfg.Label,
+		Issuer:       cfg.Issuer,
+		ClientID:     cfg.ClientID,
+		ClientSecret: cfg.ClientSecret,
+		Scopes:       cfg.Scopes,
+		RedirectURL:  baseURL + "/auth/oidc/" + cfg.Name + "/callback",
+		Client:       client,
+		now:          time.Now,
+	}
+}
+
+// Fetch (once) and validate the provider metadata
+func (p *Provider) Metadata(ctx context.Context) (*Metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.metadata != nil {
+		return p.metadata, nil
+	}
+	m := &Metadata{}
+	if err := p.get(ctx, strings.TrimSuffix(p.Issuer, "/")+"/.well-known/openid-configuration", m); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrDiscovery, err)
+	}
+	// The issuer must match exactly, otherwise one provider could issue
+	// tokens accepted as another's (OpenID Connect Discovery 4.3)
+	if m.Issuer != p.Issuer {
+		return nil, fmt.Errorf("%w: issuer %q doesn't match %q", ErrDiscovery, m.Issuer, p.Issuer)
+	}
+	if m.AuthorizationEndpoint == "" || m.TokenEndpoint == "" || m.JWKSURI == "" {
+		return nil, fmt.Errorf("%w: missing endpoints", ErrDiscovery)
+	}
+	p.metadata = m
+	return m, nil
+}
+
+// Pending authorization request kept in the session until the callback
+type Flow struct {
+	Provider  string    `json:"provider"`
+	State     string    `json:"state"`
+	Nonce     string    `json:"nonce"`
+	Verifier  string    `json:"verifier"`
+	Next      string    `json:"next,omitempty"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Start the authorization request. Returns the flow to keep in the
+// session and the provider URL to redirect the user to.
+func (p *Provider) Begin(ctx context.Context, next string) (*Flow, string, error) {
+	m, err := p.Metadata(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	flow := &Flow{
+		Provider:  p.Name,
+		State:     randomString(),
+		Nonce:     randomString(),
+		Verifier:  randomString(),
+		Next:      next,
+		ExpiresAt: p.now().Add(FLOW_TTL),
+	}
+	challenge := sha256.Sum256([]byte(flow.Verifier))
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.ClientID},
+		"redirect_uri":          {p.RedirectURL},
+		"scope":                 {strings.Join(p.Scopes, " ")},
+		"state":                 {flow.State},
+		"nonce":                 {flow.Nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	separator := "?"
+	if strings.Contains(m.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return flow, m.AuthorizationEndpoint + separator + query.Encode(), nil
+}
+
+type tokenResponse struct {
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// Exchange the authorization code for tokens and return the verified
+// claims of the ID token
+func (p *Provider) Exchange(ctx context.Context, flow *Flow, code string) (*IDClaims, error) {
+	m, err := p.Metadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.RedirectURL},
+		"code_verifier": {flow.Verifier},
+		"client_id":     {p.ClientID},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrExchange, err)
+	}
+	defer resp.Body.Close()
+
+	tokens := &tokenResponse{}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(tokens); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrExchange, err)
+	}
+	if resp.StatusCode != http.StatusOK || tokens.Error != "" {
+		return nil, fmt.Errorf("%w: %s %s", ErrExchange, tokens.Error, tokens.ErrorDescription)
+	}
+	if tokens.IDToken == "" {
+		return nil, fmt.Errorf("%w: no id token", ErrExchange)
+	}
+	return p.Verify(ctx, tokens.IDToken, flow.Nonce)
+}
+
+// Verify signature, issuer, audience, validity and nonce of the ID token
+func (p *Provider) Verify(ctx context.Context, idToken, nonce string) (*IDClaims, error) {
+	m, err := p.Metadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := p.keySet(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	claims := &IDClaims{}
+	err = jwt.Parse(idToken, keys, claims)
+	if errors.Is(err, jwt.ErrUnknownKey) {
+		// The provider may have rotated its keys
+		if keys, err = p.keySet(ctx, true); err != nil {
+			return nil, err
+		}
+		err = jwt.Parse(idToken, keys, claims)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidIDToken, err)
+	}
+
+	now := p.now()
+	switch {
+	case claims.Issuer != m.Issuer:
+		return nil, fmt.Errorf("%w: wrong issuer", ErrInvalidIDToken)
+	case !claims.Audience.Contains([]string{p.ClientID}):
+		return nil, fmt.Errorf("%w: wrong audience", ErrInvalidIDToken)
+	case (len(claims.Audience) > 1 || claims.AuthorizedParty != "") && claims.AuthorizedParty != p.ClientID:
+		return nil, fmt.Errorf("%w: wrong authorized party", ErrInvalidIDToken)
+	case now.Add(-CLOCK_SKEW).Unix() >= claims.ExpiresAt:
+		return nil, fmt.Errorf("%w: expired", ErrInvalidIDToken)
+	case now.Add(CLOCK_SKEW).Unix() < claims.IssuedAt:
+		return nil, fmt.Errorf("%w: issued in the future", ErrInvalidIDToken)
+	case subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1:
+		return nil, fmt.Errorf("%w: wrong nonce", ErrInvalidIDToken)
+	case claims.Subject == "":
+		return nil, fmt.Errorf("%w: missing subject", ErrInvalidIDToken)
+	}
+	return claims, nil
+}
+
+// Return cached provider keys. Refresh refetches them unless they were
+// fetched recently.
+func (p *Provider) keySet(ctx context.Context, refresh bool) (*jwt.PublicKeySet, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.keys != nil && (!refresh || p.now().Sub(p.keysFetched) < KEYS_REFRESH_INTERVAL) {
+		return p.keys, nil
+	}
+	set := jwt.JWKS{}
+	if err := p.get(ctx, p.metadata.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("%w: fetching keys: %w", ErrInvalidIDToken, err)
+	}
+	p.keys, p.keysFetched = jwt.NewPublicKeySet(set), p.now()
+	return p.keys, nil
+}
+
+func (p *Provider) get(ctx context.Context, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(v)
+}
+
+func randomString() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
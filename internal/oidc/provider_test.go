@@ -0,0 +1,158 @@
+package oidc
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/mcgtrt/go-puerto/internal/jwt"
+	"github.com/mcgtrt/go-puerto/internal/oidc/oidctest"
+	"github.com/mcgtrt/go-puerto/internal/session"
+	"github.com/mcgtrt/go-puerto/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestProvider(server *oidctest.Server) *Provider {
+	return NewProvider(utils.OIDCProviderConfig{
+		Name:         "test",
+		Issuer:       server.Issuer(),
+		ClientID:     server.ClientID,
+		ClientSecret: server.ClientSecret,
+		Scopes:       []string{"openid", "email"},
+	}, "http://app.example.com", server.Client())
+}
+
+// Sign in at the provider returning the code and state from the callback
+func authorize(t *testing.T, authURL string) (code, state string) {
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(authURL)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusFound, resp.StatusCode)
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	assert.NoError(t, err)
+	assert.Equal(t, "/auth/oidc/test/callback", callback.Path)
+	return callback.Query().Get("code"), callback.Query().Get("state")
+}
+
+func TestSignIn(t *testing.T) {
+	ctx := context.Background()
+	server := oidctest.NewServer(t)
+	p := newTestProvider(server)
+
+	flow, authURL, err := p.Begin(ctx, "/orders")
+	assert.NoError(t, err)
+	assert.Equal(t, "/orders", flow.Next)
+	query := must(url.Parse(authURL)).Query()
+	assert.Equal(t, "openid email", query.Get("scope"))
+	assert.Equal(t, "S256", query.Get("code_challenge_method"))
+	assert.NotContains(t, authURL, flow.Verifier, "Expected only the challenge sent to the provider")
+
+	code, state := authorize(t, authURL)
+	assert.Equal(t, flow.State, state)
+	claims, err := p.Exchange(ctx, flow, code)
+	assert.NoError(t, err)
+	assert.Equal(t, server.User.Subject, claims.Subject)
+	assert.Equal(t, server.User.Email, claims.Email)
+	assert.True(t, claims.EmailVerified)
+
+	_, err = p.Exchange(ctx, flow, code)
+	assert.ErrorIs(t, err, ErrExchange, "Expected single-use code")
+
+	t.Run("Wrong verifier", func(t *testing.T) {
+		flow, authURL, _ := p.Begin(ctx, "")
+		code, _ := authorize(t, authURL)
+		flow.Verifier = "stolen-code-without-verifier"
+		_, err := p.Exchange(ctx, flow, code)
+		assert.ErrorIs(t, err, ErrExchange)
+	})
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name   string
+		claims func(map[string]any)
+	}{
+		{"wrong issuer", func(c map[string]any) { c["iss"] = "https://evil.example.com" }},
+		{"wrong audience", func(c map[string]any) { c["aud"] = "other-client" }},
+		{"wrong authorized party", func(c map[string]any) { c["aud"] = []string{"client", "other"}; c["azp"] = "other" }},
+		{"expired", func(c map[string]any) { c["exp"] = time.Now().Add(-2 * CLOCK_SKEW).Unix() }},
+		{"issued in the future", func(c map[string]any) { c["iat"] = time.Now().Add(2 * CLOCK_SKEW).Unix() }},
+		{"wrong nonce", func(c map[string]any) { c["nonce"] = "replayed" }},
+		{"missing subject", func(c map[string]any) { delete(c, "sub") }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := oidctest.NewServer(t)
+			server.Claims = tt.claims
+			p := newTestProvider(server)
+			flow, authURL, err := p.Begin(ctx, "")
+			assert.NoError(t, err)
+			code, _ := authorize(t, authURL)
+			_, err = p.Exchange(ctx, flow, code)
+			assert.ErrorIs(t, err, ErrInvalidIDToken)
+		})
+	}
+
+	t.Run("Key rotation", func(t *testing.T) {
+		server := oidctest.NewServer(t)
+		p := newTestProvider(server)
+		flow, authURL, _ := p.Begin(ctx, "")
+		code, _ := authorize(t, authURL)
+		_, err := p.Exchange(ctx, flow, code)
+		assert.NoError(t, err)
+
+		server.Key = must(jwt.GenerateKey(jwt.ALG_EDDSA))
+		flow, authURL, _ = p.Begin(ctx, "")
+		code, _ = authorize(t, authURL)
+		_, err = p.Exchange(ctx, flow, code)
+		assert.ErrorIs(t, err, jwt.ErrUnknownKey, "Expected keys not refetched right after the last fetch")
+
+		p.keysFetched = p.keysFetched.Add(-KEYS_REFRESH_INTERVAL)
+		flow, authURL, _ = p.Begin(ctx, "")
+		code, _ = authorize(t, authURL)
+		_, err = p.Exchange(ctx, flow, code)
+		assert.NoError(t, err, "Expected rotated keys refetched")
+	})
+}
+
+func TestDiscoveryIssuerMismatch(t *testing.T) {
+	server := oidctest.NewServer(t)
+	p := newTestProvider(server)
+	p.Issuer = server.Issuer() + "/"
+	_, _, err := p.Begin(context.Background(), "")
+	assert.ErrorIs(t, err, ErrDiscovery)
+}
+
+func must[T any](v T, err error) T {
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+func TestFlows(t *testing.T) {
+	s := &session.Session{}
+	for range MAX_FLOWS + 1 {
+		assert.NoError(t, SaveFlow(s, &Flow{State: randomString(), ExpiresAt: time.Now().Add(FLOW_TTL)}))
+	}
+	flows, _ := session.Get[map[string]Flow](s, flowsSessionKey)
+	assert.Len(t, flows, MAX_FLOWS, "Expected oldest flow dropped")
+
+	flow := &Flow{State: "state", ExpiresAt: time.Now().Add(FLOW_TTL)}
+	assert.NoError(t, SaveFlow(s, flow))
+	taken, err := TakeFlow(s, "state")
+	assert.NoError(t, err)
+	assert.Equal(t, flow.State, taken.State)
+	_, err = TakeFlow(s, "state")
+	assert.ErrorIs(t, err, ErrFlowNotFound, "Expected single-use flow")
+
+	assert.NoError(t, SaveFlow(s, &Flow{State: "expired", ExpiresAt: time.Now().Add(-time.Second)}))
+	_, err = TakeFlow(s, "expired")
+	assert.ErrorIs(t, err, ErrFlowNotFound)
+}
@@ -0,0 +1,193 @@
+package scheduler
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidSchedule = errors.New("invalid cron expression")
+
+// Shorthands of common schedules
+var descriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var (
+	monthNames = []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+	dayNames   = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+)
+
+type field struct {
+	name     string
+	min, max int
+	names    []string
+}
+
+var fields = []field{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12, names: monthNames},
+	// 7 is Sunday as well
+	{name: "day of week", min: 0, max: 7, names: dayNames},
+}
+
+// Parsed cron expression with five fields: minute, hour, day of month,
+// month and day of week. Fields take *, values, ranges (1-5), steps
+// (*/15, 8-18/2), lists (1,15) and month and day names (JAN, MON).
+// @hourly, @daily, @weekly, @monthly and @yearly are shorthands. When
+// both days are restricted either of them matches, as in cron. A
+// CRON_TZ=<zone> prefix reads the expression in the zone instead of the
+// default one.
+type Schedule struct {
+	Expr     string
+	Location *time.Location
+
+	minute, hour, dom, month, dow uint64
+	// Day fields restricted to some days, not *
+	domSet, dowSet bool
+}
+
+// Parse the cron expression read in loc unless it has a CRON_TZ prefix
+func Parse(expr string, loc *time.Location) (*Schedule, error) {
+	s := &Schedule{Expr: expr, Location: loc}
+	spec := strings.TrimSpace(expr)
+	if rest, ok := strings.CutPrefix(spec, "CRON_TZ="); ok {
+		zone, fieldsSpec, _ := strings.Cut(rest, " ")
+		l, err := time.LoadLocation(zone)
+		if err != nil {
+			return nil, fmt.Errorf("%w: unknown time zone %q", ErrInvalidSchedule, zone)
+		}
+		s.Location, spec = l, strings.TrimSpace(fieldsSpec)
+	}
+	if s.Location == nil {
+		s.Location = time.UTC
+	}
+	if d, ok := descriptors[strings.ToLower(spec)]; ok {
+		spec = d
+	}
+	parts := strings.Fields(spec)
+	if len(parts) != len(fields) {
+		return nil, fmt.Errorf("%w: expected 5 fields, got %d", ErrInvalidSchedule, len(parts))
+	}
+	bits := make([]uint64, len(fields))
+	for i, part := range parts {
+		b, err := parseField(part, fields[i])
+		if err != nil {
+			return nil, err
+		}
+		bits[i] = b
+	}
+	s.minute, s.hour, s.dom, s.month, s.dow = bits[0], bits[1], bits[2], bits[3], bits[4]
+	// Sunday is both 0 and 7
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	s.domSet, s.dowSet = parts[2] != "*", parts[4] != "*"
+	return s, nil
+}
+
+func parseField(spec string, f field) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(spec, ",") {
+		rangeSpec, stepSpec, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepSpec)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("%w: invalid step %q in %s", ErrInvalidSchedule, stepSpec, f.name)
+			}
+			step = n
+		}
+		low, high := f.min, f.max
+		if rangeSpec != "*" {
+			from, to, isRange := strings.Cut(rangeSpec, "-")
+			var err error
+			if low, err = parseValue(from, f); err != nil {
+				return 0, err
+			}
+			high = low
+			if isRange {
+				if high, err = parseValue(to, f); err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				// 5/15 runs from 5 to the end of the range
+				high = f.max
+			}
+			if low > high {
+				return 0, fmt.Errorf("%w: range %q of %s ends before it starts", ErrInvalidSchedule, rangeSpec, f.name)
+			}
+		}
+		for v := low; v <= high; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+func parseValue(spec string, f field) (int, error) {
+	for i, name := range f.names {
+		if strings.EqualFold(spec, name) {
+			return i + f.min, nil
+		}
+	}
+	n, err := strconv.Atoi(spec)
+	if err != nil || n < f.min || n > f.max {
+		return 0, fmt.Errorf("%w: %s must be %d-%d, got %q", ErrInvalidSchedule, f.name, f.min, f.max, spec)
+	}
+	return n, nil
+}
+
+// First time after t matching the schedule, zero if there is none within
+// five years, e.g. for 30 February. Times skipped by daylight saving
+// changes don't run, repeated times run once.
+func (s *Schedule) Next(t time.Time) time.Time {
+	t = t.In(s.Location).Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, s.Location)
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, s.Location)
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			// Absolute time keeps moving forward when the clock goes back
+			t = t.Add(time.Duration(60-t.Minute()) * time.Minute)
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 || repeated(t) {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// The clock showed the same time an hour ago, before it was set back
+func repeated(t time.Time) bool {
+	before := t.Add(-time.Hour)
+	return before.Hour() == t.Hour() && before.Minute() == t.Minute()
+}
+
+func (s *Schedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domSet && s.dowSet {
+		return dom || dow
+	}
+	return dom && dow
+}
@@ -0,0 +1,74 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	for _, expr := range []string{"* * * * *", "*/15 9-17 * * MON-FRI", "0 0 1,15 * *", "5/10 * * jan,Jul 7", "@daily", "CRON_TZ=Asia/Tokyo 0 9 * * *"} {
+		_, err := Parse(expr, time.UTC)
+		assert.NoError(t, err, expr)
+	}
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "* * * foo *", "CRON_TZ=Nowhere/City * * * * *"} {
+		_, err := Parse(expr, time.UTC)
+		assert.ErrorIs(t, err, ErrInvalidSchedule, expr)
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	warsaw, err := time.LoadLocation("Europe/Warsaw")
+	assert.NoError(t, err)
+	at := func(loc *time.Location, value string) time.Time {
+		tm, err := time.ParseInLocation("2006-01-02 15:04", value, loc)
+		assert.NoError(t, err)
+		return tm
+	}
+
+	tests := []struct {
+		name  string
+		expr  string
+		loc   *time.Location
+		after string
+		next  []string
+	}{
+		{"every minute", "* * * * *", time.UTC, "2024-03-01 10:00", []string{"2024-03-01 10:01", "2024-03-01 10:02"}},
+		{"steps and ranges", "*/20 9-10 * * *", time.UTC, "2024-03-01 10:45", []string{"2024-03-02 09:00", "2024-03-02 09:20"}},
+		{"weekdays", "0 9 * * MON-FRI", time.UTC, "2024-03-01 12:00", []string{"2024-03-04 09:00", "2024-03-05 09:00"}},
+		{"sunday as 7", "0 0 * * 7", time.UTC, "2024-03-01 12:00", []string{"2024-03-03 00:00", "2024-03-10 00:00"}},
+		{"either day matches", "0 0 13 * FRI", time.UTC, "2024-09-01 00:00", []string{"2024-09-06 00:00", "2024-09-13 00:00", "2024-09-20 00:00"}},
+		{"leap day", "0 0 29 2 *", time.UTC, "2024-03-01 00:00", []string{"2028-02-29 00:00"}},
+		{"monthly", "@monthly", time.UTC, "2024-01-31 23:59", []string{"2024-02-01 00:00", "2024-03-01 00:00"}},
+		{"timezone", "0 9 * * *", warsaw, "2024-06-01 12:00", []string{"2024-06-02 09:00"}},
+		// The clock jumps from 2:00 to 3:00 on 31 March
+		{"skipped by daylight saving", "30 2 * * *", warsaw, "2024-03-30 03:00", []string{"2024-04-01 02:30"}},
+		// The clock goes back from 3:00 to 2:00 on 27 October
+		{"repeated by daylight saving", "30 2 * * *", warsaw, "2024-10-26 03:00", []string{"2024-10-27 02:30", "2024-10-28 02:30"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := Parse(tt.expr, tt.loc)
+			assert.NoError(t, err)
+			next := at(tt.loc, tt.after)
+			for _, want := range tt.next {
+				next = s.Next(next)
+				assert.Equal(t, want, next.In(tt.loc).Format("2006-01-02 15:04"))
+			}
+		})
+	}
+
+	t.Run("CRON_TZ overrides the default zone", func(t *testing.T) {
+		s, err := Parse("CRON_TZ=Europe/Warsaw 0 9 * * *", time.UTC)
+		assert.NoError(t, err)
+		assert.Equal(t, "Europe/Warsaw", s.Location.String())
+		assert.True(t, at(time.UTC, "2024-06-02 07:00").Equal(s.Next(at(time.UTC, "2024-06-01 12:00"))))
+	})
+
+	t.Run("Never", func(t *testing.T) {
+		s, err := Parse("0 0 30 2 *", time.UTC)
+		assert.NoError(t, err)
+		assert.True(t, s.Next(time.Now()).IsZero())
+	})
+}
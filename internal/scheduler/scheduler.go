@@ -0,0 +1,334 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"runtime/debug"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/mcgtrt/go-puerto/internal/metrics"
+	"github.com/mcgtrt/go-puerto/utils"
+)
+
+var ErrDuplicateTask = errors.New("task with the name already added")
+
+// Picks the instance running the tasks when the app runs in many replicas
+type Elector interface {
+	// Become or stay the leader for ttl. Returns false while another
+	// instance leads.
+	Campaign(ctx context.Context, ttl time.Duration) (bool, error)
+	// Step down so another instance takes over without waiting for ttl
+	Resign(ctx context.Context) error
+}
+
+// Always leads. Use it when the app runs in a single instance.
+type LocalElector struct{}
+
+func (LocalElector) Campaign(ctx context.Context, ttl time.Duration) (bool, error) { return true, nil }
+func (LocalElector) Resign(ctx context.Context) error                              { return nil }
+
+// Runs at the scheduled times. The context is cancelled when this
+// instance stops leading or the shutdown timeout runs out.
+type Task func(ctx context.Context) error
+
+// Options of added tasks
+type Option func(t *task)
+
+// Delay every run by a random time up to d, so tasks of many apps
+// scheduled at the same minute don't hit shared services together
+func Jitter(d time.Duration) Option {
+	return func(t *task) { t.jitter = d }
+}
+
+// Start runs while the previous run is still running. By default such
+// runs are skipped.
+func AllowOverlap() Option {
+	return func(t *task) { t.overlap = true }
+}
+
+// State of a task in this instance. Runs are recorded by the leader only,
+// other instances report the next run.
+type TaskStatus struct {
+	Name     string     `json:"name"`
+	Schedule string     `json:"schedule"`
+	Timezone string     `json:"timezone"`
+	Running  bool       `json:"running"`
+	LastRun  *time.Time `json:"last_run,omitempty"`
+	// Run time of the last finished run in milliseconds
+	LastDuration int64 `json:"last_duration_ms,omitempty"`
+	// Done or failed
+	LastResult string `json:"last_result,omitempty"`
+	// Kept out of JSON, the health endpoint is public
+	LastError string     `json:"-"`
+	NextRun   *time.Time `json:"next_run,omitempty"`
+	// Runs skipped as the previous run was still running
+	Skipped int `json:"skipped"`
+}
+
+type Status struct {
+	Leader bool         `json:"leader"`
+	Tasks  []TaskStatus `json:"tasks"`
+}
+
+type task struct {
+	name     string
+	schedule *Schedule
+	fn       Task
+	jitter   time.Duration
+	overlap  bool
+
+	// Guarded by the scheduler mutex
+	running int
+	status  TaskStatus
+}
+
+// Runs recurring tasks on cron schedules. Add tasks at startup, then Start
+// the scheduler and Shutdown it when the app stops. Only the leader picked
+// by the Elector runs the tasks, leadership is renewed every third of
+// LockTTL.
+type Scheduler struct {
+	Elector  Elector
+	LockTTL  time.Duration
+	Location *time.Location
+
+	mu     sync.Mutex
+	tasks  []*task
+	leader bool
+	// Cancelled when this instance stops leading
+	leaderCtx    context.Context
+	leaderCancel context.CancelFunc
+	stop         chan struct{}
+	loops        sync.WaitGroup
+	runs         sync.WaitGroup
+	started      bool
+	now          func() time.Time
+	after        func(d time.Duration) <-chan time.Time
+}
+
+func New(elector Elector, cfg *utils.SchedulerConfig) *Scheduler {
+	return &Scheduler{
+		Elector:  elector,
+		LockTTL:  cfg.LockTTL,
+		Location: cfg.Timezone,
+		stop:     make(chan struct{}),
+		now:      time.Now,
+		after:    time.After,
+	}
+}
+
+// Add the task running on the cron expression, e.g. "0 3 * * *" for every
+// day at 3am or "CRON_TZ=America/New_York */15 9-17 * * MON-FRI"
+func (s *Scheduler) Add(name, expr string, fn Task, opts ...Option) error {
+	schedule, err := Parse(expr, s.Location)
+	if err != nil {
+		return err
+	}
+	t := &task{name: name, schedule: schedule, fn: fn}
+	for _, opt := range opts {
+		opt(t)
+	}
+	t.status = TaskStatus{Name: name, Schedule: expr, Timezone: schedule.Location.String()}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.started {
+		return errors.New("tasks must be added before the scheduler starts")
+	}
+	if slices.ContainsFunc(s.tasks, func(t *task) bool { return t.name == name }) {
+		return fmt.Errorf("%w: %s", ErrDuplicateTask, name)
+	}
+	s.tasks = append(s.tasks, t)
+	return nil
+}
+
+// Start campaigning for leadership and scheduling the tasks
+func (s *Scheduler) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.started {
+		return
+	}
+	s.started = true
+	s.loops.Add(1 + len(s.tasks))
+	go s.campaign()
+	for _, t := range s.tasks {
+		go s.schedule(t)
+	}
+}
+
+// Stop scheduling and wait for the running tasks. Tasks still running
+// when the context is done are cancelled. Leadership is given up, so
+// another instance takes over right away.
+func (s *Scheduler) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	select {
+	case <-s.stop:
+	default:
+		close(s.stop)
+	}
+	s.mu.Unlock()
+	s.loops.Wait()
+
+	done := make(chan struct{})
+	go func() {
+		s.runs.Wait()
+		close(done)
+	}()
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	s.mu.Lock()
+	wasLeader := s.leader
+	s.setLeader(false)
+	s.mu.Unlock()
+	if wasLeader {
+		err = errors.Join(err, s.Elector.Resign(ctx))
+	}
+	return err
+}
+
+// Status of the tasks in the order they were added
+func (s *Scheduler) Status() Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := Status{Leader: s.leader, Tasks: make([]TaskStatus, len(s.tasks))}
+	for i, t := range s.tasks {
+		status.Tasks[i] = t.status
+		status.Tasks[i].Running = t.running > 0
+	}
+	return status
+}
+
+// Renew leadership until the scheduler stops. Errors of the elector count
+// as lost leadership, so two instances never lead knowingly at once.
+func (s *Scheduler) campaign() {
+	defer s.loops.Done()
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), s.LockTTL/3)
+		leader, err := s.Elector.Campaign(ctx, s.LockTTL)
+		cancel()
+		if err != nil {
+			slog.Warn("scheduler leader election failed", "error", err)
+		}
+		s.mu.Lock()
+		if leader != s.leader {
+			slog.Info("scheduler leadership changed", "leader", leader)
+		}
+		s.setLeader(leader)
+		s.mu.Unlock()
+
+		select {
+		case <-s.stop:
+			return
+		case <-s.after(s.LockTTL / 3):
+		}
+	}
+}
+
+// Must be called with the mutex held
+func (s *Scheduler) setLeader(leader bool) {
+	if leader && s.leaderCtx == nil {
+		s.leaderCtx, s.leaderCancel = context.WithCancel(context.Background())
+	}
+	if !leader && s.leaderCtx != nil {
+		s.leaderCancel()
+		s.leaderCtx, s.leaderCancel = nil, nil
+	}
+	s.leader = leader
+}
+
+func (s *Scheduler) schedule(t *task) {
+	defer s.loops.Done()
+	next := t.schedule.Next(s.now())
+	for !next.IsZero() {
+		nextRun := next
+		s.mu.Lock()
+		t.status.NextRun = &nextRun
+		s.mu.Unlock()
+
+		wait := next.Sub(s.now())
+		if t.jitter > 0 {
+			wait += rand.N(t.jitter)
+		}
+		select {
+		case <-s.stop:
+			return
+		case <-s.after(wait):
+		}
+		s.start(t, next)
+		// Scheduled from the planned time, so jitter doesn't shift it. Runs
+		// missed while the process was paused are not caught up.
+		next = t.schedule.Next(next)
+		if now := s.now(); next.Before(now) {
+			next = t.schedule.Next(now)
+		}
+	}
+	slog.Warn("scheduled task never runs again", "task", t.name, "schedule", t.schedule.Expr)
+	s.mu.Lock()
+	t.status.NextRun = nil
+	s.mu.Unlock()
+}
+
+func (s *Scheduler) start(t *task, scheduled time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.leader {
+		return
+	}
+	if t.running > 0 && !t.overlap {
+		t.status.Skipped++
+		metrics.SchedulerRuns.WithLabelValues(t.name, "skipped").Inc()
+		slog.Warn("scheduled task skipped, previous run still running", "task", t.name, "scheduled", scheduled)
+		return
+	}
+	t.running++
+	s.runs.Add(1)
+	go s.run(t, s.leaderCtx)
+}
+
+func (s *Scheduler) run(t *task, ctx context.Context) {
+	defer s.runs.Done()
+	started := s.now()
+	log := slog.With("task", t.name)
+	err := call(ctx, t.fn)
+	duration := s.now().Sub(started)
+
+	result := "done"
+	if err != nil {
+		result = "failed"
+		log.Error("scheduled task failed", "error", err, "duration", duration)
+	} else {
+		log.Info("scheduled task done", "duration", duration)
+	}
+	metrics.SchedulerRuns.WithLabelValues(t.name, result).Inc()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t.running--
+	t.status.LastRun = &started
+	t.status.LastDuration = duration.Milliseconds()
+	t.status.LastResult = result
+	t.status.LastError = ""
+	if err != nil {
+		t.status.LastError = err.Error()
+	}
+}
+
+// Run the task turning panics into errors, so one task can't stop the app
+func call(ctx context.Context, fn Task) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			slog.Error("scheduled task panicked", "panic", r, "stack", string(debug.Stack()))
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return fn(ctx)
+}
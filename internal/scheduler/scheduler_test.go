@@ -0,0 +1,173 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mcgtrt/go-puerto/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+type testElector struct {
+	leader   atomic.Bool
+	resigned atomic.Bool
+}
+
+func (e *testElector) Campaign(ctx context.Context, ttl time.Duration) (bool, error) {
+	return e.leader.Load(), nil
+}
+
+func (e *testElector) Resign(ctx context.Context) error {
+	e.resigned.Store(true)
+	return nil
+}
+
+// Scheduler whose clock stands still while the waits pass at once, so
+// every minute of the schedule comes right after the previous one
+func newTestScheduler(leader bool) (*Scheduler, *testElector) {
+	elector := &testElector{}
+	elector.leader.Store(leader)
+	s := New(elector, &utils.SchedulerConfig{LockTTL: 3 * time.Second, Timezone: time.UTC})
+	now := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+	s.after = func(time.Duration) <-chan time.Time { return time.After(time.Millisecond) }
+	return s, elector
+}
+
+func eventually(t *testing.T, condition func() bool) {
+	t.Helper()
+	assert.Eventually(t, condition, 2*time.Second, 5*time.Millisecond)
+}
+
+func TestScheduler(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Add", func(t *testing.T) {
+		s, _ := newTestScheduler(true)
+		assert.NoError(t, s.Add("cleanup", "@hourly", func(ctx context.Context) error { return nil }))
+		assert.ErrorIs(t, s.Add("cleanup", "@daily", func(ctx context.Context) error { return nil }), ErrDuplicateTask)
+		assert.ErrorIs(t, s.Add("report", "0 25 * * *", func(ctx context.Context) error { return nil }), ErrInvalidSchedule)
+		s.Start()
+		assert.Error(t, s.Add("late", "@daily", func(ctx context.Context) error { return nil }))
+		assert.NoError(t, s.Shutdown(ctx))
+	})
+
+	t.Run("Leader runs tasks", func(t *testing.T) {
+		s, elector := newTestScheduler(true)
+		var runs atomic.Int32
+		assert.NoError(t, s.Add("cleanup", "* * * * *", func(ctx context.Context) error {
+			if runs.Add(1) == 1 {
+				return errors.New("database unavailable")
+			}
+			return nil
+		}))
+		assert.NoError(t, s.Add("panics", "* * * * *", func(ctx context.Context) error { panic("boom") }))
+		s.Start()
+		eventually(t, func() bool {
+			status := s.Status()
+			return runs.Load() > 2 && status.Tasks[1].LastResult == "failed"
+		})
+		assert.NoError(t, s.Shutdown(ctx))
+		assert.True(t, elector.resigned.Load(), "Expected leadership given up")
+
+		status := s.Status()
+		assert.False(t, status.Leader)
+		assert.Equal(t, "cleanup", status.Tasks[0].Name)
+		assert.Equal(t, "UTC", status.Tasks[0].Timezone)
+		assert.Equal(t, "done", status.Tasks[0].LastResult)
+		assert.NotNil(t, status.Tasks[0].LastRun)
+		assert.NotNil(t, status.Tasks[0].NextRun)
+		assert.Equal(t, "panic: boom", status.Tasks[1].LastError)
+	})
+
+	t.Run("Followers don't run tasks", func(t *testing.T) {
+		s, elector := newTestScheduler(false)
+		var runs atomic.Int32
+		assert.NoError(t, s.Add("cleanup", "* * * * *", func(ctx context.Context) error {
+			runs.Add(1)
+			return nil
+		}))
+		s.Start()
+		eventually(t, func() bool { return s.Status().Tasks[0].NextRun != nil })
+		time.Sleep(20 * time.Millisecond)
+		assert.Zero(t, runs.Load())
+		assert.False(t, s.Status().Leader)
+		assert.NoError(t, s.Shutdown(ctx))
+		assert.False(t, elector.resigned.Load())
+	})
+
+	t.Run("Skips overlapping runs", func(t *testing.T) {
+		s, _ := newTestScheduler(true)
+		release := make(chan struct{})
+		var runs atomic.Int32
+		assert.NoError(t, s.Add("import", "* * * * *", func(ctx context.Context) error {
+			runs.Add(1)
+			<-release
+			return nil
+		}))
+		s.Start()
+		eventually(t, func() bool { return s.Status().Tasks[0].Skipped > 1 })
+		assert.True(t, s.Status().Tasks[0].Running)
+		assert.Equal(t, int32(1), runs.Load())
+		close(release)
+		assert.NoError(t, s.Shutdown(ctx))
+	})
+
+	t.Run("Allows overlapping runs", func(t *testing.T) {
+		s, _ := newTestScheduler(true)
+		release := make(chan struct{})
+		var runs atomic.Int32
+		assert.NoError(t, s.Add("ping", "* * * * *", func(ctx context.Context) error {
+			runs.Add(1)
+			<-release
+			return nil
+		}, AllowOverlap()))
+		s.Start()
+		eventually(t, func() bool { return runs.Load() > 1 })
+		close(release)
+		assert.NoError(t, s.Shutdown(ctx))
+	})
+
+	t.Run("Losing leadership cancels runs", func(t *testing.T) {
+		s, elector := newTestScheduler(true)
+		started := make(chan struct{}, 1)
+		cancelled := make(chan struct{})
+		assert.NoError(t, s.Add("import", "* * * * *", func(ctx context.Context) error {
+			select {
+			case started <- struct{}{}:
+			default:
+				return nil
+			}
+			<-ctx.Done()
+			close(cancelled)
+			return ctx.Err()
+		}))
+		s.Start()
+		<-started
+		elector.leader.Store(false)
+		select {
+		case <-cancelled:
+		case <-time.After(2 * time.Second):
+			t.Fatal("Expected the run cancelled")
+		}
+		assert.NoError(t, s.Shutdown(ctx))
+	})
+
+	t.Run("Shutdown timeout cancels runs", func(t *testing.T) {
+		s, _ := newTestScheduler(true)
+		started := make(chan struct{})
+		assert.NoError(t, s.Add("stuck", "* * * * *", func(ctx context.Context) error {
+			close(started)
+			<-ctx.Done()
+			return ctx.Err()
+		}))
+		s.Start()
+		<-started
+		timeout, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+		defer cancel()
+		assert.ErrorIs(t, s.Shutdown(timeout), context.DeadlineExceeded)
+	})
+}
@@ -0,0 +1,131 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/mcgtrt/go-puerto/utils"
+)
+
+// Unchanged sessions are saved at most once per this interval to extend
+// their idle timeout without writing to the store on every request
+const TOUCH_INTERVAL = time.Minute
+
+// Loads sessions from the cookie and commits them back to the store
+// and the response. Use SessionMiddleware to bind it to requests.
+type Manager struct {
+	Store      SessionStore
+	cookieName string
+	idle       time.Duration
+	absolute   time.Duration
+	secure     bool
+}
+
+// Create manager for the store with cookie name and timeouts from the
+// config. Secure cookies should be used everywhere except local development.
+func NewManager(store SessionStore, cfg *utils.SessionConfig, secure bool) *Manager {
+	return &Manager{
+		Store:      store,
+		cookieName: cfg.CookieName,
+		idle:       cfg.IdleTimeout,
+		absolute:   cfg.AbsoluteTimeout,
+		secure:     secure,
+	}
+}
+
+// Load the session of the request. Missing, invalid and expired sessions
+// are replaced with a new one, which is saved only once it gets modified.
+func (m *Manager) Load(r *http.Request) (*Session, error) {
+	now := time.Now()
+	cookie, err := r.Cookie(m.cookieName)
+	if err != nil {
+		return newSession(now), nil
+	}
+	s, err := m.Store.Load(r.Context(), cookie.Value)
+	if errors.Is(err, ErrNotFound) {
+		return newSession(now), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if m.expired(s, now) {
+		if err := m.Store.Delete(r.Context(), s.ID); err != nil {
+			return nil, err
+		}
+		return newSession(now), nil
+	}
+	return s, nil
+}
+
+func (m *Manager) expired(s *Session, now time.Time) bool {
+	return now.After(s.LastSeenAt.Add(m.idle)) || now.After(s.CreatedAt.Add(m.absolute))
+}
+
+// Save the session and set the cookie if the session was modified, renewed
+// or destroyed, or its idle timeout needs extending. Must be called before
+// the response headers are written.
+func (m *Manager) Commit(w http.ResponseWriter, r *http.Request, s *Session) error {
+	ctx := r.Context()
+	s.mu.Lock()
+	var (
+		now        = time.Now()
+		destroyed  = s.destroyed
+		isNew      = s.isNew
+		modified   = s.modified
+		id         = s.ID
+		previousID = s.previousID
+	)
+	s.mu.Unlock()
+
+	if destroyed {
+		for _, id := range []string{id, previousID} {
+			if id != "" && !isNew {
+				if err := m.Store.Delete(ctx, id); err != nil {
+					return err
+				}
+			}
+		}
+		m.setCookie(w, "", -1)
+		return nil
+	}
+	if !modified && (isNew || now.Sub(s.LastSeenAt) < TOUCH_INTERVAL) {
+		return nil
+	}
+	if previousID != "" {
+		if err := m.Store.Delete(ctx, previousID); err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	s.LastSeenAt = now
+	s.isNew, s.modified, s.previousID = false, false, ""
+	s.mu.Unlock()
+	ttl := min(m.idle, s.CreatedAt.Add(m.absolute).Sub(now))
+	token, err := m.Store.Save(ctx, s, ttl)
+	if err != nil {
+		return err
+	}
+	m.setCookie(w, token, int(ttl.Seconds()))
+	return nil
+}
+
+func (m *Manager) setCookie(w http.ResponseWriter, value string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     m.cookieName,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   m.secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// Revoke all sessions of the user, e.g. after password change or when
+// the account gets disabled
+func (m *Manager) RevokeUser(ctx context.Context, userID string) error {
+	return m.Store.DeleteByUser(ctx, userID)
+}
@@ -0,0 +1,158 @@
+package session
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/mcgtrt/go-puerto/types"
+)
+
+// Common flash message kinds
+const (
+	FLASH_SUCCESS = "success"
+	FLASH_INFO    = "info"
+	FLASH_WARNING = "warning"
+	FLASH_ERROR   = "error"
+)
+
+// One-time message shown on the next rendered page
+type Flash struct {
+	Kind    string `json:"kind"`
+	Message string `json:"message"`
+}
+
+// User session. Values are kept JSON encoded so every store can persist
+// them and they're read back with Get into the original type.
+type Session struct {
+	ID         string                     `json:"id"`
+	UserID     string                     `json:"user_id,omitempty"`
+	Values     map[string]json.RawMessage `json:"values,omitempty"`
+	Flashes    []Flash                    `json:"flashes,omitempty"`
+	CreatedAt  time.Time                  `json:"created_at"`
+	LastSeenAt time.Time                  `json:"last_seen_at"`
+
+	mu         sync.Mutex
+	isNew      bool
+	modified   bool
+	destroyed  bool
+	previousID string
+}
+
+func newSession(now time.Time) *Session {
+	return &Session{
+		ID:         newID(),
+		CreatedAt:  now,
+		LastSeenAt: now,
+		isNew:      true,
+	}
+}
+
+// Session IDs are random and carry no information (unlike UUIDv7)
+func newID() string {
+	id := make([]byte, 32)
+	rand.Read(id)
+	return base64.RawURLEncoding.EncodeToString(id)
+}
+
+// Set the value of the key. The value must be JSON serialisable.
+func (s *Session) Set(key string, value any) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Values == nil {
+		s.Values = make(map[string]json.RawMessage)
+	}
+	s.Values[key] = data
+	s.modified = true
+	return nil
+}
+
+// Remove the value of the key
+func (s *Session) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.Values[key]; ok {
+		delete(s.Values, key)
+		s.modified = true
+	}
+}
+
+// Get the value of the key decoded into T. Returns false if the key is
+// missing or its value can't be decoded into T.
+func Get[T any](s *Session, key string) (T, bool) {
+	var value T
+	s.mu.Lock()
+	data, ok := s.Values[key]
+	s.mu.Unlock()
+	if !ok || json.Unmarshal(data, &value) != nil {
+		return value, false
+	}
+	return value, true
+}
+
+// Add flash message to be shown on the next page
+func (s *Session) AddFlash(kind, message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Flashes = append(s.Flashes, Flash{Kind: kind, Message: message})
+	s.modified = true
+}
+
+// Return and remove all pending flash messages
+func (s *Session) PopFlashes() []Flash {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	flashes := s.Flashes
+	if len(flashes) > 0 {
+		s.Flashes = nil
+		s.modified = true
+	}
+	return flashes
+}
+
+// Bind the session to the user (on login) or unbind it (empty user ID).
+// Changing privileges always rotates the session ID to prevent fixation.
+func (s *Session) SetUser(userID string) {
+	s.mu.Lock()
+	s.UserID = userID
+	s.mu.Unlock()
+	s.Renew()
+}
+
+// Rotate the session ID keeping its data. The old ID is removed from the
+// store when the session is saved.
+func (s *Session) Renew() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.previousID == "" && !s.isNew {
+		s.previousID = s.ID
+	}
+	s.ID = newID()
+	s.modified = true
+}
+
+// Remove the session (e.g. on logout). A new session is started
+// on the next request.
+func (s *Session) Destroy() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.destroyed = true
+}
+
+// Return the session of the request
+func FromContext(ctx context.Context) *Session {
+	s, _ := ctx.Value(types.SessionCtxKey{}).(*Session)
+	return s
+}
+
+// Store the session in the context
+func WithSession(ctx context.Context, s *Session) context.Context {
+	return context.WithValue(ctx, types.SessionCtxKey{}, s)
+}
@@ -0,0 +1,78 @@
+package session
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSession(t *testing.T) {
+	type cart struct {
+		Items []string `json:"items"`
+		Total int      `json:"total"`
+	}
+
+	s := newSession(time.Now())
+	assert.True(t, s.isNew)
+	assert.False(t, s.modified)
+
+	t.Run("Typed get and set", func(t *testing.T) {
+		assert.NoError(t, s.Set("cart", cart{Items: []string{"apple"}, Total: 3}))
+		assert.NoError(t, s.Set("visits", 2))
+		assert.True(t, s.modified)
+
+		c, ok := Get[cart](s, "cart")
+		assert.True(t, ok)
+		assert.Equal(t, cart{Items: []string{"apple"}, Total: 3}, c)
+
+		visits, ok := Get[int](s, "visits")
+		assert.True(t, ok)
+		assert.Equal(t, 2, visits)
+
+		_, ok = Get[int](s, "cart")
+		assert.False(t, ok, "Expected mismatched type to fail")
+		_, ok = Get[string](s, "missing")
+		assert.False(t, ok)
+
+		s.Delete("visits")
+		_, ok = Get[int](s, "visits")
+		assert.False(t, ok)
+	})
+
+	t.Run("Survives encoding", func(t *testing.T) {
+		data, err := Encode(s)
+		assert.NoError(t, err)
+		decoded, err := Decode(data)
+		assert.NoError(t, err)
+
+		c, ok := Get[cart](decoded, "cart")
+		assert.True(t, ok)
+		assert.Equal(t, 3, c.Total)
+		assert.Equal(t, s.ID, decoded.ID)
+	})
+
+	t.Run("Flash messages are shown once", func(t *testing.T) {
+		s.AddFlash(FLASH_SUCCESS, "Saved")
+		assert.Equal(t, []Flash{{Kind: FLASH_SUCCESS, Message: "Saved"}}, s.PopFlashes())
+		assert.Empty(t, s.PopFlashes())
+	})
+
+	t.Run("Changing user rotates ID", func(t *testing.T) {
+		s.isNew = false
+		id := s.ID
+		s.SetUser("user-1")
+		assert.Equal(t, "user-1", s.UserID)
+		assert.NotEqual(t, id, s.ID)
+		assert.Equal(t, id, s.previousID, "Expected old ID to be removed on save")
+
+		s.Renew()
+		assert.Equal(t, id, s.previousID, "Expected the stored ID kept across renewals")
+	})
+
+	t.Run("Context", func(t *testing.T) {
+		assert.Nil(t, FromContext(context.Background()))
+		assert.Equal(t, s, FromContext(WithSession(context.Background(), s)))
+	})
+}
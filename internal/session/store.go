@@ -0,0 +1,147 @@
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/mcgtrt/go-puerto/utils"
+)
+
+var (
+	ErrNotFound              = errors.New("session not found")
+	ErrRevocationUnsupported = errors.New("session store can't revoke sessions")
+	ErrCookieTooLarge        = errors.New("session is too large to be stored in a cookie")
+)
+
+// Persistence of sessions. The token is the value of the session cookie:
+// the session ID for server-side stores or the whole encrypted session
+// for the cookie store.
+type SessionStore interface {
+	// Load the session referenced by the cookie token. Returns ErrNotFound
+	// if the session doesn't exist or has expired.
+	Load(ctx context.Context, token string) (*Session, error)
+	// Save the session for the ttl and return the cookie token
+	Save(ctx context.Context, s *Session, ttl time.Duration) (token string, err error)
+	// Delete the session by its ID
+	Delete(ctx context.Context, id string) error
+	// Delete all sessions of the user (e.g. after password change)
+	DeleteByUser(ctx context.Context, userID string) error
+}
+
+// Browsers reject cookies bigger than 4KB
+const maxCookieSize = 4000
+
+// Keeps the whole session in the cookie sealed with AES-GCM, so it needs no
+// database. Sessions can't be revoked before they expire - use one of the
+// server-side stores if revocation is required.
+type CookieStore struct{}
+
+func NewCookieStore() *CookieStore {
+	return &CookieStore{}
+}
+
+func (CookieStore) Load(ctx context.Context, token string) (*Session, error) {
+	data, err := utils.OpenAES(token)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	return Decode([]byte(data))
+}
+
+func (CookieStore) Save(ctx context.Context, s *Session, ttl time.Duration) (string, error) {
+	data, err := Encode(s)
+	if err != nil {
+		return "", err
+	}
+	token, err := utils.SealAES(string(data))
+	if err != nil {
+		return "", err
+	}
+	if len(token) > maxCookieSize {
+		return "", ErrCookieTooLarge
+	}
+	return token, nil
+}
+
+func (CookieStore) Delete(ctx context.Context, id string) error {
+	return nil
+}
+
+func (CookieStore) DeleteByUser(ctx context.Context, userID string) error {
+	return ErrRevocationUnsupported
+}
+
+// Keeps sessions in the process memory. Useful for tests and local
+// development with a single instance.
+type MemoryStore struct {
+	mu       sync.Mutex
+	sessions map[string]memorySession
+}
+
+type memorySession struct {
+	data      []byte
+	userID    string
+	expiresAt time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{sessions: make(map[string]memorySession)}
+}
+
+func (m *MemoryStore) Load(ctx context.Context, token string) (*Session, error) {
+	m.mu.Lock()
+	stored, ok := m.sessions[token]
+	m.mu.Unlock()
+	if !ok || time.Now().After(stored.expiresAt) {
+		return nil, ErrNotFound
+	}
+	return Decode(stored.data)
+}
+
+func (m *MemoryStore) Save(ctx context.Context, s *Session, ttl time.Duration) (string, error) {
+	data, err := Encode(s)
+	if err != nil {
+		return "", err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sessions[s.ID] = memorySession{data: data, userID: s.UserID, expiresAt: time.Now().Add(ttl)}
+	return s.ID, nil
+}
+
+func (m *MemoryStore) Delete(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.sessions, id)
+	return nil
+}
+
+func (m *MemoryStore) DeleteByUser(ctx context.Context, userID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, stored := range m.sessions {
+		if stored.userID == userID {
+			delete(m.sessions, id)
+		}
+	}
+	return nil
+}
+
+// Serialise the session for storing
+func Encode(s *Session) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return json.Marshal(s)
+}
+
+// Deserialise stored session
+func Decode(data []byte) (*Session, error) {
+	s := &Session{}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
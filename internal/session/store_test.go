@@ -0,0 +1,71 @@
+package session
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCookieStore(t *testing.T) {
+	require.NoError(t, os.Setenv("AES_SECRET", "thisis16byteskey"))
+	defer os.Unsetenv("AES_SECRET")
+
+	ctx := context.Background()
+	store := NewCookieStore()
+	s := newSession(time.Now())
+	s.UserID = "user-1"
+	assert.NoError(t, s.Set("theme", "dark"))
+
+	token, err := store.Save(ctx, s, time.Hour)
+	assert.NoError(t, err)
+	assert.NotContains(t, token, "dark", "Expected encrypted session")
+
+	loaded, err := store.Load(ctx, token)
+	assert.NoError(t, err)
+	assert.Equal(t, "user-1", loaded.UserID)
+	theme, _ := Get[string](loaded, "theme")
+	assert.Equal(t, "dark", theme)
+
+	_, err = store.Load(ctx, token[:len(token)-2]+"AA")
+	assert.ErrorIs(t, err, ErrNotFound, "Expected tampered cookie rejected")
+
+	assert.NoError(t, s.Set("big", strings.Repeat("x", maxCookieSize)))
+	_, err = store.Save(ctx, s, time.Hour)
+	assert.ErrorIs(t, err, ErrCookieTooLarge)
+
+	assert.ErrorIs(t, store.DeleteByUser(ctx, "user-1"), ErrRevocationUnsupported)
+}
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+
+	first, second, other := newSession(time.Now()), newSession(time.Now()), newSession(time.Now())
+	first.UserID, second.UserID, other.UserID = "user-1", "user-1", "user-2"
+	for _, s := range []*Session{first, second, other} {
+		_, err := store.Save(ctx, s, time.Hour)
+		assert.NoError(t, err)
+	}
+
+	loaded, err := store.Load(ctx, first.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, first.ID, loaded.ID)
+
+	assert.NoError(t, store.DeleteByUser(ctx, "user-1"))
+	_, err = store.Load(ctx, first.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = store.Load(ctx, second.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = store.Load(ctx, other.ID)
+	assert.NoError(t, err, "Expected sessions of other users kept")
+
+	_, err = store.Save(ctx, other, -time.Second)
+	assert.NoError(t, err)
+	_, err = store.Load(ctx, other.ID)
+	assert.ErrorIs(t, err, ErrNotFound, "Expected expired session missing")
+}
@@ -0,0 +1,202 @@
+package sse
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/a-h/templ"
+	"github.com/mcgtrt/go-puerto/internal/metrics"
+	"github.com/mcgtrt/go-puerto/utils"
+)
+
+// Events buffered for each subscriber before it's considered too slow
+const SUBSCRIBER_BUFFER = 64
+
+// Delay before subscribing to the transport again after it failed
+var RESUBSCRIBE_DELAY = time.Second
+
+// Carries published events to the brokers of all instances, including
+// the publishing one, in the same order
+type Transport interface {
+	Publish(ctx context.Context, payload []byte) error
+	// Deliver published payloads until the context is done
+	Subscribe(ctx context.Context, fn func(payload []byte)) error
+}
+
+type message struct {
+	Topic string `json:"topic"`
+	Event Event  `json:"event"`
+}
+
+// Fans events published to topics out to the subscribed streams. Without
+// a Transport events reach the streams of this instance only. The last
+// ReplaySize events are kept, so reconnecting clients get what they
+// missed.
+type Broker struct {
+	Transport  Transport
+	ReplaySize int
+	Heartbeat  time.Duration
+
+	mu     sync.Mutex
+	subs   map[*Subscription]struct{}
+	replay []message
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func NewBroker(transport Transport, cfg *utils.SSEConfig) *Broker {
+	return &Broker{
+		Transport:  transport,
+		ReplaySize: cfg.ReplaySize,
+		Heartbeat:  cfg.Heartbeat,
+		subs:       make(map[*Subscription]struct{}),
+	}
+}
+
+// Start receiving events published by all instances
+func (b *Broker) Start() {
+	if b.Transport == nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	b.cancel, b.done = cancel, make(chan struct{})
+	go func() {
+		defer close(b.done)
+		for {
+			err := b.Transport.Subscribe(ctx, b.receive)
+			if ctx.Err() != nil {
+				return
+			}
+			slog.Warn("sse transport subscription failed", "error", err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(RESUBSCRIBE_DELAY):
+			}
+		}
+	}()
+}
+
+// Stop receiving events and disconnect the subscribers
+func (b *Broker) Close() {
+	if b.cancel != nil {
+		b.cancel()
+		<-b.done
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for sub := range b.subs {
+		b.unsubscribe(sub)
+	}
+}
+
+// Send the event to the subscribers of the topic. Events without an ID
+// get one, so clients can resume after them.
+func (b *Broker) Publish(ctx context.Context, topic string, e Event) error {
+	if e.ID == "" {
+		e.ID = utils.NewULID()
+	}
+	m := message{Topic: topic, Event: e}
+	if b.Transport == nil {
+		b.deliver(m)
+		return nil
+	}
+	payload, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return b.Transport.Publish(ctx, payload)
+}
+
+// Render the templ component once and send it to the subscribers of the
+// topic as the named event
+func (b *Broker) PublishFragment(ctx context.Context, topic, name string, component templ.Component) error {
+	e, err := Fragment(ctx, name, component)
+	if err != nil {
+		return err
+	}
+	return b.Publish(ctx, topic, e)
+}
+
+func (b *Broker) receive(payload []byte) {
+	var m message
+	if err := json.Unmarshal(payload, &m); err != nil {
+		slog.Warn("invalid sse message received", "error", err)
+		return
+	}
+	b.deliver(m)
+}
+
+func (b *Broker) deliver(m message) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.ReplaySize > 0 {
+		if len(b.replay) == b.ReplaySize {
+			b.replay = slices.Delete(b.replay, 0, 1)
+		}
+		b.replay = append(b.replay, m)
+	}
+	for sub := range b.subs {
+		if !slices.Contains(sub.topics, m.Topic) {
+			continue
+		}
+		select {
+		case sub.events <- m.Event:
+		default:
+			slog.Warn("sse subscriber too slow, disconnecting", "topic", m.Topic)
+			metrics.SSEDropped.Inc()
+			b.unsubscribe(sub)
+		}
+	}
+}
+
+// Subscription of a stream to topics
+type Subscription struct {
+	// Closed when the subscriber falls behind or the broker closes
+	Events <-chan Event
+	// Events of the topics published after the last event the client
+	// received, all kept events when the ID is no longer kept
+	Replay []Event
+
+	broker *Broker
+	topics []string
+	events chan Event
+}
+
+// Subscribe to events of the topics published from now on. Events kept
+// since lastEventID are returned in Replay, empty ID replays nothing.
+func (b *Broker) Subscribe(lastEventID string, topics ...string) *Subscription {
+	events := make(chan Event, SUBSCRIBER_BUFFER)
+	sub := &Subscription{Events: events, broker: b, topics: topics, events: events}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if lastEventID != "" {
+		start := slices.IndexFunc(b.replay, func(m message) bool { return m.Event.ID == lastEventID }) + 1
+		for _, m := range b.replay[start:] {
+			if slices.Contains(topics, m.Topic) {
+				sub.Replay = append(sub.Replay, m.Event)
+			}
+		}
+	}
+	b.subs[sub] = struct{}{}
+	return sub
+}
+
+func (s *Subscription) Close() {
+	s.broker.mu.Lock()
+	defer s.broker.mu.Unlock()
+	s.broker.unsubscribe(s)
+}
+
+// Must be called with the mutex held
+func (b *Broker) unsubscribe(sub *Subscription) {
+	if _, ok := b.subs[sub]; ok {
+		delete(b.subs, sub)
+		close(sub.events)
+	}
+}
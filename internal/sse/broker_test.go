@@ -0,0 +1,121 @@
+package sse
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mcgtrt/go-puerto/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+// Transport shared by brokers of many instances in one process
+type testTransport struct {
+	mu   sync.Mutex
+	subs []func([]byte)
+}
+
+func (t *testTransport) Publish(ctx context.Context, payload []byte) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, fn := range t.subs {
+		fn(payload)
+	}
+	return nil
+}
+
+func (t *testTransport) Subscribe(ctx context.Context, fn func([]byte)) error {
+	t.mu.Lock()
+	t.subs = append(t.subs, fn)
+	t.mu.Unlock()
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestBroker(t *testing.T) {
+	ctx := context.Background()
+	cfg := &utils.SSEConfig{ReplaySize: 3, Heartbeat: time.Second}
+
+	t.Run("Fans out to topic subscribers", func(t *testing.T) {
+		b := NewBroker(nil, cfg)
+		orders := b.Subscribe("", "orders")
+		both := b.Subscribe("", "orders", "users")
+		defer orders.Close()
+		defer both.Close()
+
+		assert.NoError(t, b.Publish(ctx, "users", Event{Data: "user"}))
+		assert.NoError(t, b.Publish(ctx, "orders", Event{Data: "order"}))
+		assert.Equal(t, "order", (<-orders.Events).Data)
+		assert.Equal(t, "user", (<-both.Events).Data)
+		e := <-both.Events
+		assert.Equal(t, "order", e.Data)
+		assert.NotEmpty(t, e.ID, "Expected IDs assigned")
+	})
+
+	t.Run("Replays missed events", func(t *testing.T) {
+		b := NewBroker(nil, cfg)
+		for _, id := range []string{"1", "2", "3", "4"} {
+			topic := "orders"
+			if id == "3" {
+				topic = "users"
+			}
+			assert.NoError(t, b.Publish(ctx, topic, Event{ID: id}))
+		}
+		ids := func(sub *Subscription) []string {
+			defer sub.Close()
+			var ids []string
+			for _, e := range sub.Replay {
+				ids = append(ids, e.ID)
+			}
+			return ids
+		}
+		assert.Nil(t, ids(b.Subscribe("", "orders")), "Expected new clients get no replay")
+		assert.Equal(t, []string{"4"}, ids(b.Subscribe("2", "orders")))
+		assert.Equal(t, []string{"3", "4"}, ids(b.Subscribe("2", "orders", "users")))
+		assert.Equal(t, []string{"2", "4"}, ids(b.Subscribe("1", "orders")), "Expected everything kept when the ID is gone")
+	})
+
+	t.Run("Disconnects slow subscribers", func(t *testing.T) {
+		b := NewBroker(nil, cfg)
+		slow := b.Subscribe("", "orders")
+		for range SUBSCRIBER_BUFFER + 1 {
+			assert.NoError(t, b.Publish(ctx, "orders", Event{Data: "x"}))
+		}
+		received := 0
+		for range slow.Events {
+			received++
+		}
+		assert.Equal(t, SUBSCRIBER_BUFFER, received)
+		slow.Close()
+	})
+
+	t.Run("Delivers across instances", func(t *testing.T) {
+		transport := &testTransport{}
+		first, second := NewBroker(transport, cfg), NewBroker(transport, cfg)
+		first.Start()
+		second.Start()
+		defer first.Close()
+		defer second.Close()
+		assert.Eventually(t, func() bool {
+			transport.mu.Lock()
+			defer transport.mu.Unlock()
+			return len(transport.subs) == 2
+		}, time.Second, time.Millisecond)
+
+		sub := second.Subscribe("", "orders")
+		assert.NoError(t, first.Publish(ctx, "orders", Event{Name: "order", Data: "<p>paid</p>"}))
+		e := <-sub.Events
+		assert.Equal(t, "order", e.Name)
+		assert.Equal(t, "<p>paid</p>", e.Data)
+
+		// Published events are replayed by every instance
+		replay := first.Subscribe("0", "orders")
+		assert.Equal(t, []Event{e}, replay.Replay)
+		replay.Close()
+
+		second.Close()
+		_, open := <-sub.Events
+		assert.False(t, open, "Expected subscribers disconnected on close")
+	})
+}
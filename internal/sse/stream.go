@@ -0,0 +1,188 @@
+package sse
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/a-h/templ"
+	"github.com/mcgtrt/go-puerto/internal/metrics"
+)
+
+var ErrStreamingUnsupported = errors.New("response writer doesn't support streaming")
+
+// Message of the stream. Name is the event type HTMX swaps on with
+// sse-swap, messages without it are "message" events. Data can span many
+// lines. ID is sent back in Last-Event-ID when the browser reconnects.
+type Event struct {
+	ID   string `json:"id,omitempty"`
+	Name string `json:"name,omitempty"`
+	Data string `json:"data"`
+	// Reconnection delay asked from the browser, zero keeps its default
+	Retry time.Duration `json:"retry,omitempty"`
+}
+
+// Event with the rendered templ component as data, e.g. the fragment
+// replacing an element on the page
+func Fragment(ctx context.Context, name string, component templ.Component) (Event, error) {
+	var buf bytes.Buffer
+	if err := component.Render(ctx, &buf); err != nil {
+		return Event{}, err
+	}
+	return Event{Name: name, Data: buf.String()}, nil
+}
+
+// Event stream response. Sends are safe from many goroutines.
+type Stream struct {
+	w           http.ResponseWriter
+	rc          *http.ResponseController
+	ctx         context.Context
+	lastEventID string
+
+	mu sync.Mutex
+}
+
+// Start the event stream response. Clients resuming a stream send the
+// last received event ID in the Last-Event-ID header or, when opening
+// a new EventSource, in the lastEventId query parameter.
+func NewStream(w http.ResponseWriter, r *http.Request) (*Stream, error) {
+	if !canFlush(w) {
+		return nil, ErrStreamingUnsupported
+	}
+	s := &Stream{
+		w:           w,
+		rc:          http.NewResponseController(w),
+		ctx:         r.Context(),
+		lastEventID: r.Header.Get("Last-Event-ID"),
+	}
+	if s.lastEventID == "" {
+		s.lastEventID = r.URL.Query().Get("lastEventId")
+	}
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	// Stop nginx buffering the stream
+	h.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	return s, s.rc.Flush()
+}
+
+// Checked before writing anything, so the error can still be responded
+func canFlush(w http.ResponseWriter) bool {
+	for {
+		if _, ok := w.(http.Flusher); ok {
+			return true
+		}
+		u, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			return false
+		}
+		w = u.Unwrap()
+	}
+}
+
+// ID of the last event the client received before reconnecting
+func (s *Stream) LastEventID() string {
+	return s.lastEventID
+}
+
+// Closed when the client disconnects
+func (s *Stream) Done() <-chan struct{} {
+	return s.ctx.Done()
+}
+
+func (s *Stream) Send(e Event) error {
+	var b strings.Builder
+	if e.ID != "" {
+		b.WriteString("id: " + oneLine(e.ID) + "\n")
+	}
+	if e.Name != "" {
+		b.WriteString("event: " + oneLine(e.Name) + "\n")
+	}
+	if e.Retry > 0 {
+		b.WriteString("retry: " + strconv.FormatInt(e.Retry.Milliseconds(), 10) + "\n")
+	}
+	data := strings.ReplaceAll(e.Data, "\r\n", "\n")
+	for _, line := range strings.Split(data, "\n") {
+		b.WriteString("data: " + line + "\n")
+	}
+	b.WriteString("\n")
+	return s.write(b.String())
+}
+
+// Send the rendered templ component as the named event
+func (s *Stream) Render(name string, component templ.Component) error {
+	e, err := Fragment(s.ctx, name, component)
+	if err != nil {
+		return err
+	}
+	return s.Send(e)
+}
+
+// Send a comment ignored by browsers. It keeps proxies from closing idle
+// connections and fails once the client is gone.
+func (s *Stream) Heartbeat() error {
+	return s.write(": ping\n\n")
+}
+
+func (s *Stream) write(chunk string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.w.Write([]byte(chunk)); err != nil {
+		return err
+	}
+	return s.rc.Flush()
+}
+
+// Send events published to the topics until the client disconnects.
+// Events missed since Last-Event-ID are sent first. Clients too slow to
+// keep up are disconnected and catch up after reconnecting.
+func (s *Stream) Listen(b *Broker, topics ...string) error {
+	sub := b.Subscribe(s.lastEventID, topics...)
+	defer sub.Close()
+	metrics.SSEClients.Inc()
+	defer metrics.SSEClients.Dec()
+
+	for _, e := range sub.Replay {
+		if err := s.Send(e); err != nil {
+			return s.closed(err)
+		}
+	}
+	heartbeat := time.NewTicker(b.Heartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-s.Done():
+			return nil
+		case e, ok := <-sub.Events:
+			if !ok {
+				return nil
+			}
+			if err := s.Send(e); err != nil {
+				return s.closed(err)
+			}
+		case <-heartbeat.C:
+			if err := s.Heartbeat(); err != nil {
+				return s.closed(err)
+			}
+		}
+	}
+}
+
+// Writes fail when the client disconnects, which ends the stream normally
+func (s *Stream) closed(err error) error {
+	if s.ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
+
+// Field values can't span lines
+func oneLine(v string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(v)
+}
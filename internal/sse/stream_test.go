@@ -0,0 +1,87 @@
+package sse
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/a-h/templ"
+	"github.com/mcgtrt/go-puerto/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStreamSend(t *testing.T) {
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/events?lastEventId=41", nil)
+	s, err := NewStream(rec, req)
+	assert.NoError(t, err)
+	assert.Equal(t, "41", s.LastEventID())
+	assert.Equal(t, "text/event-stream", rec.Header().Get("Content-Type"))
+
+	assert.NoError(t, s.Send(Event{ID: "42", Name: "order\nupdated", Data: "<p>\r\nShipped</p>", Retry: 5 * time.Second}))
+	assert.NoError(t, s.Send(Event{Data: "plain"}))
+	assert.NoError(t, s.Heartbeat())
+	assert.NoError(t, s.Render("badge", templ.Raw(`<span id="count">3</span>`)))
+	assert.Equal(t, "id: 42\nevent: orderupdated\nretry: 5000\ndata: <p>\ndata: Shipped</p>\n\n"+
+		"data: plain\n\n"+
+		": ping\n\n"+
+		"event: badge\ndata: <span id=\"count\">3</span>\n\n", rec.Body.String())
+
+	req = httptest.NewRequest(http.MethodGet, "/events", nil)
+	req.Header.Set("Last-Event-ID", "43")
+	s, err = NewStream(httptest.NewRecorder(), req)
+	assert.NoError(t, err)
+	assert.Equal(t, "43", s.LastEventID())
+}
+
+func TestStreamListen(t *testing.T) {
+	broker := NewBroker(nil, &utils.SSEConfig{ReplaySize: 10, Heartbeat: 20 * time.Millisecond})
+	ctx := context.Background()
+	assert.NoError(t, broker.Publish(ctx, "orders", Event{ID: "1", Name: "order", Data: "first"}))
+	assert.NoError(t, broker.Publish(ctx, "orders", Event{ID: "2", Name: "order", Data: "second"}))
+
+	done := make(chan error, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s, err := NewStream(w, r)
+		if err != nil {
+			done <- err
+			return
+		}
+		done <- s.Listen(broker, "orders")
+	}))
+	defer server.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	req.Header.Set("Last-Event-ID", "1")
+	res, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	lines := bufio.NewScanner(res.Body)
+	next := func() string {
+		var event []string
+		for lines.Scan() && lines.Text() != "" {
+			event = append(event, lines.Text())
+		}
+		return strings.Join(event, "|")
+	}
+
+	assert.Equal(t, "id: 2|event: order|data: second", next(), "Expected missed events replayed")
+	assert.NoError(t, broker.PublishFragment(ctx, "orders", "order", templ.Raw("third")))
+	assert.NoError(t, broker.Publish(ctx, "users", Event{Data: "other topic"}))
+	assert.Regexp(t, `^id: \w{26}\|event: order\|data: third$`, next())
+	assert.Equal(t, ": ping", next(), "Expected heartbeats while idle")
+
+	res.Body.Close()
+	select {
+	case err := <-done:
+		assert.NoError(t, err, "Expected disconnects to end the stream")
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the stream to end after the client disconnected")
+	}
+	broker.mu.Lock()
+	assert.Empty(t, broker.subs, "Expected the subscription closed")
+	broker.mu.Unlock()
+}
@@ -0,0 +1,100 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+
+	"github.com/mcgtrt/go-puerto/utils"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Instrumentation name of every span created by this project
+const TRACER_NAME = "github.com/mcgtrt/go-puerto"
+
+// Returns the project tracer from the global tracer provider. Until Setup
+// is called the global provider is a no-op, so tracing code is always safe
+// to call even with tracing disabled.
+func Tracer() trace.Tracer {
+	return otel.Tracer(TRACER_NAME)
+}
+
+// Install the global tracer provider and W3C trace context propagator based
+// on the configuration. Call the returned provider's Shutdown on exit to
+// flush remaining spans.
+func Setup(cfg *utils.TracingConfig) (*sdktrace.TracerProvider, error) {
+	exporter, err := newExporter(cfg)
+	if err != nil {
+		return nil, err
+	}
+	res := resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(cfg.ServiceName))
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+	install(tp)
+	return tp, nil
+}
+
+// Install the global tracer provider recording every span synchronously into
+// the returned in-memory exporter. Use it in tests to assert on spans.
+func SetupInMemory() (*sdktrace.TracerProvider, *tracetest.InMemoryExporter) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithSyncer(exporter),
+		sdktrace.WithSampler(sdktrace.AlwaysSample()),
+	)
+	install(tp)
+	return tp, exporter
+}
+
+func install(tp trace.TracerProvider) {
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+}
+
+func newExporter(cfg *utils.TracingConfig) (sdktrace.SpanExporter, error) {
+	switch cfg.Exporter {
+	case utils.TRACING_EXPORTER_OTLP:
+		return otlptracehttp.New(context.Background(),
+			otlptracehttp.WithEndpoint(cfg.Endpoint),
+			otlptracehttp.WithInsecure(),
+		)
+	case utils.TRACING_EXPORTER_STDOUT:
+		return stdouttrace.New(stdouttrace.WithPrettyPrint())
+	case utils.TRACING_EXPORTER_MEMORY:
+		return tracetest.NewInMemoryExporter(), nil
+	}
+	return nil, errors.New("unsupported tracing exporter: " + cfg.Exporter)
+}
+
+// Returns the trace ID of the span stored in the context or an empty
+// string when there is no valid span (e.g. tracing is disabled)
+func TraceID(ctx context.Context) string {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.HasTraceID() {
+		return ""
+	}
+	return sc.TraceID().String()
+}
+
+// Mark the span as failed with the given error. Nil errors are ignored
+func RecordError(span trace.Span, err error) {
+	if err == nil {
+		return
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
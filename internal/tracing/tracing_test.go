@@ -0,0 +1,85 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/mcgtrt/go-puerto/utils"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+func TestSetup(t *testing.T) {
+	t.Run("Installs provider with service name and sampling", func(t *testing.T) {
+		tp, err := Setup(&utils.TracingConfig{ServiceName: "shop", Exporter: utils.TRACING_EXPORTER_MEMORY, SampleRatio: 1})
+		assert.NoError(t, err)
+		t.Cleanup(func() { tp.Shutdown(context.Background()) })
+		exporter := tracetest.NewInMemoryExporter()
+		tp.RegisterSpanProcessor(sdktrace.NewSimpleSpanProcessor(exporter))
+
+		assert.Equal(t, tp, otel.GetTracerProvider(), "Expected global provider installed")
+		assert.ElementsMatch(t, []string{"traceparent", "tracestate", "baggage"}, otel.GetTextMapPropagator().Fields())
+
+		_, span := Tracer().Start(context.Background(), "checkout")
+		span.End()
+
+		spans := exporter.GetSpans()
+		if assert.Len(t, spans, 1) {
+			assert.Equal(t, "checkout", spans[0].Name)
+			assert.Equal(t, TRACER_NAME, spans[0].InstrumentationScope.Name)
+			assert.Contains(t, spans[0].Resource.Attributes(), semconv.ServiceName("shop"))
+		}
+	})
+
+	t.Run("Drops spans with zero sample ratio", func(t *testing.T) {
+		tp, err := Setup(&utils.TracingConfig{ServiceName: "shop", Exporter: utils.TRACING_EXPORTER_MEMORY, SampleRatio: 0})
+		assert.NoError(t, err)
+		t.Cleanup(func() { tp.Shutdown(context.Background()) })
+		exporter := tracetest.NewInMemoryExporter()
+		tp.RegisterSpanProcessor(sdktrace.NewSimpleSpanProcessor(exporter))
+
+		ctx, span := Tracer().Start(context.Background(), "checkout")
+		span.End()
+
+		assert.Empty(t, exporter.GetSpans())
+		assert.NotEmpty(t, TraceID(ctx), "Expected trace ID of unsampled spans kept for propagation")
+	})
+
+	t.Run("Creates configured exporters", func(t *testing.T) {
+		for _, exporter := range []string{utils.TRACING_EXPORTER_OTLP, utils.TRACING_EXPORTER_STDOUT} {
+			tp, err := Setup(&utils.TracingConfig{ServiceName: "shop", Exporter: exporter, Endpoint: "localhost:4318", SampleRatio: 1})
+			assert.NoError(t, err, exporter)
+			assert.NoError(t, tp.Shutdown(context.Background()), exporter)
+		}
+	})
+
+	t.Run("Refuses unknown exporter", func(t *testing.T) {
+		_, err := Setup(&utils.TracingConfig{ServiceName: "shop", Exporter: "zipkin"})
+		assert.Error(t, err)
+	})
+}
+
+func TestSetupInMemory(t *testing.T) {
+	tp, exporter := SetupInMemory()
+	t.Cleanup(func() { tp.Shutdown(context.Background()) })
+
+	assert.Empty(t, TraceID(context.Background()), "Expected no trace ID without span")
+
+	ctx, span := Tracer().Start(context.Background(), "charge")
+	RecordError(span, nil)
+	RecordError(span, errors.New("card declined"))
+	span.End()
+
+	spans := exporter.GetSpans()
+	if assert.Len(t, spans, 1, "Expected span recorded synchronously") {
+		assert.Equal(t, spans[0].SpanContext.TraceID().String(), TraceID(ctx))
+		assert.Equal(t, codes.Error, spans[0].Status.Code)
+		assert.Equal(t, "card declined", spans[0].Status.Description)
+		assert.Len(t, spans[0].Events, 1, "Expected only the non-nil error recorded")
+	}
+}
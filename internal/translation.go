@@ -3,7 +3,12 @@ package internal
 import (
 	"encoding/json"
 	"os"
+	"path/filepath"
+	"strings"
 	"sync"
+	"sync/atomic"
+
+	"github.com/mcgtrt/go-puerto/internal/metrics"
 )
 
 type TranslationManager struct {
@@ -34,8 +39,57 @@ func (tm *TranslationManager) Load(lang, path string) error {
 	return nil
 }
 
+// Load every <lang>.json file from the directory
+func (tm *TranslationManager) LoadDir(dir string) error {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		lang := strings.TrimSuffix(filepath.Base(path), ".json")
+		if err := tm.Load(lang, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (tm *TranslationManager) Translate(lang, key string) string {
 	tm.mu.RLock()
 	defer tm.mu.RUnlock()
-	return tm.translations[lang][key]
+	translations, loaded := tm.translations[lang]
+	value, ok := translations[key]
+	if !ok {
+		// Unknown languages share one label to keep metric cardinality bounded
+		if !loaded {
+			lang = "unknown"
+		}
+		metrics.TranslationMisses.WithLabelValues(lang).Inc()
+	}
+	return value
+}
+
+var defaultTranslations atomic.Pointer[TranslationManager]
+
+// Set translations used by code that has no access to the handlers
+// (e.g. middlewares responding on their own)
+func SetDefaultTranslations(tm *TranslationManager) {
+	defaultTranslations.Store(tm)
+}
+
+// Translate key with the default translations. The language may be a raw
+// Accept-Language value - only its primary tag is used. Returns the fallback
+// when no translations are set or the key is missing.
+func Localise(lang, key, fallback string) string {
+	tm := defaultTranslations.Load()
+	if tm == nil {
+		return fallback
+	}
+	lang, _, _ = strings.Cut(lang, ",")
+	lang, _, _ = strings.Cut(lang, ";")
+	lang, _, _ = strings.Cut(lang, "-")
+	if value := tm.Translate(strings.ToLower(strings.TrimSpace(lang)), key); value != "" {
+		return value
+	}
+	return fallback
 }
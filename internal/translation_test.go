@@ -2,6 +2,7 @@ package internal
 
 import (
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -69,3 +70,21 @@ func TestTranslationManager(t *testing.T) {
 		assert.Equal(t, "", tm.Translate("fr", "greeting"), "Expected empty string for missing language")
 	})
 }
+
+func TestLocalise(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "pl.json"), []byte(`{"greeting": "Cześć"}`), 0644)
+	assert.NoError(t, err, "Failed to create test file")
+
+	assert.Equal(t, "Hello", Localise("pl", "greeting", "Hello"), "Expected fallback without translations")
+
+	tm := NewTranslationManager()
+	assert.NoError(t, tm.LoadDir(dir))
+	SetDefaultTranslations(tm)
+	defer defaultTranslations.Store(nil)
+
+	assert.Equal(t, "Cześć", Localise("pl", "greeting", "Hello"))
+	assert.Equal(t, "Cześć", Localise("pl-PL,pl;q=0.9,en;q=0.8", "greeting", "Hello"), "Expected primary language tag used")
+	assert.Equal(t, "Hello", Localise("de", "greeting", "Hello"), "Expected fallback for unknown language")
+	assert.Equal(t, "Hello", Localise("pl", "missing", "Hello"), "Expected fallback for missing key")
+}
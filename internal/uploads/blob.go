@@ -0,0 +1,59 @@
+package uploads
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"time"
+)
+
+var (
+	ErrBlobNotFound = errors.New("blob not found")
+	ErrInvalidKey   = errors.New("invalid blob key")
+)
+
+// Stored file. Size and CreatedAt are set by the store.
+type Object struct {
+	Key         string `json:"key"`
+	Size        int64  `json:"size"`
+	ContentType string `json:"content_type"`
+	// Name of the file on the device it was uploaded from
+	Filename  string    `json:"filename,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Storage of file contents
+type BlobStore interface {
+	// Store the content under the key of the object, replacing the blob
+	// stored under it. Returns ErrInvalidKey for keys failing ValidKey.
+	// Nothing is stored when reading the content fails.
+	Put(ctx context.Context, o *Object, r io.Reader) error
+	// Content of the blob, an io.ReadSeeker when the store can seek.
+	// Returns ErrBlobNotFound if there is no such blob.
+	Get(ctx context.Context, key string) (io.ReadCloser, *Object, error)
+	// Returns ErrBlobNotFound if there is no such blob
+	Stat(ctx context.Context, key string) (*Object, error)
+	// Deleting a missing blob is not an error
+	Delete(ctx context.Context, key string) error
+}
+
+// Keys are slash separated paths of letters, digits, '-', '_' and '.',
+// with no segment starting with a dot, so they map safely to file paths
+// and URLs of every store
+func ValidKey(key string) bool {
+	if key == "" || len(key) > 512 {
+		return false
+	}
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "" || segment[0] == '.' {
+			return false
+		}
+		for _, r := range segment {
+			if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.') {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,103 @@
+package uploads
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/mcgtrt/go-puerto/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidKey(t *testing.T) {
+	for _, key := range []string{"01J0ABC", "pending/01J0ABC/0", "a.b-c_d"} {
+		assert.True(t, ValidKey(key), key)
+	}
+	for _, key := range []string{"", "/abs", "a//b", "../etc/passwd", "a/.meta", ".hidden", "a b", "a\\b", strings.Repeat("a", 513)} {
+		assert.False(t, ValidKey(key), key)
+	}
+}
+
+// Behaviour every BlobStore shares
+func testBlobStore(t *testing.T, store BlobStore) {
+	ctx := context.Background()
+
+	o := &Object{Key: "tests/" + utils.NewULID(), ContentType: "text/plain", Filename: "notes.txt"}
+	assert.NoError(t, store.Put(ctx, o, strings.NewReader("hello")))
+	assert.Equal(t, int64(5), o.Size)
+	assert.False(t, o.CreatedAt.IsZero())
+	defer store.Delete(ctx, o.Key)
+
+	r, got, err := store.Get(ctx, o.Key)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	data, _ := io.ReadAll(r)
+	r.Close()
+	assert.Equal(t, "hello", string(data))
+	assert.Equal(t, int64(5), got.Size)
+	assert.Equal(t, "text/plain", got.ContentType)
+	assert.Equal(t, "notes.txt", got.Filename)
+
+	assert.NoError(t, store.Put(ctx, &Object{Key: o.Key, ContentType: "text/plain"}, strings.NewReader("bye")))
+	stat, err := store.Stat(ctx, o.Key)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), stat.Size, "Expected blob replaced")
+
+	failing := io.MultiReader(strings.NewReader("partial"), errReader{})
+	assert.Error(t, store.Put(ctx, &Object{Key: o.Key + "-failed"}, failing))
+	_, err = store.Stat(ctx, o.Key+"-failed")
+	assert.ErrorIs(t, err, ErrBlobNotFound, "Expected nothing stored when reading fails")
+
+	assert.ErrorIs(t, store.Put(ctx, &Object{Key: "../escape"}, strings.NewReader("x")), ErrInvalidKey)
+
+	assert.NoError(t, store.Delete(ctx, o.Key))
+	assert.NoError(t, store.Delete(ctx, o.Key), "Expected deleting a missing blob to succeed")
+	_, _, err = store.Get(ctx, o.Key)
+	assert.ErrorIs(t, err, ErrBlobNotFound)
+	_, err = store.Stat(ctx, o.Key)
+	assert.ErrorIs(t, err, ErrBlobNotFound)
+}
+
+type errReader struct{}
+
+func (errReader) Read(p []byte) (int, error) {
+	return 0, errors.New("connection reset")
+}
+
+func TestMemoryStore(t *testing.T) {
+	testBlobStore(t, NewMemoryStore())
+}
+
+func TestLocalStore(t *testing.T) {
+	dir := t.TempDir()
+	testBlobStore(t, NewLocalStore(dir))
+
+	entries, err := os.ReadDir(dir + "/tests")
+	assert.NoError(t, err)
+	assert.Empty(t, entries, "Expected no temporary files left behind")
+}
+
+// Runs against MinIO or any S3 compatible storage configured with the S3_
+// variables, e.g. the minio service of docker-compose.yaml
+func TestS3Store(t *testing.T) {
+	if os.Getenv(utils.S3_ENDPOINT) == "" {
+		t.Skip("S3_ENDPOINT not set")
+	}
+	store, err := NewS3Store(&utils.S3Config{
+		Endpoint:  os.Getenv(utils.S3_ENDPOINT),
+		Region:    os.Getenv(utils.S3_REGION),
+		Bucket:    os.Getenv(utils.S3_BUCKET),
+		AccessKey: os.Getenv(utils.S3_ACCESS_KEY),
+		SecretKey: os.Getenv(utils.S3_SECRET_KEY),
+		UseSSL:    os.Getenv(utils.S3_USE_SSL) != "false",
+	})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.NoError(t, store.EnsureBucket(context.Background()))
+	testBlobStore(t, store)
+}
@@ -0,0 +1,217 @@
+package uploads
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/mcgtrt/go-puerto/utils"
+)
+
+// Key prefix of unfinished resumable uploads. Expire it with a lifecycle
+// rule of the bucket to clean up uploads clients never finished.
+const PENDING_PREFIX = "pending/"
+
+var (
+	ErrUploadNotFound = errors.New("upload not found")
+	ErrOffsetMismatch = errors.New("chunk offset doesn't match the uploaded size")
+)
+
+// File sent in chunks. Clients resume failed uploads by asking for
+// Offset and sending the rest of the file from there.
+type Upload struct {
+	ID          string    `json:"id"`
+	Owner       string    `json:"-"`
+	Filename    string    `json:"filename"`
+	Size        int64     `json:"size"`
+	Offset      int64     `json:"offset"`
+	Chunks      int       `json:"-"`
+	ContentType string    `json:"content_type,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Upload as kept in the store, with the fields hidden from clients
+type uploadState struct {
+	*Upload
+	Owner  string `json:"owner"`
+	Chunks int    `json:"chunks"`
+}
+
+// Start an upload of a file of the size. The owner, e.g. the ID of the
+// user, is the only one allowed to continue it.
+func (s *Service) StartUpload(ctx context.Context, owner, filename string, size int64) (*Upload, error) {
+	if size <= 0 {
+		return nil, ErrEmptyFile
+	}
+	if size > s.MaxSize {
+		return nil, ErrTooLarge
+	}
+	u := &Upload{
+		ID:        utils.NewULID(),
+		Owner:     owner,
+		Filename:  cleanFilename(filename),
+		Size:      size,
+		CreatedAt: s.now().UTC(),
+	}
+	return u, s.saveUpload(ctx, u)
+}
+
+// Returns ErrUploadNotFound unless the owner started the upload
+func (s *Service) GetUpload(ctx context.Context, id, owner string) (*Upload, error) {
+	if !ValidKey(id) {
+		return nil, ErrUploadNotFound
+	}
+	r, _, err := s.Store.Get(ctx, uploadKey(id))
+	if errors.Is(err, ErrBlobNotFound) {
+		return nil, ErrUploadNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	state := uploadState{Upload: &Upload{}}
+	if err := json.NewDecoder(r).Decode(&state); err != nil {
+		return nil, err
+	}
+	u := state.Upload
+	u.Owner, u.Chunks = state.Owner, state.Chunks
+	if u.Owner != owner {
+		return nil, ErrUploadNotFound
+	}
+	return u, nil
+}
+
+// Append the chunk at the offset, which must be the size uploaded so far.
+// A chunk is at most ChunkSize, shorter chunks are fine. Chunks cut short
+// by a failed request aren't stored, the client asks for the offset and
+// sends them again. The type is checked with the first chunk. Once the
+// whole file is uploaded the chunks are joined and the stored file is
+// returned.
+func (s *Service) WriteChunk(ctx context.Context, id, owner string, offset int64, r io.Reader) (*Upload, *Object, error) {
+	u, err := s.GetUpload(ctx, id, owner)
+	if err != nil {
+		return nil, nil, err
+	}
+	if offset != u.Offset {
+		return u, nil, ErrOffsetMismatch
+	}
+	if u.Chunks == 0 {
+		head, err := readHead(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(head) == 0 {
+			return u, nil, nil
+		}
+		if u.ContentType, err = s.sniff(head); err != nil {
+			s.AbortUpload(context.WithoutCancel(ctx), id, owner)
+			return nil, nil, err
+		}
+		r = io.MultiReader(bytes.NewReader(head), r)
+	}
+	chunk := &Object{Key: chunkKey(id, u.Chunks), ContentType: "application/octet-stream"}
+	body := &limitedReader{r: r, n: min(s.ChunkSize, u.Size-u.Offset)}
+	if err := s.Store.Put(ctx, chunk, body); err != nil {
+		return nil, nil, err
+	}
+	if chunk.Size == 0 {
+		return u, nil, s.Store.Delete(ctx, chunk.Key)
+	}
+	u.Offset += chunk.Size
+	u.Chunks++
+	if u.Offset < u.Size {
+		return u, nil, s.saveUpload(ctx, u)
+	}
+
+	o := &Object{Key: u.ID, Size: u.Size, ContentType: u.ContentType, Filename: u.Filename}
+	chunks := &chunkReader{ctx: ctx, store: s.Store, id: id, count: u.Chunks}
+	err = s.Store.Put(ctx, o, chunks)
+	chunks.Close()
+	if err != nil {
+		// Kept, so the client can try finishing it again
+		return nil, nil, errors.Join(err, s.saveUpload(ctx, u))
+	}
+	s.deleteUpload(ctx, u)
+	return u, o, nil
+}
+
+// Delete the upload and the chunks sent so far
+func (s *Service) AbortUpload(ctx context.Context, id, owner string) error {
+	u, err := s.GetUpload(ctx, id, owner)
+	if err != nil {
+		return err
+	}
+	return s.deleteUpload(ctx, u)
+}
+
+func (s *Service) saveUpload(ctx context.Context, u *Upload) error {
+	data, err := json.Marshal(uploadState{Upload: u, Owner: u.Owner, Chunks: u.Chunks})
+	if err != nil {
+		return err
+	}
+	return s.Store.Put(ctx, &Object{Key: uploadKey(u.ID), ContentType: "application/json"}, bytes.NewReader(data))
+}
+
+func (s *Service) deleteUpload(ctx context.Context, u *Upload) error {
+	var errs []error
+	for i := range u.Chunks {
+		errs = append(errs, s.Store.Delete(ctx, chunkKey(u.ID, i)))
+	}
+	errs = append(errs, s.Store.Delete(ctx, uploadKey(u.ID)))
+	return errors.Join(errs...)
+}
+
+func uploadKey(id string) string {
+	return PENDING_PREFIX + id + "/upload.json"
+}
+
+func chunkKey(id string, i int) string {
+	return PENDING_PREFIX + id + "/" + strconv.Itoa(i)
+}
+
+// Reads the chunks of an upload one after another
+type chunkReader struct {
+	ctx   context.Context
+	store BlobStore
+	id    string
+	count int
+	next  int
+	cur   io.ReadCloser
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	for {
+		if c.cur == nil {
+			if c.next == c.count {
+				return 0, io.EOF
+			}
+			r, _, err := c.store.Get(c.ctx, chunkKey(c.id, c.next))
+			if err != nil {
+				return 0, err
+			}
+			c.cur = r
+			c.next++
+		}
+		n, err := c.cur.Read(p)
+		if err == io.EOF {
+			c.cur.Close()
+			c.cur = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (c *chunkReader) Close() error {
+	if c.cur != nil {
+		return c.cur.Close()
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package uploads
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResumableUpload(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Joins chunks into the file", func(t *testing.T) {
+		store := NewMemoryStore()
+		s := newTestService(store)
+		u, err := s.StartUpload(ctx, "user-1", "cat.png", int64(len(testPNG)))
+		assert.NoError(t, err)
+
+		_, _, err = s.WriteChunk(ctx, u.ID, "user-1", 0, bytes.NewReader(testPNG))
+		assert.ErrorIs(t, err, ErrTooLarge, "Expected chunks over ChunkSize refused")
+		u, o, err := s.WriteChunk(ctx, u.ID, "user-1", 0, bytes.NewReader(testPNG[:16]))
+		assert.NoError(t, err)
+		assert.Nil(t, o)
+		assert.Equal(t, int64(16), u.Offset)
+
+		_, _, err = s.WriteChunk(ctx, u.ID, "user-1", 0, bytes.NewReader(testPNG))
+		assert.ErrorIs(t, err, ErrOffsetMismatch)
+		_, _, err = s.WriteChunk(ctx, u.ID, "user-2", 16, bytes.NewReader(testPNG[16:]))
+		assert.ErrorIs(t, err, ErrUploadNotFound, "Expected uploads of others hidden")
+
+		for u.Offset < u.Size {
+			u, o, err = s.WriteChunk(ctx, u.ID, "user-1", u.Offset, bytes.NewReader(testPNG[u.Offset:min(u.Offset+16, u.Size)]))
+			if !assert.NoError(t, err) {
+				t.FailNow()
+			}
+		}
+		assert.NotNil(t, o)
+		assert.Equal(t, u.ID, o.Key)
+		assert.Equal(t, "image/png", o.ContentType)
+		assert.Equal(t, "cat.png", o.Filename)
+
+		r, _, err := store.Get(ctx, o.Key)
+		assert.NoError(t, err)
+		data, _ := io.ReadAll(r)
+		assert.Equal(t, testPNG, data)
+		assert.Equal(t, []string{o.Key}, store.Keys(), "Expected chunks deleted")
+		_, err = s.GetUpload(ctx, u.ID, "user-1")
+		assert.ErrorIs(t, err, ErrUploadNotFound)
+	})
+
+	t.Run("Resumes after an interrupted chunk", func(t *testing.T) {
+		s := newTestService(NewMemoryStore())
+		u, _ := s.StartUpload(ctx, "user-1", "cat.png", int64(len(testPNG)))
+
+		_, _, err := s.WriteChunk(ctx, u.ID, "user-1", 0, io.MultiReader(bytes.NewReader(testPNG[:10]), errReader{}))
+		assert.Error(t, err)
+		u, err = s.GetUpload(ctx, u.ID, "user-1")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(0), u.Offset, "Expected failed chunk not counted")
+
+		u, _, err = s.WriteChunk(ctx, u.ID, "user-1", 0, bytes.NewReader(testPNG[:10]))
+		assert.NoError(t, err)
+		assert.Equal(t, int64(10), u.Offset, "Expected short chunks accepted")
+		for u.Offset < u.Size {
+			u, _, err = s.WriteChunk(ctx, u.ID, "user-1", u.Offset, bytes.NewReader(testPNG[u.Offset:min(u.Offset+16, u.Size)]))
+			assert.NoError(t, err)
+		}
+	})
+
+	t.Run("Checks the type with the first chunk", func(t *testing.T) {
+		store := NewMemoryStore()
+		s := newTestService(store)
+		u, _ := s.StartUpload(ctx, "user-1", "page.html", 40)
+
+		_, _, err := s.WriteChunk(ctx, u.ID, "user-1", 0, strings.NewReader("<html><body>hello</body></html>"))
+		assert.ErrorIs(t, err, ErrTypeNotAllowed)
+		assert.Empty(t, store.Keys(), "Expected refused upload aborted")
+	})
+
+	t.Run("Limits the size", func(t *testing.T) {
+		s := newTestService(NewMemoryStore())
+		_, err := s.StartUpload(ctx, "user-1", "big.png", 65)
+		assert.ErrorIs(t, err, ErrTooLarge)
+		_, err = s.StartUpload(ctx, "user-1", "empty.png", 0)
+		assert.ErrorIs(t, err, ErrEmptyFile)
+	})
+
+	t.Run("Aborts", func(t *testing.T) {
+		store := NewMemoryStore()
+		s := newTestService(store)
+		u, _ := s.StartUpload(ctx, "user-1", "cat.png", int64(len(testPNG)))
+		s.WriteChunk(ctx, u.ID, "user-1", 0, bytes.NewReader(testPNG[:16]))
+
+		assert.ErrorIs(t, s.AbortUpload(ctx, u.ID, "user-2"), ErrUploadNotFound)
+		assert.NoError(t, s.AbortUpload(ctx, u.ID, "user-1"))
+		assert.Empty(t, store.Keys())
+	})
+}
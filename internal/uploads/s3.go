@@ -0,0 +1,114 @@
+package uploads
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"time"
+
+	"github.com/mcgtrt/go-puerto/utils"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// Size of the parts of multipart uploads. Content of unknown size is
+// buffered part by part, so it caps the memory used by an upload and,
+// with at most 10000 parts, the size of a blob at ~50GB.
+const S3_PART_SIZE = 5 << 20
+
+// Keeps blobs in a bucket of S3-compatible object storage, e.g. AWS S3 or
+// MinIO (docker compose up -d minio)
+type S3Store struct {
+	Client *minio.Client
+	Bucket string
+	Region string
+}
+
+func NewS3Store(cfg *utils.S3Config) (*S3Store, error) {
+	client, err := minio.New(cfg.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, ""),
+		Secure: cfg.UseSSL,
+		Region: cfg.Region,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &S3Store{Client: client, Bucket: cfg.Bucket, Region: cfg.Region}, nil
+}
+
+// Create the bucket unless it exists
+func (s *S3Store) EnsureBucket(ctx context.Context) error {
+	exists, err := s.Client.BucketExists(ctx, s.Bucket)
+	if err != nil || exists {
+		return err
+	}
+	return s.Client.MakeBucket(ctx, s.Bucket, minio.MakeBucketOptions{Region: s.Region})
+}
+
+// Content of known size (o.Size above zero) is sent in a single request
+// when it fits one part
+func (s *S3Store) Put(ctx context.Context, o *Object, r io.Reader) error {
+	if !ValidKey(o.Key) {
+		return ErrInvalidKey
+	}
+	size := o.Size
+	if size <= 0 {
+		size = -1
+	}
+	info, err := s.Client.PutObject(ctx, s.Bucket, o.Key, r, size, minio.PutObjectOptions{
+		ContentType: o.ContentType,
+		// Metadata travels in headers, which allow ASCII only
+		UserMetadata: map[string]string{"Filename": url.QueryEscape(o.Filename)},
+		PartSize:     S3_PART_SIZE,
+	})
+	if err != nil {
+		return err
+	}
+	o.Size, o.CreatedAt = info.Size, time.Now().UTC()
+	return nil
+}
+
+func (s *S3Store) Get(ctx context.Context, key string) (io.ReadCloser, *Object, error) {
+	obj, err := s.Client.GetObject(ctx, s.Bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, nil, s3Error(err)
+	}
+	// The object is fetched lazily, Stat finds out whether it exists
+	info, err := obj.Stat()
+	if err != nil {
+		obj.Close()
+		return nil, nil, s3Error(err)
+	}
+	return obj, s3Object(info), nil
+}
+
+func (s *S3Store) Stat(ctx context.Context, key string) (*Object, error) {
+	info, err := s.Client.StatObject(ctx, s.Bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		return nil, s3Error(err)
+	}
+	return s3Object(info), nil
+}
+
+func (s *S3Store) Delete(ctx context.Context, key string) error {
+	return s.Client.RemoveObject(ctx, s.Bucket, key, minio.RemoveObjectOptions{})
+}
+
+func s3Object(info minio.ObjectInfo) *Object {
+	filename, _ := url.QueryUnescape(info.UserMetadata["Filename"])
+	return &Object{
+		Key:         info.Key,
+		Size:        info.Size,
+		ContentType: info.ContentType,
+		Filename:    filename,
+		CreatedAt:   info.LastModified.UTC(),
+	}
+}
+
+func s3Error(err error) error {
+	switch minio.ToErrorResponse(err).Code {
+	case minio.NoSuchKey, minio.NoSuchBucket:
+		return ErrBlobNotFound
+	}
+	return err
+}
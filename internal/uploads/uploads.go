@@ -0,0 +1,253 @@
+package uploads
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/mcgtrt/go-puerto/utils"
+)
+
+// Bytes at the start of files their type is detected from
+const SNIFF_LEN = 512
+
+// Limits of a multipart request besides the size of each file
+const (
+	MAX_FILES       = 10
+	MAX_FIELD_BYTES = 64 << 10
+)
+
+// Path of the download links, serve it with the upload handler
+const DOWNLOAD_PATH = "/files/"
+
+var (
+	ErrEmptyFile        = errors.New("file is empty")
+	ErrTooLarge         = errors.New("file is too large")
+	ErrTooManyFiles     = errors.New("too many files")
+	ErrTypeNotAllowed   = errors.New("file type is not allowed")
+	ErrInvalidSignature = errors.New("download link is invalid or expired")
+)
+
+// Stores uploaded files after checking their size and type, and signs
+// links to download them
+type Service struct {
+	Store     BlobStore
+	MaxSize   int64
+	ChunkSize int64
+	// Media types, or wildcards like image/*, of files accepted
+	AllowedTypes []string
+	URLTTL       time.Duration
+
+	key []byte
+	now func() time.Time
+}
+
+// Create service signing links with the key derived from the application
+// secret
+func NewService(store BlobStore, cfg *utils.UploadsConfig, secret []byte) *Service {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("uploads"))
+	return &Service{
+		Store:        store,
+		MaxSize:      cfg.MaxSize,
+		ChunkSize:    cfg.ChunkSize,
+		AllowedTypes: cfg.AllowedTypes,
+		URLTTL:       cfg.URLTTL,
+		key:          mac.Sum(nil),
+		now:          time.Now,
+	}
+}
+
+// Store the file under a new key. The type is detected from the first
+// bytes of the content, the type claimed by the client is never trusted.
+func (s *Service) Save(ctx context.Context, filename string, r io.Reader) (*Object, error) {
+	head, err := readHead(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(head) == 0 {
+		return nil, ErrEmptyFile
+	}
+	contentType, err := s.sniff(head)
+	if err != nil {
+		return nil, err
+	}
+	o := &Object{Key: utils.NewULID(), ContentType: contentType, Filename: cleanFilename(filename)}
+	body := &limitedReader{r: io.MultiReader(bytes.NewReader(head), r), n: s.MaxSize}
+	if err := s.Store.Put(ctx, o, body); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// Fields and files of a multipart form
+type Form struct {
+	Values url.Values
+	Files  map[string][]*Object
+}
+
+// First file of the field, nil without files
+func (f *Form) File(name string) *Object {
+	if files := f.Files[name]; len(files) > 0 {
+		return files[0]
+	}
+	return nil
+}
+
+// Read the multipart form of the request part by part, storing files as
+// they arrive instead of buffering them in memory or temporary files. When
+// any file is refused, the files stored before it are deleted.
+func (s *Service) Receive(r *http.Request) (*Form, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	form := &Form{Values: url.Values{}, Files: make(map[string][]*Object)}
+	fieldBytes, files := 0, 0
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return form, nil
+		}
+		if err == nil && part.FileName() == "" {
+			var value []byte
+			value, err = io.ReadAll(io.LimitReader(part, int64(MAX_FIELD_BYTES-fieldBytes+1)))
+			fieldBytes += len(value)
+			if err == nil && fieldBytes > MAX_FIELD_BYTES {
+				err = ErrTooLarge
+			}
+			form.Values.Add(part.FormName(), string(value))
+		} else if err == nil {
+			files++
+			if files > MAX_FILES {
+				err = ErrTooManyFiles
+			} else {
+				var o *Object
+				if o, err = s.Save(r.Context(), part.FileName(), part); err == nil {
+					form.Files[part.FormName()] = append(form.Files[part.FormName()], o)
+				}
+			}
+		}
+		if err != nil {
+			s.discard(r.Context(), form)
+			return nil, err
+		}
+	}
+}
+
+func (s *Service) discard(ctx context.Context, form *Form) {
+	ctx = context.WithoutCancel(ctx)
+	for _, files := range form.Files {
+		for _, o := range files {
+			s.Store.Delete(ctx, o.Key)
+		}
+	}
+}
+
+// Link to download the blob, valid for URLTTL
+func (s *Service) URL(key string) string {
+	expires := strconv.FormatInt(s.now().Add(s.URLTTL).Unix(), 10)
+	query := url.Values{"expires": {expires}, "signature": {s.Sign(key + "\n" + expires)}}
+	return DOWNLOAD_PATH + key + "?" + query.Encode()
+}
+
+// Check the expiry and signature of a download link to the blob. Returns
+// the expiry time.
+func (s *Service) Verify(key string, query url.Values) (time.Time, error) {
+	expires := query.Get("expires")
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || !s.ValidSignature(key+"\n"+expires, query.Get("signature")) {
+		return time.Time{}, ErrInvalidSignature
+	}
+	at := time.Unix(unix, 0)
+	if !s.now().Before(at) {
+		return time.Time{}, ErrInvalidSignature
+	}
+	return at, nil
+}
+
+// Signature of the value proving it was issued by the app
+func (s *Service) Sign(value string) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(value))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (s *Service) ValidSignature(value, signature string) bool {
+	sig, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(value))
+	return hmac.Equal(sig, mac.Sum(nil))
+}
+
+// Media type of the content, ErrTypeNotAllowed when it isn't allowed
+func (s *Service) sniff(head []byte) (string, error) {
+	contentType, _, _ := mime.ParseMediaType(http.DetectContentType(head))
+	for _, allowed := range s.AllowedTypes {
+		if allowed == contentType || strings.HasSuffix(allowed, "/*") && strings.HasPrefix(contentType, strings.TrimSuffix(allowed, "*")) {
+			return contentType, nil
+		}
+	}
+	return "", ErrTypeNotAllowed
+}
+
+func readHead(r io.Reader) ([]byte, error) {
+	head := make([]byte, SNIFF_LEN)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	return head[:n], nil
+}
+
+// Name of the file without the path some browsers send and without
+// control characters, so it's safe in headers and logs
+func cleanFilename(name string) string {
+	name = path.Base(strings.ReplaceAll(name, "\\", "/"))
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, name)
+	if name == "." || name == "/" {
+		return ""
+	}
+	if len(name) > 255 {
+		name = strings.ToValidUTF8(name[:255], "")
+	}
+	return name
+}
+
+// Fails with ErrTooLarge once more than n bytes are read
+type limitedReader struct {
+	r io.Reader
+	n int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	if l.n < 0 {
+		return 0, ErrTooLarge
+	}
+	return n, err
+}
@@ -0,0 +1,146 @@
+package uploads
+
+import (
+	"bytes"
+	"context"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mcgtrt/go-puerto/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+// Smallest valid PNG header, enough for content sniffing
+var testPNG = append([]byte("\x89PNG\r\n\x1a\n"), make([]byte, 32)...)
+
+func newTestService(store BlobStore) *Service {
+	return NewService(store, &utils.UploadsConfig{
+		MaxSize:      64,
+		ChunkSize:    16,
+		AllowedTypes: []string{"image/*", "application/pdf"},
+		URLTTL:       time.Hour,
+	}, []byte("secret"))
+}
+
+func TestSave(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	s := newTestService(store)
+
+	o, err := s.Save(ctx, `C:\Users\me\cat.png`, bytes.NewReader(testPNG))
+	assert.NoError(t, err)
+	assert.Equal(t, "image/png", o.ContentType)
+	assert.Equal(t, "cat.png", o.Filename, "Expected path of the client removed")
+	assert.Equal(t, int64(len(testPNG)), o.Size)
+	assert.True(t, ValidKey(o.Key))
+
+	_, err = s.Save(ctx, "cat.png", strings.NewReader("<html><script>alert(1)</script>"))
+	assert.ErrorIs(t, err, ErrTypeNotAllowed, "Expected type sniffed from the content, not the name")
+	_, err = s.Save(ctx, "big.png", bytes.NewReader(append(testPNG, make([]byte, 64)...)))
+	assert.ErrorIs(t, err, ErrTooLarge)
+	_, err = s.Save(ctx, "empty.png", strings.NewReader(""))
+	assert.ErrorIs(t, err, ErrEmptyFile)
+	assert.Equal(t, []string{o.Key}, store.Keys(), "Expected refused files not stored")
+}
+
+func multipartRequest(t *testing.T, fill func(w *multipart.Writer)) *http.Request {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	fill(w)
+	assert.NoError(t, w.Close())
+	r := httptest.NewRequest(http.MethodPost, "/uploads", &body)
+	r.Header.Set("Content-Type", w.FormDataContentType())
+	return r
+}
+
+func TestReceive(t *testing.T) {
+	t.Run("Stores files and reads fields", func(t *testing.T) {
+		store := NewMemoryStore()
+		s := newTestService(store)
+		r := multipartRequest(t, func(w *multipart.Writer) {
+			w.WriteField("title", "holiday")
+			for _, name := range []string{"a.png", "b.png"} {
+				part, _ := w.CreateFormFile("files", name)
+				part.Write(testPNG)
+			}
+		})
+
+		form, err := s.Receive(r)
+		assert.NoError(t, err)
+		assert.Equal(t, "holiday", form.Values.Get("title"))
+		assert.Len(t, form.Files["files"], 2)
+		assert.Equal(t, "a.png", form.File("files").Filename)
+		assert.Nil(t, form.File("missing"))
+		assert.Len(t, store.Keys(), 2)
+	})
+
+	t.Run("Discards stored files when one is refused", func(t *testing.T) {
+		store := NewMemoryStore()
+		s := newTestService(store)
+		r := multipartRequest(t, func(w *multipart.Writer) {
+			part, _ := w.CreateFormFile("files", "a.png")
+			part.Write(testPNG)
+			part, _ = w.CreateFormFile("files", "b.exe")
+			part.Write([]byte("MZ\x90\x00"))
+		})
+
+		_, err := s.Receive(r)
+		assert.ErrorIs(t, err, ErrTypeNotAllowed)
+		assert.Empty(t, store.Keys())
+	})
+
+	t.Run("Limits files and fields", func(t *testing.T) {
+		s := newTestService(NewMemoryStore())
+		r := multipartRequest(t, func(w *multipart.Writer) {
+			for range MAX_FILES + 1 {
+				part, _ := w.CreateFormFile("files", "a.png")
+				part.Write(testPNG)
+			}
+		})
+		_, err := s.Receive(r)
+		assert.ErrorIs(t, err, ErrTooManyFiles)
+
+		r = multipartRequest(t, func(w *multipart.Writer) {
+			w.WriteField("text", strings.Repeat("a", MAX_FIELD_BYTES+1))
+		})
+		_, err = s.Receive(r)
+		assert.ErrorIs(t, err, ErrTooLarge)
+	})
+
+	t.Run("Requires multipart requests", func(t *testing.T) {
+		s := newTestService(NewMemoryStore())
+		_, err := s.Receive(httptest.NewRequest(http.MethodPost, "/uploads", strings.NewReader("a=b")))
+		assert.Error(t, err)
+	})
+}
+
+func TestURL(t *testing.T) {
+	s := newTestService(NewMemoryStore())
+	now := time.Now()
+	s.now = func() time.Time { return now }
+
+	link, err := url.Parse(s.URL("01J0ABC"))
+	assert.NoError(t, err)
+	assert.Equal(t, DOWNLOAD_PATH+"01J0ABC", link.Path)
+	expires, err := s.Verify("01J0ABC", link.Query())
+	assert.NoError(t, err)
+	assert.Equal(t, now.Add(time.Hour).Unix(), expires.Unix())
+
+	_, err = s.Verify("01J0XYZ", link.Query())
+	assert.ErrorIs(t, err, ErrInvalidSignature, "Expected link bound to the key")
+	tampered := link.Query()
+	tampered.Set("expires", "99999999999")
+	_, err = s.Verify("01J0ABC", tampered)
+	assert.ErrorIs(t, err, ErrInvalidSignature, "Expected expiry covered by the signature")
+	_, err = s.Verify("01J0ABC", url.Values{})
+	assert.ErrorIs(t, err, ErrInvalidSignature)
+
+	s.now = func() time.Time { return now.Add(time.Hour) }
+	_, err = s.Verify("01J0ABC", link.Query())
+	assert.ErrorIs(t, err, ErrInvalidSignature, "Expected expired links refused")
+}
@@ -0,0 +1,264 @@
+package ws
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/a-h/templ"
+	"github.com/coder/websocket"
+	"github.com/mcgtrt/go-puerto/internal/metrics"
+)
+
+// Control message a client sends to join or leave a room:
+//
+//	{"type": "subscribe", "room": "chat:lobby"}
+//
+// The hub answers with "subscribed", "unsubscribed" or "error".
+type control struct {
+	Type  string `json:"type"`
+	Room  string `json:"room"`
+	Error string `json:"error,omitempty"`
+}
+
+// Connection of a single client. Sends are safe from many goroutines and
+// never block, clients falling behind by more than SendBuffer messages are
+// disconnected.
+type Client struct {
+	ID string
+
+	hub    *Hub
+	conn   *websocket.Conn
+	ctx    context.Context
+	cancel context.CancelFunc
+	send   chan Message
+
+	mu     sync.Mutex
+	rooms  map[string]struct{}
+	closed bool
+}
+
+// Request context of the handshake, cancelled when the client disconnects.
+// It carries the principal of the client.
+func (c *Client) Context() context.Context {
+	return c.ctx
+}
+
+// Rooms the client is in
+func (c *Client) Rooms() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	rooms := make([]string, 0, len(c.rooms))
+	for room := range c.rooms {
+		rooms = append(rooms, room)
+	}
+	slices.Sort(rooms)
+	return rooms
+}
+
+// Add the client to the room on behalf of the server, without checking
+// the rules of the hub
+func (c *Client) Join(room string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+	c.rooms[room] = struct{}{}
+	c.hub.join(c, room)
+}
+
+// Add the client to the room it asked for when the rules of the hub allow it
+func (c *Client) Subscribe(room string) error {
+	if !c.hub.Allowed(c.ctx, room) {
+		return ErrForbiddenRoom
+	}
+	c.Join(room)
+	return nil
+}
+
+func (c *Client) Leave(room string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.rooms, room)
+	c.hub.leave(c, room)
+}
+
+// Queue the message for the client
+func (c *Client) Send(m Message) error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return ErrClientClosed
+	}
+	select {
+	case c.send <- m:
+		c.mu.Unlock()
+		return nil
+	default:
+	}
+	c.mu.Unlock()
+	slog.Warn("websocket client too slow, disconnecting", "client", c.ID)
+	metrics.WebSocketDropped.Inc()
+	go c.Close(websocket.StatusPolicyViolation, "too slow")
+	return ErrSlowConsumer
+}
+
+func (c *Client) SendJSON(v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.Send(Message{Data: data})
+}
+
+// Send the rendered templ component, e.g. a fragment swapped in by the
+// HTMX ws extension
+func (c *Client) Render(component templ.Component) error {
+	var buf bytes.Buffer
+	if err := component.Render(c.ctx, &buf); err != nil {
+		return err
+	}
+	return c.Send(Message{Data: buf.Bytes()})
+}
+
+// Leave all rooms and close the connection with the status
+func (c *Client) Close(code websocket.StatusCode, reason string) error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	rooms := make([]string, 0, len(c.rooms))
+	for room := range c.rooms {
+		rooms = append(rooms, room)
+	}
+	c.mu.Unlock()
+	c.hub.remove(c, rooms)
+	// Cancelling the context first would drop the connection without the
+	// close handshake
+	err := c.conn.Close(code, reason)
+	c.cancel()
+	return err
+}
+
+func (c *Client) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+// Serve the connection until the client disconnects. Subscribe and
+// unsubscribe messages are handled by the hub, other messages are passed
+// to OnMessage. Clients closing the connection normally return nil.
+func (c *Client) Run() error {
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		c.write()
+	}()
+	go func() {
+		defer wg.Done()
+		c.ping()
+	}()
+	err := c.read()
+	c.Close(websocket.StatusNormalClosure, "")
+	wg.Wait()
+	return err
+}
+
+func (c *Client) read() error {
+	for {
+		typ, data, err := c.conn.Read(c.ctx)
+		if err != nil {
+			if c.isClosed() || c.ctx.Err() != nil {
+				return nil
+			}
+			switch websocket.CloseStatus(err) {
+			case websocket.StatusNormalClosure, websocket.StatusGoingAway, websocket.StatusNoStatusRcvd:
+				return nil
+			}
+			return err
+		}
+		m := Message{Binary: typ == websocket.MessageBinary, Data: data}
+		if !m.Binary && c.handleControl(data) {
+			continue
+		}
+		if c.hub.OnMessage != nil {
+			c.hub.OnMessage(c, m)
+		}
+	}
+}
+
+// Handle the message when it's a subscribe or unsubscribe request
+func (c *Client) handleControl(data []byte) bool {
+	var msg control
+	if json.Unmarshal(data, &msg) != nil || msg.Room == "" {
+		return false
+	}
+	switch msg.Type {
+	case "subscribe":
+		if err := c.Subscribe(msg.Room); err != nil {
+			c.SendJSON(control{Type: "error", Room: msg.Room, Error: err.Error()})
+			return true
+		}
+		c.SendJSON(control{Type: "subscribed", Room: msg.Room})
+	case "unsubscribe":
+		c.Leave(msg.Room)
+		c.SendJSON(control{Type: "unsubscribed", Room: msg.Room})
+	default:
+		return false
+	}
+	return true
+}
+
+func (c *Client) write() {
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case m := <-c.send:
+			typ := websocket.MessageText
+			if m.Binary {
+				typ = websocket.MessageBinary
+			}
+			ctx, cancel := context.WithTimeout(c.ctx, WRITE_TIMEOUT)
+			err := c.conn.Write(ctx, typ, m.Data)
+			cancel()
+			if err != nil {
+				c.Close(websocket.StatusInternalError, "write failed")
+				return
+			}
+		}
+	}
+}
+
+// Pings keep proxies from closing idle connections and detect clients
+// that went away without closing the connection
+func (c *Client) ping() {
+	if c.hub.PingInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(c.hub.PingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(c.ctx, WRITE_TIMEOUT)
+			err := c.conn.Ping(ctx)
+			cancel()
+			if err != nil {
+				c.Close(websocket.StatusPolicyViolation, "ping timeout")
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package ws
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/a-h/templ"
+	"github.com/coder/websocket"
+	"github.com/mcgtrt/go-puerto/internal/metrics"
+	"github.com/mcgtrt/go-puerto/utils"
+)
+
+// Time given to a single write and ping before the client is disconnected
+var WRITE_TIMEOUT = 10 * time.Second
+
+// Delay before subscribing to the transport again after it failed
+var RESUBSCRIBE_DELAY = time.Second
+
+var (
+	ErrForbiddenRoom = errors.New("not allowed to join the room")
+	ErrSlowConsumer  = errors.New("client is not reading messages fast enough")
+	ErrClientClosed  = errors.New("client closed")
+)
+
+// Carries broadcasts to the hubs of all instances, including the
+// broadcasting one, in the same order
+type Transport interface {
+	Publish(ctx context.Context, payload []byte) error
+	// Deliver published payloads until the context is done
+	Subscribe(ctx context.Context, fn func(payload []byte)) error
+}
+
+// Frame sent or received over the connection. Text frames carry JSON or
+// HTML for the HTMX ws extension, binary frames anything else.
+type Message struct {
+	Binary bool   `json:"binary,omitempty"`
+	Data   []byte `json:"data"`
+}
+
+func Text(data string) Message {
+	return Message{Data: []byte(data)}
+}
+
+// Decides whether the client of the context (see auth.FromContext) may
+// join the room
+type Authorize func(ctx context.Context, room string) bool
+
+type rule struct {
+	prefix    string
+	authorize Authorize
+}
+
+type broadcast struct {
+	Room    string  `json:"room"`
+	Message Message `json:"message"`
+}
+
+// Connected clients grouped in rooms. Broadcasts to a room reach its
+// clients on every instance when the hub has a Transport. Clients join
+// rooms allowed by the rules registered with Allow.
+type Hub struct {
+	Transport      Transport
+	MaxMessageSize int64
+	SendBuffer     int
+	PingInterval   time.Duration
+	OriginPatterns []string
+	// Handles messages other than room subscriptions sent by clients. The
+	// messages are dropped without it.
+	OnMessage func(c *Client, m Message)
+
+	mu      sync.RWMutex
+	rules   []rule
+	rooms   map[string]map[*Client]struct{}
+	clients map[*Client]struct{}
+	closed  bool
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+func NewHub(transport Transport, cfg *utils.WebSocketConfig) *Hub {
+	return &Hub{
+		Transport:      transport,
+		MaxMessageSize: cfg.MaxMessageSize,
+		SendBuffer:     cfg.SendBuffer,
+		PingInterval:   cfg.PingInterval,
+		OriginPatterns: cfg.OriginPatterns,
+		rooms:          make(map[string]map[*Client]struct{}),
+		clients:        make(map[*Client]struct{}),
+	}
+}
+
+// Let clients join rooms starting with the prefix when authorize allows
+// it, e.g. only their own user room:
+//
+//	hub.Allow("user:", func(ctx context.Context, room string) bool {
+//		p := auth.FromContext(ctx)
+//		return p != nil && room == "user:"+p.ID
+//	})
+//
+// Rooms without a matching rule can't be joined by clients.
+func (h *Hub) Allow(prefix string, authorize Authorize) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.rules = append(h.rules, rule{prefix: prefix, authorize: authorize})
+}
+
+// Whether the client of the context may join the room. The longest
+// matching prefix decides.
+func (h *Hub) Allowed(ctx context.Context, room string) bool {
+	h.mu.RLock()
+	var match *rule
+	for i, r := range h.rules {
+		if strings.HasPrefix(room, r.prefix) && (match == nil || len(r.prefix) > len(match.prefix)) {
+			match = &h.rules[i]
+		}
+	}
+	h.mu.RUnlock()
+	return match != nil && match.authorize(ctx, room)
+}
+
+// Start receiving broadcasts of all instances
+func (h *Hub) Start() {
+	if h.Transport == nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	h.cancel, h.done = cancel, make(chan struct{})
+	go func() {
+		defer close(h.done)
+		for {
+			err := h.Transport.Subscribe(ctx, h.receive)
+			if ctx.Err() != nil {
+				return
+			}
+			slog.Warn("websocket transport subscription failed", "error", err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(RESUBSCRIBE_DELAY):
+			}
+		}
+	}()
+}
+
+// Stop receiving broadcasts and disconnect all clients, telling them the
+// server is going away so they reconnect to another instance
+func (h *Hub) Close() {
+	if h.cancel != nil {
+		h.cancel()
+		<-h.done
+	}
+	h.mu.Lock()
+	h.closed = true
+	clients := make([]*Client, 0, len(h.clients))
+	for c := range h.clients {
+		clients = append(clients, c)
+	}
+	h.mu.Unlock()
+	var wg sync.WaitGroup
+	for _, c := range clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.Close(websocket.StatusGoingAway, "server shutting down")
+		}()
+	}
+	wg.Wait()
+}
+
+// Accept the WebSocket handshake of the request. The handshake fails for
+// browsers on other sites than the request host and OriginPatterns, the
+// error response is written then.
+func (h *Hub) Accept(w http.ResponseWriter, r *http.Request) (*Client, error) {
+	h.mu.RLock()
+	closed := h.closed
+	h.mu.RUnlock()
+	if closed {
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return nil, ErrClientClosed
+	}
+	conn, err := websocket.Accept(w, r, &websocket.AcceptOptions{OriginPatterns: h.OriginPatterns})
+	if err != nil {
+		return nil, err
+	}
+	conn.SetReadLimit(h.MaxMessageSize)
+	ctx, cancel := context.WithCancel(r.Context())
+	c := &Client{
+		ID:     utils.NewULID(),
+		hub:    h,
+		conn:   conn,
+		ctx:    ctx,
+		cancel: cancel,
+		send:   make(chan Message, h.SendBuffer),
+		rooms:  make(map[string]struct{}),
+	}
+	h.mu.Lock()
+	h.clients[c] = struct{}{}
+	h.mu.Unlock()
+	metrics.WebSocketClients.Inc()
+	return c, nil
+}
+
+// Send the message to the clients in the room
+func (h *Hub) Broadcast(ctx context.Context, room string, m Message) error {
+	if h.Transport == nil {
+		h.deliver(broadcast{Room: room, Message: m})
+		return nil
+	}
+	payload, err := json.Marshal(broadcast{Room: room, Message: m})
+	if err != nil {
+		return err
+	}
+	return h.Transport.Publish(ctx, payload)
+}
+
+func (h *Hub) BroadcastJSON(ctx context.Context, room string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return h.Broadcast(ctx, room, Message{Data: data})
+}
+
+// Render the templ component once and send it to the clients in the room.
+// The HTMX ws extension swaps elements of the fragment by their IDs.
+func (h *Hub) BroadcastFragment(ctx context.Context, room string, component templ.Component) error {
+	var buf bytes.Buffer
+	if err := component.Render(ctx, &buf); err != nil {
+		return err
+	}
+	return h.Broadcast(ctx, room, Message{Data: buf.Bytes()})
+}
+
+func (h *Hub) receive(payload []byte) {
+	var b broadcast
+	if err := json.Unmarshal(payload, &b); err != nil {
+		slog.Warn("invalid websocket broadcast received", "error", err)
+		return
+	}
+	h.deliver(b)
+}
+
+func (h *Hub) deliver(b broadcast) {
+	h.mu.RLock()
+	clients := make([]*Client, 0, len(h.rooms[b.Room]))
+	for c := range h.rooms[b.Room] {
+		clients = append(clients, c)
+	}
+	h.mu.RUnlock()
+	for _, c := range clients {
+		c.Send(b.Message)
+	}
+}
+
+func (h *Hub) join(c *Client, room string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.clients[c]; !ok {
+		return
+	}
+	if h.rooms[room] == nil {
+		h.rooms[room] = make(map[*Client]struct{})
+	}
+	h.rooms[room][c] = struct{}{}
+}
+
+func (h *Hub) leave(c *Client, room string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.removeFromRoom(c, room)
+}
+
+// Must be called with the mutex held
+func (h *Hub) removeFromRoom(c *Client, room string) {
+	delete(h.rooms[room], c)
+	if len(h.rooms[room]) == 0 {
+		delete(h.rooms, room)
+	}
+}
+
+func (h *Hub) remove(c *Client, rooms []string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.clients[c]; !ok {
+		return
+	}
+	delete(h.clients, c)
+	for _, room := range rooms {
+		h.removeFromRoom(c, room)
+	}
+	metrics.WebSocketClients.Dec()
+}
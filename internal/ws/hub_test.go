@@ -0,0 +1,238 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/mcgtrt/go-puerto/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+// Transport shared by hubs of many instances in one process
+type testTransport struct {
+	mu   sync.Mutex
+	subs []func([]byte)
+}
+
+func (t *testTransport) Publish(ctx context.Context, payload []byte) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, fn := range t.subs {
+		fn(payload)
+	}
+	return nil
+}
+
+func (t *testTransport) Subscribe(ctx context.Context, fn func([]byte)) error {
+	t.mu.Lock()
+	t.subs = append(t.subs, fn)
+	t.mu.Unlock()
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (t *testTransport) subscribers() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.subs)
+}
+
+func newTestHub(transport Transport) *Hub {
+	hub := NewHub(transport, &utils.WebSocketConfig{MaxMessageSize: 64, SendBuffer: 8, PingInterval: time.Minute})
+	hub.Allow("public:", func(ctx context.Context, room string) bool { return true })
+	hub.Allow("public:secret", func(ctx context.Context, room string) bool { return false })
+	return hub
+}
+
+// Serve the hub and return the errors of finished clients
+func serve(t *testing.T, hub *Hub) (*httptest.Server, chan error) {
+	errs := make(chan error, 8)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client, err := hub.Accept(w, r)
+		if err != nil {
+			errs <- err
+			return
+		}
+		errs <- client.Run()
+	}))
+	t.Cleanup(srv.Close)
+	return srv, errs
+}
+
+func dial(t *testing.T, srv *httptest.Server) *websocket.Conn {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	conn, _, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	t.Cleanup(func() { conn.CloseNow() })
+	return conn
+}
+
+func read(t *testing.T, conn *websocket.Conn) (websocket.MessageType, string) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	typ, data, err := conn.Read(ctx)
+	assert.NoError(t, err)
+	return typ, string(data)
+}
+
+func write(t *testing.T, conn *websocket.Conn, v any) {
+	data, _ := json.Marshal(v)
+	assert.NoError(t, conn.Write(context.Background(), websocket.MessageText, data))
+}
+
+func subscribe(t *testing.T, conn *websocket.Conn, room string) string {
+	write(t, conn, control{Type: "subscribe", Room: room})
+	_, reply := read(t, conn)
+	return reply
+}
+
+func TestHub(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Authorises subscriptions", func(t *testing.T) {
+		hub := newTestHub(nil)
+		srv, _ := serve(t, hub)
+		conn := dial(t, srv)
+
+		assert.JSONEq(t, `{"type":"subscribed","room":"public:news"}`, subscribe(t, conn, "public:news"))
+		assert.JSONEq(t, `{"type":"error","room":"public:secret","error":"not allowed to join the room"}`, subscribe(t, conn, "public:secret"), "Expected the longest prefix decides")
+		assert.JSONEq(t, `{"type":"error","room":"private","error":"not allowed to join the room"}`, subscribe(t, conn, "private"), "Expected rooms without rules denied")
+	})
+
+	t.Run("Broadcasts to room members", func(t *testing.T) {
+		hub := newTestHub(nil)
+		srv, _ := serve(t, hub)
+		news, other := dial(t, srv), dial(t, srv)
+		subscribe(t, news, "public:news")
+		subscribe(t, other, "public:other")
+
+		assert.NoError(t, hub.BroadcastJSON(ctx, "public:news", map[string]string{"title": "hello"}))
+		assert.NoError(t, hub.Broadcast(ctx, "public:news", Message{Binary: true, Data: []byte{1, 2}}))
+		assert.NoError(t, hub.Broadcast(ctx, "public:other", Text("other")))
+
+		typ, data := read(t, news)
+		assert.Equal(t, websocket.MessageText, typ)
+		assert.JSONEq(t, `{"title":"hello"}`, data)
+		typ, data = read(t, news)
+		assert.Equal(t, websocket.MessageBinary, typ)
+		assert.Equal(t, "\x01\x02", data)
+		_, data = read(t, other)
+		assert.Equal(t, "other", data, "Expected other rooms get their messages only")
+
+		write(t, news, control{Type: "unsubscribe", Room: "public:news"})
+		read(t, news)
+		assert.NoError(t, hub.Broadcast(ctx, "public:news", Text("gone")))
+		assert.NoError(t, hub.Broadcast(ctx, "public:other", Text("other")))
+		_, data = read(t, other)
+		assert.Equal(t, "other", data)
+		assert.Empty(t, hub.rooms["public:news"], "Expected empty rooms removed")
+	})
+
+	t.Run("Fans out through the transport", func(t *testing.T) {
+		transport := &testTransport{}
+		a, b := newTestHub(transport), newTestHub(transport)
+		a.Start()
+		b.Start()
+		defer a.Close()
+		defer b.Close()
+		assert.Eventually(t, func() bool { return transport.subscribers() == 2 }, time.Second, time.Millisecond)
+		srv, _ := serve(t, b)
+		conn := dial(t, srv)
+		subscribe(t, conn, "public:news")
+
+		assert.NoError(t, a.Broadcast(ctx, "public:news", Text("from a")))
+		_, data := read(t, conn)
+		assert.Equal(t, "from a", data)
+		assert.NoError(t, conn.Close(websocket.StatusNormalClosure, ""))
+	})
+
+	t.Run("Passes other messages on", func(t *testing.T) {
+		hub := newTestHub(nil)
+		hub.OnMessage = func(c *Client, m Message) {
+			c.Send(m)
+		}
+		srv, _ := serve(t, hub)
+		conn := dial(t, srv)
+
+		write(t, conn, map[string]string{"message": "hi", "type": "chat"})
+		_, data := read(t, conn)
+		assert.JSONEq(t, `{"message":"hi","type":"chat"}`, data)
+		assert.NoError(t, conn.Write(ctx, websocket.MessageBinary, []byte{7}))
+		typ, data := read(t, conn)
+		assert.Equal(t, websocket.MessageBinary, typ)
+		assert.Equal(t, "\x07", data)
+	})
+
+	t.Run("Disconnects clients sending too large messages", func(t *testing.T) {
+		hub := newTestHub(nil)
+		srv, errs := serve(t, hub)
+		conn := dial(t, srv)
+
+		assert.NoError(t, conn.Write(ctx, websocket.MessageText, []byte(strings.Repeat("a", 65))))
+		_, _, err := conn.Read(ctx)
+		assert.Equal(t, websocket.StatusMessageTooBig, websocket.CloseStatus(err))
+		assert.Error(t, <-errs)
+	})
+
+	t.Run("Disconnects slow clients", func(t *testing.T) {
+		hub := newTestHub(nil)
+		hub.SendBuffer = 1
+		clients := make(chan *Client, 1)
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			client, err := hub.Accept(w, r)
+			if err != nil {
+				return
+			}
+			// Nothing writes the queued messages
+			client.Join("public:news")
+			clients <- client
+			<-client.Context().Done()
+		}))
+		defer srv.Close()
+		conn := dial(t, srv)
+		client := <-clients
+
+		assert.NoError(t, client.Send(Text("first")))
+		assert.ErrorIs(t, client.Send(Text("second")), ErrSlowConsumer)
+		_, _, err := conn.Read(ctx)
+		assert.Equal(t, websocket.StatusPolicyViolation, websocket.CloseStatus(err))
+		assert.ErrorIs(t, client.Send(Text("third")), ErrClientClosed)
+		assert.Eventually(t, func() bool {
+			hub.mu.RLock()
+			defer hub.mu.RUnlock()
+			return len(hub.clients) == 0 && len(hub.rooms) == 0
+		}, time.Second, time.Millisecond, "Expected client removed from the hub")
+	})
+
+	t.Run("Closes clients on shutdown", func(t *testing.T) {
+		hub := newTestHub(nil)
+		srv, errs := serve(t, hub)
+		conn := dial(t, srv)
+		subscribe(t, conn, "public:news")
+
+		done := make(chan struct{})
+		go func() {
+			hub.Close()
+			close(done)
+		}()
+		_, _, err := conn.Read(ctx)
+		assert.Equal(t, websocket.StatusGoingAway, websocket.CloseStatus(err))
+		<-done
+		assert.NoError(t, <-errs)
+
+		rec := httptest.NewRecorder()
+		_, err = hub.Accept(rec, httptest.NewRequest(http.MethodGet, "/ws", nil))
+		assert.Error(t, err)
+		assert.Equal(t, http.StatusServiceUnavailable, rec.Code, "Expected new clients refused")
+	})
+}
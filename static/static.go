@@ -0,0 +1,9 @@
+package static
+
+import "embed"
+
+// Files of the static directory embedded into the binary, so the server
+// doesn't depend on the working directory it's started from
+//
+//go:embed *
+var FS embed.FS
@@ -0,0 +1,170 @@
+// Progress of the upload forms of templates/layout/upload.templ. HTMX
+// reports the progress of multipart uploads. Forms with data-resumable
+// send files in chunks instead and resume them after network failures.
+(function () {
+	"use strict";
+
+	const RETRIES = 5;
+
+	function uploadForm(elt) {
+		return elt instanceof Element ? elt.closest("form.upload") : null;
+	}
+
+	function setProgress(form, loaded, total) {
+		const progress = form.querySelector(".upload-progress");
+		progress.hidden = false;
+		progress.value = total > 0 ? Math.round((loaded / total) * 100) : 0;
+	}
+
+	function finish(form, message) {
+		const progress = form.querySelector(".upload-progress");
+		progress.hidden = true;
+		progress.value = 0;
+		form.querySelector(".upload-status").textContent = message || "";
+	}
+
+	// Detail of a problem+json response
+	function problem(text) {
+		try {
+			const p = JSON.parse(text);
+			return p.detail || p.title;
+		} catch (err) {
+			return "";
+		}
+	}
+
+	document.addEventListener("htmx:beforeRequest", function (e) {
+		const form = uploadForm(e.target);
+		if (form) {
+			setProgress(form, 0, 0);
+			form.querySelector(".upload-status").textContent = "";
+		}
+	});
+
+	document.addEventListener("htmx:xhr:progress", function (e) {
+		const form = uploadForm(e.target);
+		if (form && e.detail.lengthComputable) {
+			setProgress(form, e.detail.loaded, e.detail.total);
+		}
+	});
+
+	document.addEventListener("htmx:afterRequest", function (e) {
+		const form = uploadForm(e.target);
+		if (!form) {
+			return;
+		}
+		if (e.detail.successful) {
+			form.reset();
+			finish(form);
+			return;
+		}
+		finish(form, problem(e.detail.xhr.responseText) || "Upload failed.");
+	});
+
+	// Runs before the listener HTMX puts on the form
+	document.addEventListener("submit", function (e) {
+		const form = e.target;
+		if (!(form instanceof HTMLFormElement) || !form.matches("form.upload[data-resumable]")) {
+			return;
+		}
+		e.preventDefault();
+		e.stopPropagation();
+		uploadFiles(form);
+	}, true);
+
+	async function uploadFiles(form) {
+		const files = Array.from(form.querySelector("input[type=file]").files);
+		const target = document.querySelector(form.getAttribute("hx-target"));
+		const total = files.reduce((size, file) => size + file.size, 0);
+		let sent = 0;
+		setProgress(form, 0, total);
+		form.querySelector(".upload-status").textContent = "";
+		try {
+			for (const file of files) {
+				const html = await uploadFile(form.dataset.resumable, file, (offset) => setProgress(form, sent + offset, total));
+				sent += file.size;
+				if (target) {
+					target.insertAdjacentHTML("beforeend", html);
+					if (window.htmx) {
+						window.htmx.process(target);
+					}
+				}
+			}
+			form.reset();
+			finish(form);
+		} catch (err) {
+			finish(form, err.message);
+		}
+	}
+
+	function headers(extra) {
+		const meta = document.querySelector('meta[name="csrf-token"]');
+		return meta ? Object.assign({ "X-CSRF-Token": meta.content }, extra) : extra;
+	}
+
+	async function failure(res) {
+		return new Error(problem(await res.text()) || "Upload failed.");
+	}
+
+	// Send the file and return the HTML of the stored file
+	async function uploadFile(startURL, file, onProgress) {
+		let res = await fetch(startURL, {
+			method: "POST",
+			headers: headers({ "Content-Type": "application/json", "Accept": "application/json" }),
+			body: JSON.stringify({ filename: file.name, size: file.size }),
+		});
+		if (!res.ok) {
+			throw await failure(res);
+		}
+		const url = res.headers.get("Location");
+		const chunkSize = Number(res.headers.get("Upload-Chunk-Size"));
+		let offset = 0;
+		let retries = 0;
+		for (;;) {
+			try {
+				res = await fetch(url, {
+					method: "PATCH",
+					headers: headers({
+						"Upload-Offset": String(offset),
+						"Content-Type": "application/offset+octet-stream",
+						"Accept": "text/html",
+					}),
+					body: file.slice(offset, offset + chunkSize),
+				});
+			} catch (err) {
+				if (++retries > RETRIES) {
+					throw new Error("Upload failed, check your connection.");
+				}
+				await new Promise((resolve) => setTimeout(resolve, retries * 1000));
+				offset = await uploadedOffset(url, offset);
+				continue;
+			}
+			if (res.status === 201) {
+				onProgress(file.size);
+				return res.text();
+			}
+			if (res.status === 204 || (res.status === 409 && ++retries <= RETRIES)) {
+				if (res.status === 204) {
+					retries = 0;
+				}
+				offset = Number(res.headers.get("Upload-Offset"));
+				onProgress(offset);
+				continue;
+			}
+			throw await failure(res);
+		}
+	}
+
+	// Offset the server has got, the last known one while it's unreachable
+	async function uploadedOffset(url, offset) {
+		try {
+			const res = await fetch(url, { method: "HEAD", headers: headers({}) });
+			if (res.ok) {
+				return Number(res.headers.get("Upload-Offset"));
+			}
+		} catch (err) {
+			// Tried again with the next chunk
+		}
+		return offset;
+	}
+})();
@@ -0,0 +1,73 @@
+package mongo_store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/mcgtrt/go-puerto/internal/apikeys"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const API_KEY_COLLECTION = "api_keys"
+
+// API key store keeping keys in the api_keys collection
+type APIKeyStore struct {
+	keys *mongo.Collection
+}
+
+// Create API key store and make sure its indexes exist
+func NewAPIKeyStore(ctx context.Context, store *MongoStore) (*APIKeyStore, error) {
+	s := &APIKeyStore{keys: store.Client.Database(store.DBName).Collection(API_KEY_COLLECTION)}
+	_, err := s.keys.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}}})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *APIKeyStore) CreateKey(ctx context.Context, k *apikeys.Key) error {
+	_, err := s.keys.InsertOne(ctx, k)
+	return err
+}
+
+func (s *APIKeyStore) GetKey(ctx context.Context, id string) (*apikeys.Key, error) {
+	k := &apikeys.Key{}
+	if err := s.keys.FindOne(ctx, bson.M{"_id": id}).Decode(k); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, apikeys.ErrKeyNotFound
+		}
+		return nil, err
+	}
+	return k, nil
+}
+
+func (s *APIKeyStore) ListKeys(ctx context.Context, userID string) ([]apikeys.Key, error) {
+	cursor, err := s.keys.Find(ctx, bson.M{"user_id": userID}, options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}))
+	if err != nil {
+		return nil, err
+	}
+	keys := []apikeys.Key{}
+	if err := cursor.All(ctx, &keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (s *APIKeyStore) DeleteKey(ctx context.Context, userID, id string) error {
+	res, err := s.keys.DeleteOne(ctx, bson.M{"_id": id, "user_id": userID})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return apikeys.ErrKeyNotFound
+	}
+	return nil
+}
+
+func (s *APIKeyStore) TouchKey(ctx context.Context, id string, at time.Time) error {
+	_, err := s.keys.UpdateByID(ctx, id, bson.M{"$set": bson.M{"last_used_at": at}})
+	return err
+}
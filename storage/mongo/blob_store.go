@@ -0,0 +1,118 @@
+package mongo_store
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/mcgtrt/go-puerto/internal/uploads"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// GridFS bucket of the blobs, kept in blobs.files and blobs.chunks
+const BLOB_BUCKET = "blobs"
+
+type blobFile struct {
+	Key        string    `bson:"_id"`
+	Length     int64     `bson:"length"`
+	UploadDate time.Time `bson:"uploadDate"`
+	Filename   string    `bson:"filename"`
+	Metadata   struct {
+		ContentType string `bson:"content_type"`
+	} `bson:"metadata"`
+}
+
+func (f *blobFile) object() *uploads.Object {
+	return &uploads.Object{
+		Key:         f.Key,
+		Size:        f.Length,
+		ContentType: f.Metadata.ContentType,
+		Filename:    f.Filename,
+		CreatedAt:   f.UploadDate.UTC(),
+	}
+}
+
+// Blob store keeping files in GridFS with their keys as IDs. GridFS can't
+// replace a file in place, so replacing a blob deletes the old one first.
+type BlobStore struct {
+	bucket *gridfs.Bucket
+}
+
+func NewBlobStore(store *MongoStore) (*BlobStore, error) {
+	db := store.Client.Database(store.DBName)
+	bucket, err := gridfs.NewBucket(db, options.GridFSBucket().SetName(BLOB_BUCKET))
+	if err != nil {
+		return nil, err
+	}
+	return &BlobStore{bucket: bucket}, nil
+}
+
+func (s *BlobStore) Put(ctx context.Context, o *uploads.Object, r io.Reader) error {
+	if !uploads.ValidKey(o.Key) {
+		return uploads.ErrInvalidKey
+	}
+	if err := s.Delete(ctx, o.Key); err != nil {
+		return err
+	}
+	opts := options.GridFSUpload().SetMetadata(bson.M{"content_type": o.ContentType})
+	stream, err := s.bucket.OpenUploadStreamWithID(o.Key, o.Filename, opts)
+	if err != nil {
+		return err
+	}
+	// GridFS takes deadlines instead of contexts
+	if deadline, ok := ctx.Deadline(); ok {
+		stream.SetWriteDeadline(deadline)
+	}
+	size, err := io.Copy(stream, r)
+	if err != nil {
+		stream.Abort()
+		return err
+	}
+	if err := stream.Close(); err != nil {
+		return err
+	}
+	o.Size, o.CreatedAt = size, time.Now().UTC()
+	return nil
+}
+
+func (s *BlobStore) Get(ctx context.Context, key string) (io.ReadCloser, *uploads.Object, error) {
+	o, err := s.Stat(ctx, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	stream, err := s.bucket.OpenDownloadStream(key)
+	if errors.Is(err, gridfs.ErrFileNotFound) {
+		return nil, nil, uploads.ErrBlobNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		stream.SetReadDeadline(deadline)
+	}
+	return stream, o, nil
+}
+
+func (s *BlobStore) Stat(ctx context.Context, key string) (*uploads.Object, error) {
+	var f blobFile
+	err := s.bucket.GetFilesCollection().FindOne(ctx, bson.M{"_id": key}).Decode(&f)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, uploads.ErrBlobNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return f.object(), nil
+}
+
+func (s *BlobStore) Delete(ctx context.Context, key string) error {
+	err := s.bucket.DeleteContext(ctx, key)
+	if errors.Is(err, gridfs.ErrFileNotFound) {
+		return nil
+	}
+	return err
+}
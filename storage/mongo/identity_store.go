@@ -0,0 +1,72 @@
+package mongo_store
+
+import (
+	"context"
+	"errors"
+
+	"github.com/mcgtrt/go-puerto/internal/oidc"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const IDENTITY_COLLECTION = "user_identities"
+
+// Identity store keeping linked provider identities in the
+// user_identities collection (unique by provider and subject)
+type IdentityStore struct {
+	identities *mongo.Collection
+}
+
+// Create identity store and make sure its indexes exist
+func NewIdentityStore(ctx context.Context, store *MongoStore) (*IdentityStore, error) {
+	s := &IdentityStore{identities: store.Client.Database(store.DBName).Collection(IDENTITY_COLLECTION)}
+	_, err := s.identities.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "provider", Value: 1}, {Key: "subject", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{Key: "user_id", Value: 1}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *IdentityStore) GetIdentity(ctx context.Context, provider, subject string) (*oidc.Identity, error) {
+	var i oidc.Identity
+	err := s.identities.FindOne(ctx, bson.M{"provider": provider, "subject": subject}).Decode(&i)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, oidc.ErrIdentityNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &i, nil
+}
+
+func (s *IdentityStore) CreateIdentity(ctx context.Context, i *oidc.Identity) error {
+	_, err := s.identities.InsertOne(ctx, i)
+	if mongo.IsDuplicateKeyError(err) {
+		return oidc.ErrIdentityLinked
+	}
+	return err
+}
+
+func (s *IdentityStore) ListIdentities(ctx context.Context, userID string) ([]oidc.Identity, error) {
+	cursor, err := s.identities.Find(ctx, bson.M{"user_id": userID}, options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	identities := []oidc.Identity{}
+	if err := cursor.All(ctx, &identities); err != nil {
+		return nil, err
+	}
+	return identities, nil
+}
+
+func (s *IdentityStore) DeleteIdentity(ctx context.Context, provider, subject string) error {
+	_, err := s.identities.DeleteOne(ctx, bson.M{"provider": provider, "subject": subject})
+	return err
+}
@@ -0,0 +1,115 @@
+package mongo_store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/mcgtrt/go-puerto/internal/magiclink"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	MAGIC_LINK_COLLECTION         = "magic_links"
+	MAGIC_LINK_REQUEST_COLLECTION = "magic_link_requests"
+)
+
+type magicLinkRequests struct {
+	Email     string    `bson:"_id"`
+	Count     int       `bson:"count"`
+	ExpiresAt time.Time `bson:"expires_at"`
+}
+
+// Magic link store keeping login links in magic_links and requests per
+// address in magic_link_requests, both removed by TTL indexes
+type MagicLinkStore struct {
+	links    *mongo.Collection
+	requests *mongo.Collection
+}
+
+// Create magic link store and make sure its indexes exist
+func NewMagicLinkStore(ctx context.Context, store *MongoStore) (*MagicLinkStore, error) {
+	db := store.Client.Database(store.DBName)
+	s := &MagicLinkStore{
+		links:    db.Collection(MAGIC_LINK_COLLECTION),
+		requests: db.Collection(MAGIC_LINK_REQUEST_COLLECTION),
+	}
+	_, err := s.links.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "token_hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "browser_hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	_, err = s.requests.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *MagicLinkStore) CreateLink(ctx context.Context, l *magiclink.Link) error {
+	_, err := s.links.InsertOne(ctx, l)
+	return err
+}
+
+// Expiry is checked in the filters as the TTL index removes expired
+// documents only once a minute
+func (s *MagicLinkStore) GetLinkByToken(ctx context.Context, tokenHash string) (*magiclink.Link, error) {
+	return s.get(s.links.FindOne(ctx, bson.M{"token_hash": tokenHash, "expires_at": bson.M{"$gt": time.Now()}}))
+}
+
+func (s *MagicLinkStore) GetLinkByBrowser(ctx context.Context, browserHash string) (*magiclink.Link, error) {
+	return s.get(s.links.FindOne(ctx, bson.M{"browser_hash": browserHash, "expires_at": bson.M{"$gt": time.Now()}}))
+}
+
+func (s *MagicLinkStore) ConfirmLink(ctx context.Context, tokenHash string, at time.Time) (*magiclink.Link, error) {
+	filter := bson.M{
+		"token_hash":   tokenHash,
+		"confirmed_at": bson.M{"$exists": false},
+		"expires_at":   bson.M{"$gt": at},
+	}
+	return s.get(s.links.FindOneAndUpdate(ctx, filter, bson.M{"$set": bson.M{"confirmed_at": at}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	))
+}
+
+func (s *MagicLinkStore) DeleteLink(ctx context.Context, id string) (bool, error) {
+	res, err := s.links.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return false, err
+	}
+	return res.DeletedCount == 1, nil
+}
+
+// The request window restarts atomically when the stored one has passed
+func (s *MagicLinkStore) RecordRequest(ctx context.Context, email string, window time.Duration) (int, error) {
+	now := time.Now()
+	active := bson.M{"$gt": bson.A{"$expires_at", now}}
+	update := mongo.Pipeline{{{Key: "$set", Value: bson.M{
+		"count":      bson.M{"$cond": bson.A{active, bson.M{"$add": bson.A{"$count", 1}}, 1}},
+		"expires_at": bson.M{"$cond": bson.A{active, "$expires_at", now.Add(window)}},
+	}}}}
+	var r magicLinkRequests
+	err := s.requests.FindOneAndUpdate(ctx, bson.M{"_id": email}, update,
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&r)
+	return r.Count, err
+}
+
+func (s *MagicLinkStore) get(res *mongo.SingleResult) (*magiclink.Link, error) {
+	l := &magiclink.Link{}
+	if err := res.Decode(l); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, magiclink.ErrLinkNotFound
+		}
+		return nil, err
+	}
+	return l, nil
+}
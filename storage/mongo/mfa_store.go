@@ -0,0 +1,174 @@
+package mongo_store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/mcgtrt/go-puerto/internal/mfa"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	MFA_FACTOR_COLLECTION        = "mfa_factors"
+	MFA_RECOVERY_CODE_COLLECTION = "mfa_recovery_codes"
+	MFA_FAILURE_COLLECTION       = "mfa_failures"
+)
+
+type recoveryCode struct {
+	UserID string `bson:"user_id"`
+	Hash   string `bson:"hash"`
+}
+
+type mfaFailures struct {
+	UserID    string    `bson:"_id"`
+	Count     int       `bson:"count"`
+	ExpiresAt time.Time `bson:"expires_at"`
+}
+
+// MFA store keeping second factors in mfa_factors, hashed recovery codes
+// in mfa_recovery_codes and failed verifications in mfa_failures removed
+// by a TTL index
+type MFAStore struct {
+	factors  *mongo.Collection
+	codes    *mongo.Collection
+	failures *mongo.Collection
+}
+
+// Create MFA store and make sure its indexes exist
+func NewMFAStore(ctx context.Context, store *MongoStore) (*MFAStore, error) {
+	db := store.Client.Database(store.DBName)
+	s := &MFAStore{
+		factors:  db.Collection(MFA_FACTOR_COLLECTION),
+		codes:    db.Collection(MFA_RECOVERY_CODE_COLLECTION),
+		failures: db.Collection(MFA_FAILURE_COLLECTION),
+	}
+	_, err := s.factors.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: bson.D{{Key: "user_id", Value: 1}}})
+	if err != nil {
+		return nil, err
+	}
+	_, err = s.codes.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "hash", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	_, err = s.failures.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *MFAStore) CreateFactor(ctx context.Context, f *mfa.Factor) error {
+	_, err := s.factors.InsertOne(ctx, f)
+	return err
+}
+
+func (s *MFAStore) ListFactors(ctx context.Context, userID string) ([]mfa.Factor, error) {
+	cursor, err := s.factors.Find(ctx, bson.M{"user_id": userID}, options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	factors := []mfa.Factor{}
+	if err := cursor.All(ctx, &factors); err != nil {
+		return nil, err
+	}
+	return factors, nil
+}
+
+func (s *MFAStore) ConfirmFactor(ctx context.Context, id string, at time.Time) error {
+	res, err := s.factors.UpdateByID(ctx, id, bson.M{"$set": bson.M{"confirmed_at": at}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return mfa.ErrFactorNotFound
+	}
+	return nil
+}
+
+func (s *MFAStore) UpdateCounter(ctx context.Context, id string, old, new int64) (bool, error) {
+	res, err := s.factors.UpdateOne(ctx, bson.M{"_id": id, "counter": old}, bson.M{"$set": bson.M{"counter": new}})
+	if err != nil {
+		return false, err
+	}
+	return res.ModifiedCount == 1, nil
+}
+
+func (s *MFAStore) DeleteFactor(ctx context.Context, id string) error {
+	_, err := s.factors.DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
+
+func (s *MFAStore) DeleteUserFactors(ctx context.Context, userID string) error {
+	if _, err := s.factors.DeleteMany(ctx, bson.M{"user_id": userID}); err != nil {
+		return err
+	}
+	_, err := s.codes.DeleteMany(ctx, bson.M{"user_id": userID})
+	return err
+}
+
+func (s *MFAStore) ReplaceRecoveryCodes(ctx context.Context, userID string, hashes []string) error {
+	if _, err := s.codes.DeleteMany(ctx, bson.M{"user_id": userID}); err != nil {
+		return err
+	}
+	codes := make([]any, len(hashes))
+	for i, hash := range hashes {
+		codes[i] = recoveryCode{UserID: userID, Hash: hash}
+	}
+	_, err := s.codes.InsertMany(ctx, codes)
+	return err
+}
+
+func (s *MFAStore) UseRecoveryCode(ctx context.Context, userID, hash string) error {
+	res, err := s.codes.DeleteOne(ctx, bson.M{"user_id": userID, "hash": hash})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return mfa.ErrRecoveryCodeNotFound
+	}
+	return nil
+}
+
+func (s *MFAStore) CountRecoveryCodes(ctx context.Context, userID string) (int, error) {
+	count, err := s.codes.CountDocuments(ctx, bson.M{"user_id": userID})
+	return int(count), err
+}
+
+// The failure window restarts atomically when the stored one has passed
+// (the TTL index removes expired documents only once a minute)
+func (s *MFAStore) RecordFailure(ctx context.Context, userID string, window time.Duration) (int, error) {
+	now := time.Now()
+	active := bson.M{"$gt": bson.A{"$expires_at", now}}
+	update := mongo.Pipeline{{{Key: "$set", Value: bson.M{
+		"count":      bson.M{"$cond": bson.A{active, bson.M{"$add": bson.A{"$count", 1}}, 1}},
+		"expires_at": bson.M{"$cond": bson.A{active, "$expires_at", now.Add(window)}},
+	}}}}
+	var f mfaFailures
+	err := s.failures.FindOneAndUpdate(ctx, bson.M{"_id": userID}, update,
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&f)
+	return f.Count, err
+}
+
+func (s *MFAStore) Failures(ctx context.Context, userID string) (int, error) {
+	var f mfaFailures
+	err := s.failures.FindOne(ctx, bson.M{"_id": userID, "expires_at": bson.M{"$gt": time.Now()}}).Decode(&f)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return 0, nil
+	}
+	return f.Count, err
+}
+
+func (s *MFAStore) ResetFailures(ctx context.Context, userID string) error {
+	_, err := s.failures.DeleteOne(ctx, bson.M{"_id": userID})
+	return err
+}
@@ -0,0 +1,72 @@
+package mongo_store
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/mcgtrt/go-puerto/internal/metrics"
+	"github.com/mcgtrt/go-puerto/internal/tracing"
+	"go.mongodb.org/mongo-driver/event"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Client options with command and pool monitors that feed the project
+// metrics and traces. Pass them to the mongo config when creating the client.
+func MonitorOptions() *options.ClientOptions {
+	return options.Client().
+		SetMonitor(newCommandMonitor()).
+		SetPoolMonitor(newPoolMonitor())
+}
+
+func newCommandMonitor() *event.CommandMonitor {
+	// Spans are started and finished in separate callbacks, so they are
+	// kept by the driver's request ID in between
+	var spans sync.Map
+
+	return &event.CommandMonitor{
+		Started: func(ctx context.Context, evt *event.CommandStartedEvent) {
+			_, span := tracing.Tracer().Start(ctx, "mongo."+evt.CommandName,
+				trace.WithSpanKind(trace.SpanKindClient),
+				trace.WithAttributes(
+					semconv.DBSystemMongoDB,
+					semconv.DBNamespace(evt.DatabaseName),
+					semconv.DBOperationName(evt.CommandName),
+				),
+			)
+			spans.Store(evt.RequestID, span)
+		},
+		Succeeded: func(_ context.Context, evt *event.CommandSucceededEvent) {
+			metrics.MongoCommandDuration.WithLabelValues(evt.CommandName, "ok").Observe(evt.Duration.Seconds())
+			if span, ok := spans.LoadAndDelete(evt.RequestID); ok {
+				span.(trace.Span).End()
+			}
+		},
+		Failed: func(_ context.Context, evt *event.CommandFailedEvent) {
+			metrics.MongoCommandDuration.WithLabelValues(evt.CommandName, "error").Observe(evt.Duration.Seconds())
+			if span, ok := spans.LoadAndDelete(evt.RequestID); ok {
+				tracing.RecordError(span.(trace.Span), errors.New(evt.Failure))
+				span.(trace.Span).End()
+			}
+		},
+	}
+}
+
+func newPoolMonitor() *event.PoolMonitor {
+	return &event.PoolMonitor{
+		Event: func(evt *event.PoolEvent) {
+			switch evt.Type {
+			case event.ConnectionCreated:
+				metrics.MongoPoolConnections.WithLabelValues("total").Inc()
+			case event.ConnectionClosed:
+				metrics.MongoPoolConnections.WithLabelValues("total").Dec()
+			case event.GetSucceeded:
+				metrics.MongoPoolConnections.WithLabelValues("in_use").Inc()
+			case event.ConnectionReturned:
+				metrics.MongoPoolConnections.WithLabelValues("in_use").Dec()
+			}
+		},
+	}
+}
@@ -0,0 +1,136 @@
+package mongo_store
+
+import (
+	"context"
+	"errors"
+
+	"github.com/mcgtrt/go-puerto/internal/authz"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	ROLE_COLLECTION            = "roles"
+	ROLE_ASSIGNMENT_COLLECTION = "role_assignments"
+)
+
+type roleAssignment struct {
+	UserID string `bson:"user_id"`
+	Role   string `bson:"role"`
+}
+
+// Policy store keeping roles in the roles collection and user roles in
+// role_assignments (unique by user and role)
+type PolicyStore struct {
+	roles       *mongo.Collection
+	assignments *mongo.Collection
+}
+
+// Create policy store and make sure its indexes exist
+func NewPolicyStore(ctx context.Context, store *MongoStore) (*PolicyStore, error) {
+	db := store.Client.Database(store.DBName)
+	s := &PolicyStore{
+		roles:       db.Collection(ROLE_COLLECTION),
+		assignments: db.Collection(ROLE_ASSIGNMENT_COLLECTION),
+	}
+	_, err := s.assignments.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "role", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{Key: "role", Value: 1}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *PolicyStore) SaveRole(ctx context.Context, r *authz.Role) error {
+	_, err := s.roles.ReplaceOne(ctx, bson.M{"_id": r.Name}, r, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (s *PolicyStore) GetRole(ctx context.Context, name string) (*authz.Role, error) {
+	var r authz.Role
+	err := s.roles.FindOne(ctx, bson.M{"_id": name}).Decode(&r)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, authz.ErrRoleNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+func (s *PolicyStore) ListRoles(ctx context.Context) ([]authz.Role, error) {
+	cursor, err := s.roles.Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	roles := []authz.Role{}
+	if err := cursor.All(ctx, &roles); err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+func (s *PolicyStore) DeleteRole(ctx context.Context, name string) error {
+	if _, err := s.assignments.DeleteMany(ctx, bson.M{"role": name}); err != nil {
+		return err
+	}
+	_, err := s.roles.DeleteOne(ctx, bson.M{"_id": name})
+	return err
+}
+
+func (s *PolicyStore) AssignRole(ctx context.Context, userID, role string) error {
+	if _, err := s.GetRole(ctx, role); err != nil {
+		return err
+	}
+	_, err := s.assignments.UpdateOne(ctx,
+		bson.M{"user_id": userID, "role": role},
+		bson.M{"$setOnInsert": roleAssignment{UserID: userID, Role: role}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+func (s *PolicyStore) UnassignRole(ctx context.Context, userID, role string) error {
+	_, err := s.assignments.DeleteOne(ctx, bson.M{"user_id": userID, "role": role})
+	return err
+}
+
+func (s *PolicyStore) UserRoles(ctx context.Context, userID string) ([]string, error) {
+	cursor, err := s.assignments.Find(ctx, bson.M{"user_id": userID})
+	if err != nil {
+		return nil, err
+	}
+	var assignments []roleAssignment
+	if err := cursor.All(ctx, &assignments); err != nil {
+		return nil, err
+	}
+	roles := make([]string, 0, len(assignments))
+	for _, a := range assignments {
+		roles = append(roles, a.Role)
+	}
+	return roles, nil
+}
+
+func (s *PolicyStore) UserPermissions(ctx context.Context, userID string) ([]string, error) {
+	roles, err := s.UserRoles(ctx, userID)
+	if err != nil || len(roles) == 0 {
+		return nil, err
+	}
+	values, err := s.roles.Distinct(ctx, "permissions", bson.M{"_id": bson.M{"$in": roles}})
+	if err != nil {
+		return nil, err
+	}
+	permissions := make([]string, 0, len(values))
+	for _, v := range values {
+		if p, ok := v.(string); ok {
+			permissions = append(permissions, p)
+		}
+	}
+	return permissions, nil
+}
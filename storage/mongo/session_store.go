@@ -0,0 +1,82 @@
+package mongo_store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/mcgtrt/go-puerto/internal/session"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const SESSION_COLLECTION = "sessions"
+
+type sessionDocument struct {
+	ID        string    `bson:"_id"`
+	UserID    string    `bson:"user_id,omitempty"`
+	Data      []byte    `bson:"data"`
+	ExpiresAt time.Time `bson:"expires_at"`
+}
+
+// Session store keeping sessions in the sessions collection. Expired
+// documents are removed by a TTL index.
+type SessionStore struct {
+	coll *mongo.Collection
+}
+
+// Create session store and make sure its indexes exist
+func NewSessionStore(ctx context.Context, store *MongoStore) (*SessionStore, error) {
+	coll := store.Client.Database(store.DBName).Collection(SESSION_COLLECTION)
+	_, err := coll.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+		{Keys: bson.D{{Key: "user_id", Value: 1}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &SessionStore{coll: coll}, nil
+}
+
+func (s *SessionStore) Load(ctx context.Context, token string) (*session.Session, error) {
+	var doc sessionDocument
+	// TTL monitor runs once a minute, so expiry is checked on read as well
+	filter := bson.M{"_id": token, "expires_at": bson.M{"$gt": time.Now()}}
+	err := s.coll.FindOne(ctx, filter).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, session.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return session.Decode(doc.Data)
+}
+
+func (s *SessionStore) Save(ctx context.Context, sess *session.Session, ttl time.Duration) (string, error) {
+	data, err := session.Encode(sess)
+	if err != nil {
+		return "", err
+	}
+	doc := sessionDocument{
+		ID:        sess.ID,
+		UserID:    sess.UserID,
+		Data:      data,
+		ExpiresAt: time.Now().Add(ttl),
+	}
+	_, err = s.coll.ReplaceOne(ctx, bson.M{"_id": sess.ID}, doc, options.Replace().SetUpsert(true))
+	if err != nil {
+		return "", err
+	}
+	return sess.ID, nil
+}
+
+func (s *SessionStore) Delete(ctx context.Context, id string) error {
+	_, err := s.coll.DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
+
+func (s *SessionStore) DeleteByUser(ctx context.Context, userID string) error {
+	_, err := s.coll.DeleteMany(ctx, bson.M{"user_id": userID})
+	return err
+}
@@ -0,0 +1,143 @@
+package mongo_store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/mcgtrt/go-puerto/internal/accounts"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	USER_COLLECTION          = "users"
+	ACCOUNT_TOKEN_COLLECTION = "account_tokens"
+)
+
+// User store keeping users in the users collection (unique by email) and
+// their single-use tokens in account_tokens removed by a TTL index
+type UserStore struct {
+	users  *mongo.Collection
+	tokens *mongo.Collection
+}
+
+// Create user store and make sure its indexes exist
+func NewUserStore(ctx context.Context, store *MongoStore) (*UserStore, error) {
+	db := store.Client.Database(store.DBName)
+	s := &UserStore{
+		users:  db.Collection(USER_COLLECTION),
+		tokens: db.Collection(ACCOUNT_TOKEN_COLLECTION),
+	}
+	_, err := s.users.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "email", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	_, err = s.tokens.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *UserStore) CreateUser(ctx context.Context, u *accounts.User) error {
+	_, err := s.users.InsertOne(ctx, u)
+	if mongo.IsDuplicateKeyError(err) {
+		return accounts.ErrEmailTaken
+	}
+	return err
+}
+
+func (s *UserStore) GetUserByID(ctx context.Context, id string) (*accounts.User, error) {
+	return s.findUser(ctx, bson.M{"_id": id})
+}
+
+func (s *UserStore) GetUserByEmail(ctx context.Context, email string) (*accounts.User, error) {
+	return s.findUser(ctx, bson.M{"email": email})
+}
+
+func (s *UserStore) findUser(ctx context.Context, filter bson.M) (*accounts.User, error) {
+	var u accounts.User
+	err := s.users.FindOne(ctx, filter).Decode(&u)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, accounts.ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+func (s *UserStore) UpdateUser(ctx context.Context, u *accounts.User) error {
+	res, err := s.users.ReplaceOne(ctx, bson.M{"_id": u.ID}, u)
+	if mongo.IsDuplicateKeyError(err) {
+		return accounts.ErrEmailTaken
+	}
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return accounts.ErrUserNotFound
+	}
+	return nil
+}
+
+// The count is incremented by an update pipeline, so concurrent failures
+// can't overwrite each other's increments. Expressions of the $set stage
+// see the old count.
+func (s *UserStore) RecordFailedLogin(ctx context.Context, id string, max int, lockedUntil, now time.Time) (bool, error) {
+	count := bson.D{{Key: "$add", Value: bson.A{"$failed_logins", 1}}}
+	reached := bson.D{{Key: "$gte", Value: bson.A{count, max}}}
+	update := mongo.Pipeline{{{Key: "$set", Value: bson.D{
+		{Key: "failed_logins", Value: bson.D{{Key: "$cond", Value: bson.A{reached, 0, count}}}},
+		{Key: "locked_until", Value: bson.D{{Key: "$cond", Value: bson.A{reached, lockedUntil, "$locked_until"}}}},
+		{Key: "updated_at", Value: now},
+	}}}}
+	var u accounts.User
+	err := s.users.FindOneAndUpdate(ctx, bson.M{"_id": id}, update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&u)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return false, accounts.ErrUserNotFound
+	}
+	if err != nil {
+		return false, err
+	}
+	return u.LockedUntil.Equal(lockedUntil), nil
+}
+
+func (s *UserStore) ResetFailedLogins(ctx context.Context, id string, now time.Time) error {
+	res, err := s.users.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"failed_logins": 0, "updated_at": now}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return accounts.ErrUserNotFound
+	}
+	return nil
+}
+
+func (s *UserStore) CreateToken(ctx context.Context, t *accounts.Token) error {
+	_, err := s.tokens.InsertOne(ctx, t)
+	return err
+}
+
+func (s *UserStore) ConsumeToken(ctx context.Context, purpose, hash string) (*accounts.Token, error) {
+	var t accounts.Token
+	filter := bson.M{"_id": hash, "purpose": purpose, "expires_at": bson.M{"$gt": time.Now()}}
+	err := s.tokens.FindOneAndDelete(ctx, filter).Decode(&t)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, accounts.ErrTokenNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
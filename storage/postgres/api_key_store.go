@@ -0,0 +1,92 @@
+package postgres_store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/mcgtrt/go-puerto/internal/apikeys"
+)
+
+const createAPIKeyTable = `
+CREATE TABLE IF NOT EXISTS api_keys (
+	id           TEXT PRIMARY KEY,
+	user_id      TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	name         TEXT NOT NULL,
+	secret_hash  TEXT NOT NULL,
+	scopes       TEXT[] NOT NULL,
+	policy       TEXT NOT NULL,
+	expires_at   TIMESTAMPTZ,
+	last_used_at TIMESTAMPTZ,
+	created_at   TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX IF NOT EXISTS api_keys_user_id_idx ON api_keys (user_id);`
+
+const apiKeyColumns = `id, user_id, name, secret_hash, scopes, policy, expires_at, last_used_at, created_at`
+
+// API key store keeping keys in the api_keys table, which are removed
+// together with their user. Requires the users table of the user store.
+type APIKeyStore struct {
+	store *PostgresStore
+}
+
+// Create API key store and make sure its table exists
+func NewAPIKeyStore(ctx context.Context, store *PostgresStore) (*APIKeyStore, error) {
+	if _, err := store.Pool.Exec(ctx, createAPIKeyTable); err != nil {
+		return nil, err
+	}
+	return &APIKeyStore{store: store}, nil
+}
+
+func (s *APIKeyStore) CreateKey(ctx context.Context, k *apikeys.Key) error {
+	_, err := s.store.Pool.Exec(ctx, `INSERT INTO api_keys (`+apiKeyColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		k.ID, k.UserID, k.Name, k.SecretHash, k.Scopes, k.Policy, k.ExpiresAt, k.LastUsedAt, k.CreatedAt,
+	)
+	return err
+}
+
+func (s *APIKeyStore) GetKey(ctx context.Context, id string) (*apikeys.Key, error) {
+	rows, err := s.store.Pool.Query(ctx, `SELECT `+apiKeyColumns+` FROM api_keys WHERE id = $1`, id)
+	if err != nil {
+		return nil, err
+	}
+	k, err := pgx.CollectExactlyOneRow(rows, scanAPIKey)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, apikeys.ErrKeyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+func (s *APIKeyStore) ListKeys(ctx context.Context, userID string) ([]apikeys.Key, error) {
+	rows, err := s.store.Pool.Query(ctx, `SELECT `+apiKeyColumns+` FROM api_keys WHERE user_id = $1 ORDER BY created_at DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, scanAPIKey)
+}
+
+func (s *APIKeyStore) DeleteKey(ctx context.Context, userID, id string) error {
+	tag, err := s.store.Pool.Exec(ctx, `DELETE FROM api_keys WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return apikeys.ErrKeyNotFound
+	}
+	return nil
+}
+
+func (s *APIKeyStore) TouchKey(ctx context.Context, id string, at time.Time) error {
+	_, err := s.store.Pool.Exec(ctx, `UPDATE api_keys SET last_used_at = $2 WHERE id = $1`, id, at)
+	return err
+}
+
+func scanAPIKey(row pgx.CollectableRow) (apikeys.Key, error) {
+	var k apikeys.Key
+	err := row.Scan(&k.ID, &k.UserID, &k.Name, &k.SecretHash, &k.Scopes, &k.Policy, &k.ExpiresAt, &k.LastUsedAt, &k.CreatedAt)
+	return k, err
+}
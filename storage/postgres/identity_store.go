@@ -0,0 +1,81 @@
+package postgres_store
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/mcgtrt/go-puerto/internal/oidc"
+)
+
+const createIdentitiesTable = `
+CREATE TABLE IF NOT EXISTS user_identities (
+	provider   TEXT NOT NULL,
+	subject    TEXT NOT NULL,
+	user_id    TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	email      TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMPTZ NOT NULL,
+	PRIMARY KEY (provider, subject)
+);
+CREATE INDEX IF NOT EXISTS user_identities_user_id_idx ON user_identities (user_id);`
+
+// Identity store keeping linked provider identities in the
+// user_identities table, which are removed together with their user.
+// Requires the users table of the user store.
+type IdentityStore struct {
+	store *PostgresStore
+}
+
+// Create identity store and make sure its table exists
+func NewIdentityStore(ctx context.Context, store *PostgresStore) (*IdentityStore, error) {
+	if _, err := store.Pool.Exec(ctx, createIdentitiesTable); err != nil {
+		return nil, err
+	}
+	return &IdentityStore{store: store}, nil
+}
+
+func (s *IdentityStore) GetIdentity(ctx context.Context, provider, subject string) (*oidc.Identity, error) {
+	var i oidc.Identity
+	err := s.store.Pool.QueryRow(ctx, `
+		SELECT provider, subject, user_id, email, created_at FROM user_identities
+		WHERE provider = $1 AND subject = $2`, provider, subject,
+	).Scan(&i.Provider, &i.Subject, &i.UserID, &i.Email, &i.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, oidc.ErrIdentityNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &i, nil
+}
+
+func (s *IdentityStore) CreateIdentity(ctx context.Context, i *oidc.Identity) error {
+	_, err := s.store.Pool.Exec(ctx, `
+		INSERT INTO user_identities (provider, subject, user_id, email, created_at)
+		VALUES ($1, $2, $3, $4, $5)`, i.Provider, i.Subject, i.UserID, i.Email, i.CreatedAt,
+	)
+	if isUniqueViolation(err) {
+		return oidc.ErrIdentityLinked
+	}
+	return err
+}
+
+func (s *IdentityStore) ListIdentities(ctx context.Context, userID string) ([]oidc.Identity, error) {
+	rows, err := s.store.Pool.Query(ctx, `
+		SELECT provider, subject, user_id, email, created_at FROM user_identities
+		WHERE user_id = $1 ORDER BY created_at`, userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (oidc.Identity, error) {
+		var i oidc.Identity
+		err := row.Scan(&i.Provider, &i.Subject, &i.UserID, &i.Email, &i.CreatedAt)
+		return i, err
+	})
+}
+
+func (s *IdentityStore) DeleteIdentity(ctx context.Context, provider, subject string) error {
+	_, err := s.store.Pool.Exec(ctx, `DELETE FROM user_identities WHERE provider = $1 AND subject = $2`, provider, subject)
+	return err
+}
@@ -0,0 +1,187 @@
+package postgres_store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/mcgtrt/go-puerto/internal/jobs"
+	"github.com/mcgtrt/go-puerto/utils"
+)
+
+const createJobTable = `
+CREATE TABLE IF NOT EXISTS jobs (
+	id           TEXT PRIMARY KEY,
+	kind         TEXT NOT NULL,
+	payload      JSONB NOT NULL,
+	priority     SMALLINT NOT NULL,
+	unique_key   TEXT,
+	status       TEXT NOT NULL,
+	attempts     INT NOT NULL,
+	max_attempts INT NOT NULL,
+	run_at       TIMESTAMPTZ NOT NULL,
+	locked_until TIMESTAMPTZ,
+	last_error   TEXT,
+	created_at   TIMESTAMPTZ NOT NULL,
+	updated_at   TIMESTAMPTZ NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS jobs_unique_key_idx ON jobs (unique_key) WHERE status <> 'failed';
+CREATE INDEX IF NOT EXISTS jobs_due_idx ON jobs (priority DESC, run_at) WHERE status <> 'failed';
+CREATE INDEX IF NOT EXISTS jobs_status_idx ON jobs (status, updated_at DESC);
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS claim_id TEXT;`
+
+const jobColumns = `id, kind, payload, priority, COALESCE(unique_key, ''), status, attempts, max_attempts,
+	run_at, locked_until, COALESCE(last_error, ''), created_at, updated_at, COALESCE(claim_id, '')`
+
+// Job queue backend keeping jobs in the jobs table. Workers of all
+// instances claim due jobs with FOR UPDATE SKIP LOCKED, so a job is never
+// claimed twice and workers don't wait for each other's locks.
+type JobStore struct {
+	store *PostgresStore
+}
+
+// Create job store and make sure its table exists
+func NewJobStore(ctx context.Context, store *PostgresStore) (*JobStore, error) {
+	if _, err := store.Pool.Exec(ctx, createJobTable); err != nil {
+		return nil, err
+	}
+	return &JobStore{store: store}, nil
+}
+
+func (s *JobStore) Enqueue(ctx context.Context, j *jobs.Job) error {
+	_, err := s.store.Pool.Exec(ctx, `
+		INSERT INTO jobs (id, kind, payload, priority, unique_key, status, attempts, max_attempts, run_at, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6, $7, $8, $9, $10, $11)`,
+		j.ID, j.Kind, string(j.Payload), j.Priority, j.UniqueKey, j.Status, j.Attempts, j.MaxAttempts, j.RunAt, j.CreatedAt, j.UpdatedAt,
+	)
+	return uniqueJobError(err)
+}
+
+func (s *JobStore) Claim(ctx context.Context, kinds []string, lease time.Duration) (*jobs.Job, error) {
+	now := time.Now()
+	rows, err := s.store.Pool.Query(ctx, `
+		UPDATE jobs SET status = 'running', attempts = attempts + 1, claim_id = $4, locked_until = $3, updated_at = $2
+		WHERE id = (
+			SELECT id FROM jobs
+			WHERE kind = ANY($1) AND (
+				status = 'queued' AND run_at <= $2 OR
+				status = 'running' AND locked_until < $2
+			)
+			ORDER BY priority DESC, run_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING `+jobColumns,
+		kinds, now, now.Add(lease), utils.NewUUIDv7(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	j, err := pgx.CollectExactlyOneRow(rows, scanJob)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, jobs.ErrNoJob
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &j, nil
+}
+
+// Complete, Retry and Fail are fenced on the ID of the claim, so a worker
+// whose lease expired can't change the job claimed again
+func (s *JobStore) Complete(ctx context.Context, j *jobs.Job) error {
+	return leaseResult(s.store.Pool.Exec(ctx, `DELETE FROM jobs WHERE id = $1 AND status = 'running' AND claim_id = $2`, j.ID, j.ClaimID))
+}
+
+func (s *JobStore) Retry(ctx context.Context, j *jobs.Job, runAt time.Time, lastError string) error {
+	return leaseResult(s.store.Pool.Exec(ctx, `
+		UPDATE jobs SET status = 'queued', run_at = $3, last_error = $4, locked_until = NULL, updated_at = $5
+		WHERE id = $1 AND status = 'running' AND claim_id = $2`,
+		j.ID, j.ClaimID, runAt, lastError, time.Now(),
+	))
+}
+
+func (s *JobStore) Fail(ctx context.Context, j *jobs.Job, lastError string) error {
+	return leaseResult(s.store.Pool.Exec(ctx, `
+		UPDATE jobs SET status = 'failed', last_error = $3, locked_until = NULL, updated_at = $4
+		WHERE id = $1 AND status = 'running' AND claim_id = $2`,
+		j.ID, j.ClaimID, lastError, time.Now(),
+	))
+}
+
+func leaseResult(tag pgconn.CommandTag, err error) error {
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return jobs.ErrLeaseLost
+	}
+	return nil
+}
+
+func (s *JobStore) List(ctx context.Context, status string, limit int) ([]jobs.Job, error) {
+	rows, err := s.store.Pool.Query(ctx, `SELECT `+jobColumns+` FROM jobs WHERE status = $1 ORDER BY updated_at DESC LIMIT $2`, status, limit)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, scanJob)
+}
+
+func (s *JobStore) Count(ctx context.Context) (map[string]int, error) {
+	rows, err := s.store.Pool.Query(ctx, `SELECT status, COUNT(*) FROM jobs GROUP BY status`)
+	if err != nil {
+		return nil, err
+	}
+	counts := map[string]int{}
+	var status string
+	var count int
+	_, err = pgx.ForEachRow(rows, []any{&status, &count}, func() error {
+		counts[status] = count
+		return nil
+	})
+	return counts, err
+}
+
+func (s *JobStore) Requeue(ctx context.Context, id string) error {
+	now := time.Now()
+	tag, err := s.store.Pool.Exec(ctx, `
+		UPDATE jobs SET status = 'queued', attempts = 0, run_at = $2, updated_at = $2
+		WHERE id = $1 AND status = 'failed'`,
+		id, now,
+	)
+	if err != nil {
+		return uniqueJobError(err)
+	}
+	if tag.RowsAffected() == 0 {
+		return jobs.ErrJobNotFound
+	}
+	return nil
+}
+
+func (s *JobStore) Delete(ctx context.Context, id string) error {
+	tag, err := s.store.Pool.Exec(ctx, `DELETE FROM jobs WHERE id = $1 AND status = 'failed'`, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return jobs.ErrJobNotFound
+	}
+	return nil
+}
+
+func scanJob(row pgx.CollectableRow) (jobs.Job, error) {
+	var j jobs.Job
+	err := row.Scan(&j.ID, &j.Kind, &j.Payload, &j.Priority, &j.UniqueKey, &j.Status, &j.Attempts, &j.MaxAttempts,
+		&j.RunAt, &j.LockedUntil, &j.LastError, &j.CreatedAt, &j.UpdatedAt, &j.ClaimID)
+	return j, err
+}
+
+// Unique key taken by another queued or running job
+func uniqueJobError(err error) error {
+	if isUniqueViolation(err) {
+		return jobs.ErrDuplicate
+	}
+	return err
+}
@@ -0,0 +1,124 @@
+package postgres_store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/mcgtrt/go-puerto/internal/jwt"
+)
+
+const createJWTTables = `
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+	hash       TEXT PRIMARY KEY,
+	family_id  TEXT NOT NULL,
+	user_id    TEXT NOT NULL,
+	scopes     TEXT[] NOT NULL DEFAULT '{}',
+	expires_at TIMESTAMPTZ NOT NULL,
+	used_at    TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS refresh_tokens_family_id_idx ON refresh_tokens (family_id);
+CREATE INDEX IF NOT EXISTS refresh_tokens_user_id_idx ON refresh_tokens (user_id);
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+	jti        TEXT PRIMARY KEY,
+	expires_at TIMESTAMPTZ NOT NULL
+);`
+
+const refreshTokenColumns = `hash, family_id, user_id, scopes, expires_at, used_at`
+
+// Refresh token store and revocation list keeping tokens in the
+// refresh_tokens and revoked_tokens tables. Expired rows are ignored and
+// removed with DeleteExpired.
+type JWTStore struct {
+	store *PostgresStore
+}
+
+// Create jwt store and make sure its tables exist
+func NewJWTStore(ctx context.Context, store *PostgresStore) (*JWTStore, error) {
+	if _, err := store.Pool.Exec(ctx, createJWTTables); err != nil {
+		return nil, err
+	}
+	return &JWTStore{store: store}, nil
+}
+
+func (s *JWTStore) Save(ctx context.Context, t *jwt.RefreshToken) error {
+	scopes := t.Scopes
+	if scopes == nil {
+		scopes = []string{}
+	}
+	_, err := s.store.Pool.Exec(ctx,
+		`INSERT INTO refresh_tokens (hash, family_id, user_id, scopes, expires_at) VALUES ($1, $2, $3, $4, $5)`,
+		t.Hash, t.FamilyID, t.UserID, scopes, t.ExpiresAt,
+	)
+	return err
+}
+
+// Marks the token used with a conditional update, so only one of
+// concurrent refreshes wins. A miss is looked up again to tell a reused
+// token from an unknown one.
+func (s *JWTStore) Use(ctx context.Context, hash string) (*jwt.RefreshToken, error) {
+	t, err := s.scan(s.store.Pool.QueryRow(ctx, `
+		UPDATE refresh_tokens SET used_at = now()
+		WHERE hash = $1 AND used_at IS NULL AND expires_at > now()
+		RETURNING `+refreshTokenColumns, hash,
+	))
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return t, err
+	}
+	t, err = s.scan(s.store.Pool.QueryRow(ctx,
+		`SELECT `+refreshTokenColumns+` FROM refresh_tokens WHERE hash = $1 AND expires_at > now()`, hash,
+	))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, jwt.ErrRefreshNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return t, jwt.ErrRefreshReused
+}
+
+func (s *JWTStore) scan(row pgx.Row) (*jwt.RefreshToken, error) {
+	var t jwt.RefreshToken
+	if err := row.Scan(&t.Hash, &t.FamilyID, &t.UserID, &t.Scopes, &t.ExpiresAt, &t.UsedAt); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (s *JWTStore) RevokeFamily(ctx context.Context, familyID string) error {
+	_, err := s.store.Pool.Exec(ctx, `DELETE FROM refresh_tokens WHERE family_id = $1`, familyID)
+	return err
+}
+
+func (s *JWTStore) RevokeUser(ctx context.Context, userID string) error {
+	_, err := s.store.Pool.Exec(ctx, `DELETE FROM refresh_tokens WHERE user_id = $1`, userID)
+	return err
+}
+
+func (s *JWTStore) Revoke(ctx context.Context, jti string, expiresAt time.Time) error {
+	_, err := s.store.Pool.Exec(ctx, `
+		INSERT INTO revoked_tokens (jti, expires_at) VALUES ($1, $2)
+		ON CONFLICT (jti) DO NOTHING`, jti, expiresAt,
+	)
+	return err
+}
+
+func (s *JWTStore) IsRevoked(ctx context.Context, jti string) (bool, error) {
+	var revoked bool
+	err := s.store.Pool.QueryRow(ctx,
+		`SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = $1 AND expires_at > now())`, jti,
+	).Scan(&revoked)
+	return revoked, err
+}
+
+// Remove expired refresh tokens and revocations. The scheduler runs it
+// every hour (jwt:cleanup) to keep the tables small.
+func (s *JWTStore) DeleteExpired(ctx context.Context) (int64, error) {
+	tokens, err := s.store.Pool.Exec(ctx, `DELETE FROM refresh_tokens WHERE expires_at <= now()`)
+	if err != nil {
+		return 0, err
+	}
+	revoked, err := s.store.Pool.Exec(ctx, `DELETE FROM revoked_tokens WHERE expires_at <= now()`)
+	return tokens.RowsAffected() + revoked.RowsAffected(), err
+}
@@ -0,0 +1,80 @@
+package postgres_store
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Leader election with a session level advisory lock. The lock is held by
+// a connection taken out of the pool for as long as this instance leads
+// and is released by Postgres when the connection drops, so the ttl is
+// not used.
+type LeaderLock struct {
+	store *PostgresStore
+	key   int64
+
+	mu   sync.Mutex
+	conn *pgxpool.Conn
+}
+
+func NewLeaderLock(store *PostgresStore, name string) *LeaderLock {
+	h := fnv.New64a()
+	h.Write([]byte("leader:" + name))
+	return &LeaderLock{store: store, key: int64(h.Sum64())}
+}
+
+func (l *LeaderLock) Campaign(ctx context.Context, ttl time.Duration) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.conn != nil {
+		// The lock lives as long as the connection
+		if err := l.conn.Ping(ctx); err == nil {
+			return true, nil
+		}
+		l.close()
+	}
+	conn, err := l.store.Pool.Acquire(ctx)
+	if err != nil {
+		return false, err
+	}
+	var locked bool
+	if err := conn.QueryRow(ctx, `SELECT pg_try_advisory_lock($1)`, l.key).Scan(&locked); err != nil {
+		conn.Release()
+		return false, err
+	}
+	if !locked {
+		conn.Release()
+		return false, nil
+	}
+	l.conn = conn
+	return true, nil
+}
+
+func (l *LeaderLock) Resign(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.conn == nil {
+		return nil
+	}
+	_, err := l.conn.Exec(ctx, `SELECT pg_advisory_unlock($1)`, l.key)
+	if err != nil {
+		l.close()
+		return err
+	}
+	l.conn.Release()
+	l.conn = nil
+	return nil
+}
+
+// Drop the connection rather than returning it to the pool, which would
+// keep the lock held by whoever uses it next
+func (l *LeaderLock) close() {
+	l.conn.Hijack().Close(context.Background())
+	l.conn = nil
+}
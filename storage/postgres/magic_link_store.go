@@ -0,0 +1,116 @@
+package postgres_store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/mcgtrt/go-puerto/internal/magiclink"
+)
+
+const createMagicLinkTables = `
+CREATE TABLE IF NOT EXISTS magic_links (
+	id           TEXT PRIMARY KEY,
+	token_hash   TEXT NOT NULL UNIQUE,
+	browser_hash TEXT NOT NULL UNIQUE,
+	email        TEXT NOT NULL,
+	user_id      TEXT REFERENCES users (id) ON DELETE CASCADE,
+	ip           TEXT NOT NULL,
+	user_agent   TEXT NOT NULL,
+	confirmed_at TIMESTAMPTZ,
+	expires_at   TIMESTAMPTZ NOT NULL,
+	created_at   TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX IF NOT EXISTS magic_links_expires_at_idx ON magic_links (expires_at);
+CREATE TABLE IF NOT EXISTS magic_link_requests (
+	email      TEXT PRIMARY KEY,
+	count      INTEGER NOT NULL,
+	expires_at TIMESTAMPTZ NOT NULL
+);`
+
+const linkColumns = `id, token_hash, browser_hash, email, COALESCE(user_id, ''), ip, user_agent, confirmed_at, expires_at, created_at`
+
+// Magic link store keeping login links in magic_links and requests per
+// address in magic_link_requests. Expired rows are removed when new links
+// are created. Requires the users table of the user store.
+type MagicLinkStore struct {
+	store *PostgresStore
+}
+
+// Create magic link store and make sure its tables exist
+func NewMagicLinkStore(ctx context.Context, store *PostgresStore) (*MagicLinkStore, error) {
+	if _, err := store.Pool.Exec(ctx, createMagicLinkTables); err != nil {
+		return nil, err
+	}
+	return &MagicLinkStore{store: store}, nil
+}
+
+func (s *MagicLinkStore) CreateLink(ctx context.Context, l *magiclink.Link) error {
+	return pgx.BeginFunc(ctx, s.store.Pool, func(tx pgx.Tx) error {
+		now := time.Now()
+		if _, err := tx.Exec(ctx, `DELETE FROM magic_links WHERE expires_at <= $1`, now); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `DELETE FROM magic_link_requests WHERE expires_at <= $1`, now); err != nil {
+			return err
+		}
+		_, err := tx.Exec(ctx, `
+			INSERT INTO magic_links (id, token_hash, browser_hash, email, user_id, ip, user_agent, confirmed_at, expires_at, created_at)
+			VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6, $7, $8, $9, $10)`,
+			l.ID, l.TokenHash, l.BrowserHash, l.Email, l.UserID, l.IP, l.UserAgent, l.ConfirmedAt, l.ExpiresAt, l.CreatedAt,
+		)
+		return err
+	})
+}
+
+func (s *MagicLinkStore) GetLinkByToken(ctx context.Context, tokenHash string) (*magiclink.Link, error) {
+	return s.get(ctx, `SELECT `+linkColumns+` FROM magic_links WHERE token_hash = $1 AND expires_at > $2`, tokenHash, time.Now())
+}
+
+func (s *MagicLinkStore) GetLinkByBrowser(ctx context.Context, browserHash string) (*magiclink.Link, error) {
+	return s.get(ctx, `SELECT `+linkColumns+` FROM magic_links WHERE browser_hash = $1 AND expires_at > $2`, browserHash, time.Now())
+}
+
+func (s *MagicLinkStore) ConfirmLink(ctx context.Context, tokenHash string, at time.Time) (*magiclink.Link, error) {
+	return s.get(ctx, `
+		UPDATE magic_links SET confirmed_at = $2
+		WHERE token_hash = $1 AND confirmed_at IS NULL AND expires_at > $2
+		RETURNING `+linkColumns, tokenHash, at,
+	)
+}
+
+func (s *MagicLinkStore) DeleteLink(ctx context.Context, id string) (bool, error) {
+	tag, err := s.store.Pool.Exec(ctx, `DELETE FROM magic_links WHERE id = $1`, id)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+func (s *MagicLinkStore) RecordRequest(ctx context.Context, email string, window time.Duration) (int, error) {
+	now := time.Now()
+	var count int
+	err := s.store.Pool.QueryRow(ctx, `
+		INSERT INTO magic_link_requests (email, count, expires_at) VALUES ($1, 1, $3)
+		ON CONFLICT (email) DO UPDATE SET
+			count      = CASE WHEN magic_link_requests.expires_at > $2 THEN magic_link_requests.count + 1 ELSE 1 END,
+			expires_at = CASE WHEN magic_link_requests.expires_at > $2 THEN magic_link_requests.expires_at ELSE $3 END
+		RETURNING count`, email, now, now.Add(window),
+	).Scan(&count)
+	return count, err
+}
+
+func (s *MagicLinkStore) get(ctx context.Context, query string, args ...any) (*magiclink.Link, error) {
+	l := &magiclink.Link{}
+	err := s.store.Pool.QueryRow(ctx, query, args...).Scan(
+		&l.ID, &l.TokenHash, &l.BrowserHash, &l.Email, &l.UserID, &l.IP, &l.UserAgent, &l.ConfirmedAt, &l.ExpiresAt, &l.CreatedAt,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, magiclink.ErrLinkNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
@@ -0,0 +1,159 @@
+package postgres_store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/mcgtrt/go-puerto/internal/mfa"
+)
+
+const createMFATables = `
+CREATE TABLE IF NOT EXISTS mfa_factors (
+	id           TEXT PRIMARY KEY,
+	user_id      TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	kind         TEXT NOT NULL,
+	name         TEXT NOT NULL,
+	secret       TEXT NOT NULL,
+	counter      BIGINT NOT NULL DEFAULT 0,
+	confirmed_at TIMESTAMPTZ,
+	created_at   TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX IF NOT EXISTS mfa_factors_user_id_idx ON mfa_factors (user_id);
+CREATE TABLE IF NOT EXISTS mfa_recovery_codes (
+	user_id TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	hash    TEXT NOT NULL,
+	PRIMARY KEY (user_id, hash)
+);
+CREATE TABLE IF NOT EXISTS mfa_failures (
+	user_id    TEXT PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+	count      INTEGER NOT NULL,
+	expires_at TIMESTAMPTZ NOT NULL
+);`
+
+const factorColumns = `id, user_id, kind, name, secret, counter, confirmed_at, created_at`
+
+// MFA store keeping second factors, hashed recovery codes and failed
+// verifications in the mfa_* tables, which are removed together with
+// their user. Requires the users table of the user store.
+type MFAStore struct {
+	store *PostgresStore
+}
+
+// Create MFA store and make sure its tables exist
+func NewMFAStore(ctx context.Context, store *PostgresStore) (*MFAStore, error) {
+	if _, err := store.Pool.Exec(ctx, createMFATables); err != nil {
+		return nil, err
+	}
+	return &MFAStore{store: store}, nil
+}
+
+func (s *MFAStore) CreateFactor(ctx context.Context, f *mfa.Factor) error {
+	_, err := s.store.Pool.Exec(ctx, `INSERT INTO mfa_factors (`+factorColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		f.ID, f.UserID, f.Kind, f.Name, f.Secret, f.Counter, f.ConfirmedAt, f.CreatedAt,
+	)
+	return err
+}
+
+func (s *MFAStore) ListFactors(ctx context.Context, userID string) ([]mfa.Factor, error) {
+	rows, err := s.store.Pool.Query(ctx, `SELECT `+factorColumns+` FROM mfa_factors WHERE user_id = $1 ORDER BY created_at`, userID)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (mfa.Factor, error) {
+		var f mfa.Factor
+		err := row.Scan(&f.ID, &f.UserID, &f.Kind, &f.Name, &f.Secret, &f.Counter, &f.ConfirmedAt, &f.CreatedAt)
+		return f, err
+	})
+}
+
+func (s *MFAStore) ConfirmFactor(ctx context.Context, id string, at time.Time) error {
+	tag, err := s.store.Pool.Exec(ctx, `UPDATE mfa_factors SET confirmed_at = $2 WHERE id = $1`, id, at)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return mfa.ErrFactorNotFound
+	}
+	return nil
+}
+
+func (s *MFAStore) UpdateCounter(ctx context.Context, id string, old, new int64) (bool, error) {
+	tag, err := s.store.Pool.Exec(ctx, `UPDATE mfa_factors SET counter = $3 WHERE id = $1 AND counter = $2`, id, old, new)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+func (s *MFAStore) DeleteFactor(ctx context.Context, id string) error {
+	_, err := s.store.Pool.Exec(ctx, `DELETE FROM mfa_factors WHERE id = $1`, id)
+	return err
+}
+
+func (s *MFAStore) DeleteUserFactors(ctx context.Context, userID string) error {
+	return pgx.BeginFunc(ctx, s.store.Pool, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, `DELETE FROM mfa_factors WHERE user_id = $1`, userID); err != nil {
+			return err
+		}
+		_, err := tx.Exec(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userID)
+		return err
+	})
+}
+
+func (s *MFAStore) ReplaceRecoveryCodes(ctx context.Context, userID string, hashes []string) error {
+	return pgx.BeginFunc(ctx, s.store.Pool, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userID); err != nil {
+			return err
+		}
+		_, err := tx.Exec(ctx, `INSERT INTO mfa_recovery_codes (user_id, hash) SELECT $1, unnest($2::text[])`, userID, hashes)
+		return err
+	})
+}
+
+func (s *MFAStore) UseRecoveryCode(ctx context.Context, userID, hash string) error {
+	tag, err := s.store.Pool.Exec(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id = $1 AND hash = $2`, userID, hash)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return mfa.ErrRecoveryCodeNotFound
+	}
+	return nil
+}
+
+func (s *MFAStore) CountRecoveryCodes(ctx context.Context, userID string) (int, error) {
+	var count int
+	err := s.store.Pool.QueryRow(ctx, `SELECT count(*) FROM mfa_recovery_codes WHERE user_id = $1`, userID).Scan(&count)
+	return count, err
+}
+
+func (s *MFAStore) RecordFailure(ctx context.Context, userID string, window time.Duration) (int, error) {
+	now := time.Now()
+	var count int
+	err := s.store.Pool.QueryRow(ctx, `
+		INSERT INTO mfa_failures (user_id, count, expires_at) VALUES ($1, 1, $3)
+		ON CONFLICT (user_id) DO UPDATE SET
+			count      = CASE WHEN mfa_failures.expires_at > $2 THEN mfa_failures.count + 1 ELSE 1 END,
+			expires_at = CASE WHEN mfa_failures.expires_at > $2 THEN mfa_failures.expires_at ELSE $3 END
+		RETURNING count`, userID, now, now.Add(window),
+	).Scan(&count)
+	return count, err
+}
+
+func (s *MFAStore) Failures(ctx context.Context, userID string) (int, error) {
+	var count int
+	err := s.store.Pool.QueryRow(ctx,
+		`SELECT count FROM mfa_failures WHERE user_id = $1 AND expires_at > $2`, userID, time.Now(),
+	).Scan(&count)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, nil
+	}
+	return count, err
+}
+
+func (s *MFAStore) ResetFailures(ctx context.Context, userID string) error {
+	_, err := s.store.Pool.Exec(ctx, `DELETE FROM mfa_failures WHERE user_id = $1`, userID)
+	return err
+}
@@ -0,0 +1,118 @@
+package postgres_store
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/mcgtrt/go-puerto/internal/authz"
+)
+
+const createPolicyTables = `
+CREATE TABLE IF NOT EXISTS roles (
+	name        TEXT PRIMARY KEY,
+	permissions TEXT[] NOT NULL DEFAULT '{}'
+);
+CREATE TABLE IF NOT EXISTS role_assignments (
+	user_id TEXT NOT NULL,
+	role    TEXT NOT NULL REFERENCES roles (name) ON DELETE CASCADE,
+	PRIMARY KEY (user_id, role)
+);`
+
+// Postgres error code of foreign key violations
+const foreignKeyViolation = "23503"
+
+// Policy store keeping roles in the roles table and user roles in
+// role_assignments, which are removed together with their role
+type PolicyStore struct {
+	store *PostgresStore
+}
+
+// Create policy store and make sure its tables exist
+func NewPolicyStore(ctx context.Context, store *PostgresStore) (*PolicyStore, error) {
+	if _, err := store.Pool.Exec(ctx, createPolicyTables); err != nil {
+		return nil, err
+	}
+	return &PolicyStore{store: store}, nil
+}
+
+func (s *PolicyStore) SaveRole(ctx context.Context, r *authz.Role) error {
+	permissions := r.Permissions
+	if permissions == nil {
+		permissions = []string{}
+	}
+	_, err := s.store.Pool.Exec(ctx, `
+		INSERT INTO roles (name, permissions) VALUES ($1, $2)
+		ON CONFLICT (name) DO UPDATE SET permissions = $2`, r.Name, permissions,
+	)
+	return err
+}
+
+func (s *PolicyStore) GetRole(ctx context.Context, name string) (*authz.Role, error) {
+	var r authz.Role
+	err := s.store.Pool.QueryRow(ctx,
+		`SELECT name, permissions FROM roles WHERE name = $1`, name,
+	).Scan(&r.Name, &r.Permissions)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, authz.ErrRoleNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+func (s *PolicyStore) ListRoles(ctx context.Context) ([]authz.Role, error) {
+	rows, err := s.store.Pool.Query(ctx, `SELECT name, permissions FROM roles ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (authz.Role, error) {
+		var r authz.Role
+		err := row.Scan(&r.Name, &r.Permissions)
+		return r, err
+	})
+}
+
+func (s *PolicyStore) DeleteRole(ctx context.Context, name string) error {
+	_, err := s.store.Pool.Exec(ctx, `DELETE FROM roles WHERE name = $1`, name)
+	return err
+}
+
+func (s *PolicyStore) AssignRole(ctx context.Context, userID, role string) error {
+	_, err := s.store.Pool.Exec(ctx, `
+		INSERT INTO role_assignments (user_id, role) VALUES ($1, $2)
+		ON CONFLICT DO NOTHING`, userID, role,
+	)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == foreignKeyViolation {
+		return authz.ErrRoleNotFound
+	}
+	return err
+}
+
+func (s *PolicyStore) UnassignRole(ctx context.Context, userID, role string) error {
+	_, err := s.store.Pool.Exec(ctx, `DELETE FROM role_assignments WHERE user_id = $1 AND role = $2`, userID, role)
+	return err
+}
+
+func (s *PolicyStore) UserRoles(ctx context.Context, userID string) ([]string, error) {
+	rows, err := s.store.Pool.Query(ctx, `SELECT role FROM role_assignments WHERE user_id = $1 ORDER BY role`, userID)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowTo[string])
+}
+
+func (s *PolicyStore) UserPermissions(ctx context.Context, userID string) ([]string, error) {
+	rows, err := s.store.Pool.Query(ctx, `
+		SELECT DISTINCT unnest(r.permissions) FROM roles r
+		JOIN role_assignments a ON a.role = r.name
+		WHERE a.user_id = $1`, userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowTo[string])
+}
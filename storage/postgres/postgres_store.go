@@ -1,8 +1,33 @@
 package postgres_store
 
+import (
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/mcgtrt/go-puerto/internal/metrics"
+)
+
 type PostgresStore struct {
+	Pool *pgxpool.Pool
 }
 
-func NewPostgresStore() *PostgresStore {
-	return &PostgresStore{}
+// Default postgres store setup with connection pool. Pool statistics are
+// exposed through the project metrics registry, registering them fails if
+// a store was created already.
+func NewPostgresStore(pool *pgxpool.Pool) (*PostgresStore, error) {
+	err := metrics.Register(metrics.NewPoolCollector("postgres", func() metrics.PoolStats {
+		stat := pool.Stat()
+		return metrics.PoolStats{
+			Total:        int64(stat.TotalConns()),
+			Idle:         int64(stat.IdleConns()),
+			InUse:        int64(stat.AcquiredConns()),
+			Max:          int64(stat.MaxConns()),
+			AcquireCount: stat.AcquireCount(),
+			WaitDuration: stat.AcquireDuration(),
+		}
+	}))
+	if err != nil {
+		return nil, err
+	}
+	return &PostgresStore{
+		Pool: pool,
+	}, nil
 }
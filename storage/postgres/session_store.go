@@ -0,0 +1,85 @@
+package postgres_store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/mcgtrt/go-puerto/internal/session"
+)
+
+const createSessionsTable = `
+CREATE TABLE IF NOT EXISTS sessions (
+	id         TEXT PRIMARY KEY,
+	user_id    TEXT,
+	data       JSONB NOT NULL,
+	expires_at TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX IF NOT EXISTS sessions_user_id_idx ON sessions (user_id);
+CREATE INDEX IF NOT EXISTS sessions_expires_at_idx ON sessions (expires_at);`
+
+// Session store keeping sessions in the sessions table. Expired rows are
+// ignored when loading and removed with DeleteExpired.
+type SessionStore struct {
+	store *PostgresStore
+}
+
+// Create session store and make sure its table exists
+func NewSessionStore(ctx context.Context, store *PostgresStore) (*SessionStore, error) {
+	if _, err := store.Pool.Exec(ctx, createSessionsTable); err != nil {
+		return nil, err
+	}
+	return &SessionStore{store: store}, nil
+}
+
+func (s *SessionStore) Load(ctx context.Context, token string) (*session.Session, error) {
+	var data []byte
+	err := s.store.Pool.QueryRow(ctx,
+		`SELECT data FROM sessions WHERE id = $1 AND expires_at > now()`, token,
+	).Scan(&data)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, session.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return session.Decode(data)
+}
+
+func (s *SessionStore) Save(ctx context.Context, sess *session.Session, ttl time.Duration) (string, error) {
+	data, err := session.Encode(sess)
+	if err != nil {
+		return "", err
+	}
+	var userID *string
+	if sess.UserID != "" {
+		userID = &sess.UserID
+	}
+	_, err = s.store.Pool.Exec(ctx, `
+		INSERT INTO sessions (id, user_id, data, expires_at) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (id) DO UPDATE SET user_id = $2, data = $3, expires_at = $4`,
+		sess.ID, userID, data, time.Now().Add(ttl),
+	)
+	if err != nil {
+		return "", err
+	}
+	return sess.ID, nil
+}
+
+func (s *SessionStore) Delete(ctx context.Context, id string) error {
+	_, err := s.store.Pool.Exec(ctx, `DELETE FROM sessions WHERE id = $1`, id)
+	return err
+}
+
+func (s *SessionStore) DeleteByUser(ctx context.Context, userID string) error {
+	_, err := s.store.Pool.Exec(ctx, `DELETE FROM sessions WHERE user_id = $1`, userID)
+	return err
+}
+
+// Remove expired sessions. The scheduler runs it every hour
+// (sessions:cleanup) to keep the table small.
+func (s *SessionStore) DeleteExpired(ctx context.Context) (int64, error) {
+	tag, err := s.store.Pool.Exec(ctx, `DELETE FROM sessions WHERE expires_at <= now()`)
+	return tag.RowsAffected(), err
+}
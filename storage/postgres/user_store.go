@@ -0,0 +1,161 @@
+package postgres_store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/mcgtrt/go-puerto/internal/accounts"
+)
+
+const createUsersTables = `
+CREATE TABLE IF NOT EXISTS users (
+	id                TEXT PRIMARY KEY,
+	email             TEXT NOT NULL UNIQUE,
+	name              TEXT NOT NULL,
+	password_hash     TEXT NOT NULL,
+	email_verified_at TIMESTAMPTZ,
+	failed_logins     INTEGER NOT NULL DEFAULT 0,
+	locked_until      TIMESTAMPTZ NOT NULL DEFAULT 'epoch',
+	created_at        TIMESTAMPTZ NOT NULL,
+	updated_at        TIMESTAMPTZ NOT NULL
+);
+CREATE TABLE IF NOT EXISTS account_tokens (
+	hash       TEXT PRIMARY KEY,
+	purpose    TEXT NOT NULL,
+	user_id    TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	expires_at TIMESTAMPTZ NOT NULL
+);`
+
+const userColumns = `id, email, name, password_hash, email_verified_at, failed_logins, locked_until, created_at, updated_at`
+
+// Postgres error code of unique constraint violations
+const uniqueViolation = "23505"
+
+// User store keeping users and their single-use tokens in the users
+// and account_tokens tables
+type UserStore struct {
+	store *PostgresStore
+}
+
+// Create user store and make sure its tables exist
+func NewUserStore(ctx context.Context, store *PostgresStore) (*UserStore, error) {
+	if _, err := store.Pool.Exec(ctx, createUsersTables); err != nil {
+		return nil, err
+	}
+	return &UserStore{store: store}, nil
+}
+
+func (s *UserStore) CreateUser(ctx context.Context, u *accounts.User) error {
+	_, err := s.store.Pool.Exec(ctx, `INSERT INTO users (`+userColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		u.ID, u.Email, u.Name, u.PasswordHash, u.EmailVerifiedAt, u.FailedLogins, u.LockedUntil, u.CreatedAt, u.UpdatedAt,
+	)
+	if isUniqueViolation(err) {
+		return accounts.ErrEmailTaken
+	}
+	return err
+}
+
+func (s *UserStore) GetUserByID(ctx context.Context, id string) (*accounts.User, error) {
+	return s.findUser(ctx, `SELECT `+userColumns+` FROM users WHERE id = $1`, id)
+}
+
+func (s *UserStore) GetUserByEmail(ctx context.Context, email string) (*accounts.User, error) {
+	return s.findUser(ctx, `SELECT `+userColumns+` FROM users WHERE email = $1`, email)
+}
+
+func (s *UserStore) findUser(ctx context.Context, query string, arg string) (*accounts.User, error) {
+	var u accounts.User
+	err := s.store.Pool.QueryRow(ctx, query, arg).Scan(
+		&u.ID, &u.Email, &u.Name, &u.PasswordHash, &u.EmailVerifiedAt, &u.FailedLogins, &u.LockedUntil, &u.CreatedAt, &u.UpdatedAt,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, accounts.ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+func (s *UserStore) UpdateUser(ctx context.Context, u *accounts.User) error {
+	tag, err := s.store.Pool.Exec(ctx, `
+		UPDATE users SET email = $2, name = $3, password_hash = $4, email_verified_at = $5,
+			failed_logins = $6, locked_until = $7, updated_at = $8
+		WHERE id = $1`,
+		u.ID, u.Email, u.Name, u.PasswordHash, u.EmailVerifiedAt, u.FailedLogins, u.LockedUntil, u.UpdatedAt,
+	)
+	if isUniqueViolation(err) {
+		return accounts.ErrEmailTaken
+	}
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return accounts.ErrUserNotFound
+	}
+	return nil
+}
+
+// The count is incremented in the database, so concurrent failures can't
+// overwrite each other's increments. SET expressions see the old count.
+func (s *UserStore) RecordFailedLogin(ctx context.Context, id string, max int, lockedUntil, now time.Time) (bool, error) {
+	var locked bool
+	err := s.store.Pool.QueryRow(ctx, `
+		UPDATE users SET
+			failed_logins = CASE WHEN failed_logins + 1 >= $2 THEN 0 ELSE failed_logins + 1 END,
+			locked_until = CASE WHEN failed_logins + 1 >= $2 THEN $3 ELSE locked_until END,
+			updated_at = $4
+		WHERE id = $1
+		RETURNING locked_until = $3`,
+		id, max, lockedUntil, now,
+	).Scan(&locked)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, accounts.ErrUserNotFound
+	}
+	return locked, err
+}
+
+func (s *UserStore) ResetFailedLogins(ctx context.Context, id string, now time.Time) error {
+	tag, err := s.store.Pool.Exec(ctx, `UPDATE users SET failed_logins = 0, updated_at = $2 WHERE id = $1`, id, now)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return accounts.ErrUserNotFound
+	}
+	return nil
+}
+
+func (s *UserStore) CreateToken(ctx context.Context, t *accounts.Token) error {
+	_, err := s.store.Pool.Exec(ctx,
+		`INSERT INTO account_tokens (hash, purpose, user_id, expires_at) VALUES ($1, $2, $3, $4)`,
+		t.Hash, t.Purpose, t.UserID, t.ExpiresAt,
+	)
+	return err
+}
+
+func (s *UserStore) ConsumeToken(ctx context.Context, purpose, hash string) (*accounts.Token, error) {
+	var t accounts.Token
+	err := s.store.Pool.QueryRow(ctx, `
+		DELETE FROM account_tokens WHERE hash = $1 AND purpose = $2
+		RETURNING hash, purpose, user_id, expires_at`, hash, purpose,
+	).Scan(&t.Hash, &t.Purpose, &t.UserID, &t.ExpiresAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, accounts.ErrTokenNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().After(t.ExpiresAt) {
+		return nil, accounts.ErrTokenNotFound
+	}
+	return &t, nil
+}
+
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == uniqueViolation
+}
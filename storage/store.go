@@ -1,6 +1,21 @@
 package storage
 
 import (
+	"context"
+
+	"github.com/mcgtrt/go-puerto/internal/accounts"
+	"github.com/mcgtrt/go-puerto/internal/apikeys"
+	"github.com/mcgtrt/go-puerto/internal/authz"
+	"github.com/mcgtrt/go-puerto/internal/jobs"
+	"github.com/mcgtrt/go-puerto/internal/jwt"
+	"github.com/mcgtrt/go-puerto/internal/magiclink"
+	"github.com/mcgtrt/go-puerto/internal/mfa"
+	"github.com/mcgtrt/go-puerto/internal/oidc"
+	"github.com/mcgtrt/go-puerto/internal/scheduler"
+	"github.com/mcgtrt/go-puerto/internal/session"
+	"github.com/mcgtrt/go-puerto/internal/sse"
+	"github.com/mcgtrt/go-puerto/internal/uploads"
+	"github.com/mcgtrt/go-puerto/internal/ws"
 	mongo_store "github.com/mcgtrt/go-puerto/storage/mongo"
 	postgres_store "github.com/mcgtrt/go-puerto/storage/postgres"
 	valkey_store "github.com/mcgtrt/go-puerto/storage/valkey"
@@ -13,6 +28,28 @@ type Store struct {
 	Mongo    *mongo_store.MongoStore
 	Postgres *postgres_store.PostgresStore
 	Valkey   *valkey_store.ValkeyStore
+	Sessions session.SessionStore
+	Users    accounts.UserStore
+	JWT      jwt.Store
+	Policies authz.PolicyStore
+	// Provider identities linked to users, kept next to the users
+	Identities oidc.IdentityStore
+	// Second factors of users, kept next to the users
+	MFA mfa.Store
+	// Login links sent by email, kept next to the users
+	MagicLinks magiclink.Store
+	// API keys of users, kept next to the users
+	APIKeys apikeys.Store
+	// Background jobs of the queue
+	Jobs jobs.Backend
+	// Picks the instance running scheduled tasks
+	Leader scheduler.Elector
+	// Carries server-sent events between instances, nil with one instance
+	Events sse.Transport
+	// Carries WebSocket broadcasts between instances, nil with one instance
+	WebSocket ws.Transport
+	// Contents of uploaded files
+	Blobs uploads.BlobStore
 }
 
 // Create new store based on the configuration provided
@@ -23,22 +60,221 @@ func NewStore(config *utils.Config) (*Store, error) {
 		valkey   *valkey_store.ValkeyStore
 	)
 	if config.Mongo != nil {
-		client, err := config.Mongo.Client()
+		client, err := config.Mongo.Client(mongo_store.MonitorOptions())
 		if err != nil {
 			return nil, err
 		}
 		mongo = mongo_store.NewMongoStore(client, config.Mongo.DBName)
 	}
 	if config.Postgres != nil {
-		postgres = postgres_store.NewPostgresStore()
+		pool, err := config.Postgres.Pool(postgres_store.WithTracer)
+		if err != nil {
+			return nil, err
+		}
+		postgres, err = postgres_store.NewPostgresStore(pool)
+		if err != nil {
+			pool.Close()
+			return nil, err
+		}
 	}
 	if config.Valkey != nil {
-		valkey = valkey_store.NewValkeyStore()
+		client, err := config.Valkey.Client()
+		if err != nil {
+			return nil, err
+		}
+		valkey = valkey_store.NewValkeyStore(client)
 	}
 
-	return &Store{
+	store := &Store{
 		Mongo:    mongo,
 		Postgres: postgres,
 		Valkey:   valkey,
-	}, nil
+	}
+	if config.Session != nil {
+		sessions, err := newSessionStore(store, config.Session.Store)
+		if err != nil {
+			return nil, err
+		}
+		store.Sessions = sessions
+	}
+	if config.Accounts != nil {
+		users, err := newUserStore(store, config.Accounts.Store)
+		if err != nil {
+			return nil, err
+		}
+		store.Users = users
+	}
+	if config.OIDC != nil {
+		identities, err := newIdentityStore(store, config.Accounts.Store)
+		if err != nil {
+			return nil, err
+		}
+		store.Identities = identities
+	}
+	if config.MFA != nil {
+		factors, err := newMFAStore(store, config.Accounts.Store)
+		if err != nil {
+			return nil, err
+		}
+		store.MFA = factors
+	}
+	if config.MagicLink != nil {
+		links, err := newMagicLinkStore(store, config.Accounts.Store)
+		if err != nil {
+			return nil, err
+		}
+		store.MagicLinks = links
+	}
+	if config.APIKeys != nil {
+		keys, err := newAPIKeyStore(store, config.Accounts.Store)
+		if err != nil {
+			return nil, err
+		}
+		store.APIKeys = keys
+	}
+	if config.JWT != nil {
+		tokens, err := newJWTStore(store, config.JWT.Store)
+		if err != nil {
+			return nil, err
+		}
+		store.JWT = tokens
+	}
+	if config.Authz != nil {
+		policies, err := newPolicyStore(store, config.Authz.Store)
+		if err != nil {
+			return nil, err
+		}
+		store.Policies = policies
+	}
+	if config.Jobs != nil {
+		backend, err := newJobStore(store, config.Jobs.Store)
+		if err != nil {
+			return nil, err
+		}
+		store.Jobs = backend
+	}
+	if config.Scheduler != nil {
+		store.Leader = newLeaderLock(store, config.Scheduler.Lock)
+	}
+	if config.SSE != nil && config.SSE.Broker == utils.SSE_BROKER_VALKEY {
+		store.Events = valkey_store.NewPubSub(store.Valkey, valkey_store.SSE_CHANNEL)
+	}
+	if config.WebSocket != nil && config.WebSocket.Broker == utils.WS_BROKER_VALKEY {
+		store.WebSocket = valkey_store.NewPubSub(store.Valkey, valkey_store.WS_CHANNEL)
+	}
+	if config.Uploads != nil {
+		blobs, err := newBlobStore(store, config.Uploads)
+		if err != nil {
+			return nil, err
+		}
+		store.Blobs = blobs
+	}
+	return store, nil
+}
+
+// Create user store backed by the configured database
+func newUserStore(store *Store, kind string) (accounts.UserStore, error) {
+	if kind == utils.ACCOUNTS_STORE_MONGO {
+		return mongo_store.NewUserStore(context.Background(), store.Mongo)
+	}
+	return postgres_store.NewUserStore(context.Background(), store.Postgres)
+}
+
+// Create identity store backed by the database of the users
+func newIdentityStore(store *Store, kind string) (oidc.IdentityStore, error) {
+	if kind == utils.ACCOUNTS_STORE_MONGO {
+		return mongo_store.NewIdentityStore(context.Background(), store.Mongo)
+	}
+	return postgres_store.NewIdentityStore(context.Background(), store.Postgres)
+}
+
+// Create second factor store backed by the database of the users
+func newMFAStore(store *Store, kind string) (mfa.Store, error) {
+	if kind == utils.ACCOUNTS_STORE_MONGO {
+		return mongo_store.NewMFAStore(context.Background(), store.Mongo)
+	}
+	return postgres_store.NewMFAStore(context.Background(), store.Postgres)
+}
+
+// Create login link store backed by the database of the users
+func newMagicLinkStore(store *Store, kind string) (magiclink.Store, error) {
+	if kind == utils.ACCOUNTS_STORE_MONGO {
+		return mongo_store.NewMagicLinkStore(context.Background(), store.Mongo)
+	}
+	return postgres_store.NewMagicLinkStore(context.Background(), store.Postgres)
+}
+
+// Create API key store backed by the database of the users
+func newAPIKeyStore(store *Store, kind string) (apikeys.Store, error) {
+	if kind == utils.ACCOUNTS_STORE_MONGO {
+		return mongo_store.NewAPIKeyStore(context.Background(), store.Mongo)
+	}
+	return postgres_store.NewAPIKeyStore(context.Background(), store.Postgres)
+}
+
+// Create job queue backend on the configured database
+func newJobStore(store *Store, kind string) (jobs.Backend, error) {
+	if kind == utils.JOBS_STORE_VALKEY {
+		return valkey_store.NewJobStore(store.Valkey), nil
+	}
+	return postgres_store.NewJobStore(context.Background(), store.Postgres)
+}
+
+// Create blob store of the uploaded files. The S3 bucket is created when
+// it doesn't exist yet.
+func newBlobStore(store *Store, cfg *utils.UploadsConfig) (uploads.BlobStore, error) {
+	switch cfg.Store {
+	case utils.UPLOADS_STORE_S3:
+		blobs, err := uploads.NewS3Store(cfg.S3)
+		if err != nil {
+			return nil, err
+		}
+		return blobs, blobs.EnsureBucket(context.Background())
+	case utils.UPLOADS_STORE_GRIDFS:
+		return mongo_store.NewBlobStore(store.Mongo)
+	default:
+		return uploads.NewLocalStore(cfg.Dir), nil
+	}
+}
+
+// Create leader election of the scheduler on the configured database
+func newLeaderLock(store *Store, kind string) scheduler.Elector {
+	switch kind {
+	case utils.SCHEDULER_LOCK_VALKEY:
+		return valkey_store.NewLeaderLock(store.Valkey, "scheduler")
+	case utils.SCHEDULER_LOCK_POSTGRES:
+		return postgres_store.NewLeaderLock(store.Postgres, "scheduler")
+	default:
+		return scheduler.LocalElector{}
+	}
+}
+
+// Create session store backed by the configured database
+func newSessionStore(store *Store, kind string) (session.SessionStore, error) {
+	switch kind {
+	case utils.SESSION_STORE_VALKEY:
+		return valkey_store.NewSessionStore(store.Valkey), nil
+	case utils.SESSION_STORE_MONGO:
+		return mongo_store.NewSessionStore(context.Background(), store.Mongo)
+	case utils.SESSION_STORE_POSTGRES:
+		return postgres_store.NewSessionStore(context.Background(), store.Postgres)
+	default:
+		return session.NewCookieStore(), nil
+	}
+}
+
+// Create refresh token and revocation store backed by the configured database
+func newJWTStore(store *Store, kind string) (jwt.Store, error) {
+	if kind == utils.JWT_STORE_VALKEY {
+		return valkey_store.NewJWTStore(store.Valkey), nil
+	}
+	return postgres_store.NewJWTStore(context.Background(), store.Postgres)
+}
+
+// Create role and role assignment store backed by the configured database
+func newPolicyStore(store *Store, kind string) (authz.PolicyStore, error) {
+	if kind == utils.AUTHZ_STORE_MONGO {
+		return mongo_store.NewPolicyStore(context.Background(), store.Mongo)
+	}
+	return postgres_store.NewPolicyStore(context.Background(), store.Postgres)
 }
@@ -0,0 +1,489 @@
+package valkey_store
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mcgtrt/go-puerto/internal/jobs"
+	"github.com/mcgtrt/go-puerto/internal/tracing"
+	"github.com/mcgtrt/go-puerto/utils"
+	"github.com/valkey-io/valkey-go"
+)
+
+// Keys of the job queue. Jobs are JSON under JOB_KEY_PREFIX, waiting jobs
+// sit in the delayed sorted set until due and are then moved to the
+// stream of their kind and priority, read by the consumer group of all
+// instances. Status sets list jobs for the admin page.
+const (
+	JOBS_KEY_PREFIX        = "jobs:"
+	JOB_KEY_PREFIX         = JOBS_KEY_PREFIX + "job:"
+	JOBS_DELAYED_KEY       = JOBS_KEY_PREFIX + "delayed"
+	JOBS_STREAM_KEY_PREFIX = JOBS_KEY_PREFIX + "stream:"
+	JOBS_STATUS_KEY_PREFIX = JOBS_KEY_PREFIX + "status:"
+	JOBS_UNIQUE_KEY_PREFIX = JOBS_KEY_PREFIX + "unique:"
+	JOBS_GROUP             = "workers"
+	// Due jobs moved to the streams on one claim
+	JOBS_PROMOTE_BATCH = 100
+)
+
+// KEYS: job, delayed, queued status, unique key (optional)
+// ARGV: id, job JSON, run at ms, updated at ms
+var enqueueJob = valkey.NewLuaScript(`
+if KEYS[4] and not redis.call('SET', KEYS[4], ARGV[1], 'NX') then
+	return 0
+end
+redis.call('SET', KEYS[1], ARGV[2])
+redis.call('ZADD', KEYS[2], ARGV[3], ARGV[1])
+redis.call('ZADD', KEYS[3], ARGV[4], ARGV[1])
+return 1`)
+
+// Move due jobs to their streams. Stream keys come from the job, so the
+// script needs a single Valkey node rather than a cluster.
+// KEYS: delayed; ARGV: now ms, batch size, key prefix
+var promoteJobs = valkey.NewLuaScript(`
+local ids = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, ARGV[2])
+for _, id in ipairs(ids) do
+	redis.call('ZREM', KEYS[1], id)
+	local data = redis.call('GET', ARGV[3] .. 'job:' .. id)
+	if data then
+		local job = cjson.decode(data)
+		redis.call('XADD', ARGV[3] .. 'stream:' .. job.kind .. ':' .. job.priority, '*', 'id', id)
+	end
+end
+return #ids`)
+
+// Mark the job of a stream entry running with the JSON made from its data
+// read before. Returns 0 when the job is gone, failed or waiting to be
+// due again, so the entry is dropped, and -1 when the job changed since
+// it was read, e.g. by the worker of an expired lease.
+// KEYS: job, delayed, queued status, running status
+// ARGV: id, job JSON read, running job JSON, now ms
+var startJob = valkey.NewLuaScript(`
+local data = redis.call('GET', KEYS[1])
+if not data then
+	return 0
+end
+local job = cjson.decode(data)
+if job.status == 'failed' or job.status == 'queued' and redis.call('ZSCORE', KEYS[2], ARGV[1]) then
+	return 0
+end
+if data ~= ARGV[2] then
+	return -1
+end
+redis.call('SET', KEYS[1], ARGV[3])
+redis.call('ZREM', KEYS[3], ARGV[1])
+redis.call('ZADD', KEYS[4], ARGV[4], ARGV[1])
+return 1`)
+
+// Record the result of the claimed job unless another worker claimed it
+// again or it was deleted. Jobs are deleted when ARGV[3] is empty, stored
+// and listed with the status of KEYS[3] otherwise.
+// KEYS: job, running status, status, delayed, unique key (optional)
+// ARGV: id, claim ID, job JSON, updated at ms, run at ms (optional)
+var finishJob = valkey.NewLuaScript(`
+local data = redis.call('GET', KEYS[1])
+if not data then
+	return 0
+end
+local job = cjson.decode(data)
+if job.status ~= 'running' or job.claim_id ~= ARGV[2] then
+	return 0
+end
+redis.call('ZREM', KEYS[2], ARGV[1])
+if ARGV[3] == '' then
+	redis.call('DEL', KEYS[1])
+else
+	redis.call('SET', KEYS[1], ARGV[3])
+	redis.call('ZADD', KEYS[3], ARGV[4], ARGV[1])
+end
+if ARGV[5] ~= '' then
+	redis.call('ZADD', KEYS[4], ARGV[5], ARGV[1])
+end
+if KEYS[5] then
+	redis.call('DEL', KEYS[5])
+end
+return 1`)
+
+// Job queue backend on Valkey streams. Every instance reads the streams
+// in one consumer group, so a job is delivered to one worker. Entries of
+// workers which stopped without finishing are claimed again with
+// XAUTOCLAIM once idle longer than the lease.
+type JobStore struct {
+	store    *ValkeyStore
+	consumer string
+	groups   sync.Map
+}
+
+func NewJobStore(store *ValkeyStore) *JobStore {
+	return &JobStore{store: store, consumer: utils.NewUUIDv7()}
+}
+
+func (s *JobStore) Enqueue(ctx context.Context, j *jobs.Job) (err error) {
+	ctx, span := startSpan(ctx, "EVALSHA")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	data, err := json.Marshal(j)
+	if err != nil {
+		return err
+	}
+	keys := []string{JOB_KEY_PREFIX + j.ID, JOBS_DELAYED_KEY, JOBS_STATUS_KEY_PREFIX + jobs.STATUS_QUEUED}
+	if j.UniqueKey != "" {
+		keys = append(keys, JOBS_UNIQUE_KEY_PREFIX+j.UniqueKey)
+	}
+	added, err := enqueueJob.Exec(ctx, s.store.Client, keys, []string{j.ID, string(data), millis(j.RunAt), millis(j.UpdatedAt)}).AsInt64()
+	if err != nil {
+		return err
+	}
+	if added == 0 {
+		return jobs.ErrDuplicate
+	}
+	return nil
+}
+
+func (s *JobStore) Claim(ctx context.Context, kinds []string, lease time.Duration) (_ *jobs.Job, err error) {
+	ctx, span := startSpan(ctx, "XREADGROUP")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	client := s.store.Client
+	now := time.Now()
+	err = promoteJobs.Exec(ctx, client, []string{JOBS_DELAYED_KEY}, []string{millis(now), strconv.Itoa(JOBS_PROMOTE_BATCH), JOBS_KEY_PREFIX}).Error()
+	if err != nil {
+		return nil, err
+	}
+	for priority := jobs.PRIORITY_HIGH; priority >= jobs.PRIORITY_LOW; priority-- {
+		for _, kind := range kinds {
+			stream := JOBS_STREAM_KEY_PREFIX + kind + ":" + strconv.Itoa(priority)
+			entry, found, err := s.read(ctx, stream, lease)
+			if err != nil {
+				return nil, err
+			}
+			if !found {
+				continue
+			}
+			j, err := s.start(ctx, stream, entry, now, lease)
+			if err != nil {
+				return nil, err
+			}
+			if j != nil {
+				return j, nil
+			}
+		}
+	}
+	return nil, jobs.ErrNoJob
+}
+
+// Read the next entry of the stream, taking over entries abandoned for
+// longer than the lease first
+func (s *JobStore) read(ctx context.Context, stream string, lease time.Duration) (valkey.XRangeEntry, bool, error) {
+	client := s.store.Client
+	if err := s.createGroup(ctx, stream); err != nil {
+		return valkey.XRangeEntry{}, false, err
+	}
+	claimed, err := client.Do(ctx, client.B().Xautoclaim().Key(stream).Group(JOBS_GROUP).Consumer(s.consumer).
+		MinIdleTime(strconv.FormatInt(lease.Milliseconds(), 10)).Start("0-0").Count(1).Build()).ToArray()
+	if err != nil {
+		return valkey.XRangeEntry{}, false, err
+	}
+	if len(claimed) > 1 {
+		entries, err := claimed[1].AsXRange()
+		if err != nil {
+			return valkey.XRangeEntry{}, false, err
+		}
+		if len(entries) > 0 {
+			return entries[0], true, nil
+		}
+	}
+	streams, err := client.Do(ctx, client.B().Xreadgroup().Group(JOBS_GROUP, s.consumer).Count(1).Streams().Key(stream).Id(">").Build()).AsXRead()
+	if valkey.IsValkeyNil(err) {
+		return valkey.XRangeEntry{}, false, nil
+	}
+	if err != nil {
+		return valkey.XRangeEntry{}, false, err
+	}
+	if entries := streams[stream]; len(entries) > 0 {
+		return entries[0], true, nil
+	}
+	return valkey.XRangeEntry{}, false, nil
+}
+
+// Mark the job of the stream entry running. Entries of deleted jobs, or
+// of jobs finished meanwhile, are dropped and nil is returned.
+func (s *JobStore) start(ctx context.Context, stream string, entry valkey.XRangeEntry, now time.Time, lease time.Duration) (*jobs.Job, error) {
+	receipt := stream + " " + entry.ID
+	id := entry.FieldValues["id"]
+	keys := []string{JOB_KEY_PREFIX + id, JOBS_DELAYED_KEY, JOBS_STATUS_KEY_PREFIX + jobs.STATUS_QUEUED, JOBS_STATUS_KEY_PREFIX + jobs.STATUS_RUNNING}
+	for {
+		data, found, err := s.store.Get(ctx, JOB_KEY_PREFIX+id)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			return nil, s.ack(ctx, receipt)
+		}
+		j := &jobs.Job{}
+		if err := json.Unmarshal([]byte(data), j); err != nil {
+			return nil, err
+		}
+		lockedUntil := now.Add(lease)
+		j.Status, j.LockedUntil, j.UpdatedAt, j.ClaimID, j.Receipt = jobs.STATUS_RUNNING, &lockedUntil, now, utils.NewUUIDv7(), receipt
+		j.Attempts++
+		running, err := json.Marshal(j)
+		if err != nil {
+			return nil, err
+		}
+		started, err := startJob.Exec(ctx, s.store.Client, keys, []string{id, data, string(running), millis(now)}).AsInt64()
+		if err != nil {
+			return nil, err
+		}
+		switch started {
+		case 1:
+			return j, nil
+		case 0:
+			return nil, s.ack(ctx, receipt)
+		}
+		// Changed since it was read, read it again
+	}
+}
+
+func (s *JobStore) Complete(ctx context.Context, j *jobs.Job) (err error) {
+	ctx, span := startSpan(ctx, "XACK")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	return s.finish(ctx, j, jobs.STATUS_RUNNING, nil, time.Time{}, j.UniqueKey)
+}
+
+func (s *JobStore) Retry(ctx context.Context, j *jobs.Job, runAt time.Time, lastError string) (err error) {
+	ctx, span := startSpan(ctx, "XACK")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	now := time.Now()
+	retried := *j
+	retried.Status, retried.RunAt, retried.LastError, retried.LockedUntil, retried.UpdatedAt = jobs.STATUS_QUEUED, runAt, lastError, nil, now
+	return s.finish(ctx, j, jobs.STATUS_QUEUED, &retried, runAt, "")
+}
+
+func (s *JobStore) Fail(ctx context.Context, j *jobs.Job, lastError string) (err error) {
+	ctx, span := startSpan(ctx, "XACK")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	now := time.Now()
+	failed := *j
+	failed.Status, failed.LastError, failed.LockedUntil, failed.UpdatedAt = jobs.STATUS_FAILED, lastError, nil, now
+	return s.finish(ctx, j, jobs.STATUS_FAILED, &failed, time.Time{}, j.UniqueKey)
+}
+
+// Store the result of the claimed job fenced on its claim ID and
+// acknowledge its stream entry. Nil result deletes the job, non-zero
+// runAt schedules it again. The stream entry of a lost lease belongs to
+// the worker which claimed it again, so it's not acknowledged.
+func (s *JobStore) finish(ctx context.Context, j *jobs.Job, status string, result *jobs.Job, runAt time.Time, uniqueKey string) error {
+	var data []byte
+	updatedAt := time.Now()
+	if result != nil {
+		var err error
+		if data, err = json.Marshal(result); err != nil {
+			return err
+		}
+		updatedAt = result.UpdatedAt
+	}
+	keys := []string{JOB_KEY_PREFIX + j.ID, JOBS_STATUS_KEY_PREFIX + jobs.STATUS_RUNNING, JOBS_STATUS_KEY_PREFIX + status, JOBS_DELAYED_KEY}
+	if uniqueKey != "" {
+		keys = append(keys, JOBS_UNIQUE_KEY_PREFIX+uniqueKey)
+	}
+	args := []string{j.ID, j.ClaimID, string(data), millis(updatedAt), ""}
+	if !runAt.IsZero() {
+		args[4] = millis(runAt)
+	}
+	finished, err := finishJob.Exec(ctx, s.store.Client, keys, args).AsInt64()
+	if err != nil {
+		return err
+	}
+	if finished == 0 {
+		return jobs.ErrLeaseLost
+	}
+	return s.ack(ctx, j.Receipt)
+}
+
+func (s *JobStore) List(ctx context.Context, status string, limit int) (_ []jobs.Job, err error) {
+	ctx, span := startSpan(ctx, "ZRANGE")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	client := s.store.Client
+	ids, err := client.Do(ctx, client.B().Zrange().Key(JOBS_STATUS_KEY_PREFIX+status).Min("+inf").Max("-inf").Byscore().Rev().Limit(0, int64(limit)).Build()).AsStrSlice()
+	if err != nil || len(ids) == 0 {
+		return []jobs.Job{}, err
+	}
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = JOB_KEY_PREFIX + id
+	}
+	values, err := client.Do(ctx, client.B().Mget().Key(keys...).Build()).ToArray()
+	if err != nil {
+		return nil, err
+	}
+	list := make([]jobs.Job, 0, len(values))
+	for _, value := range values {
+		data, err := value.ToString()
+		if valkey.IsValkeyNil(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		var j jobs.Job
+		if err := json.Unmarshal([]byte(data), &j); err != nil {
+			return nil, err
+		}
+		list = append(list, j)
+	}
+	return list, nil
+}
+
+func (s *JobStore) Count(ctx context.Context) (_ map[string]int, err error) {
+	ctx, span := startSpan(ctx, "ZCARD")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	client := s.store.Client
+	statuses := []string{jobs.STATUS_QUEUED, jobs.STATUS_RUNNING, jobs.STATUS_FAILED}
+	cmds := make(valkey.Commands, len(statuses))
+	for i, status := range statuses {
+		cmds[i] = client.B().Zcard().Key(JOBS_STATUS_KEY_PREFIX + status).Build()
+	}
+	counts := map[string]int{}
+	for i, resp := range client.DoMulti(ctx, cmds...) {
+		n, err := resp.AsInt64()
+		if err != nil {
+			return nil, err
+		}
+		if n > 0 {
+			counts[statuses[i]] = int(n)
+		}
+	}
+	return counts, nil
+}
+
+func (s *JobStore) Requeue(ctx context.Context, id string) (err error) {
+	ctx, span := startSpan(ctx, "ZREM")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	client := s.store.Client
+	j, err := s.get(ctx, id)
+	if err != nil {
+		return err
+	}
+	if j == nil || j.Status != jobs.STATUS_FAILED {
+		return jobs.ErrJobNotFound
+	}
+	removed, err := client.Do(ctx, client.B().Zrem().Key(JOBS_STATUS_KEY_PREFIX+jobs.STATUS_FAILED).Member(id).Build()).AsInt64()
+	if err != nil {
+		return err
+	}
+	if removed == 0 {
+		return jobs.ErrJobNotFound
+	}
+	now := time.Now()
+	j.Status, j.Attempts, j.RunAt, j.UpdatedAt = jobs.STATUS_QUEUED, 0, now, now
+	if err := s.Enqueue(ctx, j); err != nil {
+		// Keep the job failed, e.g. when another job took its unique key
+		client.Do(ctx, client.B().Zadd().Key(JOBS_STATUS_KEY_PREFIX+jobs.STATUS_FAILED).ScoreMember().ScoreMember(float64(now.UnixMilli()), id).Build())
+		return err
+	}
+	return nil
+}
+
+func (s *JobStore) Delete(ctx context.Context, id string) (err error) {
+	ctx, span := startSpan(ctx, "ZREM")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	client := s.store.Client
+	removed, err := client.Do(ctx, client.B().Zrem().Key(JOBS_STATUS_KEY_PREFIX+jobs.STATUS_FAILED).Member(id).Build()).AsInt64()
+	if err != nil {
+		return err
+	}
+	if removed == 0 {
+		return jobs.ErrJobNotFound
+	}
+	return client.Do(ctx, client.B().Del().Key(JOB_KEY_PREFIX+id).Build()).Error()
+}
+
+func (s *JobStore) get(ctx context.Context, id string) (*jobs.Job, error) {
+	data, found, err := s.store.Get(ctx, JOB_KEY_PREFIX+id)
+	if err != nil || !found {
+		return nil, err
+	}
+	j := &jobs.Job{}
+	if err := json.Unmarshal([]byte(data), j); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// Acknowledge and delete the stream entry of the receipt
+func (s *JobStore) ack(ctx context.Context, receipt string) error {
+	stream, id, ok := strings.Cut(receipt, " ")
+	if !ok {
+		return nil
+	}
+	client := s.store.Client
+	return s.do(ctx, valkey.Commands{
+		client.B().Xack().Key(stream).Group(JOBS_GROUP).Id(id).Build(),
+		client.B().Xdel().Key(stream).Id(id).Build(),
+	})
+}
+
+func (s *JobStore) createGroup(ctx context.Context, stream string) error {
+	if _, ok := s.groups.Load(stream); ok {
+		return nil
+	}
+	client := s.store.Client
+	err := client.Do(ctx, client.B().XgroupCreate().Key(stream).Group(JOBS_GROUP).Id("0").Mkstream().Build()).Error()
+	if err != nil && !valkey.IsValkeyBusyGroup(err) {
+		return err
+	}
+	s.groups.Store(stream, true)
+	return nil
+}
+
+func (s *JobStore) do(ctx context.Context, cmds valkey.Commands) error {
+	for _, resp := range s.store.Client.DoMulti(ctx, cmds...) {
+		if err := resp.Error(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func millis(t time.Time) string {
+	return strconv.FormatInt(t.UnixMilli(), 10)
+}
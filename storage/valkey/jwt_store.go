@@ -0,0 +1,153 @@
+package valkey_store
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/mcgtrt/go-puerto/internal/jwt"
+	"github.com/mcgtrt/go-puerto/internal/tracing"
+	"github.com/valkey-io/valkey-go"
+)
+
+// Key prefixes of refresh tokens, their use markers, token families,
+// per-user family sets and revoked access token IDs
+const (
+	REFRESH_KEY_PREFIX        = "jwt:refresh:"
+	REFRESH_USED_KEY_PREFIX   = "jwt:refresh:used:"
+	REFRESH_FAMILY_KEY_PREFIX = "jwt:refresh:family:"
+	REFRESH_USER_KEY_PREFIX   = "jwt:refresh:user:"
+	REVOKED_JWT_KEY_PREFIX    = "jwt:revoked:"
+)
+
+// Refresh token store and revocation list. Every token lives under its
+// own key expiring with the token and gets a use marker set with NX on
+// the first use, so concurrent refreshes can't both succeed.
+type JWTStore struct {
+	store *ValkeyStore
+}
+
+func NewJWTStore(store *ValkeyStore) *JWTStore {
+	return &JWTStore{store: store}
+}
+
+func (s *JWTStore) Save(ctx context.Context, t *jwt.RefreshToken) (err error) {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	ttl := time.Until(t.ExpiresAt)
+	if err := s.store.Set(ctx, REFRESH_KEY_PREFIX+t.Hash, string(data), ttl); err != nil {
+		return err
+	}
+
+	ctx, span := startSpan(ctx, "SADD")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	client := s.store.Client
+	family, user := REFRESH_FAMILY_KEY_PREFIX+t.FamilyID, REFRESH_USER_KEY_PREFIX+t.UserID
+	for _, resp := range client.DoMulti(ctx,
+		client.B().Sadd().Key(family).Member(t.Hash).Build(),
+		client.B().Pexpire().Key(family).Milliseconds(ttl.Milliseconds()).Build(),
+		client.B().Sadd().Key(user).Member(t.FamilyID).Build(),
+		client.B().Pexpire().Key(user).Milliseconds(ttl.Milliseconds()).Gt().Build(),
+		client.B().Pexpire().Key(user).Milliseconds(ttl.Milliseconds()).Nx().Build(),
+	) {
+		if err := resp.Error(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *JWTStore) Use(ctx context.Context, hash string) (*jwt.RefreshToken, error) {
+	data, found, err := s.store.Get(ctx, REFRESH_KEY_PREFIX+hash)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, jwt.ErrRefreshNotFound
+	}
+	t := &jwt.RefreshToken{}
+	if err := json.Unmarshal([]byte(data), t); err != nil {
+		return nil, err
+	}
+	first, err := s.markUsed(ctx, hash, time.Until(t.ExpiresAt))
+	if err != nil {
+		return nil, err
+	}
+	if !first {
+		return t, jwt.ErrRefreshReused
+	}
+	now := time.Now()
+	t.UsedAt = &now
+	return t, nil
+}
+
+func (s *JWTStore) markUsed(ctx context.Context, hash string, ttl time.Duration) (first bool, err error) {
+	ctx, span := startSpan(ctx, "SET")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	cmd := s.store.Client.B().Set().Key(REFRESH_USED_KEY_PREFIX + hash).Value("1").Nx().Px(ttl).Build()
+	err = s.store.Client.Do(ctx, cmd).Error()
+	if valkey.IsValkeyNil(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *JWTStore) RevokeFamily(ctx context.Context, familyID string) error {
+	key := REFRESH_FAMILY_KEY_PREFIX + familyID
+	hashes, err := s.members(ctx, key)
+	if err != nil {
+		return err
+	}
+	keys := []string{key}
+	for _, hash := range hashes {
+		keys = append(keys, REFRESH_KEY_PREFIX+hash)
+	}
+	return s.store.Del(ctx, keys...)
+}
+
+func (s *JWTStore) RevokeUser(ctx context.Context, userID string) error {
+	key := REFRESH_USER_KEY_PREFIX + userID
+	families, err := s.members(ctx, key)
+	if err != nil {
+		return err
+	}
+	for _, family := range families {
+		if err := s.RevokeFamily(ctx, family); err != nil {
+			return err
+		}
+	}
+	return s.store.Del(ctx, key)
+}
+
+func (s *JWTStore) members(ctx context.Context, key string) (members []string, err error) {
+	ctx, span := startSpan(ctx, "SMEMBERS")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	return s.store.Client.Do(ctx, s.store.Client.B().Smembers().Key(key).Build()).AsStrSlice()
+}
+
+func (s *JWTStore) Revoke(ctx context.Context, jti string, expiresAt time.Time) error {
+	ttl := time.Until(expiresAt)
+	if ttl <= 0 {
+		return nil
+	}
+	return s.store.Set(ctx, REVOKED_JWT_KEY_PREFIX+jti, "1", ttl)
+}
+
+func (s *JWTStore) IsRevoked(ctx context.Context, jti string) (bool, error) {
+	_, found, err := s.store.Get(ctx, REVOKED_JWT_KEY_PREFIX+jti)
+	return found, err
+}
@@ -0,0 +1,65 @@
+package valkey_store
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/mcgtrt/go-puerto/internal/tracing"
+	"github.com/mcgtrt/go-puerto/utils"
+	"github.com/valkey-io/valkey-go"
+)
+
+const LEADER_KEY_PREFIX = "leader:"
+
+// KEYS: lock; ARGV: holder, ttl ms
+var campaignLeader = valkey.NewLuaScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+	return 1
+end
+if redis.call('SET', KEYS[1], ARGV[1], 'NX', 'PX', ARGV[2]) then
+	return 1
+end
+return 0`)
+
+// KEYS: lock; ARGV: holder
+var resignLeader = valkey.NewLuaScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0`)
+
+// Leader election with a lock key expiring after the ttl. The leader
+// renews it, so another instance takes over within the ttl after the
+// leader stops.
+type LeaderLock struct {
+	store  *ValkeyStore
+	key    string
+	holder string
+}
+
+func NewLeaderLock(store *ValkeyStore, name string) *LeaderLock {
+	return &LeaderLock{store: store, key: LEADER_KEY_PREFIX + name, holder: utils.NewUUIDv7()}
+}
+
+func (l *LeaderLock) Campaign(ctx context.Context, ttl time.Duration) (_ bool, err error) {
+	ctx, span := startSpan(ctx, "EVALSHA")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	leader, err := campaignLeader.Exec(ctx, l.store.Client, []string{l.key}, []string{l.holder, strconv.FormatInt(ttl.Milliseconds(), 10)}).AsInt64()
+	return leader == 1, err
+}
+
+func (l *LeaderLock) Resign(ctx context.Context) (err error) {
+	ctx, span := startSpan(ctx, "EVALSHA")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	return resignLeader.Exec(ctx, l.store.Client, []string{l.key}, []string{l.holder}).Error()
+}
@@ -0,0 +1,45 @@
+package valkey_store
+
+import (
+	"context"
+
+	"github.com/mcgtrt/go-puerto/internal/tracing"
+	"github.com/valkey-io/valkey-go"
+)
+
+// Channel of server-sent events shared by all instances
+const SSE_CHANNEL = "sse:events"
+
+// Channel of WebSocket broadcasts shared by all instances
+const WS_CHANNEL = "ws:events"
+
+// Pub/sub channel carrying messages to all instances. Messages published
+// while an instance is reconnecting don't reach it.
+type PubSub struct {
+	store   *ValkeyStore
+	channel string
+}
+
+func NewPubSub(store *ValkeyStore, channel string) *PubSub {
+	return &PubSub{store: store, channel: channel}
+}
+
+func (p *PubSub) Publish(ctx context.Context, payload []byte) (err error) {
+	ctx, span := startSpan(ctx, "PUBLISH")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	client := p.store.Client
+	return client.Do(ctx, client.B().Publish().Channel(p.channel).Message(valkey.BinaryString(payload)).Build()).Error()
+}
+
+// Blocks on a dedicated connection until the context is done or the
+// connection fails
+func (p *PubSub) Subscribe(ctx context.Context, fn func(payload []byte)) error {
+	client := p.store.Client
+	return client.Receive(ctx, client.B().Subscribe().Channel(p.channel).Build(), func(msg valkey.PubSubMessage) {
+		fn([]byte(msg.Message))
+	})
+}
@@ -0,0 +1,101 @@
+package valkey_store
+
+import (
+	"context"
+	"time"
+
+	"github.com/mcgtrt/go-puerto/internal/session"
+	"github.com/mcgtrt/go-puerto/internal/tracing"
+)
+
+// Key prefixes of sessions and per-user session ID sets
+const (
+	SESSION_KEY_PREFIX      = "session:"
+	USER_SESSION_KEY_PREFIX = "session:user:"
+)
+
+// Session store keeping every session under its own key expiring with the
+// session. IDs of user sessions are indexed in a set for bulk revocation.
+type SessionStore struct {
+	store *ValkeyStore
+}
+
+func NewSessionStore(store *ValkeyStore) *SessionStore {
+	return &SessionStore{store: store}
+}
+
+func (s *SessionStore) Load(ctx context.Context, token string) (*session.Session, error) {
+	data, found, err := s.store.Get(ctx, SESSION_KEY_PREFIX+token)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, session.ErrNotFound
+	}
+	return session.Decode([]byte(data))
+}
+
+func (s *SessionStore) Save(ctx context.Context, sess *session.Session, ttl time.Duration) (string, error) {
+	data, err := session.Encode(sess)
+	if err != nil {
+		return "", err
+	}
+	if err := s.store.Set(ctx, SESSION_KEY_PREFIX+sess.ID, string(data), ttl); err != nil {
+		return "", err
+	}
+	if sess.UserID != "" {
+		if err := s.indexUserSession(ctx, sess.UserID, sess.ID, ttl); err != nil {
+			return "", err
+		}
+	}
+	return sess.ID, nil
+}
+
+// The user set lives as long as the longest of the user sessions. IDs of
+// expired sessions are left in the set - deleting them is a no-op.
+func (s *SessionStore) indexUserSession(ctx context.Context, userID, id string, ttl time.Duration) (err error) {
+	ctx, span := startSpan(ctx, "SADD")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	client, key := s.store.Client, USER_SESSION_KEY_PREFIX+userID
+	for _, resp := range client.DoMulti(ctx,
+		client.B().Sadd().Key(key).Member(id).Build(),
+		client.B().Pexpire().Key(key).Milliseconds(ttl.Milliseconds()).Gt().Build(),
+		client.B().Pexpire().Key(key).Milliseconds(ttl.Milliseconds()).Nx().Build(),
+	) {
+		if err := resp.Error(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SessionStore) Delete(ctx context.Context, id string) error {
+	return s.store.Del(ctx, SESSION_KEY_PREFIX+id)
+}
+
+func (s *SessionStore) DeleteByUser(ctx context.Context, userID string) (err error) {
+	key := USER_SESSION_KEY_PREFIX + userID
+	ids, err := s.userSessions(ctx, key)
+	if err != nil {
+		return err
+	}
+	keys := []string{key}
+	for _, id := range ids {
+		keys = append(keys, SESSION_KEY_PREFIX+id)
+	}
+	return s.store.Del(ctx, keys...)
+}
+
+func (s *SessionStore) userSessions(ctx context.Context, key string) (ids []string, err error) {
+	ctx, span := startSpan(ctx, "SMEMBERS")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	return s.store.Client.Do(ctx, s.store.Client.B().Smembers().Key(key).Build()).AsStrSlice()
+}
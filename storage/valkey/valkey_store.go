@@ -1,8 +1,79 @@
 package valkey_store
 
+import (
+	"context"
+	"time"
+
+	"github.com/mcgtrt/go-puerto/internal/metrics"
+	"github.com/mcgtrt/go-puerto/internal/tracing"
+	"github.com/valkey-io/valkey-go"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
 type ValkeyStore struct {
+	Client valkey.Client
+}
+
+// Default valkey store setup with client connection
+func NewValkeyStore(client valkey.Client) *ValkeyStore {
+	return &ValkeyStore{
+		Client: client,
+	}
+}
+
+// Get the string value of the key. Missing key is not an error - found
+// is false instead. Every lookup is counted as a cache hit or miss.
+func (s *ValkeyStore) Get(ctx context.Context, key string) (value string, found bool, err error) {
+	ctx, span := startSpan(ctx, "GET")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	value, err = s.Client.Do(ctx, s.Client.B().Get().Key(key).Build()).ToString()
+	if valkey.IsValkeyNil(err) {
+		metrics.ValkeyRequests.WithLabelValues("miss").Inc()
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	metrics.ValkeyRequests.WithLabelValues("hit").Inc()
+	return value, true, nil
+}
+
+// Set the value of the key. Zero ttl stores the key without expiration
+func (s *ValkeyStore) Set(ctx context.Context, key, value string, ttl time.Duration) (err error) {
+	ctx, span := startSpan(ctx, "SET")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	if ttl > 0 {
+		return s.Client.Do(ctx, s.Client.B().Set().Key(key).Value(value).Px(ttl).Build()).Error()
+	}
+	return s.Client.Do(ctx, s.Client.B().Set().Key(key).Value(value).Build()).Error()
+}
+
+// Delete given keys
+func (s *ValkeyStore) Del(ctx context.Context, keys ...string) (err error) {
+	ctx, span := startSpan(ctx, "DEL")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	return s.Client.Do(ctx, s.Client.B().Del().Key(keys...).Build()).Error()
 }
 
-func NewValkeyStore() *ValkeyStore {
-	return &ValkeyStore{}
+func startSpan(ctx context.Context, command string) (context.Context, trace.Span) {
+	return tracing.Tracer().Start(ctx, "valkey."+command,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemRedis,
+			semconv.DBOperationName(command),
+		),
+	)
 }
@@ -0,0 +1,21 @@
+package emails
+
+import "github.com/mcgtrt/go-puerto/internal"
+
+// Subjects of the emails, also used as their headings
+
+func VerifyEmailSubject(lang string) string {
+	return internal.Localise(lang, "mail.verify_email.subject", "Verify your email")
+}
+
+func PasswordResetSubject(lang string) string {
+	return internal.Localise(lang, "mail.password_reset.subject", "Reset your password")
+}
+
+func AccountExistsSubject(lang string) string {
+	return internal.Localise(lang, "mail.account_exists.subject", "You already have an account")
+}
+
+func MagicLinkSubject(lang string) string {
+	return internal.Localise(lang, "mail.magic_link.subject", "Your login link")
+}
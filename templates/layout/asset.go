@@ -0,0 +1,8 @@
+package layout
+
+import "github.com/mcgtrt/go-puerto/internal/assets"
+
+// Resolve the fingerprinted URL of a static file, e.g. asset("app.css")
+func asset(name string) string {
+	return assets.URL(name)
+}
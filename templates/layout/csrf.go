@@ -0,0 +1,27 @@
+package layout
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"strings"
+
+	"github.com/mcgtrt/go-puerto/internal/csrf"
+)
+
+// JSON value of hx-headers sending the CSRF token with every HTMX request
+func csrfHeaders(ctx context.Context) string {
+	headers, _ := json.Marshal(map[string]string{csrf.HEADER_NAME: csrf.Token(ctx)})
+	return string(headers)
+}
+
+// Action of multipart forms carrying the CSRF token in the query. The
+// middleware doesn't parse multipart bodies, so forms posted without
+// JavaScript can't send the token in a field.
+func CSRFAction(ctx context.Context, action string) string {
+	sep := "?"
+	if strings.Contains(action, "?") {
+		sep = "&"
+	}
+	return action + sep + csrf.FIELD_NAME + "=" + url.QueryEscape(csrf.Token(ctx))
+}
@@ -0,0 +1,19 @@
+package layout
+
+import (
+	"strconv"
+
+	"github.com/mcgtrt/go-puerto/internal/images"
+)
+
+func (p PictureProps) params(format string) images.Params {
+	return images.Params{Width: p.Width, Height: p.Height, Fit: p.Fit, Format: format}
+}
+
+func (p PictureProps) sizes() string {
+	if p.Sizes != "" || p.Width == 0 {
+		return p.Sizes
+	}
+	w := strconv.Itoa(p.Width) + "px"
+	return "(max-width: " + w + ") 100vw, " + w
+}
@@ -0,0 +1,42 @@
+package layout
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/skip2/go-qrcode"
+)
+
+// QR code drawn as a single SVG path. Rendering inline keeps secrets
+// (e.g. TOTP enrolment) out of image URLs, logs and caches.
+type qrCode struct {
+	ViewBox string
+	Path    string
+}
+
+// Encode the content into the path of the dark modules, joining runs of
+// modules in a row to keep the path short
+func newQRCode(content string) (*qrCode, error) {
+	qr, err := qrcode.New(content, qrcode.Medium)
+	if err != nil {
+		return nil, err
+	}
+	bitmap := qr.Bitmap()
+	var path strings.Builder
+	for y, row := range bitmap {
+		for x := 0; x < len(row); x++ {
+			if !row[x] {
+				continue
+			}
+			start := x
+			for x < len(row) && row[x] {
+				x++
+			}
+			fmt.Fprintf(&path, "M%d %dh%dv1h-%dz", start, y, x-start, x-start)
+		}
+	}
+	return &qrCode{
+		ViewBox: fmt.Sprintf("0 0 %d %d", len(bitmap), len(bitmap)),
+		Path:    path.String(),
+	}, nil
+}
@@ -3,3 +3,9 @@ package types
 // Context keys for unique accessing context values
 type LanguageCtxKey struct{}
 type CurrencyCtxKey struct{}
+type LogAttrsCtxKey struct{}
+type RequestIDCtxKey struct{}
+type CSRFTokenCtxKey struct{}
+type SessionCtxKey struct{}
+type PrincipalCtxKey struct{}
+type AuthorizerCtxKey struct{}
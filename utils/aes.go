@@ -65,3 +65,52 @@ func DecryptAES(secure string) (decoded string, err error) {
 
 	return string(cipherText), err
 }
+
+// Encrypt and authenticate message using AES-GCM. Unlike EncryptAES,
+// any modification of the result makes OpenAES fail, so use it for data
+// handed to clients (e.g. cookies). Make sure AES_SECRET key with value
+// was added to environmental variables.
+func SealAES(message string) (string, error) {
+	gcm, err := newGCM()
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize(), gcm.NonceSize()+len(message)+gcm.Overhead())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+
+	sealed := gcm.Seal(nonce, nonce, []byte(message), nil)
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt message sealed with SealAES. Returns an error if the message
+// was tampered with. Make sure AES_SECRET key with value was added to
+// environmental variables.
+func OpenAES(sealed string) (string, error) {
+	data, err := base64.RawURLEncoding.DecodeString(sealed)
+	if err != nil {
+		return "", err
+	}
+
+	gcm, err := newGCM()
+	if err != nil {
+		return "", err
+	}
+
+	if len(data) < gcm.NonceSize() {
+		return "", errors.New("ciphertext is too short")
+	}
+
+	message, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+	return string(message), err
+}
+
+func newGCM() (cipher.AEAD, error) {
+	block, err := aes.NewCipher([]byte(os.Getenv("AES_SECRET")))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
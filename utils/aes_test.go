@@ -177,3 +177,28 @@ func TestCrossSessionConsistency(t *testing.T) {
 	require.NoError(t, err, "Decryption failed in new session")
 	assert.Equal(t, message, decrypted, "Decrypted message does not match original")
 }
+
+func TestSealOpenAES(t *testing.T) {
+	require.NoError(t, os.Setenv("AES_SECRET", "thisis16byteskey"), "Failed to set AES_SECRET")
+
+	sealed, err := SealAES("secret message")
+	require.NoError(t, err, "Sealing failed")
+
+	opened, err := OpenAES(sealed)
+	require.NoError(t, err, "Opening failed")
+	assert.Equal(t, "secret message", opened, "Opened message does not match original")
+
+	// Flip a bit of the ciphertext
+	data, err := base64.RawURLEncoding.DecodeString(sealed)
+	require.NoError(t, err)
+	data[len(data)-1] ^= 1
+	_, err = OpenAES(base64.RawURLEncoding.EncodeToString(data))
+	assert.Error(t, err, "Expected tampered message to fail")
+
+	_, err = OpenAES("short")
+	assert.Error(t, err, "Expected failure for short ciphertext")
+
+	os.Setenv("AES_SECRET", "wrong16byteskey!")
+	_, err = OpenAES(sealed)
+	assert.Error(t, err, "Expected opening to fail with incorrect key")
+}
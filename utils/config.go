@@ -3,17 +3,31 @@ package utils
 import (
 	"context"
 	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net/mail"
+	"net/url"
 	"os"
+	"regexp"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/jackc/pgx/v5/pgxpool"
 	_ "github.com/joho/godotenv/autoload"
+	"github.com/valkey-io/valkey-go"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 const (
 	PROJECT_NAME                     = "PROJECT_NAME"
+	APP_ENV                          = "APP_ENV"
+	APP_URL                          = "APP_URL"
 	FILE_SERVER_PATH                 = "FILE_SERVER_PATH"
+	STATIC_DIR                       = "STATIC_DIR"
 	AES_SECRET                       = "AES_SECRET"
 	USE_DB_MONGO                     = "USE_DB_MONGO"
 	USE_DB_POSTGRES                  = "USE_DB_POSTGRES"
@@ -29,18 +43,121 @@ const (
 	USE_MW_ETAG                      = "USE_MW_ETAG"
 	USE_MW_VALIDATE_SANITISE_HEADERS = "USE_MW_VALIDATE_SANITISE_HEADERS"
 	USE_MW_METHOD_OVERRIDE           = "USE_MW_METHOD_OVERRIDE"
+	USE_MW_REQUEST_ID                = "USE_MW_REQUEST_ID"
+	MW_REQUEST_ID_HEADER             = "MW_REQUEST_ID_HEADER"
+	MW_REQUEST_ID_FORMAT             = "MW_REQUEST_ID_FORMAT"
+	USE_MW_COMPRESSION               = "USE_MW_COMPRESSION"
+	MW_COMPRESSION_MIN_SIZE          = "MW_COMPRESSION_MIN_SIZE"
+	MW_COMPRESSION_TYPES             = "MW_COMPRESSION_TYPES"
+	USE_MW_CSRF                      = "USE_MW_CSRF"
+	MW_CSRF_EXEMPT_PATHS             = "MW_CSRF_EXEMPT_PATHS"
+	MW_CSRF_TRUSTED_ORIGINS          = "MW_CSRF_TRUSTED_ORIGINS"
 	HTTP_PORT                        = "HTTP_PORT"
 	MONGO_DB_NAME                    = "MONGO_DB_NAME"
 	MONGO_USERNAME                   = "MONGO_USERNAME"
 	MONGO_PASSWORD                   = "MONGO_PASSWORD"
 	MONGO_HOST                       = "MONGO_HOST"
 	MONGO_PORT                       = "MONGO_PORT"
+	POSTGRES_DB_NAME                 = "POSTGRES_DB_NAME"
+	POSTGRES_USERNAME                = "POSTGRES_USERNAME"
+	POSTGRES_PASSWORD                = "POSTGRES_PASSWORD"
+	POSTGRES_HOST                    = "POSTGRES_HOST"
+	POSTGRES_PORT                    = "POSTGRES_PORT"
+	VALKEY_HOST                      = "VALKEY_HOST"
+	VALKEY_PORT                      = "VALKEY_PORT"
+	VALKEY_PASSWORD                  = "VALKEY_PASSWORD"
+	USE_METRICS                      = "USE_METRICS"
+	METRICS_PATH                     = "METRICS_PATH"
+	METRICS_PORT                     = "METRICS_PORT"
+	USE_TRACING                      = "USE_TRACING"
+	TRACING_EXPORTER                 = "TRACING_EXPORTER"
+	TRACING_ENDPOINT                 = "TRACING_ENDPOINT"
+	TRACING_SAMPLE_RATIO             = "TRACING_SAMPLE_RATIO"
+	LOG_FORMAT                       = "LOG_FORMAT"
+	LOG_LEVEL                        = "LOG_LEVEL"
+	LOG_REDACT_HEADERS               = "LOG_REDACT_HEADERS"
+	LOG_REDACT_QUERY                 = "LOG_REDACT_QUERY"
+	LOG_REDACT_COOKIES               = "LOG_REDACT_COOKIES"
+	LOG_SAMPLE_RATE                  = "LOG_SAMPLE_RATE"
+	LOG_SLOW_REQUEST_MS              = "LOG_SLOW_REQUEST_MS"
+	SESSION_STORE                    = "SESSION_STORE"
+	SESSION_COOKIE_NAME              = "SESSION_COOKIE_NAME"
+	SESSION_IDLE_TIMEOUT_MIN         = "SESSION_IDLE_TIMEOUT_MIN"
+	SESSION_ABSOLUTE_TIMEOUT_MIN     = "SESSION_ABSOLUTE_TIMEOUT_MIN"
+	ACCOUNTS_STORE                   = "ACCOUNTS_STORE"
+	JWT_KEY_FILES                    = "JWT_KEY_FILES"
+	JWT_STORE                        = "JWT_STORE"
+	JWT_ISSUER                       = "JWT_ISSUER"
+	JWT_AUDIENCE                     = "JWT_AUDIENCE"
+	JWT_ACCESS_TTL_MIN               = "JWT_ACCESS_TTL_MIN"
+	JWT_REFRESH_TTL_HOURS            = "JWT_REFRESH_TTL_HOURS"
+	JWT_CLOCK_SKEW_SEC               = "JWT_CLOCK_SKEW_SEC"
+	AUTHZ_STORE                      = "AUTHZ_STORE"
+	OIDC_PROVIDERS                   = "OIDC_PROVIDERS"
+	USE_MFA                          = "USE_MFA"
+	MFA_ISSUER                       = "MFA_ISSUER"
+	MFA_REMEMBER_DEVICE_DAYS         = "MFA_REMEMBER_DEVICE_DAYS"
+	USE_MAGIC_LINK                   = "USE_MAGIC_LINK"
+	MAGIC_LINK_TTL_MIN               = "MAGIC_LINK_TTL_MIN"
+	MAGIC_LINK_MAX_PER_HOUR          = "MAGIC_LINK_MAX_PER_HOUR"
+	USE_API_KEYS                     = "USE_API_KEYS"
+	API_KEY_PREFIX                   = "API_KEY_PREFIX"
+	API_KEYS_MAX_PER_USER            = "API_KEYS_MAX_PER_USER"
+	API_KEY_RATE_LIMIT_PER_MIN       = "API_KEY_RATE_LIMIT_PER_MIN"
+	MAIL_TRANSPORT                   = "MAIL_TRANSPORT"
+	MAIL_FROM                        = "MAIL_FROM"
+	MAIL_DIR                         = "MAIL_DIR"
+	MAIL_QUEUE_SIZE                  = "MAIL_QUEUE_SIZE"
+	MAIL_WORKERS                     = "MAIL_WORKERS"
+	MAIL_MAX_ATTEMPTS                = "MAIL_MAX_ATTEMPTS"
+	SMTP_HOST                        = "SMTP_HOST"
+	SMTP_PORT                        = "SMTP_PORT"
+	SMTP_USERNAME                    = "SMTP_USERNAME"
+	SMTP_PASSWORD                    = "SMTP_PASSWORD"
+	SMTP_TLS                         = "SMTP_TLS"
+	JOBS_STORE                       = "JOBS_STORE"
+	JOBS_CONCURRENCY                 = "JOBS_CONCURRENCY"
+	JOBS_MAX_ATTEMPTS                = "JOBS_MAX_ATTEMPTS"
+	JOBS_LEASE_SEC                   = "JOBS_LEASE_SEC"
+	JOBS_POLL_INTERVAL_MS            = "JOBS_POLL_INTERVAL_MS"
+	USE_SCHEDULER                    = "USE_SCHEDULER"
+	SCHEDULER_LOCK                   = "SCHEDULER_LOCK"
+	SCHEDULER_LOCK_TTL_SEC           = "SCHEDULER_LOCK_TTL_SEC"
+	SCHEDULER_TIMEZONE               = "SCHEDULER_TIMEZONE"
+	SSE_BROKER                       = "SSE_BROKER"
+	SSE_REPLAY_SIZE                  = "SSE_REPLAY_SIZE"
+	SSE_HEARTBEAT_SEC                = "SSE_HEARTBEAT_SEC"
+	WS_BROKER                        = "WS_BROKER"
+	WS_MAX_MESSAGE_BYTES             = "WS_MAX_MESSAGE_BYTES"
+	WS_SEND_BUFFER                   = "WS_SEND_BUFFER"
+	WS_PING_INTERVAL_SEC             = "WS_PING_INTERVAL_SEC"
+	WS_ORIGIN_PATTERNS               = "WS_ORIGIN_PATTERNS"
+	UPLOADS_STORE                    = "UPLOADS_STORE"
+	UPLOADS_DIR                      = "UPLOADS_DIR"
+	UPLOADS_MAX_BYTES                = "UPLOADS_MAX_BYTES"
+	UPLOADS_CHUNK_BYTES              = "UPLOADS_CHUNK_BYTES"
+	UPLOADS_ALLOWED_TYPES            = "UPLOADS_ALLOWED_TYPES"
+	UPLOADS_URL_TTL_SEC              = "UPLOADS_URL_TTL_SEC"
+	S3_ENDPOINT                      = "S3_ENDPOINT"
+	S3_REGION                        = "S3_REGION"
+	S3_BUCKET                        = "S3_BUCKET"
+	S3_ACCESS_KEY                    = "S3_ACCESS_KEY"
+	S3_SECRET_KEY                    = "S3_SECRET_KEY"
+	S3_USE_SSL                       = "S3_USE_SSL"
+	USE_IMAGES                       = "USE_IMAGES"
+	IMAGES_MAX_DIMENSION             = "IMAGES_MAX_DIMENSION"
+	IMAGES_MAX_PIXELS                = "IMAGES_MAX_PIXELS"
+	IMAGES_QUALITY                   = "IMAGES_QUALITY"
+	IMAGES_SRCSET_WIDTHS             = "IMAGES_SRCSET_WIDTHS"
 )
 
 func AllConfigKeys() []string {
 	return []string{
 		PROJECT_NAME,
+		APP_ENV,
+		APP_URL,
 		FILE_SERVER_PATH,
+		STATIC_DIR,
 		AES_SECRET,
 		USE_DB_MONGO,
 		USE_DB_POSTGRES,
@@ -56,22 +173,140 @@ func AllConfigKeys() []string {
 		USE_MW_ETAG,
 		USE_MW_VALIDATE_SANITISE_HEADERS,
 		USE_MW_METHOD_OVERRIDE,
+		USE_MW_REQUEST_ID,
+		MW_REQUEST_ID_HEADER,
+		MW_REQUEST_ID_FORMAT,
+		USE_MW_COMPRESSION,
+		MW_COMPRESSION_MIN_SIZE,
+		MW_COMPRESSION_TYPES,
+		USE_MW_CSRF,
+		MW_CSRF_EXEMPT_PATHS,
+		MW_CSRF_TRUSTED_ORIGINS,
 		HTTP_PORT,
 		MONGO_DB_NAME,
 		MONGO_USERNAME,
 		MONGO_PASSWORD,
 		MONGO_HOST,
 		MONGO_PORT,
+		POSTGRES_DB_NAME,
+		POSTGRES_USERNAME,
+		POSTGRES_PASSWORD,
+		POSTGRES_HOST,
+		POSTGRES_PORT,
+		VALKEY_HOST,
+		VALKEY_PORT,
+		VALKEY_PASSWORD,
+		USE_METRICS,
+		METRICS_PATH,
+		METRICS_PORT,
+		USE_TRACING,
+		TRACING_EXPORTER,
+		TRACING_ENDPOINT,
+		TRACING_SAMPLE_RATIO,
+		LOG_FORMAT,
+		LOG_LEVEL,
+		LOG_REDACT_HEADERS,
+		LOG_REDACT_QUERY,
+		LOG_REDACT_COOKIES,
+		LOG_SAMPLE_RATE,
+		LOG_SLOW_REQUEST_MS,
+		SESSION_STORE,
+		SESSION_COOKIE_NAME,
+		SESSION_IDLE_TIMEOUT_MIN,
+		SESSION_ABSOLUTE_TIMEOUT_MIN,
+		ACCOUNTS_STORE,
+		JWT_KEY_FILES,
+		JWT_STORE,
+		JWT_ISSUER,
+		JWT_AUDIENCE,
+		JWT_ACCESS_TTL_MIN,
+		JWT_REFRESH_TTL_HOURS,
+		JWT_CLOCK_SKEW_SEC,
+		AUTHZ_STORE,
+		OIDC_PROVIDERS,
+		USE_MFA,
+		MFA_ISSUER,
+		MFA_REMEMBER_DEVICE_DAYS,
+		USE_MAGIC_LINK,
+		MAGIC_LINK_TTL_MIN,
+		MAGIC_LINK_MAX_PER_HOUR,
+		USE_API_KEYS,
+		API_KEY_PREFIX,
+		API_KEYS_MAX_PER_USER,
+		API_KEY_RATE_LIMIT_PER_MIN,
+		MAIL_TRANSPORT,
+		MAIL_FROM,
+		MAIL_DIR,
+		MAIL_QUEUE_SIZE,
+		MAIL_WORKERS,
+		MAIL_MAX_ATTEMPTS,
+		SMTP_HOST,
+		SMTP_PORT,
+		SMTP_USERNAME,
+		SMTP_PASSWORD,
+		SMTP_TLS,
+		JOBS_STORE,
+		JOBS_CONCURRENCY,
+		JOBS_MAX_ATTEMPTS,
+		JOBS_LEASE_SEC,
+		JOBS_POLL_INTERVAL_MS,
+		USE_SCHEDULER,
+		SCHEDULER_LOCK,
+		SCHEDULER_LOCK_TTL_SEC,
+		SCHEDULER_TIMEZONE,
+		SSE_BROKER,
+		SSE_REPLAY_SIZE,
+		SSE_HEARTBEAT_SEC,
+		WS_BROKER,
+		WS_MAX_MESSAGE_BYTES,
+		WS_SEND_BUFFER,
+		WS_PING_INTERVAL_SEC,
+		WS_ORIGIN_PATTERNS,
+		UPLOADS_STORE,
+		UPLOADS_DIR,
+		UPLOADS_MAX_BYTES,
+		UPLOADS_CHUNK_BYTES,
+		UPLOADS_ALLOWED_TYPES,
+		UPLOADS_URL_TTL_SEC,
+		S3_ENDPOINT,
+		S3_REGION,
+		S3_BUCKET,
+		S3_ACCESS_KEY,
+		S3_SECRET_KEY,
+		S3_USE_SSL,
+		USE_IMAGES,
+		IMAGES_MAX_DIMENSION,
+		IMAGES_MAX_PIXELS,
+		IMAGES_QUALITY,
+		IMAGES_SRCSET_WIDTHS,
 	}
 }
 
 // Holds global configuration sourced from local .env file
 type Config struct {
 	HTTP       *HTTPConfig
+	Logging    *LoggingConfig
 	Middleware *MiddlewareConfig
 	Mongo      *MongoConfig
 	Postgres   *PostgresConfig
 	Valkey     *ValkeyConfig
+	Metrics    *MetricsConfig
+	Tracing    *TracingConfig
+	Session    *SessionConfig
+	Accounts   *AccountsConfig
+	JWT        *JWTConfig
+	Authz      *AuthzConfig
+	OIDC       *OIDCConfig
+	MFA        *MFAConfig
+	MagicLink  *MagicLinkConfig
+	APIKeys    *APIKeysConfig
+	Mail       *MailConfig
+	Jobs       *JobsConfig
+	Scheduler  *SchedulerConfig
+	SSE        *SSEConfig
+	WebSocket  *WebSocketConfig
+	Uploads    *UploadsConfig
+	Images     *ImagesConfig
 }
 
 // Create new default config from the local .env file. If any part of the configuration
@@ -84,6 +319,11 @@ func NewDefaultConfig() (*Config, error) {
 		return nil, err
 	}
 	config.HTTP = http
+	logging, err := newDefaultLoggingConfig()
+	if err != nil {
+		return nil, err
+	}
+	config.Logging = logging
 	mw, err := newDefaultMiddlewareConfig()
 	if err != nil {
 		return nil, err
@@ -111,15 +351,141 @@ func NewDefaultConfig() (*Config, error) {
 		}
 		config.Valkey = valkey
 	}
+	if os.Getenv(USE_METRICS) == "true" {
+		metrics, err := newDefaultMetricsConfig(config.HTTP.Port)
+		if err != nil {
+			return nil, err
+		}
+		config.Metrics = metrics
+	}
+	if os.Getenv(USE_TRACING) == "true" {
+		tracing, err := newDefaultTracingConfig()
+		if err != nil {
+			return nil, err
+		}
+		config.Tracing = tracing
+	}
+	if os.Getenv(SESSION_STORE) != "" {
+		session, err := newDefaultSessionConfig(config)
+		if err != nil {
+			return nil, err
+		}
+		config.Session = session
+	}
+	if os.Getenv(ACCOUNTS_STORE) != "" {
+		accounts, err := newDefaultAccountsConfig(config)
+		if err != nil {
+			return nil, err
+		}
+		config.Accounts = accounts
+	}
+	if os.Getenv(JWT_KEY_FILES) != "" {
+		jwt, err := newDefaultJWTConfig(config)
+		if err != nil {
+			return nil, err
+		}
+		config.JWT = jwt
+	}
+	if os.Getenv(AUTHZ_STORE) != "" {
+		authz, err := newDefaultAuthzConfig(config)
+		if err != nil {
+			return nil, err
+		}
+		config.Authz = authz
+	}
+	if os.Getenv(OIDC_PROVIDERS) != "" {
+		oidc, err := newDefaultOIDCConfig(config)
+		if err != nil {
+			return nil, err
+		}
+		config.OIDC = oidc
+	}
+	if os.Getenv(USE_MFA) == "true" {
+		mfa, err := newDefaultMFAConfig(config)
+		if err != nil {
+			return nil, err
+		}
+		config.MFA = mfa
+	}
+	if os.Getenv(USE_MAGIC_LINK) == "true" {
+		magicLink, err := newDefaultMagicLinkConfig(config)
+		if err != nil {
+			return nil, err
+		}
+		config.MagicLink = magicLink
+	}
+	if os.Getenv(USE_API_KEYS) == "true" {
+		apiKeys, err := newDefaultAPIKeysConfig(config)
+		if err != nil {
+			return nil, err
+		}
+		config.APIKeys = apiKeys
+	}
+	if os.Getenv(MAIL_TRANSPORT) != "" {
+		mail, err := newDefaultMailConfig()
+		if err != nil {
+			return nil, err
+		}
+		config.Mail = mail
+	}
+	if os.Getenv(JOBS_STORE) != "" {
+		jobs, err := newDefaultJobsConfig(config)
+		if err != nil {
+			return nil, err
+		}
+		config.Jobs = jobs
+	}
+	if os.Getenv(USE_SCHEDULER) == "true" {
+		scheduler, err := newDefaultSchedulerConfig(config)
+		if err != nil {
+			return nil, err
+		}
+		config.Scheduler = scheduler
+	}
+	if os.Getenv(SSE_BROKER) != "" {
+		sse, err := newDefaultSSEConfig(config)
+		if err != nil {
+			return nil, err
+		}
+		config.SSE = sse
+	}
+	if os.Getenv(WS_BROKER) != "" {
+		ws, err := newDefaultWebSocketConfig(config)
+		if err != nil {
+			return nil, err
+		}
+		config.WebSocket = ws
+	}
+	if os.Getenv(UPLOADS_STORE) != "" {
+		uploads, err := newDefaultUploadsConfig(config)
+		if err != nil {
+			return nil, err
+		}
+		config.Uploads = uploads
+	}
+	if os.Getenv(USE_IMAGES) == "true" {
+		images, err := newDefaultImagesConfig(config)
+		if err != nil {
+			return nil, err
+		}
+		config.Images = images
+	}
 
 	return config, nil
 }
 
-// Configuration required for HTTP server
+// Configuration required for HTTP server. Development is enabled
+// with APP_ENV=development and turns on developer diagnostics and
+// serving static files from StaticDir on disk instead of the binary.
+// BaseURL is the public address used in links sent outside of the
+// request (e.g. emails) and never taken from the Host header.
 type HTTPConfig struct {
+	BaseURL        string
 	FileServerPath string
+	StaticDir      string
 	ImportAlpineJS bool
 	Port           int
+	Development    bool
 }
 
 func newDefaultHTTPConfig() (*HTTPConfig, error) {
@@ -135,14 +501,103 @@ func newDefaultHTTPConfig() (*HTTPConfig, error) {
 	if os.Getenv(USE_JS_ALPINE) == "true" {
 		config.ImportAlpineJS = true
 	}
+	if os.Getenv(APP_ENV) == "development" {
+		config.Development = true
+	}
 	path := os.Getenv(FILE_SERVER_PATH)
 	if !IsURLSafe(path) {
 		return nil, errors.New("file server path is not URL safe")
 	}
 	config.FileServerPath = path
+	config.BaseURL = strings.TrimSuffix(os.Getenv(APP_URL), "/")
+	if config.BaseURL == "" {
+		config.BaseURL = "http://localhost:" + strconv.Itoa(port)
+	}
+	if u, err := url.Parse(config.BaseURL); err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, errors.New("app url must be an absolute url (e.g. https://example.com)")
+	}
+	config.StaticDir = os.Getenv(STATIC_DIR)
+	if config.StaticDir == "" {
+		config.StaticDir = "static"
+	}
+	return config, nil
+}
+
+// Supported log output formats
+const (
+	LOG_FORMAT_JSON = "json"
+	LOG_FORMAT_TEXT = "text"
+)
+
+// Values of these headers, query params and cookies are never logged
+var (
+	DEFAULT_LOG_REDACT_HEADERS = []string{"Authorization", "Cookie", "Set-Cookie", "Proxy-Authorization", "X-Api-Key", "X-CSRF-Token"}
+	DEFAULT_LOG_REDACT_QUERY   = []string{"token", "password", "code", "state", "secret", "api_key", "csrf_token"}
+	DEFAULT_LOG_REDACT_COOKIES = []string{"session", "csrf"}
+)
+
+// Structured logging configuration. Redaction lists extend the defaults,
+// sample rate (0 - 1) applies only to successful requests and requests
+// slower than the threshold are always logged as warnings
+type LoggingConfig struct {
+	Format        string
+	Level         slog.Level
+	RedactHeaders []string
+	RedactQuery   []string
+	RedactCookies []string
+	SampleRate    float64
+	SlowRequest   time.Duration
+}
+
+func newDefaultLoggingConfig() (*LoggingConfig, error) {
+	config := &LoggingConfig{
+		Format:        os.Getenv(LOG_FORMAT),
+		RedactHeaders: append(splitList(os.Getenv(LOG_REDACT_HEADERS)), DEFAULT_LOG_REDACT_HEADERS...),
+		RedactQuery:   append(splitList(os.Getenv(LOG_REDACT_QUERY)), DEFAULT_LOG_REDACT_QUERY...),
+		RedactCookies: append(splitList(os.Getenv(LOG_REDACT_COOKIES)), DEFAULT_LOG_REDACT_COOKIES...),
+		SampleRate:    1,
+		SlowRequest:   time.Second,
+	}
+	switch config.Format {
+	case "":
+		config.Format = LOG_FORMAT_JSON
+	case LOG_FORMAT_JSON, LOG_FORMAT_TEXT:
+	default:
+		return nil, errors.New("log format must be one of: json, text")
+	}
+	if level := os.Getenv(LOG_LEVEL); level != "" {
+		if err := config.Level.UnmarshalText([]byte(level)); err != nil {
+			return nil, errors.New("log level must be one of: debug, info, warn, error")
+		}
+	}
+	if rate := os.Getenv(LOG_SAMPLE_RATE); rate != "" {
+		r, err := strconv.ParseFloat(rate, 64)
+		if err != nil || r < 0 || r > 1 {
+			return nil, errors.New("log sample rate must be a number between 0 and 1")
+		}
+		config.SampleRate = r
+	}
+	if slow := os.Getenv(LOG_SLOW_REQUEST_MS); slow != "" {
+		ms, err := strconv.Atoi(slow)
+		if err != nil || ms < 0 {
+			return nil, errors.New("slow request threshold must be a positive number of milliseconds")
+		}
+		config.SlowRequest = time.Duration(ms) * time.Millisecond
+	}
 	return config, nil
 }
 
+// Split comma separated list skipping empty values
+func splitList(list string) []string {
+	var values []string
+	for _, v := range strings.Split(list, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
 type MiddlewareConfig struct {
 	Localisation            bool
 	SecureHeaders           bool
@@ -154,8 +609,23 @@ type MiddlewareConfig struct {
 	ETAG                    bool
 	ValidateSanitiseHeaders bool
 	MethodOverride          bool
+	RequestID               bool
+	RequestIDHeader         string
+	RequestIDFormat         string
+	Compression             bool
+	CompressionMinSize      *int
+	CompressionTypes        []string
+	CSRF                    bool
+	CSRFExemptPaths         []string
+	CSRFTrustedOrigins      []string
 }
 
+// Supported formats of generated request IDs
+const (
+	REQUEST_ID_FORMAT_UUIDV7 = "uuidv7"
+	REQUEST_ID_FORMAT_ULID   = "ulid"
+)
+
 func newDefaultMiddlewareConfig() (*MiddlewareConfig, error) {
 	cfg := &MiddlewareConfig{}
 	if loc := os.Getenv(USE_MW_LOCALISATION); loc == "true" {
@@ -205,6 +675,45 @@ func newDefaultMiddlewareConfig() (*MiddlewareConfig, error) {
 	if overr := os.Getenv(USE_MW_METHOD_OVERRIDE); overr == "true" {
 		cfg.MethodOverride = true
 	}
+	if reqID := os.Getenv(USE_MW_REQUEST_ID); reqID == "true" {
+		cfg.RequestID = true
+	}
+	cfg.RequestIDHeader = os.Getenv(MW_REQUEST_ID_HEADER)
+	if cfg.RequestIDHeader == "" {
+		cfg.RequestIDHeader = "X-Request-ID"
+	}
+	switch cfg.RequestIDFormat = os.Getenv(MW_REQUEST_ID_FORMAT); cfg.RequestIDFormat {
+	case "":
+		cfg.RequestIDFormat = REQUEST_ID_FORMAT_UUIDV7
+	case REQUEST_ID_FORMAT_UUIDV7, REQUEST_ID_FORMAT_ULID:
+	default:
+		return nil, errors.New("request id format must be one of: uuidv7, ulid")
+	}
+	if comp := os.Getenv(USE_MW_COMPRESSION); comp == "true" {
+		cfg.Compression = true
+	}
+	if size := os.Getenv(MW_COMPRESSION_MIN_SIZE); size != "" {
+		s, err := strconv.Atoi(size)
+		if err != nil || s < 0 {
+			return nil, errors.New("compression min size must be a positive number")
+		}
+		cfg.CompressionMinSize = &s
+	}
+	cfg.CompressionTypes = splitList(os.Getenv(MW_COMPRESSION_TYPES))
+	if csrf := os.Getenv(USE_MW_CSRF); csrf == "true" {
+		if os.Getenv(AES_SECRET) == "" {
+			return nil, errors.New("csrf protection requires aes secret to sign tokens")
+		}
+		cfg.CSRF = true
+	}
+	cfg.CSRFExemptPaths = splitList(os.Getenv(MW_CSRF_EXEMPT_PATHS))
+	for _, origin := range splitList(os.Getenv(MW_CSRF_TRUSTED_ORIGINS)) {
+		u, err := url.Parse(origin)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, errors.New("csrf trusted origins must be absolute urls (e.g. https://example.com)")
+		}
+		cfg.CSRFTrustedOrigins = append(cfg.CSRFTrustedOrigins, u.Scheme+"://"+u.Host)
+	}
 	return cfg, nil
 }
 
@@ -224,9 +733,12 @@ func (c *MongoConfig) ConnectionString() string {
 	return "mongodb://" + c.Username + ":" + c.Password + "@" + c.Host + ":" + c.Port
 }
 
-func (c *MongoConfig) Client() (*mongo.Client, error) {
+// Connect and ping the mongo database. Additional options (e.g. monitors)
+// are applied on top of the connection string options
+func (c *MongoConfig) Client(opts ...*options.ClientOptions) (*mongo.Client, error) {
 	ctx := context.Background()
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI(c.ConnectionString()))
+	opts = append([]*options.ClientOptions{options.Client().ApplyURI(c.ConnectionString())}, opts...)
+	client, err := mongo.Connect(ctx, opts...)
 	if err != nil {
 		return nil, errors.New("error creating mongo client: " + err.Error())
 	}
@@ -270,15 +782,1100 @@ func newDefaultMongoConfig() (*MongoConfig, error) {
 }
 
 // Required configuration for creating postgres connection
-type PostgresConfig struct{}
+type PostgresConfig struct {
+	Username string
+	Password string
+	Host     string
+	Port     string
+	DBName   string
+}
+
+func (c *PostgresConfig) ConnectionString() string {
+	u := &url.URL{
+		Scheme: "postgres",
+		Host:   c.Host + ":" + c.Port,
+		Path:   "/" + c.DBName,
+	}
+	if c.Password != "" {
+		u.User = url.UserPassword(c.Username, c.Password)
+	} else {
+		u.User = url.User(c.Username)
+	}
+	return u.String()
+}
+
+// Create and ping postgres connection pool. Configure funcs are applied to
+// the parsed pool config before connecting (e.g. to set a query tracer)
+func (c *PostgresConfig) Pool(configure ...func(*pgxpool.Config)) (*pgxpool.Pool, error) {
+	ctx := context.Background()
+	cfg, err := pgxpool.ParseConfig(c.ConnectionString())
+	if err != nil {
+		return nil, errors.New("invalid postgres configuration: " + err.Error())
+	}
+	for _, fn := range configure {
+		fn(cfg)
+	}
+	pool, err := pgxpool.NewWithConfig(ctx, cfg)
+	if err != nil {
+		return nil, errors.New("error creating postgres pool: " + err.Error())
+	}
+	if err := pool.Ping(ctx); err != nil {
+		pool.Close()
+		return nil, errors.New("error connecting to postgres - could not ping the database: " + err.Error())
+	}
+	return pool, nil
+}
 
 func newDefaultPostgresConfig() (*PostgresConfig, error) {
-	return &PostgresConfig{}, nil
+	username := os.Getenv(POSTGRES_USERNAME)
+	if username == "" {
+		username = "postgres"
+	}
+	dbname := os.Getenv(POSTGRES_DB_NAME)
+	if dbname == "" {
+		dbname = "postgres"
+	}
+	host := os.Getenv(POSTGRES_HOST)
+	if host == "" {
+		host = "localhost"
+	}
+	port := os.Getenv(POSTGRES_PORT)
+	if port == "" {
+		port = "5432"
+	}
+	if _, err := strconv.Atoi(port); err != nil {
+		return nil, errors.New("invalid port for postgres connection")
+	}
+	return &PostgresConfig{
+		DBName:   dbname,
+		Username: username,
+		Password: os.Getenv(POSTGRES_PASSWORD),
+		Host:     host,
+		Port:     port,
+	}, nil
 }
 
 // Required configuration for creating valkey connection
-type ValkeyConfig struct{}
+type ValkeyConfig struct {
+	Host     string
+	Port     string
+	Password string
+}
+
+func (c *ValkeyConfig) Address() string {
+	return c.Host + ":" + c.Port
+}
+
+// Create valkey client. The client pings the server while connecting
+func (c *ValkeyConfig) Client() (valkey.Client, error) {
+	client, err := valkey.NewClient(valkey.ClientOption{
+		InitAddress: []string{c.Address()},
+		Password:    c.Password,
+	})
+	if err != nil {
+		return nil, errors.New("error connecting to valkey: " + err.Error())
+	}
+	return client, nil
+}
 
 func newDefaultValkeyConfig() (*ValkeyConfig, error) {
-	return &ValkeyConfig{}, nil
+	host := os.Getenv(VALKEY_HOST)
+	if host == "" {
+		host = "localhost"
+	}
+	port := os.Getenv(VALKEY_PORT)
+	if port == "" {
+		port = "6379"
+	}
+	if _, err := strconv.Atoi(port); err != nil {
+		return nil, errors.New("invalid port for valkey connection")
+	}
+	return &ValkeyConfig{
+		Host:     host,
+		Port:     port,
+		Password: os.Getenv(VALKEY_PASSWORD),
+	}, nil
+}
+
+// Configuration of the Prometheus metrics endpoint. When the port is zero
+// the endpoint is mounted on the main router, otherwise it's served by a
+// separate admin server on the given port
+type MetricsConfig struct {
+	Path string
+	Port int
+}
+
+func newDefaultMetricsConfig(httpPort int) (*MetricsConfig, error) {
+	path := os.Getenv(METRICS_PATH)
+	if path == "" {
+		path = "/metrics"
+	}
+	if !IsURLSafe(path) {
+		return nil, errors.New("metrics path is not URL safe")
+	}
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	config := &MetricsConfig{Path: path}
+	if p := os.Getenv(METRICS_PORT); p != "" {
+		port, err := strconv.Atoi(p)
+		if err != nil {
+			return nil, errors.New("metrics port must be a valid port number")
+		}
+		if port < 1000 || port > 65535 {
+			return nil, errors.New("only registered and dynamic ports are allowed (1000 - 65535)")
+		}
+		if port == httpPort {
+			return nil, errors.New("metrics port must be different from http port")
+		}
+		config.Port = port
+	}
+	return config, nil
+}
+
+// Supported span exporters
+const (
+	TRACING_EXPORTER_OTLP   = "otlp"
+	TRACING_EXPORTER_STDOUT = "stdout"
+	TRACING_EXPORTER_MEMORY = "memory"
+)
+
+// Configuration of OpenTelemetry tracing. Endpoint is only used by the
+// otlp exporter (OTLP over HTTP) and sample ratio must be between 0 and 1
+type TracingConfig struct {
+	ServiceName string
+	Exporter    string
+	Endpoint    string
+	SampleRatio float64
+}
+
+func newDefaultTracingConfig() (*TracingConfig, error) {
+	config := &TracingConfig{
+		ServiceName: os.Getenv(PROJECT_NAME),
+		Exporter:    os.Getenv(TRACING_EXPORTER),
+		Endpoint:    os.Getenv(TRACING_ENDPOINT),
+		SampleRatio: 1,
+	}
+	if config.ServiceName == "" {
+		config.ServiceName = "go-puerto"
+	}
+	switch config.Exporter {
+	case "":
+		config.Exporter = TRACING_EXPORTER_OTLP
+	case TRACING_EXPORTER_OTLP, TRACING_EXPORTER_STDOUT, TRACING_EXPORTER_MEMORY:
+	default:
+		return nil, errors.New("tracing exporter must be one of: otlp, stdout, memory")
+	}
+	if config.Exporter == TRACING_EXPORTER_OTLP && config.Endpoint == "" {
+		config.Endpoint = "localhost:4318"
+	}
+	if ratio := os.Getenv(TRACING_SAMPLE_RATIO); ratio != "" {
+		r, err := strconv.ParseFloat(ratio, 64)
+		if err != nil || r < 0 || r > 1 {
+			return nil, errors.New("tracing sample ratio must be a number between 0 and 1")
+		}
+		config.SampleRatio = r
+	}
+	return config, nil
+}
+
+// Supported session stores
+const (
+	SESSION_STORE_COOKIE   = "cookie"
+	SESSION_STORE_VALKEY   = "valkey"
+	SESSION_STORE_MONGO    = "mongo"
+	SESSION_STORE_POSTGRES = "postgres"
+)
+
+// Configuration of server-side sessions. Sessions expire after being idle
+// for IdleTimeout and always after AbsoluteTimeout since their creation.
+// Database stores require the database to be enabled and the cookie store
+// requires AES_SECRET to encrypt the session data.
+type SessionConfig struct {
+	Store           string
+	CookieName      string
+	IdleTimeout     time.Duration
+	AbsoluteTimeout time.Duration
+}
+
+func newDefaultSessionConfig(config *Config) (*SessionConfig, error) {
+	cfg := &SessionConfig{
+		Store:           os.Getenv(SESSION_STORE),
+		CookieName:      os.Getenv(SESSION_COOKIE_NAME),
+		IdleTimeout:     30 * time.Minute,
+		AbsoluteTimeout: 24 * time.Hour,
+	}
+	switch cfg.Store {
+	case SESSION_STORE_COOKIE:
+		if os.Getenv(AES_SECRET) == "" {
+			return nil, errors.New("cookie session store requires aes secret to encrypt sessions")
+		}
+	case SESSION_STORE_VALKEY:
+		if config.Valkey == nil {
+			return nil, errors.New("valkey session store requires valkey database")
+		}
+	case SESSION_STORE_MONGO:
+		if config.Mongo == nil {
+			return nil, errors.New("mongo session store requires mongo database")
+		}
+	case SESSION_STORE_POSTGRES:
+		if config.Postgres == nil {
+			return nil, errors.New("postgres session store requires postgres database")
+		}
+	default:
+		return nil, errors.New("session store must be one of: cookie, valkey, mongo, postgres")
+	}
+	if cfg.CookieName == "" {
+		cfg.CookieName = "session"
+	}
+	if idle := os.Getenv(SESSION_IDLE_TIMEOUT_MIN); idle != "" {
+		min, err := strconv.Atoi(idle)
+		if err != nil || min <= 0 {
+			return nil, errors.New("session idle timeout must be a positive number of minutes")
+		}
+		cfg.IdleTimeout = time.Duration(min) * time.Minute
+	}
+	if absolute := os.Getenv(SESSION_ABSOLUTE_TIMEOUT_MIN); absolute != "" {
+		min, err := strconv.Atoi(absolute)
+		if err != nil || min <= 0 {
+			return nil, errors.New("session absolute timeout must be a positive number of minutes")
+		}
+		cfg.AbsoluteTimeout = time.Duration(min) * time.Minute
+	}
+	if cfg.IdleTimeout > cfg.AbsoluteTimeout {
+		return nil, errors.New("session idle timeout cannot be longer than absolute timeout")
+	}
+	return cfg, nil
+}
+
+// Supported accounts stores
+const (
+	ACCOUNTS_STORE_MONGO    = "mongo"
+	ACCOUNTS_STORE_POSTGRES = "postgres"
+)
+
+// Configuration of user accounts (registration, login, password reset).
+// Users are kept in the mongo or postgres database and logged in users
+// are bound to their sessions, so sessions must be enabled.
+type AccountsConfig struct {
+	Store string
+}
+
+func newDefaultAccountsConfig(config *Config) (*AccountsConfig, error) {
+	cfg := &AccountsConfig{Store: os.Getenv(ACCOUNTS_STORE)}
+	switch cfg.Store {
+	case ACCOUNTS_STORE_MONGO:
+		if config.Mongo == nil {
+			return nil, errors.New("mongo accounts store requires mongo database")
+		}
+	case ACCOUNTS_STORE_POSTGRES:
+		if config.Postgres == nil {
+			return nil, errors.New("postgres accounts store requires postgres database")
+		}
+	default:
+		return nil, errors.New("accounts store must be one of: mongo, postgres")
+	}
+	if config.Session == nil {
+		return nil, errors.New("accounts require sessions to be enabled")
+	}
+	return cfg, nil
+}
+
+// Supported stores of refresh tokens and revoked access tokens
+const (
+	JWT_STORE_VALKEY   = "valkey"
+	JWT_STORE_POSTGRES = "postgres"
+)
+
+// Configuration of JWT access and refresh tokens. KeyFiles are PEM
+// encoded private keys (Ed25519, P-256 or RSA) and the first one signs
+// new tokens. To rotate, put the new key first and keep the old one
+// listed until the tokens signed by it expire. Issuer and Audience
+// default to the public address of the application.
+type JWTConfig struct {
+	KeyFiles   []string
+	Store      string
+	Issuer     string
+	Audience   []string
+	AccessTTL  time.Duration
+	RefreshTTL time.Duration
+	ClockSkew  time.Duration
+}
+
+func newDefaultJWTConfig(config *Config) (*JWTConfig, error) {
+	cfg := &JWTConfig{
+		KeyFiles:   splitList(os.Getenv(JWT_KEY_FILES)),
+		Store:      os.Getenv(JWT_STORE),
+		Issuer:     os.Getenv(JWT_ISSUER),
+		Audience:   splitList(os.Getenv(JWT_AUDIENCE)),
+		AccessTTL:  15 * time.Minute,
+		RefreshTTL: 30 * 24 * time.Hour,
+		ClockSkew:  time.Minute,
+	}
+	switch cfg.Store {
+	case JWT_STORE_VALKEY:
+		if config.Valkey == nil {
+			return nil, errors.New("valkey jwt store requires valkey database")
+		}
+	case JWT_STORE_POSTGRES:
+		if config.Postgres == nil {
+			return nil, errors.New("postgres jwt store requires postgres database")
+		}
+	default:
+		return nil, errors.New("jwt store must be one of: valkey, postgres")
+	}
+	if cfg.Issuer == "" {
+		cfg.Issuer = config.HTTP.BaseURL
+	}
+	if len(cfg.Audience) == 0 {
+		cfg.Audience = []string{config.HTTP.BaseURL}
+	}
+	if ttl := os.Getenv(JWT_ACCESS_TTL_MIN); ttl != "" {
+		min, err := strconv.Atoi(ttl)
+		if err != nil || min <= 0 {
+			return nil, errors.New("jwt access token ttl must be a positive number of minutes")
+		}
+		cfg.AccessTTL = time.Duration(min) * time.Minute
+	}
+	if ttl := os.Getenv(JWT_REFRESH_TTL_HOURS); ttl != "" {
+		hours, err := strconv.Atoi(ttl)
+		if err != nil || hours <= 0 {
+			return nil, errors.New("jwt refresh token ttl must be a positive number of hours")
+		}
+		cfg.RefreshTTL = time.Duration(hours) * time.Hour
+	}
+	if skew := os.Getenv(JWT_CLOCK_SKEW_SEC); skew != "" {
+		sec, err := strconv.Atoi(skew)
+		if err != nil || sec < 0 {
+			return nil, errors.New("jwt clock skew must be a non-negative number of seconds")
+		}
+		cfg.ClockSkew = time.Duration(sec) * time.Second
+	}
+	if cfg.AccessTTL > cfg.RefreshTTL {
+		return nil, errors.New("jwt access token ttl cannot be longer than refresh token ttl")
+	}
+	return cfg, nil
+}
+
+// Supported stores of roles and role assignments
+const (
+	AUTHZ_STORE_MONGO    = "mongo"
+	AUTHZ_STORE_POSTGRES = "postgres"
+)
+
+// Configuration of role based authorization. Roles and their assignments
+// to users are kept in the mongo or postgres database.
+type AuthzConfig struct {
+	Store string
+}
+
+func newDefaultAuthzConfig(config *Config) (*AuthzConfig, error) {
+	cfg := &AuthzConfig{Store: os.Getenv(AUTHZ_STORE)}
+	switch cfg.Store {
+	case AUTHZ_STORE_MONGO:
+		if config.Mongo == nil {
+			return nil, errors.New("mongo authz store requires mongo database")
+		}
+	case AUTHZ_STORE_POSTGRES:
+		if config.Postgres == nil {
+			return nil, errors.New("postgres authz store requires postgres database")
+		}
+	default:
+		return nil, errors.New("authz store must be one of: mongo, postgres")
+	}
+	return cfg, nil
+}
+
+// Default scopes requested from OpenID Connect providers
+const OIDC_DEFAULT_SCOPES = "openid email profile"
+
+var oidcProviderName = regexp.MustCompile(`^[a-z0-9][a-z0-9_]*$`)
+
+// Configuration of signing in with OpenID Connect providers. Providers
+// are listed by name in OIDC_PROVIDERS and each one is configured with
+// OIDC_<NAME>_ISSUER, OIDC_<NAME>_CLIENT_ID, OIDC_<NAME>_CLIENT_SECRET
+// and optional OIDC_<NAME>_SCOPES and OIDC_<NAME>_LABEL variables.
+// Signed in users get local accounts, so accounts must be enabled.
+type OIDCConfig struct {
+	Providers []OIDCProviderConfig
+}
+
+type OIDCProviderConfig struct {
+	Name         string
+	Label        string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+}
+
+func newDefaultOIDCConfig(config *Config) (*OIDCConfig, error) {
+	if config.Accounts == nil {
+		return nil, errors.New("oidc requires accounts to be enabled")
+	}
+	cfg := &OIDCConfig{}
+	seen := make(map[string]bool)
+	for _, name := range splitList(os.Getenv(OIDC_PROVIDERS)) {
+		name = strings.ToLower(name)
+		if !oidcProviderName.MatchString(name) {
+			return nil, fmt.Errorf("oidc provider name %q must contain only letters, digits and underscores", name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("oidc provider %q is listed more than once", name)
+		}
+		seen[name] = true
+
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		provider := OIDCProviderConfig{
+			Name:         name,
+			Label:        os.Getenv(prefix + "LABEL"),
+			Issuer:       os.Getenv(prefix + "ISSUER"),
+			ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			Scopes:       strings.Fields(os.Getenv(prefix + "SCOPES")),
+		}
+		issuer, err := url.Parse(provider.Issuer)
+		if err != nil || (issuer.Scheme != "https" && issuer.Scheme != "http") || issuer.Host == "" {
+			return nil, fmt.Errorf("oidc provider %q requires valid %sISSUER url", name, prefix)
+		}
+		if provider.ClientID == "" || provider.ClientSecret == "" {
+			return nil, fmt.Errorf("oidc provider %q requires %sCLIENT_ID and %sCLIENT_SECRET", name, prefix, prefix)
+		}
+		if len(provider.Scopes) == 0 {
+			provider.Scopes = strings.Fields(OIDC_DEFAULT_SCOPES)
+		}
+		if !slices.Contains(provider.Scopes, "openid") {
+			return nil, fmt.Errorf("oidc provider %q scopes must include openid", name)
+		}
+		if provider.Label == "" {
+			provider.Label = strings.ToUpper(name[:1]) + name[1:]
+		}
+		cfg.Providers = append(cfg.Providers, provider)
+	}
+	if len(cfg.Providers) == 0 {
+		return nil, errors.New("oidc requires at least one provider")
+	}
+	return cfg, nil
+}
+
+// Configuration of two-factor authentication. Users enable it on their
+// account with an authenticator app, whose secrets are encrypted with
+// AES_SECRET. Issuer is the name shown in the app and defaults to the
+// host of the application. Browsers can be remembered to skip the second
+// factor for RememberDeviceTTL (zero disables remembering).
+type MFAConfig struct {
+	Issuer            string
+	RememberDeviceTTL time.Duration
+}
+
+func newDefaultMFAConfig(config *Config) (*MFAConfig, error) {
+	if config.Accounts == nil {
+		return nil, errors.New("mfa requires accounts to be enabled")
+	}
+	switch len(os.Getenv(AES_SECRET)) {
+	case 16, 24, 32:
+	default:
+		return nil, errors.New("mfa requires aes secret of 16, 24 or 32 bytes to encrypt secrets")
+	}
+	cfg := &MFAConfig{
+		Issuer:            os.Getenv(MFA_ISSUER),
+		RememberDeviceTTL: 30 * 24 * time.Hour,
+	}
+	if cfg.Issuer == "" {
+		base, _ := url.Parse(config.HTTP.BaseURL)
+		cfg.Issuer = base.Hostname()
+	}
+	if days := os.Getenv(MFA_REMEMBER_DEVICE_DAYS); days != "" {
+		d, err := strconv.Atoi(days)
+		if err != nil || d < 0 {
+			return nil, errors.New("mfa remember device days must be a non-negative number")
+		}
+		cfg.RememberDeviceTTL = time.Duration(d) * 24 * time.Hour
+	}
+	return cfg, nil
+}
+
+// Configuration of passwordless login with links sent by email. Links
+// are valid for TTL and at most MaxRequests can be requested for one
+// address within RequestWindow, so the login form can't be used to
+// flood mailboxes.
+type MagicLinkConfig struct {
+	TTL           time.Duration
+	MaxRequests   int
+	RequestWindow time.Duration
+}
+
+func newDefaultMagicLinkConfig(config *Config) (*MagicLinkConfig, error) {
+	if config.Accounts == nil {
+		return nil, errors.New("magic link requires accounts to be enabled")
+	}
+	cfg := &MagicLinkConfig{
+		TTL:           15 * time.Minute,
+		MaxRequests:   5,
+		RequestWindow: time.Hour,
+	}
+	if ttl := os.Getenv(MAGIC_LINK_TTL_MIN); ttl != "" {
+		minutes, err := strconv.Atoi(ttl)
+		if err != nil || minutes <= 0 {
+			return nil, errors.New("magic link ttl must be a positive number of minutes")
+		}
+		cfg.TTL = time.Duration(minutes) * time.Minute
+	}
+	if max := os.Getenv(MAGIC_LINK_MAX_PER_HOUR); max != "" {
+		n, err := strconv.Atoi(max)
+		if err != nil || n <= 0 {
+			return nil, errors.New("magic link max per hour must be a positive number")
+		}
+		cfg.MaxRequests = n
+	}
+	return cfg, nil
+}
+
+var apiKeyPrefix = regexp.MustCompile(`^[a-z][a-z0-9]{1,15}$`)
+
+// Configuration of API keys users create for their integrations. Keys
+// start with Prefix, users can have at most MaxPerUser keys and each key
+// may send RateLimitPerMinute requests (enforced by the rate limiter).
+type APIKeysConfig struct {
+	Prefix             string
+	MaxPerUser         int
+	RateLimitPerMinute int
+}
+
+func newDefaultAPIKeysConfig(config *Config) (*APIKeysConfig, error) {
+	if config.Accounts == nil {
+		return nil, errors.New("api keys require accounts to be enabled")
+	}
+	cfg := &APIKeysConfig{
+		Prefix:             "api",
+		MaxPerUser:         10,
+		RateLimitPerMinute: 60,
+	}
+	if prefix := os.Getenv(API_KEY_PREFIX); prefix != "" {
+		if !apiKeyPrefix.MatchString(prefix) {
+			return nil, errors.New("api key prefix must have 2-16 lower case letters or digits")
+		}
+		cfg.Prefix = prefix
+	}
+	if max := os.Getenv(API_KEYS_MAX_PER_USER); max != "" {
+		n, err := strconv.Atoi(max)
+		if err != nil || n <= 0 {
+			return nil, errors.New("api keys max per user must be a positive number")
+		}
+		cfg.MaxPerUser = n
+	}
+	if limit := os.Getenv(API_KEY_RATE_LIMIT_PER_MIN); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			return nil, errors.New("api key rate limit must be a positive number of requests per minute")
+		}
+		cfg.RateLimitPerMinute = n
+	}
+	return cfg, nil
+}
+
+const (
+	MAIL_TRANSPORT_SMTP = "smtp"
+	// Writes .eml files to MAIL_DIR instead of sending them
+	MAIL_TRANSPORT_FILE = "file"
+
+	SMTP_TLS_STARTTLS = "starttls"
+	SMTP_TLS_IMPLICIT = "tls"
+	// Plain connection, only for local SMTP sinks such as Mailpit
+	SMTP_TLS_NONE = "none"
+)
+
+// Configuration of outgoing email. Messages are queued in memory (up to
+// QueueSize) and delivered by Workers in the background, retrying failed
+// deliveries up to MaxAttempts times.
+type MailConfig struct {
+	Transport   string
+	From        string
+	Dir         string
+	SMTP        *SMTPConfig
+	QueueSize   int
+	Workers     int
+	MaxAttempts int
+}
+
+// SMTP server of the smtp mail transport. TLS is starttls (default, port
+// 587), tls for implicit TLS (port 465) or none.
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	TLS      string
+}
+
+func newDefaultMailConfig() (*MailConfig, error) {
+	cfg := &MailConfig{
+		Transport:   os.Getenv(MAIL_TRANSPORT),
+		From:        os.Getenv(MAIL_FROM),
+		QueueSize:   100,
+		Workers:     2,
+		MaxAttempts: 5,
+	}
+	if _, err := mail.ParseAddress(cfg.From); err != nil {
+		return nil, errors.New("mail from must be a valid address, e.g. \"Shop <shop@example.com>\"")
+	}
+	switch cfg.Transport {
+	case MAIL_TRANSPORT_SMTP:
+		smtp, err := newDefaultSMTPConfig()
+		if err != nil {
+			return nil, err
+		}
+		cfg.SMTP = smtp
+	case MAIL_TRANSPORT_FILE:
+		cfg.Dir = os.Getenv(MAIL_DIR)
+		if cfg.Dir == "" {
+			cfg.Dir = "mail"
+		}
+	default:
+		return nil, errors.New("mail transport must be one of: smtp, file")
+	}
+	for key, target := range map[string]*int{MAIL_QUEUE_SIZE: &cfg.QueueSize, MAIL_WORKERS: &cfg.Workers, MAIL_MAX_ATTEMPTS: &cfg.MaxAttempts} {
+		if value := os.Getenv(key); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n <= 0 {
+				return nil, errors.New(strings.ReplaceAll(strings.ToLower(key), "_", " ") + " must be a positive number")
+			}
+			*target = n
+		}
+	}
+	return cfg, nil
+}
+
+func newDefaultSMTPConfig() (*SMTPConfig, error) {
+	cfg := &SMTPConfig{
+		Host:     os.Getenv(SMTP_HOST),
+		Username: os.Getenv(SMTP_USERNAME),
+		Password: os.Getenv(SMTP_PASSWORD),
+		TLS:      os.Getenv(SMTP_TLS),
+	}
+	if cfg.Host == "" {
+		return nil, errors.New("smtp host is required by the smtp mail transport")
+	}
+	switch cfg.TLS {
+	case "":
+		cfg.TLS = SMTP_TLS_STARTTLS
+		cfg.Port = 587
+	case SMTP_TLS_STARTTLS:
+		cfg.Port = 587
+	case SMTP_TLS_IMPLICIT:
+		cfg.Port = 465
+	case SMTP_TLS_NONE:
+		cfg.Port = 25
+	default:
+		return nil, errors.New("smtp tls must be one of: starttls, tls, none")
+	}
+	if port := os.Getenv(SMTP_PORT); port != "" {
+		p, err := strconv.Atoi(port)
+		if err != nil || p <= 0 || p > 65535 {
+			return nil, errors.New("smtp port must be a valid port number")
+		}
+		cfg.Port = p
+	}
+	if (cfg.Username == "") != (cfg.Password == "") {
+		return nil, errors.New("smtp username and password must be set together")
+	}
+	return cfg, nil
+}
+
+const (
+	JOBS_STORE_VALKEY   = "valkey"
+	JOBS_STORE_POSTGRES = "postgres"
+)
+
+// Configuration of the background job queue. Each instance runs up to
+// Concurrency jobs at once and polls for due jobs every PollInterval.
+// Jobs must finish within Lease, after that they are considered
+// abandoned and run again. Failed jobs are retried up to MaxAttempts
+// times before they are kept as failed.
+type JobsConfig struct {
+	Store        string
+	Concurrency  int
+	MaxAttempts  int
+	Lease        time.Duration
+	PollInterval time.Duration
+}
+
+func newDefaultJobsConfig(config *Config) (*JobsConfig, error) {
+	cfg := &JobsConfig{
+		Store:        os.Getenv(JOBS_STORE),
+		Concurrency:  4,
+		MaxAttempts:  5,
+		Lease:        5 * time.Minute,
+		PollInterval: time.Second,
+	}
+	switch cfg.Store {
+	case JOBS_STORE_VALKEY:
+		if config.Valkey == nil {
+			return nil, errors.New("valkey jobs store requires valkey database")
+		}
+	case JOBS_STORE_POSTGRES:
+		if config.Postgres == nil {
+			return nil, errors.New("postgres jobs store requires postgres database")
+		}
+	default:
+		return nil, errors.New("jobs store must be one of: valkey, postgres")
+	}
+	if concurrency := os.Getenv(JOBS_CONCURRENCY); concurrency != "" {
+		n, err := strconv.Atoi(concurrency)
+		if err != nil || n <= 0 {
+			return nil, errors.New("jobs concurrency must be a positive number")
+		}
+		cfg.Concurrency = n
+	}
+	if attempts := os.Getenv(JOBS_MAX_ATTEMPTS); attempts != "" {
+		n, err := strconv.Atoi(attempts)
+		if err != nil || n <= 0 {
+			return nil, errors.New("jobs max attempts must be a positive number")
+		}
+		cfg.MaxAttempts = n
+	}
+	if lease := os.Getenv(JOBS_LEASE_SEC); lease != "" {
+		sec, err := strconv.Atoi(lease)
+		if err != nil || sec <= 0 {
+			return nil, errors.New("jobs lease must be a positive number of seconds")
+		}
+		cfg.Lease = time.Duration(sec) * time.Second
+	}
+	if interval := os.Getenv(JOBS_POLL_INTERVAL_MS); interval != "" {
+		ms, err := strconv.Atoi(interval)
+		if err != nil || ms <= 0 {
+			return nil, errors.New("jobs poll interval must be a positive number of milliseconds")
+		}
+		cfg.PollInterval = time.Duration(ms) * time.Millisecond
+	}
+	return cfg, nil
+}
+
+const (
+	// Every instance runs the tasks, fine for a single instance
+	SCHEDULER_LOCK_NONE     = "none"
+	SCHEDULER_LOCK_VALKEY   = "valkey"
+	SCHEDULER_LOCK_POSTGRES = "postgres"
+)
+
+// Configuration of the recurring task scheduler. With a lock only the
+// instance holding it runs the tasks. The Valkey lock expires after
+// LockTTL when its holder stops renewing it, the Postgres advisory lock
+// is released with the connection of its holder. Cron expressions
+// without their own timezone are read in Timezone.
+type SchedulerConfig struct {
+	Lock     string
+	LockTTL  time.Duration
+	Timezone *time.Location
+}
+
+func newDefaultSchedulerConfig(config *Config) (*SchedulerConfig, error) {
+	cfg := &SchedulerConfig{
+		Lock:     SCHEDULER_LOCK_NONE,
+		LockTTL:  30 * time.Second,
+		Timezone: time.UTC,
+	}
+	if lock := os.Getenv(SCHEDULER_LOCK); lock != "" {
+		cfg.Lock = lock
+	}
+	switch cfg.Lock {
+	case SCHEDULER_LOCK_NONE:
+	case SCHEDULER_LOCK_VALKEY:
+		if config.Valkey == nil {
+			return nil, errors.New("valkey scheduler lock requires valkey database")
+		}
+	case SCHEDULER_LOCK_POSTGRES:
+		if config.Postgres == nil {
+			return nil, errors.New("postgres scheduler lock requires postgres database")
+		}
+	default:
+		return nil, errors.New("scheduler lock must be one of: none, valkey, postgres")
+	}
+	if ttl := os.Getenv(SCHEDULER_LOCK_TTL_SEC); ttl != "" {
+		sec, err := strconv.Atoi(ttl)
+		if err != nil || sec < 3 {
+			return nil, errors.New("scheduler lock ttl must be at least 3 seconds")
+		}
+		cfg.LockTTL = time.Duration(sec) * time.Second
+	}
+	if tz := os.Getenv(SCHEDULER_TIMEZONE); tz != "" {
+		loc, err := time.LoadLocation(tz)
+		if err != nil {
+			return nil, errors.New("scheduler timezone must be an IANA time zone name, e.g. Europe/London")
+		}
+		cfg.Timezone = loc
+	}
+	return cfg, nil
+}
+
+const (
+	// Events reach clients connected to the same instance only
+	SSE_BROKER_MEMORY = "memory"
+	SSE_BROKER_VALKEY = "valkey"
+)
+
+// Configuration of server-sent events. The broker keeps the last
+// ReplaySize events for clients resuming with Last-Event-ID. Streams send
+// a comment every Heartbeat, so proxies keep idle connections open.
+type SSEConfig struct {
+	Broker     string
+	ReplaySize int
+	Heartbeat  time.Duration
+}
+
+func newDefaultSSEConfig(config *Config) (*SSEConfig, error) {
+	cfg := &SSEConfig{
+		Broker:     os.Getenv(SSE_BROKER),
+		ReplaySize: 1000,
+		Heartbeat:  15 * time.Second,
+	}
+	switch cfg.Broker {
+	case SSE_BROKER_MEMORY:
+	case SSE_BROKER_VALKEY:
+		if config.Valkey == nil {
+			return nil, errors.New("valkey sse broker requires valkey database")
+		}
+	default:
+		return nil, errors.New("sse broker must be one of: memory, valkey")
+	}
+	if size := os.Getenv(SSE_REPLAY_SIZE); size != "" {
+		n, err := strconv.Atoi(size)
+		if err != nil || n < 0 {
+			return nil, errors.New("sse replay size must be a number of events")
+		}
+		cfg.ReplaySize = n
+	}
+	if heartbeat := os.Getenv(SSE_HEARTBEAT_SEC); heartbeat != "" {
+		sec, err := strconv.Atoi(heartbeat)
+		if err != nil || sec <= 0 {
+			return nil, errors.New("sse heartbeat must be a positive number of seconds")
+		}
+		cfg.Heartbeat = time.Duration(sec) * time.Second
+	}
+	return cfg, nil
+}
+
+const (
+	// Messages reach clients connected to the same instance only
+	WS_BROKER_MEMORY = "memory"
+	WS_BROKER_VALKEY = "valkey"
+)
+
+// Configuration of the WebSocket hub. Clients sending messages larger
+// than MaxMessageSize are disconnected, as are clients with more than
+// SendBuffer messages waiting to be written. Connections are pinged every
+// PingInterval. Browsers connecting from other sites are refused unless
+// their host matches one of OriginPatterns, e.g. "*.example.com".
+type WebSocketConfig struct {
+	Broker         string
+	MaxMessageSize int64
+	SendBuffer     int
+	PingInterval   time.Duration
+	OriginPatterns []string
+}
+
+func newDefaultWebSocketConfig(config *Config) (*WebSocketConfig, error) {
+	cfg := &WebSocketConfig{
+		Broker:         os.Getenv(WS_BROKER),
+		MaxMessageSize: 32 << 10,
+		SendBuffer:     64,
+		PingInterval:   30 * time.Second,
+		OriginPatterns: splitList(os.Getenv(WS_ORIGIN_PATTERNS)),
+	}
+	switch cfg.Broker {
+	case WS_BROKER_MEMORY:
+	case WS_BROKER_VALKEY:
+		if config.Valkey == nil {
+			return nil, errors.New("valkey websocket broker requires valkey database")
+		}
+	default:
+		return nil, errors.New("websocket broker must be one of: memory, valkey")
+	}
+	if size := os.Getenv(WS_MAX_MESSAGE_BYTES); size != "" {
+		n, err := strconv.ParseInt(size, 10, 64)
+		if err != nil || n <= 0 {
+			return nil, errors.New("websocket max message size must be a positive number of bytes")
+		}
+		cfg.MaxMessageSize = n
+	}
+	if buffer := os.Getenv(WS_SEND_BUFFER); buffer != "" {
+		n, err := strconv.Atoi(buffer)
+		if err != nil || n <= 0 {
+			return nil, errors.New("websocket send buffer must be a positive number of messages")
+		}
+		cfg.SendBuffer = n
+	}
+	if interval := os.Getenv(WS_PING_INTERVAL_SEC); interval != "" {
+		sec, err := strconv.Atoi(interval)
+		if err != nil || sec <= 0 {
+			return nil, errors.New("websocket ping interval must be a positive number of seconds")
+		}
+		cfg.PingInterval = time.Duration(sec) * time.Second
+	}
+	return cfg, nil
+}
+
+const (
+	UPLOADS_STORE_LOCAL  = "local"
+	UPLOADS_STORE_S3     = "s3"
+	UPLOADS_STORE_GRIDFS = "gridfs"
+)
+
+// Configuration of file uploads. Files larger than MaxSize or of a type
+// (sniffed from the content) not matching AllowedTypes are refused.
+// Resumable uploads send chunks of up to ChunkSize. Download links are
+// signed with AES_SECRET and valid for URLTTL.
+type UploadsConfig struct {
+	Store        string
+	Dir          string
+	S3           *S3Config
+	MaxSize      int64
+	ChunkSize    int64
+	AllowedTypes []string
+	URLTTL       time.Duration
+}
+
+// S3-compatible object storage (AWS S3, MinIO, R2...) of the s3 uploads
+// store. Endpoint is the host with an optional port, without scheme.
+type S3Config struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	UseSSL    bool
+}
+
+func newDefaultUploadsConfig(config *Config) (*UploadsConfig, error) {
+	cfg := &UploadsConfig{
+		Store:        os.Getenv(UPLOADS_STORE),
+		MaxSize:      10 << 20,
+		ChunkSize:    5 << 20,
+		AllowedTypes: []string{"image/png", "image/jpeg", "image/gif", "image/webp", "application/pdf"},
+		URLTTL:       time.Hour,
+	}
+	switch cfg.Store {
+	case UPLOADS_STORE_LOCAL:
+		cfg.Dir = os.Getenv(UPLOADS_DIR)
+		if cfg.Dir == "" {
+			cfg.Dir = "uploads"
+		}
+	case UPLOADS_STORE_S3:
+		s3, err := newDefaultS3Config()
+		if err != nil {
+			return nil, err
+		}
+		cfg.S3 = s3
+	case UPLOADS_STORE_GRIDFS:
+		if config.Mongo == nil {
+			return nil, errors.New("gridfs uploads store requires mongo database")
+		}
+	default:
+		return nil, errors.New("uploads store must be one of: local, s3, gridfs")
+	}
+	if os.Getenv(AES_SECRET) == "" {
+		return nil, errors.New("uploads require aes secret to sign download urls")
+	}
+	for key, target := range map[string]*int64{UPLOADS_MAX_BYTES: &cfg.MaxSize, UPLOADS_CHUNK_BYTES: &cfg.ChunkSize} {
+		if value := os.Getenv(key); value != "" {
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || n <= 0 {
+				return nil, errors.New(strings.ReplaceAll(strings.ToLower(key), "_", " ") + " must be a positive number")
+			}
+			*target = n
+		}
+	}
+	if types := splitList(os.Getenv(UPLOADS_ALLOWED_TYPES)); len(types) > 0 {
+		for _, t := range types {
+			if _, _, err := mime.ParseMediaType(t); err != nil || !strings.Contains(t, "/") {
+				return nil, errors.New("uploads allowed types must be media types, e.g. image/png or image/*")
+			}
+		}
+		cfg.AllowedTypes = types
+	}
+	if ttl := os.Getenv(UPLOADS_URL_TTL_SEC); ttl != "" {
+		sec, err := strconv.Atoi(ttl)
+		if err != nil || sec <= 0 {
+			return nil, errors.New("uploads url ttl must be a positive number of seconds")
+		}
+		cfg.URLTTL = time.Duration(sec) * time.Second
+	}
+	return cfg, nil
+}
+
+func newDefaultS3Config() (*S3Config, error) {
+	cfg := &S3Config{
+		Endpoint:  os.Getenv(S3_ENDPOINT),
+		Region:    os.Getenv(S3_REGION),
+		Bucket:    os.Getenv(S3_BUCKET),
+		AccessKey: os.Getenv(S3_ACCESS_KEY),
+		SecretKey: os.Getenv(S3_SECRET_KEY),
+		UseSSL:    os.Getenv(S3_USE_SSL) != "false",
+	}
+	if cfg.Endpoint == "" || strings.Contains(cfg.Endpoint, "://") {
+		return nil, errors.New("s3 endpoint must be a host without scheme, e.g. localhost:9000")
+	}
+	if cfg.Bucket == "" {
+		return nil, errors.New("s3 bucket is required by the s3 uploads store")
+	}
+	if cfg.AccessKey == "" || cfg.SecretKey == "" {
+		return nil, errors.New("s3 access key and secret key are required by the s3 uploads store")
+	}
+	return cfg, nil
+}
+
+// Configuration of the image pipeline resizing uploaded images. Requested
+// sizes are capped at MaxDimension and images of more than MaxPixels
+// aren't decoded. Quality applies to lossy formats, SrcSetWidths are the
+// widths offered in srcset attributes.
+type ImagesConfig struct {
+	MaxDimension int
+	MaxPixels    int
+	Quality      int
+	SrcSetWidths []int
+}
+
+func newDefaultImagesConfig(config *Config) (*ImagesConfig, error) {
+	if config.Uploads == nil {
+		return nil, errors.New("images require uploads store")
+	}
+	cfg := &ImagesConfig{
+		MaxDimension: 4096,
+		MaxPixels:    40_000_000,
+		Quality:      82,
+		SrcSetWidths: []int{320, 640, 960, 1280, 1920},
+	}
+	if value := os.Getenv(IMAGES_MAX_DIMENSION); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			return nil, errors.New("images max dimension must be a positive number of pixels")
+		}
+		cfg.MaxDimension = n
+	}
+	if value := os.Getenv(IMAGES_MAX_PIXELS); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			return nil, errors.New("images max pixels must be a positive number")
+		}
+		cfg.MaxPixels = n
+	}
+	if value := os.Getenv(IMAGES_QUALITY); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > 100 {
+			return nil, errors.New("images quality must be between 1 and 100")
+		}
+		cfg.Quality = n
+	}
+	if widths := splitList(os.Getenv(IMAGES_SRCSET_WIDTHS)); len(widths) > 0 {
+		cfg.SrcSetWidths = make([]int, len(widths))
+		for i, value := range widths {
+			n, err := strconv.Atoi(value)
+			if err != nil || n <= 0 || n > cfg.MaxDimension {
+				return nil, errors.New("images srcset widths must be positive numbers up to the max dimension")
+			}
+			cfg.SrcSetWidths[i] = n
+		}
+		slices.Sort(cfg.SrcSetWidths)
+	}
+	return cfg, nil
 }
@@ -1,8 +1,10 @@
 package utils
 
 import (
+	"log/slog"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -184,3 +186,897 @@ func assertConfigNil(t *testing.T, c *Config, err error, msg string) {
 	assert.Error(t, err, "expected an error")
 	assert.Equal(t, err.Error(), msg, "expected the same error message")
 }
+
+func TestMetricsConfig(t *testing.T) {
+	for _, key := range AllConfigKeys() {
+		defer os.Unsetenv(key)
+	}
+	os.Setenv(HTTP_PORT, "3000")
+
+	c, err := NewDefaultConfig()
+	assert.Nil(t, err, "expected no errors")
+	assert.Nil(t, c.Metrics, "expected nil metrics config")
+
+	os.Setenv(USE_METRICS, "true")
+	c, err = NewDefaultConfig()
+	assert.Nil(t, err, "expected no errors")
+	assert.Equal(t, "/metrics", c.Metrics.Path, "expected default metrics path")
+	assert.Equal(t, 0, c.Metrics.Port, "expected metrics on the main router")
+
+	os.Setenv(METRICS_PATH, "internal/metrics")
+	os.Setenv(METRICS_PORT, "invalid")
+	c, err = NewDefaultConfig()
+	assertConfigNil(t, c, err, "metrics port must be a valid port number")
+
+	os.Setenv(METRICS_PORT, "3000")
+	c, err = NewDefaultConfig()
+	assertConfigNil(t, c, err, "metrics port must be different from http port")
+
+	os.Setenv(METRICS_PORT, "9090")
+	c, err = NewDefaultConfig()
+	assert.Nil(t, err, "expected no errors")
+	assert.Equal(t, "/internal/metrics", c.Metrics.Path, "expected metrics path with leading slash")
+	assert.Equal(t, 9090, c.Metrics.Port, "expected the same metrics port")
+}
+
+func TestStoreConnectionConfig(t *testing.T) {
+	pg := &PostgresConfig{Username: "user", Password: "p@ss", Host: "db", Port: "5432", DBName: "app"}
+	assert.Equal(t, "postgres://user:p%40ss@db:5432/app", pg.ConnectionString(), "expected escaped postgres connection string")
+
+	vk := &ValkeyConfig{Host: "cache", Port: "6379"}
+	assert.Equal(t, "cache:6379", vk.Address(), "expected the same valkey address")
+}
+
+func TestTracingConfig(t *testing.T) {
+	for _, key := range AllConfigKeys() {
+		defer os.Unsetenv(key)
+	}
+	os.Setenv(HTTP_PORT, "3000")
+
+	c, err := NewDefaultConfig()
+	assert.Nil(t, err, "expected no errors")
+	assert.Nil(t, c.Tracing, "expected nil tracing config")
+
+	os.Setenv(USE_TRACING, "true")
+	c, err = NewDefaultConfig()
+	assert.Nil(t, err, "expected no errors")
+	assert.Equal(t, TRACING_EXPORTER_OTLP, c.Tracing.Exporter, "expected otlp exporter by default")
+	assert.Equal(t, "localhost:4318", c.Tracing.Endpoint, "expected default otlp endpoint")
+	assert.Equal(t, 1.0, c.Tracing.SampleRatio, "expected every trace sampled by default")
+
+	os.Setenv(TRACING_EXPORTER, "zipkin")
+	c, err = NewDefaultConfig()
+	assertConfigNil(t, c, err, "tracing exporter must be one of: otlp, stdout, memory")
+
+	os.Setenv(TRACING_EXPORTER, TRACING_EXPORTER_STDOUT)
+	os.Setenv(TRACING_SAMPLE_RATIO, "1.5")
+	c, err = NewDefaultConfig()
+	assertConfigNil(t, c, err, "tracing sample ratio must be a number between 0 and 1")
+
+	os.Setenv(TRACING_SAMPLE_RATIO, "0.25")
+	c, err = NewDefaultConfig()
+	assert.Nil(t, err, "expected no errors")
+	assert.Equal(t, 0.25, c.Tracing.SampleRatio, "expected the same sample ratio")
+	assert.Empty(t, c.Tracing.Endpoint, "expected no endpoint for stdout exporter")
+}
+
+func TestLoggingConfig(t *testing.T) {
+	for _, key := range AllConfigKeys() {
+		defer os.Unsetenv(key)
+	}
+	os.Setenv(HTTP_PORT, "3000")
+
+	c, err := NewDefaultConfig()
+	assert.Nil(t, err, "expected no errors")
+	assert.Equal(t, LOG_FORMAT_JSON, c.Logging.Format, "expected json logs by default")
+	assert.Equal(t, slog.LevelInfo, c.Logging.Level, "expected info level by default")
+	assert.Equal(t, DEFAULT_LOG_REDACT_HEADERS, c.Logging.RedactHeaders, "expected default redacted headers")
+	assert.Equal(t, time.Second, c.Logging.SlowRequest, "expected default slow request threshold")
+
+	os.Setenv(LOG_FORMAT, "xml")
+	c, err = NewDefaultConfig()
+	assertConfigNil(t, c, err, "log format must be one of: json, text")
+
+	os.Setenv(LOG_FORMAT, LOG_FORMAT_TEXT)
+	os.Setenv(LOG_LEVEL, "loud")
+	c, err = NewDefaultConfig()
+	assertConfigNil(t, c, err, "log level must be one of: debug, info, warn, error")
+
+	os.Setenv(LOG_LEVEL, "debug")
+	os.Setenv(LOG_SAMPLE_RATE, "2")
+	c, err = NewDefaultConfig()
+	assertConfigNil(t, c, err, "log sample rate must be a number between 0 and 1")
+
+	os.Setenv(LOG_SAMPLE_RATE, "0.1")
+	os.Setenv(LOG_SLOW_REQUEST_MS, "-5")
+	c, err = NewDefaultConfig()
+	assertConfigNil(t, c, err, "slow request threshold must be a positive number of milliseconds")
+
+	os.Setenv(LOG_SLOW_REQUEST_MS, "250")
+	os.Setenv(LOG_REDACT_QUERY, "otp, ,pin")
+	c, err = NewDefaultConfig()
+	assert.Nil(t, err, "expected no errors")
+	assert.Equal(t, slog.LevelDebug, c.Logging.Level, "expected the same log level")
+	assert.Equal(t, 0.1, c.Logging.SampleRate, "expected the same sample rate")
+	assert.Equal(t, 250*time.Millisecond, c.Logging.SlowRequest, "expected the same slow request threshold")
+	assert.Equal(t, append([]string{"otp", "pin"}, DEFAULT_LOG_REDACT_QUERY...), c.Logging.RedactQuery, "expected custom query params added to defaults")
+}
+
+func TestRequestIDConfig(t *testing.T) {
+	for _, key := range AllConfigKeys() {
+		defer os.Unsetenv(key)
+	}
+	os.Setenv(HTTP_PORT, "3000")
+
+	c, err := NewDefaultConfig()
+	assert.Nil(t, err, "expected no errors")
+	assert.False(t, c.Middleware.RequestID, "expected request id mw false")
+	assert.Equal(t, "X-Request-ID", c.Middleware.RequestIDHeader, "expected default request id header")
+	assert.Equal(t, REQUEST_ID_FORMAT_UUIDV7, c.Middleware.RequestIDFormat, "expected uuidv7 by default")
+
+	os.Setenv(MW_REQUEST_ID_FORMAT, "uuidv4")
+	c, err = NewDefaultConfig()
+	assertConfigNil(t, c, err, "request id format must be one of: uuidv7, ulid")
+
+	os.Setenv(USE_MW_REQUEST_ID, "true")
+	os.Setenv(MW_REQUEST_ID_HEADER, "X-Correlation-ID")
+	os.Setenv(MW_REQUEST_ID_FORMAT, REQUEST_ID_FORMAT_ULID)
+	c, err = NewDefaultConfig()
+	assert.Nil(t, err, "expected no errors")
+	assert.True(t, c.Middleware.RequestID, "expected request id mw true")
+	assert.Equal(t, "X-Correlation-ID", c.Middleware.RequestIDHeader, "expected the same header")
+	assert.Equal(t, REQUEST_ID_FORMAT_ULID, c.Middleware.RequestIDFormat, "expected the same format")
+}
+
+func TestDevelopmentConfig(t *testing.T) {
+	for _, key := range AllConfigKeys() {
+		defer os.Unsetenv(key)
+	}
+	os.Setenv(HTTP_PORT, "3000")
+
+	c, err := NewDefaultConfig()
+	assert.Nil(t, err, "expected no errors")
+	assert.False(t, c.HTTP.Development, "expected production mode by default")
+
+	os.Setenv(APP_ENV, "development")
+	c, err = NewDefaultConfig()
+	assert.Nil(t, err, "expected no errors")
+	assert.True(t, c.HTTP.Development, "expected development mode")
+}
+
+func TestCompressionConfig(t *testing.T) {
+	for _, key := range AllConfigKeys() {
+		defer os.Unsetenv(key)
+	}
+	os.Setenv(HTTP_PORT, "3000")
+
+	c, err := NewDefaultConfig()
+	assert.Nil(t, err, "expected no errors")
+	assert.False(t, c.Middleware.Compression, "expected compression mw false")
+	assert.Nil(t, c.Middleware.CompressionMinSize, "expected default min size")
+	assert.Empty(t, c.Middleware.CompressionTypes, "expected default content types")
+
+	os.Setenv(MW_COMPRESSION_MIN_SIZE, "big")
+	c, err = NewDefaultConfig()
+	assertConfigNil(t, c, err, "compression min size must be a positive number")
+
+	os.Setenv(USE_MW_COMPRESSION, "true")
+	os.Setenv(MW_COMPRESSION_MIN_SIZE, "512")
+	os.Setenv(MW_COMPRESSION_TYPES, "text/html, application/json")
+	c, err = NewDefaultConfig()
+	assert.Nil(t, err, "expected no errors")
+	assert.True(t, c.Middleware.Compression, "expected compression mw true")
+	assert.Equal(t, 512, *c.Middleware.CompressionMinSize, "expected the same min size")
+	assert.Equal(t, []string{"text/html", "application/json"}, c.Middleware.CompressionTypes, "expected the same content types")
+}
+
+func TestCSRFConfig(t *testing.T) {
+	for _, key := range AllConfigKeys() {
+		defer os.Unsetenv(key)
+	}
+	os.Setenv(HTTP_PORT, "3000")
+
+	c, err := NewDefaultConfig()
+	assert.Nil(t, err, "expected no errors")
+	assert.False(t, c.Middleware.CSRF, "expected csrf mw false")
+
+	os.Setenv(USE_MW_CSRF, "true")
+	c, err = NewDefaultConfig()
+	assertConfigNil(t, c, err, "csrf protection requires aes secret to sign tokens")
+
+	os.Setenv(AES_SECRET, "0123456789abcdef0123456789abcdef")
+	os.Setenv(MW_CSRF_TRUSTED_ORIGINS, "example.com")
+	c, err = NewDefaultConfig()
+	assertConfigNil(t, c, err, "csrf trusted origins must be absolute urls (e.g. https://example.com)")
+
+	os.Setenv(MW_CSRF_TRUSTED_ORIGINS, "https://example.com/, https://admin.example.com")
+	os.Setenv(MW_CSRF_EXEMPT_PATHS, "/api/, /webhooks/")
+	c, err = NewDefaultConfig()
+	assert.Nil(t, err, "expected no errors")
+	assert.True(t, c.Middleware.CSRF, "expected csrf mw true")
+	assert.Equal(t, []string{"https://example.com", "https://admin.example.com"}, c.Middleware.CSRFTrustedOrigins, "expected normalised origins")
+	assert.Equal(t, []string{"/api/", "/webhooks/"}, c.Middleware.CSRFExemptPaths, "expected the same exempt paths")
+}
+
+func TestSessionConfig(t *testing.T) {
+	for _, key := range AllConfigKeys() {
+		defer os.Unsetenv(key)
+	}
+	os.Setenv(HTTP_PORT, "3000")
+
+	c, err := NewDefaultConfig()
+	assert.Nil(t, err, "expected no errors")
+	assert.Nil(t, c.Session, "expected sessions disabled")
+
+	os.Setenv(SESSION_STORE, "memcached")
+	c, err = NewDefaultConfig()
+	assertConfigNil(t, c, err, "session store must be one of: cookie, valkey, mongo, postgres")
+
+	os.Setenv(SESSION_STORE, SESSION_STORE_VALKEY)
+	c, err = NewDefaultConfig()
+	assertConfigNil(t, c, err, "valkey session store requires valkey database")
+
+	os.Setenv(SESSION_STORE, SESSION_STORE_COOKIE)
+	c, err = NewDefaultConfig()
+	assertConfigNil(t, c, err, "cookie session store requires aes secret to encrypt sessions")
+
+	os.Setenv(AES_SECRET, "0123456789abcdef0123456789abcdef")
+	c, err = NewDefaultConfig()
+	assert.Nil(t, err, "expected no errors")
+	assert.Equal(t, SESSION_STORE_COOKIE, c.Session.Store, "expected the same store")
+	assert.Equal(t, "session", c.Session.CookieName, "expected default cookie name")
+	assert.Equal(t, 30*time.Minute, c.Session.IdleTimeout, "expected default idle timeout")
+	assert.Equal(t, 24*time.Hour, c.Session.AbsoluteTimeout, "expected default absolute timeout")
+
+	os.Setenv(SESSION_IDLE_TIMEOUT_MIN, "120")
+	os.Setenv(SESSION_ABSOLUTE_TIMEOUT_MIN, "60")
+	c, err = NewDefaultConfig()
+	assertConfigNil(t, c, err, "session idle timeout cannot be longer than absolute timeout")
+
+	os.Setenv(SESSION_IDLE_TIMEOUT_MIN, "15")
+	os.Setenv(SESSION_COOKIE_NAME, "sid")
+	c, err = NewDefaultConfig()
+	assert.Nil(t, err, "expected no errors")
+	assert.Equal(t, "sid", c.Session.CookieName, "expected the same cookie name")
+	assert.Equal(t, 15*time.Minute, c.Session.IdleTimeout, "expected the same idle timeout")
+	assert.Equal(t, time.Hour, c.Session.AbsoluteTimeout, "expected the same absolute timeout")
+}
+
+func TestAccountsConfig(t *testing.T) {
+	for _, key := range AllConfigKeys() {
+		defer os.Unsetenv(key)
+	}
+	os.Setenv(HTTP_PORT, "3000")
+
+	c, err := NewDefaultConfig()
+	assert.Nil(t, err, "expected no errors")
+	assert.Nil(t, c.Accounts, "expected accounts disabled")
+
+	os.Setenv(ACCOUNTS_STORE, "memory")
+	c, err = NewDefaultConfig()
+	assertConfigNil(t, c, err, "accounts store must be one of: mongo, postgres")
+
+	os.Setenv(ACCOUNTS_STORE, ACCOUNTS_STORE_POSTGRES)
+	c, err = NewDefaultConfig()
+	assertConfigNil(t, c, err, "postgres accounts store requires postgres database")
+
+	os.Setenv(USE_DB_POSTGRES, "true")
+	c, err = NewDefaultConfig()
+	assertConfigNil(t, c, err, "accounts require sessions to be enabled")
+
+	os.Setenv(SESSION_STORE, SESSION_STORE_POSTGRES)
+	c, err = NewDefaultConfig()
+	assert.Nil(t, err, "expected no errors")
+	assert.Equal(t, ACCOUNTS_STORE_POSTGRES, c.Accounts.Store, "expected the same store")
+}
+
+func TestBaseURLConfig(t *testing.T) {
+	for _, key := range AllConfigKeys() {
+		defer os.Unsetenv(key)
+	}
+	os.Setenv(HTTP_PORT, "3000")
+
+	c, err := NewDefaultConfig()
+	assert.Nil(t, err, "expected no errors")
+	assert.Equal(t, "http://localhost:3000", c.HTTP.BaseURL, "expected local url by default")
+
+	os.Setenv(APP_URL, "example.com")
+	c, err = NewDefaultConfig()
+	assertConfigNil(t, c, err, "app url must be an absolute url (e.g. https://example.com)")
+
+	os.Setenv(APP_URL, "https://example.com/")
+	c, err = NewDefaultConfig()
+	assert.Nil(t, err, "expected no errors")
+	assert.Equal(t, "https://example.com", c.HTTP.BaseURL, "expected url without trailing slash")
+}
+
+func TestJWTConfig(t *testing.T) {
+	for _, key := range AllConfigKeys() {
+		defer os.Unsetenv(key)
+	}
+	os.Setenv(HTTP_PORT, "3000")
+
+	c, err := NewDefaultConfig()
+	assert.Nil(t, err, "expected no errors")
+	assert.Nil(t, c.JWT, "expected jwt disabled")
+
+	os.Setenv(JWT_KEY_FILES, "keys/jwt-2.pem, keys/jwt-1.pem")
+	c, err = NewDefaultConfig()
+	assertConfigNil(t, c, err, "jwt store must be one of: valkey, postgres")
+
+	os.Setenv(JWT_STORE, JWT_STORE_POSTGRES)
+	c, err = NewDefaultConfig()
+	assertConfigNil(t, c, err, "postgres jwt store requires postgres database")
+
+	os.Setenv(USE_DB_POSTGRES, "true")
+	c, err = NewDefaultConfig()
+	assert.Nil(t, err, "expected no errors")
+	assert.Equal(t, []string{"keys/jwt-2.pem", "keys/jwt-1.pem"}, c.JWT.KeyFiles, "expected key files in order")
+	assert.Equal(t, "http://localhost:3000", c.JWT.Issuer, "expected base url as issuer")
+	assert.Equal(t, []string{"http://localhost:3000"}, c.JWT.Audience, "expected base url as audience")
+	assert.Equal(t, 15*time.Minute, c.JWT.AccessTTL, "expected default access ttl")
+	assert.Equal(t, 30*24*time.Hour, c.JWT.RefreshTTL, "expected default refresh ttl")
+	assert.Equal(t, time.Minute, c.JWT.ClockSkew, "expected default clock skew")
+
+	os.Setenv(JWT_CLOCK_SKEW_SEC, "-1")
+	c, err = NewDefaultConfig()
+	assertConfigNil(t, c, err, "jwt clock skew must be a non-negative number of seconds")
+
+	os.Setenv(JWT_CLOCK_SKEW_SEC, "30")
+	os.Setenv(JWT_ACCESS_TTL_MIN, "120")
+	os.Setenv(JWT_REFRESH_TTL_HOURS, "1")
+	c, err = NewDefaultConfig()
+	assertConfigNil(t, c, err, "jwt access token ttl cannot be longer than refresh token ttl")
+
+	os.Setenv(JWT_ACCESS_TTL_MIN, "5")
+	os.Setenv(JWT_ISSUER, "https://auth.example.com")
+	os.Setenv(JWT_AUDIENCE, "mobile,web")
+	c, err = NewDefaultConfig()
+	assert.Nil(t, err, "expected no errors")
+	assert.Equal(t, "https://auth.example.com", c.JWT.Issuer, "expected the same issuer")
+	assert.Equal(t, []string{"mobile", "web"}, c.JWT.Audience, "expected the same audience")
+	assert.Equal(t, 5*time.Minute, c.JWT.AccessTTL, "expected the same access ttl")
+	assert.Equal(t, 30*time.Second, c.JWT.ClockSkew, "expected the same clock skew")
+}
+
+func TestAuthzConfig(t *testing.T) {
+	for _, key := range AllConfigKeys() {
+		defer os.Unsetenv(key)
+	}
+	os.Setenv(HTTP_PORT, "3000")
+
+	c, err := NewDefaultConfig()
+	assert.Nil(t, err, "expected no errors")
+	assert.Nil(t, c.Authz, "expected authz disabled")
+
+	os.Setenv(AUTHZ_STORE, "memory")
+	c, err = NewDefaultConfig()
+	assertConfigNil(t, c, err, "authz store must be one of: mongo, postgres")
+
+	os.Setenv(AUTHZ_STORE, AUTHZ_STORE_MONGO)
+	c, err = NewDefaultConfig()
+	assertConfigNil(t, c, err, "mongo authz store requires mongo database")
+
+	os.Setenv(AUTHZ_STORE, AUTHZ_STORE_POSTGRES)
+	os.Setenv(USE_DB_POSTGRES, "true")
+	c, err = NewDefaultConfig()
+	assert.Nil(t, err, "expected no errors")
+	assert.Equal(t, AUTHZ_STORE_POSTGRES, c.Authz.Store, "expected the same store")
+}
+
+func TestOIDCConfig(t *testing.T) {
+	for _, key := range append(AllConfigKeys(), "OIDC_GOOGLE_ISSUER", "OIDC_GOOGLE_CLIENT_ID", "OIDC_GOOGLE_CLIENT_SECRET", "OIDC_GOOGLE_SCOPES") {
+		defer os.Unsetenv(key)
+	}
+	os.Setenv(HTTP_PORT, "3000")
+
+	c, err := NewDefaultConfig()
+	assert.Nil(t, err, "expected no errors")
+	assert.Nil(t, c.OIDC, "expected oidc disabled")
+
+	os.Setenv(OIDC_PROVIDERS, "google")
+	c, err = NewDefaultConfig()
+	assertConfigNil(t, c, err, "oidc requires accounts to be enabled")
+
+	os.Setenv(USE_DB_POSTGRES, "true")
+	os.Setenv(SESSION_STORE, SESSION_STORE_POSTGRES)
+	os.Setenv(ACCOUNTS_STORE, ACCOUNTS_STORE_POSTGRES)
+	os.Setenv(OIDC_PROVIDERS, "google,google")
+	os.Setenv("OIDC_GOOGLE_ISSUER", "https://accounts.google.com")
+	os.Setenv("OIDC_GOOGLE_CLIENT_ID", "client")
+	os.Setenv("OIDC_GOOGLE_CLIENT_SECRET", "secret")
+	c, err = NewDefaultConfig()
+	assertConfigNil(t, c, err, `oidc provider "google" is listed more than once`)
+
+	os.Setenv(OIDC_PROVIDERS, "my-idp")
+	c, err = NewDefaultConfig()
+	assertConfigNil(t, c, err, `oidc provider name "my-idp" must contain only letters, digits and underscores`)
+
+	os.Setenv(OIDC_PROVIDERS, "google")
+	os.Setenv("OIDC_GOOGLE_ISSUER", "accounts.google.com")
+	c, err = NewDefaultConfig()
+	assertConfigNil(t, c, err, `oidc provider "google" requires valid OIDC_GOOGLE_ISSUER url`)
+
+	os.Setenv("OIDC_GOOGLE_ISSUER", "https://accounts.google.com")
+	os.Setenv("OIDC_GOOGLE_SCOPES", "email profile")
+	c, err = NewDefaultConfig()
+	assertConfigNil(t, c, err, `oidc provider "google" scopes must include openid`)
+
+	os.Unsetenv("OIDC_GOOGLE_SCOPES")
+	c, err = NewDefaultConfig()
+	assert.Nil(t, err, "expected no errors")
+	assert.Len(t, c.OIDC.Providers, 1, "expected one provider")
+	provider := c.OIDC.Providers[0]
+	assert.Equal(t, "Google", provider.Label, "expected label derived from the name")
+	assert.Equal(t, []string{"openid", "email", "profile"}, provider.Scopes, "expected default scopes")
+}
+
+func TestMFAConfig(t *testing.T) {
+	for _, key := range append(AllConfigKeys(), AES_SECRET, APP_URL) {
+		defer os.Unsetenv(key)
+	}
+	os.Setenv(HTTP_PORT, "3000")
+
+	c, err := NewDefaultConfig()
+	assert.Nil(t, err, "expected no errors")
+	assert.Nil(t, c.MFA, "expected mfa disabled")
+
+	os.Setenv(USE_MFA, "true")
+	c, err = NewDefaultConfig()
+	assertConfigNil(t, c, err, "mfa requires accounts to be enabled")
+
+	os.Setenv(USE_DB_POSTGRES, "true")
+	os.Setenv(SESSION_STORE, SESSION_STORE_POSTGRES)
+	os.Setenv(ACCOUNTS_STORE, ACCOUNTS_STORE_POSTGRES)
+	os.Setenv(AES_SECRET, "too short")
+	c, err = NewDefaultConfig()
+	assertConfigNil(t, c, err, "mfa requires aes secret of 16, 24 or 32 bytes to encrypt secrets")
+
+	os.Setenv(AES_SECRET, "0123456789abcdef0123456789abcdef")
+	os.Setenv(MFA_REMEMBER_DEVICE_DAYS, "-1")
+	c, err = NewDefaultConfig()
+	assertConfigNil(t, c, err, "mfa remember device days must be a non-negative number")
+
+	os.Setenv(MFA_REMEMBER_DEVICE_DAYS, "0")
+	os.Setenv(APP_URL, "https://shop.example.com")
+	c, err = NewDefaultConfig()
+	assert.Nil(t, err, "expected no errors")
+	assert.Equal(t, "shop.example.com", c.MFA.Issuer, "expected issuer from the base url")
+	assert.Zero(t, c.MFA.RememberDeviceTTL, "expected remembering devices disabled")
+}
+
+func TestMagicLinkConfig(t *testing.T) {
+	for _, key := range AllConfigKeys() {
+		defer os.Unsetenv(key)
+	}
+	os.Setenv(HTTP_PORT, "3000")
+
+	c, err := NewDefaultConfig()
+	assert.Nil(t, err, "expected no errors")
+	assert.Nil(t, c.MagicLink, "expected magic link disabled")
+
+	os.Setenv(USE_MAGIC_LINK, "true")
+	c, err = NewDefaultConfig()
+	assertConfigNil(t, c, err, "magic link requires accounts to be enabled")
+
+	os.Setenv(USE_DB_POSTGRES, "true")
+	os.Setenv(SESSION_STORE, SESSION_STORE_POSTGRES)
+	os.Setenv(ACCOUNTS_STORE, ACCOUNTS_STORE_POSTGRES)
+	c, err = NewDefaultConfig()
+	assert.Nil(t, err, "expected no errors")
+	assert.Equal(t, 15*time.Minute, c.MagicLink.TTL, "expected default ttl")
+	assert.Equal(t, 5, c.MagicLink.MaxRequests, "expected default rate limit")
+
+	os.Setenv(MAGIC_LINK_TTL_MIN, "0")
+	c, err = NewDefaultConfig()
+	assertConfigNil(t, c, err, "magic link ttl must be a positive number of minutes")
+
+	os.Setenv(MAGIC_LINK_TTL_MIN, "5")
+	os.Setenv(MAGIC_LINK_MAX_PER_HOUR, "many")
+	c, err = NewDefaultConfig()
+	assertConfigNil(t, c, err, "magic link max per hour must be a positive number")
+
+	os.Setenv(MAGIC_LINK_MAX_PER_HOUR, "3")
+	c, err = NewDefaultConfig()
+	assert.Nil(t, err, "expected no errors")
+	assert.Equal(t, 5*time.Minute, c.MagicLink.TTL)
+	assert.Equal(t, 3, c.MagicLink.MaxRequests)
+}
+
+func TestAPIKeysConfig(t *testing.T) {
+	for _, key := range AllConfigKeys() {
+		defer os.Unsetenv(key)
+	}
+	os.Setenv(HTTP_PORT, "3000")
+
+	c, err := NewDefaultConfig()
+	assert.Nil(t, err, "expected no errors")
+	assert.Nil(t, c.APIKeys, "expected api keys disabled")
+
+	os.Setenv(USE_API_KEYS, "true")
+	c, err = NewDefaultConfig()
+	assertConfigNil(t, c, err, "api keys require accounts to be enabled")
+
+	os.Setenv(USE_DB_POSTGRES, "true")
+	os.Setenv(SESSION_STORE, SESSION_STORE_POSTGRES)
+	os.Setenv(ACCOUNTS_STORE, ACCOUNTS_STORE_POSTGRES)
+	c, err = NewDefaultConfig()
+	assert.Nil(t, err, "expected no errors")
+	assert.Equal(t, &APIKeysConfig{Prefix: "api", MaxPerUser: 10, RateLimitPerMinute: 60}, c.APIKeys, "expected defaults")
+
+	os.Setenv(API_KEY_PREFIX, "My_Key")
+	c, err = NewDefaultConfig()
+	assertConfigNil(t, c, err, "api key prefix must have 2-16 lower case letters or digits")
+
+	os.Setenv(API_KEY_PREFIX, "shop")
+	os.Setenv(API_KEYS_MAX_PER_USER, "0")
+	c, err = NewDefaultConfig()
+	assertConfigNil(t, c, err, "api keys max per user must be a positive number")
+
+	os.Setenv(API_KEYS_MAX_PER_USER, "3")
+	os.Setenv(API_KEY_RATE_LIMIT_PER_MIN, "fast")
+	c, err = NewDefaultConfig()
+	assertConfigNil(t, c, err, "api key rate limit must be a positive number of requests per minute")
+
+	os.Setenv(API_KEY_RATE_LIMIT_PER_MIN, "600")
+	c, err = NewDefaultConfig()
+	assert.Nil(t, err, "expected no errors")
+	assert.Equal(t, &APIKeysConfig{Prefix: "shop", MaxPerUser: 3, RateLimitPerMinute: 600}, c.APIKeys)
+}
+
+func TestMailConfig(t *testing.T) {
+	for _, key := range AllConfigKeys() {
+		defer os.Unsetenv(key)
+	}
+	os.Setenv(HTTP_PORT, "3000")
+
+	c, err := NewDefaultConfig()
+	assert.Nil(t, err, "expected no errors")
+	assert.Nil(t, c.Mail, "expected mail disabled")
+
+	os.Setenv(MAIL_TRANSPORT, MAIL_TRANSPORT_FILE)
+	c, err = NewDefaultConfig()
+	assertConfigNil(t, c, err, `mail from must be a valid address, e.g. "Shop <shop@example.com>"`)
+
+	os.Setenv(MAIL_FROM, "Shop <shop@example.com>")
+	c, err = NewDefaultConfig()
+	assert.Nil(t, err, "expected no errors")
+	assert.Equal(t, &MailConfig{Transport: "file", From: "Shop <shop@example.com>", Dir: "mail", QueueSize: 100, Workers: 2, MaxAttempts: 5}, c.Mail, "expected defaults")
+
+	os.Setenv(MAIL_WORKERS, "0")
+	c, err = NewDefaultConfig()
+	assertConfigNil(t, c, err, "mail workers must be a positive number")
+	os.Unsetenv(MAIL_WORKERS)
+
+	os.Setenv(MAIL_TRANSPORT, "pigeon")
+	c, err = NewDefaultConfig()
+	assertConfigNil(t, c, err, "mail transport must be one of: smtp, file")
+
+	os.Setenv(MAIL_TRANSPORT, MAIL_TRANSPORT_SMTP)
+	c, err = NewDefaultConfig()
+	assertConfigNil(t, c, err, "smtp host is required by the smtp mail transport")
+
+	os.Setenv(SMTP_HOST, "smtp.example.com")
+	c, err = NewDefaultConfig()
+	assert.Nil(t, err, "expected no errors")
+	assert.Equal(t, &SMTPConfig{Host: "smtp.example.com", Port: 587, TLS: SMTP_TLS_STARTTLS}, c.Mail.SMTP, "expected starttls by default")
+
+	os.Setenv(SMTP_TLS, SMTP_TLS_IMPLICIT)
+	os.Setenv(SMTP_USERNAME, "shop")
+	c, err = NewDefaultConfig()
+	assertConfigNil(t, c, err, "smtp username and password must be set together")
+
+	os.Setenv(SMTP_PASSWORD, "secret")
+	c, err = NewDefaultConfig()
+	assert.Nil(t, err, "expected no errors")
+	assert.Equal(t, &SMTPConfig{Host: "smtp.example.com", Port: 465, Username: "shop", Password: "secret", TLS: SMTP_TLS_IMPLICIT}, c.Mail.SMTP)
+
+	// Mailpit
+	os.Setenv(SMTP_TLS, SMTP_TLS_NONE)
+	os.Setenv(SMTP_PORT, "1025")
+	os.Unsetenv(SMTP_USERNAME)
+	os.Unsetenv(SMTP_PASSWORD)
+	c, err = NewDefaultConfig()
+	assert.Nil(t, err, "expected no errors")
+	assert.Equal(t, &SMTPConfig{Host: "smtp.example.com", Port: 1025, TLS: SMTP_TLS_NONE}, c.Mail.SMTP)
+
+	os.Setenv(SMTP_TLS, "ssl")
+	c, err = NewDefaultConfig()
+	assertConfigNil(t, c, err, "smtp tls must be one of: starttls, tls, none")
+}
+
+func TestJobsConfig(t *testing.T) {
+	for _, key := range AllConfigKeys() {
+		defer os.Unsetenv(key)
+	}
+	os.Setenv(HTTP_PORT, "3000")
+
+	c, err := NewDefaultConfig()
+	assert.Nil(t, err, "expected no errors")
+	assert.Nil(t, c.Jobs, "expected jobs disabled")
+
+	os.Setenv(JOBS_STORE, "mongo")
+	c, err = NewDefaultConfig()
+	assertConfigNil(t, c, err, "jobs store must be one of: valkey, postgres")
+
+	os.Setenv(JOBS_STORE, JOBS_STORE_POSTGRES)
+	c, err = NewDefaultConfig()
+	assertConfigNil(t, c, err, "postgres jobs store requires postgres database")
+
+	os.Setenv(USE_DB_POSTGRES, "true")
+	c, err = NewDefaultConfig()
+	assert.Nil(t, err, "expected no errors")
+	assert.Equal(t, &JobsConfig{Store: "postgres", Concurrency: 4, MaxAttempts: 5, Lease: 5 * time.Minute, PollInterval: time.Second}, c.Jobs, "expected defaults")
+
+	os.Setenv(JOBS_CONCURRENCY, "-1")
+	c, err = NewDefaultConfig()
+	assertConfigNil(t, c, err, "jobs concurrency must be a positive number")
+
+	os.Setenv(JOBS_CONCURRENCY, "10")
+	os.Setenv(JOBS_LEASE_SEC, "60")
+	os.Setenv(JOBS_POLL_INTERVAL_MS, "fast")
+	c, err = NewDefaultConfig()
+	assertConfigNil(t, c, err, "jobs poll interval must be a positive number of milliseconds")
+
+	os.Setenv(JOBS_POLL_INTERVAL_MS, "200")
+	os.Setenv(JOBS_MAX_ATTEMPTS, "3")
+	c, err = NewDefaultConfig()
+	assert.Nil(t, err, "expected no errors")
+	assert.Equal(t, &JobsConfig{Store: "postgres", Concurrency: 10, MaxAttempts: 3, Lease: time.Minute, PollInterval: 200 * time.Millisecond}, c.Jobs)
+}
+
+func TestSchedulerConfig(t *testing.T) {
+	for _, key := range AllConfigKeys() {
+		defer os.Unsetenv(key)
+	}
+	os.Setenv(HTTP_PORT, "3000")
+
+	c, err := NewDefaultConfig()
+	assert.Nil(t, err, "expected no errors")
+	assert.Nil(t, c.Scheduler, "expected scheduler disabled")
+
+	os.Setenv(USE_SCHEDULER, "true")
+	c, err = NewDefaultConfig()
+	assert.Nil(t, err, "expected no errors")
+	assert.Equal(t, &SchedulerConfig{Lock: "none", LockTTL: 30 * time.Second, Timezone: time.UTC}, c.Scheduler, "expected defaults")
+
+	os.Setenv(SCHEDULER_LOCK, "mongo")
+	c, err = NewDefaultConfig()
+	assertConfigNil(t, c, err, "scheduler lock must be one of: none, valkey, postgres")
+
+	os.Setenv(SCHEDULER_LOCK, SCHEDULER_LOCK_VALKEY)
+	c, err = NewDefaultConfig()
+	assertConfigNil(t, c, err, "valkey scheduler lock requires valkey database")
+
+	os.Setenv(USE_DB_VALKEY, "true")
+	os.Setenv(SCHEDULER_LOCK_TTL_SEC, "1")
+	c, err = NewDefaultConfig()
+	assertConfigNil(t, c, err, "scheduler lock ttl must be at least 3 seconds")
+
+	os.Setenv(SCHEDULER_LOCK_TTL_SEC, "10")
+	os.Setenv(SCHEDULER_TIMEZONE, "Mars/Olympus")
+	c, err = NewDefaultConfig()
+	assertConfigNil(t, c, err, "scheduler timezone must be an IANA time zone name, e.g. Europe/London")
+
+	os.Setenv(SCHEDULER_TIMEZONE, "Europe/Warsaw")
+	c, err = NewDefaultConfig()
+	assert.Nil(t, err, "expected no errors")
+	assert.Equal(t, "valkey", c.Scheduler.Lock)
+	assert.Equal(t, 10*time.Second, c.Scheduler.LockTTL)
+	assert.Equal(t, "Europe/Warsaw", c.Scheduler.Timezone.String())
+}
+
+func TestSSEConfig(t *testing.T) {
+	for _, key := range AllConfigKeys() {
+		defer os.Unsetenv(key)
+	}
+	os.Setenv(HTTP_PORT, "3000")
+
+	c, err := NewDefaultConfig()
+	assert.Nil(t, err, "expected no errors")
+	assert.Nil(t, c.SSE, "expected sse disabled")
+
+	os.Setenv(SSE_BROKER, "kafka")
+	c, err = NewDefaultConfig()
+	assertConfigNil(t, c, err, "sse broker must be one of: memory, valkey")
+
+	os.Setenv(SSE_BROKER, SSE_BROKER_VALKEY)
+	c, err = NewDefaultConfig()
+	assertConfigNil(t, c, err, "valkey sse broker requires valkey database")
+
+	os.Setenv(SSE_BROKER, SSE_BROKER_MEMORY)
+	c, err = NewDefaultConfig()
+	assert.Nil(t, err, "expected no errors")
+	assert.Equal(t, &SSEConfig{Broker: "memory", ReplaySize: 1000, Heartbeat: 15 * time.Second}, c.SSE, "expected defaults")
+
+	os.Setenv(SSE_REPLAY_SIZE, "-1")
+	c, err = NewDefaultConfig()
+	assertConfigNil(t, c, err, "sse replay size must be a number of events")
+
+	os.Setenv(SSE_REPLAY_SIZE, "0")
+	os.Setenv(SSE_HEARTBEAT_SEC, "0")
+	c, err = NewDefaultConfig()
+	assertConfigNil(t, c, err, "sse heartbeat must be a positive number of seconds")
+
+	os.Setenv(SSE_HEARTBEAT_SEC, "30")
+	c, err = NewDefaultConfig()
+	assert.Nil(t, err, "expected no errors")
+	assert.Equal(t, &SSEConfig{Broker: "memory", ReplaySize: 0, Heartbeat: 30 * time.Second}, c.SSE)
+}
+
+func TestWebSocketConfig(t *testing.T) {
+	for _, key := range AllConfigKeys() {
+		defer os.Unsetenv(key)
+	}
+	os.Setenv(HTTP_PORT, "3000")
+
+	c, err := NewDefaultConfig()
+	assert.Nil(t, err, "expected no errors")
+	assert.Nil(t, c.WebSocket, "expected websockets disabled")
+
+	os.Setenv(WS_BROKER, "nats")
+	c, err = NewDefaultConfig()
+	assertConfigNil(t, c, err, "websocket broker must be one of: memory, valkey")
+
+	os.Setenv(WS_BROKER, WS_BROKER_VALKEY)
+	c, err = NewDefaultConfig()
+	assertConfigNil(t, c, err, "valkey websocket broker requires valkey database")
+
+	os.Setenv(WS_BROKER, WS_BROKER_MEMORY)
+	c, err = NewDefaultConfig()
+	assert.Nil(t, err, "expected no errors")
+	assert.Equal(t, &WebSocketConfig{Broker: "memory", MaxMessageSize: 32768, SendBuffer: 64, PingInterval: 30 * time.Second}, c.WebSocket, "expected defaults")
+
+	os.Setenv(WS_MAX_MESSAGE_BYTES, "0")
+	c, err = NewDefaultConfig()
+	assertConfigNil(t, c, err, "websocket max message size must be a positive number of bytes")
+
+	os.Setenv(WS_MAX_MESSAGE_BYTES, "1024")
+	os.Setenv(WS_SEND_BUFFER, "many")
+	c, err = NewDefaultConfig()
+	assertConfigNil(t, c, err, "websocket send buffer must be a positive number of messages")
+
+	os.Setenv(WS_SEND_BUFFER, "16")
+	os.Setenv(WS_PING_INTERVAL_SEC, "-5")
+	c, err = NewDefaultConfig()
+	assertConfigNil(t, c, err, "websocket ping interval must be a positive number of seconds")
+
+	os.Setenv(WS_PING_INTERVAL_SEC, "10")
+	os.Setenv(WS_ORIGIN_PATTERNS, "app.example.com, *.example.org")
+	c, err = NewDefaultConfig()
+	assert.Nil(t, err, "expected no errors")
+	assert.Equal(t, &WebSocketConfig{
+		Broker:         "memory",
+		MaxMessageSize: 1024,
+		SendBuffer:     16,
+		PingInterval:   10 * time.Second,
+		OriginPatterns: []string{"app.example.com", "*.example.org"},
+	}, c.WebSocket)
+}
+
+func TestUploadsConfig(t *testing.T) {
+	for _, key := range AllConfigKeys() {
+		defer os.Unsetenv(key)
+	}
+	os.Setenv(HTTP_PORT, "3000")
+
+	c, err := NewDefaultConfig()
+	assert.Nil(t, err, "expected no errors")
+	assert.Nil(t, c.Uploads, "expected uploads disabled")
+
+	os.Setenv(UPLOADS_STORE, "ftp")
+	c, err = NewDefaultConfig()
+	assertConfigNil(t, c, err, "uploads store must be one of: local, s3, gridfs")
+
+	os.Setenv(UPLOADS_STORE, UPLOADS_STORE_GRIDFS)
+	c, err = NewDefaultConfig()
+	assertConfigNil(t, c, err, "gridfs uploads store requires mongo database")
+
+	os.Setenv(UPLOADS_STORE, UPLOADS_STORE_LOCAL)
+	c, err = NewDefaultConfig()
+	assertConfigNil(t, c, err, "uploads require aes secret to sign download urls")
+
+	os.Setenv(AES_SECRET, "0123456789abcdef0123456789abcdef")
+	c, err = NewDefaultConfig()
+	assert.Nil(t, err, "expected no errors")
+	assert.Equal(t, &UploadsConfig{
+		Store:        "local",
+		Dir:          "uploads",
+		MaxSize:      10 << 20,
+		ChunkSize:    5 << 20,
+		AllowedTypes: []string{"image/png", "image/jpeg", "image/gif", "image/webp", "application/pdf"},
+		URLTTL:       time.Hour,
+	}, c.Uploads, "expected defaults")
+
+	os.Setenv(UPLOADS_MAX_BYTES, "-1")
+	c, err = NewDefaultConfig()
+	assertConfigNil(t, c, err, "uploads max bytes must be a positive number")
+
+	os.Setenv(UPLOADS_MAX_BYTES, "1048576")
+	os.Setenv(UPLOADS_ALLOWED_TYPES, "image/*, pdf")
+	c, err = NewDefaultConfig()
+	assertConfigNil(t, c, err, "uploads allowed types must be media types, e.g. image/png or image/*")
+
+	os.Setenv(UPLOADS_ALLOWED_TYPES, "image/*, text/plain")
+	os.Setenv(UPLOADS_URL_TTL_SEC, "soon")
+	c, err = NewDefaultConfig()
+	assertConfigNil(t, c, err, "uploads url ttl must be a positive number of seconds")
+
+	os.Setenv(UPLOADS_URL_TTL_SEC, "300")
+	os.Setenv(UPLOADS_STORE, UPLOADS_STORE_S3)
+	os.Setenv(S3_ENDPOINT, "http://localhost:9000")
+	c, err = NewDefaultConfig()
+	assertConfigNil(t, c, err, "s3 endpoint must be a host without scheme, e.g. localhost:9000")
+
+	os.Setenv(S3_ENDPOINT, "localhost:9000")
+	c, err = NewDefaultConfig()
+	assertConfigNil(t, c, err, "s3 bucket is required by the s3 uploads store")
+
+	os.Setenv(S3_BUCKET, "uploads")
+	os.Setenv(S3_ACCESS_KEY, "minioadmin")
+	c, err = NewDefaultConfig()
+	assertConfigNil(t, c, err, "s3 access key and secret key are required by the s3 uploads store")
+
+	os.Setenv(S3_SECRET_KEY, "minioadmin")
+	os.Setenv(S3_USE_SSL, "false")
+	c, err = NewDefaultConfig()
+	assert.Nil(t, err, "expected no errors")
+	assert.Equal(t, &UploadsConfig{
+		Store:        "s3",
+		S3:           &S3Config{Endpoint: "localhost:9000", Bucket: "uploads", AccessKey: "minioadmin", SecretKey: "minioadmin"},
+		MaxSize:      1 << 20,
+		ChunkSize:    5 << 20,
+		AllowedTypes: []string{"image/*", "text/plain"},
+		URLTTL:       5 * time.Minute,
+	}, c.Uploads)
+}
+
+func TestImagesConfig(t *testing.T) {
+	for _, key := range AllConfigKeys() {
+		defer os.Unsetenv(key)
+	}
+	os.Setenv(HTTP_PORT, "3000")
+
+	c, err := NewDefaultConfig()
+	assert.Nil(t, err, "expected no errors")
+	assert.Nil(t, c.Images, "expected images disabled")
+
+	os.Setenv(USE_IMAGES, "true")
+	c, err = NewDefaultConfig()
+	assertConfigNil(t, c, err, "images require uploads store")
+
+	os.Setenv(UPLOADS_STORE, UPLOADS_STORE_LOCAL)
+	os.Setenv(AES_SECRET, "0123456789abcdef0123456789abcdef")
+	c, err = NewDefaultConfig()
+	assert.Nil(t, err, "expected no errors")
+	assert.Equal(t, &ImagesConfig{
+		MaxDimension: 4096,
+		MaxPixels:    40_000_000,
+		Quality:      82,
+		SrcSetWidths: []int{320, 640, 960, 1280, 1920},
+	}, c.Images, "expected defaults")
+
+	os.Setenv(IMAGES_MAX_DIMENSION, "0")
+	c, err = NewDefaultConfig()
+	assertConfigNil(t, c, err, "images max dimension must be a positive number of pixels")
+
+	os.Setenv(IMAGES_MAX_DIMENSION, "2000")
+	os.Setenv(IMAGES_MAX_PIXELS, "many")
+	c, err = NewDefaultConfig()
+	assertConfigNil(t, c, err, "images max pixels must be a positive number")
+
+	os.Setenv(IMAGES_MAX_PIXELS, "1000000")
+	os.Setenv(IMAGES_QUALITY, "101")
+	c, err = NewDefaultConfig()
+	assertConfigNil(t, c, err, "images quality must be between 1 and 100")
+
+	os.Setenv(IMAGES_QUALITY, "70")
+	os.Setenv(IMAGES_SRCSET_WIDTHS, "800, 4000")
+	c, err = NewDefaultConfig()
+	assertConfigNil(t, c, err, "images srcset widths must be positive numbers up to the max dimension")
+
+	os.Setenv(IMAGES_SRCSET_WIDTHS, "800, 400")
+	c, err = NewDefaultConfig()
+	assert.Nil(t, err, "expected no errors")
+	assert.Equal(t, &ImagesConfig{MaxDimension: 2000, MaxPixels: 1000000, Quality: 70, SrcSetWidths: []int{400, 800}}, c.Images)
+}
@@ -24,6 +24,12 @@ func GetLocale(ctx context.Context) (language string, currency string) {
 	return
 }
 
+// Helper function to get the request ID from the context
+func GetRequestID(ctx context.Context) string {
+	id, _ := ctx.Value(types.RequestIDCtxKey{}).(string)
+	return id
+}
+
 // Return pointer of the value
 func Ptr[T any](v T) *T {
 	return &v
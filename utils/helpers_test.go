@@ -69,3 +69,11 @@ func TestPtr(t *testing.T) {
 	assert.Equal(t, s, *sptr, "expected the same string values")
 	assert.Equal(t, i, *iptr, "expected the same integer values")
 }
+
+func TestGetRequestID(t *testing.T) {
+	ctx := context.Background()
+	assert.Empty(t, GetRequestID(ctx), "expected request id to be empty")
+
+	ctx = context.WithValue(ctx, types.RequestIDCtxKey{}, "req-1")
+	assert.Equal(t, "req-1", GetRequestID(ctx), "expected the same request ids")
+}
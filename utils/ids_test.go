@@ -0,0 +1,37 @@
+package utils
+
+import (
+	"regexp"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewUUIDv7(t *testing.T) {
+	pattern := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+	first := NewUUIDv7()
+	time.Sleep(2 * time.Millisecond)
+	second := NewUUIDv7()
+
+	assert.Regexp(t, pattern, first, "Expected valid UUIDv7")
+	assert.NotEqual(t, first, second, "Expected unique IDs")
+	assert.Less(t, first, second, "Expected IDs sortable by creation time")
+}
+
+func TestNewULID(t *testing.T) {
+	pattern := regexp.MustCompile(`^[0-7][0-9A-HJKMNP-TV-Z]{25}$`)
+
+	ids := make([]string, 0, 3)
+	for range 3 {
+		ids = append(ids, NewULID())
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	for _, id := range ids {
+		assert.Regexp(t, pattern, id, "Expected valid ULID")
+	}
+	assert.True(t, sort.StringsAreSorted(ids), "Expected IDs sortable by creation time")
+}